@@ -0,0 +1,192 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Relationships of the caller's org to the record named by the first argument of a function
+const (
+	// RelationshipCustodian is the custodian of the patient
+	RelationshipCustodian = "CUSTODIAN"
+	// RelationshipRequester is the requester of the proposal
+	RelationshipRequester = "REQUESTER"
+	// RelationshipRequested is the org a proposal is requested of
+	RelationshipRequested = "REQUESTED"
+)
+
+var accessRelationships = []string{RelationshipCustodian, RelationshipRequester, RelationshipRequested}
+
+// AccessRule restricts the callers of a function. The caller must hold every attribute with
+// its value, belong to one of the MSPs if any are listed, and have one of the relationships,
+// if any are listed, with the record the first argument of the function names.
+type AccessRule struct {
+	Attributes    map[string]string `json:"attributes"`
+	MSPs          []string          `json:"msps"`
+	Relationships []string          `json:"relationships"`
+}
+
+// beforeTransaction checks the access rule of the function called, if any, before it runs
+func beforeTransaction(ctx contractapi.TransactionContextInterface) error {
+	function, args := ctx.GetStub().GetFunctionAndParameters()
+
+	if i := strings.LastIndex(function, ":"); i >= 0 {
+		function = function[i+1:]
+	}
+
+	if function == "" {
+		return nil
+	}
+
+	// Functions are called with their first letter in either case
+	runes := []rune(function)
+	runes[0] = unicode.ToUpper(runes[0])
+
+	return checkAccessRule(ctx, string(runes), args)
+}
+
+// checkAccessRule refuses the callers of a function its access rule doesn't let through.
+// SetAccessRule has none, so admins can't lock themselves out.
+func checkAccessRule(ctx contractapi.TransactionContextInterface, function string, args []string) error {
+	if function == "SetAccessRule" {
+		return nil
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	rule, ok := config.AccessRules[function]
+
+	if !ok {
+		return nil
+	}
+
+	for name, value := range rule.Attributes {
+		if err := ctx.GetClientIdentity().AssertAttributeValue(name, value); err != nil {
+			return newError(CodePermissionDenied, map[string]string{"function": function, "attribute": name}, "%s requires the %s attribute to be %s", function, name, value)
+		}
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if len(rule.MSPs) > 0 && !contains(rule.MSPs, mspID) {
+		return newError(CodePermissionDenied, map[string]string{"function": function, "mspID": mspID}, "%s is restricted to %s", function, strings.Join(rule.MSPs, ", "))
+	}
+
+	if len(rule.Relationships) == 0 {
+		return nil
+	}
+
+	if len(args) > 0 {
+		for _, relationship := range rule.Relationships {
+			if related(ctx, relationship, args[0], mspID) {
+				return nil
+			}
+		}
+	}
+
+	return newError(CodePermissionDenied, map[string]string{"function": function, "mspID": mspID}, "%s requires the caller to be the %s", function, strings.Join(rule.Relationships, " or the "))
+}
+
+// related tells whether an org has a relationship with a record. Missing records relate to no org.
+func related(ctx contractapi.TransactionContextInterface, relationship string, id string, mspID string) bool {
+	switch relationship {
+	case RelationshipCustodian:
+		patient, err := findPatient(ctx, id)
+
+		return err == nil && patient.custodian() == mspID
+	case RelationshipRequester, RelationshipRequested:
+		proposal, err := new(SimpleContract).FindProposal(ctx, id)
+
+		if err != nil {
+			return false
+		}
+
+		if relationship == RelationshipRequester {
+			return proposal.RequesterID == mspID
+		}
+
+		return proposal.RequestedID == mspID
+	}
+
+	return false
+}
+
+// SetAccessRule restricts the callers of a function on top of its own checks, so governance
+// adjusts access without upgrading the chaincode. Attributes are comma separated name=value
+// pairs, MSPs and relationships comma separated lists, relationships among CUSTODIAN,
+// REQUESTER and REQUESTED. A rule without any removes the restriction of the function.
+func (s *SimpleContract) SetAccessRule(ctx contractapi.TransactionContextInterface, function string, attributes string, msps string, relationshipsList string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if _, ok := reflect.TypeOf(s).MethodByName(function); !ok || function == "SetAccessRule" {
+		return newError(CodeInvalidArgument, map[string]string{"function": function}, "%s is not a function of the contract with an access rule", function)
+	}
+
+	rule := AccessRule{Attributes: map[string]string{}, MSPs: append([]string{}, splitList(msps)...), Relationships: append([]string{}, splitList(relationshipsList)...)}
+
+	for _, pair := range splitList(attributes) {
+		name, value, ok := strings.Cut(pair, "=")
+
+		if !ok || name == "" {
+			return newError(CodeInvalidArgument, map[string]string{"attributes": attributes}, "Attributes are name=value pairs")
+		}
+
+		rule.Attributes[name] = value
+	}
+
+	for _, relationship := range rule.Relationships {
+		if !contains(accessRelationships, relationship) {
+			return newError(CodeInvalidArgument, map[string]string{"relationship": relationship}, "Relationships are %s", strings.Join(accessRelationships, ", "))
+		}
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if config.AccessRules == nil {
+		config.AccessRules = map[string]AccessRule{}
+	}
+
+	if len(rule.Attributes) == 0 && len(rule.MSPs) == 0 && len(rule.Relationships) == 0 {
+		delete(config.AccessRules, function)
+	} else {
+		config.AccessRules[function] = rule
+	}
+
+	return putConfig(ctx, config)
+}
+
+// GetAccessRules returns the access rules of the functions that have one
+func (s *SimpleContract) GetAccessRules(ctx contractapi.TransactionContextInterface) (map[string]AccessRule, error) {
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.AccessRules == nil {
+		return map[string]AccessRule{}, nil
+	}
+
+	return config.AccessRules, nil
+}
@@ -0,0 +1,385 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// birthYearOffsetMetric is the measurement holding the encrypted birth year offset of a patient
+const birthYearOffsetMetric = "birthYearOffset"
+
+// ageBuckets are the age ranges patients are counted in, the last one being over 65
+var ageBuckets = []string{"0-17", "18-40", "41-65", "65+"}
+
+// ageBucketIndex is the composite key namespace of the indicators counted for each patient
+const ageBucketIndex = "agebucket~patient"
+
+// ageCounterIndex is the composite key namespace of the counters of each custodian by key.
+// Every transaction writes its own entry, by transaction ID, and reads sum the entries.
+const ageCounterIndex = "agebucket~counter"
+
+// AgeBucketEntry holds the indicators of a patient added to the counters of its key
+type AgeBucketEntry struct {
+	PatientID  string            `json:"patientID"`
+	KeyID      string            `json:"keyID"`
+	Indicators map[string]string `json:"indicators"`
+}
+
+// AgeBucketCounts holds the encrypted number of patients of a custodian in every age bucket,
+// under one key. Patients is the number of patients counted, in the clear. Each counter entry
+// holds what a transaction added, with the modulus of the key to sum them.
+type AgeBucketCounts struct {
+	CustodianMSP string            `json:"custodianMSP"`
+	KeyID        string            `json:"keyID"`
+	Counts       map[string]string `json:"counts"`
+	Patients     int               `json:"patients"`
+	Modulo       string            `json:"modulo,omitempty" metadata:",optional"`
+	Metadata     Metadata          `json:"metadata"`
+}
+
+// ageBucketMetric returns the flag of a patient holding its indicator for a bucket
+func ageBucketMetric(bucket string) string {
+	return "age:" + bucket
+}
+
+// findAgeBucketCounts returns the counters of a custodian under a key summed over their
+// entries, empty ones if none was written, and the keys of the entries
+func findAgeBucketCounts(ctx contractapi.TransactionContextInterface, custodianMSP string, keyID string) (*AgeBucketCounts, []string, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(ageCounterIndex, []string{custodianMSP, keyID})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := []AgeBucketCounts{}
+	keys := []string{}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		entry := AgeBucketCounts{}
+		_ = json.Unmarshal(queryResponse.Value, &entry)
+
+		entries = append(entries, entry)
+		keys = append(keys, queryResponse.Key)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, queryError(err)
+	}
+
+	counts := &AgeBucketCounts{CustodianMSP: custodianMSP, KeyID: keyID, Counts: map[string]string{}}
+
+	// Counters written before the entries were split hold the totals so far, without a modulus
+	if len(entries) == 1 {
+		counts.Counts, counts.Patients, counts.Modulo, counts.Metadata = entries[0].Counts, entries[0].Patients, entries[0].Modulo, entries[0].Metadata
+		return counts, keys, nil
+	}
+
+	for _, entry := range entries {
+		if entry.Modulo != "" {
+			counts.Modulo = entry.Modulo
+		}
+	}
+
+	if len(entries) == 0 {
+		return counts, keys, nil
+	}
+
+	q, err := parseModulus(counts.Modulo)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	totals := map[string]phewrap.Ciphertext{}
+
+	for _, entry := range entries {
+		for bucket, count := range entry.Counts {
+			c, err := parseCiphertext(keyID, count)
+
+			if err != nil {
+				return nil, nil, err
+			}
+
+			total, ok := totals[bucket]
+
+			if !ok {
+				total = phewrap.Zero()
+			}
+
+			totals[bucket] = q.Add(total, c)
+		}
+
+		counts.Patients += entry.Patients
+
+		if counts.Metadata.Created.TxID == "" || earlier(entry.Metadata.Created.Timestamp, counts.Metadata.Created.Timestamp) {
+			counts.Metadata.Created = entry.Metadata.Created
+		}
+
+		if earlier(counts.Metadata.Updated.Timestamp, entry.Metadata.Updated.Timestamp) {
+			counts.Metadata.Updated = entry.Metadata.Updated
+		}
+	}
+
+	for bucket, total := range totals {
+		counts.Counts[bucket] = total.String()
+	}
+
+	return counts, keys, nil
+}
+
+// earlier tells whether a timestamp is before another, an empty one being before any
+func earlier(a string, b string) bool {
+	first, err := timeutil.Parse(a)
+
+	if err != nil {
+		return true
+	}
+
+	second, err := timeutil.Parse(b)
+
+	return err == nil && first.Before(second)
+}
+
+// updateAgeBuckets adds indicators to the counters of a custodian under a key and takes others
+// away, either being nil. Both are applied in one entry of the transaction, which reads none of
+// the counters, so transactions setting age buckets concurrently don't conflict on them.
+func updateAgeBuckets(ctx contractapi.TransactionContextInterface, custodianMSP string, keyID string, added map[string]string, removed map[string]string, q phewrap.Modulus) error {
+	key, err := ctx.GetStub().CreateCompositeKey(ageCounterIndex, []string{custodianMSP, keyID, ctx.GetStub().GetTxID()})
+
+	if err != nil {
+		return err
+	}
+
+	counts := &AgeBucketCounts{CustodianMSP: custodianMSP, KeyID: keyID, Counts: map[string]string{}, Modulo: q.String()}
+
+	for _, bucket := range ageBuckets {
+		total := phewrap.Zero()
+
+		if added != nil {
+			indicator, err := parseCiphertext(bucket, added[bucket])
+
+			if err != nil {
+				return err
+			}
+
+			total = q.Add(total, indicator)
+		}
+
+		if removed != nil {
+			indicator, err := parseCiphertext(bucket, removed[bucket])
+
+			if err != nil {
+				return err
+			}
+
+			total = q.Add(total, q.Negate(indicator))
+		}
+
+		counts.Counts[bucket] = total.String()
+	}
+
+	if added != nil {
+		counts.Patients++
+	}
+
+	if removed != nil {
+		counts.Patients--
+	}
+
+	counts.Metadata, err = newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	countsAsBytes, _ := json.Marshal(counts)
+
+	return ctx.GetStub().PutState(key, countsAsBytes)
+}
+
+// SetPatientAgeBucket stores the encrypted birth year offset of a patient with its indicators,
+// comma separated ciphertexts of 1 for its age bucket and 0 for the others in the order
+// 0-17, 18-40, 41-65 and 65+. The indicators are added to the counters of the custodian
+// under the key of the patient, replacing those set before. Nothing checks their values.
+func (s *SimpleContract) SetPatientAgeBucket(ctx contractapi.TransactionContextInterface, id string, birthYearOffset string, indicators string, modulo string) error {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != patient.custodian() {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can set the age bucket of %s", patient.custodian(), id)
+	}
+
+	if err := checkNotFrozen(id, patient); err != nil {
+		return err
+	}
+
+	proposalID, err := patientLock(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposalID != "" {
+		return newError(CodeInvalidState, map[string]string{"id": id, "proposalID": proposalID}, "%s is locked by %s until it is executed", id, proposalID)
+	}
+
+	values := strings.Split(indicators, ",")
+
+	if len(values) != len(ageBuckets) {
+		return newError(CodeInvalidArgument, map[string]string{"indicators": fmt.Sprint(len(values))}, "One indicator is needed for each of the %d age buckets", len(ageBuckets))
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := parseCiphertext(birthYearOffsetMetric, birthYearOffset); err != nil {
+		return err
+	}
+
+	for i, value := range values {
+		indicator, err := parseCiphertext(ageBuckets[i], value)
+
+		if err != nil {
+			return err
+		}
+
+		if err := q.Validate(indicator); err != nil {
+			return newError(CodeInvalidArgument, map[string]string{"bucket": ageBuckets[i]}, "The indicator of %s is invalid. %s", ageBuckets[i], err.Error())
+		}
+	}
+
+	entryKey, err := ctx.GetStub().CreateCompositeKey(ageBucketIndex, []string{id})
+
+	if err != nil {
+		return err
+	}
+
+	entryAsBytes, err := ctx.GetStub().GetState(entryKey)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	previous := new(AgeBucketEntry)
+
+	if entryAsBytes != nil {
+		_ = json.Unmarshal(entryAsBytes, previous)
+	}
+
+	entry := AgeBucketEntry{PatientID: id, KeyID: patient.KeyID, Indicators: map[string]string{}}
+
+	if patient.Measurements == nil {
+		patient.Measurements = map[string]string{}
+	}
+
+	patient.Measurements[birthYearOffsetMetric] = birthYearOffset
+
+	for i, bucket := range ageBuckets {
+		entry.Indicators[bucket] = values[i]
+		patient.Measurements[ageBucketMetric(bucket)] = values[i]
+	}
+
+	// The indicators set before are taken away from the counters of the key they were added under
+	if entryAsBytes != nil && previous.KeyID != patient.KeyID {
+		if err := updateAgeBuckets(ctx, mspID, previous.KeyID, nil, previous.Indicators, q); err != nil {
+			return err
+		}
+
+		previous.Indicators = nil
+	}
+
+	if err := updateAgeBuckets(ctx, mspID, patient.KeyID, entry.Indicators, previous.Indicators, q); err != nil {
+		return err
+	}
+
+	entryAsBytes, _ = json.Marshal(entry)
+
+	if err := ctx.GetStub().PutState(entryKey, entryAsBytes); err != nil {
+		return err
+	}
+
+	if err := patient.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	return putPatient(ctx, id, patient)
+}
+
+// GetAgeBucketCounts returns the encrypted counters of the age buckets of a custodian under a key
+func (s *SimpleContract) GetAgeBucketCounts(ctx contractapi.TransactionContextInterface, custodianMSP string, keyID string) (*AgeBucketCounts, error) {
+	counts, _, err := findAgeBucketCounts(ctx, custodianMSP, keyID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if counts.Metadata.Created.TxID == "" {
+		return nil, newError(CodeNotFound, map[string]string{"custodianMSP": custodianMSP, "keyID": keyID}, "%s has no age buckets under %s", custodianMSP, keyID)
+	}
+
+	return counts, nil
+}
+
+// CompactAgeBucketCounts folds the counter entries of the custodian under a key into one, so
+// reads sum fewer of them. Age buckets set concurrently invalidate it rather than conflict with
+// one another, and it is run again.
+func (s *SimpleContract) CompactAgeBucketCounts(ctx contractapi.TransactionContextInterface, keyID string) (int, error) {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	counts, keys, err := findAgeBucketCounts(ctx, mspID, keyID)
+
+	if err != nil || len(keys) < 2 {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return 0, err
+		}
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(ageCounterIndex, []string{mspID, keyID, ctx.GetStub().GetTxID()})
+
+	if err != nil {
+		return 0, err
+	}
+
+	countsAsBytes, _ := json.Marshal(counts)
+
+	return len(keys), ctx.GetStub().PutState(key, countsAsBytes)
+}
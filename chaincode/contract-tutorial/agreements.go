@@ -0,0 +1,434 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// agreementIndex is the composite key namespace of the data sharing agreements of each custodian
+const agreementIndex = "agreement~requester"
+
+// quotaUsageIndex is the composite key namespace of the executions counted against the quotas
+const quotaUsageIndex = "quota~usage"
+
+// OperationTerms are the parameters of an operation in an agreement. MaxCohort caps the
+// cohort of its proposals, 0 leaving it uncapped, and NoiseRequired holds their execution
+// until the custodian adds noise to their value.
+type OperationTerms struct {
+	MaxCohort     int  `json:"maxCohort"`
+	NoiseRequired bool `json:"noiseRequired"`
+}
+
+// DataSharingAgreement holds the terms a custodian sets for the proposals of a requester.
+// DailyQuota and WeeklyQuota cap the proposals of the requester executed over the patients
+// of the custodian per UTC day and ISO week, 0 leaving them uncapped. AllowedOperations
+// lists the operations the requester may propose, any if empty. ResponseSLA is the seconds
+// the custodian has to answer a pending proposal, and Breaches counts the proposals it left
+// unanswered past them.
+type DataSharingAgreement struct {
+	CustodianMSP      string                    `json:"custodianMSP"`
+	RequesterMSP      string                    `json:"requesterMSP"`
+	DailyQuota        int                       `json:"dailyQuota,omitempty" metadata:",optional"`
+	WeeklyQuota       int                       `json:"weeklyQuota,omitempty" metadata:",optional"`
+	AllowedOperations []string                  `json:"allowedOperations,omitempty" metadata:",optional"`
+	OperationTerms    map[string]OperationTerms `json:"operationTerms,omitempty" metadata:",optional"`
+	ResponseSLA       int64                     `json:"responseSLA,omitempty" metadata:",optional"`
+	Breaches          int                       `json:"breaches,omitempty" metadata:",optional"`
+	Metadata          Metadata                  `json:"metadata"`
+}
+
+// QuotaUsage is the number of executions of the proposals of a requester counted against a
+// quota of a custodian in the current period
+type QuotaUsage struct {
+	Period string `json:"period"`
+	Quota  int    `json:"quota"`
+	Used   int    `json:"used"`
+}
+
+// agreementKey returns the key of the agreement of a custodian with a requester
+func agreementKey(ctx contractapi.TransactionContextInterface, custodianMSP string, requesterMSP string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(agreementIndex, []string{custodianMSP, requesterMSP})
+}
+
+// findAgreement returns the agreement of a custodian with a requester, nil if there is none
+func findAgreement(ctx contractapi.TransactionContextInterface, custodianMSP string, requesterMSP string) (*DataSharingAgreement, error) {
+	key, err := agreementKey(ctx, custodianMSP, requesterMSP)
+
+	if err != nil {
+		return nil, err
+	}
+
+	agreementAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if agreementAsBytes == nil {
+		return nil, nil
+	}
+
+	agreement := new(DataSharingAgreement)
+	_ = json.Unmarshal(agreementAsBytes, agreement)
+
+	return agreement, nil
+}
+
+// callerAgreement returns the agreement of the caller's org with a requester to be changed,
+// a new one if there is none. Only admins set the agreements of their org.
+func callerAgreement(ctx contractapi.TransactionContextInterface, requesterMSP string) (*DataSharingAgreement, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if requesterMSP == "" {
+		return nil, newError(CodeInvalidArgument, map[string]string{"requesterMSP": requesterMSP}, "An agreement needs a requester")
+	}
+
+	custodianMSP, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	agreement, err := findAgreement(ctx, custodianMSP, requesterMSP)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if agreement == nil {
+		metadata, err := newMetadata(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &DataSharingAgreement{CustodianMSP: custodianMSP, RequesterMSP: requesterMSP, Metadata: metadata}, nil
+	}
+
+	if err := agreement.Metadata.touch(ctx); err != nil {
+		return nil, err
+	}
+
+	return agreement, nil
+}
+
+// putAgreement writes an agreement
+func putAgreement(ctx contractapi.TransactionContextInterface, agreement *DataSharingAgreement) error {
+	key, err := agreementKey(ctx, agreement.CustodianMSP, agreement.RequesterMSP)
+
+	if err != nil {
+		return err
+	}
+
+	agreementAsBytes, _ := json.Marshal(agreement)
+
+	return ctx.GetStub().PutState(key, agreementAsBytes)
+}
+
+// SetDataSharingAgreement sets the quotas of the caller's org for the proposals of a requester.
+// Only admins set the agreements of their org.
+func (s *SimpleContract) SetDataSharingAgreement(ctx contractapi.TransactionContextInterface, requesterMSP string, dailyQuota int, weeklyQuota int) error {
+	if dailyQuota < 0 || weeklyQuota < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"dailyQuota": fmt.Sprint(dailyQuota), "weeklyQuota": fmt.Sprint(weeklyQuota)}, "Quotas can't be negative")
+	}
+
+	agreement, err := callerAgreement(ctx, requesterMSP)
+
+	if err != nil {
+		return err
+	}
+
+	agreement.DailyQuota = dailyQuota
+	agreement.WeeklyQuota = weeklyQuota
+
+	return putAgreement(ctx, agreement)
+}
+
+// SetAgreementOperations sets the comma separated operations a requester may propose over the
+// patients of the caller's org, an empty list allowing any. The terms of the operations no
+// longer allowed are dropped.
+func (s *SimpleContract) SetAgreementOperations(ctx contractapi.TransactionContextInterface, requesterMSP string, operations string) error {
+	operationsList := splitList(operations)
+
+	for _, operation := range operationsList {
+		if !contains(supportedOperations, operation) {
+			return newError(CodeInvalidArgument, map[string]string{"operation": operation}, "Operation %s is not supported", operation)
+		}
+	}
+
+	agreement, err := callerAgreement(ctx, requesterMSP)
+
+	if err != nil {
+		return err
+	}
+
+	agreement.AllowedOperations = operationsList
+
+	for operation := range agreement.OperationTerms {
+		if len(operationsList) > 0 && !contains(operationsList, operation) {
+			delete(agreement.OperationTerms, operation)
+		}
+	}
+
+	return putAgreement(ctx, agreement)
+}
+
+// SetAgreementOperationTerms sets the largest cohort of the proposals of a requester for an
+// operation, 0 leaving it uncapped, and whether their value needs noise from the custodian
+// before they are executed. Terms of 0 without noise remove them.
+func (s *SimpleContract) SetAgreementOperationTerms(ctx contractapi.TransactionContextInterface, requesterMSP string, operation string, maxCohort int, noiseRequired bool) error {
+	if !contains(supportedOperations, operation) {
+		return newError(CodeInvalidArgument, map[string]string{"operation": operation}, "Operation %s is not supported", operation)
+	}
+
+	if maxCohort < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"maxCohort": fmt.Sprint(maxCohort)}, "The max cohort can't be negative")
+	}
+
+	// Noise is added to one value, multi-valued operations would share it
+	if noiseRequired && (&Proposal{Operation: operation}).multiValued() {
+		return newError(CodeInvalidArgument, map[string]string{"operation": operation}, "Noise is added to single values, %s computes several", operation)
+	}
+
+	agreement, err := callerAgreement(ctx, requesterMSP)
+
+	if err != nil {
+		return err
+	}
+
+	if len(agreement.AllowedOperations) > 0 && !contains(agreement.AllowedOperations, operation) {
+		return newError(CodeInvalidArgument, map[string]string{"operation": operation}, "%s is not allowed to %s", operation, requesterMSP)
+	}
+
+	if agreement.OperationTerms == nil {
+		agreement.OperationTerms = map[string]OperationTerms{}
+	}
+
+	if maxCohort == 0 && !noiseRequired {
+		delete(agreement.OperationTerms, operation)
+	} else {
+		agreement.OperationTerms[operation] = OperationTerms{MaxCohort: maxCohort, NoiseRequired: noiseRequired}
+	}
+
+	return putAgreement(ctx, agreement)
+}
+
+// checkAgreementTerms refuses a proposal the agreement of its requested org with its requester
+// doesn't allow, and tells whether its value needs noise. Proposals without an agreement are
+// not restricted.
+func checkAgreementTerms(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal) (bool, error) {
+	agreement, err := findAgreement(ctx, proposal.RequestedID, proposal.RequesterID)
+
+	if err != nil || agreement == nil {
+		return false, err
+	}
+
+	if len(agreement.AllowedOperations) > 0 && !contains(agreement.AllowedOperations, proposal.Operation) {
+		return false, newError(CodePermissionDenied, map[string]string{"id": id, "operation": proposal.Operation}, "%s doesn't allow %s to propose %s", agreement.CustodianMSP, agreement.RequesterMSP, proposal.Operation)
+	}
+
+	terms, ok := agreement.OperationTerms[proposal.Operation]
+
+	if !ok {
+		return false, nil
+	}
+
+	if cohort := len(splitList(proposal.PatientsIDs)); terms.MaxCohort > 0 && cohort > terms.MaxCohort {
+		return false, newError(CodePermissionDenied, map[string]string{"id": id, "maxCohort": fmt.Sprint(terms.MaxCohort)}, "%s allows %s cohorts of at most %d patients for %s", agreement.CustodianMSP, agreement.RequesterMSP, terms.MaxCohort, proposal.Operation)
+	}
+
+	if terms.NoiseRequired && proposal.multiValued() {
+		return false, newError(CodePermissionDenied, map[string]string{"id": id, "operation": proposal.Operation}, "%s requires noise for %s, which is added to single values", agreement.CustodianMSP, proposal.Operation)
+	}
+
+	return terms.NoiseRequired, nil
+}
+
+// FindDataSharingAgreement returns the agreement of a custodian with a requester
+func (s *SimpleContract) FindDataSharingAgreement(ctx contractapi.TransactionContextInterface, custodianMSP string, requesterMSP string) (*DataSharingAgreement, error) {
+	agreement, err := findAgreement(ctx, custodianMSP, requesterMSP)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if agreement == nil {
+		return nil, newError(CodeNotFound, map[string]string{"custodianMSP": custodianMSP, "requesterMSP": requesterMSP}, "%s has no agreement with %s", custodianMSP, requesterMSP)
+	}
+
+	return agreement, nil
+}
+
+// quotaPeriods returns the day and the ISO week of a time, the periods of the daily and weekly quotas
+func quotaPeriods(t time.Time) (string, string) {
+	year, week := t.UTC().ISOWeek()
+
+	return "D" + timeutil.Day(t), fmt.Sprintf("W%d-%02d", year, week)
+}
+
+// quotaUsed returns the executions counted in a period of the agreement of a custodian with a requester
+func quotaUsed(ctx contractapi.TransactionContextInterface, custodianMSP string, requesterMSP string, period string) (int, error) {
+	used, _, err := sumCountEntries(ctx, quotaUsageIndex, custodianMSP, requesterMSP, period)
+
+	return used, err
+}
+
+// quotaUsages returns the daily then weekly usage of the agreement at the time of the transaction,
+// leaving out the quotas set to 0
+func quotaUsages(ctx contractapi.TransactionContextInterface, agreement *DataSharingAgreement) ([]QuotaUsage, error) {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	day, week := quotaPeriods(now)
+	usages := []QuotaUsage{}
+
+	for _, usage := range []QuotaUsage{{Period: day, Quota: agreement.DailyQuota}, {Period: week, Quota: agreement.WeeklyQuota}} {
+		if usage.Quota == 0 {
+			continue
+		}
+
+		used, err := quotaUsed(ctx, agreement.CustodianMSP, agreement.RequesterMSP, usage.Period)
+
+		if err != nil {
+			return nil, err
+		}
+
+		usage.Used = used
+		usages = append(usages, usage)
+	}
+
+	return usages, nil
+}
+
+// checkQuota refuses a proposal whose execution would exceed a quota of the agreement of its
+// requested org with its requester, and counts the execution if consume is set, in an entry
+// of the transaction's own. Proposals without an agreement are not capped. Executions under a
+// quota still conflict with one another as they read its count.
+func checkQuota(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal, consume bool) error {
+	agreement, err := findAgreement(ctx, proposal.RequestedID, proposal.RequesterID)
+
+	if err != nil || agreement == nil {
+		return err
+	}
+
+	usages, err := quotaUsages(ctx, agreement)
+
+	if err != nil {
+		return err
+	}
+
+	for _, usage := range usages {
+		if usage.Used >= usage.Quota {
+			return newError(CodeQuotaExceeded, map[string]string{"id": id, "period": usage.Period, "quota": fmt.Sprint(usage.Quota)}, "%s used its quota of %d executions by %s in %s", agreement.RequesterMSP, usage.Quota, agreement.CustodianMSP, usage.Period)
+		}
+	}
+
+	if !consume {
+		return nil
+	}
+
+	for _, usage := range usages {
+		if err := putCountEntry(ctx, quotaUsageIndex, 1, agreement.CustodianMSP, agreement.RequesterMSP, usage.Period); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetQuotaUsage returns the current daily then weekly usage of the quotas of a custodian's
+// agreement with a requester
+func (s *SimpleContract) GetQuotaUsage(ctx contractapi.TransactionContextInterface, custodianMSP string, requesterMSP string) ([]QuotaUsage, error) {
+	agreement, err := s.FindDataSharingAgreement(ctx, custodianMSP, requesterMSP)
+
+	if err != nil {
+		return nil, err
+	}
+
+	usages, err := quotaUsages(ctx, agreement)
+
+	return usages, err
+}
+
+// SetProposalNoise sets the encrypted noise the requested org of a proposal adds to its value,
+// under the key of the proposal, which the agreement with its requester may require before it
+// is executed. The requester only gets the noisy value.
+func (s *SimpleContract) SetProposalNoise(ctx contractapi.TransactionContextInterface, id string, noise string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status == ProposalExecuted {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s has already been executed", id)
+	}
+
+	if proposal.multiValued() {
+		return newError(CodeInvalidArgument, map[string]string{"id": id}, "%s has several values, noise is added to single values", id)
+	}
+
+	if err := requireApprover(ctx, proposal.RequestedID, id); err != nil {
+		return err
+	}
+
+	if _, err := parseCiphertext("noise", noise); err != nil {
+		return err
+	}
+
+	proposal.Noise = noise
+
+	if err := proposal.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// addNoise adds the noise of the requested org to the computed value of a proposal
+func addNoise(proposal *Proposal, modulo string) error {
+	if proposal.Noise == "" {
+		return nil
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return err
+	}
+
+	noise, err := parseCiphertext("noise", proposal.Noise)
+
+	if err != nil {
+		return err
+	}
+
+	if err := q.Validate(noise); err != nil {
+		return newError(CodeInvalidArgument, map[string]string{"modulo": modulo}, "The noise is invalid. %s", err.Error())
+	}
+
+	value, err := parseCiphertext("value", proposal.Value)
+
+	if err != nil {
+		return err
+	}
+
+	proposal.Value = q.Add(value, noise).String()
+
+	return nil
+}
@@ -0,0 +1,203 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// proposalVersionIndex is the composite key namespace of superseded proposal versions
+const proposalVersionIndex = "proposal~version"
+
+// versionKey returns the composite key of a proposal version
+func versionKey(ctx contractapi.TransactionContextInterface, id string, version int) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(proposalVersionIndex, []string{id, fmt.Sprintf("%06d", version)})
+}
+
+// saveVersion keeps the current version of the proposal and moves it to the next version
+func saveVersion(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal) error {
+	key, err := versionKey(ctx, id, proposal.Version)
+
+	if err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	if err := ctx.GetStub().PutState(key, proposalAsBytes); err != nil {
+		return err
+	}
+
+	proposal.Version++
+
+	return nil
+}
+
+// AmendProposal changes the cohort, operation and expiry of a proposal not yet executed, nor
+// declined for good. The current version is kept and the amended proposal must be approved again.
+func (s *SimpleContract) AmendProposal(ctx contractapi.TransactionContextInterface, id string, patientsIDs string, operation string, expiry string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status == ProposalExecuted {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s has already been executed", id)
+	}
+
+	if proposal.Status == ProposalRejected && proposal.Reason.Code == ReasonDeclined {
+		return newError(CodeInvalidState, map[string]string{"id": id, "code": ReasonDeclined}, "%s was declined for good", id)
+	}
+
+	if len(proposal.ResultIDs) > 0 {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s combines results, its cohort can't be changed", id)
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can amend %s", proposal.RequesterID, id)
+	}
+
+	if _, err := s.validateProposalTerms(ctx, proposal.ProtocolID, patientsIDs, operation, proposal.Metrics, expiry); err != nil {
+		return err
+	}
+
+	amended := *proposal
+	amended.PatientsIDs, amended.Operation = patientsIDs, operation
+	noiseRequired, err := checkAgreementTerms(ctx, id, &amended)
+
+	if err != nil {
+		return err
+	}
+
+	// Release the approved cohort, it is locked again on re-approval
+	if proposal.Status == ProposalApproved {
+		if err := unlockCohort(ctx, id, proposal); err != nil {
+			return err
+		}
+	}
+
+	// Amending answers an open counter-proposal with the amended terms
+	if proposal.Status == ProposalCountered {
+		details, err := newTransactionDetails(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		counter := &proposal.Negotiation[len(proposal.Negotiation)-1]
+		counter.Status = CounterRejected
+		counter.Answered = &details
+	}
+
+	if err := saveVersion(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	proposal.PatientsIDs = patientsIDs
+	proposal.Operation = operation
+	proposal.Expiry = expiry
+	proposal.Status = ProposalPending
+	proposal.Approvals = nil
+	proposal.Reason = nil
+	proposal.NoiseRequired = noiseRequired
+	proposal.Noise = ""
+
+	if err := awaitResponse(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	if err := notify(ctx, proposal.RequestedID, NotificationProposalAwaitingApproval, id, fmt.Sprintf("%s amended %s, it requests your approval again", proposal.RequesterID, id)); err != nil {
+		return err
+	}
+
+	if err := proposal.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// FindProposalVersion returns a version of a proposal, including the current one
+func (s *SimpleContract) FindProposalVersion(ctx contractapi.TransactionContextInterface, id string, version int) (*Proposal, error) {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if proposal.Version == version {
+		return proposal, nil
+	}
+
+	key, err := versionKey(ctx, id, version)
+
+	if err != nil {
+		return nil, err
+	}
+
+	proposalAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if proposalAsBytes == nil {
+		return nil, newError(CodeNotFound, map[string]string{"id": id, "version": fmt.Sprint(version)}, "Version %d of %s does not exist", version, id)
+	}
+
+	proposal = new(Proposal)
+	_ = json.Unmarshal(proposalAsBytes, proposal)
+
+	return proposal, nil
+}
+
+// ProposalPage is a page of the versions of a proposal
+type ProposalPage struct {
+	Records   []Proposal `json:"records"`
+	Bookmark  string     `json:"bookmark"`
+	Count     int32      `json:"count"`
+	Truncated bool       `json:"truncated"`
+}
+
+// GetProposalVersions returns a page of the versions of a proposal, oldest first. The current
+// version ends the last page.
+func (s *SimpleContract) GetProposalVersions(ctx contractapi.TransactionContextInterface, id string, pageSize int32, bookmark string) (*ProposalPage, error) {
+	current, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectTypedPage[Proposal](queryContext(), indexPages(ctx, proposalVersionIndex, []string{id}), size, bookmark)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if results.Bookmark == "" {
+		results.Records = append(results.Records, *current)
+	}
+
+	return &ProposalPage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
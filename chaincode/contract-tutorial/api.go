@@ -0,0 +1,150 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Versions of the API of the contract, each served by a contract of its own name
+const (
+	// APIVersion1 is the original API, kept as shims over the current one
+	APIVersion1 = "v1"
+	// APIVersion2 is the current API, with composite key indexes, validation and error envelopes
+	APIVersion2 = "v2"
+)
+
+var apiVersions = []string{APIVersion1, APIVersion2}
+
+var deprecatedAPIVersions = []string{APIVersion1}
+
+// defaultAPIVersion is the version called without a prefix. It stays v1 while clients of the
+// original API move to v2, which they call as "v2:<function>".
+const defaultAPIVersion = APIVersion1
+
+// LegacyContract serves the functions of the v1 API, called as "v1:<function>" or, as the
+// default version, without a prefix. Each one runs the v2 function of the same name and
+// returns plain text errors as v1 did. Functions whose v1 behaviour can't be kept fail with a
+// plain text message naming their replacement.
+type LegacyContract struct {
+	contractapi.Contract
+	current SimpleContract
+}
+
+// newLegacyContract returns the v1 contract
+func newLegacyContract() *LegacyContract {
+	legacyContract := new(LegacyContract)
+	legacyContract.Name = APIVersion1
+	legacyContract.Info.Version = ContractVersion
+	legacyContract.Info.Description = "The original API, kept as shims over v2 returning plain text errors"
+	legacyContract.TransactionContextHandler = new(CachingContext)
+	legacyContract.BeforeTransaction = beforeTransaction
+
+	return legacyContract
+}
+
+// legacyError turns an error envelope into the plain text message of v1
+func legacyError(err error) error {
+	var contractError *ContractError
+
+	if errors.As(err, &contractError) {
+		return errors.New(contractError.Message)
+	}
+
+	return err
+}
+
+// Ping ...
+func (l *LegacyContract) Ping(ctx contractapi.TransactionContextInterface) (string, error) {
+	return l.current.Ping(ctx)
+}
+
+// GetContractInfo tells clients calling without a version which versions are served
+func (l *LegacyContract) GetContractInfo(ctx contractapi.TransactionContextInterface) (*ContractInfo, error) {
+	info, err := l.current.GetContractInfo(ctx)
+
+	return info, legacyError(err)
+}
+
+// CreatePatient ...
+func (l *LegacyContract) CreatePatient(ctx contractapi.TransactionContextInterface, id string, name string, preExistingConditions string, diagnosisID string, statusID string, keyID string) error {
+	return legacyError(l.current.CreatePatient(ctx, id, name, preExistingConditions, diagnosisID, statusID, keyID))
+}
+
+// FindPatient ...
+func (l *LegacyContract) FindPatient(ctx contractapi.TransactionContextInterface, id string) (*Patient, error) {
+	patient, err := l.current.FindPatient(ctx, id)
+
+	return patient, legacyError(err)
+}
+
+// AllPatients returned the whole range in v1, it reads the pages up to the result limit
+func (l *LegacyContract) AllPatients(ctx contractapi.TransactionContextInterface, firstID string, lastID string) ([]QueryResult, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, legacyError(err)
+	}
+
+	results := []QueryResult{}
+	bookmark := ""
+	read := int32(0)
+
+	for {
+		page, err := l.current.AllPatients(ctx, firstID, lastID, 0, bookmark)
+
+		if err != nil {
+			return nil, legacyError(err)
+		}
+
+		results = append(results, page.Records...)
+		read += page.Count
+
+		if !page.Truncated {
+			return results, nil
+		}
+
+		if int(read) >= limit {
+			return nil, legacyError(queryError(fmt.Errorf("%w, the limit is %d", ledgeriter.ErrLimitExceeded, limit)))
+		}
+
+		bookmark = page.Bookmark
+	}
+}
+
+// UpdatePatient ...
+func (l *LegacyContract) UpdatePatient(ctx contractapi.TransactionContextInterface, id string, name string, preExistingConditions string, diagnosisID string, statusID string, keyID string) error {
+	return legacyError(l.current.UpdatePatient(ctx, id, name, preExistingConditions, diagnosisID, statusID, keyID))
+}
+
+// CreateProposal computed a mean at once in v1. Proposals are now made under a study
+// protocol and approved by the custodian before they are executed, so it is refused with
+// the steps replacing it.
+func (l *LegacyContract) CreateProposal(ctx contractapi.TransactionContextInterface, id string, requesterID string, requestedID string, patientsIDs string, keyID string, modulo string) error {
+	return legacyError(newError(CodeUnimplemented, map[string]string{"id": id, "replacement": APIVersion2 + ":CreateProposal"}, "CreateProposal of %s is no longer supported, use CreateProposal of %s then ExecuteProposal once approved", APIVersion1, APIVersion2))
+}
+
+// FindProposal ...
+func (l *LegacyContract) FindProposal(ctx contractapi.TransactionContextInterface, id string) (*Proposal, error) {
+	proposal, err := l.current.FindProposal(ctx, id)
+
+	return proposal, legacyError(err)
+}
+
+// CreateResult ...
+func (l *LegacyContract) CreateResult(ctx contractapi.TransactionContextInterface, proposalID string, firstToken string, secondToken string, keyID string, modulo string) error {
+	return legacyError(l.current.CreateResult(ctx, proposalID, firstToken, secondToken, keyID, modulo))
+}
+
+// FindResult ...
+func (l *LegacyContract) FindResult(ctx contractapi.TransactionContextInterface, id string) (*Result, error) {
+	result, err := l.current.FindResult(ctx, id)
+
+	return result, legacyError(err)
+}
@@ -0,0 +1,160 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	tutorial "github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// Events of the contract the bridge writes to the store
+const (
+	eventResultReleased = "ResultReleased"
+	eventPatientUpdated = "PatientUpdated"
+)
+
+// reconnectDelay is the wait before listening again when the peer closes the event stream
+const reconnectDelay = 5 * time.Second
+
+// resultReleasedEvent is the payload of the ResultReleased event
+type resultReleasedEvent struct {
+	ResultID   string `json:"resultID"`
+	ProposalID string `json:"proposalID"`
+}
+
+// patientUpdatedEvent is the payload of the PatientUpdated event. The changes name the
+// fields of the patient with the hashes of their values, never the values.
+type patientUpdatedEvent struct {
+	PatientID string          `json:"patientID"`
+	Changes   json.RawMessage `json:"changes"`
+}
+
+// resultReader reads the released results the bridge writes
+type resultReader interface {
+	FindResult(ctx context.Context, id string) (*tutorial.Result, error)
+}
+
+// Bridge writes the chaincode events of the contract to an analytical store
+type Bridge struct {
+	network   *client.Network
+	contract  resultReader
+	chaincode string
+	store     *Store
+}
+
+// Run writes the events to the store until the context is done or an event can't be
+// written. It resumes after the checkpoint of the store, or at startBlock on the first run,
+// and listens again when the peer closes the stream. Events are delivered at least once
+// and written at most once, so each is written exactly once.
+func (b *Bridge) Run(ctx context.Context, startBlock uint64) error {
+	for {
+		checkpoint, err := b.store.Checkpoint(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		option := client.WithStartBlock(startBlock)
+
+		if checkpoint != nil {
+			option = client.WithCheckpoint(checkpoint)
+		}
+
+		if err := b.listen(ctx, option); err != nil {
+			return err
+		}
+
+		log.Printf("Event stream closed, listening again in %s", reconnectDelay)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// listen writes the events of a stream until it closes
+func (b *Bridge) listen(ctx context.Context, option client.ChaincodeEventsOption) error {
+	listenCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := b.network.ChaincodeEvents(listenCtx, b.chaincode, option)
+
+	if err != nil {
+		return err
+	}
+
+	for event := range events {
+		if err := b.write(ctx, event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write writes an event to the store and moves the checkpoint past it. Other events,
+// malformed payloads and results the contract refuses to return, as embargoed ones, only
+// move the checkpoint.
+func (b *Bridge) write(ctx context.Context, event *client.ChaincodeEvent) error {
+	checkpoint := Checkpoint{Block: event.BlockNumber, Transaction: event.TransactionID}
+
+	switch event.EventName {
+	case eventResultReleased:
+		released := new(resultReleasedEvent)
+
+		if err := json.Unmarshal(event.Payload, released); err != nil {
+			log.Printf("Skipping the malformed %s event of %s. %s", event.EventName, event.TransactionID, err.Error())
+			break
+		}
+
+		// The result is read before the database transaction starts, as it may take a while
+		result, err := b.contract.FindResult(ctx, released.ResultID)
+
+		var contractError *tutorial.ContractError
+
+		if errors.As(err, &contractError) {
+			log.Printf("Skipping the %s event of %s, %s can't be read. %s", event.EventName, event.TransactionID, released.ResultID, err.Error())
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return b.store.Write(ctx, checkpoint, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO released_results (result_id, proposal_id, key_id, value, operation, cohort_size, block_number, transaction_id)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (result_id) DO NOTHING`,
+				released.ResultID, released.ProposalID, result.KeyID, result.Value, result.Manifest.Operation, result.Manifest.CohortSize, event.BlockNumber, event.TransactionID)
+
+			return err
+		})
+	case eventPatientUpdated:
+		updated := new(patientUpdatedEvent)
+
+		if err := json.Unmarshal(event.Payload, updated); err != nil {
+			log.Printf("Skipping the malformed %s event of %s. %s", event.EventName, event.TransactionID, err.Error())
+			break
+		}
+
+		return b.store.Write(ctx, checkpoint, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO patient_updates (transaction_id, patient_id, changes, block_number)
+				VALUES ($1, $2, $3, $4) ON CONFLICT (transaction_id) DO NOTHING`,
+				event.TransactionID, updated.PatientID, string(updated.Changes), event.BlockNumber)
+
+			return err
+		})
+	}
+
+	return b.store.Write(ctx, checkpoint, nil)
+}
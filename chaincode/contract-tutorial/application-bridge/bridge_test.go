@@ -0,0 +1,114 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	tutorial "github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+)
+
+// fakeResults returns the results it holds, refuses the others as the contract would and
+// fails with unreachable while set
+type fakeResults struct {
+	results     map[string]*tutorial.Result
+	unreachable error
+}
+
+func (f *fakeResults) FindResult(ctx context.Context, id string) (*tutorial.Result, error) {
+	if f.unreachable != nil {
+		return nil, f.unreachable
+	}
+
+	if result, ok := f.results[id]; ok {
+		return result, nil
+	}
+
+	return nil, &tutorial.ContractError{Code: "INVALID_STATE", Message: id + " is embargoed"}
+}
+
+func TestWrite(t *testing.T) {
+	ctx := context.Background()
+	results := &fakeResults{results: map[string]*tutorial.Result{
+		"RESULT0": {ProposalID: "PROPOSAL0", KeyID: "KEY1", Value: "c", Manifest: tutorial.Manifest{Operation: "MEAN", CohortSize: 2}},
+	}}
+	store := openTestStore(t, filepath.Join(t.TempDir(), "bridge.db"))
+	defer store.Close()
+	b := &Bridge{contract: results, store: store}
+
+	checkpointAt := func(block uint64, transactionID string) {
+		t.Helper()
+
+		checkpoint, err := store.Checkpoint(ctx)
+
+		if err != nil || checkpoint == nil || checkpoint.BlockNumber() != block || checkpoint.TransactionID() != transactionID {
+			t.Errorf("Expected the checkpoint at %s of block %d, got %+v %v", transactionID, block, checkpoint, err)
+		}
+	}
+
+	released := &client.ChaincodeEvent{BlockNumber: 1, TransactionID: "tx1", EventName: eventResultReleased, Payload: []byte(`{"resultID":"RESULT0","proposalID":"PROPOSAL0"}`)}
+	updated := &client.ChaincodeEvent{BlockNumber: 2, TransactionID: "tx2", EventName: eventPatientUpdated, Payload: []byte(`{"patientID":"PATIENT0","changes":{"statusID":"ab12"}}`)}
+
+	for _, event := range []*client.ChaincodeEvent{released, updated} {
+		if err := b.write(ctx, event); err != nil {
+			t.Fatalf("write failed. %s", err.Error())
+		}
+	}
+
+	checkpointAt(2, "tx2")
+
+	// Events are delivered again when the bridge stops before the peer sees the checkpoint
+	for _, event := range []*client.ChaincodeEvent{released, updated} {
+		if err := b.write(ctx, event); err != nil {
+			t.Fatalf("write failed. %s", err.Error())
+		}
+	}
+
+	if rows := countRows(t, store, "released_results"); rows != 1 {
+		t.Errorf("Expected the result to be written once, got %d rows", rows)
+	}
+
+	if rows := countRows(t, store, "patient_updates"); rows != 1 {
+		t.Errorf("Expected the update to be written once, got %d rows", rows)
+	}
+
+	cohortSize := 0
+
+	if err := store.db.QueryRow(`SELECT cohort_size FROM released_results WHERE result_id = 'RESULT0'`).Scan(&cohortSize); err != nil || cohortSize != 2 {
+		t.Errorf("Expected the manifest of RESULT0 to be written, got %d %v", cohortSize, err)
+	}
+
+	// Events that can't be written only move the checkpoint, without stopping the bridge
+	for i, event := range []*client.ChaincodeEvent{
+		{BlockNumber: 3, TransactionID: "tx3", EventName: "ProposalCreated", Payload: []byte(`{}`)},
+		{BlockNumber: 4, TransactionID: "tx4", EventName: eventPatientUpdated, Payload: []byte(`{`)},
+		{BlockNumber: 5, TransactionID: "tx5", EventName: eventResultReleased, Payload: []byte(`{"resultID":"RESULT1","proposalID":"PROPOSAL1"}`)},
+	} {
+		if err := b.write(ctx, event); err != nil {
+			t.Errorf("Expected event %d to be skipped, got %s", i, err.Error())
+		}
+
+		checkpointAt(event.BlockNumber, event.TransactionID)
+	}
+
+	if rows := countRows(t, store, "released_results"); rows != 1 {
+		t.Errorf("Expected the embargoed result not to be written, got %d rows", rows)
+	}
+
+	// The bridge stops without moving the checkpoint when the contract can't be reached,
+	// so the event is delivered again when it resumes
+	results.unreachable = errors.New("unavailable")
+	err := b.write(ctx, &client.ChaincodeEvent{BlockNumber: 6, TransactionID: "tx6", EventName: eventResultReleased, Payload: released.Payload})
+
+	if err != results.unreachable {
+		t.Errorf("Expected the bridge to stop when the contract is unreachable, got %v", err)
+	}
+
+	checkpointAt(5, "tx5")
+}
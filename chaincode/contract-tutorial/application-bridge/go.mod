@@ -0,0 +1,25 @@
+module github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/application-bridge
+
+go 1.21
+
+require (
+	github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/pkg/client v0.0.0
+	github.com/hyperledger/fabric-gateway v1.4.0
+	github.com/lib/pq v1.12.3
+	github.com/mattn/go-sqlite3 v1.14.52
+	google.golang.org/grpc v1.59.0
+)
+
+require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/hyperledger/fabric-protos-go-apiv2 v0.2.1 // indirect
+	github.com/miekg/pkcs11 v1.1.1 // indirect
+	golang.org/x/crypto v0.14.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20231016165738-49dd2c1f3d0b // indirect
+	google.golang.org/protobuf v1.31.0 // indirect
+)
+
+replace github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/pkg/client => ../pkg/client
@@ -0,0 +1,173 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Command application-bridge streams the ResultReleased and PatientUpdated events of the
+// contract into an analytical store, SQLite or PostgreSQL, for off-chain reporting:
+//
+//	application-bridge -driver sqlite3 -dsn bridge.db
+//	application-bridge -driver postgres -dsn "postgres://bridge@localhost/analytics" -start-block 0
+//
+// Reports query the store rather than the ledger. Each event is written exactly once: the
+// store keeps the block and transaction of the last event written, updated in the database
+// transaction writing the event, and the bridge resumes after them when restarted.
+//
+// The gateway peer and the identity are read from the environment, with the defaults of
+// Org1 of the test network, as for application-java.
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+
+	tutorial "github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var (
+	channelName   = env("CHANNEL_NAME", "mychannel")
+	chaincodeName = env("CHAINCODE_NAME", "contract-tutorial")
+	mspID         = env("MSP_ID", "Org1MSP")
+	peerEndpoint  = env("PEER_ENDPOINT", "localhost:7051")
+	peerHostAlias = env("PEER_HOST_ALIAS", "peer0.org1.example.com")
+	cryptoPath    = env("CRYPTO_PATH", "../../../test-network/organizations/peerOrganizations/org1.example.com")
+	certPath      = env("CERT_PATH", filepath.Join(cryptoPath, "users/User1@org1.example.com/msp/signcerts/cert.pem"))
+	keyDirPath    = env("KEY_DIR_PATH", filepath.Join(cryptoPath, "users/User1@org1.example.com/msp/keystore"))
+	tlsCertPath   = env("TLS_CERT_PATH", filepath.Join(cryptoPath, "peers/peer0.org1.example.com/tls/ca.crt"))
+)
+
+func main() {
+	driver := flag.String("driver", "sqlite3", "database driver, sqlite3 or postgres")
+	dsn := flag.String("dsn", "bridge.db", "data source name of the database")
+	startBlock := flag.Uint64("start-block", 0, "block the first run starts at, later runs resume after the checkpoint")
+	flag.Parse()
+
+	if *driver != "sqlite3" && *driver != "postgres" {
+		fail(fmt.Errorf("unknown driver %s", *driver))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	store, err := OpenStore(ctx, *driver, *dsn)
+
+	if err != nil {
+		fail(err)
+	}
+
+	defer store.Close()
+
+	gw, err := connect()
+
+	if err != nil {
+		fail(err)
+	}
+
+	defer gw.Close()
+
+	bridge := &Bridge{
+		network:   gw.GetNetwork(channelName),
+		contract:  tutorial.New(gw, channelName, chaincodeName),
+		chaincode: chaincodeName,
+		store:     store,
+	}
+
+	log.Printf("Writing the events of %s on %s to %s", chaincodeName, channelName, *driver)
+
+	if err := bridge.Run(ctx, *startBlock); err != nil && ctx.Err() == nil {
+		fail(err)
+	}
+}
+
+// connect connects to the gateway peer with the identity of the environment
+func connect() (*client.Gateway, error) {
+	tlsCertificatePEM, err := os.ReadFile(tlsCertPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tlsCertificate, err := identity.CertificateFromPEM(tlsCertificatePEM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	certPool := x509.NewCertPool()
+	certPool.AddCert(tlsCertificate)
+
+	connection, err := grpc.Dial(peerEndpoint, grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(certPool, peerHostAlias)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	certificatePEM, err := os.ReadFile(certPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	certificate, err := identity.CertificateFromPEM(certificatePEM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := identity.NewX509Identity(mspID, certificate)
+
+	if err != nil {
+		return nil, err
+	}
+
+	keyFiles, err := os.ReadDir(keyDirPath)
+
+	if err != nil || len(keyFiles) == 0 {
+		return nil, fmt.Errorf("no private key in %s", keyDirPath)
+	}
+
+	privateKeyPEM, err := os.ReadFile(filepath.Join(keyDirPath, keyFiles[0].Name()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, err := identity.PrivateKeyFromPEM(privateKeyPEM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sign, err := identity.NewPrivateKeySign(privateKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Connect(id, client.WithSign(sign), client.WithClientConnection(connection))
+}
+
+// env returns a variable of the environment, the fallback when it is empty
+func env(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return fallback
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "application-bridge:", err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,131 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// schema creates the tables of the analytical store. It is written in the SQL SQLite and
+// PostgreSQL share, with numbered placeholders, which both drivers accept.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS bridge_checkpoint (
+		id INTEGER PRIMARY KEY,
+		block_number BIGINT NOT NULL,
+		transaction_id TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS released_results (
+		result_id TEXT PRIMARY KEY,
+		proposal_id TEXT NOT NULL,
+		key_id TEXT NOT NULL,
+		value TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		cohort_size INTEGER NOT NULL,
+		block_number BIGINT NOT NULL,
+		transaction_id TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS patient_updates (
+		transaction_id TEXT PRIMARY KEY,
+		patient_id TEXT NOT NULL,
+		changes TEXT NOT NULL,
+		block_number BIGINT NOT NULL
+	)`,
+}
+
+// Checkpoint is the position of the last event written to the store: its block and
+// transaction. Fabric sets at most one chaincode event per transaction, so the transaction
+// ID tells which events of the block were written.
+type Checkpoint struct {
+	Block       uint64
+	Transaction string
+}
+
+// BlockNumber returns the block of the last event written
+func (c *Checkpoint) BlockNumber() uint64 {
+	return c.Block
+}
+
+// TransactionID returns the transaction of the last event written
+func (c *Checkpoint) TransactionID() string {
+	return c.Transaction
+}
+
+// Store is the analytical store the bridge writes events to
+type Store struct {
+	db *sql.DB
+}
+
+// OpenStore opens the database of a driver and creates the tables it lacks
+func OpenStore(ctx context.Context, driver string, dsn string) (*Store, error) {
+	db, err := sql.Open(driver, dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statement := range schema {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			db.Close()
+
+			return nil, fmt.Errorf("failed to create the tables. %s", err.Error())
+		}
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close closes the database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Checkpoint returns the position of the last event written, nil before the first
+func (s *Store) Checkpoint(ctx context.Context) (*Checkpoint, error) {
+	checkpoint := new(Checkpoint)
+
+	err := s.db.QueryRowContext(ctx, `SELECT block_number, transaction_id FROM bridge_checkpoint WHERE id = 1`).Scan(&checkpoint.Block, &checkpoint.Transaction)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return checkpoint, nil
+}
+
+// Write runs the writes of an event and moves the checkpoint past it in one database
+// transaction, so an event is written once even if the bridge stops in between. The rows
+// are keyed by what the ledger keys them by, so an event delivered again is ignored.
+func (s *Store) Write(ctx context.Context, checkpoint Checkpoint, write func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if write != nil {
+		if err := write(tx); err != nil {
+			tx.Rollback()
+
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO bridge_checkpoint (id, block_number, transaction_id) VALUES (1, $1, $2)
+		ON CONFLICT (id) DO UPDATE SET block_number = excluded.block_number, transaction_id = excluded.transaction_id`, checkpoint.Block, checkpoint.Transaction)
+
+	if err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
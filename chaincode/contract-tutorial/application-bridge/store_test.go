@@ -0,0 +1,98 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// openTestStore opens a store in a SQLite database of a temporary directory
+func openTestStore(t *testing.T, path string) *Store {
+	store, err := OpenStore(context.Background(), "sqlite3", path)
+
+	if err != nil {
+		t.Fatalf("OpenStore failed. %s", err.Error())
+	}
+
+	return store
+}
+
+// countRows returns the rows of a table of the store
+func countRows(t *testing.T, store *Store, table string) int {
+	count := 0
+
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatalf("Counting %s failed. %s", table, err.Error())
+	}
+
+	return count
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bridge.db")
+	store := openTestStore(t, path)
+
+	if checkpoint, err := store.Checkpoint(ctx); err != nil || checkpoint != nil {
+		t.Fatalf("Expected no checkpoint before the first event, got %+v %v", checkpoint, err)
+	}
+
+	insert := func(transactionID string) func(tx *sql.Tx) error {
+		return func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, `INSERT INTO patient_updates (transaction_id, patient_id, changes, block_number) VALUES ($1, 'PATIENT0', '{}', 1)`, transactionID)
+
+			return err
+		}
+	}
+
+	if err := store.Write(ctx, Checkpoint{Block: 1, Transaction: "tx1"}, insert("tx1")); err != nil {
+		t.Fatalf("Write failed. %s", err.Error())
+	}
+
+	if err := store.Write(ctx, Checkpoint{Block: 2, Transaction: "tx2"}, nil); err != nil {
+		t.Fatalf("Write failed. %s", err.Error())
+	}
+
+	failed := errors.New("failed")
+	err := store.Write(ctx, Checkpoint{Block: 3, Transaction: "tx3"}, func(tx *sql.Tx) error {
+		if err := insert("tx3")(tx); err != nil {
+			return err
+		}
+
+		return failed
+	})
+
+	if err != failed {
+		t.Errorf("Expected the error of the write, got %v", err)
+	}
+
+	if rows := countRows(t, store, "patient_updates"); rows != 1 {
+		t.Errorf("Expected the failed write to be rolled back, got %d rows", rows)
+	}
+
+	store.Close()
+
+	// The checkpoint and the rows outlive the bridge
+	store = openTestStore(t, path)
+	defer store.Close()
+
+	checkpoint, err := store.Checkpoint(ctx)
+
+	if err != nil {
+		t.Fatalf("Checkpoint failed. %s", err.Error())
+	}
+
+	if checkpoint == nil || checkpoint.BlockNumber() != 2 || checkpoint.TransactionID() != "tx2" {
+		t.Errorf("Expected the checkpoint of the last event written, got %+v", checkpoint)
+	}
+
+	if rows := countRows(t, store, "patient_updates"); rows != 1 {
+		t.Errorf("Expected the rows to be kept, got %d", rows)
+	}
+}
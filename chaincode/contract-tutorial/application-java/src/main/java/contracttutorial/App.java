@@ -0,0 +1,174 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package contracttutorial;
+
+import java.io.Reader;
+import java.nio.charset.StandardCharsets;
+import java.nio.file.Files;
+import java.nio.file.Path;
+import java.nio.file.Paths;
+import java.time.Duration;
+import java.util.Arrays;
+import java.util.concurrent.TimeUnit;
+
+import com.google.gson.Gson;
+import com.google.gson.GsonBuilder;
+import io.grpc.Grpc;
+import io.grpc.ManagedChannel;
+import io.grpc.TlsChannelCredentials;
+import org.hyperledger.fabric.client.Gateway;
+import org.hyperledger.fabric.client.identity.Identities;
+import org.hyperledger.fabric.client.identity.Identity;
+import org.hyperledger.fabric.client.identity.Signer;
+import org.hyperledger.fabric.client.identity.Signers;
+import org.hyperledger.fabric.client.identity.X509Identity;
+
+/**
+ * Sample application calling the contract as a member hospital would:
+ *
+ * <pre>
+ * patients &lt;batch.json&gt;
+ *     creates the patients of a JSON batch generated by cmd/datagen
+ * propose &lt;id&gt; &lt;protocolID&gt; &lt;requesterMSP&gt; &lt;requestedMSP&gt; &lt;keyID&gt; &lt;operation&gt; &lt;patientID&gt;...
+ *     submits a proposal over the patients, which the custodian then approves and executes
+ * await &lt;proposalID&gt; [seconds]
+ *     waits for the result of a proposal, 60 seconds by default, and prints it
+ * </pre>
+ *
+ * The gateway peer and the identity are read from the environment, with the defaults of
+ * Org1 of the test network.
+ */
+public final class App {
+    private static final String CHANNEL_NAME = env("CHANNEL_NAME", "mychannel");
+    private static final String CHAINCODE_NAME = env("CHAINCODE_NAME", "contract-tutorial");
+    private static final String MSP_ID = env("MSP_ID", "Org1MSP");
+    private static final String PEER_ENDPOINT = env("PEER_ENDPOINT", "localhost:7051");
+    private static final String PEER_HOST_ALIAS = env("PEER_HOST_ALIAS", "peer0.org1.example.com");
+
+    private static final Path CRYPTO_PATH = Paths.get(env("CRYPTO_PATH",
+            "../../../test-network/organizations/peerOrganizations/org1.example.com"));
+    private static final Path CERT_PATH = Paths.get(env("CERT_PATH",
+            CRYPTO_PATH.resolve("users/User1@org1.example.com/msp/signcerts/cert.pem").toString()));
+    private static final Path KEY_DIR_PATH = Paths.get(env("KEY_DIR_PATH",
+            CRYPTO_PATH.resolve("users/User1@org1.example.com/msp/keystore").toString()));
+    private static final Path TLS_CERT_PATH = Paths.get(env("TLS_CERT_PATH",
+            CRYPTO_PATH.resolve("peers/peer0.org1.example.com/tls/ca.crt").toString()));
+
+    private static final Gson GSON = new GsonBuilder().setPrettyPrinting().create();
+
+    /**
+     * An element of the JSON batch generated by cmd/datagen.
+     */
+    private static final class Patient {
+        String id;
+        String name;
+        String preExistingConditions;
+        String diagnosisID;
+        String statusID;
+        String keyID;
+    }
+
+    private App() { }
+
+    public static void main(final String[] args) throws Exception {
+        if (args.length == 0) {
+            usage();
+        }
+
+        ManagedChannel channel = newGrpcConnection();
+
+        try (Gateway gateway = Gateway.newInstance()
+                .identity(newIdentity())
+                .signer(newSigner())
+                .connection(channel)
+                .evaluateOptions(options -> options.withDeadlineAfter(5, TimeUnit.SECONDS))
+                .endorseOptions(options -> options.withDeadlineAfter(15, TimeUnit.SECONDS))
+                .submitOptions(options -> options.withDeadlineAfter(5, TimeUnit.SECONDS))
+                .commitStatusOptions(options -> options.withDeadlineAfter(1, TimeUnit.MINUTES))
+                .connect()) {
+            run(new ContractClient(gateway, CHANNEL_NAME, CHAINCODE_NAME), args);
+        } catch (ContractException e) {
+            System.err.println(e.getMessage() + " " + e.getDetails());
+            System.exit(1);
+        } finally {
+            channel.shutdownNow().awaitTermination(5, TimeUnit.SECONDS);
+        }
+    }
+
+    private static void run(final ContractClient client, final String[] args) throws Exception {
+        switch (args[0]) {
+        case "patients":
+            if (args.length != 2) {
+                usage();
+            }
+
+            try (Reader reader = Files.newBufferedReader(Paths.get(args[1]), StandardCharsets.UTF_8)) {
+                for (Patient patient : GSON.fromJson(reader, Patient[].class)) {
+                    client.createPatient(patient.id, patient.name, patient.preExistingConditions, patient.diagnosisID,
+                            patient.statusID, patient.keyID);
+                    System.out.println("Created " + patient.id);
+                }
+            }
+            break;
+        case "propose":
+            if (args.length < 8) {
+                usage();
+            }
+
+            client.submitProposal(args[1], args[2], args[3], args[4], Arrays.asList(Arrays.copyOfRange(args, 7, args.length)),
+                    args[5], args[6], "");
+            System.out.println(GSON.toJson(client.findProposal(args[1])));
+            break;
+        case "await":
+            if (args.length != 2 && args.length != 3) {
+                usage();
+            }
+
+            Duration timeout = Duration.ofSeconds(args.length == 3 ? Long.parseLong(args[2]) : 60);
+            System.out.println(GSON.toJson(client.awaitResult(args[1], timeout)));
+            break;
+        default:
+            usage();
+        }
+    }
+
+    private static void usage() {
+        System.err.println("usage: patients <batch.json> | propose <id> <protocolID> <requesterMSP> <requestedMSP> <keyID> "
+                + "<operation> <patientID>... | await <proposalID> [seconds]");
+        System.exit(2);
+    }
+
+    private static String env(final String name, final String fallback) {
+        String value = System.getenv(name);
+
+        return value == null || value.isEmpty() ? fallback : value;
+    }
+
+    private static ManagedChannel newGrpcConnection() throws Exception {
+        var credentials = TlsChannelCredentials.newBuilder()
+                .trustManager(TLS_CERT_PATH.toFile())
+                .build();
+
+        return Grpc.newChannelBuilder(PEER_ENDPOINT, credentials)
+                .overrideAuthority(PEER_HOST_ALIAS)
+                .build();
+    }
+
+    private static Identity newIdentity() throws Exception {
+        try (Reader certReader = Files.newBufferedReader(CERT_PATH)) {
+            return new X509Identity(MSP_ID, Identities.readX509Certificate(certReader));
+        }
+    }
+
+    private static Signer newSigner() throws Exception {
+        try (var keyFiles = Files.list(KEY_DIR_PATH)) {
+            Path keyPath = keyFiles.findFirst().orElseThrow();
+
+            try (Reader keyReader = Files.newBufferedReader(keyPath)) {
+                return Signers.newPrivateKeySigner(Identities.readPrivateKey(keyReader));
+            }
+        }
+    }
+}
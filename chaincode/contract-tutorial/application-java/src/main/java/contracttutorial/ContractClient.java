@@ -0,0 +1,315 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package contracttutorial;
+
+import java.io.ByteArrayInputStream;
+import java.io.IOException;
+import java.io.UncheckedIOException;
+import java.nio.charset.StandardCharsets;
+import java.time.Duration;
+import java.util.Base64;
+import java.util.List;
+import java.util.concurrent.CompletableFuture;
+import java.util.concurrent.TimeUnit;
+import java.util.concurrent.TimeoutException;
+import java.util.concurrent.atomic.AtomicBoolean;
+import java.util.regex.Matcher;
+import java.util.regex.Pattern;
+import java.util.zip.GZIPInputStream;
+
+import com.google.gson.Gson;
+import com.google.gson.JsonParseException;
+import org.hyperledger.fabric.client.ChaincodeEvent;
+import org.hyperledger.fabric.client.CloseableIterator;
+import org.hyperledger.fabric.client.CommitException;
+import org.hyperledger.fabric.client.Contract;
+import org.hyperledger.fabric.client.EndorseException;
+import org.hyperledger.fabric.client.Gateway;
+import org.hyperledger.fabric.client.GatewayException;
+import org.hyperledger.fabric.client.GatewayRuntimeException;
+import org.hyperledger.fabric.client.Network;
+import org.hyperledger.fabric.protos.gateway.ErrorDetail;
+import org.hyperledger.fabric.protos.peer.TxValidationCode;
+
+/**
+ * Calls the contract tutorial chaincode through the Fabric Gateway, retrying the
+ * transactions that fail on MVCC conflicts. It mirrors the Go client of pkg/client.
+ */
+public final class ContractClient {
+    /** The chaincode event emitted when a result is created. */
+    private static final String EVENT_RESULT_RELEASED = "ResultReleased";
+
+    /** The contract serving the API the client is written against, the default one serving v1. */
+    private static final String API_VERSION = "v2";
+
+    private static final Pattern PROPOSAL_NUMBER = Pattern.compile("[0-9]+");
+
+    private final Gson gson = new Gson();
+    private final Network network;
+    private final Contract contract;
+    private final String chaincode;
+    private int retries = 3;
+    private Duration backoff = Duration.ofMillis(100);
+
+    /**
+     * Returns a client of the chaincode on a channel of the gateway.
+     */
+    public ContractClient(final Gateway gateway, final String channel, final String chaincode) {
+        this.network = gateway.getNetwork(channel);
+        this.contract = network.getContract(chaincode, API_VERSION);
+        this.chaincode = chaincode;
+    }
+
+    /**
+     * Sets how many times a transaction failing on an MVCC conflict is submitted again, 3 by default.
+     */
+    public ContractClient withRetries(final int retries) {
+        this.retries = retries;
+        return this;
+    }
+
+    /**
+     * Sets the wait before the first retry, doubled on each retry, 100ms by default.
+     */
+    public ContractClient withBackoff(final Duration backoff) {
+        this.backoff = backoff;
+        return this;
+    }
+
+    public void createPatient(final String id, final String name, final String preExistingConditions, final String diagnosisID,
+            final String statusID, final String keyID) throws ContractException, GatewayException, CommitException, InterruptedException {
+        submit("CreatePatient", id, name, preExistingConditions, diagnosisID, statusID, keyID);
+    }
+
+    /**
+     * Creates a proposal over the patients under a study protocol, an empty expiry never expires.
+     */
+    public void submitProposal(final String id, final String protocolID, final String requesterID, final String requestedID,
+            final List<String> patientsIDs, final String keyID, final String operation, final String expiry)
+            throws ContractException, GatewayException, CommitException, InterruptedException {
+        submit("CreateProposal", id, protocolID, requesterID, requestedID, String.join(",", patientsIDs), keyID, operation, expiry);
+    }
+
+    public Records.Proposal findProposal(final String id) throws ContractException, GatewayException {
+        return evaluate(Records.Proposal.class, "FindProposal", id);
+    }
+
+    public Records.Result findResult(final String id) throws ContractException, GatewayException {
+        return evaluate(Records.Result.class, "FindResult", id);
+    }
+
+    /**
+     * Returns a page of the patients of the caller's org in a range of IDs, the response gzipped
+     * by the contract and decompressed by the client.
+     */
+    public Records.PatientPage allPatients(final String firstID, final String lastID, final int pageSize, final String bookmark)
+            throws ContractException, GatewayException {
+        return evaluateCompressed(Records.PatientPage.class, "AllPatients", firstID, lastID, Integer.toString(pageSize), bookmark);
+    }
+
+    /**
+     * Returns a page of the patients of the caller's org, the response gzipped by the contract
+     * and decompressed by the client.
+     */
+    public Records.PatientPage getMyOrgPatients(final int pageSize, final String bookmark)
+            throws ContractException, GatewayException {
+        return evaluateCompressed(Records.PatientPage.class, "GetMyOrgPatients", Integer.toString(pageSize), bookmark);
+    }
+
+    /**
+     * Returns the result of a proposal, waiting for the custodian to release it if needed.
+     * It returns when the result is released and fails once the timeout elapses.
+     */
+    public Records.Result awaitResult(final String proposalID, final Duration timeout)
+            throws ContractException, GatewayException, TimeoutException {
+        AtomicBoolean timedOut = new AtomicBoolean();
+
+        // Listen before looking the result up, so a result released in between isn't missed
+        try (CloseableIterator<ChaincodeEvent> events = network.getChaincodeEvents(chaincode)) {
+            CompletableFuture.delayedExecutor(timeout.toMillis(), TimeUnit.MILLISECONDS).execute(() -> {
+                timedOut.set(true);
+                events.close();
+            });
+
+            try {
+                return findProposalResult(proposalID);
+            } catch (ContractException e) {
+                if (!"NOT_FOUND".equals(e.getCode())) {
+                    throw e;
+                }
+            }
+
+            while (events.hasNext()) {
+                ChaincodeEvent event = events.next();
+
+                if (!EVENT_RESULT_RELEASED.equals(event.getEventName())) {
+                    continue;
+                }
+
+                Records.ResultReleasedEvent released = decode(event.getPayload(), Records.ResultReleasedEvent.class);
+
+                if (released == null || !proposalID.equals(released.proposalID)) {
+                    continue;
+                }
+
+                return findResult(released.resultID);
+            }
+        } catch (GatewayRuntimeException e) {
+            if (!timedOut.get()) {
+                throw e;
+            }
+        }
+
+        if (timedOut.get()) {
+            throw new TimeoutException("result of " + proposalID + " not released within " + timeout);
+        }
+
+        throw new IllegalStateException("chaincode events closed before the result was released");
+    }
+
+    /**
+     * Endorses and submits a transaction, then waits for its commit. Every retry is endorsed
+     * again, so it reads the state written by the conflicting transaction.
+     */
+    private byte[] submit(final String name, final String... args)
+            throws ContractException, GatewayException, CommitException, InterruptedException {
+        long wait = backoff.toMillis();
+
+        for (int attempt = 0;; attempt++) {
+            try {
+                return contract.submitTransaction(name, args);
+            } catch (EndorseException e) {
+                throw contractException(e);
+            } catch (CommitException e) {
+                if (!retryable(e) || attempt >= retries) {
+                    throw e;
+                }
+            }
+
+            Thread.sleep(wait);
+            wait *= 2;
+        }
+    }
+
+    /**
+     * Queries the chaincode and decodes the JSON result.
+     */
+    private <T> T evaluate(final Class<T> type, final String name, final String... args)
+            throws ContractException, GatewayException {
+        byte[] result;
+
+        try {
+            result = contract.evaluateTransaction(name, args);
+        } catch (GatewayException e) {
+            throw contractException(e);
+        }
+
+        return gson.fromJson(new String(result, StandardCharsets.UTF_8), type);
+    }
+
+    /**
+     * Queries a listing through CompressedQuery, accepting gzip, and decodes the decompressed
+     * JSON result.
+     */
+    private <T> T evaluateCompressed(final Class<T> type, final String name, final String... args)
+            throws ContractException, GatewayException {
+        Records.CompressedResponse response = evaluate(Records.CompressedResponse.class, "CompressedQuery", "gzip", name, gson.toJson(args));
+        byte[] payload = Base64.getDecoder().decode(response.payload);
+
+        switch (response.encoding) {
+            case "identity":
+                break;
+            case "gzip":
+                try (GZIPInputStream in = new GZIPInputStream(new ByteArrayInputStream(payload))) {
+                    payload = in.readAllBytes();
+                } catch (IOException e) {
+                    throw new UncheckedIOException(e);
+                }
+                break;
+            default:
+                throw new IllegalStateException("unsupported response encoding " + response.encoding);
+        }
+
+        return gson.fromJson(new String(payload, StandardCharsets.UTF_8), type);
+    }
+
+    /**
+     * Tells whether a transaction failed to commit only because of a concurrent one.
+     */
+    private static boolean retryable(final CommitException e) {
+        return e.getCode() == TxValidationCode.MVCC_READ_CONFLICT || e.getCode() == TxValidationCode.PHANTOM_READ_CONFLICT;
+    }
+
+    /**
+     * Returns the error envelope in the details of a gateway error, or throws the error itself.
+     */
+    private <E extends GatewayException> ContractException contractException(final E e) throws E {
+        for (ErrorDetail detail : e.getDetails()) {
+            String message = detail.getMessage();
+            int i = message.indexOf('{');
+
+            if (i < 0) {
+                continue;
+            }
+
+            Records.ErrorEnvelope envelope = decode(message.substring(i).getBytes(StandardCharsets.UTF_8), Records.ErrorEnvelope.class);
+
+            if (envelope != null && envelope.code != null && !envelope.code.isEmpty()) {
+                return new ContractException(envelope.code, envelope.message, envelope.details, e);
+            }
+        }
+
+        throw e;
+    }
+
+    /**
+     * Decodes a JSON payload, null if it is malformed.
+     */
+    private <T> T decode(final byte[] payload, final Class<T> type) {
+        try {
+            return gson.fromJson(new String(payload, StandardCharsets.UTF_8), type);
+        } catch (JsonParseException e) {
+            return null;
+        }
+    }
+
+    /**
+     * Returns the result of a proposal. Results released before their IDs took the whole proposal
+     * ID are numbered after it, and may be another proposal's.
+     */
+    private Records.Result findProposalResult(final String proposalID) throws ContractException, GatewayException {
+        try {
+            return findResult("RESULT-" + proposalID);
+        } catch (ContractException e) {
+            if (!"NOT_FOUND".equals(e.getCode())) {
+                throw e;
+            }
+
+            Records.Result legacy;
+
+            try {
+                legacy = findResult("RESULT" + proposalNumber(proposalID));
+            } catch (ContractException legacyException) {
+                throw e;
+            }
+
+            if (!proposalID.equals(legacy.proposalID)) {
+                throw e;
+            }
+
+            return legacy;
+        }
+    }
+
+    /**
+     * Returns the number of a proposal ID, shared by the ID of the results released before
+     * result IDs took the whole proposal ID.
+     */
+    private static String proposalNumber(final String proposalID) {
+        Matcher matcher = PROPOSAL_NUMBER.matcher(proposalID);
+
+        return matcher.find() ? matcher.group() : "";
+    }
+}
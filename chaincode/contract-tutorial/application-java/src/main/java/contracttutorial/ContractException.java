@@ -0,0 +1,38 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package contracttutorial;
+
+import java.util.Collections;
+import java.util.Map;
+
+/**
+ * A business failure of the contract, decoded from the error envelope it returns.
+ */
+public final class ContractException extends Exception {
+    private static final long serialVersionUID = 1L;
+
+    private final String code;
+    private final Map<String, String> details;
+
+    ContractException(final String code, final String message, final Map<String, String> details, final Throwable cause) {
+        super(code + ": " + message, cause);
+        this.code = code;
+        this.details = details == null ? Collections.emptyMap() : Collections.unmodifiableMap(details);
+    }
+
+    /**
+     * @return the error code, such as NOT_FOUND or PERMISSION_DENIED
+     */
+    public String getCode() {
+        return code;
+    }
+
+    /**
+     * @return the details of the failure, such as the ID of the record involved
+     */
+    public Map<String, String> getDetails() {
+        return details;
+    }
+}
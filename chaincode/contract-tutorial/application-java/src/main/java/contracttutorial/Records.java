@@ -0,0 +1,111 @@
+/*
+ * SPDX-License-Identifier: Apache-2.0
+ */
+
+package contracttutorial;
+
+import java.util.List;
+import java.util.Map;
+
+/**
+ * The records of the contract the client reads, with the fields of their JSON encoding.
+ */
+public final class Records {
+    private Records() { }
+
+    /**
+     * A computation requested over a cohort.
+     */
+    public static final class Proposal {
+        public String protocolID;
+        public String requesterID;
+        public String requestedID;
+        public String patientsIDs;
+        public String keyID;
+        public String operation;
+        public String expiry;
+        public String asOf;
+        public String status;
+        public int version;
+        public List<String> metrics;
+        public String value;
+        public Map<String, String> values;
+    }
+
+    /**
+     * Describes the computation behind a result.
+     */
+    public static final class Manifest {
+        public String operation;
+        public List<String> metrics;
+        public int cohortSize;
+        public String asOf;
+        public List<String> pseudonyms;
+    }
+
+    /**
+     * The re-encrypted result of an executed proposal.
+     */
+    public static final class Result {
+        public String proposalID;
+        public String keyID;
+        public String value;
+        public Manifest manifest;
+    }
+
+    /**
+     * A patient record, its values encrypted under its key.
+     */
+    public static final class Patient {
+        public String name;
+        public String preExistingConditions;
+        public String diagnosisID;
+        public String statusID;
+        public String keyID;
+        public Map<String, String> measurements;
+        public String custodianMSP;
+    }
+
+    /**
+     * A patient of a listing with its ID.
+     */
+    public static final class PatientRecord {
+        public String Key;
+        public Patient Record;
+    }
+
+    /**
+     * A page of a patient listing, its bookmark reading the next one.
+     */
+    public static final class PatientPage {
+        public List<PatientRecord> records;
+        public String bookmark;
+        public int count;
+        public boolean truncated;
+    }
+
+    /**
+     * A listing response in the encoding the contract applied.
+     */
+    static final class CompressedResponse {
+        String encoding;
+        String payload;
+    }
+
+    /**
+     * The payload of the ResultReleased event.
+     */
+    static final class ResultReleasedEvent {
+        String resultID;
+        String proposalID;
+    }
+
+    /**
+     * The error envelope of a business failure.
+     */
+    static final class ErrorEnvelope {
+        String code;
+        String message;
+        Map<String, String> details;
+    }
+}
@@ -0,0 +1,188 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// mspRootIndex is the composite key namespace of the root certificates of each MSP
+const mspRootIndex = "msp~root"
+
+// adminOU is the organizational unit of admin identities when node OUs are enabled
+const adminOU = "admin"
+
+// attestationMessage is what the custodian's admin signs to approve a version of a proposal
+func attestationMessage(id string, version int) []byte {
+	return []byte(fmt.Sprintf("APPROVE\x00%s\x00%d", id, version))
+}
+
+// parseCertificate decodes a PEM encoded certificate
+func parseCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, newError(CodeInvalidArgument, nil, "Not a PEM encoded certificate")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, nil, "Invalid certificate. %s", err.Error())
+	}
+
+	return cert, nil
+}
+
+// RegisterMSPRoot stores the PEM encoded root certificates attestations of an MSP
+// are verified against. Only admins of the MSP itself can set them.
+func (s *SimpleContract) RegisterMSPRoot(ctx contractapi.TransactionContextInterface, mspID string, rootsPEM string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	callerMSPID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if callerMSPID != mspID {
+		return newError(CodePermissionDenied, map[string]string{"mspID": callerMSPID}, "Only %s can register its roots", mspID)
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM([]byte(rootsPEM)) {
+		return newError(CodeInvalidArgument, map[string]string{"mspID": mspID}, "No PEM encoded certificate found")
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(mspRootIndex, []string{mspID})
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte(rootsPEM))
+}
+
+// verifyAttestation checks that an admin of the MSP signed the message and returns its certificate
+func verifyAttestation(ctx contractapi.TransactionContextInterface, mspID string, message []byte, certPEM string, signature string) (*x509.Certificate, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(mspRootIndex, []string{mspID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rootsPEM, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if rootsPEM == nil {
+		return nil, newError(CodeNotFound, map[string]string{"mspID": mspID}, "%s has no registered roots", mspID)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(rootsPEM)
+
+	cert, err := parseCertificate(certPEM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		return nil, newError(CodePermissionDenied, map[string]string{"mspID": mspID}, "Certificate is not issued by %s. %s", mspID, err.Error())
+	}
+
+	admin := false
+
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		admin = admin || ou == adminOU
+	}
+
+	if !admin {
+		return nil, newError(CodePermissionDenied, map[string]string{"mspID": mspID}, "Certificate is not of an admin of %s", mspID)
+	}
+
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+
+	if !ok {
+		return nil, newError(CodeInvalidArgument, nil, "Certificate does not hold an ECDSA key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, nil, "Signature is not base64 encoded")
+	}
+
+	digest := sha256.Sum256(message)
+
+	if !ecdsa.VerifyASN1(publicKey, digest[:], sig) {
+		return nil, newError(CodePermissionDenied, map[string]string{"mspID": mspID}, "Invalid signature")
+	}
+
+	return cert, nil
+}
+
+// ApproveProposalWithAttestation approves a pending proposal with the signature of an admin
+// of the custodian, made off-chain over the proposal ID and version. Anyone can submit it.
+func (s *SimpleContract) ApproveProposalWithAttestation(ctx contractapi.TransactionContextInterface, id string, certPEM string, signature string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalPending {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not pending", id)
+	}
+
+	cert, err := verifyAttestation(ctx, proposal.RequestedID, attestationMessage(id, proposal.Version), certPEM, signature)
+
+	if err != nil {
+		return withDetail(err, "id", id)
+	}
+
+	submission, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// Recorded as the signer, in the format of the client identity IDs
+	approval := submission
+	approval.ClientID = base64.StdEncoding.EncodeToString([]byte("x509::" + cert.Subject.String() + "::" + cert.Issuer.String()))
+	approval.MSPID = proposal.RequestedID
+
+	proposal.Approvals = append(proposal.Approvals, approval)
+	proposal.Status = ProposalApproved
+	proposal.Metadata.Updated = submission
+
+	if err := checkQuota(ctx, id, proposal, false); err != nil {
+		return err
+	}
+
+	if err := lockCohort(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	if err := emitEvent(ctx, EventProposalApproved, &ProposalEvent{ProposalID: id, Status: proposal.Status}); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
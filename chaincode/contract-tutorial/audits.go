@@ -0,0 +1,429 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// auditRecordIndex is the composite key namespace of the proposals and results created in
+// each month, the periods they are audited in
+const auditRecordIndex = "audit~record"
+
+// auditPeriodIndex is the composite key namespace of the closed audit periods
+const auditPeriodIndex = "audit~period"
+
+// auditAssignmentIndex is the composite key namespace of the audit assignments of each period
+const auditAssignmentIndex = "audit~assignment"
+
+// Types of the records audited
+const (
+	AuditRecordProposal = "PROPOSAL"
+	AuditRecordResult   = "RESULT"
+)
+
+// Audit assignment statuses
+const (
+	AuditPending   = "PENDING"
+	AuditCompleted = "COMPLETED"
+)
+
+// AuditPeriod is a month closed for auditing. BlockHash is the hex encoded hash of the
+// latest block at its close, the seed of its sample.
+type AuditPeriod struct {
+	Period    string              `json:"period"`
+	BlockHash string              `json:"blockHash"`
+	Closed    TransactionDetails  `json:"closed"`
+	Rate      float64             `json:"rate,omitempty" metadata:",optional"`
+	Sampled   *TransactionDetails `json:"sampled,omitempty" metadata:",optional"`
+}
+
+// AuditAssignment is a proposal or result of a period an auditor org reviews by hand
+type AuditAssignment struct {
+	Period     string              `json:"period"`
+	RecordType string              `json:"recordType"`
+	RecordID   string              `json:"recordID"`
+	AuditorMSP string              `json:"auditorMSP"`
+	Status     string              `json:"status"`
+	Finding    string              `json:"finding,omitempty" metadata:",optional"`
+	Assigned   TransactionDetails  `json:"assigned"`
+	Completed  *TransactionDetails `json:"completed,omitempty" metadata:",optional"`
+}
+
+// AuditSample is the sample of a period, drawn from the Population records created in it
+type AuditSample struct {
+	Period      string            `json:"period"`
+	Seed        string            `json:"seed"`
+	Rate        float64           `json:"rate"`
+	Population  int               `json:"population"`
+	Assignments []AuditAssignment `json:"assignments"`
+}
+
+// indexAuditRecord adds a new proposal or result to the records of the month of its creation
+func indexAuditRecord(ctx contractapi.TransactionContextInterface, recordType string, id string) error {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return putIndexEntry(ctx, auditRecordIndex, timeutil.Month(now), recordType, id)
+}
+
+// parsePeriod parses the YYYY-MM month of an audit period and returns its end
+func parsePeriod(period string) (time.Time, error) {
+	start, err := timeutil.ParseMonth(period)
+
+	if err != nil {
+		return time.Time{}, newError(CodeInvalidArgument, map[string]string{"period": period}, "%s", err.Error())
+	}
+
+	return start.AddDate(0, 1, 0), nil
+}
+
+// findAuditPeriod returns the key of an audit period and the period, nil if it isn't closed
+func findAuditPeriod(ctx contractapi.TransactionContextInterface, period string) (string, *AuditPeriod, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(auditPeriodIndex, []string{period})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	periodAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if periodAsBytes == nil {
+		return key, nil, nil
+	}
+
+	auditPeriod := new(AuditPeriod)
+	_ = json.Unmarshal(periodAsBytes, auditPeriod)
+
+	return key, auditPeriod, nil
+}
+
+// findAuditAssignment returns the key of the assignment of a record of a period and the
+// assignment, nil if the record wasn't sampled
+func findAuditAssignment(ctx contractapi.TransactionContextInterface, period string, recordID string) (string, *AuditAssignment, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(auditAssignmentIndex, []string{period, recordID})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	assignmentAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if assignmentAsBytes == nil {
+		return key, nil, nil
+	}
+
+	assignment := new(AuditAssignment)
+	_ = json.Unmarshal(assignmentAsBytes, assignment)
+
+	return key, assignment, nil
+}
+
+// SetAuditorMSP sets the org audit samples are assigned to
+func (s *SimpleContract) SetAuditorMSP(ctx contractapi.TransactionContextInterface, mspID string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if mspID == "" {
+		return newError(CodeInvalidArgument, map[string]string{"field": "mspID"}, "mspID is required")
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.AuditorMSP = mspID
+
+	return putConfig(ctx, config)
+}
+
+// CloseAuditPeriod closes a YYYY-MM month for auditing once it is over, recording the hex
+// encoded hash of the latest block of the channel, read off-chain with qscc, as the seed of
+// its sample. Chaincode can't read blocks, so auditors check the hash against the ledger.
+func (s *SimpleContract) CloseAuditPeriod(ctx contractapi.TransactionContextInterface, period string, blockHash string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	end, err := parsePeriod(period)
+
+	if err != nil {
+		return err
+	}
+
+	if hash, err := hex.DecodeString(blockHash); err != nil || len(hash) != 32 || blockHash != strings.ToLower(blockHash) {
+		return newError(CodeInvalidArgument, map[string]string{"blockHash": blockHash}, "The block hash is not a lower case hex encoded SHA-256 hash")
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if now.Before(end) {
+		return newError(CodeInvalidState, map[string]string{"period": period}, "%s is not over until %s", period, timeutil.Format(end))
+	}
+
+	key, existing, err := findAuditPeriod(ctx, period)
+
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		return newError(CodeAlreadyExists, map[string]string{"period": period}, "%s is already closed", period)
+	}
+
+	closed, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	periodAsBytes, _ := json.Marshal(AuditPeriod{Period: period, BlockHash: blockHash, Closed: closed})
+
+	return ctx.GetStub().PutState(key, periodAsBytes)
+}
+
+// FindAuditPeriod returns a closed audit period
+func (s *SimpleContract) FindAuditPeriod(ctx contractapi.TransactionContextInterface, period string) (*AuditPeriod, error) {
+	_, auditPeriod, err := findAuditPeriod(ctx, period)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if auditPeriod == nil {
+		return nil, newError(CodeNotFound, map[string]string{"period": period}, "%s is not closed", period)
+	}
+
+	return auditPeriod, nil
+}
+
+// SelectAuditSample assigns a share of the proposals and results created in a closed period,
+// the rate rounded up, to the auditor org for manual audit, and sends it an AUDIT_ASSIGNED
+// notification for each. The records are those whose SHA-256 hash of the block hash of the
+// period and their ID ranks first, as SampleCohort ranks patients, so anyone can recompute
+// the sample. A period is sampled once.
+func (s *SimpleContract) SelectAuditSample(ctx contractapi.TransactionContextInterface, period string, rate float64) (*AuditSample, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	if !(rate > 0 && rate <= 1) {
+		return nil, newError(CodeInvalidArgument, map[string]string{"rate": fmt.Sprint(rate)}, "The rate must be above 0 and at most 1")
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.AuditorMSP == "" {
+		return nil, newError(CodeInvalidState, nil, "No auditor org is set, set it with SetAuditorMSP")
+	}
+
+	periodKey, auditPeriod, err := s.findClosedPeriod(ctx, period)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if auditPeriod.Sampled != nil {
+		return nil, newError(CodeAlreadyExists, map[string]string{"period": period, "txID": auditPeriod.Sampled.TxID}, "%s is already sampled", period)
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	type record struct {
+		recordType string
+		id         string
+		rank       string
+	}
+
+	records := []record{}
+
+	for _, recordType := range []string{AuditRecordProposal, AuditRecordResult} {
+		err := forEachIndexEntry(ctx, limit, auditRecordIndex, []string{period, recordType}, func(id string) error {
+			records = append(records, record{recordType: recordType, id: id, rank: sampleRank(auditPeriod.BlockHash, id)})
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].rank != records[j].rank {
+			return records[i].rank < records[j].rank
+		}
+
+		return records[i].id < records[j].id
+	})
+
+	assigned, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sample := &AuditSample{Period: period, Seed: auditPeriod.BlockHash, Rate: rate, Population: len(records), Assignments: []AuditAssignment{}}
+	n := int(math.Ceil(rate * float64(len(records))))
+
+	for _, r := range records[:n] {
+		assignment := AuditAssignment{Period: period, RecordType: r.recordType, RecordID: r.id, AuditorMSP: config.AuditorMSP, Status: AuditPending, Assigned: assigned}
+
+		key, _, err := findAuditAssignment(ctx, period, r.id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		assignmentAsBytes, _ := json.Marshal(assignment)
+
+		if err := ctx.GetStub().PutState(key, assignmentAsBytes); err != nil {
+			return nil, err
+		}
+
+		if err := notify(ctx, config.AuditorMSP, NotificationAuditAssigned, r.id, fmt.Sprintf("%s of %s was sampled for audit", r.id, period)); err != nil {
+			return nil, err
+		}
+
+		sample.Assignments = append(sample.Assignments, assignment)
+	}
+
+	auditPeriod.Rate = rate
+	auditPeriod.Sampled = &assigned
+
+	periodAsBytes, _ := json.Marshal(auditPeriod)
+
+	if err := ctx.GetStub().PutState(periodKey, periodAsBytes); err != nil {
+		return nil, err
+	}
+
+	return sample, nil
+}
+
+// findClosedPeriod returns the key of a closed audit period and the period
+func (s *SimpleContract) findClosedPeriod(ctx contractapi.TransactionContextInterface, period string) (string, *AuditPeriod, error) {
+	if _, err := parsePeriod(period); err != nil {
+		return "", nil, err
+	}
+
+	key, auditPeriod, err := findAuditPeriod(ctx, period)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if auditPeriod == nil {
+		return "", nil, newError(CodeInvalidState, map[string]string{"period": period}, "%s is not closed, close it with CloseAuditPeriod", period)
+	}
+
+	return key, auditPeriod, nil
+}
+
+// CompleteAuditAssignment records the finding of the auditor org on a record of a period
+// assigned to it
+func (s *SimpleContract) CompleteAuditAssignment(ctx contractapi.TransactionContextInterface, period string, recordID string, finding string) error {
+	key, assignment, err := findAuditAssignment(ctx, period, recordID)
+
+	if err != nil {
+		return err
+	}
+
+	if assignment == nil {
+		return newError(CodeNotFound, map[string]string{"period": period, "recordID": recordID}, "%s was not sampled for audit in %s", recordID, period)
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != assignment.AuditorMSP {
+		return newError(CodePermissionDenied, map[string]string{"recordID": recordID, "mspID": details.MSPID}, "Only %s can complete the audit of %s", assignment.AuditorMSP, recordID)
+	}
+
+	if assignment.Status == AuditCompleted {
+		return newError(CodeInvalidState, map[string]string{"recordID": recordID, "txID": assignment.Completed.TxID}, "The audit of %s is already completed", recordID)
+	}
+
+	if finding == "" {
+		return newError(CodeInvalidArgument, map[string]string{"field": "finding"}, "finding is required")
+	}
+
+	assignment.Status = AuditCompleted
+	assignment.Finding = finding
+	assignment.Completed = &details
+
+	assignmentAsBytes, _ := json.Marshal(assignment)
+
+	return ctx.GetStub().PutState(key, assignmentAsBytes)
+}
+
+// GetAuditAssignments returns the assignments of a sampled period, in order of record ID
+func (s *SimpleContract) GetAuditAssignments(ctx contractapi.TransactionContextInterface, period string) ([]AuditAssignment, error) {
+	if _, _, err := s.findClosedPeriod(ctx, period); err != nil {
+		return nil, err
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := []AuditAssignment{}
+
+	err = forEachIndexEntry(ctx, limit, auditAssignmentIndex, []string{period}, func(recordID string) error {
+		_, assignment, err := findAuditAssignment(ctx, period, recordID)
+
+		if err != nil || assignment == nil {
+			return err
+		}
+
+		assignments = append(assignments, *assignment)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return assignments, nil
+}
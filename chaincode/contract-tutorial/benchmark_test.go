@@ -0,0 +1,161 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/hanesbarbosa/phe"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// setupCohort creates n encrypted patients and an approved proposal over them
+func setupCohort(b *testing.B, n int) (*shimtest.MockStub, *phe.PublicKey) {
+	s := new(SimpleContract)
+	stub := shimtest.NewMockStub("contract-tutorial", nil)
+	sk, pk := phe.GenerateKeys(256)
+	ids := make([]string, n)
+
+	stub.MockTransactionStart("setup")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+
+	for i := 0; i < n; i++ {
+		ids[i] = fmt.Sprintf("PATIENT%d", i)
+		value := phe.Encrypt(sk, pk, big.NewInt(int64(i%100))).ToString()
+
+		if err := s.CreatePatient(custodian, ids[i], "Name", value, "D1", "S1", "KEY0"); err != nil {
+			b.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+
+	grantConsents(b, s, custodian, "Org1MSP", ids...)
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		b.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", strings.Join(ids, ","), "KEY0", OperationMean, ""); err != nil {
+		b.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		b.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("setup")
+
+	return stub, pk
+}
+
+// benchmarkExecuteProposal measures the endorsement of ExecuteProposal for a cohort of n patients
+func benchmarkExecuteProposal(b *testing.B, n int) {
+	s := new(SimpleContract)
+	stub, pk := setupCohort(b, n)
+	approved := stub.State["PROPOSAL0"]
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		stub.State["PROPOSAL0"] = approved
+		stub.MockTransactionStart("execute")
+		ctx := newContext(stub, "researcher", "Org1MSP", nil)
+		b.StartTimer()
+
+		if err := s.ExecuteProposal(ctx, "PROPOSAL0", pk.Q.String()); err != nil {
+			b.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+
+		b.StopTimer()
+		stub.MockTransactionEnd("execute")
+		// The mock stub blocks once its event channel is full
+		<-stub.ChaincodeEventsChannel
+		b.StartTimer()
+	}
+}
+
+func BenchmarkExecuteProposal100(b *testing.B) {
+	benchmarkExecuteProposal(b, 100)
+}
+
+func BenchmarkExecuteProposal1000(b *testing.B) {
+	benchmarkExecuteProposal(b, 1000)
+}
+
+func BenchmarkExecuteProposal10000(b *testing.B) {
+	benchmarkExecuteProposal(b, 10000)
+}
+
+// countingStub counts the reads reaching the stub, each one a round trip to the peer
+type countingStub struct {
+	*shimtest.MockStub
+	reads int
+}
+
+func (c *countingStub) GetState(key string) ([]byte, error) {
+	c.reads++
+
+	return c.MockStub.GetState(key)
+}
+
+// benchmarkCreatePatientsBatch measures the endorsement of a batch of n patients,
+// reporting the reads reaching the stub, with or without the caching context
+func benchmarkCreatePatientsBatch(b *testing.B, n int, cached bool) {
+	s := new(SimpleContract)
+	stub := &countingStub{MockStub: shimtest.NewMockStub("contract-tutorial", nil)}
+
+	stub.MockTransactionStart("setup")
+	if err := s.RegisterKey(newContext(stub.MockStub, "clinician", "Org2MSP", nil), "KEY0", "0", 0, ""); err != nil {
+		b.Fatalf("RegisterKey failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("setup")
+
+	patients := make([]PatientInput, n)
+
+	for i := range patients {
+		patients[i] = PatientInput{ID: fmt.Sprintf("PATIENT%d", i), Name: "Name", PreExistingConditions: "0", DiagnosisID: "D1", StatusID: "S1", KeyID: "KEY0"}
+	}
+
+	stub.reads = 0
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		stub.MockTransactionStart("batch")
+
+		var ctx contractapi.SettableTransactionContextInterface = new(contractapi.TransactionContext)
+
+		if cached {
+			ctx = new(CachingContext)
+		}
+
+		ctx.SetStub(stub)
+		ctx.SetClientIdentity(&mockIdentity{id: "clinician", mspID: "Org2MSP"})
+		b.StartTimer()
+
+		if err := s.CreatePatientsBatch(ctx.(contractapi.TransactionContextInterface), patients); err != nil {
+			b.Fatalf("CreatePatientsBatch failed. %s", err.Error())
+		}
+
+		b.StopTimer()
+		stub.MockTransactionEnd("batch")
+		b.StartTimer()
+	}
+
+	b.ReportMetric(float64(stub.reads)/float64(b.N), "reads/op")
+}
+
+func BenchmarkCreatePatientsBatch1000(b *testing.B) {
+	benchmarkCreatePatientsBatch(b, 1000, false)
+}
+
+func BenchmarkCreatePatientsBatch1000Cached(b *testing.B) {
+	benchmarkCreatePatientsBatch(b, 1000, true)
+}
@@ -0,0 +1,166 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// biometricIndex is the composite key namespace of the biometric binding of each patient, in
+// the implicit collection of its custodian
+const biometricIndex = "biometric~patient"
+
+// biometricField is the transient field carrying the hash of a biometric template
+const biometricField = "biometric"
+
+// BiometricBinding binds a patient to the hash of a biometric template, salted with the ID of
+// the transaction that bound it. The template and its hash never reach the ledger, only the
+// salted hash does, in the implicit collection of the custodian.
+type BiometricBinding struct {
+	PatientID string   `json:"patientID"`
+	Salt      string   `json:"salt"`
+	Hash      string   `json:"hash"`
+	Metadata  Metadata `json:"metadata"`
+}
+
+// transientBiometric returns the hex encoded SHA-256 hash of a biometric template passed in
+// the "biometric" transient field. Anything else is refused, so a template passed by mistake
+// is never stored.
+func transientBiometric(ctx contractapi.TransactionContextInterface) (string, error) {
+	transMap, err := ctx.GetStub().GetTransient()
+
+	if err != nil {
+		return "", fmt.Errorf("Error getting transient. %s", err.Error())
+	}
+
+	biometric, ok := transMap[biometricField]
+
+	if !ok || len(biometric) == 0 {
+		return "", newError(CodeInvalidArgument, nil, "%s not found in the transient map", biometricField)
+	}
+
+	hash := strings.ToLower(string(biometric))
+
+	if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+		return "", newError(CodeInvalidArgument, map[string]string{"field": biometricField}, "%s must be the hex encoded SHA-256 hash of the template", biometricField)
+	}
+
+	return hash, nil
+}
+
+// findBiometricBinding returns the biometric binding of a patient, nil if it has none
+func findBiometricBinding(ctx contractapi.TransactionContextInterface, collection string, key string) (*BiometricBinding, error) {
+	bindingAsBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from private data. %s", err.Error())
+	}
+
+	if bindingAsBytes == nil {
+		return nil, nil
+	}
+
+	binding := new(BiometricBinding)
+	_ = json.Unmarshal(bindingAsBytes, binding)
+
+	return binding, nil
+}
+
+// SetBiometricBinding binds a patient to the hash of a biometric template passed in the
+// "biometric" transient field, replacing its former binding. Only clinicians of the custodian
+// bind patients, and only the peers of the custodian must endorse it.
+func (s *SimpleContract) SetBiometricBinding(ctx contractapi.TransactionContextInterface, patientID string) error {
+	collection, key, err := clinicianCollection(ctx, patientID, biometricIndex, "biometric binding")
+
+	if err != nil {
+		return err
+	}
+
+	hash, err := transientBiometric(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	binding, err := findBiometricBinding(ctx, collection, key)
+
+	if err != nil {
+		return err
+	}
+
+	if binding == nil {
+		binding = &BiometricBinding{PatientID: patientID}
+		binding.Metadata, err = newMetadata(ctx)
+	} else {
+		err = binding.Metadata.touch(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	binding.Salt = ctx.GetStub().GetTxID()
+	binding.Hash = hashString(binding.Salt + hash)
+
+	bindingAsBytes, _ := json.Marshal(binding)
+
+	return ctx.GetStub().PutPrivateData(collection, key, bindingAsBytes)
+}
+
+// VerifyBiometricBinding tells whether the hash passed in the "biometric" transient field
+// matches the binding of a patient, confirming its identity at the point of care. Only
+// clinicians of the custodian verify, evaluating it on a peer of their org.
+func (s *SimpleContract) VerifyBiometricBinding(ctx contractapi.TransactionContextInterface, patientID string) (bool, error) {
+	collection, key, err := clinicianCollection(ctx, patientID, biometricIndex, "biometric binding")
+
+	if err != nil {
+		return false, err
+	}
+
+	hash, err := transientBiometric(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	binding, err := findBiometricBinding(ctx, collection, key)
+
+	if err != nil {
+		return false, err
+	}
+
+	if binding == nil {
+		return false, newError(CodeNotFound, map[string]string{"id": patientID}, "%s has no biometric binding", patientID)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hashString(binding.Salt+hash)), []byte(binding.Hash)) == 1, nil
+}
+
+// RemoveBiometricBinding removes the biometric binding of a patient
+func (s *SimpleContract) RemoveBiometricBinding(ctx contractapi.TransactionContextInterface, patientID string) error {
+	collection, key, err := clinicianCollection(ctx, patientID, biometricIndex, "biometric binding")
+
+	if err != nil {
+		return err
+	}
+
+	binding, err := findBiometricBinding(ctx, collection, key)
+
+	if err != nil {
+		return err
+	}
+
+	if binding == nil {
+		return newError(CodeNotFound, map[string]string{"id": patientID}, "%s has no biometric binding", patientID)
+	}
+
+	return ctx.GetStub().DelPrivateData(collection, key)
+}
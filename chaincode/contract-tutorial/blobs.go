@@ -0,0 +1,239 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// blobChunkIndex is the composite key namespace of the chunks of large values, by the hash of their content
+const blobChunkIndex = "blob~chunk"
+
+// blobPrefix starts the manifests stored in place of large values. No JSON record or index
+// entry starts with it.
+var blobPrefix = []byte("\x00blob:")
+
+// Default size above which a value is chunked, and size of its chunks
+const (
+	defaultBlobThreshold = 256 << 10
+	defaultBlobChunkSize = 64 << 10
+)
+
+// blobManifest lists the hashes of the chunks of a large value, in order
+type blobManifest struct {
+	Size   int      `json:"size"`
+	Chunks []string `json:"chunks"`
+}
+
+// blobStub stores the values above a threshold as chunks under the hash of their content,
+// which identical ciphertexts share, and a manifest under their key. Values are reassembled
+// on read, by key, range, query and history alike. Chunks are never deleted as other values
+// may share them.
+type blobStub struct {
+	shim.ChaincodeStubInterface
+	threshold int
+	chunkSize int
+}
+
+// newBlobStub wraps a stub, chunking the values above threshold into chunks of chunkSize bytes
+func newBlobStub(stub shim.ChaincodeStubInterface, threshold int, chunkSize int) *blobStub {
+	return &blobStub{ChaincodeStubInterface: stub, threshold: threshold, chunkSize: chunkSize}
+}
+
+// PutState writes a value, chunked if above the threshold
+func (b *blobStub) PutState(key string, value []byte) error {
+	if len(value) <= b.threshold {
+		return b.ChaincodeStubInterface.PutState(key, value)
+	}
+
+	manifest := blobManifest{Size: len(value), Chunks: []string{}}
+
+	for start := 0; start < len(value); start += b.chunkSize {
+		end := start + b.chunkSize
+
+		if end > len(value) {
+			end = len(value)
+		}
+
+		chunk := value[start:end]
+		hash := hashString(string(chunk))
+
+		chunkKey, err := b.CreateCompositeKey(blobChunkIndex, []string{hash})
+
+		if err != nil {
+			return err
+		}
+
+		if err := b.ChaincodeStubInterface.PutState(chunkKey, chunk); err != nil {
+			return err
+		}
+
+		manifest.Chunks = append(manifest.Chunks, hash)
+	}
+
+	manifestAsBytes, _ := json.Marshal(manifest)
+
+	return b.ChaincodeStubInterface.PutState(key, append(append([]byte{}, blobPrefix...), manifestAsBytes...))
+}
+
+// GetState reads a value, reassembled if chunked
+func (b *blobStub) GetState(key string) ([]byte, error) {
+	value, err := b.ChaincodeStubInterface.GetState(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return b.reassemble(key, value)
+}
+
+// reassemble returns the value a manifest stands for, other values as they are
+func (b *blobStub) reassemble(key string, value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, blobPrefix) {
+		return value, nil
+	}
+
+	manifest := new(blobManifest)
+
+	if err := json.Unmarshal(value[len(blobPrefix):], manifest); err != nil {
+		return nil, fmt.Errorf("Malformed blob manifest of %s. %s", key, err.Error())
+	}
+
+	reassembled := make([]byte, 0, manifest.Size)
+
+	for _, hash := range manifest.Chunks {
+		chunkKey, err := b.CreateCompositeKey(blobChunkIndex, []string{hash})
+
+		if err != nil {
+			return nil, err
+		}
+
+		chunk, err := b.ChaincodeStubInterface.GetState(chunkKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if chunk == nil || hashString(string(chunk)) != hash {
+			return nil, fmt.Errorf("Chunk %s of %s is missing or altered", hash, key)
+		}
+
+		reassembled = append(reassembled, chunk...)
+	}
+
+	return reassembled, nil
+}
+
+// blobIterator reassembles the values of a state iterator
+type blobIterator struct {
+	shim.StateQueryIteratorInterface
+	stub *blobStub
+}
+
+// Next returns the next record with its value reassembled
+func (it *blobIterator) Next() (*queryresult.KV, error) {
+	kv, err := it.StateQueryIteratorInterface.Next()
+
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := it.stub.reassemble(kv.Key, kv.Value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &queryresult.KV{Namespace: kv.Namespace, Key: kv.Key, Value: value}, nil
+}
+
+// blobHistoryIterator reassembles the values of a history iterator
+type blobHistoryIterator struct {
+	shim.HistoryQueryIteratorInterface
+	stub *blobStub
+	key  string
+}
+
+// Next returns the next modification with its value reassembled
+func (it *blobHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	modification, err := it.HistoryQueryIteratorInterface.Next()
+
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := it.stub.reassemble(it.key, modification.Value)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &queryresult.KeyModification{TxId: modification.TxId, Value: value, Timestamp: modification.Timestamp, IsDelete: modification.IsDelete}, nil
+}
+
+// wrap reassembles the values of a state iterator
+func (b *blobStub) wrap(it shim.StateQueryIteratorInterface, err error) (shim.StateQueryIteratorInterface, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &blobIterator{StateQueryIteratorInterface: it, stub: b}, nil
+}
+
+// wrapPage reassembles the values of a page of a state iterator
+func (b *blobStub) wrapPage(it shim.StateQueryIteratorInterface, metadata *peer.QueryResponseMetadata, err error) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &blobIterator{StateQueryIteratorInterface: it, stub: b}, metadata, nil
+}
+
+// GetStateByRange ...
+func (b *blobStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return b.wrap(b.ChaincodeStubInterface.GetStateByRange(startKey, endKey))
+}
+
+// GetStateByRangeWithPagination ...
+func (b *blobStub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	return b.wrapPage(b.ChaincodeStubInterface.GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark))
+}
+
+// GetStateByPartialCompositeKey ...
+func (b *blobStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return b.wrap(b.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys))
+}
+
+// GetStateByPartialCompositeKeyWithPagination ...
+func (b *blobStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	return b.wrapPage(b.ChaincodeStubInterface.GetStateByPartialCompositeKeyWithPagination(objectType, keys, pageSize, bookmark))
+}
+
+// GetQueryResult ...
+func (b *blobStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return b.wrap(b.ChaincodeStubInterface.GetQueryResult(query))
+}
+
+// GetQueryResultWithPagination ...
+func (b *blobStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	return b.wrapPage(b.ChaincodeStubInterface.GetQueryResultWithPagination(query, pageSize, bookmark))
+}
+
+// GetHistoryForKey ...
+func (b *blobStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	it, err := b.ChaincodeStubInterface.GetHistoryForKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &blobHistoryIterator{HistoryQueryIteratorInterface: it, stub: b, key: key}, nil
+}
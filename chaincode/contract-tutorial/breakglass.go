@@ -0,0 +1,223 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// emergencyAttribute is the client attribute of the identities allowed to break the glass
+const emergencyAttribute = "emergency"
+
+// breakGlassIndex is the composite key namespace of the emergency accesses to each patient.
+// No transaction updates or deletes its entries.
+const breakGlassIndex = "breakglass~log"
+
+// breakGlassWindow is how long after breaking the glass for a patient the caller reads it
+const breakGlassWindow = 15 * time.Minute
+
+// PriorityHigh is the priority of the events to be acted upon at once
+const PriorityHigh = "HIGH"
+
+// BreakGlassEntry records an emergency access to a patient and its justification
+type BreakGlassEntry struct {
+	PatientID     string             `json:"patientID"`
+	Justification string             `json:"justification"`
+	Accessed      TransactionDetails `json:"accessed"`
+}
+
+// EmergencyAccessEvent is the payload of EventEmergencyAccess
+type EmergencyAccessEvent struct {
+	EventHeader
+	PatientID    string `json:"patientID"`
+	CustodianMSP string `json:"custodianMSP"`
+	AccessorMSP  string `json:"accessorMSP"`
+	Priority     string `json:"priority"`
+}
+
+// BreakGlass records an emergency access to a patient without consent by a caller with the
+// emergency=true attribute. The access is logged with its justification, the custodian is
+// notified and an event is emitted. It must be submitted: only a committed access lets the
+// caller read the patient with EmergencyAccessPatient.
+func (s *SimpleContract) BreakGlass(ctx contractapi.TransactionContextInterface, patientID string, justification string) error {
+	if err := requireEmergencyAttribute(ctx); err != nil {
+		return err
+	}
+
+	if justification == "" {
+		return newError(CodeInvalidArgument, map[string]string{"id": patientID}, "An emergency access needs a justification")
+	}
+
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(breakGlassIndex, []string{patientID, details.TxID})
+
+	if err != nil {
+		return err
+	}
+
+	entryAsBytes, _ := json.Marshal(BreakGlassEntry{PatientID: patientID, Justification: justification, Accessed: details})
+
+	if err := ctx.GetStub().PutState(key, entryAsBytes); err != nil {
+		return err
+	}
+
+	// Emergencies are treatment, outside of any proposal
+	if err := logAccess(ctx, patientID, "", PurposeTreatment); err != nil {
+		return err
+	}
+
+	custodianMSP := patient.custodian()
+
+	if err := notify(ctx, custodianMSP, NotificationEmergencyAccess, patientID, fmt.Sprintf("%s accessed %s in an emergency: %s", details.MSPID, patientID, justification)); err != nil {
+		return err
+	}
+
+	event := &EmergencyAccessEvent{PatientID: patientID, CustodianMSP: custodianMSP, AccessorMSP: details.MSPID, Priority: PriorityHigh}
+
+	return emitEvent(ctx, EventEmergencyAccess, event)
+}
+
+// EmergencyAccessPatient returns a patient without consent to a caller with the emergency=true
+// attribute that broke the glass for it with BreakGlass in the last breakGlassWindow
+func (s *SimpleContract) EmergencyAccessPatient(ctx contractapi.TransactionContextInterface, patientID string) (*Patient, error) {
+	if err := requireEmergencyAttribute(ctx); err != nil {
+		return nil, err
+	}
+
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	broken, err := brokeGlass(ctx, patientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !broken {
+		return nil, newError(CodePermissionDenied, map[string]string{"id": patientID}, "No emergency access to %s was recorded by the caller in the last %s, submit BreakGlass first", patientID, breakGlassWindow)
+	}
+
+	return filterPatient(ctx, patient)
+}
+
+// requireEmergencyAttribute refuses callers without the emergency=true attribute
+func requireEmergencyAttribute(ctx contractapi.TransactionContextInterface) error {
+	if err := ctx.GetClientIdentity().AssertAttributeValue(emergencyAttribute, "true"); err != nil {
+		return newError(CodePermissionDenied, map[string]string{"attribute": emergencyAttribute}, "Caller can't access patients in an emergency. %s", err.Error())
+	}
+
+	return nil
+}
+
+// brokeGlass tells whether the caller recorded an emergency access to a patient in the last
+// breakGlassWindow
+func brokeGlass(ctx contractapi.TransactionContextInterface, patientID string) (bool, error) {
+	caller, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(breakGlassIndex, []string{patientID})
+
+	if err != nil {
+		return false, err
+	}
+
+	broken := false
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		entry := new(BreakGlassEntry)
+		_ = json.Unmarshal(queryResponse.Value, entry)
+
+		if entry.Accessed.ClientID != caller.ClientID || entry.Accessed.MSPID != caller.MSPID {
+			return nil
+		}
+
+		accessed, err := timeutil.Parse(entry.Accessed.Timestamp)
+
+		if err != nil || accessed.After(now) || now.Sub(accessed) > breakGlassWindow {
+			return nil
+		}
+
+		broken = true
+
+		return ledgeriter.ErrStop
+	})
+
+	if err != nil {
+		return false, err
+	}
+
+	return broken, nil
+}
+
+// BreakGlassPage is a page of break-glass log entries
+type BreakGlassPage struct {
+	Records   []BreakGlassEntry `json:"records"`
+	Bookmark  string            `json:"bookmark"`
+	Count     int32             `json:"count"`
+	Truncated bool              `json:"truncated"`
+}
+
+// GetBreakGlassLog returns a page of the emergency accesses to a patient, to its custodian only
+func (s *SimpleContract) GetBreakGlassLog(ctx contractapi.TransactionContextInterface, patientID string, pageSize int32, bookmark string) (*BreakGlassPage, error) {
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mspID != patient.custodian() {
+		return nil, newError(CodePermissionDenied, map[string]string{"id": patientID, "mspID": mspID}, "Only %s can read the break-glass log of %s", patient.custodian(), patientID)
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectTypedPage[BreakGlassEntry](queryContext(), indexPages(ctx, breakGlassIndex, []string{patientID}), size, bookmark)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &BreakGlassPage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
@@ -0,0 +1,142 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// CachingContext is the transaction context of the contract. It caches the
+// configuration, the key records and the sharding configuration for the length
+// of a transaction, as batches read them once per record, stores large values
+// in chunks through a blobStub, meters the patients executions read and numbers the
+// events journaled and the counter entries written.
+//
+// Nothing is cached across transactions: a value served without GetState would
+// be missing from the read set, so endorsements over stale state would still validate.
+type CachingContext struct {
+	contractapi.TransactionContext
+	cache   map[string][]byte
+	meter   *executionMeter
+	events  int
+	entries int
+}
+
+// SetStub sets the stub of the transaction, chunking the values above the default threshold
+func (c *CachingContext) SetStub(stub shim.ChaincodeStubInterface) {
+	c.TransactionContext.SetStub(newBlobStub(stub, defaultBlobThreshold, defaultBlobChunkSize))
+}
+
+// cachedState reads a key through the cache of the transaction, if the context has one
+func cachedState(ctx contractapi.TransactionContextInterface, key string) ([]byte, error) {
+	c, ok := ctx.(*CachingContext)
+
+	if !ok {
+		return ctx.GetStub().GetState(key)
+	}
+
+	if value, ok := c.cache[key]; ok {
+		return value, nil
+	}
+
+	value, err := c.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cache == nil {
+		c.cache = map[string][]byte{}
+	}
+
+	c.cache[key] = value
+
+	return value, nil
+}
+
+// putCachedState writes a key read through cachedState. The cached value is dropped
+// rather than replaced, so later reads see whatever the stub returns for the key.
+func putCachedState(ctx contractapi.TransactionContextInterface, key string, value []byte) error {
+	if c, ok := ctx.(*CachingContext); ok {
+		delete(c.cache, key)
+	}
+
+	return ctx.GetStub().PutState(key, value)
+}
+
+// entryCounter is a transaction context numbering the counter entries of its transaction
+type entryCounter interface {
+	nextEntry() int
+}
+
+// nextEntry returns the index of the next counter entry of the transaction
+func (c *CachingContext) nextEntry() int {
+	c.entries++
+
+	return c.entries - 1
+}
+
+// putCountEntry adds to a counter under an index in an entry of the transaction's own, keyed
+// by the attributes of the counter, the transaction ID and the index of the entry within the
+// transaction, so concurrent transactions never conflict over the counter and reads sum its
+// entries. Contexts that don't number their entries add to a single entry per transaction,
+// read back from the stub.
+func putCountEntry(ctx contractapi.TransactionContextInterface, index string, n int, attributes ...string) error {
+	counter, numbered := ctx.(entryCounter)
+	entry := 0
+
+	if numbered {
+		entry = counter.nextEntry()
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(index, append(attributes, ctx.GetStub().GetTxID(), fmt.Sprintf("%04d", entry)))
+
+	if err != nil {
+		return err
+	}
+
+	if !numbered {
+		countAsBytes, err := ctx.GetStub().GetState(key)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		count, _ := strconv.Atoi(string(countAsBytes))
+		n += count
+	}
+
+	return ctx.GetStub().PutState(key, []byte(strconv.Itoa(n)))
+}
+
+// sumCountEntries sums the entries of a counter under an index with their keys, all of them
+// as each is a few bytes. A count written at the key of the counter itself, as counters were
+// before they were split in entries, is summed too.
+func sumCountEntries(ctx contractapi.TransactionContextInterface, index string, attributes ...string) (int, []string, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, attributes)
+
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sum := 0
+	keys := []string{}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		n, _ := strconv.Atoi(string(queryResponse.Value))
+		sum += n
+		keys = append(keys, queryResponse.Key)
+
+		return nil
+	})
+
+	return sum, keys, err
+}
@@ -0,0 +1,89 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// channelsDescriptor is the deployment descriptor of the contract: the configuration each
+// channel it is deployed on starts with, by channel ID
+//
+//go:embed channels.json
+var channelsDescriptor []byte
+
+// channelConfigs are the configurations of the descriptor, kept as JSON so every read gets
+// its own copy
+var channelConfigs = map[string]json.RawMessage{}
+
+func init() {
+	if err := json.Unmarshal(channelsDescriptor, &channelConfigs); err != nil {
+		panic(fmt.Sprintf("channels.json is invalid: %s", err.Error()))
+	}
+
+	for channelID, configAsBytes := range channelConfigs {
+		if err := json.Unmarshal(configAsBytes, new(Config)); err != nil {
+			panic(fmt.Sprintf("the configuration of %s in channels.json is invalid: %s", channelID, err.Error()))
+		}
+	}
+}
+
+// channelDefaults returns the configuration a channel starts with, empty for the channels
+// the descriptor doesn't list
+func channelDefaults(channelID string) *Config {
+	config := new(Config)
+
+	if configAsBytes, ok := channelConfigs[channelID]; ok {
+		_ = json.Unmarshal(configAsBytes, config)
+	}
+
+	config.Metadata = Metadata{}
+
+	return config
+}
+
+// GetChannels returns the IDs of the channels the deployment descriptor configures, sorted
+func (s *SimpleContract) GetChannels(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	channelIDs := []string{}
+
+	for channelID := range channelConfigs {
+		channelIDs = append(channelIDs, channelID)
+	}
+
+	sort.Strings(channelIDs)
+
+	return channelIDs, nil
+}
+
+// GetChannelDefaults returns the configuration the deployment descriptor sets for the channel
+// of the transaction, which GetConfig returns until an admin changes it
+func (s *SimpleContract) GetChannelDefaults(ctx contractapi.TransactionContextInterface) (*Config, error) {
+	return channelDefaults(ctx.GetStub().GetChannelID()), nil
+}
+
+// ResetConfig drops the settings the admins made on the channel of the transaction, restoring
+// the configuration of the deployment descriptor, as after upgrading to a package with new
+// defaults
+func (s *SimpleContract) ResetConfig(ctx contractapi.TransactionContextInterface) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defaults := channelDefaults(ctx.GetStub().GetChannelID())
+	defaults.Metadata = config.Metadata
+
+	return putConfig(ctx, defaults)
+}
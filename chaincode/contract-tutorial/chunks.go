@@ -0,0 +1,314 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// executionCheckpointIndex is the composite key namespace of the partial executions of proposals
+const executionCheckpointIndex = "execution~checkpoint"
+
+// ExecutionCheckpoint is the partial execution of a proposal over the chunks of its cohort
+// processed so far. Sums holds the encrypted sums of the chunks in the order of the metrics
+// of the proposal, Skipped the patients of the chunks left out as incomplete, and Bookmark
+// the position of the next patient in the cohort.
+type ExecutionCheckpoint struct {
+	ProposalID string             `json:"proposalID"`
+	Modulo     string             `json:"modulo"`
+	CohortHash string             `json:"cohortHash"`
+	Sums       []string           `json:"sums"`
+	Processed  int                `json:"processed"`
+	Skipped    []SkippedPatient   `json:"skipped,omitempty" metadata:",optional"`
+	Bookmark   string             `json:"bookmark"`
+	Remaining  bool               `json:"remaining"`
+	Updated    TransactionDetails `json:"updated"`
+}
+
+// executionCheckpointKey returns the key of the checkpoint of a proposal
+func executionCheckpointKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(executionCheckpointIndex, []string{id})
+}
+
+// findExecutionCheckpoint returns the checkpoint of a proposal, nil if its execution hasn't started
+func findExecutionCheckpoint(ctx contractapi.TransactionContextInterface, id string) (*ExecutionCheckpoint, error) {
+	key, err := executionCheckpointKey(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if checkpointAsBytes == nil {
+		return nil, nil
+	}
+
+	checkpoint := new(ExecutionCheckpoint)
+	_ = json.Unmarshal(checkpointAsBytes, checkpoint)
+
+	return checkpoint, nil
+}
+
+// chunkedMetrics returns the metrics a proposal averages, refusing proposals that can't be
+// executed in chunks
+func chunkedMetrics(id string, proposal *Proposal) ([]string, error) {
+	if proposal.Operation != OperationMean || len(proposal.ResultIDs) > 0 || proposal.MultiKey {
+		return nil, newError(CodeInvalidArgument, map[string]string{"id": id, "operation": proposal.Operation}, "Only means over a cohort are executed in chunks, execute %s with ExecuteProposal", id)
+	}
+
+	if len(proposal.Metrics) == 0 {
+		return []string{""}, nil
+	}
+
+	return proposal.Metrics, nil
+}
+
+// ExecuteProposalChunk sums the encrypted values of a page of the cohort of an approved mean
+// proposal into its checkpoint, so cohorts too large for a transaction are executed over
+// several, and returns the checkpoint. Once no patient remains, FinalizeProposal completes
+// the execution. A cohort changed since the last chunk, by a withdrawal, starts over.
+func (s *SimpleContract) ExecuteProposalChunk(ctx contractapi.TransactionContextInterface, id string, modulo string, pageSize int32) (*ExecutionCheckpoint, error) {
+	proposal, err := s.findExecutableProposal(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	metrics, err := chunkedMetrics(id, proposal)
+
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := executionLimits(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A chunk is a cohort of its own, bounded as one
+	if int(size) > limits.MaxCohort {
+		size = int32(limits.MaxCohort)
+	}
+
+	checkpoint, err := findExecutionCheckpoint(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pids := strings.Split(proposal.PatientsIDs, ",")
+	cohortHash := hashString(proposal.PatientsIDs)
+
+	if checkpoint == nil || checkpoint.CohortHash != cohortHash {
+		if err := checkQuota(ctx, id, proposal, false); err != nil {
+			return nil, err
+		}
+
+		checkpoint = &ExecutionCheckpoint{ProposalID: id, Modulo: q.String(), CohortHash: cohortHash, Sums: make([]string, len(metrics))}
+
+		for i := range checkpoint.Sums {
+			checkpoint.Sums[i] = phewrap.Zero().String()
+		}
+	}
+
+	if checkpoint.Modulo != q.String() {
+		return nil, newError(CodeInvalidArgument, map[string]string{"id": id, "modulo": modulo}, "The execution of %s started with modulo %s", id, checkpoint.Modulo)
+	}
+
+	if checkpoint.Processed == len(pids) {
+		return nil, newError(CodeInvalidState, map[string]string{"id": id}, "Every patient of %s has been processed, finalize it with FinalizeProposal", id)
+	}
+
+	end := checkpoint.Processed + int(size)
+
+	if end > len(pids) {
+		end = len(pids)
+	}
+
+	chunk, skipped, err := s.skipIncomplete(ctx, proposal, pids[checkpoint.Processed:end])
+
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint.Skipped = append(checkpoint.Skipped, skipped...)
+
+	if err := s.authorizeCohort(ctx, id, proposal, chunk); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkCohortProofs(ctx, proposal, chunk); err != nil {
+		return nil, err
+	}
+
+	for i, metric := range metrics {
+		ms, err := s.cohortValues(ctx, chunk, metric, proposal.AsOf)
+
+		if err != nil {
+			return nil, err
+		}
+
+		cs, err := parseCohort(chunk, ms)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sum, err := parseCiphertext(id, checkpoint.Sums[i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		checkpoint.Sums[i] = q.Add(append([]phewrap.Ciphertext{sum}, cs...)...).String()
+	}
+
+	checkpoint.Processed = end
+	checkpoint.Bookmark = ""
+	checkpoint.Remaining = end < len(pids)
+
+	if checkpoint.Remaining {
+		checkpoint.Bookmark = strconv.Itoa(end)
+	}
+
+	if checkpoint.Updated, err = newTransactionDetails(ctx); err != nil {
+		return nil, err
+	}
+
+	key, err := executionCheckpointKey(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	checkpointAsBytes, _ := json.Marshal(checkpoint)
+
+	return checkpoint, ctx.GetStub().PutState(key, checkpointAsBytes)
+}
+
+// FindExecutionCheckpoint returns the partial execution of a proposal executed in chunks
+func (s *SimpleContract) FindExecutionCheckpoint(ctx contractapi.TransactionContextInterface, id string) (*ExecutionCheckpoint, error) {
+	checkpoint, err := findExecutionCheckpoint(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if checkpoint == nil {
+		return nil, newError(CodeNotFound, map[string]string{"id": id}, "%s is not being executed in chunks", id)
+	}
+
+	return checkpoint, nil
+}
+
+// FinalizeProposal divides the sums of a proposal executed in chunks by the number of patients
+// of its cohort that contributed, the same means ExecuteProposal computes, and completes its
+// execution
+func (s *SimpleContract) FinalizeProposal(ctx contractapi.TransactionContextInterface, id string) error {
+	proposal, err := s.findExecutableProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	metrics, err := chunkedMetrics(id, proposal)
+
+	if err != nil {
+		return err
+	}
+
+	checkpoint, err := s.FindExecutionCheckpoint(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	pids := strings.Split(proposal.PatientsIDs, ",")
+
+	if checkpoint.CohortHash != hashString(proposal.PatientsIDs) {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "The cohort of %s changed, execute it again from the first chunk", id)
+	}
+
+	if checkpoint.Processed < len(pids) {
+		return newError(CodeInvalidState, map[string]string{"id": id, "processed": fmt.Sprint(checkpoint.Processed)}, "%d patients of %s remain to be processed", len(pids)-checkpoint.Processed, id)
+	}
+
+	proposal.Skipped = append(proposal.Skipped, checkpoint.Skipped...)
+	contributing := proposal.aggregatedPatients()
+
+	if len(contributing) == 0 {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "Every patient of %s was skipped as incomplete", id)
+	}
+
+	q, err := parseModulus(checkpoint.Modulo)
+
+	if err != nil {
+		return err
+	}
+
+	values := make([]string, len(metrics))
+
+	for i := range metrics {
+		sum, err := parseCiphertext(id, checkpoint.Sums[i])
+
+		if err != nil {
+			return err
+		}
+
+		m, err := q.Divide(sum, int64(len(contributing)))
+
+		if err != nil {
+			return newError(CodeInvalidArgument, map[string]string{"modulo": q.String()}, "Can't average the cohort. %s", err.Error())
+		}
+
+		values[i] = m.String()
+	}
+
+	if len(proposal.Metrics) == 0 {
+		proposal.Value = values[0]
+	} else {
+		proposal.Values = map[string]string{}
+
+		for i, metric := range metrics {
+			proposal.Values[metric] = values[i]
+		}
+	}
+
+	key, err := executionCheckpointKey(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return err
+	}
+
+	return completeExecution(ctx, id, proposal, contributing, checkpoint.Modulo)
+}
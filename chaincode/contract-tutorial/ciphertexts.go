@@ -0,0 +1,97 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// parseModulus parses the modulo argument of a transaction
+func parseModulus(modulo string) (phewrap.Modulus, error) {
+	q, err := phewrap.ParseModulus(modulo)
+
+	if err != nil {
+		return phewrap.Modulus{}, newError(CodeInvalidArgument, map[string]string{"modulo": modulo}, "Modulo %s is not an integer", modulo)
+	}
+
+	return q, nil
+}
+
+// parseCiphertext parses an encrypted value of the record with an ID, stored or submitted, in
+// any encoding
+func parseCiphertext(id string, value string) (phewrap.Ciphertext, error) {
+	c, err := phewrap.ParseCiphertext(value)
+
+	if err != nil {
+		return phewrap.Ciphertext{}, newError(CodeInvalidArgument, map[string]string{"id": id}, "The value of %s is %s", id, err.Error())
+	}
+
+	return c, nil
+}
+
+// parseCohort parses the encrypted values of the patients of a cohort, in the same order
+func parseCohort(pids []string, ms []string) ([]phewrap.Ciphertext, error) {
+	cs := make([]phewrap.Ciphertext, len(ms))
+
+	for i, m := range ms {
+		c, err := parseCiphertext(pids[i], m)
+
+		if err != nil {
+			return nil, err
+		}
+
+		cs[i] = c
+	}
+
+	return cs, nil
+}
+
+// parseToken parses the token of a key update
+func parseToken(firstToken string, secondToken string) (phewrap.Token, error) {
+	t, err := phewrap.ParseToken(firstToken, secondToken)
+
+	if err != nil {
+		return phewrap.Token{}, newError(CodeInvalidArgument, nil, "The %s", err.Error())
+	}
+
+	return t, nil
+}
+
+// mean averages the encrypted values of a cohort
+func mean(q phewrap.Modulus, pids []string, ms []string) (string, error) {
+	cs, err := parseCohort(pids, ms)
+
+	if err != nil {
+		return "", err
+	}
+
+	m, err := q.Mean(cs)
+
+	if err != nil {
+		return "", newError(CodeInvalidArgument, map[string]string{"modulo": q.String()}, "Can't average the cohort. %s", err.Error())
+	}
+
+	return m.String(), nil
+}
+
+// ConvertCiphertext rewrites a ciphertext in an encoding: dec, the format of phe, or hex and
+// b64u, which are shorter for large moduli. Clients that can't parse an encoding convert the
+// values they read with it.
+func (s *SimpleContract) ConvertCiphertext(ctx contractapi.TransactionContextInterface, value string, encoding string) (string, error) {
+	e, err := phewrap.ParseEncoding(encoding)
+
+	if err != nil {
+		return "", newError(CodeInvalidArgument, map[string]string{"encoding": encoding}, "The %s", err.Error())
+	}
+
+	c, err := parseCiphertext("value", value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return c.Encode(e), nil
+}
@@ -0,0 +1,184 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// citationIndex is the composite key namespace of the citation tokens, by token then result
+const citationIndex = "citation~result"
+
+// citationTokenLength is the number of hex digits of a citation token, short enough to print
+const citationTokenLength = 20
+
+// Citation binds a citation token to the result it was generated for. The token is the
+// truncated SHA-256 hash of the manifest of the result, the channel and the transaction
+// that created the result, which locates its block.
+type Citation struct {
+	Token          string             `json:"token"`
+	ResultID       string             `json:"resultID"`
+	Operation      string             `json:"operation"`
+	Metrics        []string           `json:"metrics"`
+	CohortSizeBand string             `json:"cohortSizeBand"`
+	ManifestHash   string             `json:"manifestHash"`
+	ChannelID      string             `json:"channelID"`
+	TxID           string             `json:"txID"`
+	Timestamp      string             `json:"timestamp"`
+	Generated      TransactionDetails `json:"generated"`
+}
+
+// citedResult returns the manifest of a result or result set, the transaction that created it
+// and the proposal it answers
+func (s *SimpleContract) citedResult(ctx contractapi.TransactionContextInterface, resultID string) (Manifest, TransactionDetails, string, error) {
+	if strings.HasPrefix(resultID, "RESULTSET") {
+		resultSet, err := s.FindResultSet(ctx, resultID)
+
+		if err != nil {
+			return Manifest{}, TransactionDetails{}, "", err
+		}
+
+		return resultSet.Manifest, resultSet.Metadata.Created, resultSet.ProposalID, nil
+	}
+
+	result, err := s.FindResult(ctx, resultID)
+
+	if err != nil {
+		return Manifest{}, TransactionDetails{}, "", err
+	}
+
+	return result.Manifest, result.Metadata.Created, result.ProposalID, nil
+}
+
+// citationToken returns the token of a result from its manifest and the transaction that created it
+func citationToken(resultID string, manifest Manifest, channelID string, created TransactionDetails) (string, string) {
+	manifestAsBytes, _ := json.Marshal(manifest)
+	manifestHash := hashString(string(manifestAsBytes))
+
+	token := hashString(fmt.Sprintf("%s\x00%s\x00%s\x00%s", resultID, manifestHash, channelID, created.TxID))
+
+	return token[:citationTokenLength], manifestHash
+}
+
+// findCitation returns the citation of a token, nil if none was generated
+func findCitation(ctx contractapi.TransactionContextInterface, token string) (*Citation, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(citationIndex, []string{token})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var citation *Citation
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 1, func(queryResponse *queryresult.KV) error {
+		citation = new(Citation)
+		_ = json.Unmarshal(queryResponse.Value, citation)
+
+		return ledgeriter.ErrStop
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	return citation, nil
+}
+
+// GenerateCitationToken returns a short token the requester of a result prints in papers
+// citing it, so readers verify the statistic with VerifyCitationToken. Generating it again
+// returns the same token.
+func (s *SimpleContract) GenerateCitationToken(ctx contractapi.TransactionContextInterface, resultID string) (string, error) {
+	manifest, created, proposalID, err := s.citedResult(ctx, resultID)
+
+	if err != nil {
+		return "", err
+	}
+
+	proposal, err := s.FindProposal(ctx, proposalID)
+
+	if err != nil {
+		return "", err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	if mspID != proposal.RequesterID {
+		return "", newError(CodePermissionDenied, map[string]string{"id": resultID, "mspID": mspID}, "Only %s can cite %s", proposal.RequesterID, resultID)
+	}
+
+	token, manifestHash := citationToken(resultID, manifest, ctx.GetStub().GetChannelID(), created)
+
+	existing, err := findCitation(ctx, token)
+
+	if err != nil || existing != nil {
+		return token, err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	citation := Citation{
+		Token:          token,
+		ResultID:       resultID,
+		Operation:      manifest.Operation,
+		Metrics:        manifest.Metrics,
+		CohortSizeBand: cohortSizeBand(manifest.CohortSize),
+		ManifestHash:   manifestHash,
+		ChannelID:      ctx.GetStub().GetChannelID(),
+		TxID:           created.TxID,
+		Timestamp:      created.Timestamp,
+		Generated:      details,
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(citationIndex, []string{token, resultID})
+
+	if err != nil {
+		return "", err
+	}
+
+	citationAsBytes, _ := json.Marshal(citation)
+
+	return token, ctx.GetStub().PutState(key, citationAsBytes)
+}
+
+// VerifyCitationToken returns the computation a citation token was generated for, after
+// checking the token against the manifest of the result on the ledger. Anyone can call it,
+// and the citation tells neither the patients nor the orgs of the study.
+func (s *SimpleContract) VerifyCitationToken(ctx contractapi.TransactionContextInterface, token string) (*Citation, error) {
+	citation, err := findCitation(ctx, token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if citation == nil {
+		return nil, newError(CodeNotFound, map[string]string{"token": token}, "No result is cited as %s", token)
+	}
+
+	manifest, created, _, err := s.citedResult(ctx, citation.ResultID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if expected, _ := citationToken(citation.ResultID, manifest, citation.ChannelID, created); expected != token {
+		return nil, newError(CodeInvalidState, map[string]string{"token": token, "resultID": citation.ResultID}, "%s no longer matches %s", citation.ResultID, token)
+	}
+
+	return citation, nil
+}
@@ -0,0 +1,146 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Command datagen generates synthetic patients with phe encrypted values for
+// load testing the aggregation path of the contract.
+//
+// The patients are printed either as a JSON batch, ready to be passed to
+// CreatePatientsBatch, or as a script of peer invoke commands:
+//
+//	datagen -n 1000 -key key.json -format json > patients.json
+//	datagen -n 1000 -key key.json -format script > patients.sh
+//
+// When -key is not given a new key is generated and written to -keyout so the
+// aggregates computed over the generated patients can be decrypted later.
+// The plaintext values and names only depend on -seed, which makes the load
+// reproducible.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"os"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/keyfile"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hanesbarbosa/phe"
+)
+
+// Patient is an element of the CreatePatientsBatch argument
+type Patient struct {
+	ID                    string `json:"id"`
+	Name                  string `json:"name"`
+	PreExistingConditions string `json:"preExistingConditions"`
+	DiagnosisID           string `json:"diagnosisID"`
+	StatusID              string `json:"statusID"`
+	KeyID                 string `json:"keyID"`
+}
+
+// invocation is the -c argument of a peer chaincode invoke command
+type invocation struct {
+	Function string   `json:"function"`
+	Args     []string `json:"Args"`
+}
+
+func main() {
+	n := flag.Int("n", 100, "number of patients")
+	start := flag.Int("start", 0, "number of the first patient")
+	prefix := flag.String("prefix", "PATIENT", "prefix of the patient IDs")
+	keyPath := flag.String("key", "", "JSON key file, a new key is generated when empty")
+	keyOut := flag.String("keyout", "key.json", "file the generated key is written to")
+	length := flag.Int64("l", 2048, "length of a generated key")
+	keyID := flag.String("keyid", "KEY0", "key ID recorded on the patients")
+	min := flag.Int64("min", 0, "minimum plaintext value")
+	max := flag.Int64("max", 100, "maximum plaintext value")
+	diagnoses := flag.Int("diagnoses", 10, "number of distinct diagnosis IDs")
+	seed := flag.Int64("seed", 1, "seed of the plaintext values")
+	format := flag.String("format", "json", "output format, json or script")
+	channel := flag.String("channel", "mychannel", "channel used by the script")
+	chaincode := flag.String("chaincode", "contract-tutorial", "chaincode used by the script")
+	flag.Parse()
+
+	if *max < *min {
+		fail(fmt.Errorf("max must not be lower than min"))
+	}
+
+	if *format != "json" && *format != "script" {
+		fail(fmt.Errorf("unknown format %s", *format))
+	}
+
+	sk, pk, err := loadOrGenerateKey(*keyPath, *keyOut, *length)
+
+	if err != nil {
+		fail(err)
+	}
+
+	r := rand.New(rand.NewSource(*seed))
+	patients := []Patient{}
+
+	for i := *start; i < *start+*n; i++ {
+		m := big.NewInt(*min + r.Int63n(*max-*min+1))
+
+		patients = append(patients, Patient{
+			ID:                    fmt.Sprintf("%s%d", *prefix, i),
+			Name:                  fmt.Sprintf("Patient %d", i),
+			PreExistingConditions: phewrap.Encrypt(sk, pk, m).String(),
+			DiagnosisID:           fmt.Sprintf("DIAGNOSIS%d", r.Intn(*diagnoses)),
+			StatusID:              "STATUS0",
+			KeyID:                 *keyID,
+		})
+	}
+
+	if *format == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(patients)
+	} else {
+		err = writeScript(patients, *channel, *chaincode)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+// loadOrGenerateKey reads the key at path or generates and saves a new one
+func loadOrGenerateKey(path string, out string, length int64) (*phe.SecretKey, *phe.PublicKey, error) {
+	if path != "" {
+		return keyfile.Load(path)
+	}
+
+	sk, pk := phe.GenerateKeys(length)
+
+	if err := keyfile.Save(out, sk, pk); err != nil {
+		return nil, nil, err
+	}
+
+	return sk, pk, nil
+}
+
+// writeScript prints one peer chaincode invoke command per patient
+func writeScript(patients []Patient, channel string, chaincode string) error {
+	fmt.Println("#!/bin/bash")
+	fmt.Println("set -e")
+
+	for _, p := range patients {
+		c, err := json.Marshal(invocation{
+			Function: "v2:CreatePatient",
+			Args:     []string{p.ID, p.Name, p.PreExistingConditions, p.DiagnosisID, p.StatusID, p.KeyID},
+		})
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("peer chaincode invoke -C %s -n %s -c '%s' \"$@\"\n", channel, chaincode, c)
+	}
+
+	return nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "datagen:", err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,62 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Command metadocs writes the docs the contract serves with its metadata, the parameter
+// names and doc comments of its transactions and the doc comments of its types, read from
+// its source. Each argument maps the type of a contract to its name:
+//
+//	metadocs -dir . -out contract-docs.json SimpleContract=v2 LegacyContract=v1
+//
+// It is run by go generate in the directory of the contract, after any change to the
+// signature or the doc comment of a transaction or a type.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/metadocs"
+)
+
+func main() {
+	dir := flag.String("dir", ".", "directory of the contract")
+	out := flag.String("out", "contract-docs.json", "file the docs are written to")
+	flag.Parse()
+
+	receivers := map[string]string{}
+
+	for _, arg := range flag.Args() {
+		receiver, contract, ok := strings.Cut(arg, "=")
+
+		if !ok || receiver == "" || contract == "" {
+			fail(fmt.Errorf("%s is not <type>=<contract>", arg))
+		}
+
+		receivers[receiver] = contract
+	}
+
+	if len(receivers) == 0 {
+		fail(fmt.Errorf("no contract given"))
+	}
+
+	docs, err := metadocs.Parse(*dir, receivers)
+
+	if err != nil {
+		fail(err)
+	}
+
+	docsAsBytes, _ := json.MarshalIndent(docs, "", "  ")
+
+	if err := os.WriteFile(*out, append(docsAsBytes, '\n'), 0644); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "metadocs:", err.Error())
+	os.Exit(1)
+}
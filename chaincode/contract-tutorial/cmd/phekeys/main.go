@@ -0,0 +1,214 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Command phekeys manages the phe keys of a client and prepares the arguments of the
+// contract in the exact format it expects:
+//
+//	phekeys generate -l 2048 -out key.json
+//	phekeys modulus -key key.json
+//	phekeys encrypt -key key.json [-encoding hex] 37 42
+//	phekeys decrypt -key key.json <ciphertext>...
+//	phekeys token -from key.json -to newkey.json
+//
+// generate writes a new key pair to a file only its owner can read and prints its modulus,
+// the modulo of RegisterKey, ExecuteProposal and CreateResult. encrypt prints a ciphertext
+// per value, ready for CreatePatient or SetPatientMeasurement, and decrypt the fraction each
+// ciphertext holds, as an integer when it is one. token prints the first then the second
+// token moving ciphertexts from one key to the other, the tokens of CreateResult and of
+// RekeyResults after RotateKey. Both keys must have the same length, so the same modulus.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/keyfile"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hanesbarbosa/phe"
+)
+
+// commands are the subcommands of phekeys by name
+var commands = map[string]func(args []string) error{
+	"generate": generate,
+	"modulus":  modulus,
+	"encrypt":  encrypt,
+	"decrypt":  decrypt,
+	"token":    token,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	command, ok := commands[os.Args[1]]
+
+	if !ok {
+		usage()
+	}
+
+	if err := command(os.Args[2:]); err != nil {
+		fail(err)
+	}
+}
+
+// generate writes a new key pair and prints its modulus
+func generate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	length := flags.Int64("l", 2048, "length of the key")
+	out := flags.String("out", "key.json", "file the key is written to")
+	_ = flags.Parse(args)
+
+	if _, err := os.Stat(*out); err == nil {
+		return fmt.Errorf("%s already exists, a key is never overwritten", *out)
+	}
+
+	sk, pk := phe.GenerateKeys(*length)
+
+	if err := keyfile.Save(*out, sk, pk); err != nil {
+		return err
+	}
+
+	fmt.Println(pk.Q.String())
+
+	return nil
+}
+
+// modulus prints the modulus of a key
+func modulus(args []string) error {
+	flags := flag.NewFlagSet("modulus", flag.ExitOnError)
+	keyPath := flags.String("key", "key.json", "key file")
+	_ = flags.Parse(args)
+
+	_, pk, err := keyfile.Load(*keyPath)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(pk.Q.String())
+
+	return nil
+}
+
+// encrypt prints the ciphertext of each value
+func encrypt(args []string) error {
+	flags := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	keyPath := flags.String("key", "key.json", "key file")
+	encoding := flags.String("encoding", string(phewrap.EncodingDecimal), "encoding of the ciphertexts, dec, hex or b64u")
+	_ = flags.Parse(args)
+
+	e, err := phewrap.ParseEncoding(*encoding)
+
+	if err != nil {
+		return err
+	}
+
+	sk, pk, err := keyfile.Load(*keyPath)
+
+	if err != nil {
+		return err
+	}
+
+	values := []*big.Int{}
+
+	// Every value is checked before any is printed, so a typo doesn't leave a partial output
+	for _, arg := range flags.Args() {
+		value, ok := new(big.Int).SetString(arg, 10)
+
+		if !ok || value.Sign() < 0 {
+			return fmt.Errorf("%s is not a non-negative integer, phe doesn't decrypt negative values", arg)
+		}
+
+		values = append(values, value)
+	}
+
+	for _, value := range values {
+		fmt.Println(phewrap.Encrypt(sk, pk, value).Encode(e))
+	}
+
+	return nil
+}
+
+// decrypt prints the value of each ciphertext
+func decrypt(args []string) error {
+	flags := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keyPath := flags.String("key", "key.json", "key file")
+	_ = flags.Parse(args)
+
+	sk, pk, err := keyfile.Load(*keyPath)
+
+	if err != nil {
+		return err
+	}
+
+	cs, err := phewrap.ParseCiphertexts(flags.Args())
+
+	if err != nil {
+		return err
+	}
+
+	q, _ := phewrap.ParseModulus(pk.Q.String())
+
+	for i, c := range cs {
+		if err := q.Validate(c); err != nil {
+			return fmt.Errorf("ciphertext %d is not under %s. %s", i, *keyPath, err.Error())
+		}
+	}
+
+	for _, c := range cs {
+		fmt.Println(phewrap.Decrypt(sk, pk, c).RatString())
+	}
+
+	return nil
+}
+
+// token prints the two tokens moving ciphertexts from one key to another
+func token(args []string) error {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	fromPath := flags.String("from", "key.json", "key file of the ciphertexts")
+	toPath := flags.String("to", "", "key file the ciphertexts are moved to")
+	_ = flags.Parse(args)
+
+	if *toPath == "" {
+		return fmt.Errorf("-to is required")
+	}
+
+	fromSK, fromPK, err := keyfile.Load(*fromPath)
+
+	if err != nil {
+		return err
+	}
+
+	toSK, toPK, err := keyfile.Load(*toPath)
+
+	if err != nil {
+		return err
+	}
+
+	t, err := phewrap.NewKeys(fromSK, fromPK).TokenTo(phewrap.NewKeys(toSK, toPK))
+
+	if err != nil {
+		return err
+	}
+
+	first, second := t.Strings()
+
+	fmt.Println(first)
+	fmt.Println(second)
+
+	return nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: phekeys generate|modulus|encrypt|decrypt|token [flags] [args]")
+	os.Exit(2)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "phekeys:", err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,287 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Record types of the compaction report beyond the entities with an ID format
+const (
+	EntityResult             = "RESULT"
+	EntityResultSet          = "RESULT_SET"
+	EntityConsentReceipt     = "CONSENT_RECEIPT"
+	EntityComputationReceipt = "COMPUTATION_RECEIPT"
+	EntityConfig             = "CONFIG"
+	EntityEvent              = "EVENT"
+	EntityOther              = "OTHER"
+)
+
+// indexes are the composite key namespaces of the contract
+var indexes = []string{
+	patientOrgIndex, patientShardIndex, patientLockIndex, proposalVersionIndex, consentPatientIndex,
+	consentDecisionIndex, accessLogIndex, breakGlassIndex, notificationIndex, publicationIndex,
+	mspRootIndex, ageBucketIndex, ageCounterIndex, delegationIndex, decryptionIndex, withdrawalIndex,
+	proposalContentIndex, agreementIndex, quotaUsageIndex, resultKeyIndex, rekeyProgressIndex,
+	usageIndex, consentExpiryIndex, executionCheckpointIndex, proposalOrgIndex, resultOrgIndex,
+	keyOwnerIndex, blobChunkIndex, requestIndex, requestExpiryIndex, guardianIndex, auditRecordIndex,
+	auditPeriodIndex, auditAssignmentIndex, quarantineIndex, emergencyUseIndex, citationIndex,
+	proposalDueIndex, diagnosisNodeIndex, diagnosisParentIndex, privacyBudgetIndex,
+	deletionProofIndex, minimizationIndex, measurementIndex, consentReceiptIndex, keyUsageIndex,
+	notificationAgeIndex,
+}
+
+// StateUsage is the number of records of a type or index and the bytes of their keys and values
+type StateUsage struct {
+	Name    string `json:"name"`
+	Records int    `json:"records"`
+	Bytes   int    `json:"bytes"`
+}
+
+// OrphanedIndexEntry is an index entry referencing a record that no longer exists
+type OrphanedIndexEntry struct {
+	Index      string   `json:"index"`
+	Attributes []string `json:"attributes"`
+	MissingID  string   `json:"missingID"`
+}
+
+// CompactionReport describes the state of the contract for planning retention runs.
+// Superseded results are encrypted under rotated keys, and purgeable proposals
+// expired before being executed.
+type CompactionReport struct {
+	Entities             []StateUsage         `json:"entities"`
+	Indexes              []StateUsage         `json:"indexes"`
+	OrphanedIndexEntries []OrphanedIndexEntry `json:"orphanedIndexEntries"`
+	SupersededResults    []string             `json:"supersededResults"`
+	PurgeableProposals   []string             `json:"purgeableProposals"`
+	Generated            TransactionDetails   `json:"generated"`
+}
+
+// recordType tells the type of a record by its key or the fields of its JSON, as IDs are free-form
+func recordType(key string, value []byte) string {
+	switch {
+	case key == configKey || key == shardConfigKey:
+		return EntityConfig
+	case key == eventSequenceKey || strings.HasPrefix(key, eventJournalPrefix):
+		return EntityEvent
+	}
+
+	fields := map[string]json.RawMessage{}
+
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return EntityOther
+	}
+
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := fields[name]; !ok {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	switch {
+	case has("preExistingConditions"):
+		return EntityPatient
+	case has("requesterID", "patientsIDs"):
+		return EntityProposal
+	case has("outputHash"):
+		return EntityComputationReceipt
+	case has("values", "manifest"):
+		return EntityResultSet
+	case has("manifest"):
+		return EntityResult
+	case has("consentID", "termsHash"):
+		return EntityConsentReceipt
+	case has("granteeMSP", "termsHash"):
+		return EntityConsent
+	case has("ownerMSP", "modulo"):
+		return EntityKey
+	case has("irbApprovalNumber"):
+		return EntityProtocol
+	}
+
+	return EntityOther
+}
+
+// referencedIDs returns the records an index entry points to, by the position of their ID
+var referencedIDs = map[string][]int{
+	patientOrgIndex:      {1},
+	patientShardIndex:    {1},
+	patientLockIndex:     {0, 1},
+	consentPatientIndex:  {0, 2},
+	consentReceiptIndex:  {0},
+	ageBucketIndex:       {0},
+	decryptionIndex:      {0},
+	withdrawalIndex:      {0},
+	proposalContentIndex: {1},
+	resultKeyIndex:       {1},
+	consentExpiryIndex:   {1},
+	proposalOrgIndex:     {2},
+	resultOrgIndex:       {1},
+	keyOwnerIndex:        {1},
+	keyUsageIndex:        {0},
+	guardianIndex:        {0},
+	auditRecordIndex:     {2},
+	emergencyUseIndex:    {0, 2},
+	auditAssignmentIndex: {1},
+	citationIndex:        {1},
+	proposalDueIndex:     {1},
+	privacyBudgetIndex:   {0},
+	minimizationIndex:    {0},
+}
+
+// usage sorts the usage of every name
+func usage(byName map[string]*StateUsage) []StateUsage {
+	results := []StateUsage{}
+
+	for _, u := range byName {
+		results = append(results, *u)
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	return results
+}
+
+// GetCompactionReport reports the records of the world state by type and index with their sizes,
+// the index entries whose records are gone, the superseded results and the proposals that can be
+// purged. It reads the whole state, so admins should evaluate it rather than submit it.
+func (s *SimpleContract) GetCompactionReport(ctx contractapi.TransactionContextInterface) (*CompactionReport, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.compactionReport(ctx)
+}
+
+// compactionReport compiles the report of GetCompactionReport, whoever the caller
+func (s *SimpleContract) compactionReport(ctx contractapi.TransactionContextInterface) (*CompactionReport, error) {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CompactionReport{OrphanedIndexEntries: []OrphanedIndexEntry{}, SupersededResults: []string{}, PurgeableProposals: []string{}}
+	entities := map[string]*StateUsage{}
+	rotated := map[string]bool{}
+	results := map[string]string{}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The state is walked whole, a report has no records to return beyond its counts
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		entity := recordType(queryResponse.Key, queryResponse.Value)
+
+		if entities[entity] == nil {
+			entities[entity] = &StateUsage{Name: entity}
+		}
+
+		entities[entity].Records++
+		entities[entity].Bytes += len(queryResponse.Key) + len(queryResponse.Value)
+
+		switch entity {
+		case EntityKey:
+			key := new(KeyRecord)
+			_ = json.Unmarshal(queryResponse.Value, key)
+			rotated[queryResponse.Key] = key.Status == KeyRotated
+		case EntityResult, EntityResultSet:
+			result := struct {
+				KeyID string `json:"keyID"`
+			}{}
+			_ = json.Unmarshal(queryResponse.Value, &result)
+			results[queryResponse.Key] = result.KeyID
+		case EntityProposal:
+			proposal := new(Proposal)
+			_ = json.Unmarshal(queryResponse.Value, proposal)
+
+			if proposal.Status == ProposalExecuted || proposal.Expiry == "" {
+				break
+			}
+
+			if timeutil.Expired(proposal.Expiry, now) {
+				report.PurgeableProposals = append(report.PurgeableProposals, queryResponse.Key)
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for id, keyID := range results {
+		if rotated[keyID] {
+			report.SupersededResults = append(report.SupersededResults, id)
+		}
+	}
+
+	sort.Strings(report.SupersededResults)
+
+	byIndex := map[string]*StateUsage{}
+
+	for _, index := range indexes {
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{})
+
+		if err != nil {
+			return nil, err
+		}
+
+		byIndex[index] = &StateUsage{Name: index}
+
+		err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+			byIndex[index].Records++
+			byIndex[index].Bytes += len(queryResponse.Key) + len(queryResponse.Value)
+
+			_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+			if err != nil {
+				return err
+			}
+
+			missingID, err := missingReference(ctx, index, attributes, nil)
+
+			if err != nil {
+				return err
+			}
+
+			if missingID != "" {
+				report.OrphanedIndexEntries = append(report.OrphanedIndexEntries, OrphanedIndexEntry{Index: index, Attributes: attributes, MissingID: missingID})
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report.Entities = usage(entities)
+	report.Indexes = usage(byIndex)
+
+	report.Generated, err = newTransactionDetails(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
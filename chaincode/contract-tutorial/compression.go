@@ -0,0 +1,168 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Response encodings
+const (
+	EncodingIdentity = "identity"
+	EncodingGzip     = "gzip"
+)
+
+// compressionThreshold is the size of the JSON responses under which gzip isn't worth it,
+// they are returned as is
+const compressionThreshold = 1024
+
+// CompressedResponse is the response of a listing in the encoding applied, its payload the
+// base64 of the JSON response, gzipped when the encoding is gzip
+type CompressedResponse struct {
+	Encoding string `json:"encoding"`
+	Payload  string `json:"payload"`
+}
+
+// compressedQuery runs a listing on its string arguments
+type compressedQuery struct {
+	arguments int
+	run       func(s *SimpleContract, ctx contractapi.TransactionContextInterface, args []string) (interface{}, error)
+}
+
+// compressedQueries are the listings whose responses can be compressed, the patient listings
+// returning whole cohorts
+var compressedQueries = map[string]compressedQuery{
+	"AllPatients": {4, func(s *SimpleContract, ctx contractapi.TransactionContextInterface, args []string) (interface{}, error) {
+		pageSize, err := parsePageSize(args[2])
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.AllPatients(ctx, args[0], args[1], pageSize, args[3])
+	}},
+	"GetMyOrgPatients": {2, func(s *SimpleContract, ctx contractapi.TransactionContextInterface, args []string) (interface{}, error) {
+		pageSize, err := parsePageSize(args[0])
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.GetMyOrgPatients(ctx, pageSize, args[1])
+	}},
+	"GetPatientsInShard": {3, func(s *SimpleContract, ctx contractapi.TransactionContextInterface, args []string) (interface{}, error) {
+		shard, err := strconv.Atoi(args[0])
+
+		if err != nil {
+			return nil, newError(CodeInvalidArgument, map[string]string{"shard": args[0]}, "shard must be an integer")
+		}
+
+		pageSize, err := parsePageSize(args[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.GetPatientsInShard(ctx, shard, pageSize, args[2])
+	}},
+	"QueryPatientsDSL": {3, func(s *SimpleContract, ctx contractapi.TransactionContextInterface, args []string) (interface{}, error) {
+		pageSize, err := parsePageSize(args[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		return s.QueryPatientsDSL(ctx, args[0], pageSize, args[2])
+	}},
+}
+
+// parsePageSize parses the page size argument of a listing
+func parsePageSize(value string) (int32, error) {
+	pageSize, err := strconv.ParseInt(value, 10, 32)
+
+	if err != nil {
+		return 0, newError(CodeInvalidArgument, map[string]string{"pageSize": value}, "pageSize must be an integer")
+	}
+
+	return int32(pageSize), nil
+}
+
+// compressedFunctions returns the names of the listings whose responses can be compressed
+func compressedFunctions() []string {
+	names := []string{}
+
+	for name := range compressedQueries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// CompressedQuery runs a patient listing, its arguments given as a JSON array of strings, and
+// returns its response in the encoding the client accepts: gzip or identity. Responses under
+// 1 KiB are returned as identity, whatever the encoding accepted, and clients decode the
+// payload by the encoding of the response.
+func (s *SimpleContract) CompressedQuery(ctx contractapi.TransactionContextInterface, encoding string, function string, args string) (*CompressedResponse, error) {
+	if encoding != EncodingGzip && encoding != EncodingIdentity {
+		return nil, newError(CodeInvalidArgument, map[string]string{"encoding": encoding}, "encoding must be %s or %s", EncodingGzip, EncodingIdentity)
+	}
+
+	query, ok := compressedQueries[function]
+
+	if !ok {
+		return nil, newError(CodeInvalidArgument, map[string]string{"function": function}, "%s can't be compressed, only %s", function, strings.Join(compressedFunctions(), ", "))
+	}
+
+	arguments := []string{}
+
+	if err := json.Unmarshal([]byte(args), &arguments); err != nil {
+		return nil, newError(CodeInvalidArgument, map[string]string{"args": args}, "args must be a JSON array of strings")
+	}
+
+	if len(arguments) != query.arguments {
+		return nil, newError(CodeInvalidArgument, map[string]string{"function": function, "args": fmt.Sprint(len(arguments))}, "%s takes %d arguments", function, query.arguments)
+	}
+
+	// The listing answers under its own access rule
+	if err := checkAccessRule(ctx, function, arguments); err != nil {
+		return nil, err
+	}
+
+	response, err := query.run(s, ctx, arguments)
+
+	if err != nil {
+		return nil, err
+	}
+
+	responseAsBytes, _ := json.Marshal(response)
+
+	if encoding == EncodingIdentity || len(responseAsBytes) < compressionThreshold {
+		return &CompressedResponse{Encoding: EncodingIdentity, Payload: base64.StdEncoding.EncodeToString(responseAsBytes)}, nil
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+
+	if _, err := writer.Write(responseAsBytes); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return &CompressedResponse{Encoding: EncodingGzip, Payload: base64.StdEncoding.EncodeToString(compressed.Bytes())}, nil
+}
@@ -0,0 +1,276 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// configKey is the world state key of the contract configuration
+const configKey = "CONFIG"
+
+// Entities whose IDs can be given a format
+const (
+	EntityPatient  = "PATIENT"
+	EntityProposal = "PROPOSAL"
+	EntityConsent  = "CONSENT"
+	EntityKey      = "KEY"
+	EntityProtocol = "PROTOCOL"
+)
+
+var entities = []string{EntityPatient, EntityProposal, EntityConsent, EntityKey, EntityProtocol}
+
+// Config is the configuration of the contract set by its admins. IDFormats holds
+// the pattern the whole ID of each entity must match, IDs are free-form without one.
+// ReadPolicies holds the fields of a patient the callers of each role may read.
+// DefaultPageSize and MaxResults replace the built-in limits of the queries when set, and
+// DuplicateWindow the seconds an identical proposal is refused for, and RequestTTL the seconds
+// a request processed under an idempotency key is remembered for. NotificationTTL and
+// DraftTTL are the seconds CollectGarbage keeps notifications and drafts for. StrictProofs
+// only lets proposals compute over measurements with a range proof. StateDatabase is the
+// state database of the peers, goleveldb unless set. MaxCohort, MaxCiphertextLength and
+// MaxExecutionSteps replace the built-in limits of the executions when set.
+type Config struct {
+	IDFormats           map[string]string        `json:"idFormats,omitempty" metadata:",optional"`
+	ReadPolicies        map[string][]string      `json:"readPolicies,omitempty" metadata:",optional"`
+	DefaultPageSize     int32                    `json:"defaultPageSize,omitempty" metadata:",optional"`
+	MaxResults          int                      `json:"maxResults,omitempty" metadata:",optional"`
+	DuplicateWindow     int64                    `json:"duplicateWindow,omitempty" metadata:",optional"`
+	RequestTTL          int64                    `json:"requestTTL,omitempty" metadata:",optional"`
+	NotificationTTL     int64                    `json:"notificationTTL,omitempty" metadata:",optional"`
+	DraftTTL            int64                    `json:"draftTTL,omitempty" metadata:",optional"`
+	StrictProofs        bool                     `json:"strictProofs,omitempty" metadata:",optional"`
+	StateDatabase       string                   `json:"stateDatabase,omitempty" metadata:",optional"`
+	AuditorMSP          string                   `json:"auditorMSP,omitempty" metadata:",optional"`
+	AccessRules         map[string]AccessRule    `json:"accessRules,omitempty" metadata:",optional"`
+	ActiveEmergency     string                   `json:"activeEmergency,omitempty" metadata:",optional"`
+	PrivacyBudget       int                      `json:"privacyBudget,omitempty" metadata:",optional"`
+	RetentionRules      map[string]RetentionRule `json:"retentionRules,omitempty" metadata:",optional"`
+	MaxCohort           int                      `json:"maxCohort,omitempty" metadata:",optional"`
+	MaxCiphertextLength int                      `json:"maxCiphertextLength,omitempty" metadata:",optional"`
+	MaxExecutionSteps   int                      `json:"maxExecutionSteps,omitempty" metadata:",optional"`
+	Metadata            Metadata                 `json:"metadata"`
+}
+
+// findConfig returns the configuration, the defaults of the channel if it was never set
+func findConfig(ctx contractapi.TransactionContextInterface) (*Config, error) {
+	configAsBytes, err := cachedState(ctx, configKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if configAsBytes == nil {
+		return channelDefaults(ctx.GetStub().GetChannelID()), nil
+	}
+
+	config := new(Config)
+	_ = json.Unmarshal(configAsBytes, config)
+
+	return config, nil
+}
+
+// putConfig stamps and writes the configuration
+func putConfig(ctx contractapi.TransactionContextInterface, config *Config) error {
+	var err error
+
+	if config.Metadata.Created.TxID == "" {
+		config.Metadata, err = newMetadata(ctx)
+	} else {
+		err = config.Metadata.touch(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	configAsBytes, _ := json.Marshal(config)
+
+	return putCachedState(ctx, configKey, configAsBytes)
+}
+
+// GetConfig returns the configuration of the channel, its defaults for the settings never set
+func (s *SimpleContract) GetConfig(ctx contractapi.TransactionContextInterface) (*Config, error) {
+	return findConfig(ctx)
+}
+
+// SetIDFormat sets the regular expression the IDs of an entity must match when created,
+// e.g. PAT-\d{6} for patients. An empty pattern lets the IDs be free-form again.
+func (s *SimpleContract) SetIDFormat(ctx contractapi.TransactionContextInterface, entity string, pattern string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if !contains(entities, entity) {
+		return newError(CodeInvalidArgument, map[string]string{"entity": entity}, "Entity %s has no ID", entity)
+	}
+
+	if _, err := regexp.Compile(pattern); err != nil {
+		return newError(CodeInvalidArgument, map[string]string{"pattern": pattern}, "Invalid pattern. %s", err.Error())
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if config.IDFormats == nil {
+		config.IDFormats = map[string]string{}
+	}
+
+	if pattern == "" {
+		delete(config.IDFormats, entity)
+	} else {
+		config.IDFormats[entity] = pattern
+	}
+
+	return putConfig(ctx, config)
+}
+
+// SetQueryLimits sets the size of the pages of the listings when clients ask for none, and the
+// most results a query reads, at most 10000. A limit of 0 restores the built-in one.
+func (s *SimpleContract) SetQueryLimits(ctx contractapi.TransactionContextInterface, defaultPageSize int32, maxResults int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if maxResults < 0 || maxResults > maxQueryResults {
+		return newError(CodeInvalidArgument, map[string]string{"maxResults": fmt.Sprint(maxResults)}, "The result limit must be between 0 and %d", maxQueryResults)
+	}
+
+	limit := maxResults
+
+	if limit == 0 {
+		limit = maxQueryResults
+	}
+
+	if defaultPageSize < 0 || int(defaultPageSize) > limit {
+		return newError(CodeInvalidArgument, map[string]string{"defaultPageSize": fmt.Sprint(defaultPageSize)}, "The default page size must be between 0 and %d", limit)
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.DefaultPageSize = defaultPageSize
+	config.MaxResults = maxResults
+
+	return putConfig(ctx, config)
+}
+
+// Keys and key prefixes of the records the contract writes under keys of its own. Results,
+// result sets, receipts and publications get generated IDs, and the event journal is kept by
+// position.
+var (
+	reservedKeys     = []string{configKey, shardConfigKey, eventSequenceKey}
+	reservedPrefixes = []string{"RESULT", "RECEIPT", "COMPUTATION", "PUBLICATION", eventJournalPrefix}
+)
+
+// checkIDCharacters refuses an empty ID, or one holding invalid UTF-8, a control character
+// or the U+0000 and U+10FFFF delimiters of composite keys
+func checkIDCharacters(id string) error {
+	if id == "" {
+		return newError(CodeInvalidArgument, map[string]string{"id": id}, "An ID can't be empty")
+	}
+
+	if !utf8.ValidString(id) {
+		return newError(CodeInvalidArgument, map[string]string{"id": id}, "%q is not valid UTF-8", id)
+	}
+
+	for i, r := range id {
+		position := map[string]string{"id": id, "position": fmt.Sprint(i), "character": fmt.Sprintf("%U", r)}
+
+		switch {
+		case r == 0:
+			return newError(CodeInvalidArgument, position, "%q holds the composite key separator U+0000 at byte %d", id, i)
+		case r == utf8.MaxRune:
+			return newError(CodeInvalidArgument, position, "%q holds the composite key range end U+10FFFF at byte %d", id, i)
+		case unicode.IsControl(r):
+			return newError(CodeInvalidArgument, position, "%q holds the control character %U at byte %d", id, r, i)
+		}
+	}
+
+	return nil
+}
+
+// checkReservedID refuses an ID checkIDCharacters refuses, or one a record of the contract
+// could be written under: its keys, the prefixes of its generated IDs and the namespaces of
+// its indexes
+func checkReservedID(id string) error {
+	if err := checkIDCharacters(id); err != nil {
+		return err
+	}
+
+	if contains(reservedKeys, id) || contains(indexes, id) {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "reserved": id}, "%s is reserved by the contract", id)
+	}
+
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return newError(CodeInvalidArgument, map[string]string{"id": id, "reserved": prefix}, "%s starts with %s, which is reserved for the IDs the contract generates", id, prefix)
+		}
+	}
+
+	return nil
+}
+
+// checkIDFormat refuses a reserved ID or one not matching the format of its entity
+func checkIDFormat(ctx contractapi.TransactionContextInterface, entity string, id string) error {
+	if err := checkReservedID(id); err != nil {
+		return err
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	pattern, ok := config.IDFormats[entity]
+
+	if !ok {
+		return nil
+	}
+
+	if !regexp.MustCompile(`^(?:` + pattern + `)$`).MatchString(id) {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "entity": entity, "pattern": pattern}, "%s is not a valid %s ID, it must match %s", id, entity, pattern)
+	}
+
+	return nil
+}
+
+// checkIDUnused refuses an ID that already holds a record, the entities sharing one namespace
+func checkIDUnused(ctx contractapi.TransactionContextInterface, id string) error {
+	recordAsBytes, err := ctx.GetStub().GetState(id)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if recordAsBytes != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": id}, "%s already exists", id)
+	}
+
+	return nil
+}
+
+// ValidateID checks an ID against the format of its entity, so clients can check it before creating it
+func (s *SimpleContract) ValidateID(ctx contractapi.TransactionContextInterface, entity string, id string) error {
+	if !contains(entities, entity) {
+		return newError(CodeInvalidArgument, map[string]string{"entity": entity}, "Entity %s has no ID", entity)
+	}
+
+	return checkIDFormat(ctx, entity, id)
+}
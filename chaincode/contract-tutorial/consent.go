@@ -0,0 +1,252 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/payloadschema"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Consent statuses, consents recorded without a status are granted
+const (
+	ConsentGranted = "GRANTED"
+	ConsentRevoked = "REVOKED"
+	ConsentExpired = "EXPIRED"
+)
+
+// Consent describes a patient's consent for an organization to use their data. A consent
+// with an RFC 3339 expiry lapses then, ExpiryNotified being set once its custodian has
+// been notified of the coming expiry. Proxy is the guardianship of a consent given by a
+// guardian of the patient.
+type Consent struct {
+	PatientID      string         `json:"patientID"`
+	GranteeMSP     string         `json:"granteeMSP"`
+	TermsHash      string         `json:"termsHash"`
+	Purposes       []string       `json:"purposes,omitempty" metadata:",optional"`
+	Expiry         string         `json:"expiry,omitempty" metadata:",optional"`
+	ExpiryNotified bool           `json:"expiryNotified,omitempty" metadata:",optional"`
+	Proxy          *GuardianProxy `json:"proxy,omitempty" metadata:",optional"`
+	PrivacyBudget  int            `json:"privacyBudget,omitempty" metadata:",optional"`
+	Status         string         `json:"status"`
+	ReceiptID      string         `json:"receiptID"`
+	Metadata       Metadata       `json:"metadata"`
+}
+
+// ConsentInput describes a consent to be granted by GrantConsentsBatch
+type ConsentInput struct {
+	ID         string `json:"id"`
+	PatientID  string `json:"patientID"`
+	GranteeMSP string `json:"granteeMSP"`
+	Terms      string `json:"terms"`
+	Purposes   string `json:"purposes"`
+	Expiry     string `json:"expiry,omitempty" metadata:",optional"`
+}
+
+// ConsentReceipt is the proof handed to a patient of what they agreed to
+type ConsentReceipt struct {
+	ConsentID  string         `json:"consentID"`
+	PatientID  string         `json:"patientID"`
+	GranteeMSP string         `json:"granteeMSP"`
+	TermsHash  string         `json:"termsHash"`
+	Purposes   []string       `json:"purposes,omitempty" metadata:",optional"`
+	Expiry     string         `json:"expiry,omitempty" metadata:",optional"`
+	Proxy      *GuardianProxy `json:"proxy,omitempty" metadata:",optional"`
+	TxID       string         `json:"txID"`
+	Timestamp  string         `json:"timestamp"`
+	Hash       string         `json:"hash"`
+}
+
+// consentReceiptIndex is the composite key namespace of the receipt of each consent
+const consentReceiptIndex = "receipt~consent"
+
+// hashString returns the hex encoded SHA-256 hash of s
+func hashString(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+// GrantConsent records the consent and its receipt. Purposes are comma separated, and an
+// empty expiry never expires. Only the custodian of the patient and its guardians in force
+// grant consents, under an ID not used yet.
+func (s *SimpleContract) GrantConsent(ctx contractapi.TransactionContextInterface, id string, patientID string, granteeMSP string, terms string, purposes string, expiry string) error {
+	return s.putConsent(ctx, id, patientID, granteeMSP, terms, purposes, expiry, nil)
+}
+
+// GrantConsentsBatch grants several consents in one transaction as GrantConsent does, failing
+// them all with the ID of the first consent refused
+func (s *SimpleContract) GrantConsentsBatch(ctx contractapi.TransactionContextInterface, consents []ConsentInput) error {
+	consentsAsBytes, _ := json.Marshal(consents)
+
+	if err := validatePayload(payloadschema.Consents, consentsAsBytes); err != nil {
+		return err
+	}
+
+	for _, c := range consents {
+		if err := s.putConsent(ctx, c.ID, c.PatientID, c.GranteeMSP, c.Terms, c.Purposes, c.Expiry, nil); err != nil {
+			return withDetail(err, "consentID", c.ID)
+		}
+	}
+
+	return nil
+}
+
+// putConsent records a new consent, its receipt and its index entry, with the guardianship
+// of a consent given by a guardian. A caller other than the custodian of the patient must be
+// a guardian in force, and its guardianship is recorded.
+func (s *SimpleContract) putConsent(ctx contractapi.TransactionContextInterface, id string, patientID string, granteeMSP string, terms string, purposes string, expiry string, proxy *GuardianProxy) error {
+	if err := checkIDFormat(ctx, EntityConsent, id); err != nil {
+		return err
+	}
+
+	if err := checkIDUnused(ctx, id); err != nil {
+		return err
+	}
+
+	if expiry != "" {
+		if _, err := parseTimestamp("expiry", expiry); err != nil {
+			return err
+		}
+	}
+
+	codes, err := parsePurposes(purposes)
+
+	if err != nil {
+		return err
+	}
+
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return err
+	}
+
+	if proxy == nil {
+		mspID, err := callerMSP(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if mspID != patient.custodian() {
+			if proxy, err = guardianProxy(ctx, patientID, codes); err != nil {
+				return err
+			}
+
+			if proxy == nil {
+				return newError(CodePermissionDenied, map[string]string{"patientID": patientID, "mspID": mspID}, "Only %s and the guardians of %s can grant its consents", patient.custodian(), patientID)
+			}
+		}
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	receiptKey, err := ctx.GetStub().CreateCompositeKey(consentReceiptIndex, []string{id})
+
+	if err != nil {
+		return err
+	}
+
+	receipt := ConsentReceipt{
+		ConsentID:  id,
+		PatientID:  patientID,
+		GranteeMSP: granteeMSP,
+		TermsHash:  hashString(terms),
+		Purposes:   codes,
+		Expiry:     expiry,
+		Proxy:      proxy,
+		TxID:       metadata.Created.TxID,
+		Timestamp:  metadata.Created.Timestamp,
+	}
+
+	// The receipt hash is taken over the receipt JSON while its hash is still empty
+	receiptAsBytes, _ := json.Marshal(receipt)
+	receipt.Hash = hashString(string(receiptAsBytes))
+
+	consent := Consent{
+		PatientID:  patientID,
+		GranteeMSP: granteeMSP,
+		TermsHash:  receipt.TermsHash,
+		Purposes:   codes,
+		Expiry:     expiry,
+		Proxy:      proxy,
+		Status:     ConsentGranted,
+		ReceiptID:  id,
+		Metadata:   metadata,
+	}
+
+	consentAsBytes, _ := json.Marshal(consent)
+
+	if err := ctx.GetStub().PutState(id, consentAsBytes); err != nil {
+		return err
+	}
+
+	if err := indexConsent(ctx, patientID, granteeMSP, id); err != nil {
+		return err
+	}
+
+	if err := indexConsentExpiry(ctx, expiry, id); err != nil {
+		return err
+	}
+
+	receiptAsBytes, _ = json.Marshal(receipt)
+
+	return ctx.GetStub().PutState(receiptKey, receiptAsBytes)
+}
+
+// FindConsent returns a consent
+func (s *SimpleContract) FindConsent(ctx contractapi.TransactionContextInterface, id string) (*Consent, error) {
+	consentAsBytes, err := ctx.GetStub().GetState(id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if consentAsBytes == nil {
+		return nil, errNotFound(id)
+	}
+
+	consent := new(Consent)
+	_ = json.Unmarshal(consentAsBytes, consent)
+
+	return consent, nil
+}
+
+// FindConsentReceipt returns the receipt of a consent by the receiptID of the consent, its ID,
+// the hash of its terms as they were granted. Receipts of consents granted before receipts
+// were keyed by consent are found by their former RECEIPT ID.
+func (s *SimpleContract) FindConsentReceipt(ctx contractapi.TransactionContextInterface, id string) (*ConsentReceipt, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(consentReceiptIndex, []string{id})
+
+	if err != nil {
+		return nil, err
+	}
+
+	receiptAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err == nil && receiptAsBytes == nil {
+		receiptAsBytes, err = ctx.GetStub().GetState(id)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	receipt := new(ConsentReceipt)
+
+	if receiptAsBytes == nil || json.Unmarshal(receiptAsBytes, receipt) != nil || receipt.Hash == "" {
+		return nil, errNotFound(id)
+	}
+
+	return receipt, nil
+}
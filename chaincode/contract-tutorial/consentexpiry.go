@@ -0,0 +1,159 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// consentExpiryIndex is the composite key namespace of the consents with an expiry, in order of expiry
+const consentExpiryIndex = "consent~expiry"
+
+// consentExpiryNotice is how long before its expiry the custodian of a consent is notified
+const consentExpiryNotice = 30 * 24 * time.Hour
+
+// ConsentExpiryReport is what a run of ExpireConsents did. Remaining tells whether
+// consents are left to expire or notify of.
+type ConsentExpiryReport struct {
+	Expired   []string `json:"expired"`
+	Notified  []string `json:"notified"`
+	Remaining bool     `json:"remaining"`
+}
+
+// indexConsentExpiry adds a consent to the consents expiring, by its expiry in UTC so the
+// entries sort in order of expiry
+func indexConsentExpiry(ctx contractapi.TransactionContextInterface, expiry string, id string) error {
+	if expiry == "" {
+		return nil
+	}
+
+	t, err := timeutil.Parse(expiry)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(consentExpiryIndex, []string{timeutil.Format(t), id})
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+// expired tells whether a consent has expired at a time
+func (c *Consent) expired(now time.Time) bool {
+	return timeutil.Expired(c.Expiry, now)
+}
+
+// ExpireConsents records the expiry of the consents past it and notifies the custodians of
+// the patients of the consents expiring within 30 days, so renewal can be sought. Each call
+// handles a page of consents, in order of expiry, and is called again while some remain.
+func (s *SimpleContract) ExpireConsents(ctx contractapi.TransactionContextInterface) (*ConsentExpiryReport, error) {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := resolvePageSize(ctx, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consentExpiryIndex, []string{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ConsentExpiryReport{Expired: []string{}, Notified: []string{}}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		expiry, err := timeutil.Parse(attributes[0])
+
+		if err != nil {
+			return err
+		}
+
+		if now.Add(consentExpiryNotice).Before(expiry) {
+			return ledgeriter.ErrStop
+		}
+
+		id := attributes[1]
+
+		consent, err := s.FindConsent(ctx, id)
+
+		if err != nil {
+			return err
+		}
+
+		expired := !now.Before(expiry)
+
+		// Consents already notified of wait for their expiry without taking a place in the page
+		if !expired && (consent.ExpiryNotified || consent.Status != ConsentGranted) {
+			return nil
+		}
+
+		if len(report.Expired)+len(report.Notified) == int(pageSize) {
+			report.Remaining = true
+			return ledgeriter.ErrStop
+		}
+
+		if expired {
+			if consent.Status != ConsentRevoked {
+				consent.Status = ConsentExpired
+			}
+
+			if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+				return err
+			}
+
+			report.Expired = append(report.Expired, id)
+		} else {
+			patient, err := findPatient(ctx, consent.PatientID)
+
+			if err != nil {
+				return err
+			}
+
+			if err := notify(ctx, patient.custodian(), NotificationConsentExpiring, id, fmt.Sprintf("%s of %s to %s expires at %s, seek its renewal", id, consent.PatientID, consent.GranteeMSP, consent.Expiry)); err != nil {
+				return err
+			}
+
+			consent.ExpiryNotified = true
+			report.Notified = append(report.Notified, id)
+		}
+
+		if err := consent.Metadata.touch(ctx); err != nil {
+			return err
+		}
+
+		consentAsBytes, _ := json.Marshal(consent)
+
+		return ctx.GetStub().PutState(id, consentAsBytes)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
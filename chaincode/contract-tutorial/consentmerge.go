@@ -0,0 +1,208 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// consentDecisionIndex is the composite key namespace of the decisions waiting to be
+// merged into each consent. Every decision has a key of its own, so concurrent
+// decisions never conflict.
+const consentDecisionIndex = "consent~decision"
+
+// Consent decision actions
+const (
+	ConsentActionGrant  = "GRANT"
+	ConsentActionRevoke = "REVOKE"
+)
+
+// ConsentDecision is a grant or revocation of a consent by the patient or a guardian, Proxy
+// being the guardianship of a decision taken by a guardian
+type ConsentDecision struct {
+	ConsentID string             `json:"consentID"`
+	Action    string             `json:"action"`
+	TermsHash string             `json:"termsHash,omitempty" metadata:",optional"`
+	Purposes  []string           `json:"purposes,omitempty" metadata:",optional"`
+	Proxy     *GuardianProxy     `json:"proxy,omitempty" metadata:",optional"`
+	Decided   TransactionDetails `json:"decided"`
+}
+
+// RecordConsentDecision records a grant, with its terms and comma separated purposes, or a
+// revocation of a consent. It takes effect once merged by MergeConsent. Only the custodian of
+// the patient and its guardians, while their guardianship is in force, decide.
+func (s *SimpleContract) RecordConsentDecision(ctx contractapi.TransactionContextInterface, consentID string, action string, terms string, purposes string) error {
+	consent, err := s.FindConsent(ctx, consentID)
+
+	if err != nil {
+		return err
+	}
+
+	decision := ConsentDecision{ConsentID: consentID, Action: action}
+
+	switch action {
+	case ConsentActionGrant:
+		if terms == "" {
+			return newError(CodeInvalidArgument, map[string]string{"action": action}, "A grant needs terms")
+		}
+
+		codes, err := parsePurposes(purposes)
+
+		if err != nil {
+			return err
+		}
+
+		decision.TermsHash = hashString(terms)
+		decision.Purposes = codes
+	case ConsentActionRevoke:
+	default:
+		return newError(CodeInvalidArgument, map[string]string{"action": action}, "Action must be %s or %s", ConsentActionGrant, ConsentActionRevoke)
+	}
+
+	patient, err := findPatient(ctx, consent.PatientID)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// A guardian of the patient decides within the scope of its guardianship
+	if mspID != patient.custodian() {
+		if decision.Proxy, err = guardianProxy(ctx, consent.PatientID, decision.Purposes); err != nil {
+			return err
+		}
+
+		if decision.Proxy == nil {
+			return newError(CodePermissionDenied, map[string]string{"id": consentID, "mspID": mspID}, "Only %s and the guardians of %s can decide on its consents", patient.custodian(), consent.PatientID)
+		}
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	decision.Decided = details
+
+	key, err := ctx.GetStub().CreateCompositeKey(consentDecisionIndex, []string{consentID, details.TxID})
+
+	if err != nil {
+		return err
+	}
+
+	decisionAsBytes, _ := json.Marshal(decision)
+
+	return ctx.GetStub().PutState(key, decisionAsBytes)
+}
+
+// ConsentDecisionPage is a page of consent decisions
+type ConsentDecisionPage struct {
+	Records   []ConsentDecision `json:"records"`
+	Bookmark  string            `json:"bookmark"`
+	Count     int32             `json:"count"`
+	Truncated bool              `json:"truncated"`
+}
+
+// GetConsentDecisions returns a page of the decisions of a consent waiting to be merged
+func (s *SimpleContract) GetConsentDecisions(ctx contractapi.TransactionContextInterface, consentID string, pageSize int32, bookmark string) (*ConsentDecisionPage, error) {
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectTypedPage[ConsentDecision](queryContext(), indexPages(ctx, consentDecisionIndex, []string{consentID}), size, bookmark)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsentDecisionPage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
+
+// MergeConsent applies the pending decisions of a consent. Among them a revocation
+// wins over any grant, otherwise the terms and purposes of the latest grant apply. Since the
+// decisions merged together were all recorded since the last merge, a patient can
+// grant again after a merged revocation.
+func (s *SimpleContract) MergeConsent(ctx contractapi.TransactionContextInterface, consentID string) (*Consent, error) {
+	consent, err := s.FindConsent(ctx, consentID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consentDecisionIndex, []string{consentID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *ConsentDecision
+	revoked := false
+	merged := 0
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		decision := new(ConsentDecision)
+		_ = json.Unmarshal(queryResponse.Value, decision)
+
+		if decision.Action == ConsentActionRevoke {
+			revoked = true
+		} else if latest == nil || timeutil.Compare(decision.Decided.Timestamp, latest.Decided.Timestamp) > 0 ||
+			(timeutil.Compare(decision.Decided.Timestamp, latest.Decided.Timestamp) == 0 && decision.Decided.TxID > latest.Decided.TxID) {
+			// Ties are broken by transaction ID, so every peer merges the same way
+			latest = decision
+		}
+
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return err
+		}
+
+		merged++
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	if merged == 0 {
+		return nil, newError(CodeInvalidState, map[string]string{"id": consentID}, "%s has no decision to merge", consentID)
+	}
+
+	if revoked {
+		consent.Status = ConsentRevoked
+	} else {
+		consent.Status = ConsentGranted
+		consent.TermsHash = latest.TermsHash
+		consent.Purposes = latest.Purposes
+		consent.Proxy = latest.Proxy
+	}
+
+	if err := consent.Metadata.touch(ctx); err != nil {
+		return nil, err
+	}
+
+	consentAsBytes, _ := json.Marshal(consent)
+
+	return consent, ctx.GetStub().PutState(consentID, consentAsBytes)
+}
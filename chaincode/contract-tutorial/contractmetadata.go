@@ -0,0 +1,178 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/metadocs"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+//go:generate go run ./cmd/metadocs -dir . -out contract-docs.json SimpleContract=v2 LegacyContract=v1 ReportingContract=reporting
+
+// contractDocs are the parameter names and doc comments of the transactions and types of the
+// contracts, which contractapi can't reflect. metadocs writes them from the source.
+//
+//go:embed contract-docs.json
+var contractDocs []byte
+
+// contractReceivers maps the type of each contract to its name, as go generate passes them to metadocs
+var contractReceivers = map[string]string{"SimpleContract": APIVersion2, "LegacyContract": APIVersion1, "ReportingContract": ReportingNamespace}
+
+// contractMetadata is the metadata of the chaincode completed with its docs, set by newChaincode
+var contractMetadata string
+
+// metadataStub calls GetMetadata of the system contract outside of a transaction, which
+// only reads the function name. There is no creator, so no client identity.
+type metadataStub struct {
+	shim.ChaincodeStubInterface
+}
+
+// GetFunctionAndParameters returns GetMetadata of the system contract
+func (s *metadataStub) GetFunctionAndParameters() (string, []string) {
+	return contractapi.SystemContractName + ":GetMetadata", nil
+}
+
+// GetCreator fails, the call has no creator
+func (s *metadataStub) GetCreator() ([]byte, error) {
+	return nil, errors.New("GetMetadata has no creator")
+}
+
+// documentMetadata returns the metadata contractapi reflected from the contracts of a
+// chaincode, completed for client generation: the transactions get their description and
+// the names of their parameters instead of param0, param1..., the types their description,
+// the properties lose the ",omitempty" contractapi leaves in their name, and references
+// between types point to their schema in the components.
+func documentMetadata(cc *contractapi.ContractChaincode) (string, error) {
+	response := cc.Invoke(&metadataStub{})
+
+	if response.Status != shim.OK {
+		return "", fmt.Errorf("Failed to read the metadata. %s", response.Message)
+	}
+
+	docs := metadocs.Docs{}
+
+	if err := json.Unmarshal(contractDocs, &docs); err != nil {
+		return "", fmt.Errorf("contract-docs.json is invalid. %s", err.Error())
+	}
+
+	metadata := map[string]interface{}{}
+
+	if err := json.Unmarshal(response.Payload, &metadata); err != nil {
+		return "", err
+	}
+
+	metadata["info"] = map[string]interface{}{
+		"title":       "contract-tutorial",
+		"description": "Patient records, consents and proposals aggregating phe encrypted values across orgs",
+		"version":     ContractVersion,
+		"license":     map[string]interface{}{"name": "Apache-2.0"},
+	}
+
+	contracts, _ := metadata["contracts"].(map[string]interface{})
+
+	for name, contract := range contracts {
+		transactions, _ := contract.(map[string]interface{})["transactions"].([]interface{})
+
+		for _, transaction := range transactions {
+			documentTransaction(transaction.(map[string]interface{}), docs, name)
+		}
+	}
+
+	components, _ := metadata["components"].(map[string]interface{})
+	schemas, _ := components["schemas"].(map[string]interface{})
+
+	for name, schema := range schemas {
+		object := schema.(map[string]interface{})
+
+		if description := docs.Types[name]; description != "" {
+			object["description"] = description
+		}
+
+		if properties, ok := object["properties"].(map[string]interface{}); ok {
+			for property, propertySchema := range properties {
+				if trimmed, _, ok := strings.Cut(property, ","); ok {
+					delete(properties, property)
+					properties[trimmed] = propertySchema
+				}
+			}
+		}
+
+		referenceComponents(object)
+	}
+
+	metadataAsBytes, _ := json.Marshal(metadata)
+
+	return string(metadataAsBytes), nil
+}
+
+// documentTransaction describes a transaction of a contract and names its parameters. The
+// v1 and reporting functions without a doc comment of their own are described by the v2
+// function they run.
+func documentTransaction(transaction map[string]interface{}, docs metadocs.Docs, contract string) {
+	name, _ := transaction["name"].(string)
+	doc, ok := docs.Contracts[contract][name]
+
+	if !ok {
+		return
+	}
+
+	description := doc.Description
+
+	if description == "" {
+		description = docs.Contracts[APIVersion2][name].Description
+	}
+
+	if description != "" {
+		transaction["description"] = description
+	}
+
+	parameters, _ := transaction["parameters"].([]interface{})
+
+	// A function changed since contract-docs.json was written keeps the reflected names
+	if len(parameters) != len(doc.Parameters) {
+		return
+	}
+
+	for i, parameter := range parameters {
+		parameter.(map[string]interface{})["name"] = doc.Parameters[i]
+	}
+}
+
+// referenceComponents points the references of a schema of the components, which contractapi
+// gives by bare type name, to the schemas of those types
+func referenceComponents(schema interface{}) {
+	switch schema := schema.(type) {
+	case map[string]interface{}:
+		for key, value := range schema {
+			if ref, ok := value.(string); ok && key == "$ref" && !strings.HasPrefix(ref, "#/") {
+				schema[key] = "#/components/schemas/" + ref
+			} else {
+				referenceComponents(value)
+			}
+		}
+	case []interface{}:
+		for _, value := range schema {
+			referenceComponents(value)
+		}
+	}
+}
+
+// GetMetadata returns the metadata of the chaincode, that of GetMetadata of the system
+// contract completed with the names of the parameters and the descriptions of the
+// transactions and types, for client SDK generators to type every function and record
+func (s *SimpleContract) GetMetadata(ctx contractapi.TransactionContextInterface) (string, error) {
+	if contractMetadata == "" {
+		return "", newError(CodeInvalidState, nil, "The metadata is set when the chaincode starts")
+	}
+
+	return contractMetadata, nil
+}
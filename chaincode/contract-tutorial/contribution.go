@@ -0,0 +1,307 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// contributionStatementIndex is the composite key namespace of the contribution statements of
+// an org by period, in its implicit collection
+const contributionStatementIndex = "statement~period"
+
+// StudyContribution is what the patients of a custodian contributed to a proposal in a period.
+// Patients accessed without consent under a public-health emergency have no consent listed.
+type StudyContribution struct {
+	ProposalID  string   `json:"proposalID"`
+	ProtocolID  string   `json:"protocolID"`
+	RequesterID string   `json:"requesterID"`
+	Purpose     string   `json:"purpose"`
+	PatientsIDs []string `json:"patientsIDs"`
+	ConsentIDs  []string `json:"consentIDs"`
+}
+
+// ContributionStatement summarizes the studies the patients of a custodian were included in
+// during a YYYY-MM period and the consents they were included under
+type ContributionStatement struct {
+	MSPID     string              `json:"mspID"`
+	Period    string              `json:"period"`
+	Patients  int                 `json:"patients"`
+	Studies   []StudyContribution `json:"studies"`
+	Generated TransactionDetails  `json:"generated"`
+}
+
+// accessConsents returns the consents of a patient to a grantee covering a purpose that were
+// granted before an access, whatever became of them since
+func (s *SimpleContract) accessConsents(ctx contractapi.TransactionContextInterface, patientID string, granteeMSP string, purpose string, accessed time.Time) ([]string, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consentPatientIndex, []string{patientID, granteeMSP})
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []string{}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		consent, err := s.FindConsent(ctx, attributes[2])
+
+		if err != nil {
+			return err
+		}
+
+		granted, err := timeutil.Parse(consent.Metadata.Created.Timestamp)
+
+		if err != nil || granted.After(accessed) {
+			return nil
+		}
+
+		if (len(consent.Purposes) == 0 && purpose == PurposeResearch) || contains(consent.Purposes, purpose) {
+			ids = append(ids, attributes[2])
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	return ids, nil
+}
+
+// contributions returns the accesses to the patients of an org between start and end, by proposal
+// and purpose, and the number of patients accessed
+func (s *SimpleContract) contributions(ctx contractapi.TransactionContextInterface, mspID string, start time.Time, end time.Time) ([]StudyContribution, int, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(patientOrgIndex, []string{mspID})
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	pids := []string{}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		pids = append(pids, attributes[1])
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, 0, queryError(err)
+	}
+
+	studies := map[string]*StudyContribution{}
+	patients := 0
+
+	for _, pid := range pids {
+		entries := []AccessLogEntry{}
+
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(accessLogIndex, []string{pid})
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+			entry := AccessLogEntry{}
+			_ = json.Unmarshal(queryResponse.Value, &entry)
+
+			if accessed, err := timeutil.Parse(entry.Accessed.Timestamp); err == nil && !accessed.Before(start) && accessed.Before(end) {
+				entries = append(entries, entry)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, 0, queryError(err)
+		}
+
+		if len(entries) > 0 {
+			patients++
+		}
+
+		for _, entry := range entries {
+			key := entry.ProposalID + "\x00" + entry.Purpose
+			study, ok := studies[key]
+
+			if !ok {
+				proposal, err := s.FindProposal(ctx, entry.ProposalID)
+
+				if err != nil {
+					return nil, 0, err
+				}
+
+				study = &StudyContribution{ProposalID: entry.ProposalID, ProtocolID: proposal.ProtocolID, RequesterID: proposal.RequesterID, Purpose: entry.Purpose, PatientsIDs: []string{}, ConsentIDs: []string{}}
+				studies[key] = study
+			}
+
+			if !contains(study.PatientsIDs, pid) {
+				study.PatientsIDs = append(study.PatientsIDs, pid)
+			}
+
+			accessed, _ := timeutil.Parse(entry.Accessed.Timestamp)
+			consentIDs, err := s.accessConsents(ctx, pid, study.RequesterID, entry.Purpose, accessed)
+
+			if err != nil {
+				return nil, 0, err
+			}
+
+			for _, id := range consentIDs {
+				if !contains(study.ConsentIDs, id) {
+					study.ConsentIDs = append(study.ConsentIDs, id)
+				}
+			}
+		}
+	}
+
+	results := []StudyContribution{}
+
+	for _, study := range studies {
+		results = append(results, *study)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].ProposalID != results[j].ProposalID {
+			return results[i].ProposalID < results[j].ProposalID
+		}
+
+		return results[i].Purpose < results[j].Purpose
+	})
+
+	return results, patients, nil
+}
+
+// GenerateContributionStatement summarizes which patients of the caller's org were included in
+// which studies during a YYYY-MM period once it is over, and under which consents, so the
+// custodian can report it to its patients. The statement is kept in the implicit collection of
+// the org, so only its peers endorse the transaction and hold the statement.
+func (s *SimpleContract) GenerateContributionStatement(ctx contractapi.TransactionContextInterface, period string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	end, err := parsePeriod(period)
+
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if now.Before(end) {
+		return newError(CodeInvalidState, map[string]string{"period": period}, "%s is not over until %s", period, timeutil.Format(end))
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	collection, err := implicitCollection(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(contributionStatementIndex, []string{period})
+
+	if err != nil {
+		return err
+	}
+
+	existing, err := ctx.GetStub().GetPrivateData(collection, key)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from private data. %s", err.Error())
+	}
+
+	if existing != nil {
+		return newError(CodeAlreadyExists, map[string]string{"period": period, "mspID": mspID}, "The statement of %s for %s already exists", mspID, period)
+	}
+
+	studies, patients, err := s.contributions(ctx, mspID, end.AddDate(0, -1, 0), end)
+
+	if err != nil {
+		return err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	statementAsBytes, _ := json.Marshal(ContributionStatement{MSPID: mspID, Period: period, Patients: patients, Studies: studies, Generated: details})
+
+	return ctx.GetStub().PutPrivateData(collection, key, statementAsBytes)
+}
+
+// GetContributionStatement returns the contribution statement of the caller's org for a period
+func (s *SimpleContract) GetContributionStatement(ctx contractapi.TransactionContextInterface, period string) (*ContributionStatement, error) {
+	collection, err := implicitCollection(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(contributionStatementIndex, []string{period})
+
+	if err != nil {
+		return nil, err
+	}
+
+	statementAsBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from private data. %s", err.Error())
+	}
+
+	if statementAsBytes == nil {
+		return nil, newError(CodeNotFound, map[string]string{"period": period}, "No statement was generated for %s", period)
+	}
+
+	statement := new(ContributionStatement)
+	_ = json.Unmarshal(statementAsBytes, statement)
+
+	return statement, nil
+}
@@ -0,0 +1,143 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Count operations over flags, measurements holding an encrypted 0 or 1
+const (
+	// OperationCount counts the patients with a flag
+	OperationCount = "COUNT"
+	// OperationAnd counts the patients with both of two flags
+	OperationAnd = "AND"
+	// OperationOr counts the patients with either of two flags
+	OperationOr = "OR"
+)
+
+// supportedOperations are the operations proposals can request
+var supportedOperations = []string{OperationMean, OperationCount, OperationAnd, OperationOr, OperationGroupMean, OperationSum, OperationDistinctDiagnoses}
+
+// conjunction returns the flag holding the product of two flags. The scheme only
+// adds ciphertexts, so the custodian stores the product of the flags a co-occurrence
+// is counted for as a flag of its own, named after both in alphabetical order.
+func conjunction(a string, b string) string {
+	flags := []string{a, b}
+	sort.Strings(flags)
+
+	return strings.Join(flags, "&")
+}
+
+// checkOperationMetrics checks the number of metrics an operation computes over
+func checkOperationMetrics(operation string, metrics []string) error {
+	if !contains(supportedOperations, operation) {
+		return newError(CodeInvalidArgument, map[string]string{"operation": operation}, "Operation %s is not supported", operation)
+	}
+
+	expected := map[string]int{OperationCount: 1, OperationAnd: 2, OperationOr: 2, OperationGroupMean: 1, OperationSum: 1, OperationDistinctDiagnoses: 1}
+
+	if n, ok := expected[operation]; ok && len(metrics) != n {
+		return newError(CodeInvalidArgument, map[string]string{"operation": operation, "metrics": strings.Join(metrics, ",")}, "Operation %s is over %d metrics", operation, n)
+	}
+
+	return nil
+}
+
+// multiValued tells whether a proposal computes one value per metric or group, released as a result set
+func (p *Proposal) multiValued() bool {
+	return (p.Operation == OperationMean && len(p.Metrics) > 0) || p.Operation == OperationGroupMean || p.Operation == OperationDistinctDiagnoses
+}
+
+// sum adds the encrypted values of a flag over the cohort
+func (s *SimpleContract) sum(ctx contractapi.TransactionContextInterface, q phewrap.Modulus, pids []string, flag string, asOf string) (phewrap.Ciphertext, error) {
+	ms, err := s.cohortValues(ctx, pids, flag, asOf)
+
+	if err != nil {
+		return phewrap.Ciphertext{}, err
+	}
+
+	cs, err := parseCohort(pids, ms)
+
+	if err != nil {
+		return phewrap.Ciphertext{}, err
+	}
+
+	return q.Add(cs...), nil
+}
+
+// count computes the encrypted count of a count operation over the cohort
+func (s *SimpleContract) count(ctx contractapi.TransactionContextInterface, proposal *Proposal, pids []string, modulo string) (string, error) {
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return "", err
+	}
+
+	if proposal.Operation == OperationCount {
+		total, err := s.sum(ctx, q, pids, proposal.Metrics[0], proposal.AsOf)
+
+		if err != nil {
+			return "", err
+		}
+
+		return total.String(), nil
+	}
+
+	both, err := s.sum(ctx, q, pids, conjunction(proposal.Metrics[0], proposal.Metrics[1]), proposal.AsOf)
+
+	if err != nil {
+		return "", err
+	}
+
+	if proposal.Operation == OperationAnd {
+		return both.String(), nil
+	}
+
+	// Either flag is counted as the sum of both counts less the co-occurrences
+	first, err := s.sum(ctx, q, pids, proposal.Metrics[0], proposal.AsOf)
+
+	if err != nil {
+		return "", err
+	}
+
+	second, err := s.sum(ctx, q, pids, proposal.Metrics[1], proposal.AsOf)
+
+	if err != nil {
+		return "", err
+	}
+
+	return q.Add(first, second, q.Negate(both)).String(), nil
+}
+
+// CreateCountProposal requests a count over comma separated flags: one flag for
+// COUNT, two for AND and OR
+func (s *SimpleContract) CreateCountProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, operation string, flags string, expiry string) error {
+	if done, err := beginRequest(ctx, "CreateCountProposal", id, protocolID, requesterID, requestedID, patientsIDs, keyID, operation, flags, expiry); err != nil || done {
+		return err
+	}
+
+	flagsList := splitList(flags)
+
+	if len(flagsList) == 2 && flagsList[0] == flagsList[1] {
+		return newError(CodeInvalidArgument, map[string]string{"flags": flags}, "Flags must be distinct")
+	}
+
+	if operation == OperationMean {
+		return newError(CodeInvalidArgument, map[string]string{"operation": operation}, "%s is requested with CreateProposal or CreateMultiMetricProposal", operation)
+	}
+
+	proposal, err := s.proposeCohort(ctx, id, protocolID, requesterID, requestedID, patientsIDs, keyID, operation, expiry, flagsList)
+
+	if err != nil {
+		return err
+	}
+
+	return s.storeNewProposal(ctx, id, proposal)
+}
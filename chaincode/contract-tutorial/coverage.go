@@ -0,0 +1,224 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Reasons a patient selected for a proposal didn't contribute to its result
+const (
+	// SkipWithdrawn is a patient withdrawn from the proposal after it was created
+	SkipWithdrawn = "WITHDRAWN"
+	// SkipOutlier is a patient the custodian flagged as an outlier of a sum
+	SkipOutlier = "OUTLIER"
+	// SkipMissingMeasurement is a patient without a measurement the proposal reads
+	SkipMissingMeasurement = "MISSING_MEASUREMENT"
+	// SkipNoConsent is a patient without consent for the purpose of the proposal
+	SkipNoConsent = "NO_CONSENT"
+	// SkipArchived is a patient no longer on the ledger, or not yet at the cutoff of the proposal
+	SkipArchived = "ARCHIVED"
+	// SkipChangedSinceCutoff is a patient changed since the cutoff of the proposal
+	SkipChangedSinceCutoff = "CHANGED_SINCE_CUTOFF"
+)
+
+// SkippedPatient is a patient selected for a proposal that didn't contribute to its result
+type SkippedPatient struct {
+	PatientID string `json:"patientID"`
+	Reason    string `json:"reason"`
+}
+
+// Coverage tells how much of the selected cohort a result stands for: the patients selected,
+// those whose values it was computed over, the effective N, and those skipped by reason.
+// Missingness is the share of the selected patients skipped.
+type Coverage struct {
+	Selected    int            `json:"selected"`
+	Contributed int            `json:"contributed"`
+	Skipped     map[string]int `json:"skipped,omitempty" metadata:",optional"`
+	Missingness float64        `json:"missingness"`
+}
+
+// skips tells whether a patient of the cohort was skipped by the execution of the proposal
+func (p *Proposal) skips(pid string) bool {
+	for _, skipped := range p.Skipped {
+		if skipped.PatientID == pid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readMetrics returns the measurements the execution of a proposal reads from each patient
+func (p *Proposal) readMetrics() []string {
+	switch {
+	case len(p.Metrics) == 0:
+		return []string{DefaultMetric}
+	case p.Operation == OperationAnd:
+		return []string{conjunction(p.Metrics[0], p.Metrics[1])}
+	case p.Operation == OperationOr:
+		return []string{p.Metrics[0], p.Metrics[1], conjunction(p.Metrics[0], p.Metrics[1])}
+	}
+
+	return p.Metrics
+}
+
+// incompleteReason returns why a patient can't contribute to a proposal, empty if it can
+func (s *SimpleContract) incompleteReason(ctx contractapi.TransactionContextInterface, pid string, proposal *Proposal, emergency *PublicHealthEmergency) (string, error) {
+	patient, err := s.cohortPatient(ctx, pid, proposal.AsOf)
+
+	if contractError, ok := err.(*ContractError); ok && contractError.Code == CodeNotFound {
+		return SkipArchived, nil
+	} else if ok && contractError.Code == CodeInvalidState {
+		return SkipChangedSinceCutoff, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// Patients without consent are accessed for public health under an emergency
+	err = s.checkConsent(ctx, pid, proposal.RequesterID, proposal.accessPurpose())
+
+	if contractError, ok := err.(*ContractError); ok && contractError.Code == CodePermissionDenied {
+		if emergency == nil {
+			return SkipNoConsent, nil
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	for _, metric := range proposal.readMetrics() {
+		if _, ok := measurement(patient, metric); !ok {
+			return SkipMissingMeasurement, nil
+		}
+	}
+
+	return "", nil
+}
+
+// skipIncomplete leaves out of a cohort the patients that can't contribute to a proposal
+// skipping incomplete patients, returning the patients that contribute and those skipped.
+// Other proposals keep their cohort and fail on the first incomplete patient.
+func (s *SimpleContract) skipIncomplete(ctx contractapi.TransactionContextInterface, proposal *Proposal, pids []string) ([]string, []SkippedPatient, error) {
+	if !proposal.SkipIncomplete {
+		return pids, nil, nil
+	}
+
+	emergency, err := coveringEmergency(ctx, proposal)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contributing := []string{}
+	skipped := []SkippedPatient{}
+
+	for _, pid := range pids {
+		reason, err := s.incompleteReason(ctx, pid, proposal, emergency)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if reason == "" {
+			contributing = append(contributing, pid)
+		} else {
+			skipped = append(skipped, SkippedPatient{PatientID: pid, Reason: reason})
+		}
+	}
+
+	return contributing, skipped, nil
+}
+
+// coverage returns the coverage of an executed proposal. A meta-analysis covers what the
+// results it combines covered, results computed before coverage was recorded counting their
+// cohort as contributed.
+func (s *SimpleContract) coverage(ctx contractapi.TransactionContextInterface, proposal *Proposal) (*Coverage, error) {
+	coverage := &Coverage{Skipped: map[string]int{}}
+
+	if len(proposal.ResultIDs) > 0 {
+		for _, resultID := range proposal.ResultIDs {
+			result, err := s.FindResult(ctx, resultID)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if result.Manifest.Coverage == nil {
+				coverage.Selected += result.Manifest.CohortSize
+				coverage.Contributed += result.Manifest.CohortSize
+
+				continue
+			}
+
+			coverage.Selected += result.Manifest.Coverage.Selected
+			coverage.Contributed += result.Manifest.Coverage.Contributed
+
+			for reason, count := range result.Manifest.Coverage.Skipped {
+				coverage.Skipped[reason] += count
+			}
+		}
+	} else {
+		coverage.Contributed = len(proposal.aggregatedPatients())
+		coverage.Selected = coverage.Contributed + len(proposal.Skipped) + len(proposal.Exclusions)
+
+		for _, skipped := range proposal.Skipped {
+			coverage.Skipped[skipped.Reason]++
+		}
+
+		if len(proposal.Exclusions) > 0 {
+			coverage.Skipped[SkipOutlier] = len(proposal.Exclusions)
+		}
+	}
+
+	if coverage.Selected > 0 {
+		coverage.Missingness = float64(coverage.Selected-coverage.Contributed) / float64(coverage.Selected)
+	}
+
+	return coverage, nil
+}
+
+// SetProposalSkipIncomplete sets whether the execution of a pending proposal leaves out the
+// patients that were deleted, lack consent for its purpose or miss a measurement it reads,
+// instead of failing on them. The skipped patients are recorded on the proposal and counted
+// in the coverage of its result. Proposals combining results have no patients to skip.
+func (s *SimpleContract) SetProposalSkipIncomplete(ctx contractapi.TransactionContextInterface, id string, skip bool) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalPending {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not pending", id)
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can set how %s treats incomplete patients", proposal.RequesterID, id)
+	}
+
+	if len(proposal.ResultIDs) > 0 {
+		return newError(CodeInvalidArgument, map[string]string{"id": id}, "%s combines results, it has no patients to skip", id)
+	}
+
+	proposal.SkipIncomplete = skip
+
+	if err := proposal.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
@@ -0,0 +1,125 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProposalRejected is the status of a proposal the requested org rejected
+const ProposalRejected = "REJECTED"
+
+// Codes of the reasons of approvals and rejections, telling the requester what to do next
+const (
+	// ReasonNote comments an approval, nothing is expected of the requester
+	ReasonNote = "NOTE"
+	// ReasonAmendCohort rejects a proposal until its cohort, operation or expiry is amended
+	ReasonAmendCohort = "AMEND_COHORT"
+	// ReasonFixKey rejects a proposal whose key must be registered, renewed or replaced first
+	ReasonFixKey = "FIX_KEY"
+	// ReasonDeclined rejects a proposal for good, it can't be amended
+	ReasonDeclined = "DECLINED"
+)
+
+var (
+	approvalReasons  = []string{ReasonNote}
+	rejectionReasons = []string{ReasonAmendCohort, ReasonFixKey, ReasonDeclined}
+)
+
+// maxReasonText is the most bytes of free text a reason holds
+const maxReasonText = 1024
+
+// DecisionReason is the reason the requested org gave for approving or rejecting a proposal
+type DecisionReason struct {
+	Code    string             `json:"code"`
+	Text    string             `json:"text,omitempty" metadata:",optional"`
+	Decided TransactionDetails `json:"decided"`
+}
+
+// newDecisionReason returns a reason with one of the codes allowed for the decision
+func newDecisionReason(ctx contractapi.TransactionContextInterface, id string, code string, text string, allowed []string) (*DecisionReason, error) {
+	if !contains(allowed, code) {
+		return nil, newError(CodeInvalidArgument, map[string]string{"id": id, "code": code}, "Reason codes are %s", strings.Join(allowed, ", "))
+	}
+
+	if len(text) > maxReasonText || !utf8.ValidString(text) {
+		return nil, newError(CodeInvalidArgument, map[string]string{"id": id, "length": fmt.Sprint(len(text))}, "The text of a reason is UTF-8 of at most %d bytes", maxReasonText)
+	}
+
+	decided, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &DecisionReason{Code: code, Text: text, Decided: decided}, nil
+}
+
+// message describes a reason in a notification
+func (r *DecisionReason) message() string {
+	if r.Text == "" {
+		return r.Code
+	}
+
+	return r.Code + ": " + r.Text
+}
+
+// ApproveProposalWithReason approves a pending proposal like ApproveProposal, with a comment
+// of code NOTE stored on the proposal and sent to the requester
+func (s *SimpleContract) ApproveProposalWithReason(ctx contractapi.TransactionContextInterface, id string, code string, text string) error {
+	reason, err := newDecisionReason(ctx, id, code, text, approvalReasons)
+
+	if err != nil {
+		return err
+	}
+
+	return s.approveProposal(ctx, id, reason)
+}
+
+// RejectProposal rejects a pending proposal by an admin or a current delegate of the requested
+// org. The reason code tells the requester to amend the proposal, to fix its key, or that it
+// is declined for good, and is stored on the proposal and sent to the requester.
+func (s *SimpleContract) RejectProposal(ctx contractapi.TransactionContextInterface, id string, code string, text string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalPending {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not pending", id)
+	}
+
+	if err := requireApprover(ctx, proposal.RequestedID, id); err != nil {
+		return err
+	}
+
+	reason, err := newDecisionReason(ctx, id, code, text, rejectionReasons)
+
+	if err != nil {
+		return err
+	}
+
+	proposal.Status = ProposalRejected
+	proposal.Reason = reason
+	proposal.Metadata.Updated = reason.Decided
+
+	if err := notify(ctx, proposal.RequesterID, NotificationProposalRejected, id, fmt.Sprintf("%s rejected %s. %s", proposal.RequestedID, id, reason.message())); err != nil {
+		return err
+	}
+
+	if err := emitEvent(ctx, EventProposalRejected, &ProposalEvent{ProposalID: id, Status: proposal.Status, ReasonCode: reason.Code, ReasonText: reason.Text}); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
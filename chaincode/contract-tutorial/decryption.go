@@ -0,0 +1,189 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// decryptionIndex is the composite key namespace of the decryption attestations of each result
+const decryptionIndex = "result~decryption"
+
+// DecryptionAttestation links the hash of the decrypted value of a result to its ciphertext,
+// signed by the owner of the key with the certificate it submitted the attestation with
+type DecryptionAttestation struct {
+	ResultID       string             `json:"resultID"`
+	KeyID          string             `json:"keyID"`
+	CiphertextHash string             `json:"ciphertextHash"`
+	PlaintextHash  string             `json:"plaintextHash"`
+	Signature      string             `json:"signature"`
+	Certificate    string             `json:"certificate"`
+	Submitted      TransactionDetails `json:"submitted"`
+}
+
+// decryptionMessage is what the owner of the key signs to attest the decrypted value of a result
+func decryptionMessage(resultID string, ciphertextHash string, plaintextHash string) []byte {
+	return []byte(fmt.Sprintf("DECRYPT\x00%s\x00%s\x00%s", resultID, ciphertextHash, plaintextHash))
+}
+
+// keyOwner returns the org holding the secret key of a result, the requester for unregistered keys
+func (s *SimpleContract) keyOwner(ctx contractapi.TransactionContextInterface, result *Result) (string, error) {
+	key, err := findKeyRecord(ctx, result.KeyID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if key != nil {
+		return key.OwnerMSP, nil
+	}
+
+	proposal, err := s.FindProposal(ctx, result.ProposalID)
+
+	if err != nil {
+		return "", err
+	}
+
+	return proposal.RequesterID, nil
+}
+
+// SubmitDecryptionAttestation records the hex encoded SHA-256 hash of the decrypted value of a
+// result, as a decimal string, with the base64 encoded ECDSA signature of the caller over the
+// SHA-256 digest of DECRYPT\0<result ID>\0<ciphertext hash>\0<plaintext hash>. Only the owner
+// of the key can submit it, once per result.
+func (s *SimpleContract) SubmitDecryptionAttestation(ctx contractapi.TransactionContextInterface, resultID string, plaintextHash string, signature string) error {
+	result, err := s.FindResult(ctx, resultID)
+
+	if err != nil {
+		return err
+	}
+
+	ownerMSP, err := s.keyOwner(ctx, result)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != ownerMSP {
+		return newError(CodePermissionDenied, map[string]string{"id": resultID, "mspID": mspID}, "Only %s can attest the decryption of %s", ownerMSP, resultID)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(decryptionIndex, []string{resultID})
+
+	if err != nil {
+		return err
+	}
+
+	attestationAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if attestationAsBytes != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": resultID}, "The decryption of %s is already attested", resultID)
+	}
+
+	cert, err := ctx.GetClientIdentity().GetX509Certificate()
+
+	if err != nil || cert == nil {
+		return newError(CodePermissionDenied, map[string]string{"id": resultID}, "Caller has no X.509 certificate")
+	}
+
+	publicKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
+
+	if !ok {
+		return newError(CodeInvalidArgument, nil, "Certificate does not hold an ECDSA key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+
+	if err != nil {
+		return newError(CodeInvalidArgument, nil, "Signature is not base64 encoded")
+	}
+
+	ciphertextHash := hashString(result.Value)
+	digest := sha256.Sum256(decryptionMessage(resultID, ciphertextHash, plaintextHash))
+
+	if !ecdsa.VerifyASN1(publicKey, digest[:], sig) {
+		return newError(CodePermissionDenied, map[string]string{"id": resultID}, "Invalid signature")
+	}
+
+	submitted, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	attestation := DecryptionAttestation{
+		ResultID:       resultID,
+		KeyID:          result.KeyID,
+		CiphertextHash: ciphertextHash,
+		PlaintextHash:  plaintextHash,
+		Signature:      signature,
+		Certificate:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		Submitted:      submitted,
+	}
+
+	attestationAsBytes, _ = json.Marshal(attestation)
+
+	return ctx.GetStub().PutState(key, attestationAsBytes)
+}
+
+// GetDecryptionAttestation returns the decryption attestation of a result
+func (s *SimpleContract) GetDecryptionAttestation(ctx contractapi.TransactionContextInterface, resultID string) (*DecryptionAttestation, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(decryptionIndex, []string{resultID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	attestationAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if attestationAsBytes == nil {
+		return nil, newError(CodeNotFound, map[string]string{"id": resultID}, "The decryption of %s is not attested", resultID)
+	}
+
+	attestation := new(DecryptionAttestation)
+	_ = json.Unmarshal(attestationAsBytes, attestation)
+
+	return attestation, nil
+}
+
+// VerifyDecryptedValue tells whether a claimed plaintext is the attested decryption of a result,
+// whose ciphertext must not have changed since
+func (s *SimpleContract) VerifyDecryptedValue(ctx contractapi.TransactionContextInterface, resultID string, plaintext string) (bool, error) {
+	attestation, err := s.GetDecryptionAttestation(ctx, resultID)
+
+	if err != nil {
+		return false, err
+	}
+
+	result, err := s.FindResult(ctx, resultID)
+
+	if err != nil {
+		return false, err
+	}
+
+	return phewrap.EqualStrings(attestation.CiphertextHash, hashString(result.Value)) && phewrap.EqualStrings(attestation.PlaintextHash, hashString(plaintext)), nil
+}
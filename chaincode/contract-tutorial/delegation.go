@@ -0,0 +1,284 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// delegationIndex is the composite key namespace of the approval delegations of each org
+const delegationIndex = "approval~delegate"
+
+// Delegation statuses
+const (
+	DelegationActive  = "ACTIVE"
+	DelegationRevoked = "REVOKED"
+)
+
+// Delegation lets client identities of an org approve its proposals without being admins,
+// either the identity with a subject or the identities with an attribute=value attribute
+type Delegation struct {
+	ID           string   `json:"id"`
+	CustodianMSP string   `json:"custodianMSP"`
+	Subject      string   `json:"subject,omitempty" metadata:",optional"`
+	Attribute    string   `json:"attribute,omitempty" metadata:",optional"`
+	Expiry       string   `json:"expiry,omitempty" metadata:",optional"`
+	Status       string   `json:"status"`
+	Metadata     Metadata `json:"metadata"`
+}
+
+// delegationKey returns the key of a delegation of an org
+func delegationKey(ctx contractapi.TransactionContextInterface, mspID string, id string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(delegationIndex, []string{mspID, id})
+}
+
+// findDelegation returns a delegation of an org
+func findDelegation(ctx contractapi.TransactionContextInterface, mspID string, id string) (string, *Delegation, error) {
+	key, err := delegationKey(ctx, mspID, id)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	delegationAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if delegationAsBytes == nil {
+		return "", nil, errNotFound(id)
+	}
+
+	delegation := new(Delegation)
+	_ = json.Unmarshal(delegationAsBytes, delegation)
+
+	return key, delegation, nil
+}
+
+// delegates tells whether a delegation currently covers the caller
+func (d *Delegation) delegates(ctx contractapi.TransactionContextInterface, now time.Time) (bool, error) {
+	if d.Status != DelegationActive {
+		return false, nil
+	}
+
+	if timeutil.Expired(d.Expiry, now) {
+		return false, nil
+	}
+
+	if d.Subject != "" {
+		clientID, anonymous, err := callerID(ctx)
+
+		if err != nil {
+			return false, err
+		}
+
+		// The holders of Idemix credentials share their ID, no subject designates one of them
+		return !anonymous && clientID == d.Subject, nil
+	}
+
+	attribute := strings.SplitN(d.Attribute, "=", 2)
+
+	return ctx.GetClientIdentity().AssertAttributeValue(attribute[0], attribute[1]) == nil, nil
+}
+
+// requireApprover refuses callers that are neither admins nor current delegates of the custodian org
+func requireApprover(ctx contractapi.TransactionContextInterface, custodianMSP string, proposalID string) error {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != custodianMSP {
+		return newError(CodePermissionDenied, map[string]string{"id": proposalID, "mspID": mspID}, "Only %s can approve %s", custodianMSP, proposalID)
+	}
+
+	if requireAdmin(ctx) == nil {
+		return nil
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(delegationIndex, []string{custodianMSP})
+
+	if err != nil {
+		return err
+	}
+
+	delegated := false
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		delegation := new(Delegation)
+		_ = json.Unmarshal(queryResponse.Value, delegation)
+
+		ok, err := delegation.delegates(ctx, now)
+
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			delegated = true
+			return ledgeriter.ErrStop
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return queryError(err)
+	}
+
+	if !delegated {
+		return newError(CodePermissionDenied, map[string]string{"id": proposalID, "mspID": mspID}, "Caller is neither an admin nor a delegate of %s", custodianMSP)
+	}
+
+	return nil
+}
+
+// DelegateApproval lets the identity with a subject, or the identities with an attribute given
+// as name=value, approve the proposals of the admin's org until an optional RFC 3339 expiry
+func (s *SimpleContract) DelegateApproval(ctx contractapi.TransactionContextInterface, id string, subject string, attribute string, expiry string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if err := checkIDCharacters(id); err != nil {
+		return err
+	}
+
+	if (subject == "") == (attribute == "") {
+		return newError(CodeInvalidArgument, map[string]string{"id": id}, "A delegation is either to a subject or to an attribute")
+	}
+
+	if attribute != "" && !strings.Contains(attribute, "=") {
+		return newError(CodeInvalidArgument, map[string]string{"attribute": attribute}, "Attribute %s is not of the form name=value", attribute)
+	}
+
+	if expiry != "" {
+		if _, err := parseTimestamp("expiry", expiry); err != nil {
+			return err
+		}
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := delegationKey(ctx, metadata.Created.MSPID, id)
+
+	if err != nil {
+		return err
+	}
+
+	delegationAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if delegationAsBytes != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": id}, "%s already exists", id)
+	}
+
+	delegation := Delegation{
+		ID:           id,
+		CustodianMSP: metadata.Created.MSPID,
+		Subject:      subject,
+		Attribute:    attribute,
+		Expiry:       expiry,
+		Status:       DelegationActive,
+		Metadata:     metadata,
+	}
+
+	delegationAsBytes, _ = json.Marshal(delegation)
+
+	return ctx.GetStub().PutState(key, delegationAsBytes)
+}
+
+// RevokeDelegation ends a delegation of the admin's org
+func (s *SimpleContract) RevokeDelegation(ctx contractapi.TransactionContextInterface, id string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, delegation, err := findDelegation(ctx, mspID, id)
+
+	if err != nil {
+		return err
+	}
+
+	if delegation.Status == DelegationRevoked {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s is already revoked", id)
+	}
+
+	delegation.Status = DelegationRevoked
+
+	if err := delegation.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	delegationAsBytes, _ := json.Marshal(delegation)
+
+	return ctx.GetStub().PutState(key, delegationAsBytes)
+}
+
+// DelegationPage is a page of delegations
+type DelegationPage struct {
+	Records   []Delegation `json:"records"`
+	Bookmark  string       `json:"bookmark"`
+	Count     int32        `json:"count"`
+	Truncated bool         `json:"truncated"`
+}
+
+// GetDelegations returns a page of the delegations of the caller's org, revoked ones included
+func (s *SimpleContract) GetDelegations(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*DelegationPage, error) {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectTypedPage[Delegation](queryContext(), indexPages(ctx, delegationIndex, []string{mspID}), size, bookmark)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &DelegationPage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
@@ -0,0 +1,280 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// deletionProofIndex is the composite key namespace of the deletion proofs
+const deletionProofIndex = "deletion~proof"
+
+// Statuses of a deletion proof
+const (
+	DeletionPending  = "PENDING"
+	DeletionVerified = "VERIFIED"
+)
+
+// DeletionAttestation is the signature of an admin of the custodian stating its peers no
+// longer hold a purged key, with the certificate it was made with
+type DeletionAttestation struct {
+	Signer      string             `json:"signer"`
+	Certificate string             `json:"certificate"`
+	Signature   string             `json:"signature"`
+	Submitted   TransactionDetails `json:"submitted"`
+}
+
+// DeletionProof records the purge of a key of a private data collection by its custodian.
+// The key and its value are only kept as their hex encoded SHA-256 hashes. Purged is the
+// purge transaction, and the proof is verified once Attestors distinct admins of the
+// custodian attested the erasure.
+type DeletionProof struct {
+	ID           string                `json:"id"`
+	Collection   string                `json:"collection"`
+	KeyHash      string                `json:"keyHash"`
+	ValueHash    string                `json:"valueHash"`
+	CustodianMSP string                `json:"custodianMSP"`
+	Attestors    int                   `json:"attestors"`
+	Attestations []DeletionAttestation `json:"attestations"`
+	Status       string                `json:"status"`
+	Purged       TransactionDetails    `json:"purged"`
+	Metadata     Metadata              `json:"metadata"`
+}
+
+// deletionMessage is what the admins of the custodian sign to attest the erasure of a key
+func deletionMessage(proof *DeletionProof) []byte {
+	return []byte(fmt.Sprintf("PURGED\x00%s\x00%s\x00%s\x00%s", proof.ID, proof.Collection, proof.KeyHash, proof.Purged.TxID))
+}
+
+// findDeletionProof returns a deletion proof, nil if there is none
+func findDeletionProof(ctx contractapi.TransactionContextInterface, id string) (string, *DeletionProof, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(deletionProofIndex, []string{id})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	proofAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if proofAsBytes == nil {
+		return key, nil, nil
+	}
+
+	proof := new(DeletionProof)
+	_ = json.Unmarshal(proofAsBytes, proof)
+
+	return key, proof, nil
+}
+
+// transientKey returns the private data key passed in the "key" transient field, so the key
+// isn't written to the ledger with the arguments of the transaction
+func transientKey(ctx contractapi.TransactionContextInterface) (string, error) {
+	transMap, err := ctx.GetStub().GetTransient()
+
+	if err != nil {
+		return "", fmt.Errorf("Error getting transient. %s", err.Error())
+	}
+
+	key, ok := transMap["key"]
+
+	if !ok || len(key) == 0 {
+		return "", newError(CodeInvalidArgument, nil, "key not found in the transient map")
+	}
+
+	return string(key), nil
+}
+
+// PurgePrivateData deletes the key passed in the "key" transient field from a private data
+// collection of the caller's org, its implicit collection if none is given, and records a
+// deletion proof awaiting the attestations of attestors admins of the org. Only admins purge.
+func (s *SimpleContract) PurgePrivateData(ctx contractapi.TransactionContextInterface, id string, collection string, attestors int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if attestors < 1 {
+		return newError(CodeInvalidArgument, map[string]string{"attestors": fmt.Sprint(attestors)}, "A deletion proof needs at least one attestor")
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	own, err := implicitCollection(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if collection == "" {
+		collection = own
+	}
+
+	if strings.HasPrefix(collection, "_implicit_org_") && collection != own {
+		return newError(CodePermissionDenied, map[string]string{"collection": collection, "mspID": mspID}, "%s can only purge its own implicit collection", mspID)
+	}
+
+	proofKey, existing, err := findDeletionProof(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": id}, "Deletion proof %s already exists", id)
+	}
+
+	key, err := transientKey(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	valueAsBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from private data. %s", err.Error())
+	}
+
+	if valueAsBytes == nil {
+		return newError(CodeNotFound, map[string]string{"id": id, "collection": collection}, "The key is not in %s", collection)
+	}
+
+	if err := ctx.GetStub().DelPrivateData(collection, key); err != nil {
+		return err
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	valueHash := sha256.Sum256(valueAsBytes)
+
+	proof := DeletionProof{
+		ID:           id,
+		Collection:   collection,
+		KeyHash:      hashString(key),
+		ValueHash:    hex.EncodeToString(valueHash[:]),
+		CustodianMSP: mspID,
+		Attestors:    attestors,
+		Attestations: []DeletionAttestation{},
+		Status:       DeletionPending,
+		Purged:       metadata.Created,
+		Metadata:     metadata,
+	}
+
+	proofAsBytes, _ := json.Marshal(proof)
+
+	return ctx.GetStub().PutState(proofKey, proofAsBytes)
+}
+
+// AttestDeletion adds to a deletion proof the signature of an admin of the custodian, made
+// off-chain once its peers no longer hold the key, over the proof ID, the collection, the key
+// hash and the purge transaction ID. Anyone can submit it, and each admin attests once.
+func (s *SimpleContract) AttestDeletion(ctx contractapi.TransactionContextInterface, id string, certPEM string, signature string) error {
+	proofKey, proof, err := findDeletionProof(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proof == nil {
+		return newError(CodeNotFound, map[string]string{"id": id}, "Deletion proof %s does not exist", id)
+	}
+
+	cert, err := verifyAttestation(ctx, proof.CustodianMSP, deletionMessage(proof), certPEM, signature)
+
+	if err != nil {
+		return withDetail(err, "id", id)
+	}
+
+	// Recorded in the format of the client identity IDs, as attested approvals are
+	signer := base64.StdEncoding.EncodeToString([]byte("x509::" + cert.Subject.String() + "::" + cert.Issuer.String()))
+
+	for _, attestation := range proof.Attestations {
+		if attestation.Signer == signer {
+			return newError(CodeAlreadyExists, map[string]string{"id": id}, "%s already attested %s", cert.Subject.CommonName, id)
+		}
+	}
+
+	submitted, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	proof.Attestations = append(proof.Attestations, DeletionAttestation{Signer: signer, Certificate: certPEM, Signature: signature, Submitted: submitted})
+
+	if len(proof.Attestations) >= proof.Attestors {
+		proof.Status = DeletionVerified
+	}
+
+	if err := proof.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	proofAsBytes, _ := json.Marshal(proof)
+
+	return ctx.GetStub().PutState(proofKey, proofAsBytes)
+}
+
+// GetDeletionProof returns a deletion proof
+func (s *SimpleContract) GetDeletionProof(ctx contractapi.TransactionContextInterface, id string) (*DeletionProof, error) {
+	_, proof, err := findDeletionProof(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if proof == nil {
+		return nil, newError(CodeNotFound, map[string]string{"id": id}, "Deletion proof %s does not exist", id)
+	}
+
+	return proof, nil
+}
+
+// VerifyDeletionProof tells whether the erasure of a deletion proof is attested by enough
+// distinct admins of the custodian, checking their signatures again against the registered
+// roots of the custodian, which may have been rotated since
+func (s *SimpleContract) VerifyDeletionProof(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	proof, err := s.GetDeletionProof(ctx, id)
+
+	if err != nil {
+		return false, err
+	}
+
+	signers := []string{}
+
+	for _, attestation := range proof.Attestations {
+		if _, err := verifyAttestation(ctx, proof.CustodianMSP, deletionMessage(proof), attestation.Certificate, attestation.Signature); err != nil {
+			if _, ok := err.(*ContractError); ok {
+				continue
+			}
+
+			return false, err
+		}
+
+		if !contains(signers, attestation.Signer) {
+			signers = append(signers, attestation.Signer)
+		}
+	}
+
+	return len(signers) >= proof.Attestors, nil
+}
@@ -0,0 +1,284 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// measurementIndex is the composite key namespace of the measurement definitions of each custodian by metric
+const measurementIndex = "measurement~definition"
+
+// MeasurementDefinition is what a custodian means by a metric: the unit of its values, the
+// scale they are encoded at, the encoded value being the value times the scale, and the
+// range of the encoded values. Glucose in mg/dL at scale 10 encodes 95.3 mg/dL as 953.
+type MeasurementDefinition struct {
+	CustodianMSP string   `json:"custodianMSP"`
+	Metric       string   `json:"metric"`
+	Unit         string   `json:"unit"`
+	Scale        int      `json:"scale"`
+	Min          int      `json:"min"`
+	Max          int      `json:"max"`
+	Metadata     Metadata `json:"metadata"`
+}
+
+// MeasurementSemantics is the unit and scale of a metric. Values of a metric only add up or
+// compare when both match.
+type MeasurementSemantics struct {
+	Metric string `json:"metric"`
+	Unit   string `json:"unit"`
+	Scale  int    `json:"scale"`
+}
+
+// semantics returns the unit and scale of a definition
+func (d *MeasurementDefinition) semantics() MeasurementSemantics {
+	return MeasurementSemantics{Metric: d.Metric, Unit: d.Unit, Scale: d.Scale}
+}
+
+// findMeasurementDefinition returns the definition of a metric by a custodian, nil if it defined none
+func findMeasurementDefinition(ctx contractapi.TransactionContextInterface, custodianMSP string, metric string) (*MeasurementDefinition, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(measurementIndex, []string{custodianMSP, metric})
+
+	if err != nil {
+		return nil, err
+	}
+
+	definitionAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if definitionAsBytes == nil {
+		return nil, nil
+	}
+
+	definition := new(MeasurementDefinition)
+	_ = json.Unmarshal(definitionAsBytes, definition)
+
+	return definition, nil
+}
+
+// findSemantics returns the semantics of a metric among others, nil if they don't hold it
+func findSemantics(semantics []MeasurementSemantics, metric string) *MeasurementSemantics {
+	for i := range semantics {
+		if semantics[i].Metric == metric {
+			return &semantics[i]
+		}
+	}
+
+	return nil
+}
+
+// checkDefinitions refuses a proposal whose requester expects a metric to mean other than what
+// the custodian defined it as, or that the custodian didn't define. It is checked on approval
+// and on every execution, as the custodian may redefine a metric in between.
+func checkDefinitions(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal) error {
+	for _, expected := range proposal.Definitions {
+		definition, err := findMeasurementDefinition(ctx, proposal.RequestedID, expected.Metric)
+
+		if err != nil {
+			return err
+		}
+
+		if definition == nil {
+			return newError(CodeInvalidState, map[string]string{"id": id, "metric": expected.Metric, "custodianMSP": proposal.RequestedID}, "%s did not define %s", proposal.RequestedID, expected.Metric)
+		}
+
+		if definition.semantics() != expected {
+			return newError(CodeInvalidState, map[string]string{"id": id, "metric": expected.Metric, "unit": definition.Unit, "scale": fmt.Sprint(definition.Scale)}, "%s expects %s in %s at scale %d, %s defines it in %s at scale %d", id, expected.Metric, expected.Unit, expected.Scale, proposal.RequestedID, definition.Unit, definition.Scale)
+		}
+	}
+
+	return nil
+}
+
+// checkCompatibleDefinitions refuses to aggregate results whose manifests define a metric
+// differently. Results computed before their custodian defined a metric don't tell.
+func checkCompatibleDefinitions(resultID string, manifest Manifest, sourceID string, source Manifest) error {
+	for _, semantics := range manifest.Definitions {
+		other := findSemantics(source.Definitions, semantics.Metric)
+
+		if other != nil && *other != semantics {
+			return newError(CodeInvalidArgument, map[string]string{"resultID": resultID, "metric": semantics.Metric, "unit": semantics.Unit, "scale": fmt.Sprint(semantics.Scale)}, "%s has %s in %s at scale %d, %s in %s at scale %d", resultID, semantics.Metric, semantics.Unit, semantics.Scale, sourceID, other.Unit, other.Scale)
+		}
+	}
+
+	return nil
+}
+
+// manifestDefinitions returns the semantics of the metrics an executed proposal computed: those
+// its custodian defined, or for a meta-analysis those of the results it combines
+func (s *SimpleContract) manifestDefinitions(ctx contractapi.TransactionContextInterface, proposal *Proposal, metrics []string) ([]MeasurementSemantics, error) {
+	known := []MeasurementSemantics{}
+
+	for _, resultID := range proposal.ResultIDs {
+		result, err := s.FindResult(ctx, resultID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		known = append(known, result.Manifest.Definitions...)
+	}
+
+	definitions := []MeasurementSemantics{}
+
+	for _, metric := range metrics {
+		if len(proposal.ResultIDs) > 0 {
+			if semantics := findSemantics(known, metric); semantics != nil {
+				definitions = append(definitions, *semantics)
+			}
+
+			continue
+		}
+
+		definition, err := findMeasurementDefinition(ctx, proposal.RequestedID, metric)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if definition != nil {
+			definitions = append(definitions, definition.semantics())
+		}
+	}
+
+	return definitions, nil
+}
+
+// DefineMeasurement defines a metric of the patients of the org of the caller: its unit, the
+// scale its values are encoded at and the range of the encoded values, replacing its former
+// definition. preExistingConditions is defined as any other metric. Only admins define
+// measurements.
+func (s *SimpleContract) DefineMeasurement(ctx contractapi.TransactionContextInterface, metric string, unit string, scale int, min int, max int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if err := checkIDCharacters(metric); err != nil {
+		return err
+	}
+
+	if unit == "" {
+		return newError(CodeInvalidArgument, map[string]string{"metric": metric}, "The unit of %s can't be empty", metric)
+	}
+
+	if scale < 1 {
+		return newError(CodeInvalidArgument, map[string]string{"metric": metric, "scale": fmt.Sprint(scale)}, "The scale of %s must be at least 1", metric)
+	}
+
+	if min > max {
+		return newError(CodeInvalidArgument, map[string]string{"metric": metric, "min": fmt.Sprint(min), "max": fmt.Sprint(max)}, "The range of %s is empty", metric)
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	definition, err := findMeasurementDefinition(ctx, mspID, metric)
+
+	if err != nil {
+		return err
+	}
+
+	if definition == nil {
+		definition = &MeasurementDefinition{CustodianMSP: mspID, Metric: metric}
+		definition.Metadata, err = newMetadata(ctx)
+	} else {
+		err = definition.Metadata.touch(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	definition.Unit, definition.Scale, definition.Min, definition.Max = unit, scale, min, max
+
+	key, err := ctx.GetStub().CreateCompositeKey(measurementIndex, []string{mspID, metric})
+
+	if err != nil {
+		return err
+	}
+
+	definitionAsBytes, _ := json.Marshal(definition)
+
+	return ctx.GetStub().PutState(key, definitionAsBytes)
+}
+
+// FindMeasurementDefinition returns the definition of a metric by a custodian
+func (s *SimpleContract) FindMeasurementDefinition(ctx contractapi.TransactionContextInterface, custodianMSP string, metric string) (*MeasurementDefinition, error) {
+	definition, err := findMeasurementDefinition(ctx, custodianMSP, metric)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if definition == nil {
+		return nil, newError(CodeNotFound, map[string]string{"custodianMSP": custodianMSP, "metric": metric}, "%s did not define %s", custodianMSP, metric)
+	}
+
+	return definition, nil
+}
+
+// SetProposalDefinition declares the unit and scale the requester of a pending proposal expects
+// a metric of it in, replacing its former expectation of the metric. The proposal is approved
+// and executed only while its custodian defines the metric the same way.
+func (s *SimpleContract) SetProposalDefinition(ctx contractapi.TransactionContextInterface, id string, metric string, unit string, scale int) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalPending {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not pending", id)
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can set the definitions of %s", proposal.RequesterID, id)
+	}
+
+	metrics := proposal.Metrics
+
+	if len(metrics) == 0 {
+		metrics = []string{DefaultMetric}
+	}
+
+	if !contains(metrics, metric) {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "metric": metric}, "%s does not compute %s", id, metric)
+	}
+
+	if unit == "" || scale < 1 {
+		return newError(CodeInvalidArgument, map[string]string{"metric": metric, "scale": fmt.Sprint(scale)}, "A definition needs a unit and a scale of at least 1")
+	}
+
+	expected := MeasurementSemantics{Metric: metric, Unit: unit, Scale: scale}
+
+	if semantics := findSemantics(proposal.Definitions, metric); semantics != nil {
+		*semantics = expected
+	} else {
+		proposal.Definitions = append(proposal.Definitions, expected)
+	}
+
+	if err := proposal.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
@@ -0,0 +1,84 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// OperationDistinctDiagnoses counts the distinct diagnoses of the cohort, and the patients of each
+const OperationDistinctDiagnoses = "DISTINCT_DIAGNOSES"
+
+// CreateDistinctDiagnosesProposal requests the number of distinct diagnoses of the cohort and
+// the encrypted number of patients with each. Diagnosis IDs aren't encrypted, so the number of
+// diagnoses is computed in the clear. The contract can't encrypt, so each patient is counted
+// with a flag holding an encrypted 1, which the custodian sets on every patient.
+func (s *SimpleContract) CreateDistinctDiagnosesProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, flag string, expiry string) error {
+	if done, err := beginRequest(ctx, "CreateDistinctDiagnosesProposal", id, protocolID, requesterID, requestedID, patientsIDs, keyID, flag, expiry); err != nil || done {
+		return err
+	}
+
+	if flag == "" || flag == DefaultMetric {
+		return newError(CodeInvalidArgument, map[string]string{"flag": flag}, "Patients are counted with a flag holding an encrypted 1")
+	}
+
+	proposal, err := s.proposeCohort(ctx, id, protocolID, requesterID, requestedID, patientsIDs, keyID, OperationDistinctDiagnoses, expiry, []string{flag})
+
+	if err != nil {
+		return err
+	}
+
+	proposal.GroupBy = GroupByDiagnosis
+
+	return s.storeNewProposal(ctx, id, proposal)
+}
+
+// diagnosisCounts sums the flag of the proposal within every diagnosis of the cohort, which
+// counts its patients, and returns the encrypted counts with the number of diagnoses. Unlike
+// grouped means, the group sizes stay encrypted.
+func (s *SimpleContract) diagnosisCounts(ctx contractapi.TransactionContextInterface, proposal *Proposal, pids []string, modulo string) (map[string]string, int, error) {
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	counts := map[string]phewrap.Ciphertext{}
+
+	for _, pid := range pids {
+		patient, err := s.cohortPatient(ctx, pid, proposal.AsOf)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		value, ok := measurement(patient, proposal.Metrics[0])
+
+		if !ok {
+			return nil, 0, newError(CodeNotFound, map[string]string{"id": pid, "metric": proposal.Metrics[0]}, "%s has no %s measurement", pid, proposal.Metrics[0])
+		}
+
+		c, err := parseCiphertext(pid, value)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if _, ok := counts[patient.DiagnosisID]; !ok {
+			counts[patient.DiagnosisID] = phewrap.Zero()
+		}
+
+		counts[patient.DiagnosisID] = q.Add(counts[patient.DiagnosisID], c)
+	}
+
+	values := map[string]string{}
+
+	for diagnosisID, count := range counts {
+		values[diagnosisID] = count.String()
+	}
+
+	return values, len(values), nil
+}
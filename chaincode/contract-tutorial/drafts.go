@@ -0,0 +1,172 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/payloadschema"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// DraftPatient is a work-in-progress patient kept in the org's implicit collection
+type DraftPatient struct {
+	Patient  PatientInput `json:"patient"`
+	Metadata Metadata     `json:"metadata"`
+}
+
+// implicitCollection returns the implicit private collection of the caller's org
+func implicitCollection(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	return "_implicit_org_" + mspID, nil
+}
+
+// validatePatientInput checks a patient is complete before it is published to shared state
+func validatePatientInput(p PatientInput) error {
+	required := [][2]string{{"id", p.ID}, {"name", p.Name}, {"diagnosisID", p.DiagnosisID}, {"statusID", p.StatusID}, {"keyID", p.KeyID}}
+
+	for _, field := range required {
+		if field[1] == "" {
+			return newError(CodeInvalidArgument, map[string]string{"id": p.ID, "field": field[0]}, "%s is required", field[0])
+		}
+	}
+
+	if _, err := phewrap.ParseCiphertext(p.PreExistingConditions); err != nil {
+		return newError(CodeInvalidArgument, map[string]string{"id": p.ID, "field": "preExistingConditions"}, "preExistingConditions is not a phe ciphertext")
+	}
+
+	return nil
+}
+
+// SaveDraftPatient stores the patient passed in the "patient" transient field in
+// the caller org's implicit collection, keeping incomplete data off the shared ledger.
+// It must be endorsed by a peer of the caller's org only.
+func (s *SimpleContract) SaveDraftPatient(ctx contractapi.TransactionContextInterface) error {
+	transMap, err := ctx.GetStub().GetTransient()
+
+	if err != nil {
+		return fmt.Errorf("Error getting transient. %s", err.Error())
+	}
+
+	patientAsBytes, ok := transMap["patient"]
+
+	if !ok {
+		return newError(CodeInvalidArgument, nil, "patient not found in the transient map")
+	}
+
+	draft := DraftPatient{}
+
+	if err := json.Unmarshal(patientAsBytes, &draft.Patient); err != nil {
+		return newError(CodeInvalidArgument, nil, "patient in the transient map is not valid JSON")
+	}
+
+	if err := validatePayload(payloadschema.DraftPatient, patientAsBytes); err != nil {
+		return err
+	}
+
+	collection, err := implicitCollection(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	previous, err := findDraft(ctx, collection, draft.Patient.ID)
+
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		draft.Metadata = previous.Metadata
+		err = draft.Metadata.touch(ctx)
+	} else {
+		draft.Metadata, err = newMetadata(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	draftAsBytes, _ := json.Marshal(draft)
+
+	return ctx.GetStub().PutPrivateData(collection, draft.Patient.ID, draftAsBytes)
+}
+
+// findDraft returns a draft of the collection, or nil if it does not exist
+func findDraft(ctx contractapi.TransactionContextInterface, collection string, id string) (*DraftPatient, error) {
+	draftAsBytes, err := ctx.GetStub().GetPrivateData(collection, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from private data. %s", err.Error())
+	}
+
+	if draftAsBytes == nil {
+		return nil, nil
+	}
+
+	draft := new(DraftPatient)
+	_ = json.Unmarshal(draftAsBytes, draft)
+
+	return draft, nil
+}
+
+// FindDraftPatient returns a draft of the caller's org
+func (s *SimpleContract) FindDraftPatient(ctx contractapi.TransactionContextInterface, id string) (*DraftPatient, error) {
+	collection, err := implicitCollection(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	draft, err := findDraft(ctx, collection, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if draft == nil {
+		return nil, errNotFound(id)
+	}
+
+	return draft, nil
+}
+
+// PromoteDraft validates a draft of the caller's org and publishes it as a patient
+func (s *SimpleContract) PromoteDraft(ctx contractapi.TransactionContextInterface, id string) error {
+	draft, err := s.FindDraftPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := validatePatientInput(draft.Patient); err != nil {
+		return err
+	}
+
+	if err := checkIDUnused(ctx, id); err != nil {
+		return err
+	}
+
+	p := draft.Patient
+
+	if err := s.CreatePatient(ctx, id, p.Name, p.PreExistingConditions, p.DiagnosisID, p.StatusID, p.KeyID); err != nil {
+		return err
+	}
+
+	collection, err := implicitCollection(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelPrivateData(collection, id)
+}
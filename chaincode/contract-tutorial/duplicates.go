@@ -0,0 +1,148 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// proposalContentIndex is the composite key namespace of the proposals by the hash of their content
+const proposalContentIndex = "proposal~content"
+
+// defaultDuplicateWindow is how long an identical proposal is refused unless the configuration sets another
+const defaultDuplicateWindow = 24 * time.Hour
+
+// contentHash returns the hash of what a proposal computes: its requester, cohort in any order,
+// operation with its metrics, grouping and combined results, and key
+func contentHash(proposal *Proposal) string {
+	pids := strings.Split(proposal.PatientsIDs, ",")
+	sort.Strings(pids)
+
+	return hashString(strings.Join([]string{
+		proposal.RequesterID,
+		strings.Join(pids, ","),
+		proposal.Operation,
+		strings.Join(proposal.Metrics, ","),
+		proposal.GroupBy,
+		strings.Join(proposal.ResultIDs, ","),
+		proposal.KeyID,
+	}, "\x00"))
+}
+
+// duplicateWindow returns how long an identical proposal is refused
+func duplicateWindow(ctx contractapi.TransactionContextInterface) (time.Duration, error) {
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if config.DuplicateWindow > 0 {
+		return time.Duration(config.DuplicateWindow) * time.Second, nil
+	}
+
+	return defaultDuplicateWindow, nil
+}
+
+// checkDuplicate refuses a new proposal identical to one created within the duplicate window,
+// naming the existing proposal, and indexes the content hash of the new one otherwise
+func checkDuplicate(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal) error {
+	proposal.ContentHash = contentHash(proposal)
+
+	window, err := duplicateWindow(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(proposalContentIndex, []string{proposal.ContentHash})
+
+	if err != nil {
+		return err
+	}
+
+	existingID := ""
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		created := new(TransactionDetails)
+		_ = json.Unmarshal(queryResponse.Value, created)
+
+		t, err := timeutil.Parse(created.Timestamp)
+
+		if err == nil && now.Sub(t) < window {
+			existingID = attributes[1]
+			return ledgeriter.ErrStop
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return queryError(err)
+	}
+
+	if existingID != "" {
+		return newError(CodeAlreadyExists, map[string]string{"id": existingID, "contentHash": proposal.ContentHash}, "%s duplicates %s, created less than %s ago", id, existingID, window)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(proposalContentIndex, []string{proposal.ContentHash, id})
+
+	if err != nil {
+		return err
+	}
+
+	createdAsBytes, _ := json.Marshal(proposal.Metadata.Created)
+
+	return ctx.GetStub().PutState(key, createdAsBytes)
+}
+
+// SetDuplicateWindow sets for how many seconds a proposal identical to an earlier one is refused.
+// A window of 0 restores the built-in one of a day.
+func (s *SimpleContract) SetDuplicateWindow(ctx contractapi.TransactionContextInterface, seconds int64) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if seconds < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"seconds": fmt.Sprint(seconds)}, "The duplicate window can't be negative")
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.DuplicateWindow = seconds
+
+	return putConfig(ctx, config)
+}
@@ -0,0 +1,105 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// embargoed tells whether an optional embargo is still in force at now
+func embargoed(embargo string, now time.Time) bool {
+	return embargo != "" && !timeutil.Expired(embargo, now)
+}
+
+// checkEmbargo refuses to read a result under embargo to orgs other than the requester and
+// the custodian of its proposal
+func (s *SimpleContract) checkEmbargo(ctx contractapi.TransactionContextInterface, id string, result *Result) error {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if !embargoed(result.Embargo, now) {
+		return nil
+	}
+
+	proposal, err := s.FindProposal(ctx, result.ProposalID)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != proposal.RequesterID && mspID != proposal.RequestedID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "embargo": result.Embargo}, "%s is under embargo until %s", id, result.Embargo)
+	}
+
+	return nil
+}
+
+// SetResultEmbargo sets the time until which a result is only readable by the requester and
+// the custodian of its proposal, an empty embargo lifting it. Once the embargo lifts, the
+// result and its publication are readable by the whole channel. Only the requester sets the
+// embargo of its results, and what was read before it was set stays read.
+func (s *SimpleContract) SetResultEmbargo(ctx contractapi.TransactionContextInterface, id string, embargo string) error {
+	if embargo != "" {
+		if _, err := parseTimestamp("embargo", embargo); err != nil {
+			return err
+		}
+	}
+
+	result, err := s.FindResult(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	proposal, err := s.FindProposal(ctx, result.ProposalID)
+
+	if err != nil {
+		return err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": details.MSPID}, "Only %s can set the embargo of %s", proposal.RequesterID, id)
+	}
+
+	result.Embargo = embargo
+	result.Metadata.Updated = details
+
+	resultAsBytes, _ := json.Marshal(result)
+
+	if err := ctx.GetStub().PutState(id, resultAsBytes); err != nil {
+		return err
+	}
+
+	// The publication of the result, if any, shares its embargo
+	key, publication, err := findResultPublication(ctx, id)
+
+	if err != nil || publication == nil {
+		return err
+	}
+
+	publication.Embargo = embargo
+	publicationAsBytes, _ := json.Marshal(publication)
+
+	return ctx.GetStub().PutState(key, publicationAsBytes)
+}
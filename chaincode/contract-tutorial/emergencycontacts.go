@@ -0,0 +1,222 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/payloadschema"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// clinicianRole is the role of the callers allowed to handle the emergency contacts of patients
+const clinicianRole = "clinician"
+
+// emergencyContactIndex is the composite key namespace of the emergency contacts of each
+// patient, in the implicit collection of its custodian
+const emergencyContactIndex = "contact~patient"
+
+// EmergencyContact is a person to reach in an emergency, the next of kin among them
+type EmergencyContact struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Relationship string `json:"relationship,omitempty" metadata:",optional"`
+	Phone        string `json:"phone,omitempty" metadata:",optional"`
+	Email        string `json:"email,omitempty" metadata:",optional"`
+	Address      string `json:"address,omitempty" metadata:",optional"`
+	NextOfKin    bool   `json:"nextOfKin,omitempty" metadata:",optional"`
+}
+
+// EmergencyContacts are the emergency contacts of a patient, kept off the shared ledger
+type EmergencyContacts struct {
+	PatientID string             `json:"patientID"`
+	Contacts  []EmergencyContact `json:"contacts"`
+	Metadata  Metadata           `json:"metadata"`
+}
+
+// contactsCollection returns the collection holding the emergency contacts of a patient and
+// their key in it. Only clinicians of the custodian of the patient handle them.
+func contactsCollection(ctx contractapi.TransactionContextInterface, patientID string) (string, string, error) {
+	return clinicianCollection(ctx, patientID, emergencyContactIndex, "emergency contacts")
+}
+
+// clinicianCollection returns the implicit collection of the custodian of a patient and the
+// key of the patient in an index of it, refusing callers other than its clinicians. subject
+// names what the index holds in the error.
+func clinicianCollection(ctx contractapi.TransactionContextInterface, patientID string, index string, subject string) (string, string, error) {
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	role, err := callerRole(ctx)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if mspID != patient.custodian() || role != clinicianRole {
+		return "", "", newError(CodePermissionDenied, map[string]string{"id": patientID, "mspID": mspID}, "Only clinicians of %s can handle the %s of %s", patient.custodian(), subject, patientID)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(index, []string{patientID})
+
+	if err != nil {
+		return "", "", err
+	}
+
+	collection, err := implicitCollection(ctx)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return collection, key, nil
+}
+
+// findEmergencyContacts returns the emergency contacts of a patient, nil if it has none
+func findEmergencyContacts(ctx contractapi.TransactionContextInterface, collection string, key string) (*EmergencyContacts, error) {
+	contactsAsBytes, err := ctx.GetStub().GetPrivateData(collection, key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from private data. %s", err.Error())
+	}
+
+	if contactsAsBytes == nil {
+		return nil, nil
+	}
+
+	contacts := new(EmergencyContacts)
+	_ = json.Unmarshal(contactsAsBytes, contacts)
+
+	return contacts, nil
+}
+
+// transientContact returns the emergency contact passed in the "contact" transient field
+func transientContact(ctx contractapi.TransactionContextInterface) (EmergencyContact, error) {
+	contact := EmergencyContact{}
+
+	transMap, err := ctx.GetStub().GetTransient()
+
+	if err != nil {
+		return contact, fmt.Errorf("Error getting transient. %s", err.Error())
+	}
+
+	contactAsBytes, ok := transMap["contact"]
+
+	if !ok {
+		return contact, newError(CodeInvalidArgument, nil, "contact not found in the transient map")
+	}
+
+	if err := validatePayload(payloadschema.EmergencyContact, contactAsBytes); err != nil {
+		return contact, err
+	}
+
+	_ = json.Unmarshal(contactAsBytes, &contact)
+
+	return contact, nil
+}
+
+// putEmergencyContact adds the contact passed in the "contact" transient field to the
+// emergency contacts of a patient, or replaces the one with its ID
+func putEmergencyContact(ctx contractapi.TransactionContextInterface, patientID string, replace bool) error {
+	collection, key, err := contactsCollection(ctx, patientID)
+
+	if err != nil {
+		return err
+	}
+
+	contact, err := transientContact(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	contacts, err := findEmergencyContacts(ctx, collection, key)
+
+	if err != nil {
+		return err
+	}
+
+	if contacts == nil {
+		contacts = &EmergencyContacts{PatientID: patientID, Contacts: []EmergencyContact{}}
+		contacts.Metadata, err = newMetadata(ctx)
+	} else {
+		err = contacts.Metadata.touch(ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	position := -1
+
+	for i := range contacts.Contacts {
+		if contacts.Contacts[i].ID == contact.ID {
+			position = i
+		}
+	}
+
+	if replace && position < 0 {
+		return newError(CodeNotFound, map[string]string{"id": patientID, "contactID": contact.ID}, "%s has no emergency contact %s", patientID, contact.ID)
+	}
+
+	if !replace && position >= 0 {
+		return newError(CodeAlreadyExists, map[string]string{"id": patientID, "contactID": contact.ID}, "%s already has an emergency contact %s", patientID, contact.ID)
+	}
+
+	if replace {
+		contacts.Contacts[position] = contact
+	} else {
+		contacts.Contacts = append(contacts.Contacts, contact)
+	}
+
+	contactsAsBytes, _ := json.Marshal(contacts)
+
+	return ctx.GetStub().PutPrivateData(collection, key, contactsAsBytes)
+}
+
+// AddEmergencyContact adds the contact passed in the "contact" transient field to the
+// emergency contacts of a patient, kept in the implicit collection of its custodian. Only
+// callers of the custodian with the clinician role add them, and only the peers of the
+// custodian must endorse it.
+func (s *SimpleContract) AddEmergencyContact(ctx contractapi.TransactionContextInterface, patientID string) error {
+	return putEmergencyContact(ctx, patientID, false)
+}
+
+// UpdateEmergencyContact replaces the emergency contact of a patient with the ID of the
+// contact passed in the "contact" transient field
+func (s *SimpleContract) UpdateEmergencyContact(ctx contractapi.TransactionContextInterface, patientID string) error {
+	return putEmergencyContact(ctx, patientID, true)
+}
+
+// GetEmergencyContacts returns the emergency contacts of a patient to the clinicians of its custodian
+func (s *SimpleContract) GetEmergencyContacts(ctx contractapi.TransactionContextInterface, patientID string) (*EmergencyContacts, error) {
+	collection, key, err := contactsCollection(ctx, patientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, err := findEmergencyContacts(ctx, collection, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if contacts == nil {
+		return nil, newError(CodeNotFound, map[string]string{"id": patientID}, "%s has no emergency contacts", patientID)
+	}
+
+	return contacts, nil
+}
@@ -0,0 +1,59 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codes of the business failures of the contract
+const (
+	CodeNotFound         = "NOT_FOUND"
+	CodeAlreadyExists    = "ALREADY_EXISTS"
+	CodeInvalidArgument  = "INVALID_ARGUMENT"
+	CodePermissionDenied = "PERMISSION_DENIED"
+	CodeInvalidState     = "INVALID_STATE"
+	CodeUnimplemented    = "UNIMPLEMENTED"
+	CodeQuotaExceeded    = "RESOURCE_EXHAUSTED"
+)
+
+// ContractError is the envelope of a business failure. It is serialized as
+// the message of the error response so clients can branch on its code, while
+// failures of the peer itself (world state, identity) stay plain text.
+type ContractError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Error returns the JSON envelope
+func (e *ContractError) Error() string {
+	errorAsBytes, _ := json.Marshal(e)
+	return string(errorAsBytes)
+}
+
+// newError returns a business failure with the given code and details
+func newError(code string, details map[string]string, format string, args ...interface{}) error {
+	return &ContractError{Code: code, Message: fmt.Sprintf(format, args...), Details: details}
+}
+
+// errNotFound is returned when the record id does not exist
+func errNotFound(id string) error {
+	return newError(CodeNotFound, map[string]string{"id": id}, "%s does not exist", id)
+}
+
+// withDetail adds a detail to a business failure, other errors are returned unchanged
+func withDetail(err error, key string, value string) error {
+	if contractError, ok := err.(*ContractError); ok {
+		if contractError.Details == nil {
+			contractError.Details = map[string]string{}
+		}
+
+		contractError.Details[key] = value
+	}
+
+	return err
+}
@@ -0,0 +1,251 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Chaincode events, also appended to the event journal
+const (
+	EventProposalCreated   = "ProposalCreated"
+	EventProposalApproved  = "ProposalApproved"
+	EventProposalExecuted  = "ProposalExecuted"
+	EventResultReleased    = "ResultReleased"
+	EventResultSetReleased = "ResultSetReleased"
+	EventEmergencyAccess   = "EmergencyAccess"
+	EventPatientUpdated    = "PatientUpdated"
+	EventProposalRejected  = "ProposalRejected"
+)
+
+// eventJournalPrefix starts the keys of the journal entries, simple keys so they can be range queried
+const eventJournalPrefix = "~EVENT"
+
+// eventSequenceKey held the sequence of the last journaled transaction before the journal was
+// keyed by transaction. It stays reserved, as ledgers may still hold it.
+const eventSequenceKey = "~SEQ~EVENT"
+
+// maxEventsPage is the most journal entries GetEventsSince returns at once
+const maxEventsPage = 1000
+
+// EventHeader starts the payload of every event. SchemaVersion is the version of the
+// schema of the payload, raised whenever a field is removed or changes meaning.
+type EventHeader struct {
+	SchemaVersion int `json:"schemaVersion"`
+}
+
+// eventPayload is a payload emitEvent stamps with the schema version of its event
+type eventPayload interface {
+	stamp(version int)
+}
+
+// stamp sets the schema version of the payload
+func (h *EventHeader) stamp(version int) {
+	h.SchemaVersion = version
+}
+
+// ProposalEvent is the payload of the proposal events
+type ProposalEvent struct {
+	EventHeader
+	ProposalID string `json:"proposalID"`
+	Status     string `json:"status"`
+	ReasonCode string `json:"reasonCode,omitempty"`
+	ReasonText string `json:"reasonText,omitempty"`
+}
+
+// ResultReleasedEvent is the payload of EventResultReleased and EventResultSetReleased
+type ResultReleasedEvent struct {
+	EventHeader
+	ResultID   string `json:"resultID"`
+	ProposalID string `json:"proposalID"`
+}
+
+// FieldChange is a changed field of a record, with the SHA-256 hashes of its old and new
+// values. A field set for the first time has no old hash, a removed one no new hash.
+type FieldChange struct {
+	Field   string `json:"field"`
+	OldHash string `json:"oldHash,omitempty"`
+	NewHash string `json:"newHash,omitempty"`
+}
+
+// PatientUpdatedEvent is the payload of EventPatientUpdated, naming the changed fields of a
+// patient without their values. Measurements are named measurements.<metric>.
+type PatientUpdatedEvent struct {
+	EventHeader
+	PatientID string        `json:"patientID"`
+	Changes   []FieldChange `json:"changes"`
+}
+
+// trackedFields returns the fields of a patient PatientUpdated events track, by JSON name
+func trackedFields(patient *Patient) map[string]string {
+	fields := map[string]string{
+		FieldName:                  patient.Name,
+		FieldPreExistingConditions: patient.PreExistingConditions,
+		FieldDiagnosisID:           patient.DiagnosisID,
+		FieldStatusID:              patient.StatusID,
+		FieldKeyID:                 patient.KeyID,
+	}
+
+	for metric, value := range patient.Measurements {
+		fields[FieldMeasurements+"."+metric] = value
+	}
+
+	return fields
+}
+
+// emitPatientUpdated emits the changes of a patient since its fields were read, if any
+func emitPatientUpdated(ctx contractapi.TransactionContextInterface, id string, before map[string]string, patient *Patient) error {
+	after := trackedFields(patient)
+	changes := []FieldChange{}
+
+	for field, value := range after {
+		if old, ok := before[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, NewHash: hashString(value)})
+		} else if old != value {
+			changes = append(changes, FieldChange{Field: field, OldHash: hashString(old), NewHash: hashString(value)})
+		}
+	}
+
+	for field, old := range before {
+		if _, ok := after[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, OldHash: hashString(old)})
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+
+	return emitEvent(ctx, EventPatientUpdated, &PatientUpdatedEvent{PatientID: id, Changes: changes})
+}
+
+// JournalEntry is an event in the journal. Position is where it stands in the journal, ordered
+// by the timestamp and ID of its transaction, then by the order the transaction emitted it.
+type JournalEntry struct {
+	Position  string `json:"position"`
+	Name      string `json:"name"`
+	Payload   string `json:"payload"`
+	TxID      string `json:"txID"`
+	Timestamp string `json:"timestamp"`
+}
+
+// eventCounter is a transaction context numbering the events of its transaction
+type eventCounter interface {
+	nextEvent() int
+}
+
+// nextEvent returns the index of the next event of the transaction
+func (c *CachingContext) nextEvent() int {
+	c.events++
+
+	return c.events - 1
+}
+
+// journalPosition returns the position of an event of the transaction. Every transaction
+// journals under positions of its own, so concurrent transactions never conflict.
+func journalPosition(ctx contractapi.TransactionContextInterface, name string) (string, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+
+	if err != nil {
+		return "", err
+	}
+
+	// Contexts that don't number their events tell those of a transaction apart by name only
+	index := 0
+
+	if counter, ok := ctx.(eventCounter); ok {
+		index = counter.nextEvent()
+	}
+
+	return fmt.Sprintf("%012d%09d~%s~%04d~%s", ts.GetSeconds(), ts.GetNanos(), ctx.GetStub().GetTxID(), index, name), nil
+}
+
+// emitEvent sets the chaincode event of the transaction and appends it to the journal.
+// Only the last event set by a transaction is delivered live, the journal keeps them all.
+func emitEvent(ctx contractapi.TransactionContextInterface, name string, payload eventPayload) error {
+	payload.stamp(eventTypes[name].version)
+
+	payloadAsBytes, _ := json.Marshal(payload)
+
+	if err := ctx.GetStub().SetEvent(name, payloadAsBytes); err != nil {
+		return err
+	}
+
+	position, err := journalPosition(ctx, name)
+
+	if err != nil {
+		return err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	entry := JournalEntry{
+		Position:  position,
+		Name:      name,
+		Payload:   string(payloadAsBytes),
+		TxID:      details.TxID,
+		Timestamp: details.Timestamp,
+	}
+
+	entryAsBytes, _ := json.Marshal(entry)
+
+	return ctx.GetStub().PutState(eventJournalPrefix+position, entryAsBytes)
+}
+
+// GetEventsSince returns up to limit journal entries after a position, in order. Clients
+// replay the activity they missed from the position of the last entry they processed,
+// empty from the start.
+func (s *SimpleContract) GetEventsSince(ctx contractapi.TransactionContextInterface, position string, limit int) ([]JournalEntry, error) {
+	if limit < 1 || limit > maxEventsPage {
+		return nil, newError(CodeInvalidArgument, map[string]string{"limit": fmt.Sprint(limit)}, "Limit must be between 1 and %d", maxEventsPage)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange(eventJournalPrefix+position, eventJournalPrefix+"~")
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := []JournalEntry{}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		if len(results) == limit {
+			return ledgeriter.ErrStop
+		}
+
+		entry := new(JournalEntry)
+		_ = json.Unmarshal(queryResponse.Value, entry)
+
+		// Entries journaled under a sequence, before positions, are placed by their key
+		entry.Position = strings.TrimPrefix(queryResponse.Key, eventJournalPrefix)
+
+		if entry.Position == position {
+			return nil
+		}
+
+		results = append(results, *entry)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
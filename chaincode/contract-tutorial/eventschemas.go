@@ -0,0 +1,126 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// eventType describes the payload of an event and the current version of its schema
+type eventType struct {
+	payload     interface{}
+	version     int
+	description string
+}
+
+// eventTypes holds every event the contract emits
+var eventTypes = map[string]eventType{
+	EventProposalCreated:   {ProposalEvent{}, 1, "A proposal was created and awaits the approval of the requested org"},
+	EventProposalApproved:  {ProposalEvent{}, 1, "A proposal was approved and its cohort locked"},
+	EventProposalExecuted:  {ProposalEvent{}, 1, "A proposal was executed and its result stored"},
+	EventResultReleased:    {ResultReleasedEvent{}, 1, "The result of a proposal was released to its requester"},
+	EventResultSetReleased: {ResultReleasedEvent{}, 1, "The result set of a multi-metric proposal was released to its requester"},
+	EventEmergencyAccess:   {EmergencyAccessEvent{}, 1, "A patient was accessed without consent in an emergency"},
+	EventPatientUpdated:    {PatientUpdatedEvent{}, 1, "Fields of a patient changed, named with the hashes of their old and new values"},
+	EventProposalRejected:  {ProposalEvent{}, 1, "A proposal was rejected, its reason code telling the requester to amend it, fix its key or give up"},
+}
+
+// EventSchema is the JSON schema of the payload of an event
+type EventSchema struct {
+	Name          string `json:"name"`
+	SchemaVersion int    `json:"schemaVersion"`
+	Schema        string `json:"schema"`
+}
+
+// GetEventSchemas returns the JSON schemas of the payloads of the events, sorted by name,
+// for listeners to validate and deserialize the events and journal entries they receive
+func (s *SimpleContract) GetEventSchemas(ctx contractapi.TransactionContextInterface) ([]EventSchema, error) {
+	names := []string{}
+
+	for name := range eventTypes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	schemas := []EventSchema{}
+
+	for _, name := range names {
+		schema := jsonSchema(reflect.TypeOf(eventTypes[name].payload))
+		schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+		schema["title"] = name
+		schema["description"] = eventTypes[name].description
+
+		schemaAsBytes, _ := json.Marshal(schema)
+
+		schemas = append(schemas, EventSchema{Name: name, SchemaVersion: eventTypes[name].version, Schema: string(schemaAsBytes)})
+	}
+
+	return schemas, nil
+}
+
+// jsonSchema returns the JSON schema of the JSON encoding of a type. Embedded structs
+// contribute their fields, fields without omitempty are required.
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+
+		addStructFields(t, properties, &required)
+
+		return map[string]interface{}{"type": "object", "properties": properties, "required": required}
+	}
+
+	return map[string]interface{}{}
+}
+
+// addStructFields adds the JSON fields of a struct and of its embedded structs to a schema
+func addStructFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")
+
+		if field.Anonymous && tag[0] == "" {
+			addStructFields(field.Type, properties, required)
+			continue
+		}
+
+		if tag[0] == "-" || field.PkgPath != "" {
+			continue
+		}
+
+		name := tag[0]
+
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = jsonSchema(field.Type)
+
+		if !contains(tag[1:], "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
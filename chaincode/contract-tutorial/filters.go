@@ -0,0 +1,180 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// roleAttribute is the certificate attribute holding the role of the caller
+const roleAttribute = "contract.role"
+
+// defaultRole is the role of callers without the role attribute
+const defaultRole = "default"
+
+// Fields of a patient a read policy can let through
+const (
+	FieldName                  = "name"
+	FieldPreExistingConditions = "preExistingConditions"
+	FieldDiagnosisID           = "diagnosisID"
+	FieldStatusID              = "statusID"
+	FieldKeyID                 = "keyID"
+	FieldMeasurements          = "measurements"
+	FieldMetadata              = "metadata"
+)
+
+var patientFields = []string{FieldName, FieldPreExistingConditions, FieldDiagnosisID, FieldStatusID, FieldKeyID, FieldMeasurements, FieldMetadata}
+
+// responseFilter redacts the patients returned to a caller. A nil filter lets every field through.
+type responseFilter struct {
+	visible []string
+}
+
+// newResponseFilter returns the filter of the read policy of the caller's role, nil if it has none
+func newResponseFilter(ctx contractapi.TransactionContextInterface) (*responseFilter, error) {
+	role, err := callerRole(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	visible, ok := config.ReadPolicies[role]
+
+	if !ok {
+		return nil, nil
+	}
+
+	return &responseFilter{visible: visible}, nil
+}
+
+// patient returns a copy of the patient holding the visible fields only, the others listed as redacted
+func (f *responseFilter) patient(patient *Patient) *Patient {
+	if f == nil || patient == nil {
+		return patient
+	}
+
+	filtered := *patient
+	redacted := []string{}
+
+	for _, field := range patientFields {
+		if f.lets(field) {
+			continue
+		}
+
+		switch field {
+		case FieldName:
+			filtered.Name = ""
+		case FieldPreExistingConditions:
+			filtered.PreExistingConditions = ""
+		case FieldDiagnosisID:
+			filtered.DiagnosisID = ""
+		case FieldStatusID:
+			filtered.StatusID = ""
+		case FieldKeyID:
+			filtered.KeyID = ""
+			filtered.KeyFingerprint = ""
+		case FieldMeasurements:
+			filtered.Measurements = nil
+			filtered.Proofs = nil
+		case FieldMetadata:
+			filtered.Metadata = Metadata{}
+		}
+
+		redacted = append(redacted, field)
+	}
+
+	if len(redacted) > 0 {
+		filtered.Redacted = redacted
+	}
+
+	return &filtered
+}
+
+// lets tells whether the filter lets a field of the patients through
+func (f *responseFilter) lets(field string) bool {
+	return f == nil || contains(f.visible, field)
+}
+
+// records filters the patients of query results
+func (f *responseFilter) records(results []QueryResult) []QueryResult {
+	if f == nil {
+		return results
+	}
+
+	filtered := make([]QueryResult, len(results))
+
+	for i, result := range results {
+		filtered[i] = QueryResult{Key: result.Key, Record: f.patient(result.Record)}
+	}
+
+	return filtered
+}
+
+// filterPatient applies the read policy of the caller to a patient about to be returned
+func filterPatient(ctx contractapi.TransactionContextInterface, patient *Patient) (*Patient, error) {
+	filter, err := newResponseFilter(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.patient(patient), nil
+}
+
+// filterRecords applies the read policy of the caller to query results about to be returned
+func filterRecords(ctx contractapi.TransactionContextInterface, results []QueryResult) ([]QueryResult, error) {
+	filter, err := newResponseFilter(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.records(results), nil
+}
+
+// SetReadPolicy sets the comma separated fields of a patient the callers of a role may read,
+// the role being the contract.role attribute of their certificate or "default" without one.
+// Roles without a policy read every field, an empty list of fields removes the policy.
+func (s *SimpleContract) SetReadPolicy(ctx contractapi.TransactionContextInterface, role string, fields string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if role == "" {
+		return newError(CodeInvalidArgument, map[string]string{"role": role}, "A read policy needs a role")
+	}
+
+	visible := splitList(fields)
+
+	for _, field := range visible {
+		if !contains(patientFields, field) {
+			return newError(CodeInvalidArgument, map[string]string{"field": field}, "Patients have no field %s", field)
+		}
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if config.ReadPolicies == nil {
+		config.ReadPolicies = map[string][]string{}
+	}
+
+	if len(visible) == 0 {
+		delete(config.ReadPolicies, role)
+	} else {
+		config.ReadPolicies[role] = visible
+	}
+
+	return putConfig(ctx, config)
+}
@@ -0,0 +1,101 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Freeze records why and by whom a patient was put under legal hold or investigation
+type Freeze struct {
+	Reason string             `json:"reason"`
+	Frozen TransactionDetails `json:"frozen"`
+}
+
+// checkNotFrozen refuses writes to a frozen patient and its inclusion in proposals
+func checkNotFrozen(id string, patient *Patient) error {
+	if patient.Freeze == nil {
+		return nil
+	}
+
+	return newError(CodeInvalidState, map[string]string{"id": id, "reason": patient.Freeze.Reason}, "%s is frozen. %s", id, patient.Freeze.Reason)
+}
+
+// requireAdminOrCustodian refuses callers that are neither admins nor members of the custodian org of the patient
+func requireAdminOrCustodian(ctx contractapi.TransactionContextInterface, id string, patient *Patient) error {
+	if requireAdmin(ctx) == nil {
+		return nil
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != patient.custodian() {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only admins and %s can freeze or unfreeze %s", patient.custodian(), id)
+	}
+
+	return nil
+}
+
+// FreezePatient blocks the writes to a patient and its inclusion in new proposals until it is
+// unfrozen. The reason and the freezing transaction are kept on the patient.
+func (s *SimpleContract) FreezePatient(ctx contractapi.TransactionContextInterface, id string, reason string) error {
+	if reason == "" {
+		return newError(CodeInvalidArgument, map[string]string{"id": id}, "Freezing %s needs a reason", id)
+	}
+
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := requireAdminOrCustodian(ctx, id, patient); err != nil {
+		return err
+	}
+
+	if err := checkNotFrozen(id, patient); err != nil {
+		return err
+	}
+
+	frozen, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	patient.Freeze = &Freeze{Reason: reason, Frozen: frozen}
+	patient.Metadata.Updated = frozen
+
+	return putPatient(ctx, id, patient)
+}
+
+// UnfreezeRecord lifts the freeze of a patient
+func (s *SimpleContract) UnfreezeRecord(ctx contractapi.TransactionContextInterface, id string) error {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := requireAdminOrCustodian(ctx, id, patient); err != nil {
+		return err
+	}
+
+	if patient.Freeze == nil {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s is not frozen", id)
+	}
+
+	patient.Freeze = nil
+
+	if err := patient.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	return putPatient(ctx, id, patient)
+}
@@ -0,0 +1,104 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// OperationGroupMean averages a metric within each group of the cohort
+const OperationGroupMean = "GROUP_MEAN"
+
+// Patient attributes a cohort can be grouped by
+const (
+	GroupByDiagnosis = "DIAGNOSIS"
+	GroupByStatus    = "STATUS"
+)
+
+// groupOf returns the group of a patient
+func groupOf(patient *Patient, groupBy string) string {
+	if groupBy == GroupByStatus {
+		return patient.StatusID
+	}
+
+	return patient.DiagnosisID
+}
+
+// CreateGroupedProposal requests the mean of a metric for every diagnosis or status of the
+// cohort in one approval cycle. The preExistingConditions value is averaged without a metric.
+func (s *SimpleContract) CreateGroupedProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, metric string, groupBy string, expiry string) error {
+	if done, err := beginRequest(ctx, "CreateGroupedProposal", id, protocolID, requesterID, requestedID, patientsIDs, keyID, metric, groupBy, expiry); err != nil || done {
+		return err
+	}
+
+	if groupBy != GroupByDiagnosis && groupBy != GroupByStatus {
+		return newError(CodeInvalidArgument, map[string]string{"groupBy": groupBy}, "Cohorts are grouped by %s or %s", GroupByDiagnosis, GroupByStatus)
+	}
+
+	if metric == "" {
+		metric = DefaultMetric
+	}
+
+	proposal, err := s.proposeCohort(ctx, id, protocolID, requesterID, requestedID, patientsIDs, keyID, OperationGroupMean, expiry, []string{metric})
+
+	if err != nil {
+		return err
+	}
+
+	proposal.GroupBy = groupBy
+
+	return s.storeNewProposal(ctx, id, proposal)
+}
+
+// groupMeans computes the encrypted mean and the size of every group of the cohort
+func (s *SimpleContract) groupMeans(ctx contractapi.TransactionContextInterface, proposal *Proposal, pids []string, modulo string) (map[string]string, map[string]int, error) {
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	groups := map[string][]string{}
+	members := map[string][]string{}
+	order := []string{}
+
+	for _, pid := range pids {
+		patient, err := s.cohortPatient(ctx, pid, proposal.AsOf)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		value, ok := measurement(patient, proposal.Metrics[0])
+
+		if !ok {
+			return nil, nil, newError(CodeNotFound, map[string]string{"id": pid, "metric": proposal.Metrics[0]}, "%s has no %s measurement", pid, proposal.Metrics[0])
+		}
+
+		group := groupOf(patient, proposal.GroupBy)
+
+		if _, ok := groups[group]; !ok {
+			order = append(order, group)
+		}
+
+		groups[group] = append(groups[group], value)
+		members[group] = append(members[group], pid)
+	}
+
+	values := map[string]string{}
+	sizes := map[string]int{}
+
+	for _, group := range order {
+		values[group], err = mean(q, members[group], groups[group])
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		sizes[group] = len(groups[group])
+	}
+
+	return values, sizes, nil
+}
@@ -0,0 +1,292 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// guardianIndex is the composite key namespace of the guardians of each patient, by their client ID
+const guardianIndex = "guardian~patient"
+
+// Relationships of a guardian to a patient
+const (
+	RelationshipParent          = "PARENT"
+	RelationshipLegalGuardian   = "LEGAL_GUARDIAN"
+	RelationshipHealthcareProxy = "HEALTHCARE_PROXY"
+)
+
+var relationships = []string{RelationshipParent, RelationshipLegalGuardian, RelationshipHealthcareProxy}
+
+// Guardian statuses
+const (
+	GuardianActive  = "ACTIVE"
+	GuardianRevoked = "REVOKED"
+)
+
+// Guardian links the identity of a guardian, by its client ID, to a patient who can't consent
+// on their own, a minor or an incapacitated adult. The guardian consents on behalf of the
+// patient for the purposes of its scope, between the optional RFC 3339 bounds of its validity.
+// VerificationSource references what the custodian checked the relationship against, such as
+// a birth certificate or a court order.
+type Guardian struct {
+	PatientID          string   `json:"patientID"`
+	GuardianID         string   `json:"guardianID"`
+	Relationship       string   `json:"relationship"`
+	Scope              []string `json:"scope"`
+	ValidFrom          string   `json:"validFrom,omitempty" metadata:",optional"`
+	ValidTo            string   `json:"validTo,omitempty" metadata:",optional"`
+	VerificationSource string   `json:"verificationSource"`
+	Status             string   `json:"status"`
+	Metadata           Metadata `json:"metadata"`
+}
+
+// GuardianProxy is the guardianship a consent or consent decision was given under
+type GuardianProxy struct {
+	GuardianID         string `json:"guardianID"`
+	Relationship       string `json:"relationship"`
+	VerificationSource string `json:"verificationSource"`
+}
+
+// findGuardian returns the key of a guardian of a patient and the guardian, nil if there is none
+func findGuardian(ctx contractapi.TransactionContextInterface, patientID string, guardianID string) (string, *Guardian, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(guardianIndex, []string{patientID, guardianID})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	guardianAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if guardianAsBytes == nil {
+		return key, nil, nil
+	}
+
+	guardian := new(Guardian)
+	_ = json.Unmarshal(guardianAsBytes, guardian)
+
+	return key, guardian, nil
+}
+
+// requireCustodian refuses callers that are not members of the custodian org of the patient
+func requireCustodian(ctx contractapi.TransactionContextInterface, id string, patient *Patient) error {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != patient.custodian() {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can manage the guardians of %s", patient.custodian(), id)
+	}
+
+	return nil
+}
+
+// guardianProxy returns the guardianship the caller acts under for a patient, checking it is
+// active, valid now and scoped to the purposes. It returns nil if the caller is not a
+// guardian of the patient.
+func guardianProxy(ctx contractapi.TransactionContextInterface, patientID string, purposes []string) (*GuardianProxy, error) {
+	clientID, anonymous, err := callerID(ctx)
+
+	if err != nil || anonymous {
+		return nil, err
+	}
+
+	_, guardian, err := findGuardian(ctx, patientID, clientID)
+
+	if err != nil || guardian == nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	validFrom, err := timeutil.ParseOptional(guardian.ValidFrom)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if guardian.Status != GuardianActive || now.Before(validFrom) || timeutil.Expired(guardian.ValidTo, now) {
+		return nil, newError(CodePermissionDenied, map[string]string{"patientID": patientID, "status": guardian.Status}, "The guardianship of %s is not in force", patientID)
+	}
+
+	for _, purpose := range purposes {
+		if !contains(guardian.Scope, purpose) {
+			return nil, newError(CodePermissionDenied, map[string]string{"patientID": patientID, "purpose": purpose}, "The guardianship of %s doesn't extend to %s", patientID, purpose)
+		}
+	}
+
+	return &GuardianProxy{GuardianID: guardian.GuardianID, Relationship: guardian.Relationship, VerificationSource: guardian.VerificationSource}, nil
+}
+
+// RegisterGuardian records, for the custodian of a patient, a guardian consenting on behalf of
+// the patient for comma separated purposes. The validity bounds are optional RFC 3339
+// timestamps, the coming of age of a minor typically ending it.
+func (s *SimpleContract) RegisterGuardian(ctx contractapi.TransactionContextInterface, patientID string, guardianID string, relationship string, scope string, validFrom string, validTo string, verificationSource string) error {
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return err
+	}
+
+	if err := requireCustodian(ctx, patientID, patient); err != nil {
+		return err
+	}
+
+	if guardianID == "" {
+		return newError(CodeInvalidArgument, map[string]string{"field": "guardianID"}, "guardianID is required")
+	}
+
+	if !contains(relationships, relationship) {
+		return newError(CodeInvalidArgument, map[string]string{"relationship": relationship}, "Relationship %s is not supported", relationship)
+	}
+
+	if verificationSource == "" {
+		return newError(CodeInvalidArgument, map[string]string{"field": "verificationSource"}, "verificationSource is required")
+	}
+
+	codes, err := parsePurposes(scope)
+
+	if err != nil {
+		return err
+	}
+
+	if validFrom != "" {
+		if _, err := parseTimestamp("validFrom", validFrom); err != nil {
+			return err
+		}
+	}
+
+	if validTo != "" {
+		if _, err := parseTimestamp("validTo", validTo); err != nil {
+			return err
+		}
+	}
+
+	if validFrom != "" && validTo != "" && timeutil.Compare(validFrom, validTo) >= 0 {
+		return newError(CodeInvalidArgument, map[string]string{"validFrom": validFrom, "validTo": validTo}, "The validity must end after it starts")
+	}
+
+	key, existing, err := findGuardian(ctx, patientID, guardianID)
+
+	if err != nil {
+		return err
+	}
+
+	if existing != nil && existing.Status == GuardianActive {
+		return newError(CodeAlreadyExists, map[string]string{"patientID": patientID, "guardianID": guardianID}, "%s is already a guardian of %s", guardianID, patientID)
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	guardian := Guardian{
+		PatientID:          patientID,
+		GuardianID:         guardianID,
+		Relationship:       relationship,
+		Scope:              codes,
+		ValidFrom:          validFrom,
+		ValidTo:            validTo,
+		VerificationSource: verificationSource,
+		Status:             GuardianActive,
+		Metadata:           metadata,
+	}
+
+	guardianAsBytes, _ := json.Marshal(guardian)
+
+	return ctx.GetStub().PutState(key, guardianAsBytes)
+}
+
+// RevokeGuardian ends, for the custodian of a patient, a guardianship. Consents already given
+// under it stand until revoked.
+func (s *SimpleContract) RevokeGuardian(ctx contractapi.TransactionContextInterface, patientID string, guardianID string) error {
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return err
+	}
+
+	if err := requireCustodian(ctx, patientID, patient); err != nil {
+		return err
+	}
+
+	key, guardian, err := findGuardian(ctx, patientID, guardianID)
+
+	if err != nil {
+		return err
+	}
+
+	if guardian == nil {
+		return newError(CodeNotFound, map[string]string{"patientID": patientID, "guardianID": guardianID}, "%s is not a guardian of %s", guardianID, patientID)
+	}
+
+	if guardian.Status == GuardianRevoked {
+		return newError(CodeInvalidState, map[string]string{"patientID": patientID, "guardianID": guardianID}, "The guardianship of %s over %s is already revoked", guardianID, patientID)
+	}
+
+	guardian.Status = GuardianRevoked
+
+	if err := guardian.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	guardianAsBytes, _ := json.Marshal(guardian)
+
+	return ctx.GetStub().PutState(key, guardianAsBytes)
+}
+
+// FindGuardian returns a guardian of a patient
+func (s *SimpleContract) FindGuardian(ctx contractapi.TransactionContextInterface, patientID string, guardianID string) (*Guardian, error) {
+	_, guardian, err := findGuardian(ctx, patientID, guardianID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if guardian == nil {
+		return nil, newError(CodeNotFound, map[string]string{"patientID": patientID, "guardianID": guardianID}, "%s is not a guardian of %s", guardianID, patientID)
+	}
+
+	return guardian, nil
+}
+
+// GrantProxyConsent records a consent given by a guardian of the patient on their behalf, as
+// GrantConsent does. The caller must be a guardian in force whose scope covers the purposes,
+// and the guardianship is recorded on the consent and its receipt.
+func (s *SimpleContract) GrantProxyConsent(ctx contractapi.TransactionContextInterface, id string, patientID string, granteeMSP string, terms string, purposes string, expiry string) error {
+	codes, err := parsePurposes(purposes)
+
+	if err != nil {
+		return err
+	}
+
+	proxy, err := guardianProxy(ctx, patientID, codes)
+
+	if err != nil {
+		return err
+	}
+
+	if proxy == nil {
+		return newError(CodePermissionDenied, map[string]string{"patientID": patientID}, "Caller is not a guardian of %s", patientID)
+	}
+
+	return s.putConsent(ctx, id, patientID, granteeMSP, terms, purposes, expiry, proxy)
+}
@@ -0,0 +1,283 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Housekeeping record types, collected by CollectGarbage once past their TTL
+const (
+	// HousekeepingNotification is a notification, aged from its acknowledgment or else its creation
+	HousekeepingNotification = "NOTIFICATION"
+	// HousekeepingDraft is a draft patient, aged from its last save
+	HousekeepingDraft = "DRAFT"
+	// HousekeepingRequest is a processed request, aged from its processing
+	HousekeepingRequest = "REQUEST"
+	// HousekeepingField is a field of a patient past its retention rule, cleared rather than deleted
+	HousekeepingField = "FIELD"
+)
+
+var housekeepingTypes = []string{HousekeepingNotification, HousekeepingDraft, HousekeepingRequest, HousekeepingField}
+
+// Built-in TTLs of the housekeeping records the configuration doesn't set another for
+const (
+	defaultNotificationTTL = 90 * 24 * time.Hour
+	defaultDraftTTL        = 30 * 24 * time.Hour
+)
+
+// HousekeepingCount is what a run of CollectGarbage deleted of a type of record. Remaining
+// tells whether expired records of the type are left to delete.
+type HousekeepingCount struct {
+	Type      string `json:"type"`
+	Deleted   int    `json:"deleted"`
+	Remaining bool   `json:"remaining"`
+}
+
+// HousekeepingReport is what a run of CollectGarbage deleted, by type of record
+type HousekeepingReport struct {
+	Counts    []HousekeepingCount `json:"counts"`
+	Collected TransactionDetails  `json:"collected"`
+}
+
+// housekeepingTTL returns how long the records of a housekeeping type are kept
+func housekeepingTTL(ctx contractapi.TransactionContextInterface, recordType string) (time.Duration, error) {
+	if recordType == HousekeepingRequest {
+		return requestTTL(ctx)
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if recordType == HousekeepingNotification && config.NotificationTTL > 0 {
+		return time.Duration(config.NotificationTTL) * time.Second, nil
+	}
+
+	if recordType == HousekeepingDraft && config.DraftTTL > 0 {
+		return time.Duration(config.DraftTTL) * time.Second, nil
+	}
+
+	if recordType == HousekeepingDraft {
+		return defaultDraftTTL, nil
+	}
+
+	return defaultNotificationTTL, nil
+}
+
+// expiredSince tells whether a TTL elapsed since a timestamp
+func expiredSince(timestamp string, ttl time.Duration, now time.Time) bool {
+	t, err := timeutil.Parse(timestamp)
+
+	return err == nil && now.After(t.Add(ttl))
+}
+
+// collectNotifications deletes up to limit notifications of every org past their TTL, in
+// order of the time it runs from, and tells whether expired notifications remain.
+// Notifications written before they were indexed by age aren't collected.
+func collectNotifications(ctx contractapi.TransactionContextInterface, now time.Time, limit int) (int, bool, error) {
+	ttl, err := housekeepingTTL(ctx, HousekeepingNotification)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(notificationAgeIndex, []string{})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	deleted := 0
+	remaining := false
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		if !expiredSince(attributes[0], ttl, now) {
+			return ledgeriter.ErrStop
+		}
+
+		if deleted == limit {
+			remaining = true
+			return ledgeriter.ErrStop
+		}
+
+		key, err := ctx.GetStub().CreateCompositeKey(notificationIndex, []string{attributes[1], attributes[2]})
+
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return err
+		}
+
+		deleted++
+
+		return ctx.GetStub().DelState(queryResponse.Key)
+	})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return deleted, remaining, nil
+}
+
+// collectDrafts deletes up to limit drafts of the caller's org past their TTL, and tells
+// whether expired drafts remain
+func collectDrafts(ctx contractapi.TransactionContextInterface, now time.Time, limit int) (int, bool, error) {
+	ttl, err := housekeepingTTL(ctx, HousekeepingDraft)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	collection, err := implicitCollection(ctx)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Range queries skip composite keys, such as the emergency contacts kept in the collection
+	resultsIterator, err := ctx.GetStub().GetPrivateDataByRange(collection, "", "")
+
+	if err != nil {
+		return 0, false, fmt.Errorf("Failed to read from private data. %s", err.Error())
+	}
+
+	deleted := 0
+	remaining := false
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		draft := new(DraftPatient)
+		_ = json.Unmarshal(queryResponse.Value, draft)
+
+		if !expiredSince(draft.Metadata.Updated.Timestamp, ttl, now) {
+			return nil
+		}
+
+		if deleted == limit {
+			remaining = true
+			return ledgeriter.ErrStop
+		}
+
+		deleted++
+
+		return ctx.GetStub().DelPrivateData(collection, queryResponse.Key)
+	})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return deleted, remaining, nil
+}
+
+// CollectGarbage deletes the notifications, drafts and processed requests past their TTL, up
+// to a page of each type, and reports how many it deleted so operators can schedule it. It is
+// called again while some remain. Drafts are those of the caller's org, kept in its implicit
+// collection, so the transaction must be endorsed by its peers and run by every org. Likewise
+// it clears the fields of the patients of the caller's org past their retention rules, the
+// FIELD count being the fields cleared.
+func (s *SimpleContract) CollectGarbage(ctx contractapi.TransactionContextInterface) (*HousekeepingReport, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := resolvePageSize(ctx, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	collectors := map[string]func(contractapi.TransactionContextInterface, time.Time, int) (int, bool, error){
+		HousekeepingNotification: collectNotifications,
+		HousekeepingDraft:        collectDrafts,
+		HousekeepingRequest:      purgeRequests,
+		HousekeepingField:        minimizeFields,
+	}
+
+	report := &HousekeepingReport{Counts: []HousekeepingCount{}}
+
+	for _, recordType := range housekeepingTypes {
+		count := HousekeepingCount{Type: recordType}
+
+		count.Deleted, count.Remaining, err = collectors[recordType](ctx, now, int(pageSize))
+
+		if err != nil {
+			return nil, err
+		}
+
+		report.Counts = append(report.Counts, count)
+	}
+
+	report.Collected, err = newTransactionDetails(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// SetHousekeepingTTL sets for how many seconds the records of a housekeeping type are kept
+// before CollectGarbage deletes them. A TTL of 0 restores the built-in one: 90 days for
+// notifications, 30 for drafts and a week for requests, whose TTL SetRequestTTL sets too.
+func (s *SimpleContract) SetHousekeepingTTL(ctx contractapi.TransactionContextInterface, recordType string, seconds int64) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if !contains(housekeepingTypes, recordType) {
+		return newError(CodeInvalidArgument, map[string]string{"type": recordType}, "Housekeeping types are %s", strings.Join(housekeepingTypes, ", "))
+	}
+
+	if recordType == HousekeepingField {
+		return newError(CodeInvalidArgument, map[string]string{"type": recordType}, "Fields are kept by the retention rules SetFieldRetention sets")
+	}
+
+	if seconds < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"seconds": fmt.Sprint(seconds)}, "The TTL can't be negative")
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	switch recordType {
+	case HousekeepingNotification:
+		config.NotificationTTL = seconds
+	case HousekeepingDraft:
+		config.DraftTTL = seconds
+	case HousekeepingRequest:
+		config.RequestTTL = seconds
+	}
+
+	return putConfig(ctx, config)
+}
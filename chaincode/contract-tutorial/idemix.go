@@ -0,0 +1,82 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Attributes of an Idemix credential. Idemix credentials have neither a certificate nor
+// the attributes of the contract, only the OU and the MSP role of their holder.
+const (
+	idemixOUAttribute   = "ou"
+	idemixRoleAttribute = "role"
+)
+
+// idemixIDPrefix starts the IDs recorded for the holders of Idemix credentials
+const idemixIDPrefix = "idemix::"
+
+// callerID returns the ID of the caller and whether it is anonymous. The holders of Idemix
+// credentials can't be told apart, they are recorded as idemix::<MSP>::<OU> so their
+// enrollment identity is never revealed.
+func callerID(ctx contractapi.TransactionContextInterface) (string, bool, error) {
+	clientID, err := ctx.GetClientIdentity().GetID()
+
+	if err == nil {
+		return clientID, false, nil
+	}
+
+	cert, certErr := ctx.GetClientIdentity().GetX509Certificate()
+
+	if certErr != nil || cert != nil {
+		return "", false, fmt.Errorf("Failed to read client identity. %s", err.Error())
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	ou, _, err := ctx.GetClientIdentity().GetAttributeValue(idemixOUAttribute)
+
+	if err != nil {
+		return "", false, fmt.Errorf("Failed to read client identity. %s", err.Error())
+	}
+
+	return idemixIDPrefix + mspID + "::" + ou, true, nil
+}
+
+// callerRole returns the role the read policies of the caller are looked up by: the role
+// attribute of its certificate, or the OU of its Idemix credential, the default role without
+func callerRole(ctx contractapi.TransactionContextInterface) (string, error) {
+	role, found, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+
+	if err != nil {
+		return "", err
+	}
+
+	if found && role != "" {
+		return role, nil
+	}
+
+	if _, anonymous, err := callerID(ctx); err != nil || !anonymous {
+		return defaultRole, err
+	}
+
+	ou, found, err := ctx.GetClientIdentity().GetAttributeValue(idemixOUAttribute)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !found || ou == "" {
+		return defaultRole, nil
+	}
+
+	return ou, nil
+}
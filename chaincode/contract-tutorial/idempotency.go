@@ -0,0 +1,280 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// requestIDField is the transient field clients pass the idempotency key of a request in
+const requestIDField = "requestID"
+
+// requestIndex is the composite key namespace of the processed requests, by MSP and request ID
+const requestIndex = "request~id"
+
+// requestExpiryIndex is the composite key namespace of the processed requests, in order of expiry
+const requestExpiryIndex = "request~expiry"
+
+// defaultRequestTTL is how long a processed request is remembered unless the configuration sets another
+const defaultRequestTTL = 7 * 24 * time.Hour
+
+// ProcessedRequest is a request processed under an idempotency key. ArgsHash is the hash
+// of the JSON encoded arguments of the function.
+type ProcessedRequest struct {
+	RequestID string             `json:"requestID"`
+	Function  string             `json:"function"`
+	ArgsHash  string             `json:"argsHash"`
+	Processed TransactionDetails `json:"processed"`
+	Expires   string             `json:"expires"`
+}
+
+// RequestPurgeReport is what a run of PurgeExpiredRequests did. Remaining tells whether
+// expired requests are left to purge.
+type RequestPurgeReport struct {
+	Purged    int  `json:"purged"`
+	Remaining bool `json:"remaining"`
+}
+
+// requestTTL returns how long a processed request is remembered
+func requestTTL(ctx contractapi.TransactionContextInterface) (time.Duration, error) {
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if config.RequestTTL > 0 {
+		return time.Duration(config.RequestTTL) * time.Second, nil
+	}
+
+	return defaultRequestTTL, nil
+}
+
+// findProcessedRequest returns a request of an org processed under an idempotency key, nil
+// if there is none
+func findProcessedRequest(ctx contractapi.TransactionContextInterface, mspID string, requestID string) (string, *ProcessedRequest, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(requestIndex, []string{mspID, requestID})
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	requestAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if requestAsBytes == nil {
+		return key, nil, nil
+	}
+
+	request := new(ProcessedRequest)
+	_ = json.Unmarshal(requestAsBytes, request)
+
+	return key, request, nil
+}
+
+// beginRequest records the request of the transaction under the idempotency key passed in
+// the requestID transient field, if any, and tells whether the request was already
+// processed. Only committed transactions record their request, so a processed request
+// succeeded and its duplicates succeed without doing anything. Reusing a key for other
+// arguments fails with ALREADY_EXISTS. Keys are scoped to the MSP of the caller.
+func beginRequest(ctx contractapi.TransactionContextInterface, function string, args ...interface{}) (bool, error) {
+	transMap, err := ctx.GetStub().GetTransient()
+
+	if err != nil {
+		return false, fmt.Errorf("Error getting transient. %s", err.Error())
+	}
+
+	requestID := string(transMap[requestIDField])
+
+	if requestID == "" {
+		return false, nil
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	key, request, err := findProcessedRequest(ctx, mspID, requestID)
+
+	if err != nil {
+		return false, err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	argsAsBytes, _ := json.Marshal(args)
+	argsHash := hashString(string(argsAsBytes))
+
+	if request != nil && !timeutil.Expired(request.Expires, now) {
+		if request.Function != function || request.ArgsHash != argsHash {
+			return false, newError(CodeAlreadyExists, map[string]string{"requestID": requestID, "txID": request.Processed.TxID}, "Request %s was processed by %s with other arguments", requestID, request.Function)
+		}
+
+		return true, nil
+	}
+
+	ttl, err := requestTTL(ctx)
+
+	if err != nil {
+		return false, err
+	}
+
+	request = &ProcessedRequest{RequestID: requestID, Function: function, ArgsHash: argsHash, Expires: timeutil.Format(now.Add(ttl))}
+
+	if request.Processed, err = newTransactionDetails(ctx); err != nil {
+		return false, err
+	}
+
+	if err := putIndexEntry(ctx, requestExpiryIndex, request.Expires, mspID, requestID); err != nil {
+		return false, err
+	}
+
+	requestAsBytes, _ := json.Marshal(request)
+
+	return false, ctx.GetStub().PutState(key, requestAsBytes)
+}
+
+// FindProcessedRequest returns a request of the caller's org processed under an idempotency
+// key, so a client whose transaction timed out can tell whether it was committed
+func (s *SimpleContract) FindProcessedRequest(ctx contractapi.TransactionContextInterface, requestID string) (*ProcessedRequest, error) {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, request, err := findProcessedRequest(ctx, mspID, requestID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if request == nil {
+		return nil, newError(CodeNotFound, map[string]string{"requestID": requestID}, "Request %s was not processed", requestID)
+	}
+
+	return request, nil
+}
+
+// PurgeExpiredRequests deletes the processed requests past their expiry, a page at a time
+// in order of expiry, and is called again while some remain
+func (s *SimpleContract) PurgeExpiredRequests(ctx contractapi.TransactionContextInterface) (*RequestPurgeReport, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := resolvePageSize(ctx, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RequestPurgeReport{}
+
+	report.Purged, report.Remaining, err = purgeRequests(ctx, now, int(pageSize))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// purgeRequests deletes up to limit processed requests past their expiry, in order of expiry,
+// and tells whether expired requests remain
+func purgeRequests(ctx contractapi.TransactionContextInterface, now time.Time, limit int) (int, bool, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(requestExpiryIndex, []string{})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	purged := 0
+	remaining := false
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		if !timeutil.Expired(attributes[0], now) {
+			return ledgeriter.ErrStop
+		}
+
+		if purged == limit {
+			remaining = true
+			return ledgeriter.ErrStop
+		}
+
+		key, request, err := findProcessedRequest(ctx, attributes[1], attributes[2])
+
+		if err != nil {
+			return err
+		}
+
+		// A request processed again after its expiry left an entry of its previous expiry behind
+		if request != nil && request.Expires == attributes[0] {
+			if err := ctx.GetStub().DelState(key); err != nil {
+				return err
+			}
+		}
+
+		purged++
+
+		return ctx.GetStub().DelState(queryResponse.Key)
+	})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return purged, remaining, nil
+}
+
+// SetRequestTTL sets for how many seconds the requests processed under an idempotency key are
+// remembered. A TTL of 0 restores the built-in one of a week.
+func (s *SimpleContract) SetRequestTTL(ctx contractapi.TransactionContextInterface, seconds int64) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if seconds < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"seconds": fmt.Sprint(seconds)}, "The request TTL can't be negative")
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.RequestTTL = seconds
+
+	return putConfig(ctx, config)
+}
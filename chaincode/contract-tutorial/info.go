@@ -0,0 +1,68 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"runtime/debug"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const (
+	// ContractVersion is the version of the contract
+	ContractVersion = "3.0.0"
+	// SchemaVersion is the version of the records written by the contract
+	SchemaVersion = 3
+	pheModule     = "github.com/hanesbarbosa/phe"
+)
+
+// ContractInfo describes the deployed contract
+type ContractInfo struct {
+	ContractVersion       string   `json:"contractVersion"`
+	SchemaVersion         int      `json:"schemaVersion"`
+	SupportedOperations   []string `json:"supportedOperations"`
+	PheVersion            string   `json:"pheVersion"`
+	APIVersions           []string `json:"apiVersions"`
+	DeprecatedAPIVersions []string `json:"deprecatedAPIVersions"`
+	DefaultAPIVersion     string   `json:"defaultAPIVersion"`
+}
+
+// pheVersion returns the version of the phe library built into the chaincode
+func pheVersion() string {
+	info, ok := debug.ReadBuildInfo()
+
+	if !ok {
+		return "unknown"
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == pheModule {
+			return dep.Version
+		}
+	}
+
+	return "unknown"
+}
+
+// Ping answers pong, for clients to check the chaincode is reachable
+func (s *SimpleContract) Ping(ctx contractapi.TransactionContextInterface) (string, error) {
+	return "pong", nil
+}
+
+// GetContractInfo returns the versions of the contract, of its records, of its API and of
+// phe, and the operations proposals can request
+func (s *SimpleContract) GetContractInfo(ctx contractapi.TransactionContextInterface) (*ContractInfo, error) {
+	info := ContractInfo{
+		ContractVersion:       ContractVersion,
+		SchemaVersion:         SchemaVersion,
+		SupportedOperations:   supportedOperations,
+		PheVersion:            pheVersion(),
+		APIVersions:           apiVersions,
+		DeprecatedAPIVersions: deprecatedAPIVersions,
+		DefaultAPIVersion:     defaultAPIVersion,
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,376 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// quarantineIndex is the composite key namespace of the records moved out of the way by RepairIntegrity
+const quarantineIndex = "quarantine~key"
+
+// Kinds of dangling references
+const (
+	// ReferenceProposalPatient is a proposal whose cohort holds a patient that doesn't exist
+	ReferenceProposalPatient = "PROPOSAL_PATIENT"
+	// ReferenceResultProposal is a result or result set of a proposal that doesn't exist
+	ReferenceResultProposal = "RESULT_PROPOSAL"
+	// ReferenceIndexEntry is an index entry pointing to a record that doesn't exist
+	ReferenceIndexEntry = "INDEX_ENTRY"
+)
+
+// Repair actions
+const (
+	// RepairRemovedReference drops the missing patients from the cohort of a proposal
+	RepairRemovedReference = "REMOVED_REFERENCE"
+	// RepairDeleted deletes an index entry
+	RepairDeleted = "DELETED"
+	// RepairQuarantined moves a record under the quarantine~key index
+	RepairQuarantined = "QUARANTINED"
+)
+
+// DanglingReference is a reference of a record or index entry to a record that doesn't exist
+type DanglingReference struct {
+	Kind       string   `json:"kind"`
+	Key        string   `json:"key"`
+	Index      string   `json:"index,omitempty" metadata:",optional"`
+	Attributes []string `json:"attributes,omitempty" metadata:",optional"`
+	MissingIDs []string `json:"missingIDs"`
+}
+
+// IntegrityReport lists the dangling references of the world state
+type IntegrityReport struct {
+	DanglingReferences []DanglingReference `json:"danglingReferences"`
+	Checked            TransactionDetails  `json:"checked"`
+}
+
+// IntegrityRepair is a change RepairIntegrity made to resolve a dangling reference
+type IntegrityRepair struct {
+	Reference DanglingReference `json:"reference"`
+	Action    string            `json:"action"`
+}
+
+// RepairReport lists the changes of RepairIntegrity, and the dangling references it left as
+// they are: the cohorts of executed proposals, which their results were computed over
+type RepairReport struct {
+	Repairs  []IntegrityRepair   `json:"repairs"`
+	Skipped  []DanglingReference `json:"skipped"`
+	Repaired TransactionDetails  `json:"repaired"`
+}
+
+// QuarantinedRecord is a record RepairIntegrity moved out of the way, kept with its value so
+// it can be restored by hand
+type QuarantinedRecord struct {
+	Key         string             `json:"key"`
+	Value       string             `json:"value"`
+	Reason      DanglingReference  `json:"reason"`
+	Quarantined TransactionDetails `json:"quarantined"`
+}
+
+// missingReference returns the first record an index entry points to that doesn't exist or is
+// gone within the transaction, an empty ID if every one exists
+func missingReference(ctx contractapi.TransactionContextInterface, index string, attributes []string, gone map[string]bool) (string, error) {
+	for _, position := range referencedIDs[index] {
+		if gone[attributes[position]] {
+			return attributes[position], nil
+		}
+
+		recordAsBytes, err := ctx.GetStub().GetState(attributes[position])
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		if recordAsBytes == nil {
+			return attributes[position], nil
+		}
+	}
+
+	return "", nil
+}
+
+// danglingRecords walks the world state for the proposals referencing missing patients and
+// the results and result sets referencing missing proposals, sorted by key
+func danglingRecords(ctx contractapi.TransactionContextInterface) ([]DanglingReference, map[string]*Proposal, error) {
+	patients := map[string]bool{}
+	proposals := map[string]*Proposal{}
+	results := map[string]string{}
+
+	resultsIterator, err := ctx.GetStub().GetStateByRange("", "")
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// References are checked once every record is known, the state being walked once
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		switch recordType(queryResponse.Key, queryResponse.Value) {
+		case EntityPatient:
+			patients[queryResponse.Key] = true
+		case EntityProposal:
+			proposal := new(Proposal)
+			_ = json.Unmarshal(queryResponse.Value, proposal)
+			proposals[queryResponse.Key] = proposal
+		case EntityResult, EntityResultSet:
+			result := struct {
+				ProposalID string `json:"proposalID"`
+			}{}
+			_ = json.Unmarshal(queryResponse.Value, &result)
+			results[queryResponse.Key] = result.ProposalID
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	references := []DanglingReference{}
+
+	for id, proposal := range proposals {
+		missing := []string{}
+
+		for _, pid := range strings.Split(proposal.PatientsIDs, ",") {
+			if !patients[pid] {
+				missing = append(missing, pid)
+			}
+		}
+
+		if len(missing) > 0 {
+			references = append(references, DanglingReference{Kind: ReferenceProposalPatient, Key: id, MissingIDs: missing})
+		}
+	}
+
+	for id, proposalID := range results {
+		if proposals[proposalID] == nil {
+			references = append(references, DanglingReference{Kind: ReferenceResultProposal, Key: id, MissingIDs: []string{proposalID}})
+		}
+	}
+
+	sort.Slice(references, func(i, j int) bool { return references[i].Key < references[j].Key })
+
+	return references, proposals, nil
+}
+
+// danglingIndexEntries walks the indexes for the entries pointing to records that don't exist
+// or are gone within the transaction
+func danglingIndexEntries(ctx contractapi.TransactionContextInterface, gone map[string]bool) ([]DanglingReference, error) {
+	references := []DanglingReference{}
+
+	for _, index := range indexes {
+		if len(referencedIDs[index]) == 0 {
+			continue
+		}
+
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{})
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+			_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+			if err != nil {
+				return err
+			}
+
+			missingID, err := missingReference(ctx, index, attributes, gone)
+
+			if err != nil {
+				return err
+			}
+
+			if missingID != "" {
+				references = append(references, DanglingReference{Kind: ReferenceIndexEntry, Key: queryResponse.Key, Index: index, Attributes: attributes, MissingIDs: []string{missingID}})
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return references, nil
+}
+
+// CheckIntegrity reports the dangling references of the world state: proposals whose cohort
+// holds missing patients, results and result sets of missing proposals, and index entries
+// pointing to missing records. It reads the whole state, so admins should evaluate it.
+func (s *SimpleContract) CheckIntegrity(ctx contractapi.TransactionContextInterface) (*IntegrityReport, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	records, _, err := danglingRecords(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := danglingIndexEntries(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &IntegrityReport{DanglingReferences: append(records, entries...)}
+
+	report.Checked, err = newTransactionDetails(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// quarantine moves a record under the quarantine~key index with the reference that dangled
+func quarantine(ctx contractapi.TransactionContextInterface, reference DanglingReference, details TransactionDetails) error {
+	recordAsBytes, err := ctx.GetStub().GetState(reference.Key)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(quarantineIndex, []string{reference.Key})
+
+	if err != nil {
+		return err
+	}
+
+	quarantinedAsBytes, _ := json.Marshal(QuarantinedRecord{Key: reference.Key, Value: string(recordAsBytes), Reason: reference, Quarantined: details})
+
+	if err := ctx.GetStub().PutState(key, quarantinedAsBytes); err != nil {
+		return err
+	}
+
+	return ctx.GetStub().DelState(reference.Key)
+}
+
+// RepairIntegrity resolves the dangling references CheckIntegrity reports and returns every
+// change made. Missing patients are dropped from the cohorts of the proposals not yet
+// executed, and proposals left without patients are quarantined, as are the results and
+// result sets of missing proposals. Index entries pointing to missing records, quarantined
+// ones included, are deleted. Executed proposals are left as they are, their results having
+// been computed over their cohorts. Quarantined records are moved under the quarantine~key
+// index with their value, and read back with FindQuarantinedRecord.
+func (s *SimpleContract) RepairIntegrity(ctx contractapi.TransactionContextInterface) (*RepairReport, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	records, proposals, err := danglingRecords(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RepairReport{Repairs: []IntegrityRepair{}, Skipped: []DanglingReference{}, Repaired: details}
+	gone := map[string]bool{}
+
+	for _, reference := range records {
+		action := RepairQuarantined
+
+		if reference.Kind == ReferenceProposalPatient {
+			proposal := proposals[reference.Key]
+
+			if proposal.Status == ProposalExecuted {
+				report.Skipped = append(report.Skipped, reference)
+				continue
+			}
+
+			remaining := []string{}
+
+			for _, pid := range strings.Split(proposal.PatientsIDs, ",") {
+				if !contains(reference.MissingIDs, pid) {
+					remaining = append(remaining, pid)
+				}
+			}
+
+			if len(remaining) > 0 {
+				action = RepairRemovedReference
+				proposal.PatientsIDs = strings.Join(remaining, ",")
+				proposal.Metadata.Updated = details
+
+				proposalAsBytes, _ := json.Marshal(proposal)
+
+				if err := ctx.GetStub().PutState(reference.Key, proposalAsBytes); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if action == RepairQuarantined {
+			if err := quarantine(ctx, reference, details); err != nil {
+				return nil, err
+			}
+
+			gone[reference.Key] = true
+		}
+
+		report.Repairs = append(report.Repairs, IntegrityRepair{Reference: reference, Action: action})
+	}
+
+	entries, err := danglingIndexEntries(ctx, gone)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reference := range entries {
+		if err := ctx.GetStub().DelState(reference.Key); err != nil {
+			return nil, err
+		}
+
+		report.Repairs = append(report.Repairs, IntegrityRepair{Reference: reference, Action: RepairDeleted})
+	}
+
+	return report, nil
+}
+
+// FindQuarantinedRecord returns a record RepairIntegrity quarantined, by its former key
+func (s *SimpleContract) FindQuarantinedRecord(ctx contractapi.TransactionContextInterface, key string) (*QuarantinedRecord, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	quarantineKey, err := ctx.GetStub().CreateCompositeKey(quarantineIndex, []string{key})
+
+	if err != nil {
+		return nil, err
+	}
+
+	quarantinedAsBytes, err := ctx.GetStub().GetState(quarantineKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if quarantinedAsBytes == nil {
+		return nil, errNotFound(key)
+	}
+
+	quarantined := new(QuarantinedRecord)
+	_ = json.Unmarshal(quarantinedAsBytes, quarantined)
+
+	return quarantined, nil
+}
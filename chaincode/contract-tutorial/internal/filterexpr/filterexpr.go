@@ -0,0 +1,348 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package filterexpr parses the filter expressions of the patient queries, comparisons of
+// fields with values joined by AND and OR and grouped by parentheses, e.g.
+//
+//	diagnosisID = D1 AND (statusID = "S1" OR statusID = S2)
+//
+// An expression is either evaluated against a record or compiled to a CouchDB selector.
+// AND binds tighter than OR, and values are bare words or double quoted strings.
+package filterexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Comparison operators, compared lexicographically
+const (
+	OpEqual        = "="
+	OpNotEqual     = "!="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+)
+
+// selectorOperators maps the operators to those of CouchDB
+var selectorOperators = map[string]string{
+	OpEqual:        "$eq",
+	OpNotEqual:     "$ne",
+	OpLess:         "$lt",
+	OpLessEqual:    "$lte",
+	OpGreater:      "$gt",
+	OpGreaterEqual: "$gte",
+}
+
+// Expr is a parsed filter expression
+type Expr interface {
+	// Eval tells whether a record whose fields get returns matches the expression
+	Eval(get func(field string) string) bool
+	// Selector returns the CouchDB selector of the expression, prefix starting every field path
+	Selector(prefix string) map[string]interface{}
+	// Fields returns the fields the expression compares, in order of appearance
+	Fields() []string
+}
+
+// Comparison compares a field with a value
+type Comparison struct {
+	Field string
+	Op    string
+	Value string
+}
+
+// Eval compares the field of the record with the value
+func (c Comparison) Eval(get func(field string) string) bool {
+	v := get(c.Field)
+
+	switch c.Op {
+	case OpEqual:
+		return v == c.Value
+	case OpNotEqual:
+		return v != c.Value
+	case OpLess:
+		return v < c.Value
+	case OpLessEqual:
+		return v <= c.Value
+	case OpGreater:
+		return v > c.Value
+	}
+
+	return v >= c.Value
+}
+
+// Selector returns {"<prefix><field>": {"$op": "<value>"}}
+func (c Comparison) Selector(prefix string) map[string]interface{} {
+	return map[string]interface{}{prefix + c.Field: map[string]interface{}{selectorOperators[c.Op]: c.Value}}
+}
+
+// Fields returns the field of the comparison
+func (c Comparison) Fields() []string {
+	return []string{c.Field}
+}
+
+// Logical joins expressions with AND or OR
+type Logical struct {
+	And   bool
+	Terms []Expr
+}
+
+// Eval matches every term with AND, any with OR
+func (l Logical) Eval(get func(field string) string) bool {
+	for _, term := range l.Terms {
+		if term.Eval(get) != l.And {
+			return !l.And
+		}
+	}
+
+	return l.And
+}
+
+// Selector returns {"$and": [...]} or {"$or": [...]}
+func (l Logical) Selector(prefix string) map[string]interface{} {
+	op := "$or"
+
+	if l.And {
+		op = "$and"
+	}
+
+	terms := make([]interface{}, len(l.Terms))
+
+	for i, term := range l.Terms {
+		terms[i] = term.Selector(prefix)
+	}
+
+	return map[string]interface{}{op: terms}
+}
+
+// Fields returns the fields of the terms
+func (l Logical) Fields() []string {
+	fields := []string{}
+
+	for _, term := range l.Terms {
+		fields = append(fields, term.Fields()...)
+	}
+
+	return fields
+}
+
+// token is a lexical token of an expression. Quoted values are never keywords or operators.
+type token struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+// tokenize splits an expression into parentheses, operators, words and quoted strings
+func tokenize(s string) ([]token, error) {
+	tokens := []token{}
+
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, token{text: s[i : i+1], pos: i})
+			i++
+		case strings.ContainsRune("=!<>", r):
+			n := 1
+
+			if i+1 < len(s) && s[i+1] == '=' {
+				n = 2
+			}
+
+			op := s[i : i+n]
+
+			if _, ok := selectorOperators[op]; !ok {
+				return nil, fmt.Errorf("unknown operator %q at %d", op, i)
+			}
+
+			tokens = append(tokens, token{text: op, pos: i})
+			i += n
+		case r == '"':
+			end := i + 1
+
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+
+			value, err := strconv.Unquote(s[i : end+1])
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d", i)
+			}
+
+			tokens = append(tokens, token{text: value, quoted: true, pos: i})
+			i = end + 1
+		case isWordChar(r):
+			end := i
+
+			for end < len(s) && isWordChar(rune(s[end])) {
+				end++
+			}
+
+			tokens = append(tokens, token{text: s[i:end], pos: i})
+			i = end
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", r, i)
+		}
+	}
+
+	return tokens, nil
+}
+
+// isWordChar tells whether a character can appear in a field name or bare value
+func isWordChar(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-:", r))
+}
+
+// parser is a recursive descent parser over the tokens of an expression
+type parser struct {
+	tokens []token
+	next   int
+	depth  int
+}
+
+// maxDepth bounds the nesting of parentheses
+const maxDepth = 16
+
+// Parse parses a filter expression
+func Parse(s string) (Expr, error) {
+	tokens, err := tokenize(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.or()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.next < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.tokens[p.next].text, p.tokens[p.next].pos)
+	}
+
+	return expr, nil
+}
+
+// peekKeyword tells whether the next token is a keyword, in any case
+func (p *parser) peekKeyword(keyword string) bool {
+	return p.next < len(p.tokens) && !p.tokens[p.next].quoted && strings.EqualFold(p.tokens[p.next].text, keyword)
+}
+
+// or parses terms joined by OR
+func (p *parser) or() (Expr, error) {
+	return p.logical("OR", false, p.and)
+}
+
+// and parses factors joined by AND
+func (p *parser) and() (Expr, error) {
+	return p.logical("AND", true, p.factor)
+}
+
+// logical parses operands joined by a keyword, an operand alone being returned as is
+func (p *parser) logical(keyword string, and bool, operand func() (Expr, error)) (Expr, error) {
+	first, err := operand()
+
+	if err != nil {
+		return nil, err
+	}
+
+	terms := []Expr{first}
+
+	for p.peekKeyword(keyword) {
+		p.next++
+
+		term, err := operand()
+
+		if err != nil {
+			return nil, err
+		}
+
+		terms = append(terms, term)
+	}
+
+	if len(terms) == 1 {
+		return first, nil
+	}
+
+	return Logical{And: and, Terms: terms}, nil
+}
+
+// factor parses a parenthesized expression or a comparison
+func (p *parser) factor() (Expr, error) {
+	if p.next == len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	t := p.tokens[p.next]
+
+	if !t.quoted && t.text == "(" {
+		if p.depth == maxDepth {
+			return nil, fmt.Errorf("too many nested parentheses at %d", t.pos)
+		}
+
+		p.next++
+		p.depth++
+
+		expr, err := p.or()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if p.next == len(p.tokens) || p.tokens[p.next].quoted || p.tokens[p.next].text != ")" {
+			return nil, fmt.Errorf("missing ) for ( at %d", t.pos)
+		}
+
+		p.next++
+		p.depth--
+
+		return expr, nil
+	}
+
+	if p.next+3 > len(p.tokens) {
+		return nil, fmt.Errorf("incomplete comparison at %d", t.pos)
+	}
+
+	field, op, value := t, p.tokens[p.next+1], p.tokens[p.next+2]
+
+	if field.quoted || !isWordChar(rune(field.text[0])) || p.peekKeywordAt(p.next) {
+		return nil, fmt.Errorf("expected a field at %d", field.pos)
+	}
+
+	if _, ok := selectorOperators[op.text]; !ok || op.quoted {
+		return nil, fmt.Errorf("expected an operator at %d", op.pos)
+	}
+
+	if !value.quoted && !isWordChar(rune(value.text[0])) {
+		return nil, fmt.Errorf("expected a value at %d", value.pos)
+	}
+
+	p.next += 3
+
+	return Comparison{Field: field.text, Op: op.text, Value: value.text}, nil
+}
+
+// peekKeywordAt tells whether the token at i is AND or OR
+func (p *parser) peekKeywordAt(i int) bool {
+	t := p.tokens[i]
+
+	return !t.quoted && (strings.EqualFold(t.text, "AND") || strings.EqualFold(t.text, "OR"))
+}
@@ -0,0 +1,53 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package filterexpr
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"", "diagnosisID", "diagnosisID =", "diagnosisID == D1", "(diagnosisID = D1", "diagnosisID = D1 AND", "diagnosisID = D1 statusID = S1", "AND = D1", `diagnosisID = "D1`, "diagnosisID ~ D1"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse accepted %q", s)
+		}
+	}
+
+	expr, err := Parse(`diagnosisID = D1 and (statusID = "S 1" OR statusID != S2) OR keyID >= KEY9`)
+
+	if err != nil {
+		t.Fatalf("Parse failed. %s", err.Error())
+	}
+
+	selector, _ := json.Marshal(expr.Selector("doc."))
+	expected := `{"$or":[{"$and":[{"doc.diagnosisID":{"$eq":"D1"}},{"$or":[{"doc.statusID":{"$eq":"S 1"}},{"doc.statusID":{"$ne":"S2"}}]}]},{"doc.keyID":{"$gte":"KEY9"}}]}`
+
+	if string(selector) != expected {
+		t.Errorf("Expected selector %s, got %s", expected, selector)
+	}
+
+	if fields := expr.Fields(); len(fields) != 4 || fields[0] != "diagnosisID" || fields[3] != "keyID" {
+		t.Errorf("Unexpected fields %v", fields)
+	}
+}
+
+func TestEval(t *testing.T) {
+	expr, _ := Parse("diagnosisID = D1 AND (statusID = S1 OR keyID > KEY1)")
+
+	for _, c := range []struct {
+		record   map[string]string
+		expected bool
+	}{
+		{map[string]string{"diagnosisID": "D1", "statusID": "S1", "keyID": "KEY0"}, true},
+		{map[string]string{"diagnosisID": "D1", "statusID": "S2", "keyID": "KEY2"}, true},
+		{map[string]string{"diagnosisID": "D1", "statusID": "S2", "keyID": "KEY0"}, false},
+		{map[string]string{"diagnosisID": "D2", "statusID": "S1", "keyID": "KEY2"}, false},
+	} {
+		if expr.Eval(func(field string) string { return c.record[field] }) != c.expected {
+			t.Errorf("Expected %v for %v", c.expected, c.record)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package keyfile reads and writes the JSON key files of the tools: a phe secret key and its
+// public key, with the multivectors in the encoding of phe and the integers in decimal.
+package keyfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hanesbarbosa/phe"
+)
+
+// Key is the JSON representation of a phe key pair
+type Key struct {
+	B  int64  `json:"b"`
+	Q  string `json:"q"`
+	K1 string `json:"k1"`
+	K2 string `json:"k2"`
+	G  string `json:"g"`
+}
+
+// Load reads the key pair of a key file
+func Load(path string) (*phe.SecretKey, *phe.PublicKey, error) {
+	keyAsBytes, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := new(Key)
+
+	if err := json.Unmarshal(keyAsBytes, key); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s. %s", path, err.Error())
+	}
+
+	sk, pk, err := phewrap.ParseKeys(key.K1, key.K2, key.G, key.B, key.Q)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s is not a valid key. %s", path, err.Error())
+	}
+
+	return sk, pk, nil
+}
+
+// Save writes a key pair to a key file only its owner can read
+func Save(path string, sk *phe.SecretKey, pk *phe.PublicKey) error {
+	key := Key{B: pk.B, Q: pk.Q.String(), K1: sk.K1.ToString(), K2: sk.K2.ToString(), G: sk.G.String()}
+	keyAsBytes, _ := json.MarshalIndent(key, "", "  ")
+
+	return ioutil.WriteFile(path, keyAsBytes, 0600)
+}
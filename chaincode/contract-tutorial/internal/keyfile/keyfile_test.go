@@ -0,0 +1,57 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package keyfile
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hanesbarbosa/phe"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keyfile")
+
+	if err != nil {
+		t.Fatalf("TempDir failed. %s", err.Error())
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "key.json")
+	sk, pk := phe.GenerateKeys(256)
+
+	if err := Save(path, sk, pk); err != nil {
+		t.Fatalf("Save failed. %s", err.Error())
+	}
+
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the key file to be readable by its owner only, got %s", info.Mode().Perm())
+	}
+
+	loadedSK, loadedPK, err := Load(path)
+
+	if err != nil {
+		t.Fatalf("Load failed. %s", err.Error())
+	}
+
+	c := phewrap.Encrypt(sk, pk, big.NewInt(42))
+
+	if value := phewrap.Decrypt(loadedSK, loadedPK, c); value.Cmp(big.NewRat(42, 1)) != 0 {
+		t.Errorf("Expected the loaded key to decrypt 42, got %s", value.String())
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{"b":32,"q":"7","k1":"x","k2":"y","g":"1"}`), 0600); err != nil {
+		t.Fatalf("WriteFile failed. %s", err.Error())
+	}
+
+	if _, _, err := Load(path); err == nil {
+		t.Errorf("Expected a key with malformed multivectors to be refused")
+	}
+}
@@ -0,0 +1,196 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package ledgeriter walks the iterators of the chaincode shim. Every helper closes
+// the iterator, reports the error of Close when nothing else failed, stops once the
+// context is done and refuses to read more results than its limit.
+package ledgeriter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// ErrLimitExceeded is returned when a query has more results than its limit
+var ErrLimitExceeded = errors.New("too many results")
+
+// ErrStop ends a walk early without an error when returned by a callback
+var ErrStop = errors.New("stop")
+
+// Iterator is implemented by the state and history iterators of the shim
+type Iterator[T any] interface {
+	HasNext() bool
+	Next() (T, error)
+	Close() error
+}
+
+// PageQuery fetches a page of results after a bookmark
+type PageQuery func(pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error)
+
+// ForEach calls fn for every result of the iterator, up to limit results unless limit is 0
+func ForEach[T any](ctx context.Context, it Iterator[T], limit int, fn func(T) error) (err error) {
+	defer func() {
+		if closeErr := it.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close iterator. %s", closeErr.Error())
+		}
+	}()
+
+	for n := 0; it.HasNext(); n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if limit > 0 && n == limit {
+			return fmt.Errorf("%w, the limit is %d", ErrLimitExceeded, limit)
+		}
+
+		item, err := it.Next()
+
+		if err != nil {
+			return err
+		}
+
+		if err := fn(item); err != nil {
+			if errors.Is(err, ErrStop) {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Collect maps every result of the iterator with fn, skipping those it returns false for
+func Collect[T any, R any](ctx context.Context, it Iterator[T], limit int, fn func(T) (R, bool, error)) ([]R, error) {
+	results := []R{}
+
+	err := ForEach(ctx, it, limit, func(item T) error {
+		result, ok, err := fn(item)
+
+		if ok {
+			results = append(results, result)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// CollectTyped decodes the JSON value of every result of a state iterator
+func CollectTyped[R any](ctx context.Context, it Iterator[*queryresult.KV], limit int) ([]R, error) {
+	return Collect(ctx, it, limit, func(kv *queryresult.KV) (R, bool, error) {
+		var result R
+		_ = json.Unmarshal(kv.Value, &result)
+
+		return result, true, nil
+	})
+}
+
+// CountOnly counts the results of the iterator without decoding them
+func CountOnly[T any](ctx context.Context, it Iterator[T], limit int) (int, error) {
+	count := 0
+
+	err := ForEach(ctx, it, limit, func(T) error {
+		count++
+		return nil
+	})
+
+	return count, err
+}
+
+// ForEachPage calls fn for every result of up to pages pages of a paginated query, starting
+// after bookmark. It returns the bookmark after the last page read, empty once the query
+// is exhausted, and the number of results fetched.
+func ForEachPage(ctx context.Context, query PageQuery, pageSize int32, bookmark string, pages int, fn func(*queryresult.KV) error) (string, int32, error) {
+	fetched := int32(0)
+
+	for page := 0; page < pages; page++ {
+		it, metadata, err := query(pageSize, bookmark)
+
+		if err != nil {
+			return "", fetched, err
+		}
+
+		stopped := false
+
+		err = ForEach[*queryresult.KV](ctx, it, int(pageSize), func(kv *queryresult.KV) error {
+			err := fn(kv)
+			stopped = errors.Is(err, ErrStop)
+
+			return err
+		})
+
+		if err != nil {
+			return "", fetched, err
+		}
+
+		fetched += metadata.FetchedRecordsCount
+		bookmark = metadata.Bookmark
+
+		if metadata.FetchedRecordsCount < pageSize {
+			return "", fetched, nil
+		}
+
+		if stopped {
+			break
+		}
+	}
+
+	return bookmark, fetched, nil
+}
+
+// Page is one page of the results of a paginated query. Bookmark is empty once the query is
+// exhausted, and Count is the number of results fetched, those skipped included.
+type Page[R any] struct {
+	Records  []R
+	Bookmark string
+	Count    int32
+}
+
+// CollectPage maps the results of one page of a paginated query with fn, skipping those it
+// returns false for
+func CollectPage[R any](ctx context.Context, query PageQuery, pageSize int32, bookmark string, fn func(*queryresult.KV) (R, bool, error)) (Page[R], error) {
+	page := Page[R]{Records: []R{}}
+
+	var err error
+
+	page.Bookmark, page.Count, err = ForEachPage(ctx, query, pageSize, bookmark, 1, func(kv *queryresult.KV) error {
+		result, ok, err := fn(kv)
+
+		if ok {
+			page.Records = append(page.Records, result)
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return Page[R]{}, err
+	}
+
+	return page, nil
+}
+
+// CollectTypedPage decodes the JSON value of every result of one page of a paginated query
+func CollectTypedPage[R any](ctx context.Context, query PageQuery, pageSize int32, bookmark string) (Page[R], error) {
+	return CollectPage(ctx, query, pageSize, bookmark, func(kv *queryresult.KV) (R, bool, error) {
+		var result R
+		_ = json.Unmarshal(kv.Value, &result)
+
+		return result, true, nil
+	})
+}
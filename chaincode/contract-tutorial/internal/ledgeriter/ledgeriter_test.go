@@ -0,0 +1,115 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package ledgeriter
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+type sliceIterator struct {
+	items    []*queryresult.KV
+	closed   bool
+	closeErr error
+}
+
+func (it *sliceIterator) HasNext() bool {
+	return len(it.items) > 0
+}
+
+func (it *sliceIterator) Next() (*queryresult.KV, error) {
+	item := it.items[0]
+	it.items = it.items[1:]
+
+	return item, nil
+}
+
+func (it *sliceIterator) Close() error {
+	it.closed = true
+	return it.closeErr
+}
+
+func newIterator(values ...string) *sliceIterator {
+	it := new(sliceIterator)
+
+	for _, value := range values {
+		it.items = append(it.items, &queryresult.KV{Key: value, Value: []byte(`"` + value + `"`)})
+	}
+
+	return it
+}
+
+func TestCollectTyped(t *testing.T) {
+	it := newIterator("a", "b")
+	results, err := CollectTyped[string](context.Background(), it, 2)
+
+	if err != nil {
+		t.Fatalf("CollectTyped failed. %s", err.Error())
+	}
+
+	if len(results) != 2 || results[0] != "a" || results[1] != "b" || !it.closed {
+		t.Fatalf("CollectTyped returned %v, closed %t", results, it.closed)
+	}
+
+	it = newIterator("a", "b", "c")
+
+	if _, err := CollectTyped[string](context.Background(), it, 2); !errors.Is(err, ErrLimitExceeded) || !it.closed {
+		t.Fatalf("CollectTyped read past its limit, %v", err)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	seen := 0
+	err := ForEach[*queryresult.KV](context.Background(), newIterator("a", "b", "c"), 0, func(*queryresult.KV) error {
+		seen++
+		return ErrStop
+	})
+
+	if err != nil || seen != 1 {
+		t.Fatalf("ForEach did not stop, %d results seen, %v", seen, err)
+	}
+
+	it := newIterator("a")
+	it.closeErr = errors.New("closed")
+	count, err := CountOnly[*queryresult.KV](context.Background(), it, 0)
+
+	if count != 1 || err == nil {
+		t.Fatalf("CountOnly ignored the error of Close, %d results, %v", count, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := CountOnly[*queryresult.KV](ctx, newIterator("a"), 0); err != context.Canceled {
+		t.Fatalf("CountOnly ignored the cancellation, %v", err)
+	}
+}
+
+func TestCollectPage(t *testing.T) {
+	query := func(pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		if bookmark == "" {
+			return newIterator("a", "b"), &peer.QueryResponseMetadata{FetchedRecordsCount: 2, Bookmark: "c"}, nil
+		}
+
+		return newIterator("c"), &peer.QueryResponseMetadata{FetchedRecordsCount: 1, Bookmark: ""}, nil
+	}
+
+	page, err := CollectTypedPage[string](context.Background(), query, 2, "")
+
+	if err != nil || len(page.Records) != 2 || page.Bookmark != "c" || page.Count != 2 {
+		t.Fatalf("CollectTypedPage returned %+v, %v", page, err)
+	}
+
+	page, err = CollectTypedPage[string](context.Background(), query, 2, page.Bookmark)
+
+	if err != nil || len(page.Records) != 1 || page.Records[0] != "c" || page.Bookmark != "" {
+		t.Fatalf("CollectTypedPage did not exhaust the query, %+v, %v", page, err)
+	}
+}
@@ -0,0 +1,166 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package metadocs reads from the source of a contract what its reflected metadata lacks:
+// the names of the parameters of its transactions and the doc comments of its transactions
+// and types, which the contract serves along with its metadata for client generation.
+package metadocs
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// contextType is the type of the transaction context, which isn't a parameter of the transaction
+const contextType = "contractapi.TransactionContextInterface"
+
+// Transaction documents a transaction of a contract
+type Transaction struct {
+	Description string   `json:"description,omitempty"`
+	Parameters  []string `json:"parameters"`
+}
+
+// Docs document the transactions of the contracts by contract and name, and the types by name
+type Docs struct {
+	Contracts map[string]map[string]Transaction `json:"contracts"`
+	Types     map[string]string                 `json:"types"`
+}
+
+// Parse reads the docs of the Go files of a directory, tests aside. receivers maps the type
+// of each contract to its name, and the exported methods of those types are its transactions.
+func Parse(dir string, receivers map[string]string) (*Docs, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+
+	docs := &Docs{Contracts: map[string]map[string]Transaction{}, Types: map[string]string{}}
+
+	for _, contract := range receivers {
+		docs.Contracts[contract] = map[string]Transaction{}
+	}
+
+	fset := token.NewFileSet()
+
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+
+		f, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				parseFunc(docs, decl, receivers)
+			case *ast.GenDecl:
+				parseTypes(docs, decl)
+			}
+		}
+	}
+
+	return docs, nil
+}
+
+// parseFunc documents a method of a contract
+func parseFunc(docs *Docs, decl *ast.FuncDecl, receivers map[string]string) {
+	if decl.Recv == nil || len(decl.Recv.List) != 1 || !decl.Name.IsExported() {
+		return
+	}
+
+	receiver := decl.Recv.List[0].Type
+
+	if star, ok := receiver.(*ast.StarExpr); ok {
+		receiver = star.X
+	}
+
+	ident, ok := receiver.(*ast.Ident)
+
+	if !ok {
+		return
+	}
+
+	contract, ok := receivers[ident.Name]
+
+	if !ok {
+		return
+	}
+
+	transaction := Transaction{Description: description(decl.Name.Name, decl.Doc), Parameters: []string{}}
+
+	for _, field := range decl.Type.Params.List {
+		if typeName(field.Type) == contextType {
+			continue
+		}
+
+		for _, name := range field.Names {
+			transaction.Parameters = append(transaction.Parameters, name.Name)
+		}
+	}
+
+	docs.Contracts[contract][decl.Name.Name] = transaction
+}
+
+// parseTypes documents the exported types of a declaration
+func parseTypes(docs *Docs, decl *ast.GenDecl) {
+	if decl.Tok != token.TYPE {
+		return
+	}
+
+	for _, spec := range decl.Specs {
+		typeSpec := spec.(*ast.TypeSpec)
+
+		if !typeSpec.Name.IsExported() {
+			continue
+		}
+
+		doc := typeSpec.Doc
+
+		if doc == nil && len(decl.Specs) == 1 {
+			doc = decl.Doc
+		}
+
+		if text := description(typeSpec.Name.Name, doc); text != "" {
+			docs.Types[typeSpec.Name.Name] = text
+		}
+	}
+}
+
+// description returns a doc comment on a single line, empty for the "Name ..." placeholder
+func description(name string, doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+
+	text := strings.Join(strings.Fields(doc.Text()), " ")
+
+	if text == name+" ..." {
+		return ""
+	}
+
+	return text
+}
+
+// typeName returns a type as it is written, for the identifiers and selectors it is compared with
+func typeName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return expr.Name
+	case *ast.SelectorExpr:
+		return typeName(expr.X) + "." + expr.Sel.Name
+	}
+
+	return ""
+}
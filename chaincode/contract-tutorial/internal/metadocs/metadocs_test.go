@@ -0,0 +1,81 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package metadocs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const source = `package main
+
+import "github.com/hyperledger/fabric-contract-api-go/contractapi"
+
+// Contract is the contract
+type Contract struct{}
+
+// Other isn't a contract
+type Other struct{}
+
+type (
+	// Grouped is documented on its spec
+	Grouped struct{}
+	undocumented struct{}
+)
+
+// Find returns a record
+// of the ledger
+func (c *Contract) Find(ctx contractapi.TransactionContextInterface, id string, from, to int) (string, error) {
+	return "", nil
+}
+
+// Ping ...
+func (c Contract) Ping(ctx contractapi.TransactionContextInterface) error {
+	return nil
+}
+
+// find isn't exported
+func (c *Contract) find(id string) {}
+
+// Find isn't a method of a contract
+func (o *Other) Find(id string) {}
+`
+
+func TestParse(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := os.WriteFile(filepath.Join(dir, "contract.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "contract_test.go"), []byte("package main\n\n// Test ...\nfunc (c *Contract) Test() {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, err := Parse(dir, map[string]string{"Contract": "v2"})
+
+	if err != nil {
+		t.Fatalf("Parse failed. %s", err.Error())
+	}
+
+	expected := map[string]Transaction{
+		"Find": {Description: "Find returns a record of the ledger", Parameters: []string{"id", "from", "to"}},
+		"Ping": {Parameters: []string{}},
+	}
+
+	if !reflect.DeepEqual(docs.Contracts["v2"], expected) {
+		t.Errorf("Expected %+v, got %+v", expected, docs.Contracts["v2"])
+	}
+
+	if docs.Types["Contract"] != "Contract is the contract" || docs.Types["Grouped"] != "Grouped is documented on its spec" || len(docs.Types) != 3 {
+		t.Errorf("Expected the exported types with their doc comments, got %v", docs.Types)
+	}
+
+	if _, err := Parse(filepath.Join(dir, "missing"), nil); err != nil {
+		t.Errorf("Expected an empty directory to have no docs, got %s", err.Error())
+	}
+}
@@ -0,0 +1,118 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package payloadschema validates the JSON payloads of the contract against the JSON schemas
+// (draft-07) embedded with it. Violations are reported with the JSON pointer (RFC 6901) of
+// the offending value, in a deterministic order so every endorser returns the same error.
+package payloadschema
+
+import (
+	"embed"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+//go:embed *.json
+var files embed.FS
+
+// Schema names
+const (
+	Patients         = "patients"
+	Consents         = "consents"
+	DraftPatient     = "draft-patient"
+	EmergencyContact = "emergency-contact"
+)
+
+// Violation is a value of a payload not matching its schema. Pointer is empty for the
+// payload itself.
+type Violation struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+var schemas = map[string]*gojsonschema.Schema{}
+
+func init() {
+	for _, name := range Names() {
+		schema, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(Source(name)))
+
+		if err != nil {
+			panic(fmt.Sprintf("schema %s is invalid: %s", name, err.Error()))
+		}
+
+		schemas[name] = schema
+	}
+}
+
+// Names returns the names of the schemas, sorted
+func Names() []string {
+	return []string{Consents, DraftPatient, EmergencyContact, Patients}
+}
+
+// Source returns the JSON schema of a name, empty if there is none
+func Source(name string) string {
+	schemaAsBytes, _ := files.ReadFile(name + ".json")
+
+	return string(schemaAsBytes)
+}
+
+// Validate returns the violations of the schema of a name by a JSON payload, sorted by
+// pointer. It fails if there is no such schema or the payload is not JSON.
+func Validate(name string, payload []byte) ([]Violation, error) {
+	schema, ok := schemas[name]
+
+	if !ok {
+		return nil, fmt.Errorf("unknown schema %s", name)
+	}
+
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(payload))
+
+	if err != nil {
+		return nil, err
+	}
+
+	violations := []Violation{}
+
+	for _, resultError := range result.Errors() {
+		ptr := pointer(resultError.Context())
+
+		// The missing or extra property is the offending value, rather than its object
+		if property, ok := resultError.Details()["property"].(string); ok {
+			ptr += "/" + escape(property)
+		}
+
+		violations = append(violations, Violation{Pointer: ptr, Message: resultError.Description()})
+	}
+
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Pointer != violations[j].Pointer {
+			return violations[i].Pointer < violations[j].Pointer
+		}
+
+		return violations[i].Message < violations[j].Message
+	})
+
+	return violations, nil
+}
+
+// pointer returns the JSON pointer of a validation context
+func pointer(context *gojsonschema.JsonContext) string {
+	// Property names may hold dots, the default delimiter, so the tokens are split on NUL
+	tokens := strings.Split(context.String("\x00"), "\x00")
+	ptr := ""
+
+	for _, token := range tokens[1:] {
+		ptr += "/" + escape(token)
+	}
+
+	return ptr
+}
+
+// escape escapes a reference token of a JSON pointer
+func escape(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
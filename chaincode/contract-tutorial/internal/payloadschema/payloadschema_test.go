@@ -0,0 +1,57 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package payloadschema
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchemas(t *testing.T) {
+	for _, name := range Names() {
+		if Source(name) == "" {
+			t.Errorf("Schema %s is not embedded", name)
+		}
+	}
+
+	if _, err := Validate("templates", []byte(`[]`)); err == nil {
+		t.Errorf("Expected an unknown schema to be refused")
+	}
+
+	if _, err := Validate(Patients, []byte(`[{"id":`)); err == nil {
+		t.Errorf("Expected a malformed payload to be refused")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := `[{"id":"CONSENT0","patientID":"PATIENT0","granteeMSP":"Org1MSP","terms":"terms","purposes":"RESEARCH","expiry":"2024-01-01T00:00:00Z"}]`
+
+	if violations, err := Validate(Consents, []byte(valid)); err != nil || len(violations) != 0 {
+		t.Errorf("Expected a valid payload to pass, got %v %v", violations, err)
+	}
+
+	payload := `[{"id":"CONSENT0","patientID":"PATIENT0","granteeMSP":"Org1MSP","terms":"terms","purposes":"RESEARCH"},` +
+		`{"id":"","patientID":"PATIENT1","granteeMSP":"Org1MSP","purposes":"RESEARCH","expiry":"soon","a/b":1}]`
+
+	violations, err := Validate(Consents, []byte(payload))
+
+	if err != nil {
+		t.Fatalf("Validate failed. %s", err.Error())
+	}
+
+	pointers := []string{}
+
+	for _, violation := range violations {
+		pointers = append(pointers, violation.Pointer)
+	}
+
+	if expected := []string{"/1/a~1b", "/1/expiry", "/1/id", "/1/terms"}; !reflect.DeepEqual(pointers, expected) {
+		t.Errorf("Expected violations at %v, got %v", expected, violations)
+	}
+
+	if violations, _ := Validate(DraftPatient, []byte(`"PATIENT0"`)); len(violations) != 1 || violations[0].Pointer != "" {
+		t.Errorf("Expected a violation of the payload itself, got %v", violations)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package phefake is a test double of phe. A key is a scalar invertible modulo a small prime,
+// and a value is encrypted as its product with the key in the scalar coefficient of a
+// multivector, the other coefficients being zero. The operations of phewrap act on these
+// ciphertexts as on those of phe, so tests check what the contract computes without
+// generating real keys. It hides nothing and must not leave tests.
+package phefake
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hanesbarbosa/phe"
+)
+
+// Prime is the modulus of the fake keys. Decryption recovers fractions whose numerator and
+// denominator are below the square root of half of it, 32768, and as with phe negative
+// values don't decrypt.
+const Prime = 2147483647
+
+// Keys is a fake key, the scalar k modulo q
+type Keys struct {
+	q *big.Int
+	k *big.Int
+}
+
+// NewKeys returns the fake key k modulo Prime
+func NewKeys(k int64) (*Keys, error) {
+	q := big.NewInt(Prime)
+	key := new(big.Int).Mod(big.NewInt(k), q)
+
+	if key.Sign() == 0 {
+		return nil, fmt.Errorf("%d is not invertible modulo %d", k, Prime)
+	}
+
+	return &Keys{q: q, k: key}, nil
+}
+
+// scalar returns the multivector of a scalar
+func scalar(v *big.Int) *phe.Multivector {
+	return phe.NewMultivector([]string{v.String(), "0", "0", "0", "0", "0", "0", "0"})
+}
+
+// Modulus returns Prime
+func (k *Keys) Modulus() phewrap.Modulus {
+	q, _ := phewrap.ParseModulus(k.q.String())
+
+	return q
+}
+
+// Encrypt returns the multivector of the value times the key
+func (k *Keys) Encrypt(value *big.Int) phewrap.Ciphertext {
+	v := new(big.Int).Mul(value, k.k)
+
+	return phewrap.FromMultivector(scalar(v.Mod(v, k.q)))
+}
+
+// Decrypt divides the scalar coefficient of a ciphertext by the key, and recovers the fraction
+// it encodes as phe does
+func (k *Keys) Decrypt(c phewrap.Ciphertext) *big.Rat {
+	v := new(big.Int).Mul(c.Multivector().E0, new(big.Int).ModInverse(k.k, k.q))
+
+	return phe.ExtendedEuclideanAlgorithm(k.q, v.Mod(v, k.q))
+}
+
+// TokenTo returns the token moving ciphertexts to another fake key, the quotient of the keys
+func (k *Keys) TokenTo(other phewrap.Keys) (phewrap.Token, error) {
+	to, ok := other.(*Keys)
+
+	if !ok {
+		return phewrap.Token{}, errors.New("tokens move ciphertexts between fake keys only")
+	}
+
+	t := new(big.Int).Mul(to.k, new(big.Int).ModInverse(k.k, k.q))
+
+	return phewrap.NewToken(&phe.Token{T1: scalar(t.Mod(t, k.q)), T2: scalar(big.NewInt(1))}), nil
+}
@@ -0,0 +1,43 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package phefake
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+func TestKeyProperties(t *testing.T) {
+	if _, err := NewKeys(Prime); err == nil {
+		t.Fatalf("NewKeys accepted a key of 0 modulo Prime")
+	}
+
+	var _ phewrap.Keys = new(Keys)
+
+	properties := gopter.NewProperties(nil)
+	key := gen.Int64Range(1, Prime-1)
+	value := gen.Int64Range(0, 10000)
+
+	properties.Property("values decrypt to themselves", prop.ForAll(func(k int64, v int64) bool {
+		keys, _ := NewKeys(k)
+
+		return keys.Decrypt(keys.Encrypt(big.NewInt(v))).Cmp(big.NewRat(v, 1)) == 0
+	}, key, value))
+
+	properties.Property("tokens move values between keys", prop.ForAll(func(k1 int64, k2 int64, v int64) bool {
+		keys, _ := NewKeys(k1)
+		other, _ := NewKeys(k2)
+		token, err := keys.TokenTo(other)
+
+		return err == nil && other.Decrypt(keys.Modulus().KeyUpdate(token, keys.Encrypt(big.NewInt(v)))).Cmp(big.NewRat(v, 1)) == 0
+	}, key, key, value))
+
+	properties.TestingRun(t)
+}
@@ -0,0 +1,421 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package phewrap wraps the phe library for the contract and its client applications.
+// Values are parsed and validated once into typed ciphertexts, moduli and tokens, the
+// operations never alter their operands, and encodings are compared in constant time.
+package phewrap
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/hanesbarbosa/phe"
+)
+
+// ciphertextFormat matches the encoding of a multivector by phe, its 8 coefficients in order
+var ciphertextFormat = regexp.MustCompile(`^(\d+)e0\+(\d+)e1\+(\d+)e2\+(\d+)e3\+(\d+)e12\+(\d+)e13\+(\d+)e23\+(\d+)e123$`)
+
+// Encoding is the text format of a ciphertext. Decimal is the format of phe, and the others
+// are tagged with their name and a colon, followed by the 8 coefficients separated by dots:
+// in lowercase hex, or big-endian in unpadded base64url with zero empty.
+type Encoding string
+
+// Encodings
+const (
+	EncodingDecimal   Encoding = "dec"
+	EncodingHex       Encoding = "hex"
+	EncodingBase64URL Encoding = "b64u"
+)
+
+// Encodings are the encodings of ciphertexts
+var Encodings = []Encoding{EncodingDecimal, EncodingHex, EncodingBase64URL}
+
+// ParseEncoding parses the name of an encoding
+func ParseEncoding(s string) (Encoding, error) {
+	for _, e := range Encodings {
+		if string(e) == s {
+			return e, nil
+		}
+	}
+
+	return "", fmt.Errorf("encoding %s is not one of dec, hex and b64u", s)
+}
+
+// ErrEmpty is returned when a mean is computed over no ciphertexts
+var ErrEmpty = errors.New("no ciphertexts")
+
+// Modulus is the public modulus q of a key, the operations on ciphertexts reduce modulo it
+type Modulus struct {
+	q *big.Int
+}
+
+// ParseModulus parses a decimal modulus greater than 1
+func ParseModulus(s string) (Modulus, error) {
+	q, ok := new(big.Int).SetString(s, 10)
+
+	if !ok || q.Cmp(big.NewInt(1)) <= 0 {
+		return Modulus{}, fmt.Errorf("modulo %s is not an integer greater than 1", s)
+	}
+
+	return Modulus{q: q}, nil
+}
+
+// Int returns a copy of the modulus
+func (m Modulus) Int() *big.Int {
+	return new(big.Int).Set(m.q)
+}
+
+// String returns the decimal modulus
+func (m Modulus) String() string {
+	return m.q.String()
+}
+
+func (m Modulus) publicKey() *phe.PublicKey {
+	return &phe.PublicKey{Q: m.q}
+}
+
+// Ciphertext is an encrypted value, a multivector of phe, and the encoding it was parsed
+// from. Operations keep the encoding of their operands, the first tagged one in sums, so
+// values computed from tagged ciphertexts are written in their encoding.
+type Ciphertext struct {
+	m   *phe.Multivector
+	enc Encoding
+}
+
+// coefficients returns the coefficients of a multivector in order
+func coefficients(m *phe.Multivector) []*big.Int {
+	return []*big.Int{m.E0, m.E1, m.E2, m.E3, m.E12, m.E13, m.E23, m.E123}
+}
+
+// Zero returns the ciphertext sums start from
+func Zero() Ciphertext {
+	return Ciphertext{m: phe.NewMultivector([]string{"0", "0", "0", "0", "0", "0", "0", "0"})}
+}
+
+// ParseCiphertext parses a ciphertext in any encoding. phe panics on malformed decimal ones.
+func ParseCiphertext(s string) (Ciphertext, error) {
+	for _, e := range []Encoding{EncodingHex, EncodingBase64URL} {
+		if strings.HasPrefix(s, string(e)+":") {
+			return parseTagged(e, strings.TrimPrefix(s, string(e)+":"))
+		}
+	}
+
+	coefficients := ciphertextFormat.FindStringSubmatch(s)
+
+	if coefficients == nil {
+		return Ciphertext{}, errors.New("not an encoded multivector")
+	}
+
+	return Ciphertext{m: phe.NewMultivector(coefficients[1:])}, nil
+}
+
+// parseTagged parses the coefficients of a tagged ciphertext
+func parseTagged(e Encoding, s string) (Ciphertext, error) {
+	parts := strings.Split(s, ".")
+
+	if len(parts) != 8 {
+		return Ciphertext{}, fmt.Errorf("not 8 %s coefficients", e)
+	}
+
+	decimals := make([]string, 8)
+
+	for i, part := range parts {
+		n := new(big.Int)
+
+		switch e {
+		case EncodingHex:
+			if _, ok := n.SetString(part, 16); !ok || strings.HasPrefix(part, "-") || strings.HasPrefix(part, "+") {
+				return Ciphertext{}, fmt.Errorf("coefficient %d is not hex", i)
+			}
+		case EncodingBase64URL:
+			b, err := base64.RawURLEncoding.DecodeString(part)
+
+			if err != nil {
+				return Ciphertext{}, fmt.Errorf("coefficient %d is not base64url", i)
+			}
+
+			n.SetBytes(b)
+		}
+
+		decimals[i] = n.String()
+	}
+
+	return Ciphertext{m: phe.NewMultivector(decimals), enc: e}, nil
+}
+
+// ParseCiphertexts parses encoded multivectors, naming the position of the first malformed one
+func ParseCiphertexts(ss []string) ([]Ciphertext, error) {
+	cs := make([]Ciphertext, len(ss))
+
+	for i, s := range ss {
+		c, err := ParseCiphertext(s)
+
+		if err != nil {
+			return nil, fmt.Errorf("ciphertext %d is %s", i, err.Error())
+		}
+
+		cs[i] = c
+	}
+
+	return cs, nil
+}
+
+// FromMultivector wraps a copy of a multivector
+func FromMultivector(m *phe.Multivector) Ciphertext {
+	return Ciphertext{m: phe.CloneMultivector(m)}
+}
+
+// Encoding returns the encoding of the ciphertext, decimal unless it was parsed from another
+func (c Ciphertext) Encoding() Encoding {
+	if c.enc == "" {
+		return EncodingDecimal
+	}
+
+	return c.enc
+}
+
+// In returns the ciphertext to be written in an encoding
+func (c Ciphertext) In(e Encoding) Ciphertext {
+	return Ciphertext{m: c.m, enc: e}
+}
+
+// Multivector returns a copy of the multivector of the ciphertext
+func (c Ciphertext) Multivector() *phe.Multivector {
+	return phe.CloneMultivector(c.m)
+}
+
+// String returns the ciphertext in its encoding
+func (c Ciphertext) String() string {
+	return c.Encode(c.Encoding())
+}
+
+// Encode returns the ciphertext in an encoding
+func (c Ciphertext) Encode(e Encoding) string {
+	if e == EncodingDecimal || e == "" {
+		return c.m.ToString()
+	}
+
+	parts := make([]string, 8)
+
+	for i, n := range coefficients(c.m) {
+		if e == EncodingHex {
+			parts[i] = n.Text(16)
+		} else {
+			parts[i] = base64.RawURLEncoding.EncodeToString(n.Bytes())
+		}
+	}
+
+	return string(e) + ":" + strings.Join(parts, ".")
+}
+
+// Convert rewrites a ciphertext in another encoding
+func Convert(s string, e Encoding) (string, error) {
+	c, err := ParseCiphertext(s)
+
+	if err != nil {
+		return "", err
+	}
+
+	return c.Encode(e), nil
+}
+
+// Equal compares the values of two ciphertexts in constant time, whatever their encodings
+func (c Ciphertext) Equal(other Ciphertext) bool {
+	return EqualStrings(c.Encode(EncodingDecimal), other.Encode(EncodingDecimal))
+}
+
+// Validate checks that every coefficient of a ciphertext is reduced modulo q
+func (m Modulus) Validate(c Ciphertext) error {
+	for _, e := range coefficients(c.m) {
+		if e.Sign() < 0 || e.Cmp(m.q) >= 0 {
+			return fmt.Errorf("ciphertext is not reduced modulo %s", m.q.String())
+		}
+	}
+
+	return nil
+}
+
+// Add sums ciphertexts
+func (m Modulus) Add(cs ...Ciphertext) Ciphertext {
+	total := Zero()
+
+	for _, c := range cs {
+		total.m = phe.Addition(m.publicKey(), total.m, c.m)
+
+		if total.enc == "" {
+			total.enc = c.enc
+		}
+	}
+
+	return total
+}
+
+// Scale multiplies a ciphertext by a scalar
+func (m Modulus) Scale(c Ciphertext, k int64) Ciphertext {
+	return Ciphertext{m: phe.ScalarMultiplication(c.Multivector(), new(big.Int).Mod(big.NewInt(k), m.q), m.q), enc: c.enc}
+}
+
+// Negate returns the ciphertext of the opposite of a value, as the scheme subtracts by adding it
+func (m Modulus) Negate(c Ciphertext) Ciphertext {
+	return Ciphertext{m: phe.ScalarMultiplication(c.Multivector(), new(big.Int).Sub(m.q, big.NewInt(1)), m.q), enc: c.enc}
+}
+
+// Divide divides a ciphertext by a scalar invertible modulo q
+func (m Modulus) Divide(c Ciphertext, n int64) (Ciphertext, error) {
+	if new(big.Int).ModInverse(big.NewInt(n), m.q) == nil {
+		return Ciphertext{}, fmt.Errorf("%d is not invertible modulo %s", n, m.q.String())
+	}
+
+	return Ciphertext{m: phe.ScalarDivision(m.publicKey(), c.Multivector(), big.NewInt(n)), enc: c.enc}, nil
+}
+
+// Mean averages ciphertexts
+func (m Modulus) Mean(cs []Ciphertext) (Ciphertext, error) {
+	if len(cs) == 0 {
+		return Ciphertext{}, ErrEmpty
+	}
+
+	return m.Divide(m.Add(cs...), int64(len(cs)))
+}
+
+// Token moves ciphertexts from one key to another of the same modulus
+type Token struct {
+	t *phe.Token
+}
+
+// ParseToken parses the two encoded multivectors of a token
+func ParseToken(first string, second string) (Token, error) {
+	t1, err := ParseCiphertext(first)
+
+	if err != nil {
+		return Token{}, fmt.Errorf("first token is %s", err.Error())
+	}
+
+	t2, err := ParseCiphertext(second)
+
+	if err != nil {
+		return Token{}, fmt.Errorf("second token is %s", err.Error())
+	}
+
+	return Token{t: &phe.Token{T1: t1.m, T2: t2.m}}, nil
+}
+
+// NewToken wraps a token generated by phe
+func NewToken(t *phe.Token) Token {
+	return Token{t: &phe.Token{T1: phe.CloneMultivector(t.T1), T2: phe.CloneMultivector(t.T2)}}
+}
+
+// Strings returns the encodings of the two multivectors of the token
+func (t Token) Strings() (string, string) {
+	return t.t.T1.ToString(), t.t.T2.ToString()
+}
+
+// KeyUpdate moves a ciphertext to the key of a token
+func (m Modulus) KeyUpdate(t Token, c Ciphertext) Ciphertext {
+	return Ciphertext{m: phe.KeyUpdate(m.publicKey(), t.t, c.m), enc: c.enc}
+}
+
+// ParseKeys parses a secret key and its public key from their decimal and encoded parts
+func ParseKeys(k1 string, k2 string, g string, b int64, q string) (*phe.SecretKey, *phe.PublicKey, error) {
+	modulus, err := ParseModulus(q)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	generator, ok := new(big.Int).SetString(g, 10)
+
+	if !ok {
+		return nil, nil, fmt.Errorf("g %s is not an integer", g)
+	}
+
+	first, err := ParseCiphertext(k1)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("k1 is %s", err.Error())
+	}
+
+	second, err := ParseCiphertext(k2)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("k2 is %s", err.Error())
+	}
+
+	return &phe.SecretKey{K1: first.m, K2: second.m, G: generator}, &phe.PublicKey{B: b, Q: modulus.q}, nil
+}
+
+// Encrypt encrypts a value under a key
+func Encrypt(sk *phe.SecretKey, pk *phe.PublicKey, value *big.Int) Ciphertext {
+	return Ciphertext{m: phe.Encrypt(sk, pk, value)}
+}
+
+// Decrypt decrypts a ciphertext with a copy of the secret key, which phe alters
+func Decrypt(sk *phe.SecretKey, pk *phe.PublicKey, c Ciphertext) *big.Rat {
+	return phe.Decrypt(cloneSecretKey(sk), pk, c.Multivector())
+}
+
+func cloneSecretKey(sk *phe.SecretKey) *phe.SecretKey {
+	return &phe.SecretKey{K1: phe.CloneMultivector(sk.K1), K2: phe.CloneMultivector(sk.K2), G: new(big.Int).Set(sk.G)}
+}
+
+// EqualStrings compares two strings in constant time, as for ciphertexts and hashes
+func EqualStrings(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Keys encrypts and decrypts values under a key and issues the tokens moving its ciphertexts
+// to another key. PHEKeys implements it with phe, and tests substitute a fake for it.
+type Keys interface {
+	Modulus() Modulus
+	Encrypt(value *big.Int) Ciphertext
+	Decrypt(c Ciphertext) *big.Rat
+	TokenTo(other Keys) (Token, error)
+}
+
+// PHEKeys is a secret key of phe and its public key
+type PHEKeys struct {
+	sk *phe.SecretKey
+	pk *phe.PublicKey
+}
+
+// NewKeys wraps a secret key of phe and its public key
+func NewKeys(sk *phe.SecretKey, pk *phe.PublicKey) *PHEKeys {
+	return &PHEKeys{sk: sk, pk: pk}
+}
+
+// Modulus returns the modulus of the public key
+func (k *PHEKeys) Modulus() Modulus {
+	return Modulus{q: new(big.Int).Set(k.pk.Q)}
+}
+
+// Encrypt encrypts a value under the key
+func (k *PHEKeys) Encrypt(value *big.Int) Ciphertext {
+	return Encrypt(k.sk, k.pk, value)
+}
+
+// Decrypt decrypts a ciphertext under the key
+func (k *PHEKeys) Decrypt(c Ciphertext) *big.Rat {
+	return Decrypt(k.sk, k.pk, c)
+}
+
+// TokenTo returns the token moving ciphertexts to other keys of phe of the same modulus and size
+func (k *PHEKeys) TokenTo(other Keys) (Token, error) {
+	to, ok := other.(*PHEKeys)
+
+	if !ok {
+		return Token{}, errors.New("tokens move ciphertexts between keys of phe only")
+	}
+
+	if k.pk.B != to.pk.B || k.pk.Q.Cmp(to.pk.Q) != 0 {
+		return Token{}, errors.New("keys of different moduli or sizes")
+	}
+
+	return Token{t: phe.GenerateToken(cloneSecretKey(k.sk), cloneSecretKey(to.sk), k.pk, to.pk)}, nil
+}
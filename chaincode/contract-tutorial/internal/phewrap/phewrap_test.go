@@ -0,0 +1,145 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package phewrap
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/hanesbarbosa/phe"
+)
+
+func TestParse(t *testing.T) {
+	if _, err := ParseModulus("1"); err == nil {
+		t.Fatalf("ParseModulus accepted 1")
+	}
+
+	for _, s := range []string{"", "0", "1e0+2e1", "1e0+2e1+3e2+4e3+5e12+6e13+7e23+-8e123"} {
+		if _, err := ParseCiphertext(s); err == nil {
+			t.Fatalf("ParseCiphertext accepted %q", s)
+		}
+	}
+
+	c, err := ParseCiphertext("1e0+2e1+3e2+4e3+5e12+6e13+7e23+8e123")
+
+	if err != nil {
+		t.Fatalf("ParseCiphertext failed. %s", err.Error())
+	}
+
+	q, _ := ParseModulus("7")
+
+	if err := q.Validate(c); err == nil {
+		t.Fatalf("Validate accepted a coefficient of 8 modulo 7")
+	}
+}
+
+func TestOperations(t *testing.T) {
+	sk, pk := phe.GenerateKeys(256)
+	q, _ := ParseModulus(pk.Q.String())
+	cs := []Ciphertext{}
+
+	for _, value := range []int64{3, 5, 10} {
+		cs = append(cs, Encrypt(sk, pk, big.NewInt(value)))
+	}
+
+	first, second := cs[0].String(), cs[1].String()
+	m, err := q.Mean(cs)
+
+	if err != nil {
+		t.Fatalf("Mean failed. %s", err.Error())
+	}
+
+	if cs[0].String() != first || cs[1].String() != second {
+		t.Fatalf("Mean altered its operands")
+	}
+
+	if d := Decrypt(sk, pk, m); d.Cmp(big.NewRat(6, 1)) != 0 {
+		t.Fatalf("Expected a mean of 6, got %s", d.String())
+	}
+
+	// Subtractions decrypt correctly as long as the result is not negative
+	d := Decrypt(sk, pk, q.Add(cs[2], cs[1], q.Negate(cs[0])))
+
+	if d.Cmp(big.NewRat(12, 1)) != 0 {
+		t.Fatalf("Expected a difference of 12, got %s", d.String())
+	}
+
+	if d := Decrypt(sk, pk, q.Scale(cs[1], 4)); d.Cmp(big.NewRat(20, 1)) != 0 {
+		t.Fatalf("Expected a product of 20, got %s", d.String())
+	}
+
+	if _, err := q.Mean(nil); err != ErrEmpty {
+		t.Fatalf("Mean accepted no ciphertexts, %v", err)
+	}
+
+	if !cs[0].Equal(FromMultivector(cs[0].Multivector())) || cs[0].Equal(cs[1]) {
+		t.Fatalf("Equal does not compare the encodings")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	sk, pk := phe.GenerateKeys(256)
+	otherSK, _ := phe.GenerateKeys(256)
+	keys, other := NewKeys(sk, pk), NewKeys(otherSK, pk)
+	c := keys.Encrypt(big.NewInt(42))
+
+	token, err := keys.TokenTo(other)
+
+	if err != nil {
+		t.Fatalf("TokenTo failed. %s", err.Error())
+	}
+
+	if d := other.Decrypt(keys.Modulus().KeyUpdate(token, c)); d.Cmp(big.NewRat(42, 1)) != 0 {
+		t.Fatalf("Expected 42 under the other key, got %s", d.String())
+	}
+
+	if d := keys.Decrypt(c); d.Cmp(big.NewRat(42, 1)) != 0 {
+		t.Fatalf("Decrypt altered the key, got %s", d.String())
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	sk, pk := phe.GenerateKeys(256)
+	q, _ := ParseModulus(pk.Q.String())
+	c := Encrypt(sk, pk, big.NewInt(9))
+
+	for _, e := range Encodings {
+		parsed, err := ParseCiphertext(c.Encode(e))
+
+		if err != nil {
+			t.Fatalf("ParseCiphertext failed on %s. %s", e, err.Error())
+		}
+
+		if parsed.Encoding() != e || parsed.String() != c.Encode(e) || !parsed.Equal(c) {
+			t.Fatalf("Expected %s to round trip, got %s", e, parsed.String())
+		}
+	}
+
+	if hex := c.Encode(EncodingHex); len(hex) >= len(c.String()) {
+		t.Fatalf("Expected hex to be shorter than decimal, got %d and %d", len(hex), len(c.String()))
+	}
+
+	for _, s := range []string{"hex:1.2.3", "hex:1.2.3.4.5.6.7.-8", "hex:1.2.3.4.5.6.7.g", "b64u:A.B.C.D.E.F.G.H=", "b64u:"} {
+		if _, err := ParseCiphertext(s); err == nil {
+			t.Fatalf("ParseCiphertext accepted %q", s)
+		}
+	}
+
+	// Sums keep the encoding of the first tagged operand
+	b64, _ := ParseCiphertext(c.Encode(EncodingBase64URL))
+	sum := q.Add(c, b64, c.In(EncodingHex))
+
+	if sum.Encoding() != EncodingBase64URL || Decrypt(sk, pk, sum).Cmp(big.NewRat(27, 1)) != 0 {
+		t.Fatalf("Expected a sum of 27 in b64u, got %s", sum.String())
+	}
+
+	if converted, err := Convert(sum.String(), EncodingDecimal); err != nil || converted != sum.Encode(EncodingDecimal) {
+		t.Fatalf("Convert failed, got %s", converted)
+	}
+
+	if _, err := ParseEncoding("base32"); err == nil {
+		t.Fatalf("ParseEncoding accepted base32")
+	}
+}
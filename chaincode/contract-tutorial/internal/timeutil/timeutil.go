@@ -0,0 +1,125 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package timeutil handles the timestamps and dates of the contract. Timestamps are RFC 3339
+// strings, dates YYYY-MM-DD strings and months YYYY-MM strings, validated when they enter the contract, always
+// written in UTC and compared as parsed times, never as strings, so offsets don't matter.
+package timeutil
+
+import (
+	"fmt"
+	"time"
+)
+
+// Layouts of the timestamps and dates of the contract
+const (
+	Layout      = time.RFC3339
+	DayLayout   = "2006-01-02"
+	MonthLayout = "2006-01"
+)
+
+// Parse parses an RFC 3339 timestamp into UTC
+func Parse(s string) (time.Time, error) {
+	t, err := time.Parse(Layout, s)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not a valid RFC 3339 timestamp", s)
+	}
+
+	return t.UTC(), nil
+}
+
+// ParseOptional parses an RFC 3339 timestamp into UTC, the zero time if it is empty
+func ParseOptional(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	return Parse(s)
+}
+
+// ParseDay parses a YYYY-MM-DD date into the start of its UTC day
+func ParseDay(s string) (time.Time, error) {
+	t, err := time.Parse(DayLayout, s)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not a YYYY-MM-DD date", s)
+	}
+
+	return t, nil
+}
+
+// ParseMonth parses a YYYY-MM month into the start of its first UTC day
+func ParseMonth(s string) (time.Time, error) {
+	t, err := time.Parse(MonthLayout, s)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not a YYYY-MM month", s)
+	}
+
+	return t, nil
+}
+
+// Format writes a time as an RFC 3339 timestamp in UTC
+func Format(t time.Time) string {
+	return t.UTC().Format(Layout)
+}
+
+// Day writes the UTC day of a time as a YYYY-MM-DD date
+func Day(t time.Time) string {
+	return t.UTC().Format(DayLayout)
+}
+
+// Month returns the YYYY-MM month of a time in UTC
+func Month(t time.Time) string {
+	return t.UTC().Format(MonthLayout)
+}
+
+// FromUnix returns the UTC time of a timestamp in seconds and nanoseconds, as the shim gives
+// the timestamp of a transaction
+func FromUnix(seconds int64, nanos int32) time.Time {
+	return time.Unix(seconds, int64(nanos)).UTC()
+}
+
+// Expired tells whether an optional RFC 3339 deadline has passed at now. An empty or
+// malformed deadline never passes.
+func Expired(deadline string, now time.Time) bool {
+	if deadline == "" {
+		return false
+	}
+
+	t, err := Parse(deadline)
+
+	return err == nil && now.After(t)
+}
+
+// Compare compares two RFC 3339 timestamps as times, -1, 0 or 1 as a is before, at or after b.
+// Malformed timestamps sort before every valid one.
+func Compare(a string, b string) int {
+	ta, errA := Parse(a)
+	tb, errB := Parse(b)
+
+	switch {
+	case errA != nil || errB != nil:
+		return compareValidity(errA == nil, errB == nil)
+	case ta.Before(tb):
+		return -1
+	case ta.After(tb):
+		return 1
+	}
+
+	return 0
+}
+
+// compareValidity orders a valid timestamp after a malformed one
+func compareValidity(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+
+	return -1
+}
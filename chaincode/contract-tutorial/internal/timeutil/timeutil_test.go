@@ -0,0 +1,87 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package timeutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"", "2024-01-01", "2024-01-01 10:00:00", "2024-13-01T00:00:00Z", "yesterday"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse accepted %q", s)
+		}
+	}
+
+	ts, err := Parse("2024-01-01T02:00:00+02:00")
+
+	if err != nil {
+		t.Fatalf("Parse failed. %s", err.Error())
+	}
+
+	if ts.Location() != time.UTC || Format(ts) != "2024-01-01T00:00:00Z" {
+		t.Errorf("Expected 2024-01-01T00:00:00Z, got %s", Format(ts))
+	}
+
+	if ts, err := ParseOptional(""); err != nil || !ts.IsZero() {
+		t.Errorf("ParseOptional didn't return the zero time for an empty timestamp")
+	}
+
+	if _, err := ParseDay("2024-02-30"); err == nil {
+		t.Errorf("ParseDay accepted 2024-02-30")
+	}
+
+	if day, err := ParseDay("2024-02-29"); err != nil || Day(day) != "2024-02-29" {
+		t.Errorf("ParseDay failed on 2024-02-29")
+	}
+
+	if Day(FromUnix(1704067199, 999999999)) != "2023-12-31" {
+		t.Errorf("FromUnix didn't return the UTC day")
+	}
+
+	if _, err := ParseMonth("2024-13"); err == nil {
+		t.Errorf("ParseMonth accepted 2024-13")
+	}
+
+	if month, err := ParseMonth("2024-02"); err != nil || Month(month.AddDate(0, 1, -1)) != "2024-02" {
+		t.Errorf("ParseMonth failed on 2024-02")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	now, _ := Parse("2024-01-01T12:00:00Z")
+
+	for _, c := range []struct {
+		deadline string
+		expired  bool
+	}{
+		{"", false},
+		{"never", false},
+		{"2024-01-01T12:00:00Z", false},
+		{"2024-01-01T13:00:00+02:00", true},
+		{"2024-01-01T11:00:00-02:00", false},
+	} {
+		if Expired(c.deadline, now) != c.expired {
+			t.Errorf("Expected Expired(%q) to be %v", c.deadline, c.expired)
+		}
+	}
+
+	for _, c := range []struct {
+		a, b     string
+		expected int
+	}{
+		// A string comparison orders these the other way around
+		{"2024-01-01T10:00:00+02:00", "2024-01-01T09:00:00Z", -1},
+		{"2024-01-01T10:00:00+02:00", "2024-01-01T08:00:00Z", 0},
+		{"2024-01-01T08:00:00-02:00", "2024-01-01T09:00:00Z", 1},
+		{"", "2024-01-01T09:00:00Z", -1},
+		{"", "malformed", 0},
+	} {
+		if got := Compare(c.a, c.b); got != c.expected {
+			t.Errorf("Expected Compare(%q, %q) to be %d, got %d", c.a, c.b, c.expected, got)
+		}
+	}
+}
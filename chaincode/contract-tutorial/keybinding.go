@@ -0,0 +1,119 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// KeyBinding tells whether a record is bound to the key it claims. Mismatches lists the
+// fingerprint when the record's differs from the key's, and the fields holding values that
+// aren't ciphertexts under the modulus of the key.
+type KeyBinding struct {
+	RecordID          string   `json:"recordID"`
+	KeyID             string   `json:"keyID"`
+	KeyFingerprint    string   `json:"keyFingerprint"`
+	RecordFingerprint string   `json:"recordFingerprint,omitempty" metadata:",optional"`
+	Bound             bool     `json:"bound"`
+	Mismatches        []string `json:"mismatches"`
+}
+
+// boundCiphertexts returns the key, the recorded fingerprint and the ciphertexts by field of
+// a patient, a result or a result set
+func (s *SimpleContract) boundCiphertexts(ctx contractapi.TransactionContextInterface, id string) (string, string, map[string]string, error) {
+	if strings.HasPrefix(id, "RESULTSET") {
+		resultSet, err := s.FindResultSet(ctx, id)
+
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		values := map[string]string{}
+
+		for metric, value := range resultSet.Values {
+			values["values."+metric] = value
+		}
+
+		return resultSet.KeyID, resultSet.KeyFingerprint, values, nil
+	}
+
+	if strings.HasPrefix(id, "RESULT") {
+		result, err := s.FindResult(ctx, id)
+
+		if err != nil {
+			return "", "", nil, err
+		}
+
+		return result.KeyID, result.KeyFingerprint, map[string]string{"value": result.Value}, nil
+	}
+
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	values := map[string]string{FieldPreExistingConditions: patient.PreExistingConditions}
+
+	for metric, value := range patient.Measurements {
+		values[FieldMeasurements+"."+metric] = value
+	}
+
+	return patient.KeyID, patient.KeyFingerprint, values, nil
+}
+
+// VerifyKeyBinding checks that a patient, a result or a result set is encrypted under the key
+// it claims: the fingerprint recorded with it must be the fingerprint of the registered key,
+// and its values must be ciphertexts under the modulus of the key. Mis-tagged records are
+// caught before proposals aggregate them with others. The key must be registered.
+func (s *SimpleContract) VerifyKeyBinding(ctx contractapi.TransactionContextInterface, id string) (*KeyBinding, error) {
+	keyID, fingerprint, values, err := s.boundCiphertexts(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := findKeyRecord(ctx, keyID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return nil, newError(CodeNotFound, map[string]string{"id": id, "keyID": keyID}, "%s is not registered, %s can't be checked against it", keyID, id)
+	}
+
+	binding := &KeyBinding{RecordID: id, KeyID: keyID, KeyFingerprint: key.fingerprint(), RecordFingerprint: fingerprint, Mismatches: []string{}}
+
+	if fingerprint != "" && fingerprint != binding.KeyFingerprint {
+		binding.Mismatches = append(binding.Mismatches, "keyFingerprint")
+	}
+
+	q, err := parseModulus(key.Modulo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []string{}
+
+	for field, value := range values {
+		c, err := parseCiphertext(field, value)
+
+		if err != nil || q.Validate(c) != nil {
+			fields = append(fields, field)
+		}
+	}
+
+	sort.Strings(fields)
+
+	binding.Mismatches = append(binding.Mismatches, fields...)
+	binding.Bound = len(binding.Mismatches) == 0
+
+	return binding, nil
+}
@@ -0,0 +1,368 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// keyExpiryNotice is how long before its expiry the owner of a key in use is notified
+const keyExpiryNotice = 30 * 24 * time.Hour
+
+// keyUsageIndex is the composite key namespace of the computations and records counted on
+// each key. Every transaction writes its own entries, by transaction ID, and reads sum them.
+const keyUsageIndex = "key~usage"
+
+// Key usage counters
+const (
+	counterComputations = "computations"
+	counterRecords      = "records"
+)
+
+// Key statuses
+const (
+	KeyActive  = "ACTIVE"
+	KeyRotated = "ROTATED"
+)
+
+// KeyRecord is the registry entry of a phe key. ExpiryNotified is set
+// once the owner has been notified of the coming expiry. Fingerprint identifies
+// the public key material, the modulus, on the records encrypted under the key.
+// Computations and Records are stored as compacted, FindKey adds the entries since.
+type KeyRecord struct {
+	OwnerMSP       string   `json:"ownerMSP"`
+	Modulo         string   `json:"modulo"`
+	Fingerprint    string   `json:"fingerprint,omitempty" metadata:",optional"`
+	Status         string   `json:"status"`
+	RotatedTo      string   `json:"rotatedTo"`
+	UsageLimit     int      `json:"usageLimit"`
+	Expiry         string   `json:"expiry"`
+	Computations   int      `json:"computations"`
+	Records        int      `json:"records"`
+	ExpiryNotified bool     `json:"expiryNotified"`
+	Metadata       Metadata `json:"metadata"`
+}
+
+// RegisterKey adds a key to the registry. A usage limit of 0 does not limit the
+// number of computations and an empty expiry never expires.
+func (s *SimpleContract) RegisterKey(ctx contractapi.TransactionContextInterface, id string, modulo string, usageLimit int, expiry string) error {
+	if err := checkIDFormat(ctx, EntityKey, id); err != nil {
+		return err
+	}
+
+	keyAsBytes, err := cachedState(ctx, id)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if keyAsBytes != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": id}, "%s already exists", id)
+	}
+
+	if usageLimit < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"usageLimit": fmt.Sprint(usageLimit)}, "Usage limit must not be negative")
+	}
+
+	if expiry != "" {
+		if _, err := parseTimestamp("expiry", expiry); err != nil {
+			return err
+		}
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key := KeyRecord{
+		OwnerMSP:    metadata.Created.MSPID,
+		Modulo:      modulo,
+		Fingerprint: keyFingerprint(modulo),
+		Status:      KeyActive,
+		UsageLimit:  usageLimit,
+		Expiry:      expiry,
+		Metadata:    metadata,
+	}
+
+	if err := putIndexEntry(ctx, keyOwnerIndex, key.OwnerMSP, id); err != nil {
+		return err
+	}
+
+	keyAsBytes, _ = json.Marshal(key)
+
+	return putCachedState(ctx, id, keyAsBytes)
+}
+
+// FindKey returns a registered key
+func (s *SimpleContract) FindKey(ctx contractapi.TransactionContextInterface, id string) (*KeyRecord, error) {
+	keyAsBytes, err := cachedState(ctx, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if keyAsBytes == nil {
+		return nil, errNotFound(id)
+	}
+
+	key := new(KeyRecord)
+	_ = json.Unmarshal(keyAsBytes, key)
+
+	if _, err := countKeyUsage(ctx, id, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// countKeyUsage adds to a key the computations and records counted on it since it was
+// compacted, returning the keys of their entries
+func countKeyUsage(ctx contractapi.TransactionContextInterface, id string, key *KeyRecord) ([]string, error) {
+	computations, computationKeys, err := sumCountEntries(ctx, keyUsageIndex, id, counterComputations)
+
+	if err != nil {
+		return nil, err
+	}
+
+	records, recordKeys, err := sumCountEntries(ctx, keyUsageIndex, id, counterRecords)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key.Computations += computations
+	key.Records += records
+
+	return append(computationKeys, recordKeys...), nil
+}
+
+// CompactKeyUsage folds the computations and records counted on a key of the caller's org
+// into its registry entry, so reads sum fewer entries, and returns the number of entries
+// folded. Computations and records counted concurrently invalidate it rather than conflict
+// with one another, and it is run again.
+func (s *SimpleContract) CompactKeyUsage(ctx contractapi.TransactionContextInterface, id string) (int, error) {
+	key, err := findKeyRecord(ctx, id)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if key == nil {
+		return 0, errNotFound(id)
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if mspID != key.OwnerMSP {
+		return 0, newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can compact the usage of %s", key.OwnerMSP, id)
+	}
+
+	keys, err := countKeyUsage(ctx, id, key)
+
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	for _, entryKey := range keys {
+		if err := ctx.GetStub().DelState(entryKey); err != nil {
+			return 0, err
+		}
+	}
+
+	keyAsBytes, _ := json.Marshal(key)
+
+	return len(keys), putCachedState(ctx, id, keyAsBytes)
+}
+
+// RotateKey marks a key as replaced by another registered key
+func (s *SimpleContract) RotateKey(ctx contractapi.TransactionContextInterface, id string, newKeyID string) error {
+	// The entry is written back as stored, without the usage counted since it was compacted
+	key, err := findKeyRecord(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if key == nil {
+		return errNotFound(id)
+	}
+
+	if _, err := s.FindKey(ctx, newKeyID); err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != key.OwnerMSP {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can rotate %s", key.OwnerMSP, id)
+	}
+
+	key.Status = KeyRotated
+	key.RotatedTo = newKeyID
+
+	if err := key.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	keyAsBytes, _ := json.Marshal(key)
+
+	return putCachedState(ctx, id, keyAsBytes)
+}
+
+// keyFingerprint returns the SHA-256 hash of the scheme and the modulus of a key
+func keyFingerprint(modulo string) string {
+	// Moduli are hashed in their canonical decimal form, whatever the caller passed
+	if q, err := phewrap.ParseModulus(modulo); err == nil {
+		modulo = q.String()
+	}
+
+	return hashString(SchemePHE + "\x00" + modulo)
+}
+
+// fingerprint returns the fingerprint of a key, computed for keys registered before they were recorded
+func (k *KeyRecord) fingerprint() string {
+	if k.Fingerprint != "" {
+		return k.Fingerprint
+	}
+
+	return keyFingerprint(k.Modulo)
+}
+
+// registeredFingerprint returns the fingerprint of a key to record on the records encrypted
+// under it, empty if the key is not registered
+func registeredFingerprint(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	key, err := findKeyRecord(ctx, id)
+
+	if err != nil || key == nil {
+		return "", err
+	}
+
+	return key.fingerprint(), nil
+}
+
+// findKeyRecord returns the registry entry of a key, or nil if it is not registered
+func findKeyRecord(ctx contractapi.TransactionContextInterface, id string) (*KeyRecord, error) {
+	keyAsBytes, err := cachedState(ctx, id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if keyAsBytes == nil {
+		return nil, nil
+	}
+
+	key := new(KeyRecord)
+
+	if err := json.Unmarshal(keyAsBytes, key); err != nil || key.Status == "" {
+		return nil, newError(CodeInvalidArgument, map[string]string{"id": id}, "%s is not a key", id)
+	}
+
+	return key, nil
+}
+
+// checkKeyUsable refuses keys that are rotated, expired or overused.
+// Keys that are not registered are not accounted for and can always be used.
+func checkKeyUsable(ctx contractapi.TransactionContextInterface, id string) error {
+	key, err := findKeyRecord(ctx, id)
+
+	if err != nil || key == nil {
+		return err
+	}
+
+	if key.Status == KeyRotated {
+		return newError(CodeInvalidState, map[string]string{"id": id, "rotatedTo": key.RotatedTo}, "%s was rotated to %s", id, key.RotatedTo)
+	}
+
+	if key.Expiry != "" {
+		now, err := txTime(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if timeutil.Expired(key.Expiry, now) {
+			return newError(CodeInvalidState, map[string]string{"id": id, "expiry": key.Expiry}, "%s expired at %s, rotate it with RotateKey", id, key.Expiry)
+		}
+	}
+
+	if key.UsageLimit == 0 {
+		return nil
+	}
+
+	// Only limited keys read their computations, which the computations of the key then conflict over
+	computations, _, err := sumCountEntries(ctx, keyUsageIndex, id, counterComputations)
+
+	if err != nil {
+		return err
+	}
+
+	if key.Computations+computations >= key.UsageLimit {
+		return newError(CodeInvalidState, map[string]string{"id": id, "usageLimit": fmt.Sprint(key.UsageLimit)}, "%s reached its limit of %d computations, rotate it with RotateKey", id, key.UsageLimit)
+	}
+
+	return nil
+}
+
+// accountKeyUsage adds computations and records referencing a registered key, in entries of
+// the transaction's own. The registry entry is only written once to record that its owner was
+// notified of its coming expiry.
+func accountKeyUsage(ctx contractapi.TransactionContextInterface, id string, computations int, records int) error {
+	key, err := findKeyRecord(ctx, id)
+
+	if err != nil || key == nil {
+		return err
+	}
+
+	for _, usage := range []struct {
+		counter string
+		n       int
+	}{{counterComputations, computations}, {counterRecords, records}} {
+		if usage.n == 0 {
+			continue
+		}
+
+		if err := putCountEntry(ctx, keyUsageIndex, usage.n, id, usage.counter); err != nil {
+			return err
+		}
+	}
+
+	if key.Expiry != "" && !key.ExpiryNotified {
+		now, err := txTime(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if timeutil.Expired(key.Expiry, now.Add(keyExpiryNotice)) {
+			if err := notify(ctx, key.OwnerMSP, NotificationKeyExpiring, id, fmt.Sprintf("%s expires at %s, rotate it with RotateKey", id, key.Expiry)); err != nil {
+				return err
+			}
+
+			key.ExpiryNotified = true
+			keyAsBytes, _ := json.Marshal(key)
+
+			return putCachedState(ctx, id, keyAsBytes)
+		}
+	}
+
+	return nil
+}
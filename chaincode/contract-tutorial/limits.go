@@ -0,0 +1,156 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Built-in limits of the executions, protecting the endorsing peers from transactions that
+// would run for minutes
+const (
+	// defaultMaxCohort is the most patients a transaction executes a proposal over
+	defaultMaxCohort = 5000
+	// defaultMaxCiphertextLength is the most bytes of a ciphertext an execution parses
+	defaultMaxCiphertextLength = 65536
+	// defaultMaxExecutionSteps is the most patients a transaction reads while executing, a
+	// patient read once per metric or pass over the cohort
+	defaultMaxExecutionSteps = 50000
+)
+
+// ExecutionLimits are the limits of the executions in effect, the configured ones or else the
+// built-in ones
+type ExecutionLimits struct {
+	MaxCohort           int `json:"maxCohort"`
+	MaxCiphertextLength int `json:"maxCiphertextLength"`
+	MaxExecutionSteps   int `json:"maxExecutionSteps"`
+}
+
+// executionMeter counts the steps of the execution of a transaction
+type executionMeter struct {
+	limits ExecutionLimits
+	steps  int
+}
+
+// executionLimits returns the limits of the executions in effect
+func executionLimits(ctx contractapi.TransactionContextInterface) (ExecutionLimits, error) {
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return ExecutionLimits{}, err
+	}
+
+	limits := ExecutionLimits{MaxCohort: defaultMaxCohort, MaxCiphertextLength: defaultMaxCiphertextLength, MaxExecutionSteps: defaultMaxExecutionSteps}
+
+	if config.MaxCohort > 0 {
+		limits.MaxCohort = config.MaxCohort
+	}
+
+	if config.MaxCiphertextLength > 0 {
+		limits.MaxCiphertextLength = config.MaxCiphertextLength
+	}
+
+	if config.MaxExecutionSteps > 0 {
+		limits.MaxExecutionSteps = config.MaxExecutionSteps
+	}
+
+	return limits, nil
+}
+
+// checkCohortLimit refuses to execute a proposal over more patients than a transaction may
+func checkCohortLimit(ctx contractapi.TransactionContextInterface, id string, size int) error {
+	limits, err := executionLimits(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if size > limits.MaxCohort {
+		return newError(CodeQuotaExceeded, map[string]string{"id": id, "cohortSize": fmt.Sprint(size), "maxCohort": fmt.Sprint(limits.MaxCohort)}, "%s has %d patients, a transaction executes at most %d, execute means in chunks with ExecuteProposalChunk", id, size, limits.MaxCohort)
+	}
+
+	return nil
+}
+
+// meterPatient counts the read of a patient by the execution of the transaction, aborting it
+// once it read more patients than the step limit or when a ciphertext of the patient is
+// longer than the limit, before any is parsed. The meter lives in the context of the
+// transaction, so contexts other than CachingContext aren't metered.
+func meterPatient(ctx contractapi.TransactionContextInterface, pid string, patient *Patient) error {
+	c, ok := ctx.(*CachingContext)
+
+	if !ok {
+		return nil
+	}
+
+	if c.meter == nil {
+		limits, err := executionLimits(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		c.meter = &executionMeter{limits: limits}
+	}
+
+	c.meter.steps++
+
+	if c.meter.steps > c.meter.limits.MaxExecutionSteps {
+		return newError(CodeQuotaExceeded, map[string]string{"patientID": pid, "maxExecutionSteps": fmt.Sprint(c.meter.limits.MaxExecutionSteps)}, "The transaction was aborted after reading %d patients, execute smaller cohorts or in chunks", c.meter.limits.MaxExecutionSteps)
+	}
+
+	values := []string{patient.PreExistingConditions}
+
+	for _, value := range patient.Measurements {
+		values = append(values, value)
+	}
+
+	for _, value := range values {
+		if len(value) > c.meter.limits.MaxCiphertextLength {
+			return newError(CodeQuotaExceeded, map[string]string{"patientID": pid, "length": fmt.Sprint(len(value)), "maxCiphertextLength": fmt.Sprint(c.meter.limits.MaxCiphertextLength)}, "A ciphertext of %s has %d bytes, executions parse at most %d", pid, len(value), c.meter.limits.MaxCiphertextLength)
+		}
+	}
+
+	return nil
+}
+
+// SetExecutionLimits sets the most patients a transaction executes a proposal over, the
+// longest ciphertext it parses and the most patient reads it makes, aborting the executions
+// beyond them with RESOURCE_EXHAUSTED. A limit of 0 restores the built-in one.
+func (s *SimpleContract) SetExecutionLimits(ctx contractapi.TransactionContextInterface, maxCohort int, maxCiphertextLength int, maxExecutionSteps int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if maxCohort < 0 || maxCiphertextLength < 0 || maxExecutionSteps < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"maxCohort": fmt.Sprint(maxCohort), "maxCiphertextLength": fmt.Sprint(maxCiphertextLength), "maxExecutionSteps": fmt.Sprint(maxExecutionSteps)}, "The limits can't be negative")
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.MaxCohort = maxCohort
+	config.MaxCiphertextLength = maxCiphertextLength
+	config.MaxExecutionSteps = maxExecutionSteps
+
+	return putConfig(ctx, config)
+}
+
+// GetExecutionLimits returns the limits of the executions in effect, the size of the chunks
+// of ExecuteProposalChunk among them
+func (s *SimpleContract) GetExecutionLimits(ctx contractapi.TransactionContextInterface) (*ExecutionLimits, error) {
+	limits, err := executionLimits(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &limits, nil
+}
@@ -0,0 +1,155 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TokenSubmission is a transaction that moved a result from a key to another with a token,
+// the release of the result from the key of the cohort first
+type TokenSubmission struct {
+	FromKeyID string             `json:"fromKeyID"`
+	ToKeyID   string             `json:"toKeyID"`
+	Submitted TransactionDetails `json:"submitted"`
+}
+
+// LineageKey is a key of the lineage of a result, nil Record for unregistered keys
+type LineageKey struct {
+	ID     string     `json:"id"`
+	Record *KeyRecord `json:"record,omitempty" metadata:",optional"`
+}
+
+// ResultLineage is the chain of records a result or result set was computed from. PatientsIDs
+// is only returned to the custodian of the cohort, others identify it by CohortHash, the hash
+// of the comma separated patient IDs of the proposal.
+type ResultLineage struct {
+	ResultID        string               `json:"resultID"`
+	ProposalID      string               `json:"proposalID"`
+	ProtocolID      string               `json:"protocolID"`
+	Operation       string               `json:"operation"`
+	RequesterMSP    string               `json:"requesterMSP"`
+	CustodianMSP    string               `json:"custodianMSP"`
+	CohortHash      string               `json:"cohortHash"`
+	CohortSize      int                  `json:"cohortSize"`
+	PatientsIDs     []string             `json:"patientsIDs,omitempty" metadata:",optional"`
+	Pseudonyms      []string             `json:"pseudonyms,omitempty" metadata:",optional"`
+	SourceResultIDs []string             `json:"sourceResultIDs,omitempty" metadata:",optional"`
+	Proposed        TransactionDetails   `json:"proposed"`
+	Approvals       []TransactionDetails `json:"approvals"`
+	Executed        TransactionDetails   `json:"executed"`
+	ReceiptID       string               `json:"receiptID,omitempty" metadata:",optional"`
+	Tokens          []TokenSubmission    `json:"tokens"`
+	Keys            []LineageKey         `json:"keys"`
+	Decrypted       *TransactionDetails  `json:"decrypted,omitempty" metadata:",optional"`
+}
+
+// GetResultLineage compiles the lineage of a result or result set from the references its
+// records hold: the cohort and proposal it was computed from, the approvals and execution of
+// the proposal, the token submissions that released and rekeyed it with the keys involved,
+// in order, and the attestation of its decryption
+func (s *SimpleContract) GetResultLineage(ctx contractapi.TransactionContextInterface, resultID string) (*ResultLineage, error) {
+	var proposalID, keyID, receiptID string
+	var manifest Manifest
+	var rekeys []Rekey
+	var released TransactionDetails
+
+	if strings.HasPrefix(resultID, "RESULTSET") {
+		resultSet, err := s.FindResultSet(ctx, resultID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		proposalID, keyID, receiptID, manifest, rekeys, released = resultSet.ProposalID, resultSet.KeyID, resultSet.ReceiptID, resultSet.Manifest, resultSet.Rekeys, resultSet.Metadata.Created
+	} else {
+		result, err := s.FindResult(ctx, resultID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		proposalID, keyID, receiptID, manifest, rekeys, released = result.ProposalID, result.KeyID, result.ReceiptID, result.Manifest, result.Rekeys, result.Metadata.Created
+	}
+
+	proposal, err := s.FindProposal(ctx, proposalID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	lineage := &ResultLineage{
+		ResultID:        resultID,
+		ProposalID:      proposalID,
+		ProtocolID:      proposal.ProtocolID,
+		Operation:       proposal.Operation,
+		RequesterMSP:    proposal.RequesterID,
+		CustodianMSP:    proposal.RequestedID,
+		CohortHash:      hashString(proposal.PatientsIDs),
+		CohortSize:      manifest.CohortSize,
+		Pseudonyms:      manifest.Pseudonyms,
+		SourceResultIDs: proposal.ResultIDs,
+		Proposed:        proposal.Metadata.Created,
+		Approvals:       proposal.Approvals,
+		// Nothing updates an executed proposal, its last update is its execution
+		Executed:  proposal.Metadata.Updated,
+		ReceiptID: receiptID,
+		Tokens:    []TokenSubmission{},
+		Keys:      []LineageKey{},
+	}
+
+	if mspID == proposal.RequestedID {
+		lineage.PatientsIDs = strings.Split(proposal.PatientsIDs, ",")
+	}
+
+	// The keys the result went through, from the key of the cohort to its current key
+	keyIDs := []string{proposal.KeyID}
+	submitted := []TransactionDetails{released}
+
+	for _, rekey := range rekeys {
+		keyIDs = append(keyIDs, rekey.FromKeyID)
+		submitted = append(submitted, rekey.Rekeyed)
+	}
+
+	keyIDs = append(keyIDs, keyID)
+
+	for i, details := range submitted {
+		lineage.Tokens = append(lineage.Tokens, TokenSubmission{FromKeyID: keyIDs[i], ToKeyID: keyIDs[i+1], Submitted: details})
+	}
+
+	for i, id := range keyIDs {
+		if i > 0 && id == keyIDs[i-1] {
+			continue
+		}
+
+		record, err := findKeyRecord(ctx, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lineage.Keys = append(lineage.Keys, LineageKey{ID: id, Record: record})
+	}
+
+	attestation, err := s.GetDecryptionAttestation(ctx, resultID)
+
+	if contractError, ok := err.(*ContractError); err != nil && (!ok || contractError.Code != CodeNotFound) {
+		return nil, err
+	}
+
+	if attestation != nil {
+		lineage.Decrypted = &attestation.Submitted
+	}
+
+	return lineage, nil
+}
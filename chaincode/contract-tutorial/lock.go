@@ -0,0 +1,107 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// patientLockIndex is the composite key namespace of patients locked by approved proposals
+const patientLockIndex = "patient~lock"
+
+// lockCohort locks the encrypted measurements of the proposal's patients
+// from the approval of the proposal until it is executed or expires
+func lockCohort(ctx contractapi.TransactionContextInterface, proposalID string, proposal *Proposal) error {
+	for _, pid := range strings.Split(proposal.PatientsIDs, ",") {
+		key, err := ctx.GetStub().CreateCompositeKey(patientLockIndex, []string{pid, proposalID})
+
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unlockCohort releases the locks taken by lockCohort
+func unlockCohort(ctx contractapi.TransactionContextInterface, proposalID string, proposal *Proposal) error {
+	for _, pid := range strings.Split(proposal.PatientsIDs, ",") {
+		key, err := ctx.GetStub().CreateCompositeKey(patientLockIndex, []string{pid, proposalID})
+
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// holdsLock tells whether a proposal still holds the locks it took on approval: approved
+// proposals do until they expire. Locks left by proposals no longer approved, or purged,
+// are ignored.
+func holdsLock(ctx contractapi.TransactionContextInterface, proposalID string, now time.Time) (bool, error) {
+	proposalAsBytes, err := ctx.GetStub().GetState(proposalID)
+
+	if err != nil || proposalAsBytes == nil {
+		return false, err
+	}
+
+	proposal := new(Proposal)
+	_ = json.Unmarshal(proposalAsBytes, proposal)
+
+	return proposal.Status == ProposalApproved && !timeutil.Expired(proposal.Expiry, now), nil
+}
+
+// patientLock returns the ID of a proposal holding a lock on the patient, if any
+func patientLock(ctx contractapi.TransactionContextInterface, patientID string) (string, error) {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(patientLockIndex, []string{patientID})
+
+	if err != nil {
+		return "", err
+	}
+
+	lock := ""
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		held, err := holdsLock(ctx, keyParts[1], now)
+
+		if err != nil || !held {
+			return err
+		}
+
+		lock = keyParts[1]
+
+		return ledgeriter.ErrStop
+	})
+
+	return lock, err
+}
@@ -1,13 +1,40 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
 package main
 
 import (
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
 
-func main() {
+// newChaincode returns the chaincode serving every version of the API
+func newChaincode() (*contractapi.ContractChaincode, error) {
 	simpleContract := new(SimpleContract)
+	simpleContract.Name = APIVersion2
+	simpleContract.Info.Version = ContractVersion
+	simpleContract.Info.Description = "The current API, with composite key indexes, validation and error envelopes"
+	simpleContract.TransactionContextHandler = new(CachingContext)
+	simpleContract.BeforeTransaction = beforeTransaction
+
+	// The first contract is the default one, called without a version
+	cc, err := contractapi.NewChaincode(newLegacyContract(), simpleContract, newReportingContract())
+
+	if err != nil {
+		return nil, err
+	}
+
+	contractMetadata, err = documentMetadata(cc)
 
-	cc, err := contractapi.NewChaincode(simpleContract)
+	if err != nil {
+		return nil, err
+	}
+
+	return cc, nil
+}
+
+func main() {
+	cc, err := newChaincode()
 
 	if err != nil {
 		panic(err.Error())
@@ -0,0 +1,161 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// metaAnalysisInput is the metric of the receipt inputs of a meta-analysis, the hashes of its results
+const metaAnalysisInput = "RESULT"
+
+// resultManifest is the manifest of a result combined by a meta-analysis
+type resultManifest struct {
+	id       string
+	manifest Manifest
+}
+
+// CreateMetaAnalysisProposal requests the combination of prior results of the requester under
+// the same key. The results must share their operation and metrics, and define their metrics
+// alike, and the cohort of the proposal is the cohorts of the results put together, so it is
+// approved as any other.
+func (s *SimpleContract) CreateMetaAnalysisProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, resultIDs string, keyID string, expiry string) error {
+	if done, err := beginRequest(ctx, "CreateMetaAnalysisProposal", id, protocolID, requesterID, requestedID, resultIDs, keyID, expiry); err != nil || done {
+		return err
+	}
+
+	ids := splitList(resultIDs)
+
+	if len(ids) < 2 {
+		return newError(CodeInvalidArgument, map[string]string{"resultIDs": resultIDs}, "A meta-analysis combines at least two results")
+	}
+
+	var source *Proposal
+	manifests := []resultManifest{}
+	pids := []string{}
+	seen := map[string]bool{}
+
+	for _, resultID := range ids {
+		if seen[resultID] {
+			return newError(CodeInvalidArgument, map[string]string{"resultIDs": resultIDs}, "Results must be distinct")
+		}
+
+		seen[resultID] = true
+
+		result, err := s.FindResult(ctx, resultID)
+
+		if err != nil {
+			return err
+		}
+
+		if result.Value == "" {
+			return newError(CodeInvalidArgument, map[string]string{"resultID": resultID}, "%s is not a single-valued result", resultID)
+		}
+
+		if result.KeyID != keyID {
+			return newError(CodeInvalidArgument, map[string]string{"resultID": resultID, "keyID": result.KeyID}, "%s is encrypted under %s, not %s", resultID, result.KeyID, keyID)
+		}
+
+		proposal, err := s.FindProposal(ctx, result.ProposalID)
+
+		if err != nil {
+			return err
+		}
+
+		if proposal.RequesterID != requesterID {
+			return newError(CodePermissionDenied, map[string]string{"resultID": resultID, "requesterID": proposal.RequesterID}, "%s was released to %s", resultID, proposal.RequesterID)
+		}
+
+		if source == nil {
+			source = proposal
+		} else if proposal.Operation != source.Operation || strings.Join(proposal.Metrics, ",") != strings.Join(source.Metrics, ",") {
+			return newError(CodeInvalidArgument, map[string]string{"resultID": resultID, "operation": proposal.Operation}, "%s does not compute the same operation and metrics as %s", resultID, ids[0])
+		}
+
+		for _, defined := range manifests {
+			if err := checkCompatibleDefinitions(resultID, result.Manifest, defined.id, defined.manifest); err != nil {
+				return err
+			}
+		}
+
+		manifests = append(manifests, resultManifest{id: resultID, manifest: result.Manifest})
+
+		pids = append(pids, strings.Split(proposal.PatientsIDs, ",")...)
+	}
+
+	proposal, err := s.newProposal(ctx, id, protocolID, requesterID, requestedID, strings.Join(pids, ","), keyID, source.Operation, expiry, source.Metrics)
+
+	if err != nil {
+		return err
+	}
+
+	proposal.ResultIDs = ids
+
+	return s.storeNewProposal(ctx, id, proposal)
+}
+
+// combineResults computes the value of a meta-analysis. Counts are added up, and means are
+// weighted by the cohort sizes of their results before being divided by the total size.
+func (s *SimpleContract) combineResults(ctx contractapi.TransactionContextInterface, proposal *Proposal, modulo string) (string, error) {
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return "", err
+	}
+
+	total := phewrap.Zero()
+	size := int64(0)
+
+	for _, resultID := range proposal.ResultIDs {
+		result, err := s.FindResult(ctx, resultID)
+
+		if err != nil {
+			return "", err
+		}
+
+		value, err := parseCiphertext(resultID, result.Value)
+
+		if err != nil {
+			return "", err
+		}
+
+		if proposal.Operation == OperationMean {
+			value = q.Scale(value, int64(result.Manifest.CohortSize))
+		}
+
+		total = q.Add(total, value)
+		size += int64(result.Manifest.CohortSize)
+	}
+
+	if proposal.Operation == OperationMean {
+		total, err = q.Divide(total, size)
+
+		if err != nil {
+			return "", newError(CodeInvalidArgument, map[string]string{"modulo": modulo}, "Can't weight the results. %s", err.Error())
+		}
+	}
+
+	return total.String(), nil
+}
+
+// resultHashes returns the receipt input of a meta-analysis
+func (s *SimpleContract) resultHashes(ctx contractapi.TransactionContextInterface, proposal *Proposal) (ReceiptInput, error) {
+	input := ReceiptInput{Metric: metaAnalysisInput, Hashes: []string{}}
+
+	for _, resultID := range proposal.ResultIDs {
+		result, err := s.FindResult(ctx, resultID)
+
+		if err != nil {
+			return ReceiptInput{}, err
+		}
+
+		input.Hashes = append(input.Hashes, hashString(result.Value))
+	}
+
+	return input, nil
+}
@@ -0,0 +1,367 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// DefaultMetric names the encrypted value stored in the patient's PreExistingConditions
+const DefaultMetric = "preExistingConditions"
+
+// Manifest describes the computation behind a result. The cohort is referenced
+// by the pseudonyms of the patients in the study, never by their IDs.
+type Manifest struct {
+	Operation      string                 `json:"operation"`
+	Metrics        []string               `json:"metrics"`
+	CohortSize     int                    `json:"cohortSize"`
+	AsOf           string                 `json:"asOf,omitempty" metadata:",optional"`
+	Pseudonyms     []string               `json:"pseudonyms,omitempty" metadata:",optional"`
+	GroupBy        string                 `json:"groupBy,omitempty" metadata:",optional"`
+	Excluded       int                    `json:"excluded,omitempty" metadata:",optional"`
+	ExclusionsHash string                 `json:"exclusionsHash,omitempty" metadata:",optional"`
+	Noisy          bool                   `json:"noisy,omitempty" metadata:",optional"`
+	Definitions    []MeasurementSemantics `json:"definitions,omitempty" metadata:",optional"`
+	Coverage       *Coverage              `json:"coverage,omitempty" metadata:",optional"`
+}
+
+// newManifest describes the computation of an executed proposal
+func (s *SimpleContract) newManifest(ctx contractapi.TransactionContextInterface, proposalID string, proposal *Proposal) (Manifest, error) {
+	pids := proposal.aggregatedPatients()
+	metrics := proposal.Metrics
+
+	if len(metrics) == 0 {
+		metrics = []string{DefaultMetric}
+	}
+
+	// The proposal is the study the patients are pseudonymized for
+	pseudonyms, err := cohortPseudonyms(ctx, proposalID, proposal, pids)
+
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	definitions, err := s.manifestDefinitions(ctx, proposal, metrics)
+
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	coverage, err := s.coverage(ctx, proposal)
+
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	return Manifest{
+		Operation:      proposal.Operation,
+		Metrics:        metrics,
+		CohortSize:     len(pids),
+		AsOf:           proposal.AsOf,
+		Pseudonyms:     pseudonyms,
+		GroupBy:        proposal.GroupBy,
+		Excluded:       len(proposal.Exclusions),
+		ExclusionsHash: proposal.ExclusionsHash,
+		Noisy:          proposal.Noise != "",
+		Definitions:    definitions,
+		Coverage:       coverage,
+	}, nil
+}
+
+// ResultSet holds one ciphertext per metric of a multi-metric proposal, or per group of a grouped one
+type ResultSet struct {
+	ProposalID        string            `json:"proposalID"`
+	KeyID             string            `json:"keyID"`
+	KeyFingerprint    string            `json:"keyFingerprint,omitempty" metadata:",optional"`
+	Values            map[string]string `json:"values"`
+	GroupSizes        map[string]int    `json:"groupSizes,omitempty" metadata:",optional"`
+	DistinctDiagnoses int               `json:"distinctDiagnoses,omitempty" metadata:",optional"`
+	Manifest          Manifest          `json:"manifest"`
+	ReceiptID         string            `json:"receiptID,omitempty" metadata:",optional"`
+	Rekeys            []Rekey           `json:"rekeys,omitempty" metadata:",optional"`
+	Metadata          Metadata          `json:"metadata"`
+}
+
+// measurement returns the encrypted value of a metric of the patient
+func measurement(patient *Patient, metric string) (string, bool) {
+	if metric == "" || metric == DefaultMetric {
+		return patient.PreExistingConditions, patient.PreExistingConditions != ""
+	}
+
+	value, ok := patient.Measurements[metric]
+
+	return value, ok
+}
+
+// cohortPatient returns a patient of a cohort, as it was at asOf unless it is empty, within
+// the execution limits of the transaction
+func (s *SimpleContract) cohortPatient(ctx contractapi.TransactionContextInterface, pid string, asOf string) (*Patient, error) {
+	patient, err := patientAt(ctx, pid, asOf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := meterPatient(ctx, pid, patient); err != nil {
+		return nil, err
+	}
+
+	return patient, nil
+}
+
+// patientAt returns a patient as it was at asOf unless it is empty, from the world state
+func patientAt(ctx contractapi.TransactionContextInterface, pid string, asOf string) (*Patient, error) {
+	if asOf == "" {
+		return findPatient(ctx, pid)
+	}
+
+	cutoff, err := timeutil.Parse(asOf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return patientAtCutoff(ctx, pid, cutoff)
+}
+
+// cohortValues returns the encrypted values of a metric for every patient,
+// as they were at asOf unless it is empty
+func (s *SimpleContract) cohortValues(ctx contractapi.TransactionContextInterface, pids []string, metric string, asOf string) ([]string, error) {
+	var ms []string
+
+	for _, pid := range pids {
+		patient, err := s.cohortPatient(ctx, pid, asOf)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value, ok := measurement(patient, metric)
+
+		if !ok {
+			return nil, newError(CodeNotFound, map[string]string{"id": pid, "metric": metric}, "%s has no %s measurement", pid, metric)
+		}
+
+		ms = append(ms, value)
+	}
+
+	return ms, nil
+}
+
+// SetPatientMeasurement stores an encrypted measurement of a patient
+func (s *SimpleContract) SetPatientMeasurement(ctx contractapi.TransactionContextInterface, id string, metric string, value string) error {
+	if metric == "" || metric == DefaultMetric {
+		return newError(CodeInvalidArgument, map[string]string{"metric": metric}, "%s is set by UpdatePatient", DefaultMetric)
+	}
+
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := checkNotFrozen(id, patient); err != nil {
+		return err
+	}
+
+	proposalID, err := patientLock(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposalID != "" {
+		return newError(CodeInvalidState, map[string]string{"id": id, "proposalID": proposalID}, "%s is locked by %s until it is executed", id, proposalID)
+	}
+
+	before := trackedFields(patient)
+
+	if patient.Measurements == nil {
+		patient.Measurements = map[string]string{}
+	}
+
+	patient.Measurements[metric] = value
+
+	if err := patient.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	if err := emitPatientUpdated(ctx, id, before, patient); err != nil {
+		return err
+	}
+
+	return putPatient(ctx, id, patient)
+}
+
+// CreateMultiMetricProposal requests the mean of several comma separated metrics in one approval cycle
+func (s *SimpleContract) CreateMultiMetricProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, metrics string, expiry string) error {
+	if done, err := beginRequest(ctx, "CreateMultiMetricProposal", id, protocolID, requesterID, requestedID, patientsIDs, keyID, metrics, expiry); err != nil || done {
+		return err
+	}
+
+	seen := map[string]bool{}
+	metricsList := []string{}
+
+	for _, metric := range strings.Split(metrics, ",") {
+		if metric == "" || seen[metric] {
+			return newError(CodeInvalidArgument, map[string]string{"metrics": metrics}, "Metrics must be distinct and not empty")
+		}
+
+		seen[metric] = true
+		metricsList = append(metricsList, metric)
+	}
+
+	proposal, err := s.proposeCohort(ctx, id, protocolID, requesterID, requestedID, patientsIDs, keyID, OperationMean, expiry, metricsList)
+
+	if err != nil {
+		return err
+	}
+
+	return s.storeNewProposal(ctx, id, proposal)
+}
+
+// resultSetID returns the ID of the result set of a proposal, derived from the whole proposal ID
+func resultSetID(proposalID string) string {
+	return "RESULTSET-" + proposalID
+}
+
+// CreateResultSet moves every value of an executed multi-metric or grouped proposal to the requester's key
+func (s *SimpleContract) CreateResultSet(ctx contractapi.TransactionContextInterface, proposalID string, firstToken string, secondToken string, keyID string, modulo string) error {
+	proposal, err := s.FindProposal(ctx, proposalID)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalExecuted {
+		return newError(CodeInvalidState, map[string]string{"id": proposalID, "status": proposal.Status}, "%s has not been executed", proposalID)
+	}
+
+	if !proposal.multiValued() {
+		return newError(CodeInvalidArgument, map[string]string{"id": proposalID}, "%s has a single value, its result is created by CreateResult", proposalID)
+	}
+
+	id := resultSetID(proposalID)
+
+	if err := checkUnreleased(ctx, proposalID, proposal); err != nil {
+		return err
+	}
+
+	if err := checkKeyUsable(ctx, keyID); err != nil {
+		return err
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	manifest, err := s.newManifest(ctx, proposalID, proposal)
+
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := registeredFingerprint(ctx, keyID)
+
+	if err != nil {
+		return err
+	}
+
+	resultSet := ResultSet{
+		ProposalID:        proposalID,
+		KeyID:             keyID,
+		KeyFingerprint:    fingerprint,
+		Values:            map[string]string{},
+		GroupSizes:        proposal.GroupSizes,
+		DistinctDiagnoses: proposal.DistinctDiagnoses,
+		Manifest:          manifest,
+		Metadata:          metadata,
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return err
+	}
+
+	token, err := parseToken(firstToken, secondToken)
+
+	if err != nil {
+		return err
+	}
+
+	for name, value := range proposal.Values {
+		c, err := parseCiphertext(proposalID, value)
+
+		if err != nil {
+			return err
+		}
+
+		resultSet.Values[name] = q.KeyUpdate(token, c).String()
+	}
+
+	resultSet.ReceiptID, err = s.putComputationReceipt(ctx, id, proposalID, proposal, keyID, modulo, outputHash("", resultSet.Values))
+
+	if err != nil {
+		return err
+	}
+
+	if err := accountKeyUsage(ctx, keyID, 1, 1); err != nil {
+		return err
+	}
+
+	if err := indexResultKey(ctx, keyID, id); err != nil {
+		return err
+	}
+
+	if err := putIndexEntry(ctx, resultOrgIndex, proposal.RequesterID, id); err != nil {
+		return err
+	}
+
+	if err := indexAuditRecord(ctx, AuditRecordResult, id); err != nil {
+		return err
+	}
+
+	if err := notify(ctx, proposal.RequesterID, NotificationResultReleased, id, fmt.Sprintf("%s of %s has been released", id, proposalID)); err != nil {
+		return err
+	}
+
+	if err := emitEvent(ctx, EventResultSetReleased, &ResultReleasedEvent{ResultID: id, ProposalID: proposalID}); err != nil {
+		return err
+	}
+
+	if err := markReleased(ctx, proposalID, proposal); err != nil {
+		return err
+	}
+
+	resultSetAsBytes, _ := json.Marshal(resultSet)
+
+	return ctx.GetStub().PutState(id, resultSetAsBytes)
+}
+
+// FindResultSet returns the result set of a multi-valued proposal
+func (s *SimpleContract) FindResultSet(ctx contractapi.TransactionContextInterface, id string) (*ResultSet, error) {
+	resultSetAsBytes, err := ctx.GetStub().GetState(id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if resultSetAsBytes == nil {
+		return nil, errNotFound(id)
+	}
+
+	resultSet := new(ResultSet)
+	_ = json.Unmarshal(resultSetAsBytes, resultSet)
+
+	return resultSet, nil
+}
@@ -0,0 +1,269 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// KeyPartial is the encrypted sum of the patients of a multi-key proposal under one key, and
+// its translation to the key of the proposal by the holder of the key
+type KeyPartial struct {
+	KeyID      string              `json:"keyID"`
+	HolderMSP  string              `json:"holderMSP"`
+	Patients   int                 `json:"patients"`
+	Value      string              `json:"value"`
+	Translated string              `json:"translated,omitempty" metadata:",optional"`
+	Translator *TransactionDetails `json:"translator,omitempty" metadata:",optional"`
+}
+
+// CreateMultiKeyProposal requests the mean of the cohort when its patients are encrypted under
+// different keys, keyID being the key the requester designates for the mean. Executing it
+// computes a partial sum per key, the holder of each key translates its partial to keyID with
+// SubmitKeyTranslation, and CombineKeyPartials adds the translated partials up.
+func (s *SimpleContract) CreateMultiKeyProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, expiry string) error {
+	if done, err := beginRequest(ctx, "CreateMultiKeyProposal", id, protocolID, requesterID, requestedID, patientsIDs, keyID, expiry); err != nil || done {
+		return err
+	}
+
+	proposal, err := s.proposeCohort(ctx, id, protocolID, requesterID, requestedID, patientsIDs, keyID, OperationMean, expiry, nil)
+
+	if err != nil {
+		return err
+	}
+
+	proposal.MultiKey = true
+
+	return s.storeNewProposal(ctx, id, proposal)
+}
+
+// keyHolder returns the org translating the partials under a key: the owner of a registered
+// key, the custodian of the proposal otherwise
+func keyHolder(ctx contractapi.TransactionContextInterface, keyID string, proposal *Proposal) (string, error) {
+	key, err := findKeyRecord(ctx, keyID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if key == nil {
+		return proposal.RequestedID, nil
+	}
+
+	return key.OwnerMSP, nil
+}
+
+// executePartials computes the partial sums of a multi-key proposal, one per key of its
+// patients in order of key ID, and asks the holders of the keys other than the proposal's to
+// translate them. The partial under the key of the proposal needs no translation.
+func (s *SimpleContract) executePartials(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal, pids []string, modulo string) error {
+	if len(proposal.Partials) > 0 {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "The partials of %s are computed, combine them with CombineKeyPartials", id)
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return err
+	}
+
+	sums := map[string]phewrap.Ciphertext{}
+	counts := map[string]int{}
+
+	for _, pid := range pids {
+		patient, err := s.cohortPatient(ctx, pid, proposal.AsOf)
+
+		if err != nil {
+			return err
+		}
+
+		c, err := parseCiphertext(pid, patient.PreExistingConditions)
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := sums[patient.KeyID]; !ok {
+			sums[patient.KeyID] = phewrap.Zero()
+		}
+
+		sums[patient.KeyID] = q.Add(sums[patient.KeyID], c)
+		counts[patient.KeyID]++
+	}
+
+	keyIDs := []string{}
+
+	for keyID := range sums {
+		keyIDs = append(keyIDs, keyID)
+	}
+
+	sort.Strings(keyIDs)
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	for _, keyID := range keyIDs {
+		if err := checkKeyUsable(ctx, keyID); err != nil {
+			return withDetail(err, "id", id)
+		}
+
+		holderMSP, err := keyHolder(ctx, keyID, proposal)
+
+		if err != nil {
+			return err
+		}
+
+		partial := KeyPartial{KeyID: keyID, HolderMSP: holderMSP, Patients: counts[keyID], Value: sums[keyID].String()}
+
+		if keyID == proposal.KeyID {
+			partial.Translated = partial.Value
+			partial.Translator = &details
+		} else {
+			if err := accountKeyUsage(ctx, keyID, 1, 0); err != nil {
+				return err
+			}
+
+			message := fmt.Sprintf("The partial of %s under %s awaits its translation to %s", id, keyID, proposal.KeyID)
+
+			if err := notify(ctx, holderMSP, NotificationKeyTranslationRequested, id, message); err != nil {
+				return err
+			}
+		}
+
+		proposal.Partials = append(proposal.Partials, partial)
+	}
+
+	proposal.Metadata.Updated = details
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// SubmitKeyTranslation translates the partial of a multi-key proposal under a key to the key of
+// the proposal with a token from one to the other, as results are released. Only the holder of
+// the key submits it, once.
+func (s *SimpleContract) SubmitKeyTranslation(ctx contractapi.TransactionContextInterface, id string, keyID string, firstToken string, secondToken string, modulo string) error {
+	proposal, err := s.findExecutableProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	var partial *KeyPartial
+
+	for i := range proposal.Partials {
+		if proposal.Partials[i].KeyID == keyID {
+			partial = &proposal.Partials[i]
+		}
+	}
+
+	if partial == nil {
+		return newError(CodeNotFound, map[string]string{"id": id, "keyID": keyID}, "%s has no partial under %s", id, keyID)
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != partial.HolderMSP {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "keyID": keyID, "mspID": details.MSPID}, "Only %s can translate the partial of %s under %s", partial.HolderMSP, id, keyID)
+	}
+
+	if partial.Translator != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": id, "keyID": keyID}, "The partial of %s under %s is already translated", id, keyID)
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return err
+	}
+
+	token, err := parseToken(firstToken, secondToken)
+
+	if err != nil {
+		return err
+	}
+
+	value, err := parseCiphertext(id, partial.Value)
+
+	if err != nil {
+		return err
+	}
+
+	partial.Translated = q.KeyUpdate(token, value).String()
+	partial.Translator = &details
+	proposal.Metadata.Updated = details
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// CombineKeyPartials completes the execution of a multi-key proposal once every partial is
+// translated, adding them up under the key of the proposal and dividing by the cohort size
+func (s *SimpleContract) CombineKeyPartials(ctx contractapi.TransactionContextInterface, id string, modulo string) error {
+	proposal, err := s.findExecutableProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if !proposal.MultiKey || len(proposal.Partials) == 0 {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s has no partials to combine", id)
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return err
+	}
+
+	pending := []string{}
+	total := phewrap.Zero()
+	size := int64(0)
+
+	for _, partial := range proposal.Partials {
+		if partial.Translator == nil {
+			pending = append(pending, partial.KeyID)
+			continue
+		}
+
+		value, err := parseCiphertext(id, partial.Translated)
+
+		if err != nil {
+			return err
+		}
+
+		total = q.Add(total, value)
+		size += int64(partial.Patients)
+	}
+
+	if len(pending) > 0 {
+		return newError(CodeInvalidState, map[string]string{"id": id, "keyIDs": strings.Join(pending, ",")}, "The partials of %s under %s await their translation", id, strings.Join(pending, ", "))
+	}
+
+	total, err = q.Divide(total, size)
+
+	if err != nil {
+		return newError(CodeInvalidArgument, map[string]string{"modulo": modulo}, "Can't average the partials. %s", err.Error())
+	}
+
+	proposal.Value = total.String()
+
+	return completeExecution(ctx, id, proposal, proposal.aggregatedPatients(), modulo)
+}
@@ -0,0 +1,179 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProposalCountered is the status of a proposal waiting for the requester
+// to answer a counter-proposal of the custodian
+const ProposalCountered = "COUNTERED"
+
+// Counter-proposal statuses
+const (
+	CounterOpen     = "OPEN"
+	CounterAccepted = "ACCEPTED"
+	CounterRejected = "REJECTED"
+)
+
+// CounterProposal is an entry of the negotiation thread of a proposal
+type CounterProposal struct {
+	PatientsIDs string              `json:"patientsIDs"`
+	Operation   string              `json:"operation"`
+	Expiry      string              `json:"expiry"`
+	Note        string              `json:"note"`
+	Status      string              `json:"status"`
+	Proposed    TransactionDetails  `json:"proposed"`
+	Answered    *TransactionDetails `json:"answered,omitempty" metadata:",optional"`
+}
+
+// CounterPropose answers a pending proposal with different terms. The custodian
+// proposing them accepts them, so the proposal is approved once the requester accepts.
+func (s *SimpleContract) CounterPropose(ctx contractapi.TransactionContextInterface, id string, patientsIDs string, operation string, expiry string, note string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalPending {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not pending", id)
+	}
+
+	if len(proposal.ResultIDs) > 0 {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s combines results, its cohort can't be changed", id)
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != proposal.RequestedID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": details.MSPID}, "Only %s can counter %s", proposal.RequestedID, id)
+	}
+
+	// An accepted counter-proposal approves the proposal, so only its approvers counter it
+	if err := requireApprover(ctx, proposal.RequestedID, id); err != nil {
+		return err
+	}
+
+	if _, err := s.validateProposalTerms(ctx, proposal.ProtocolID, patientsIDs, operation, proposal.Metrics, expiry); err != nil {
+		return err
+	}
+
+	proposal.Negotiation = append(proposal.Negotiation, CounterProposal{
+		PatientsIDs: patientsIDs,
+		Operation:   operation,
+		Expiry:      expiry,
+		Note:        note,
+		Status:      CounterOpen,
+		Proposed:    details,
+	})
+	proposal.Status = ProposalCountered
+	proposal.Metadata.Updated = details
+
+	if err := notify(ctx, proposal.RequesterID, NotificationCounterProposal, id, fmt.Sprintf("%s countered %s, it awaits your answer", proposal.RequestedID, id)); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// AnswerCounterProposal lets the requester accept or reject the open counter-proposal.
+// Accepting applies its terms as a new version of the approved proposal, rejecting
+// leaves the proposal pending with its current terms.
+func (s *SimpleContract) AnswerCounterProposal(ctx contractapi.TransactionContextInterface, id string, accept bool) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalCountered {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s has no open counter-proposal", id)
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": details.MSPID}, "Only %s can answer the counter-proposal of %s", proposal.RequesterID, id)
+	}
+
+	counter := &proposal.Negotiation[len(proposal.Negotiation)-1]
+	counter.Answered = &details
+	proposal.Metadata.Updated = details
+
+	if !accept {
+		counter.Status = CounterRejected
+		proposal.Status = ProposalPending
+
+		if err := awaitResponse(ctx, id, proposal); err != nil {
+			return err
+		}
+
+		if err := notify(ctx, proposal.RequestedID, NotificationProposalAwaitingApproval, id, fmt.Sprintf("%s rejected the counter-proposal, %s requests your approval", proposal.RequesterID, id)); err != nil {
+			return err
+		}
+
+		proposalAsBytes, _ := json.Marshal(proposal)
+
+		return ctx.GetStub().PutState(id, proposalAsBytes)
+	}
+
+	// The cohort may have changed since the counter-proposal was made
+	if _, err := s.validateProposalTerms(ctx, proposal.ProtocolID, counter.PatientsIDs, counter.Operation, proposal.Metrics, counter.Expiry); err != nil {
+		return err
+	}
+
+	countered := *proposal
+	countered.PatientsIDs, countered.Operation = counter.PatientsIDs, counter.Operation
+	noiseRequired, err := checkAgreementTerms(ctx, id, &countered)
+
+	if err != nil {
+		return err
+	}
+
+	counter.Status = CounterAccepted
+
+	if err := saveVersion(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	proposal.PatientsIDs = counter.PatientsIDs
+	proposal.Operation = counter.Operation
+	proposal.Expiry = counter.Expiry
+	proposal.NoiseRequired = noiseRequired
+	proposal.Noise = ""
+	proposal.Approvals = []TransactionDetails{counter.Proposed}
+	proposal.Status = ProposalApproved
+
+	if err := checkQuota(ctx, id, proposal, false); err != nil {
+		return err
+	}
+
+	if err := lockCohort(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	if err := emitEvent(ctx, EventProposalApproved, &ProposalEvent{ProposalID: id, Status: proposal.Status}); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
@@ -0,0 +1,175 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// notificationIndex is the composite key namespace of the notifications of each org
+const notificationIndex = "notification~msp"
+
+// notificationAgeIndex is the composite key namespace of the notifications in order of the
+// time their TTL runs from: their acknowledgment, or else their creation
+const notificationAgeIndex = "notification~age"
+
+// Notification types
+const (
+	NotificationProposalAwaitingApproval         = "PROPOSAL_AWAITING_APPROVAL"
+	NotificationCounterProposal                  = "COUNTER_PROPOSAL"
+	NotificationResultReleased                   = "RESULT_RELEASED"
+	NotificationKeyExpiring                      = "KEY_EXPIRING"
+	NotificationEmergencyAccess                  = "EMERGENCY_ACCESS"
+	NotificationPatientWithdrawn                 = "PATIENT_WITHDRAWN"
+	NotificationConsentExpiring                  = "CONSENT_EXPIRING"
+	NotificationExclusionsAwaitingAcknowledgment = "EXCLUSIONS_AWAITING_ACKNOWLEDGMENT"
+	NotificationAuditAssigned                    = "AUDIT_ASSIGNED"
+	NotificationTransferAwaitingAcceptance       = "TRANSFER_AWAITING_ACCEPTANCE"
+	NotificationKeyTranslationRequested          = "KEY_TRANSLATION_REQUESTED"
+	NotificationConsentlessAccess                = "CONSENTLESS_ACCESS"
+	NotificationResponseOverdue                  = "RESPONSE_OVERDUE"
+	NotificationProposalApproved                 = "PROPOSAL_APPROVED"
+	NotificationProposalRejected                 = "PROPOSAL_REJECTED"
+)
+
+// Notification is an entry of an org's inbox, written whenever the org has to act
+type Notification struct {
+	ID           string              `json:"id"`
+	RecipientMSP string              `json:"recipientMSP"`
+	Type         string              `json:"type"`
+	Subject      string              `json:"subject"`
+	Message      string              `json:"message"`
+	Created      TransactionDetails  `json:"created"`
+	Acknowledged *TransactionDetails `json:"acknowledged,omitempty" metadata:",optional"`
+}
+
+// notify writes a notification to the inbox of an org
+func notify(ctx contractapi.TransactionContextInterface, mspID string, notificationType string, subject string, message string) error {
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// Unique per transaction as long as an org isn't notified twice of the same thing
+	id := hashString(details.TxID + "\x00" + mspID + "\x00" + notificationType + "\x00" + subject)[:32]
+
+	key, err := ctx.GetStub().CreateCompositeKey(notificationIndex, []string{mspID, id})
+
+	if err != nil {
+		return err
+	}
+
+	notification := Notification{
+		ID:           id,
+		RecipientMSP: mspID,
+		Type:         notificationType,
+		Subject:      subject,
+		Message:      message,
+		Created:      details,
+	}
+
+	if err := putIndexEntry(ctx, notificationAgeIndex, details.Timestamp, mspID, id); err != nil {
+		return err
+	}
+
+	notificationAsBytes, _ := json.Marshal(notification)
+
+	return ctx.GetStub().PutState(key, notificationAsBytes)
+}
+
+// NotificationPage is a page of notifications. Count includes the acknowledged ones left out.
+type NotificationPage struct {
+	Records   []Notification `json:"records"`
+	Bookmark  string         `json:"bookmark"`
+	Count     int32          `json:"count"`
+	Truncated bool           `json:"truncated"`
+}
+
+// GetMyNotifications returns a page of the inbox of the caller's org
+func (s *SimpleContract) GetMyNotifications(ctx contractapi.TransactionContextInterface, includeAcknowledged bool, pageSize int32, bookmark string) (*NotificationPage, error) {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectPage(queryContext(), indexPages(ctx, notificationIndex, []string{mspID}), size, bookmark, func(queryResponse *queryresult.KV) (Notification, bool, error) {
+		notification := new(Notification)
+		_ = json.Unmarshal(queryResponse.Value, notification)
+
+		return *notification, notification.Acknowledged == nil || includeAcknowledged, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &NotificationPage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
+
+// AcknowledgeNotification marks a notification of the caller's org as handled
+func (s *SimpleContract) AcknowledgeNotification(ctx contractapi.TransactionContextInterface, id string) error {
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(notificationIndex, []string{details.MSPID, id})
+
+	if err != nil {
+		return err
+	}
+
+	notificationAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if notificationAsBytes == nil {
+		return errNotFound(id)
+	}
+
+	notification := new(Notification)
+	_ = json.Unmarshal(notificationAsBytes, notification)
+
+	if notification.Acknowledged != nil {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s has already been acknowledged", id)
+	}
+
+	// The TTL of the notification now runs from its acknowledgment
+	ageKey, err := ctx.GetStub().CreateCompositeKey(notificationAgeIndex, []string{notification.Created.Timestamp, details.MSPID, id})
+
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetStub().DelState(ageKey); err != nil {
+		return err
+	}
+
+	if err := putIndexEntry(ctx, notificationAgeIndex, details.Timestamp, details.MSPID, id); err != nil {
+		return err
+	}
+
+	notification.Acknowledged = &details
+
+	notificationAsBytes, _ = json.Marshal(notification)
+
+	return ctx.GetStub().PutState(key, notificationAsBytes)
+}
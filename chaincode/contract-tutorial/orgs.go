@@ -0,0 +1,63 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// patientOrgIndex is the composite key namespace partitioning patients by custodian MSP.
+// Patient keys stay the IDs clients use, the partition is scanned through the index.
+const patientOrgIndex = "patient~org"
+
+// indexPatientOrg adds a new patient to the partition of its custodian
+func indexPatientOrg(ctx contractapi.TransactionContextInterface, mspID string, id string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(patientOrgIndex, []string{mspID, id})
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+// GetMyOrgPatients returns a page of the patients the caller's org is the custodian of
+func (s *SimpleContract) GetMyOrgPatients(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PatientPage, error) {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectPage(queryContext(), indexPages(ctx, patientOrgIndex, []string{mspID}), size, bookmark, func(queryResponse *queryresult.KV) (QueryResult, bool, error) {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return QueryResult{}, false, err
+		}
+
+		patient, err := findPatient(ctx, attributes[1])
+
+		if err != nil {
+			return QueryResult{}, false, err
+		}
+
+		return QueryResult{Key: attributes[1], Record: patient}, true, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newPatientPage(ctx, results)
+}
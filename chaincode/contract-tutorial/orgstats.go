@@ -0,0 +1,182 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// proposalOrgIndex is the composite key namespace of the proposals of each org, as requester or requested
+const proposalOrgIndex = "proposal~org"
+
+// resultOrgIndex is the composite key namespace of the results and result sets released to each requester
+const resultOrgIndex = "result~org"
+
+// keyOwnerIndex is the composite key namespace of the registered keys of each owner
+const keyOwnerIndex = "key~owner"
+
+// Parts an org takes in a proposal
+const (
+	proposalRoleRequester = "REQUESTER"
+	proposalRoleRequested = "REQUESTED"
+)
+
+// KeyUsage is the usage of a registered key
+type KeyUsage struct {
+	KeyID        string `json:"keyID"`
+	Status       string `json:"status"`
+	Computations int    `json:"computations"`
+	UsageLimit   int    `json:"usageLimit"`
+	Records      int    `json:"records"`
+}
+
+// OrgStatistics is the activity of an org. Active proposals are pending, approved or countered.
+type OrgStatistics struct {
+	MSPID                      string     `json:"mspID"`
+	Patients                   int        `json:"patients"`
+	ActiveProposalsAsRequester int        `json:"activeProposalsAsRequester"`
+	ActiveProposalsAsRequested int        `json:"activeProposalsAsRequested"`
+	ReleasedResults            int        `json:"releasedResults"`
+	Keys                       []KeyUsage `json:"keys"`
+}
+
+// putIndexEntry writes an entry of an index
+func putIndexEntry(ctx contractapi.TransactionContextInterface, index string, attributes ...string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(index, attributes)
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+// indexProposalOrgs adds a new proposal to the proposals of its requester and requested orgs
+func indexProposalOrgs(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal) error {
+	if err := putIndexEntry(ctx, proposalOrgIndex, proposal.RequesterID, proposalRoleRequester, id); err != nil {
+		return err
+	}
+
+	return putIndexEntry(ctx, proposalOrgIndex, proposal.RequestedID, proposalRoleRequested, id)
+}
+
+// forEachIndexEntry calls f with the last attribute of the entries of an index under a partial
+// key, up to the result limit
+func forEachIndexEntry(ctx contractapi.TransactionContextInterface, limit int, index string, attributes []string, f func(id string) error) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, attributes)
+
+	if err != nil {
+		return err
+	}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		return f(attributes[len(attributes)-1])
+	})
+
+	if err != nil {
+		return queryError(err)
+	}
+
+	return nil
+}
+
+// activeProposals counts the proposals an org takes a part in that are not executed yet
+func (s *SimpleContract) activeProposals(ctx contractapi.TransactionContextInterface, limit int, mspID string, role string) (int, error) {
+	active := 0
+
+	err := forEachIndexEntry(ctx, limit, proposalOrgIndex, []string{mspID, role}, func(id string) error {
+		proposal, err := s.FindProposal(ctx, id)
+
+		if err != nil {
+			return err
+		}
+
+		if proposal.Status != ProposalExecuted {
+			active++
+		}
+
+		return nil
+	})
+
+	return active, err
+}
+
+// GetOrgStatistics returns, for the dashboards of admins, the patients an org is the custodian
+// of, its active proposals as requester and as requested org, the results released to it and
+// the usage of its registered keys, all counted from the indexes up to the result limit
+func (s *SimpleContract) GetOrgStatistics(ctx contractapi.TransactionContextInterface, mspID string) (*OrgStatistics, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	return s.orgStatistics(ctx, mspID)
+}
+
+// orgStatistics counts the statistics of an org for GetOrgStatistics, whoever the caller
+func (s *SimpleContract) orgStatistics(ctx contractapi.TransactionContextInterface, mspID string) (*OrgStatistics, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	statistics := &OrgStatistics{MSPID: mspID, Keys: []KeyUsage{}}
+
+	err = forEachIndexEntry(ctx, limit, patientOrgIndex, []string{mspID}, func(string) error {
+		statistics.Patients++
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if statistics.ActiveProposalsAsRequester, err = s.activeProposals(ctx, limit, mspID, proposalRoleRequester); err != nil {
+		return nil, err
+	}
+
+	if statistics.ActiveProposalsAsRequested, err = s.activeProposals(ctx, limit, mspID, proposalRoleRequested); err != nil {
+		return nil, err
+	}
+
+	err = forEachIndexEntry(ctx, limit, resultOrgIndex, []string{mspID}, func(string) error {
+		statistics.ReleasedResults++
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = forEachIndexEntry(ctx, limit, keyOwnerIndex, []string{mspID}, func(id string) error {
+		key, err := findKeyRecord(ctx, id)
+
+		if err != nil || key == nil {
+			return err
+		}
+
+		if _, err := countKeyUsage(ctx, id, key); err != nil {
+			return err
+		}
+
+		statistics.Keys = append(statistics.Keys, KeyUsage{KeyID: id, Status: key.Status, Computations: key.Computations, UsageLimit: key.UsageLimit, Records: key.Records})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return statistics, nil
+}
@@ -0,0 +1,245 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// OperationSum adds a metric over the cohort, leaving out the outliers flagged by the custodian
+const OperationSum = "SUM"
+
+// Exclusion is a patient of a cohort the custodian flagged as an outlier, left out of the sum
+type Exclusion struct {
+	PatientID string             `json:"patientID"`
+	Reason    string             `json:"reason"`
+	Flagged   TransactionDetails `json:"flagged"`
+}
+
+// excludes tells whether a patient is flagged as an outlier of the proposal
+func (p *Proposal) excludes(pid string) bool {
+	for _, exclusion := range p.Exclusions {
+		if exclusion.PatientID == pid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// aggregatedPatients returns the patients of the cohort the proposal computes over, its
+// outliers and the patients its execution skipped left out
+func (p *Proposal) aggregatedPatients() []string {
+	pids := []string{}
+
+	for _, pid := range strings.Split(p.PatientsIDs, ",") {
+		if !p.excludes(pid) && !p.skips(pid) {
+			pids = append(pids, pid)
+		}
+	}
+
+	return pids
+}
+
+// exclusionsHash returns the hash of the exclusions of a proposal, the SHA-256 of the JSON
+// array of their [patientID, reason] pairs sorted by patient ID
+func exclusionsHash(exclusions []Exclusion) string {
+	pairs := [][2]string{}
+
+	for _, exclusion := range exclusions {
+		pairs = append(pairs, [2]string{exclusion.PatientID, exclusion.Reason})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0]
+	})
+
+	pairsAsBytes, _ := json.Marshal(pairs)
+
+	return hashString(string(pairsAsBytes))
+}
+
+// CreateSumProposal requests the sum of a metric over the cohort. The custodian may flag
+// outliers with FlagOutlier until it is executed, and the requester acknowledges them with
+// AcknowledgeExclusions before the result is released. The preExistingConditions value is
+// summed without a metric.
+func (s *SimpleContract) CreateSumProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, metric string, expiry string) error {
+	if done, err := beginRequest(ctx, "CreateSumProposal", id, protocolID, requesterID, requestedID, patientsIDs, keyID, metric, expiry); err != nil || done {
+		return err
+	}
+
+	if metric == "" {
+		metric = DefaultMetric
+	}
+
+	proposal, err := s.proposeCohort(ctx, id, protocolID, requesterID, requestedID, patientsIDs, keyID, OperationSum, expiry, []string{metric})
+
+	if err != nil {
+		return err
+	}
+
+	return s.storeNewProposal(ctx, id, proposal)
+}
+
+// FlagOutlier lets the custodian leave a patient of the cohort of a sum proposal out of the
+// sum, for a reason the requester sees. Patients are flagged before the proposal is
+// executed, and at least one must remain.
+func (s *SimpleContract) FlagOutlier(ctx contractapi.TransactionContextInterface, id string, patientID string, reason string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Operation != OperationSum {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "operation": proposal.Operation}, "Only the outliers of a %s are flagged", OperationSum)
+	}
+
+	if proposal.Status != ProposalPending && proposal.Status != ProposalApproved {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "The outliers of %s are flagged before it is executed", id)
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != proposal.RequestedID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": details.MSPID}, "Only %s can flag the outliers of %s", proposal.RequestedID, id)
+	}
+
+	if reason == "" {
+		return newError(CodeInvalidArgument, map[string]string{"field": "reason"}, "reason is required")
+	}
+
+	pids := strings.Split(proposal.PatientsIDs, ",")
+
+	if !contains(pids, patientID) {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "patientID": patientID}, "%s is not in the cohort of %s", patientID, id)
+	}
+
+	if proposal.excludes(patientID) {
+		return newError(CodeAlreadyExists, map[string]string{"id": id, "patientID": patientID}, "%s is already flagged as an outlier of %s", patientID, id)
+	}
+
+	if len(proposal.aggregatedPatients()) == 1 {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "patientID": patientID}, "%s is the last patient summed by %s", patientID, id)
+	}
+
+	proposal.Exclusions = append(proposal.Exclusions, Exclusion{PatientID: patientID, Reason: reason, Flagged: details})
+	proposal.Metadata.Updated = details
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// outlierSum computes the encrypted sum of a sum proposal over its cohort less the outliers.
+// Outliers no longer in the cohort, after an amendment or a withdrawal, are dropped, and the
+// hash of the exclusions applied is recorded for the requester to acknowledge.
+func (s *SimpleContract) outlierSum(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal, pids []string, modulo string) (string, error) {
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return "", err
+	}
+
+	exclusions := []Exclusion{}
+
+	for _, exclusion := range proposal.Exclusions {
+		if contains(pids, exclusion.PatientID) {
+			exclusions = append(exclusions, exclusion)
+		}
+	}
+
+	proposal.Exclusions = exclusions
+	proposal.ExclusionsHash = ""
+
+	if len(exclusions) > 0 {
+		proposal.ExclusionsHash = exclusionsHash(exclusions)
+	}
+
+	included := proposal.aggregatedPatients()
+
+	if len(included) == 0 {
+		return "", newError(CodeInvalidState, map[string]string{"id": id}, "Every patient of %s is flagged as an outlier", id)
+	}
+
+	total, err := s.sum(ctx, q, included, proposal.Metrics[0], proposal.AsOf)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(exclusions) > 0 {
+		message := fmt.Sprintf("%d patients of %s were left out as outliers, acknowledge them to release its result", len(exclusions), id)
+
+		if err := notify(ctx, proposal.RequesterID, NotificationExclusionsAwaitingAcknowledgment, id, message); err != nil {
+			return "", err
+		}
+	}
+
+	return total.String(), nil
+}
+
+// AcknowledgeExclusions records that the requester reviewed the outliers left out of an
+// executed sum proposal, passing the exclusions hash of the proposal. The result of a
+// proposal with exclusions is released only once they are acknowledged.
+func (s *SimpleContract) AcknowledgeExclusions(ctx contractapi.TransactionContextInterface, id string, exclusionsHash string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalExecuted {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s has not been executed", id)
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": details.MSPID}, "Only %s can acknowledge the exclusions of %s", proposal.RequesterID, id)
+	}
+
+	if len(proposal.Exclusions) == 0 {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s has no exclusions", id)
+	}
+
+	if proposal.ExclusionsAcknowledged != nil {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "The exclusions of %s are already acknowledged", id)
+	}
+
+	if exclusionsHash != proposal.ExclusionsHash {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "exclusionsHash": proposal.ExclusionsHash}, "The exclusions of %s don't match the hash", id)
+	}
+
+	proposal.ExclusionsAcknowledged = &details
+	proposal.Metadata.Updated = details
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// checkExclusionsAcknowledged refuses to release the result of a proposal whose exclusions
+// the requester hasn't acknowledged
+func checkExclusionsAcknowledged(id string, proposal *Proposal) error {
+	if len(proposal.Exclusions) > 0 && proposal.ExclusionsAcknowledged == nil {
+		return newError(CodeInvalidState, map[string]string{"id": id, "exclusions": fmt.Sprint(len(proposal.Exclusions))}, "The exclusions of %s must be acknowledged by %s first", id, proposal.RequesterID)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Participation is a proposal that included a patient: the accesses to its data by the
+// executions of the proposal, for their purposes, and the result released from it. An
+// approved proposal whose execution didn't read the patient yet has no accesses.
+type Participation struct {
+	ProposalID   string               `json:"proposalID"`
+	ProtocolID   string               `json:"protocolID"`
+	RequesterMSP string               `json:"requesterMSP"`
+	Operation    string               `json:"operation"`
+	Status       string               `json:"status"`
+	Purposes     []string             `json:"purposes,omitempty" metadata:",optional"`
+	Accessed     []TransactionDetails `json:"accessed,omitempty" metadata:",optional"`
+	ResultID     string               `json:"resultID,omitempty" metadata:",optional"`
+}
+
+// PatientParticipation is every proposal that included a patient, in the order they were proposed
+type PatientParticipation struct {
+	PatientID      string          `json:"patientID"`
+	Participations []Participation `json:"participations"`
+}
+
+// releasedResultID returns the ID of the result or result set released from a proposal, empty
+// until one is. Results released before their IDs were derived from the whole proposal ID
+// are numbered after it, and told from the results of other proposals by their proposal.
+func releasedResultID(ctx contractapi.TransactionContextInterface, proposalID string) (string, error) {
+	ids := []string{resultID(proposalID), resultSetID(proposalID), legacyResultID("RESULT", proposalID), legacyResultID("RESULTSET", proposalID)}
+
+	for _, id := range ids {
+		resultAsBytes, err := ctx.GetStub().GetState(id)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		result := new(Result)
+
+		if resultAsBytes != nil && json.Unmarshal(resultAsBytes, result) == nil && result.ProposalID == proposalID {
+			return id, nil
+		}
+	}
+
+	return "", nil
+}
+
+// GetPatientParticipation returns every proposal that included a patient, from the access
+// log its executions wrote and the locks of the approved proposals awaiting execution, with
+// the results released from them, so custodians can tell patients where their data was
+// used. Only the custodian of the patient reads it, as its access log.
+func (s *SimpleContract) GetPatientParticipation(ctx contractapi.TransactionContextInterface, patientID string) (*PatientParticipation, error) {
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mspID != patient.custodian() {
+		return nil, newError(CodePermissionDenied, map[string]string{"id": patientID, "mspID": mspID}, "Only %s can read the participation of %s", patient.custodian(), patientID)
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	participations := map[string]*Participation{}
+	proposalIDs := []string{}
+
+	participation := func(proposalID string) *Participation {
+		if participations[proposalID] == nil {
+			participations[proposalID] = &Participation{ProposalID: proposalID}
+			proposalIDs = append(proposalIDs, proposalID)
+		}
+
+		return participations[proposalID]
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(accessLogIndex, []string{patientID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		entry := AccessLogEntry{}
+		_ = json.Unmarshal(queryResponse.Value, &entry)
+
+		p := participation(entry.ProposalID)
+		p.Accessed = append(p.Accessed, entry.Accessed)
+
+		if !contains(p.Purposes, entry.Purpose) {
+			p.Purposes = append(p.Purposes, entry.Purpose)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	resultsIterator, err = ctx.GetStub().GetStateByPartialCompositeKey(patientLockIndex, []string{patientID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		participation(attributes[1])
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	proposed := map[string]string{}
+
+	for _, proposalID := range proposalIDs {
+		p := participations[proposalID]
+		proposal, err := s.FindProposal(ctx, proposalID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		p.ProtocolID, p.RequesterMSP, p.Operation, p.Status = proposal.ProtocolID, proposal.RequesterID, proposal.Operation, proposal.Status
+		proposed[proposalID] = proposal.Metadata.Created.Timestamp
+
+		sort.Slice(p.Accessed, func(i, j int) bool {
+			return p.Accessed[i].Timestamp < p.Accessed[j].Timestamp
+		})
+
+		if p.ResultID, err = releasedResultID(ctx, proposalID); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(proposalIDs, func(i, j int) bool {
+		return proposed[proposalIDs[i]] < proposed[proposalIDs[j]]
+	})
+
+	report := &PatientParticipation{PatientID: patientID, Participations: []Participation{}}
+
+	for _, proposalID := range proposalIDs {
+		report.Participations = append(report.Participations, *participations[proposalID])
+	}
+
+	return report, nil
+}
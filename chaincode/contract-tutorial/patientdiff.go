@@ -0,0 +1,152 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// PatientVersion is a version of a patient in the history of its key, written by a transaction
+type PatientVersion struct {
+	TxID      string              `json:"txID"`
+	Timestamp string              `json:"timestamp"`
+	Deleted   bool                `json:"deleted,omitempty" metadata:",optional"`
+	Updated   *TransactionDetails `json:"updated,omitempty" metadata:",optional"`
+}
+
+// FieldDiff is a field of a patient that differs between two versions. A field missing from
+// a version, such as a measurement not yet set, has an empty value.
+type FieldDiff struct {
+	Field    string `json:"field"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+}
+
+// PatientDiff is the difference between two versions of a patient, field by field
+type PatientDiff struct {
+	PatientID string         `json:"patientID"`
+	From      PatientVersion `json:"from"`
+	To        PatientVersion `json:"to"`
+	Changes   []FieldDiff    `json:"changes"`
+	Redacted  []string       `json:"redacted,omitempty" metadata:",optional"`
+}
+
+// patientVersions returns the versions of a patient written by transactions, by transaction ID
+func patientVersions(ctx contractapi.TransactionContextInterface, id string, txIDs ...string) (map[string]*queryresult.KeyModification, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[string]*queryresult.KeyModification{}
+
+	err = ledgeriter.ForEach[*queryresult.KeyModification](queryContext(), resultsIterator, limit, func(modification *queryresult.KeyModification) error {
+		if contains(txIDs, modification.TxId) {
+			versions[modification.TxId] = modification
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	for _, txID := range txIDs {
+		if versions[txID] == nil {
+			return nil, newError(CodeNotFound, map[string]string{"id": id, "txID": txID}, "%s was not written by %s", id, txID)
+		}
+	}
+
+	return versions, nil
+}
+
+// versionOf returns a version of a patient and the patient it holds, nil if it was deleted
+func versionOf(modification *queryresult.KeyModification) (PatientVersion, *Patient) {
+	ts := timeutil.FromUnix(modification.Timestamp.Seconds, modification.Timestamp.Nanos)
+	version := PatientVersion{TxID: modification.TxId, Timestamp: timeutil.Format(ts), Deleted: modification.IsDelete}
+
+	if modification.IsDelete {
+		return version, nil
+	}
+
+	patient := new(Patient)
+	_ = json.Unmarshal(modification.Value, patient)
+
+	return version, patient
+}
+
+// DiffPatientVersions returns the fields of a patient that differ between the versions written
+// by two transactions, from the history of its key, so auditors investigating an update don't
+// compare the records by hand. The read policy of the caller applies to both versions, the
+// fields it withholds being left out and listed in redacted. The history database of
+// the peers must be enabled.
+func (s *SimpleContract) DiffPatientVersions(ctx contractapi.TransactionContextInterface, id string, txIDa string, txIDb string) (*PatientDiff, error) {
+	versions, err := patientVersions(ctx, id, txIDa, txIDb)
+
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &PatientDiff{PatientID: id, Changes: []FieldDiff{}}
+	fields := []map[string]string{}
+
+	for i, txID := range []string{txIDa, txIDb} {
+		version, patient := versionOf(versions[txID])
+		values := map[string]string{}
+
+		if patient != nil {
+			patient, err = filterPatient(ctx, patient)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !contains(patient.Redacted, FieldMetadata) {
+				version.Updated = &patient.Metadata.Updated
+			}
+
+			diff.Redacted = patient.Redacted
+			values = trackedFields(patient)
+		}
+
+		if i == 0 {
+			diff.From = version
+		} else {
+			diff.To = version
+		}
+
+		fields = append(fields, values)
+	}
+
+	for field, old := range fields[0] {
+		if value := fields[1][field]; value != old {
+			diff.Changes = append(diff.Changes, FieldDiff{Field: field, OldValue: old, NewValue: value})
+		}
+	}
+
+	for field, value := range fields[1] {
+		if _, ok := fields[0][field]; !ok && value != "" {
+			diff.Changes = append(diff.Changes, FieldDiff{Field: field, NewValue: value})
+		}
+	}
+
+	sort.Slice(diff.Changes, func(i, j int) bool { return diff.Changes[i].Field < diff.Changes[j].Field })
+
+	return diff, nil
+}
@@ -0,0 +1,66 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/payloadschema"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// PayloadSchema is the JSON schema of a JSON payload the contract accepts
+type PayloadSchema struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// validatePayload checks a JSON payload against its schema before it is processed. The
+// first violation, by pointer, is returned as INVALID_ARGUMENT with its JSON pointer in
+// the details.
+func validatePayload(name string, payload []byte) error {
+	violations, err := payloadschema.Validate(name, payload)
+
+	if err != nil {
+		return newError(CodeInvalidArgument, map[string]string{"schema": name}, "Payload is not valid JSON. %s", err.Error())
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	details := map[string]string{"schema": name, "pointer": violations[0].Pointer, "violations": fmt.Sprint(len(violations))}
+
+	return newError(CodeInvalidArgument, details, "Payload does not match schema %s at %q: %s", name, violations[0].Pointer, violations[0].Message)
+}
+
+// GetPayloadSchemas returns the JSON schemas of the JSON payloads of the contract, sorted
+// by name: the patients of CreatePatientsBatch, the consents of GrantConsentsBatch, the
+// draft patient of SaveDraftPatient and the emergency contacts of the custodians
+func (s *SimpleContract) GetPayloadSchemas(ctx contractapi.TransactionContextInterface) ([]PayloadSchema, error) {
+	schemas := []PayloadSchema{}
+
+	for _, name := range payloadschema.Names() {
+		schemas = append(schemas, PayloadSchema{Name: name, Schema: payloadschema.Source(name)})
+	}
+
+	return schemas, nil
+}
+
+// ValidatePayload returns every violation of a schema by a JSON payload, for integrators to
+// check their payloads before submitting them. A valid payload has none.
+func (s *SimpleContract) ValidatePayload(ctx contractapi.TransactionContextInterface, schema string, payload string) ([]payloadschema.Violation, error) {
+	if payloadschema.Source(schema) == "" {
+		return nil, newError(CodeNotFound, map[string]string{"schema": schema}, "Schema %s does not exist", schema)
+	}
+
+	violations, err := payloadschema.Validate(schema, []byte(payload))
+
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, map[string]string{"schema": schema}, "Payload is not valid JSON. %s", err.Error())
+	}
+
+	return violations, nil
+}
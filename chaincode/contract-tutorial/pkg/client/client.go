@@ -0,0 +1,221 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+// Package client calls the contract tutorial chaincode through the Fabric
+// Gateway, retrying the transactions that fail on MVCC conflicts.
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/status"
+)
+
+// apiVersion is the contract of the chaincode serving the API the client is written against.
+// The default contract serves the deprecated v1 API.
+const apiVersion = "v2"
+
+// Client submits and evaluates the transactions of the contract on a channel
+type Client struct {
+	gateway   *client.Gateway
+	network   *client.Network
+	contract  *client.Contract
+	chaincode string
+	retries   int
+	backoff   time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithRetries sets how many times a transaction failing on an MVCC conflict is submitted again, 3 by default
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
+// WithBackoff sets the wait before the first retry, doubled on each retry, 100ms by default
+func WithBackoff(backoff time.Duration) Option {
+	return func(c *Client) {
+		c.backoff = backoff
+	}
+}
+
+// New returns a client of the chaincode on a channel of the gateway
+func New(gw *client.Gateway, channel string, chaincode string, options ...Option) *Client {
+	network := gw.GetNetwork(channel)
+	c := &Client{
+		gateway:   gw,
+		network:   network,
+		contract:  network.GetContractWithName(chaincode, apiVersion),
+		chaincode: chaincode,
+		retries:   3,
+		backoff:   100 * time.Millisecond,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// ContractError is a business failure of the contract, decoded from the error envelope it returns
+type ContractError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+func (e *ContractError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// contractError returns the error envelope in the details of a gateway error, or err itself
+func contractError(err error) error {
+	for _, detail := range status.Convert(err).Details() {
+		message, ok := detail.(interface{ GetMessage() string })
+
+		if !ok {
+			continue
+		}
+
+		if i := strings.Index(message.GetMessage(), "{"); i >= 0 {
+			e := new(ContractError)
+
+			if json.Unmarshal([]byte(message.GetMessage()[i:]), e) == nil && e.Code != "" {
+				return e
+			}
+		}
+	}
+
+	return err
+}
+
+// retryable tells whether a transaction failed to commit only because of a concurrent one
+func retryable(err error) bool {
+	var commitError *client.CommitError
+	var statusError *CommitStatusError
+
+	switch {
+	case errors.As(err, &commitError):
+		return conflict(commitError.Code)
+	case errors.As(err, &statusError):
+		return conflict(statusError.Code)
+	}
+
+	return false
+}
+
+// conflict tells whether a validation code is that of a read conflict with a concurrent transaction
+func conflict(code peer.TxValidationCode) bool {
+	return code == peer.TxValidationCode_MVCC_READ_CONFLICT || code == peer.TxValidationCode_PHANTOM_READ_CONFLICT
+}
+
+// submit endorses and submits a transaction, then waits for its commit. Every
+// retry is endorsed again, so it reads the state written by the conflicting transaction.
+func (c *Client) submit(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return c.retry(ctx, func() ([]byte, error) {
+		return c.submitOnce(ctx, name, args...)
+	})
+}
+
+// retry calls submit again while it fails on an MVCC conflict, up to the retries of the client
+func (c *Client) retry(ctx context.Context, submit func() ([]byte, error)) ([]byte, error) {
+	backoff := c.backoff
+
+	for attempt := 0; ; attempt++ {
+		result, err := submit()
+
+		if err == nil || !retryable(err) || attempt >= c.retries {
+			return result, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+// submitOnce endorses and submits a transaction and waits for its commit
+func (c *Client) submitOnce(ctx context.Context, name string, args ...string) ([]byte, error) {
+	result, err := c.contract.SubmitWithContext(ctx, name, client.WithArguments(args...))
+
+	var endorseError *client.EndorseError
+
+	if errors.As(err, &endorseError) {
+		return nil, contractError(err)
+	}
+
+	return result, err
+}
+
+// evaluate queries the chaincode and decodes the JSON result into v
+func (c *Client) evaluate(ctx context.Context, v interface{}, name string, args ...string) error {
+	result, err := c.contract.EvaluateWithContext(ctx, name, client.WithArguments(args...))
+
+	if err != nil {
+		return contractError(err)
+	}
+
+	return json.Unmarshal(result, v)
+}
+
+// compressedResponse is a listing response in the encoding the contract applied
+type compressedResponse struct {
+	Encoding string `json:"encoding"`
+	Payload  string `json:"payload"`
+}
+
+// evaluateCompressed queries a listing through CompressedQuery, accepting gzip, and decodes
+// the decompressed JSON result into v
+func (c *Client) evaluateCompressed(ctx context.Context, v interface{}, name string, args ...string) error {
+	argsAsBytes, _ := json.Marshal(args)
+	response := new(compressedResponse)
+
+	if err := c.evaluate(ctx, response, "CompressedQuery", "gzip", name, string(argsAsBytes)); err != nil {
+		return err
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(response.Payload)
+
+	if err != nil {
+		return err
+	}
+
+	switch response.Encoding {
+	case "identity":
+	case "gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+
+		if err != nil {
+			return err
+		}
+
+		defer reader.Close()
+
+		if payload, err = io.ReadAll(reader); err != nil {
+			return err
+		}
+	default:
+		return errors.New("unsupported response encoding " + response.Encoding)
+	}
+
+	return json.Unmarshal(payload, v)
+}
@@ -0,0 +1,181 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConflict(t *testing.T) {
+	for _, c := range []struct {
+		code     peer.TxValidationCode
+		conflict bool
+	}{
+		{peer.TxValidationCode_MVCC_READ_CONFLICT, true},
+		{peer.TxValidationCode_PHANTOM_READ_CONFLICT, true},
+		{peer.TxValidationCode_VALID, false},
+		{peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, false},
+		{peer.TxValidationCode_DUPLICATE_TXID, false},
+	} {
+		if conflict(c.code) != c.conflict {
+			t.Errorf("Expected conflict(%s) to be %v", c.code, c.conflict)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"MVCC commit error", &client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_MVCC_READ_CONFLICT}, true},
+		{"phantom commit error", &client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_PHANTOM_READ_CONFLICT}, true},
+		{"MVCC commit status error", &CommitStatusError{TransactionID: "tx1", Code: peer.TxValidationCode_MVCC_READ_CONFLICT}, true},
+		{"wrapped phantom commit status error", fmt.Errorf("commit failed: %w", &CommitStatusError{TransactionID: "tx1", Code: peer.TxValidationCode_PHANTOM_READ_CONFLICT}), true},
+		{"policy commit error", &client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}, false},
+		{"policy commit status error", &CommitStatusError{TransactionID: "tx1", Code: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}, false},
+		{"contract error", &ContractError{Code: "INVALID_STATE", Message: "PROPOSAL0 is not pending"}, false},
+		{"other error", errors.New("connection refused"), false},
+		{"no error", nil, false},
+	} {
+		if retryable(c.err) != c.retryable {
+			t.Errorf("Expected the %s to be retryable %v", c.name, c.retryable)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	mvcc := &client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_MVCC_READ_CONFLICT}
+	policy := &client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}
+
+	for _, c := range []struct {
+		name     string
+		retries  int
+		failures []error
+		calls    int
+		err      error
+	}{
+		{"success", 3, nil, 1, nil},
+		{"conflicts then success", 3, []error{mvcc, mvcc}, 3, nil},
+		{"retries running out", 2, []error{mvcc, mvcc, mvcc, mvcc}, 3, mvcc},
+		{"no retries", 0, []error{mvcc}, 1, mvcc},
+		{"other failure", 3, []error{policy}, 1, policy},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			retrying := &Client{retries: c.retries, backoff: time.Millisecond}
+			calls := 0
+
+			result, err := retrying.retry(context.Background(), func() ([]byte, error) {
+				calls++
+
+				if calls <= len(c.failures) {
+					return nil, c.failures[calls-1]
+				}
+
+				return []byte("result"), nil
+			})
+
+			if calls != c.calls {
+				t.Errorf("Expected %d submissions, got %d", c.calls, calls)
+			}
+
+			if err != c.err {
+				t.Errorf("Expected %v, got %v", c.err, err)
+			}
+
+			if c.err == nil && string(result) != "result" {
+				t.Errorf("Expected the result of the last submission, got %q", result)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	backoff := 20 * time.Millisecond
+	retrying := &Client{retries: 3, backoff: backoff}
+	submitted := []time.Time{}
+
+	_, err := retrying.retry(context.Background(), func() ([]byte, error) {
+		submitted = append(submitted, time.Now())
+
+		return nil, &CommitStatusError{TransactionID: "tx1", Code: peer.TxValidationCode_MVCC_READ_CONFLICT}
+	})
+
+	if !retryable(err) || len(submitted) != 4 {
+		t.Fatalf("Expected 4 submissions ending on the conflict, got %d and %v", len(submitted), err)
+	}
+
+	// The wait before each retry doubles the one before
+	for i := 1; i < len(submitted); i++ {
+		wait := backoff << (i - 1)
+
+		if gap := submitted[i].Sub(submitted[i-1]); gap < wait {
+			t.Errorf("Expected retry %d to wait at least %s, waited %s", i, wait, gap)
+		}
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	retrying := &Client{retries: 3, backoff: time.Hour}
+	calls := 0
+
+	_, err := retrying.retry(ctx, func() ([]byte, error) {
+		calls++
+		cancel()
+
+		return nil, &client.CommitError{TransactionID: "tx1", Code: peer.TxValidationCode_MVCC_READ_CONFLICT}
+	})
+
+	if err != context.Canceled || calls != 1 {
+		t.Errorf("Expected the cancellation to stop the retries after 1 submission, got %d and %v", calls, err)
+	}
+}
+
+func TestContractError(t *testing.T) {
+	withDetail := func(message string) error {
+		s, err := status.New(codes.Aborted, "failed to endorse transaction").WithDetails(&gateway.ErrorDetail{Address: "peer0.org1.example.com:7051", MspId: "Org1MSP", Message: message})
+
+		if err != nil {
+			t.Fatalf("WithDetails failed. %s", err.Error())
+		}
+
+		return s.Err()
+	}
+
+	decoded := contractError(withDetail(`chaincode response 500, {"code":"NOT_FOUND","message":"PATIENT0 does not exist","details":{"id":"PATIENT0"}}`))
+	e, ok := decoded.(*ContractError)
+
+	if !ok || e.Code != "NOT_FOUND" || e.Message != "PATIENT0 does not exist" || e.Details["id"] != "PATIENT0" {
+		t.Errorf("Expected the NOT_FOUND envelope of PATIENT0, got %v", decoded)
+	}
+
+	for _, c := range []struct {
+		name string
+		err  error
+	}{
+		{"plain text detail", withDetail("chaincode response 500, PATIENT0 does not exist")},
+		{"detail without a code", withDetail(`chaincode response 500, {"message":"PATIENT0 does not exist"}`)},
+		{"malformed detail", withDetail(`chaincode response 500, {"code":`)},
+		{"status without details", status.Error(codes.Unavailable, "connection refused")},
+		{"other error", errors.New("connection refused")},
+	} {
+		if decoded := contractError(c.err); decoded != c.err {
+			t.Errorf("Expected the %s to be returned as is, got %v", c.name, decoded)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// eventResultReleased is the chaincode event emitted when a result is created
+const eventResultReleased = "ResultReleased"
+
+// Proposal is a computation requested over a cohort
+type Proposal struct {
+	ProtocolID  string            `json:"protocolID"`
+	RequesterID string            `json:"requesterID"`
+	RequestedID string            `json:"requestedID"`
+	PatientsIDs string            `json:"patientsIDs"`
+	KeyID       string            `json:"keyID"`
+	Operation   string            `json:"operation"`
+	Expiry      string            `json:"expiry"`
+	AsOf        string            `json:"asOf,omitempty"`
+	Status      string            `json:"status"`
+	Version     int               `json:"version"`
+	Metrics     []string          `json:"metrics,omitempty"`
+	Value       string            `json:"value"`
+	Values      map[string]string `json:"values,omitempty"`
+}
+
+// Manifest describes the computation behind a result
+type Manifest struct {
+	Operation  string   `json:"operation"`
+	Metrics    []string `json:"metrics"`
+	CohortSize int      `json:"cohortSize"`
+	AsOf       string   `json:"asOf,omitempty"`
+	Pseudonyms []string `json:"pseudonyms,omitempty"`
+}
+
+// Result is the re-encrypted result of an executed proposal
+type Result struct {
+	ProposalID string   `json:"proposalID"`
+	KeyID      string   `json:"keyID"`
+	Value      string   `json:"value"`
+	Manifest   Manifest `json:"manifest"`
+}
+
+// Patient is a patient record, its values encrypted under its key
+type Patient struct {
+	Name                  string            `json:"name"`
+	PreExistingConditions string            `json:"preExistingConditions"`
+	DiagnosisID           string            `json:"diagnosisID"`
+	StatusID              string            `json:"statusID"`
+	KeyID                 string            `json:"keyID"`
+	Measurements          map[string]string `json:"measurements,omitempty"`
+	CustodianMSP          string            `json:"custodianMSP,omitempty"`
+}
+
+// PatientRecord is a patient of a listing with its ID
+type PatientRecord struct {
+	Key    string   `json:"Key"`
+	Record *Patient `json:"Record"`
+}
+
+// PatientPage is a page of a patient listing, Bookmark reading the next one
+type PatientPage struct {
+	Records   []PatientRecord `json:"records"`
+	Bookmark  string          `json:"bookmark"`
+	Count     int32           `json:"count"`
+	Truncated bool            `json:"truncated"`
+}
+
+// resultReleasedEvent is the payload of the ResultReleased event
+type resultReleasedEvent struct {
+	ResultID   string `json:"resultID"`
+	ProposalID string `json:"proposalID"`
+}
+
+// CreatePatient ...
+func (c *Client) CreatePatient(ctx context.Context, id string, name string, preExistingConditions string, diagnosisID string, statusID string, keyID string) error {
+	_, err := c.submit(ctx, "CreatePatient", id, name, preExistingConditions, diagnosisID, statusID, keyID)
+
+	return err
+}
+
+// SubmitProposal creates a proposal over the patients under a study protocol, an empty expiry never expires
+func (c *Client) SubmitProposal(ctx context.Context, id string, protocolID string, requesterID string, requestedID string, patientsIDs []string, keyID string, operation string, expiry string) error {
+	_, err := c.submit(ctx, "CreateProposal", id, protocolID, requesterID, requestedID, strings.Join(patientsIDs, ","), keyID, operation, expiry)
+
+	return err
+}
+
+// FindProposal ...
+func (c *Client) FindProposal(ctx context.Context, id string) (*Proposal, error) {
+	proposal := new(Proposal)
+
+	if err := c.evaluate(ctx, proposal, "FindProposal", id); err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+// FindResult ...
+func (c *Client) FindResult(ctx context.Context, id string) (*Result, error) {
+	result := new(Result)
+
+	if err := c.evaluate(ctx, result, "FindResult", id); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// AllPatients returns a page of the patients of the caller's org in a range of IDs, the response
+// gzipped by the contract and decompressed by the client
+func (c *Client) AllPatients(ctx context.Context, firstID string, lastID string, pageSize int32, bookmark string) (*PatientPage, error) {
+	page := new(PatientPage)
+
+	if err := c.evaluateCompressed(ctx, page, "AllPatients", firstID, lastID, strconv.Itoa(int(pageSize)), bookmark); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// GetMyOrgPatients returns a page of the patients of the caller's org, the response gzipped by the
+// contract and decompressed by the client
+func (c *Client) GetMyOrgPatients(ctx context.Context, pageSize int32, bookmark string) (*PatientPage, error) {
+	page := new(PatientPage)
+
+	if err := c.evaluateCompressed(ctx, page, "GetMyOrgPatients", strconv.Itoa(int(pageSize)), bookmark); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// findProposalResult returns the result of a proposal. Results released before their IDs
+// took the whole proposal ID are numbered after it, and may be another proposal's.
+func (c *Client) findProposalResult(ctx context.Context, proposalID string) (*Result, error) {
+	result, err := c.FindResult(ctx, "RESULT-"+proposalID)
+
+	var contractError *ContractError
+
+	if err == nil || !errors.As(err, &contractError) || contractError.Code != "NOT_FOUND" {
+		return result, err
+	}
+
+	legacy, legacyErr := c.FindResult(ctx, "RESULT"+regexp.MustCompile(`[0-9]+`).FindString(proposalID))
+
+	if legacyErr != nil || legacy.ProposalID != proposalID {
+		return nil, err
+	}
+
+	return legacy, nil
+}
+
+// AwaitResult returns the result of a proposal, waiting for the custodian to release it
+// if needed. It returns when the result is released or the context is done.
+func (c *Client) AwaitResult(ctx context.Context, proposalID string) (*Result, error) {
+	// Listen before looking the result up, so a result released in between isn't missed
+	listenCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := c.network.ChaincodeEvents(listenCtx, c.chaincode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := c.findProposalResult(ctx, proposalID)
+
+	var contractError *ContractError
+
+	if err == nil || !errors.As(err, &contractError) || contractError.Code != "NOT_FOUND" {
+		return result, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				return nil, errors.New("chaincode events closed before the result was released")
+			}
+
+			if event.EventName != eventResultReleased {
+				continue
+			}
+
+			released := new(resultReleasedEvent)
+
+			if json.Unmarshal(event.Payload, released) != nil || released.ProposalID != proposalID {
+				continue
+			}
+
+			return c.FindResult(ctx, released.ResultID)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+)
+
+// Offline signing. A gateway connected without a signer leaves the signatures of a
+// transaction to its caller, so private keys held in hardware security modules never reach
+// the client. A transaction is signed three times, each message being serialized with its
+// digest so it can be carried to the signer and back: the proposal, before endorsement, the
+// endorsed transaction, before submission, and the request of its commit status.
+
+// Sign signs a digest with the private key of the identity of the gateway, e.g. in a
+// hardware security module
+type Sign func(ctx context.Context, digest []byte) ([]byte, error)
+
+// CommitStatusError is returned when a transaction signed offline fails to commit
+type CommitStatusError struct {
+	TransactionID string
+	Code          peer.TxValidationCode
+}
+
+func (e *CommitStatusError) Error() string {
+	return fmt.Sprintf("transaction %s failed to commit with status code %d (%s)", e.TransactionID, int32(e.Code), peer.TxValidationCode_name[int32(e.Code)])
+}
+
+// PrepareProposal returns the serialized proposal of a transaction and the digest to sign
+func (c *Client) PrepareProposal(name string, args ...string) ([]byte, []byte, error) {
+	proposal, err := c.contract.NewProposal(name, client.WithArguments(args...))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	proposalAsBytes, err := proposal.Bytes()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return proposalAsBytes, proposal.Digest(), nil
+}
+
+// EndorseProposal endorses a proposal with its signature, and returns the serialized
+// endorsed transaction, the digest to sign and the result of the transaction
+func (c *Client) EndorseProposal(ctx context.Context, proposalAsBytes []byte, signature []byte) ([]byte, []byte, []byte, error) {
+	proposal, err := c.gateway.NewSignedProposal(proposalAsBytes, signature)
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	transaction, err := proposal.EndorseWithContext(ctx)
+
+	var endorseError *client.EndorseError
+
+	if errors.As(err, &endorseError) {
+		return nil, nil, nil, contractError(err)
+	}
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	transactionAsBytes, err := transaction.Bytes()
+
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return transactionAsBytes, transaction.Digest(), transaction.Result(), nil
+}
+
+// SubmitTransaction submits an endorsed transaction with its signature to the orderer, and
+// returns the serialized request of its commit status and the digest to sign
+func (c *Client) SubmitTransaction(ctx context.Context, transactionAsBytes []byte, signature []byte) ([]byte, []byte, error) {
+	transaction, err := c.gateway.NewSignedTransaction(transactionAsBytes, signature)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commit, err := transaction.SubmitWithContext(ctx)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commitAsBytes, err := commit.Bytes()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return commitAsBytes, commit.Digest(), nil
+}
+
+// AwaitCommit waits for the commit of a submitted transaction, requesting its status with
+// the signature of the request. A transaction failing validation returns a CommitStatusError.
+func (c *Client) AwaitCommit(ctx context.Context, commitAsBytes []byte, signature []byte) error {
+	commit, err := c.gateway.NewSignedCommit(commitAsBytes, signature)
+
+	if err != nil {
+		return err
+	}
+
+	status, err := commit.StatusWithContext(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if !status.Successful {
+		return &CommitStatusError{TransactionID: status.TransactionID, Code: status.Code}
+	}
+
+	return nil
+}
+
+// SubmitSigned runs the offline signing flow of a transaction with sign, and returns its
+// result once committed. Like the other transactions, it is endorsed, signed and submitted
+// again when it fails on an MVCC conflict.
+func (c *Client) SubmitSigned(ctx context.Context, sign Sign, name string, args ...string) ([]byte, error) {
+	return c.retry(ctx, func() ([]byte, error) {
+		proposal, digest, err := c.PrepareProposal(name, args...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		signature, err := sign(ctx, digest)
+
+		if err != nil {
+			return nil, err
+		}
+
+		transaction, digest, result, err := c.EndorseProposal(ctx, proposal, signature)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if signature, err = sign(ctx, digest); err != nil {
+			return nil, err
+		}
+
+		commit, digest, err := c.SubmitTransaction(ctx, transaction, signature)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if signature, err = sign(ctx, digest); err != nil {
+			return nil, err
+		}
+
+		return result, c.AwaitCommit(ctx, commit, signature)
+	})
+}
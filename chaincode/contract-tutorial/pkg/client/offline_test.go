@@ -0,0 +1,199 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/gateway"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeIdentity is the identity of a gateway connected without a signer
+type fakeIdentity struct{}
+
+func (fakeIdentity) MspID() string {
+	return "Org1MSP"
+}
+
+func (fakeIdentity) Credentials() []byte {
+	return []byte("certificate")
+}
+
+// fakeGateway answers the calls of the gateway service, endorsing every proposal with
+// result and committing the transactions with the codes in turn, then as valid. It
+// appends each call and the signature it carries to calls.
+type fakeGateway struct {
+	result []byte
+	codes  []peer.TxValidationCode
+	calls  *[]string
+}
+
+func (f *fakeGateway) Invoke(ctx context.Context, method string, args interface{}, reply interface{}, opts ...grpc.CallOption) error {
+	switch method {
+	case gateway.Gateway_Endorse_FullMethodName:
+		*f.calls = append(*f.calls, "Endorse "+string(args.(*gateway.EndorseRequest).GetProposedTransaction().GetSignature()))
+		reply.(*gateway.EndorseResponse).PreparedTransaction = preparedTransaction(f.result)
+	case gateway.Gateway_Submit_FullMethodName:
+		*f.calls = append(*f.calls, "Submit "+string(args.(*gateway.SubmitRequest).GetPreparedTransaction().GetSignature()))
+	case gateway.Gateway_CommitStatus_FullMethodName:
+		*f.calls = append(*f.calls, "CommitStatus "+string(args.(*gateway.SignedCommitStatusRequest).GetSignature()))
+		code := peer.TxValidationCode_VALID
+
+		if len(f.codes) > 0 {
+			code, f.codes = f.codes[0], f.codes[1:]
+		}
+
+		reply.(*gateway.CommitStatusResponse).Result = code
+	default:
+		return fmt.Errorf("unexpected call %s", method)
+	}
+
+	return nil
+}
+
+func (f *fakeGateway) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, fmt.Errorf("unexpected stream %s", method)
+}
+
+// preparedTransaction returns the envelope of a transaction endorsed with result
+func preparedTransaction(result []byte) *common.Envelope {
+	chaincodeAction, _ := proto.Marshal(&peer.ChaincodeAction{Response: &peer.Response{Status: 200, Payload: result}})
+	responsePayload, _ := proto.Marshal(&peer.ProposalResponsePayload{Extension: chaincodeAction})
+	actionPayload, _ := proto.Marshal(&peer.ChaincodeActionPayload{Action: &peer.ChaincodeEndorsedAction{ProposalResponsePayload: responsePayload}})
+	transaction, _ := proto.Marshal(&peer.Transaction{Actions: []*peer.TransactionAction{{Payload: actionPayload}}})
+	channelHeader, _ := proto.Marshal(&common.ChannelHeader{ChannelId: "mychannel"})
+	payload, _ := proto.Marshal(&common.Payload{Header: &common.Header{ChannelHeader: channelHeader}, Data: transaction})
+
+	return &common.Envelope{Payload: payload}
+}
+
+// newOfflineClient returns a client of a gateway connected without a signer, answered by fake
+func newOfflineClient(t *testing.T, fake *fakeGateway) *Client {
+	gw, err := client.Connect(fakeIdentity{}, client.WithClientConnection(fake))
+
+	if err != nil {
+		t.Fatalf("Connect failed. %s", err.Error())
+	}
+
+	t.Cleanup(func() { gw.Close() })
+
+	return New(gw, "mychannel", "contract-tutorial", WithBackoff(time.Millisecond))
+}
+
+// countingSign signs the nth digest with "signature<n>", failing on the failAt digest, and
+// appends each signature to calls
+func countingSign(calls *[]string, failAt int) Sign {
+	n := 0
+
+	return func(ctx context.Context, digest []byte) ([]byte, error) {
+		n++
+
+		if len(digest) == 0 {
+			return nil, errors.New("empty digest")
+		}
+
+		if n == failAt {
+			*calls = append(*calls, "sign failed")
+
+			return nil, errSigner
+		}
+
+		*calls = append(*calls, fmt.Sprintf("sign signature%d", n))
+
+		return []byte(fmt.Sprintf("signature%d", n)), nil
+	}
+}
+
+var errSigner = errors.New("the hardware security module is locked")
+
+func TestSubmitSigned(t *testing.T) {
+	calls := []string{}
+	c := newOfflineClient(t, &fakeGateway{result: []byte("PROPOSAL0"), calls: &calls})
+
+	result, err := c.SubmitSigned(context.Background(), countingSign(&calls, 0), "ApproveProposal", "PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("SubmitSigned failed. %s", err.Error())
+	}
+
+	if string(result) != "PROPOSAL0" {
+		t.Errorf("Expected the result of the endorsement, got %q", result)
+	}
+
+	// Each message is signed before it is sent, with its own signature
+	expected := []string{
+		"sign signature1", "Endorse signature1",
+		"sign signature2", "Submit signature2",
+		"sign signature3", "CommitStatus signature3",
+	}
+
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, calls)
+	}
+}
+
+func TestSubmitSignedSignerFailure(t *testing.T) {
+	for _, c := range []struct {
+		failAt   int
+		expected []string
+	}{
+		{1, []string{"sign failed"}},
+		{2, []string{"sign signature1", "Endorse signature1", "sign failed"}},
+		{3, []string{"sign signature1", "Endorse signature1", "sign signature2", "Submit signature2", "sign failed"}},
+	} {
+		calls := []string{}
+		offline := newOfflineClient(t, &fakeGateway{result: []byte("PROPOSAL0"), calls: &calls})
+
+		_, err := offline.SubmitSigned(context.Background(), countingSign(&calls, c.failAt), "ApproveProposal", "PROPOSAL0")
+
+		if err != errSigner {
+			t.Errorf("Expected the failure of signature %d to be returned, got %v", c.failAt, err)
+		}
+
+		if fmt.Sprint(calls) != fmt.Sprint(c.expected) {
+			t.Errorf("Expected the flow to stop at signature %d with %v, got %v", c.failAt, c.expected, calls)
+		}
+	}
+}
+
+func TestSubmitSignedCommitStatus(t *testing.T) {
+	calls := []string{}
+	offline := newOfflineClient(t, &fakeGateway{result: []byte("PROPOSAL0"), codes: []peer.TxValidationCode{peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE}, calls: &calls})
+
+	_, err := offline.SubmitSigned(context.Background(), countingSign(&calls, 0), "ApproveProposal", "PROPOSAL0")
+
+	var statusError *CommitStatusError
+
+	if !errors.As(err, &statusError) || statusError.Code != peer.TxValidationCode_ENDORSEMENT_POLICY_FAILURE || statusError.TransactionID == "" {
+		t.Fatalf("Expected a CommitStatusError with ENDORSEMENT_POLICY_FAILURE, got %v", err)
+	}
+
+	if len(calls) != 6 {
+		t.Errorf("Expected a transaction failing validation not to be submitted again, got %v", calls)
+	}
+
+	// A transaction failing on a conflict is endorsed, signed and submitted again
+	calls = []string{}
+	offline = newOfflineClient(t, &fakeGateway{result: []byte("PROPOSAL0"), codes: []peer.TxValidationCode{peer.TxValidationCode_MVCC_READ_CONFLICT}, calls: &calls})
+
+	result, err := offline.SubmitSigned(context.Background(), countingSign(&calls, 0), "ApproveProposal", "PROPOSAL0")
+
+	if err != nil || string(result) != "PROPOSAL0" {
+		t.Fatalf("Expected the retry to commit, got %q and %v", result, err)
+	}
+
+	if len(calls) != 12 || calls[7] != "Endorse signature4" || calls[11] != "CommitStatus signature6" {
+		t.Errorf("Expected the retry to be signed again, got %v", calls)
+	}
+}
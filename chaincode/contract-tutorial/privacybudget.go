@@ -0,0 +1,308 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// privacyBudgetIndex is the composite key namespace of the budget each patient spent in a month
+const privacyBudgetIndex = "budget~patient"
+
+// operationSensitivity is what an aggregate costs each patient of its cohort. Sums and the
+// means of groups disclose more of each patient than means and counts.
+var operationSensitivity = map[string]int{
+	OperationMean:              1,
+	OperationCount:             1,
+	OperationAnd:               1,
+	OperationOr:                1,
+	OperationDistinctDiagnoses: 1,
+	OperationGroupMean:         2,
+	OperationSum:               2,
+}
+
+// PrivacyBudget is the budget of a patient toward a grantee in a month and what aggregates
+// spent of it, whoever requested them. A budget of 0 is unlimited.
+type PrivacyBudget struct {
+	PatientID  string `json:"patientID"`
+	GranteeMSP string `json:"granteeMSP"`
+	Period     string `json:"period"`
+	Budget     int    `json:"budget"`
+	Spent      int    `json:"spent"`
+}
+
+// privacyCost returns what an aggregate costs each patient, a mean of several metrics costing
+// once per metric
+func privacyCost(operation string, metrics []string) int {
+	cost, ok := operationSensitivity[operation]
+
+	if !ok {
+		cost = 1
+	}
+
+	if operation == OperationMean && len(metrics) > 1 {
+		cost *= len(metrics)
+	}
+
+	return cost
+}
+
+// privacyBudget returns the monthly budget of a patient toward a grantee: the smallest set on
+// their consents to it in force, or the channel's if they set none
+func (s *SimpleContract) privacyBudget(ctx contractapi.TransactionContextInterface, patientID string, granteeMSP string) (int, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consentPatientIndex, []string{patientID, granteeMSP})
+
+	if err != nil {
+		return 0, err
+	}
+
+	budget := 0
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		consent, err := s.FindConsent(ctx, attributes[2])
+
+		if err != nil {
+			return err
+		}
+
+		if consent.Status != ConsentGranted || consent.expired(now) || consent.PrivacyBudget == 0 {
+			return nil
+		}
+
+		if budget == 0 || consent.PrivacyBudget < budget {
+			budget = consent.PrivacyBudget
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, queryError(err)
+	}
+
+	if budget > 0 {
+		return budget, nil
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return config.PrivacyBudget, nil
+}
+
+// privacySpent returns what a patient spent of their budget in the month of the transaction
+// with the key of the counter
+func privacySpent(ctx contractapi.TransactionContextInterface, patientID string) (string, string, int, error) {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	period := timeutil.Month(now)
+
+	key, err := ctx.GetStub().CreateCompositeKey(privacyBudgetIndex, []string{patientID, period})
+
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	spentAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return "", "", 0, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	spent, _ := strconv.Atoi(string(spentAsBytes))
+
+	return key, period, spent, nil
+}
+
+// excludeExhausted removes from a cohort the patients whose budget toward the requester can't
+// pay for the aggregate this month
+func (s *SimpleContract) excludeExhausted(ctx contractapi.TransactionContextInterface, requesterID string, patientsIDs string, operation string, metrics []string) (string, error) {
+	cost := privacyCost(operation, metrics)
+	remaining := []string{}
+
+	for _, pid := range strings.Split(patientsIDs, ",") {
+		budget, err := s.privacyBudget(ctx, pid, requesterID)
+
+		if err != nil {
+			return "", err
+		}
+
+		if budget > 0 {
+			_, _, spent, err := privacySpent(ctx, pid)
+
+			if err != nil {
+				return "", err
+			}
+
+			if spent+cost > budget {
+				continue
+			}
+		}
+
+		remaining = append(remaining, pid)
+	}
+
+	if len(remaining) == 0 {
+		return "", newError(CodeQuotaExceeded, map[string]string{"requesterID": requesterID}, "Every patient of the cohort spent their privacy budget toward %s this month", requesterID)
+	}
+
+	return strings.Join(remaining, ","), nil
+}
+
+// spendPrivacyBudget charges the patients of a cohort with limited budgets for an aggregate,
+// refusing it if one of them can no longer pay. Combining released results costs nothing.
+func (s *SimpleContract) spendPrivacyBudget(ctx contractapi.TransactionContextInterface, proposalID string, proposal *Proposal, pids []string) error {
+	if len(proposal.ResultIDs) > 0 {
+		return nil
+	}
+
+	cost := privacyCost(proposal.Operation, proposal.Metrics)
+
+	for _, pid := range pids {
+		budget, err := s.privacyBudget(ctx, pid, proposal.RequesterID)
+
+		if err != nil {
+			return err
+		}
+
+		if budget == 0 {
+			continue
+		}
+
+		key, period, spent, err := privacySpent(ctx, pid)
+
+		if err != nil {
+			return err
+		}
+
+		if spent+cost > budget {
+			return newError(CodeQuotaExceeded, map[string]string{"id": proposalID, "patientID": pid, "period": period}, "%s spent %d of their privacy budget of %d in %s, %s costs %d", pid, spent, budget, period, proposalID, cost)
+		}
+
+		if err := ctx.GetStub().PutState(key, []byte(strconv.Itoa(spent+cost))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetConsentPrivacyBudget sets how much aggregates may spend each month of the patient of a
+// consent while it is in force, 0 leaving them to the channel's budget. Only the custodian of
+// the patient sets it.
+func (s *SimpleContract) SetConsentPrivacyBudget(ctx contractapi.TransactionContextInterface, id string, budget int) error {
+	if budget < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"budget": fmt.Sprint(budget)}, "The privacy budget can't be negative")
+	}
+
+	consent, err := s.FindConsent(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	patient, err := findPatient(ctx, consent.PatientID)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != patient.custodian() {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can set the privacy budget of %s", patient.custodian(), id)
+	}
+
+	consent.PrivacyBudget = budget
+
+	if err := consent.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	consentAsBytes, _ := json.Marshal(consent)
+
+	return ctx.GetStub().PutState(id, consentAsBytes)
+}
+
+// SetPrivacyBudget sets the monthly privacy budget of the patients whose consents set none,
+// 0 leaving them unlimited
+func (s *SimpleContract) SetPrivacyBudget(ctx contractapi.TransactionContextInterface, budget int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if budget < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"budget": fmt.Sprint(budget)}, "The privacy budget can't be negative")
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.PrivacyBudget = budget
+
+	return putConfig(ctx, config)
+}
+
+// GetPrivacyBudget returns the budget of a patient toward a grantee this month and what they
+// spent of it
+func (s *SimpleContract) GetPrivacyBudget(ctx contractapi.TransactionContextInterface, patientID string, granteeMSP string) (*PrivacyBudget, error) {
+	if _, err := findPatient(ctx, patientID); err != nil {
+		return nil, err
+	}
+
+	budget, err := s.privacyBudget(ctx, patientID, granteeMSP)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, period, spent, err := privacySpent(ctx, patientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &PrivacyBudget{PatientID: patientID, GranteeMSP: granteeMSP, Period: period, Budget: budget, Spent: spent}, nil
+}
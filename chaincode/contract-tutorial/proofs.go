@@ -0,0 +1,175 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/base64"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// MeasurementProof is a range proof produced off-chain for the encrypted value of a measurement.
+// The contract can't check the proof, it keeps it with the hash of the ciphertext it was
+// submitted for so auditors can verify it, and a new value leaves the measurement without one.
+type MeasurementProof struct {
+	Scheme         string             `json:"scheme"`
+	Proof          string             `json:"proof"`
+	CiphertextHash string             `json:"ciphertextHash"`
+	Submitted      TransactionDetails `json:"submitted"`
+}
+
+// MeasurementProofStatus is the proof of a measurement returned to auditors. Current tells
+// whether the proof was submitted for the value the measurement holds now.
+type MeasurementProofStatus struct {
+	PatientID string            `json:"patientID"`
+	Metric    string            `json:"metric"`
+	Proof     *MeasurementProof `json:"proof,omitempty" metadata:",optional"`
+	Current   bool              `json:"current"`
+}
+
+// proofMetric returns the name the proof of a metric is kept under
+func proofMetric(metric string) string {
+	if metric == "" {
+		return DefaultMetric
+	}
+
+	return metric
+}
+
+// currentProof returns the proof of a measurement if it covers the value the measurement holds
+func currentProof(patient *Patient, metric string) (*MeasurementProof, bool) {
+	proof, ok := patient.Proofs[proofMetric(metric)]
+
+	if !ok {
+		return nil, false
+	}
+
+	value, _ := measurement(patient, metric)
+
+	return &proof, proof.CiphertextHash == hashString(value)
+}
+
+// SetMeasurementProof attaches a base64 encoded range proof of a scheme to the current encrypted
+// value of a measurement of a patient, preExistingConditions included. Only the custodian of the
+// patient submits proofs.
+func (s *SimpleContract) SetMeasurementProof(ctx contractapi.TransactionContextInterface, id string, metric string, scheme string, proof string) error {
+	if scheme == "" {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "metric": metric}, "A proof needs the identifier of its scheme")
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(proof); err != nil || proof == "" {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "metric": metric}, "Proof is not base64 encoded")
+	}
+
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != patient.custodian() {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can submit the proofs of %s", patient.custodian(), id)
+	}
+
+	if err := checkNotFrozen(id, patient); err != nil {
+		return err
+	}
+
+	value, ok := measurement(patient, metric)
+
+	if !ok {
+		return newError(CodeNotFound, map[string]string{"id": id, "metric": metric}, "%s has no %s measurement", id, metric)
+	}
+
+	submitted, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if patient.Proofs == nil {
+		patient.Proofs = map[string]MeasurementProof{}
+	}
+
+	patient.Proofs[proofMetric(metric)] = MeasurementProof{Scheme: scheme, Proof: proof, CiphertextHash: hashString(value), Submitted: submitted}
+	patient.Metadata.Updated = submitted
+
+	return putPatient(ctx, id, patient)
+}
+
+// VerifyMeasurementProof returns the proof of a measurement of a patient for auditors to check
+// off-chain, and whether it covers the value the measurement holds now
+func (s *SimpleContract) VerifyMeasurementProof(ctx contractapi.TransactionContextInterface, id string, metric string) (*MeasurementProofStatus, error) {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := measurement(patient, metric); !ok {
+		return nil, newError(CodeNotFound, map[string]string{"id": id, "metric": metric}, "%s has no %s measurement", id, metric)
+	}
+
+	proof, current := currentProof(patient, metric)
+
+	return &MeasurementProofStatus{PatientID: id, Metric: proofMetric(metric), Proof: proof, Current: current}, nil
+}
+
+// SetStrictProofs sets whether proposals are only executed over measurements holding a
+// proof of their current value
+func (s *SimpleContract) SetStrictProofs(ctx contractapi.TransactionContextInterface, strict bool) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.StrictProofs = strict
+
+	return putConfig(ctx, config)
+}
+
+// checkCohortProofs refuses, in strict mode, to compute over measurements of the cohort
+// lacking a proof of the values the proposal reads
+func (s *SimpleContract) checkCohortProofs(ctx contractapi.TransactionContextInterface, proposal *Proposal, pids []string) error {
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	// Meta-analyses read results, not measurements
+	if !config.StrictProofs || len(proposal.ResultIDs) > 0 {
+		return nil
+	}
+
+	metrics := proposal.inputMetrics()
+
+	for _, pid := range pids {
+		patient, err := s.cohortPatient(ctx, pid, proposal.AsOf)
+
+		if err != nil {
+			return err
+		}
+
+		for _, metric := range metrics {
+			if _, current := currentProof(patient, metric); !current {
+				return newError(CodeInvalidState, map[string]string{"id": pid, "metric": metric}, "%s lacks a proof of its %s measurement", pid, metric)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ProtocolScope is the data a study protocol may compute over. Empty lists don't restrict.
+type ProtocolScope struct {
+	DiagnosisIDs  []string `json:"diagnosisIDs,omitempty" metadata:",optional"`
+	Metrics       []string `json:"metrics,omitempty" metadata:",optional"`
+	MaxCohortSize int      `json:"maxCohortSize"`
+}
+
+// StudyProtocol is a study approved by an IRB, which proposals are made under
+type StudyProtocol struct {
+	Title                    string        `json:"title"`
+	PrincipalInvestigator    string        `json:"principalInvestigator"`
+	PrincipalInvestigatorMSP string        `json:"principalInvestigatorMSP"`
+	IRBApprovalNumber        string        `json:"irbApprovalNumber"`
+	AllowedOperations        []string      `json:"allowedOperations"`
+	Scope                    ProtocolScope `json:"scope"`
+	Metadata                 Metadata      `json:"metadata"`
+}
+
+// splitList splits a comma separated list, an empty string being an empty list
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	return strings.Split(list, ",")
+}
+
+// contains tells whether a list holds a value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RegisterStudyProtocol records a study protocol with the caller as principal investigator.
+// Operations, diagnoses and metrics are comma separated, a max cohort size of 0 doesn't limit it.
+func (s *SimpleContract) RegisterStudyProtocol(ctx contractapi.TransactionContextInterface, id string, title string, irbApprovalNumber string, allowedOperations string, diagnosisIDs string, metrics string, maxCohortSize int) error {
+	if err := checkIDFormat(ctx, EntityProtocol, id); err != nil {
+		return err
+	}
+
+	if err := checkIDUnused(ctx, id); err != nil {
+		return err
+	}
+
+	if irbApprovalNumber == "" {
+		return newError(CodeInvalidArgument, map[string]string{"id": id}, "A study protocol needs an IRB approval number")
+	}
+
+	operations := splitList(allowedOperations)
+
+	if len(operations) == 0 {
+		return newError(CodeInvalidArgument, map[string]string{"allowedOperations": allowedOperations}, "A study protocol needs at least one operation")
+	}
+
+	for _, operation := range operations {
+		if !contains(supportedOperations, operation) {
+			return newError(CodeInvalidArgument, map[string]string{"operation": operation}, "Operation %s is not supported", operation)
+		}
+	}
+
+	if maxCohortSize < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"maxCohortSize": fmt.Sprint(maxCohortSize)}, "Max cohort size must not be negative")
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	protocol := StudyProtocol{
+		Title:                    title,
+		PrincipalInvestigator:    metadata.Created.ClientID,
+		PrincipalInvestigatorMSP: metadata.Created.MSPID,
+		IRBApprovalNumber:        irbApprovalNumber,
+		AllowedOperations:        operations,
+		Scope: ProtocolScope{
+			DiagnosisIDs:  splitList(diagnosisIDs),
+			Metrics:       splitList(metrics),
+			MaxCohortSize: maxCohortSize,
+		},
+		Metadata: metadata,
+	}
+
+	protocolAsBytes, _ := json.Marshal(protocol)
+
+	return ctx.GetStub().PutState(id, protocolAsBytes)
+}
+
+// FindStudyProtocol returns a study protocol
+func (s *SimpleContract) FindStudyProtocol(ctx contractapi.TransactionContextInterface, id string) (*StudyProtocol, error) {
+	protocolAsBytes, err := ctx.GetStub().GetState(id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if protocolAsBytes == nil {
+		return nil, errNotFound(id)
+	}
+
+	protocol := new(StudyProtocol)
+
+	if err := json.Unmarshal(protocolAsBytes, protocol); err != nil || protocol.IRBApprovalNumber == "" {
+		return nil, newError(CodeInvalidArgument, map[string]string{"id": id}, "%s is not a study protocol", id)
+	}
+
+	return protocol, nil
+}
+
+// checkProtocolScope refuses an operation over patients beyond the scope of a protocol
+func checkProtocolScope(protocolID string, protocol *StudyProtocol, operation string, patients []*Patient) error {
+	if !contains(protocol.AllowedOperations, operation) {
+		return newError(CodePermissionDenied, map[string]string{"protocolID": protocolID, "operation": operation}, "%s does not allow operation %s", protocolID, operation)
+	}
+
+	if protocol.Scope.MaxCohortSize > 0 && len(patients) > protocol.Scope.MaxCohortSize {
+		return newError(CodePermissionDenied, map[string]string{"protocolID": protocolID, "maxCohortSize": fmt.Sprint(protocol.Scope.MaxCohortSize)}, "%s allows cohorts of at most %d patients", protocolID, protocol.Scope.MaxCohortSize)
+	}
+
+	if len(protocol.Scope.DiagnosisIDs) > 0 {
+		for _, patient := range patients {
+			if !contains(protocol.Scope.DiagnosisIDs, patient.DiagnosisID) {
+				return newError(CodePermissionDenied, map[string]string{"protocolID": protocolID, "diagnosisID": patient.DiagnosisID}, "%s does not cover diagnosis %s", protocolID, patient.DiagnosisID)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkProtocolMetrics refuses metrics beyond the scope of a protocol
+func checkProtocolMetrics(protocolID string, protocol *StudyProtocol, metrics []string) error {
+	if len(protocol.Scope.Metrics) == 0 {
+		return nil
+	}
+
+	for _, metric := range metrics {
+		if !contains(protocol.Scope.Metrics, metric) {
+			return newError(CodePermissionDenied, map[string]string{"protocolID": protocolID, "metric": metric}, "%s does not cover metric %s", protocolID, metric)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// TransactionDetails describes the transaction that wrote a record
+type TransactionDetails struct {
+	TxID      string `json:"txID"`
+	Timestamp string `json:"timestamp"`
+	ClientID  string `json:"clientID"`
+	MSPID     string `json:"mspID"`
+}
+
+// Metadata keeps the creating and last updating transactions of a record
+type Metadata struct {
+	Created TransactionDetails `json:"created"`
+	Updated TransactionDetails `json:"updated"`
+}
+
+// Provenance is the provenance summary of a record
+type Provenance struct {
+	ID          string             `json:"id"`
+	Created     TransactionDetails `json:"created"`
+	LastUpdated TransactionDetails `json:"lastUpdated"`
+}
+
+// txTime returns the timestamp of the current transaction
+func txTime(ctx contractapi.TransactionContextInterface) (time.Time, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to read transaction timestamp. %s", err.Error())
+	}
+
+	return timeutil.FromUnix(ts.Seconds, ts.Nanos), nil
+}
+
+// parseTimestamp validates an RFC 3339 timestamp argument
+func parseTimestamp(name string, value string) (time.Time, error) {
+	t, err := timeutil.Parse(value)
+
+	if err != nil {
+		return time.Time{}, newError(CodeInvalidArgument, map[string]string{name: value}, "%s", err.Error())
+	}
+
+	return t, nil
+}
+
+// parseDay validates a YYYY-MM-DD date argument
+func parseDay(name string, value string) (time.Time, error) {
+	t, err := timeutil.ParseDay(value)
+
+	if err != nil {
+		return time.Time{}, newError(CodeInvalidArgument, map[string]string{name: value}, "%s", err.Error())
+	}
+
+	return t, nil
+}
+
+// callerMSP returns the MSP ID of the client submitting the transaction
+func callerMSP(ctx contractapi.TransactionContextInterface) (string, error) {
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to read client MSP. %s", err.Error())
+	}
+
+	return mspID, nil
+}
+
+// newTransactionDetails collects the details of the current transaction
+func newTransactionDetails(ctx contractapi.TransactionContextInterface) (TransactionDetails, error) {
+	t, err := txTime(ctx)
+
+	if err != nil {
+		return TransactionDetails{}, err
+	}
+
+	clientID, _, err := callerID(ctx)
+
+	if err != nil {
+		return TransactionDetails{}, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return TransactionDetails{}, err
+	}
+
+	return TransactionDetails{
+		TxID:      ctx.GetStub().GetTxID(),
+		Timestamp: timeutil.Format(t),
+		ClientID:  clientID,
+		MSPID:     mspID,
+	}, nil
+}
+
+// newMetadata returns the metadata of a record created by the current transaction
+func newMetadata(ctx contractapi.TransactionContextInterface) (Metadata, error) {
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	return Metadata{Created: details, Updated: details}, nil
+}
+
+// touch records the current transaction as the last update of the metadata
+func (m *Metadata) touch(ctx contractapi.TransactionContextInterface) error {
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	m.Updated = details
+
+	return nil
+}
+
+// GetTransactionDetails returns the provenance summary of a patient, proposal or result
+func (s *SimpleContract) GetTransactionDetails(ctx contractapi.TransactionContextInterface, id string) (*Provenance, error) {
+	recordAsBytes, err := ctx.GetStub().GetState(id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if recordAsBytes == nil {
+		return nil, errNotFound(id)
+	}
+
+	record := struct {
+		Metadata Metadata `json:"metadata"`
+	}{}
+	_ = json.Unmarshal(recordAsBytes, &record)
+
+	provenance := Provenance{
+		ID:          id,
+		Created:     record.Metadata.Created,
+		LastUpdated: record.Metadata.Updated,
+	}
+
+	return &provenance, nil
+}
@@ -0,0 +1,164 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// studySecretIndex is the composite key namespace of the secret of each study in the implicit
+// collection of its custodian, which only the peers of the custodian hold
+const studySecretIndex = "study~secret"
+
+// pseudonym returns the pseudonym of a patient for the study with the given secret
+func pseudonym(secret []byte, patientID string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(patientID))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// studySecret returns the secret a custodian set for a study, or nil if it has not been set.
+// Only the peers of the custodian can read it.
+func studySecret(ctx contractapi.TransactionContextInterface, custodianMSP string, studyID string) ([]byte, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(studySecretIndex, []string{studyID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ctx.GetStub().GetPrivateData("_implicit_org_"+custodianMSP, key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from private data. %s", err.Error())
+	}
+
+	return secret, nil
+}
+
+// requireStudyCustodian returns the proposal of a study if the caller is its custodian
+func (s *SimpleContract) requireStudyCustodian(ctx contractapi.TransactionContextInterface, studyID string, action string) (*Proposal, error) {
+	proposal, err := s.FindProposal(ctx, studyID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mspID != proposal.RequestedID {
+		return nil, newError(CodePermissionDenied, map[string]string{"studyID": studyID, "mspID": mspID}, "Only %s can %s of %s", proposal.RequestedID, action, studyID)
+	}
+
+	return proposal, nil
+}
+
+// SetStudySecret stores the secret passed in the "secret" transient field for a study, the
+// proposal whose patients it pseudonymizes, in the implicit collection of its custodian. Only
+// the custodian of the proposal sets it. The secret can't be changed afterwards, so the
+// pseudonyms of the study stay stable.
+func (s *SimpleContract) SetStudySecret(ctx contractapi.TransactionContextInterface, studyID string) error {
+	proposal, err := s.requireStudyCustodian(ctx, studyID, "set the secret")
+
+	if err != nil {
+		return err
+	}
+
+	transMap, err := ctx.GetStub().GetTransient()
+
+	if err != nil {
+		return fmt.Errorf("Error getting transient. %s", err.Error())
+	}
+
+	secret, ok := transMap["secret"]
+
+	if !ok || len(secret) < 32 {
+		return newError(CodeInvalidArgument, map[string]string{"studyID": studyID}, "secret of at least 32 bytes not found in the transient map")
+	}
+
+	previous, err := studySecret(ctx, proposal.RequestedID, studyID)
+
+	if err != nil {
+		return err
+	}
+
+	if previous != nil {
+		return newError(CodeAlreadyExists, map[string]string{"studyID": studyID}, "%s already has a secret", studyID)
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(studySecretIndex, []string{studyID})
+
+	if err != nil {
+		return err
+	}
+
+	collection, err := implicitCollection(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutPrivateData(collection, key, secret)
+}
+
+// GeneratePseudonym returns the deterministic pseudonym of a patient in a study, to the
+// custodian of the study only
+func (s *SimpleContract) GeneratePseudonym(ctx contractapi.TransactionContextInterface, patientID string, studyID string) (string, error) {
+	proposal, err := s.requireStudyCustodian(ctx, studyID, "pseudonymize the patients")
+
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := findPatient(ctx, patientID); err != nil {
+		return "", err
+	}
+
+	secret, err := studySecret(ctx, proposal.RequestedID, studyID)
+
+	if err != nil {
+		return "", err
+	}
+
+	if secret == nil {
+		return "", newError(CodeNotFound, map[string]string{"studyID": studyID}, "%s has no secret", studyID)
+	}
+
+	return pseudonym(secret, patientID), nil
+}
+
+// cohortPseudonyms returns the pseudonyms of the patients in a study, or nil when the study
+// has no secret. Only the peers of the custodian hold the secret, so transactions of other
+// orgs don't read it and leave the patients unpseudonymized.
+func cohortPseudonyms(ctx contractapi.TransactionContextInterface, studyID string, proposal *Proposal, pids []string) ([]string, error) {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil || mspID != proposal.RequestedID {
+		return nil, err
+	}
+
+	secret, err := studySecret(ctx, proposal.RequestedID, studyID)
+
+	if err != nil || secret == nil {
+		return nil, err
+	}
+
+	pseudonyms := []string{}
+
+	for _, pid := range pids {
+		pseudonyms = append(pseudonyms, pseudonym(secret, pid))
+	}
+
+	return pseudonyms, nil
+}
@@ -0,0 +1,281 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// publicationIndex is the composite key namespace of the publications, so they can be listed
+const publicationIndex = "publication~id"
+
+// DiagnosisMixed is the diagnosis class of cohorts with several diagnoses
+const DiagnosisMixed = "MIXED"
+
+// Publication is the public disclosure of a result. It references neither the
+// patients nor the orgs of the study, and either the encrypted value or the value
+// decrypted by the key owner.
+type Publication struct {
+	ID             string   `json:"id"`
+	Operation      string   `json:"operation"`
+	Metrics        []string `json:"metrics"`
+	CohortSizeBand string   `json:"cohortSizeBand"`
+	DiagnosisClass string   `json:"diagnosisClass"`
+	EncryptedValue string   `json:"encryptedValue,omitempty" metadata:",optional"`
+	Value          string   `json:"value,omitempty" metadata:",optional"`
+	Published      string   `json:"published"`
+	Embargo        string   `json:"embargo,omitempty" metadata:",optional"`
+}
+
+// cohortSizeBand hides the exact size of a cohort
+func cohortSizeBand(size int) string {
+	switch {
+	case size < 10:
+		return "1-9"
+	case size < 50:
+		return "10-49"
+	case size < 100:
+		return "50-99"
+	case size < 500:
+		return "100-499"
+	case size < 1000:
+		return "500-999"
+	default:
+		return "1000+"
+	}
+}
+
+// publicationMessage is what an admin of the key owner signs to attest the decrypted value of a result
+func publicationMessage(resultID string, value string) []byte {
+	return []byte(fmt.Sprintf("PUBLISH\x00%s\x00%s", resultID, value))
+}
+
+// publicationID returns the ID of the publication of a result, derived from the whole result ID
+func publicationID(resultID string) string {
+	return "PUBLICATION-" + resultID
+}
+
+// findResultPublication returns the key and the publication of a result, nil if it wasn't
+// published. Results published before publications took the whole result ID keep
+// the number of their result, as PUBLICATION<n> for RESULT<n>.
+func findResultPublication(ctx contractapi.TransactionContextInterface, resultID string) (string, *Publication, error) {
+	ids := []string{publicationID(resultID)}
+
+	if number := strings.TrimPrefix(resultID, "RESULT"); number != resultID && number != "" && strings.Trim(number, "0123456789") == "" {
+		ids = append(ids, "PUBLICATION"+number)
+	}
+
+	for _, id := range ids {
+		key, err := ctx.GetStub().CreateCompositeKey(publicationIndex, []string{id})
+
+		if err != nil {
+			return "", nil, err
+		}
+
+		publicationAsBytes, err := ctx.GetStub().GetState(key)
+
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+		}
+
+		if publicationAsBytes != nil {
+			publication := new(Publication)
+			_ = json.Unmarshal(publicationAsBytes, publication)
+
+			return key, publication, nil
+		}
+	}
+
+	return "", nil, nil
+}
+
+// diagnosisClass returns the diagnosis shared by the cohort, or DiagnosisMixed
+func (s *SimpleContract) diagnosisClass(ctx contractapi.TransactionContextInterface, proposal *Proposal) (string, error) {
+	class := ""
+
+	for _, pid := range strings.Split(proposal.PatientsIDs, ",") {
+		patient, err := s.cohortPatient(ctx, pid, proposal.AsOf)
+
+		if err != nil {
+			return "", err
+		}
+
+		if class != "" && class != patient.DiagnosisID {
+			return DiagnosisMixed, nil
+		}
+
+		class = patient.DiagnosisID
+	}
+
+	return class, nil
+}
+
+// PublishAnonymizedResult discloses a result to the public. Without a value the encrypted
+// value is published, otherwise the value must be attested by an admin of the key owner,
+// who signs it off-chain the way proposals are approved with attestations.
+func (s *SimpleContract) PublishAnonymizedResult(ctx contractapi.TransactionContextInterface, resultID string, value string, certPEM string, signature string) error {
+	result, err := s.FindResult(ctx, resultID)
+
+	if err != nil {
+		return err
+	}
+
+	proposal, err := s.FindProposal(ctx, result.ProposalID)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": resultID, "mspID": mspID}, "Only %s can publish %s", proposal.RequesterID, resultID)
+	}
+
+	_, published, err := findResultPublication(ctx, resultID)
+
+	if err != nil {
+		return err
+	}
+
+	if published != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": published.ID}, "%s has already been published as %s", resultID, published.ID)
+	}
+
+	id := publicationID(resultID)
+
+	key, err := ctx.GetStub().CreateCompositeKey(publicationIndex, []string{id})
+
+	if err != nil {
+		return err
+	}
+
+	class, err := s.diagnosisClass(ctx, proposal)
+
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	publication := Publication{
+		ID:             id,
+		Operation:      result.Manifest.Operation,
+		Metrics:        result.Manifest.Metrics,
+		CohortSizeBand: cohortSizeBand(result.Manifest.CohortSize),
+		DiagnosisClass: class,
+		Published:      timeutil.Format(now),
+		Embargo:        result.Embargo,
+	}
+
+	if value == "" {
+		publication.EncryptedValue = result.Value
+	} else {
+		keyRecord, err := findKeyRecord(ctx, result.KeyID)
+
+		if err != nil {
+			return err
+		}
+
+		if keyRecord == nil {
+			return newError(CodeInvalidState, map[string]string{"keyID": result.KeyID}, "%s is not registered, its owner can't attest the value", result.KeyID)
+		}
+
+		if _, err := verifyAttestation(ctx, keyRecord.OwnerMSP, publicationMessage(resultID, value), certPEM, signature); err != nil {
+			return withDetail(err, "id", resultID)
+		}
+
+		publication.Value = value
+	}
+
+	publicationAsBytes, _ := json.Marshal(publication)
+
+	return ctx.GetStub().PutState(key, publicationAsBytes)
+}
+
+// FindPublication returns a publication once the embargo of its result, if any, lifts
+func (s *SimpleContract) FindPublication(ctx contractapi.TransactionContextInterface, id string) (*Publication, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(publicationIndex, []string{id})
+
+	if err != nil {
+		return nil, err
+	}
+
+	publicationAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if publicationAsBytes == nil {
+		return nil, errNotFound(id)
+	}
+
+	publication := new(Publication)
+	_ = json.Unmarshal(publicationAsBytes, publication)
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if embargoed(publication.Embargo, now) {
+		return nil, newError(CodePermissionDenied, map[string]string{"id": id, "embargo": publication.Embargo}, "%s is under embargo until %s", id, publication.Embargo)
+	}
+
+	return publication, nil
+}
+
+// PublicationPage is a page of publications
+type PublicationPage struct {
+	Records   []Publication `json:"records"`
+	Bookmark  string        `json:"bookmark"`
+	Count     int32         `json:"count"`
+	Truncated bool          `json:"truncated"`
+}
+
+// AllPublications returns a page of the publications, those under embargo left out
+func (s *SimpleContract) AllPublications(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PublicationPage, error) {
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectPage(queryContext(), indexPages(ctx, publicationIndex, []string{}), size, bookmark, func(queryResponse *queryresult.KV) (Publication, bool, error) {
+		var publication Publication
+		_ = json.Unmarshal(queryResponse.Value, &publication)
+
+		return publication, !embargoed(publication.Embargo, now), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &PublicationPage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
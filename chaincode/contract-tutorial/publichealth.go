@@ -0,0 +1,382 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// emergencyQuorum is the number of orgs whose admins must approve a public-health emergency
+const emergencyQuorum = 2
+
+// maxEmergencyDuration is the longest a public-health emergency is declared for
+const maxEmergencyDuration = 90 * 24 * time.Hour
+
+// emergencyUseIndex is the composite key namespace of the consentless accesses under each
+// public-health emergency. No transaction updates or deletes its entries.
+const emergencyUseIndex = "emergency~use"
+
+// Public-health emergency statuses
+const (
+	EmergencyProposed = "PROPOSED"
+	EmergencyActive   = "ACTIVE"
+	EmergencyExpired  = "EXPIRED"
+	EmergencyEnded    = "ENDED"
+)
+
+// PublicHealthEmergency lets proposals compute the operations it lists without the consent of
+// their patients until it expires, once the admins of enough orgs approved it
+type PublicHealthEmergency struct {
+	ID            string               `json:"id"`
+	Operations    []string             `json:"operations"`
+	Justification string               `json:"justification"`
+	Expiry        string               `json:"expiry"`
+	Status        string               `json:"status"`
+	Approvals     []TransactionDetails `json:"approvals"`
+	Activated     *TransactionDetails  `json:"activated,omitempty" metadata:",optional"`
+	Ended         *TransactionDetails  `json:"ended,omitempty" metadata:",optional"`
+	Metadata      Metadata             `json:"metadata"`
+}
+
+// EmergencyUse records the patients a proposal accessed without their consent under a
+// public-health emergency
+type EmergencyUse struct {
+	EmergencyID string             `json:"emergencyID"`
+	ProposalID  string             `json:"proposalID"`
+	Operation   string             `json:"operation"`
+	PatientsIDs []string           `json:"patientsIDs"`
+	Used        TransactionDetails `json:"used"`
+}
+
+// EmergencyUsePage is a page of the consentless accesses under a public-health emergency
+type EmergencyUsePage struct {
+	Records   []EmergencyUse `json:"records"`
+	Bookmark  string         `json:"bookmark"`
+	Count     int32          `json:"count"`
+	Truncated bool           `json:"truncated"`
+}
+
+// findEmergency returns a public-health emergency, expired once past its expiry
+func findEmergency(ctx contractapi.TransactionContextInterface, id string) (*PublicHealthEmergency, error) {
+	emergencyAsBytes, err := ctx.GetStub().GetState(id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if emergencyAsBytes == nil {
+		return nil, errNotFound(id)
+	}
+
+	emergency := new(PublicHealthEmergency)
+	_ = json.Unmarshal(emergencyAsBytes, emergency)
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Emergencies expire without a transaction recording it
+	if (emergency.Status == EmergencyProposed || emergency.Status == EmergencyActive) && timeutil.Expired(emergency.Expiry, now) {
+		emergency.Status = EmergencyExpired
+	}
+
+	return emergency, nil
+}
+
+// putEmergency stamps and writes a public-health emergency
+func putEmergency(ctx contractapi.TransactionContextInterface, emergency *PublicHealthEmergency) error {
+	if err := emergency.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	emergencyAsBytes, _ := json.Marshal(emergency)
+
+	return ctx.GetStub().PutState(emergency.ID, emergencyAsBytes)
+}
+
+// activeEmergency returns the active public-health emergency, nil without one
+func activeEmergency(ctx contractapi.TransactionContextInterface) (*PublicHealthEmergency, error) {
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ActiveEmergency == "" {
+		return nil, nil
+	}
+
+	emergency, err := findEmergency(ctx, config.ActiveEmergency)
+
+	if err != nil || emergency.Status != EmergencyActive {
+		return nil, err
+	}
+
+	return emergency, nil
+}
+
+// DeclarePublicHealthEmergency proposes to let proposals compute the comma separated
+// operations without the consent of their patients until the expiry, at most 90 days ahead.
+// The declaring org approves it, and it is active once the admins of another org approve it
+// with ApprovePublicHealthEmergency.
+func (s *SimpleContract) DeclarePublicHealthEmergency(ctx contractapi.TransactionContextInterface, id string, operations string, justification string, expiry string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if err := checkReservedID(id); err != nil {
+		return err
+	}
+
+	if err := checkIDUnused(ctx, id); err != nil {
+		return err
+	}
+
+	operationsList := splitList(operations)
+
+	if len(operationsList) == 0 {
+		return newError(CodeInvalidArgument, map[string]string{"operations": operations}, "An emergency needs at least one operation")
+	}
+
+	for _, operation := range operationsList {
+		if !contains(supportedOperations, operation) {
+			return newError(CodeInvalidArgument, map[string]string{"operation": operation}, "Operation %s is not supported", operation)
+		}
+	}
+
+	if justification == "" {
+		return newError(CodeInvalidArgument, map[string]string{"id": id}, "An emergency needs a justification")
+	}
+
+	deadline, err := parseTimestamp("expiry", expiry)
+
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if !deadline.After(now) || deadline.Sub(now) > maxEmergencyDuration {
+		return newError(CodeInvalidArgument, map[string]string{"expiry": expiry}, "An emergency expires within %d days", int(maxEmergencyDuration.Hours()/24))
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	emergency := &PublicHealthEmergency{
+		ID:            id,
+		Operations:    operationsList,
+		Justification: justification,
+		Expiry:        expiry,
+		Status:        EmergencyProposed,
+		Approvals:     []TransactionDetails{details},
+		Metadata:      metadata,
+	}
+
+	return putEmergency(ctx, emergency)
+}
+
+// ApprovePublicHealthEmergency approves a declared emergency for the caller's org, activating
+// it once orgs enough approved it. Only one emergency is active at a time.
+func (s *SimpleContract) ApprovePublicHealthEmergency(ctx contractapi.TransactionContextInterface, id string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	emergency, err := findEmergency(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if emergency.Status != EmergencyProposed {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": emergency.Status}, "%s is not awaiting approval", id)
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	for _, approval := range emergency.Approvals {
+		if approval.MSPID == details.MSPID {
+			return newError(CodeAlreadyExists, map[string]string{"id": id, "mspID": details.MSPID}, "%s already approved %s", details.MSPID, id)
+		}
+	}
+
+	emergency.Approvals = append(emergency.Approvals, details)
+
+	if len(emergency.Approvals) < emergencyQuorum {
+		return putEmergency(ctx, emergency)
+	}
+
+	active, err := activeEmergency(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if active != nil {
+		return newError(CodeInvalidState, map[string]string{"id": id, "activeID": active.ID}, "%s is active, end it before activating %s", active.ID, id)
+	}
+
+	emergency.Status = EmergencyActive
+	emergency.Activated = &details
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.ActiveEmergency = id
+
+	if err := putConfig(ctx, config); err != nil {
+		return err
+	}
+
+	return putEmergency(ctx, emergency)
+}
+
+// EndPublicHealthEmergency ends an emergency before its expiry, or withdraws a declared one.
+// Restoring consent takes the admins of a single org.
+func (s *SimpleContract) EndPublicHealthEmergency(ctx contractapi.TransactionContextInterface, id string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	emergency, err := findEmergency(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if emergency.Status != EmergencyProposed && emergency.Status != EmergencyActive {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": emergency.Status}, "%s is %s", id, strings.ToLower(emergency.Status))
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if emergency.Status == EmergencyActive {
+		config, err := findConfig(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		config.ActiveEmergency = ""
+
+		if err := putConfig(ctx, config); err != nil {
+			return err
+		}
+	}
+
+	emergency.Status = EmergencyEnded
+	emergency.Ended = &details
+
+	return putEmergency(ctx, emergency)
+}
+
+// FindPublicHealthEmergency returns a public-health emergency
+func (s *SimpleContract) FindPublicHealthEmergency(ctx contractapi.TransactionContextInterface, id string) (*PublicHealthEmergency, error) {
+	return findEmergency(ctx, id)
+}
+
+// recordEmergencyUse logs the patients a proposal accessed without their consent under an
+// emergency and notifies their custodians
+func (s *SimpleContract) recordEmergencyUse(ctx contractapi.TransactionContextInterface, emergency *PublicHealthEmergency, proposalID string, proposal *Proposal, pids []string) error {
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(emergencyUseIndex, []string{emergency.ID, details.TxID, proposalID})
+
+	if err != nil {
+		return err
+	}
+
+	useAsBytes, _ := json.Marshal(EmergencyUse{EmergencyID: emergency.ID, ProposalID: proposalID, Operation: proposal.Operation, PatientsIDs: pids, Used: details})
+
+	if err := ctx.GetStub().PutState(key, useAsBytes); err != nil {
+		return err
+	}
+
+	custodians := map[string][]string{}
+	order := []string{}
+
+	for _, pid := range pids {
+		patient, err := findPatient(ctx, pid)
+
+		if err != nil {
+			return err
+		}
+
+		if _, ok := custodians[patient.custodian()]; !ok {
+			order = append(order, patient.custodian())
+		}
+
+		custodians[patient.custodian()] = append(custodians[patient.custodian()], pid)
+	}
+
+	for _, mspID := range order {
+		message := fmt.Sprintf("%s accessed %s without consent under %s", proposalID, strings.Join(custodians[mspID], ", "), emergency.ID)
+
+		if err := notify(ctx, mspID, NotificationConsentlessAccess, proposalID, message); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetEmergencyUses returns a page of the consentless accesses under a public-health emergency
+func (s *SimpleContract) GetEmergencyUses(ctx contractapi.TransactionContextInterface, id string, pageSize int32, bookmark string) (*EmergencyUsePage, error) {
+	if _, err := findEmergency(ctx, id); err != nil {
+		return nil, err
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectTypedPage[EmergencyUse](queryContext(), indexPages(ctx, emergencyUseIndex, []string{id}), size, bookmark)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmergencyUsePage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
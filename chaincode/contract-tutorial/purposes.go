@@ -0,0 +1,298 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// Purposes of access, consents recorded without purposes are for research
+const (
+	PurposeTreatment    = "TREATMENT"
+	PurposeResearch     = "RESEARCH"
+	PurposePublicHealth = "PUBLIC_HEALTH"
+	PurposeBilling      = "BILLING"
+)
+
+var purposes = []string{PurposeTreatment, PurposeResearch, PurposePublicHealth, PurposeBilling}
+
+// consentPatientIndex is the composite key namespace of the consents of each patient by grantee
+const consentPatientIndex = "consent~patient"
+
+// accessLogIndex is the composite key namespace of the accesses to each patient's data
+const accessLogIndex = "access~log"
+
+// AccessLogEntry records a use of a patient's data and its declared purpose
+type AccessLogEntry struct {
+	PatientID  string             `json:"patientID"`
+	ProposalID string             `json:"proposalID"`
+	Purpose    string             `json:"purpose"`
+	Accessed   TransactionDetails `json:"accessed"`
+}
+
+// parsePurposes splits a comma separated list of purposes, refusing unknown or no purposes
+func parsePurposes(list string) ([]string, error) {
+	codes := splitList(list)
+
+	if len(codes) == 0 {
+		return nil, newError(CodeInvalidArgument, map[string]string{"purposes": list}, "At least one purpose is needed")
+	}
+
+	for _, code := range codes {
+		if !contains(purposes, code) {
+			return nil, newError(CodeInvalidArgument, map[string]string{"purpose": code}, "Purpose %s is not supported", code)
+		}
+	}
+
+	return codes, nil
+}
+
+// covers tells whether a consent is granted for a purpose at a time, an expired consent
+// being treated as revoked before ExpireConsents records it
+func (c *Consent) covers(purpose string, now time.Time) bool {
+	if c.Status == ConsentRevoked || c.Status == ConsentExpired || c.expired(now) {
+		return false
+	}
+
+	if len(c.Purposes) == 0 {
+		return purpose == PurposeResearch
+	}
+
+	return contains(c.Purposes, purpose)
+}
+
+// indexConsent adds a consent to the consents of its patient
+func indexConsent(ctx contractapi.TransactionContextInterface, patientID string, granteeMSP string, id string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(consentPatientIndex, []string{patientID, granteeMSP, id})
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+// checkConsent refuses access to a patient without a consent to the grantee covering the purpose
+func (s *SimpleContract) checkConsent(ctx contractapi.TransactionContextInterface, patientID string, granteeMSP string, purpose string) error {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(consentPatientIndex, []string{patientID, granteeMSP})
+
+	if err != nil {
+		return err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	found := false
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		consent, err := s.FindConsent(ctx, attributes[2])
+
+		if err != nil {
+			return err
+		}
+
+		if consent.PatientID == patientID && consent.GranteeMSP == granteeMSP && consent.covers(purpose, now) {
+			found = true
+			return ledgeriter.ErrStop
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return queryError(err)
+	}
+
+	if found {
+		return nil
+	}
+
+	return newError(CodePermissionDenied, map[string]string{"patientID": patientID, "granteeMSP": granteeMSP, "purpose": purpose}, "%s has not consented to %s using their data for %s", patientID, granteeMSP, purpose)
+}
+
+// logAccess records the use of a patient's data by a proposal
+func logAccess(ctx contractapi.TransactionContextInterface, patientID string, proposalID string, purpose string) error {
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(accessLogIndex, []string{patientID, details.TxID, proposalID})
+
+	if err != nil {
+		return err
+	}
+
+	entryAsBytes, _ := json.Marshal(AccessLogEntry{PatientID: patientID, ProposalID: proposalID, Purpose: purpose, Accessed: details})
+
+	return ctx.GetStub().PutState(key, entryAsBytes)
+}
+
+// accessPurpose returns the declared purpose of a proposal, RESEARCH if it declared none
+func (p *Proposal) accessPurpose() string {
+	if p.Purpose == "" {
+		return PurposeResearch
+	}
+
+	return p.Purpose
+}
+
+// coveringEmergency returns the active public-health emergency covering the operation of a
+// proposal, nil if none does
+func coveringEmergency(ctx contractapi.TransactionContextInterface, proposal *Proposal) (*PublicHealthEmergency, error) {
+	emergency, err := activeEmergency(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if emergency != nil && !contains(emergency.Operations, proposal.Operation) {
+		return nil, nil
+	}
+
+	return emergency, nil
+}
+
+// authorizeCohort checks the consent of every patient of a proposal for its purpose, logs
+// their access and charges their privacy budget. Under a public-health emergency covering its operation, patients without
+// consent are accessed for public health and the use is recorded.
+func (s *SimpleContract) authorizeCohort(ctx contractapi.TransactionContextInterface, proposalID string, proposal *Proposal, pids []string) error {
+	purpose := proposal.accessPurpose()
+
+	emergency, err := coveringEmergency(ctx, proposal)
+
+	if err != nil {
+		return err
+	}
+
+	consentless := []string{}
+
+	for _, pid := range pids {
+		accessPurpose := purpose
+
+		if err := s.checkConsent(ctx, pid, proposal.RequesterID, purpose); err != nil {
+			if contractError, ok := err.(*ContractError); emergency == nil || !ok || contractError.Code != CodePermissionDenied {
+				return withDetail(err, "id", proposalID)
+			}
+
+			accessPurpose = PurposePublicHealth
+			consentless = append(consentless, pid)
+		}
+
+		if err := logAccess(ctx, pid, proposalID, accessPurpose); err != nil {
+			return err
+		}
+	}
+
+	if err := s.spendPrivacyBudget(ctx, proposalID, proposal, pids); err != nil {
+		return err
+	}
+
+	if len(consentless) == 0 {
+		return nil
+	}
+
+	return s.recordEmergencyUse(ctx, emergency, proposalID, proposal, consentless)
+}
+
+// SetProposalPurpose declares the purpose of a pending proposal, RESEARCH until set
+func (s *SimpleContract) SetProposalPurpose(ctx contractapi.TransactionContextInterface, id string, purpose string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalPending {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not pending", id)
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can set the purpose of %s", proposal.RequesterID, id)
+	}
+
+	if !contains(purposes, purpose) {
+		return newError(CodeInvalidArgument, map[string]string{"purpose": purpose}, "Purpose %s is not supported", purpose)
+	}
+
+	proposal.Purpose = purpose
+
+	if err := proposal.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// AccessLogPage is a page of access log entries
+type AccessLogPage struct {
+	Records   []AccessLogEntry `json:"records"`
+	Bookmark  string           `json:"bookmark"`
+	Count     int32            `json:"count"`
+	Truncated bool             `json:"truncated"`
+}
+
+// GetAccessLog returns a page of the accesses to a patient's data, to its custodian only
+func (s *SimpleContract) GetAccessLog(ctx contractapi.TransactionContextInterface, patientID string, pageSize int32, bookmark string) (*AccessLogPage, error) {
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mspID != patient.custodian() {
+		return nil, newError(CodePermissionDenied, map[string]string{"id": patientID, "mspID": mspID}, "Only %s can read the access log of %s", patient.custodian(), patientID)
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectTypedPage[AccessLogEntry](queryContext(), indexPages(ctx, accessLogIndex, []string{patientID}), size, bookmark)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessLogPage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
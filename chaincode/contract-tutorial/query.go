@@ -0,0 +1,97 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// maxQueryResults is the most results a query of the contract reads, the configuration can lower it
+const maxQueryResults = 10000
+
+// defaultPageSize is the size of the pages of the listings unless the configuration sets another
+const defaultPageSize = 100
+
+// queryContext returns the context of the queries of a transaction. The shim cancels
+// nothing itself, the context leaves room for deadlines set by the contract.
+func queryContext() context.Context {
+	return context.Background()
+}
+
+// queryError turns the limit of a query into a contract error
+func queryError(err error) error {
+	if errors.Is(err, ledgeriter.ErrLimitExceeded) {
+		return newError(CodeInvalidArgument, nil, "The query has too many results, narrow it down. %s", err.Error())
+	}
+
+	return err
+}
+
+// resultLimit returns the most results a query reads
+func resultLimit(ctx contractapi.TransactionContextInterface) (int, error) {
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if config.MaxResults > 0 {
+		return config.MaxResults, nil
+	}
+
+	return maxQueryResults, nil
+}
+
+// resolvePageSize returns the size of a page of a listing, the configured default when the
+// client asks for none, and the result limit when it asks for more
+func resolvePageSize(ctx contractapi.TransactionContextInterface, requested int32) (int32, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	size := requested
+
+	if size <= 0 {
+		size = defaultPageSize
+
+		if config.DefaultPageSize > 0 {
+			size = config.DefaultPageSize
+		}
+	}
+
+	if int(size) > limit {
+		size = int32(limit)
+	}
+
+	return size, nil
+}
+
+// indexPages returns the paginated query of the entries of a composite key index
+func indexPages(ctx contractapi.TransactionContextInterface, index string, attributes []string) ledgeriter.PageQuery {
+	return func(pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		return ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, attributes, pageSize, bookmark)
+	}
+}
+
+// rangePages returns the paginated query of a range of keys
+func rangePages(ctx contractapi.TransactionContextInterface, startKey string, endKey string) ledgeriter.PageQuery {
+	return func(pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		return ctx.GetStub().GetStateByRangeWithPagination(startKey, endKey, pageSize, bookmark)
+	}
+}
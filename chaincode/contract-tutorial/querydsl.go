@@ -0,0 +1,182 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/filterexpr"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// State databases of the peers, as named by their stateDatabase setting
+const (
+	StateDatabaseLevelDB = "goleveldb"
+	StateDatabaseCouchDB = "CouchDB"
+)
+
+// filterFields are the fields of a patient filter expressions compare, those stored in the clear
+var filterFields = []string{FieldName, FieldDiagnosisID, FieldStatusID, FieldKeyID}
+
+// patientField returns a field of a patient compared by filter expressions
+func patientField(patient *Patient, field string) string {
+	switch field {
+	case FieldName:
+		return patient.Name
+	case FieldDiagnosisID:
+		return patient.DiagnosisID
+	case FieldStatusID:
+		return patient.StatusID
+	}
+
+	return patient.KeyID
+}
+
+// parseFilter parses a filter expression over the fields the read policy of the caller lets through
+func parseFilter(ctx contractapi.TransactionContextInterface, expression string) (filterexpr.Expr, error) {
+	expr, err := filterexpr.Parse(expression)
+
+	if err != nil {
+		return nil, newError(CodeInvalidArgument, map[string]string{"expression": expression}, "Invalid filter expression, %s", err.Error())
+	}
+
+	filter, err := newResponseFilter(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range expr.Fields() {
+		if !contains(filterFields, field) {
+			return nil, newError(CodeInvalidArgument, map[string]string{"field": field}, "Patients can't be filtered by %s", field)
+		}
+
+		// Filtering by a withheld field would disclose it
+		if !filter.lets(field) {
+			return nil, newError(CodePermissionDenied, map[string]string{"field": field}, "The read policy of the caller withholds %s", field)
+		}
+	}
+
+	return expr, nil
+}
+
+// couchDBPages returns the paginated CouchDB query of the patients an org is the custodian of
+// matching an expression
+func couchDBPages(ctx contractapi.TransactionContextInterface, mspID string, expr filterexpr.Expr) ledgeriter.PageQuery {
+	query, _ := json.Marshal(map[string]interface{}{
+		"selector": map[string]interface{}{
+			"$and": []interface{}{
+				map[string]interface{}{FieldPreExistingConditions: map[string]interface{}{"$exists": true}},
+				// Patients never transferred have no custodianMSP, their custodian created them
+				map[string]interface{}{"$or": []interface{}{
+					map[string]interface{}{"custodianMSP": mspID},
+					map[string]interface{}{"custodianMSP": map[string]interface{}{"$exists": false}, "metadata.created.mspID": mspID},
+				}},
+				expr.Selector(""),
+			},
+		},
+	})
+
+	return func(pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+		return ctx.GetStub().GetQueryResultWithPagination(string(query), pageSize, bookmark)
+	}
+}
+
+// QueryPatientsDSL returns a page of the patients of the caller's org matching a filter expression,
+// comparisons of name, diagnosisID, statusID or keyID with values joined by AND and OR, e.g.
+// diagnosisID = D1 AND (statusID = S1 OR statusID = S2). On CouchDB the expression is compiled
+// to a selector, otherwise the patients of the org are scanned, and pages hold the matches only.
+func (s *SimpleContract) QueryPatientsDSL(ctx contractapi.TransactionContextInterface, expression string, pageSize int32, bookmark string) (*PatientPage, error) {
+	expr, err := parseFilter(ctx, expression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if config.StateDatabase == StateDatabaseCouchDB {
+		results, err := ledgeriter.CollectPage(queryContext(), couchDBPages(ctx, mspID, expr), size, bookmark, func(queryResponse *queryresult.KV) (QueryResult, bool, error) {
+			patient := new(Patient)
+			_ = json.Unmarshal(queryResponse.Value, patient)
+
+			return QueryResult{Key: queryResponse.Key, Record: patient}, true, nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		return newPatientPage(ctx, results)
+	}
+
+	results, err := ledgeriter.CollectPage(queryContext(), indexPages(ctx, patientOrgIndex, []string{mspID}), size, bookmark, func(queryResponse *queryresult.KV) (QueryResult, bool, error) {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return QueryResult{}, false, err
+		}
+
+		patient, err := findPatient(ctx, attributes[1])
+
+		if err != nil {
+			return QueryResult{}, false, err
+		}
+
+		matches := expr.Eval(func(field string) string {
+			return patientField(patient, field)
+		})
+
+		return QueryResult{Key: attributes[1], Record: patient}, matches, nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newPatientPage(ctx, results)
+}
+
+// SetStateDatabase tells the contract the state database of the peers, goleveldb or CouchDB,
+// so that QueryPatientsDSL runs CouchDB selectors where the peers support them
+func (s *SimpleContract) SetStateDatabase(ctx contractapi.TransactionContextInterface, database string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if database != StateDatabaseLevelDB && database != StateDatabaseCouchDB {
+		return newError(CodeInvalidArgument, map[string]string{"database": database}, "The state database is %s or %s", StateDatabaseLevelDB, StateDatabaseCouchDB)
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	config.StateDatabase = database
+
+	return putConfig(ctx, config)
+}
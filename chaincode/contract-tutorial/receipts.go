@@ -0,0 +1,209 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// SchemePHE names the encryption scheme of the computations
+const SchemePHE = "phe"
+
+// ReceiptInput holds the hashes of the encrypted values of a metric, in the order of the cohort
+type ReceiptInput struct {
+	Metric string   `json:"metric"`
+	Hashes []string `json:"hashes"`
+}
+
+// ComputationReceipt binds a result to the exact inputs and parameters it was computed from.
+// It is endorsed with the result, Hash being taken over the receipt JSON while it is empty.
+type ComputationReceipt struct {
+	ResultID    string         `json:"resultID"`
+	ProposalID  string         `json:"proposalID"`
+	Operation   string         `json:"operation"`
+	PatientsIDs []string       `json:"patientsIDs"`
+	AsOf        string         `json:"asOf,omitempty" metadata:",optional"`
+	Inputs      []ReceiptInput `json:"inputs"`
+	Scheme      string         `json:"scheme"`
+	Modulo      string         `json:"modulo"`
+	KeyID       string         `json:"keyID"`
+	OutputHash  string         `json:"outputHash"`
+	TxID        string         `json:"txID"`
+	Timestamp   string         `json:"timestamp"`
+	Hash        string         `json:"hash"`
+}
+
+// inputMetrics returns the metrics and flags a proposal reads from every patient
+func (p *Proposal) inputMetrics() []string {
+	switch p.Operation {
+	case OperationCount:
+		return []string{p.Metrics[0]}
+	case OperationAnd:
+		return []string{conjunction(p.Metrics[0], p.Metrics[1])}
+	case OperationOr:
+		return []string{p.Metrics[0], p.Metrics[1], conjunction(p.Metrics[0], p.Metrics[1])}
+	}
+
+	if len(p.Metrics) == 0 {
+		return []string{DefaultMetric}
+	}
+
+	return p.Metrics
+}
+
+// computationReceiptID returns the ID of the receipt of a proposal's result or result set,
+// derived from the whole proposal ID so no two proposals share one
+func computationReceiptID(proposalID string) string {
+	return "COMPUTATION-" + proposalID
+}
+
+// outputHash hashes the value of a result, or the values of a result set by metric
+func outputHash(value string, values map[string]string) string {
+	if values == nil {
+		return hashString(value)
+	}
+
+	valuesAsBytes, _ := json.Marshal(values)
+
+	return hashString(string(valuesAsBytes))
+}
+
+// putComputationReceipt records the receipt of a result. Its cohort is locked until then,
+// so the values read again are the ones the proposal was executed over.
+func (s *SimpleContract) putComputationReceipt(ctx contractapi.TransactionContextInterface, resultID string, proposalID string, proposal *Proposal, keyID string, modulo string, output string) (string, error) {
+	pids := proposal.aggregatedPatients()
+	inputs := []ReceiptInput{}
+
+	// A meta-analysis reads the values of its results rather than the patients'
+	if len(proposal.ResultIDs) > 0 {
+		input, err := s.resultHashes(ctx, proposal)
+
+		if err != nil {
+			return "", err
+		}
+
+		inputs = append(inputs, input)
+	} else {
+		for _, metric := range proposal.inputMetrics() {
+			ms, err := s.cohortValues(ctx, pids, metric, proposal.AsOf)
+
+			if err != nil {
+				return "", err
+			}
+
+			input := ReceiptInput{Metric: metric, Hashes: []string{}}
+
+			for _, m := range ms {
+				input.Hashes = append(input.Hashes, hashString(m))
+			}
+
+			inputs = append(inputs, input)
+		}
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	receipt := ComputationReceipt{
+		ResultID:    resultID,
+		ProposalID:  proposalID,
+		Operation:   proposal.Operation,
+		PatientsIDs: pids,
+		AsOf:        proposal.AsOf,
+		Inputs:      inputs,
+		Scheme:      SchemePHE,
+		Modulo:      modulo,
+		KeyID:       keyID,
+		OutputHash:  output,
+		TxID:        details.TxID,
+		Timestamp:   details.Timestamp,
+	}
+
+	receiptAsBytes, _ := json.Marshal(receipt)
+	receipt.Hash = hashString(string(receiptAsBytes))
+	receiptAsBytes, _ = json.Marshal(receipt)
+
+	id := computationReceiptID(proposalID)
+
+	return id, ctx.GetStub().PutState(id, receiptAsBytes)
+}
+
+// FindComputationReceipt returns the computation receipt of a result
+func (s *SimpleContract) FindComputationReceipt(ctx contractapi.TransactionContextInterface, id string) (*ComputationReceipt, error) {
+	receiptAsBytes, err := ctx.GetStub().GetState(id)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if receiptAsBytes == nil {
+		return nil, errNotFound(id)
+	}
+
+	receipt := new(ComputationReceipt)
+	_ = json.Unmarshal(receiptAsBytes, receipt)
+
+	return receipt, nil
+}
+
+// VerifyComputationReceipt checks a receipt against its own hash and the output of its result,
+// as it was computed if the result has been rekeyed since.
+// Parties holding the input ciphertexts check them by comparing their hashes to the receipt's.
+func (s *SimpleContract) VerifyComputationReceipt(ctx contractapi.TransactionContextInterface, id string) error {
+	receipt, err := s.FindComputationReceipt(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	unsigned := *receipt
+	unsigned.Hash = ""
+	unsignedAsBytes, _ := json.Marshal(unsigned)
+
+	if hashString(string(unsignedAsBytes)) != receipt.Hash {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "%s does not match its hash", id)
+	}
+
+	var output string
+	var rekeys []Rekey
+
+	if strings.HasPrefix(receipt.ResultID, "RESULTSET") {
+		resultSet, err := s.FindResultSet(ctx, receipt.ResultID)
+
+		if err != nil {
+			return err
+		}
+
+		output = outputHash("", resultSet.Values)
+		rekeys = resultSet.Rekeys
+	} else {
+		result, err := s.FindResult(ctx, receipt.ResultID)
+
+		if err != nil {
+			return err
+		}
+
+		output = outputHash(result.Value, nil)
+		rekeys = result.Rekeys
+	}
+
+	// A rekeyed result is checked by the output it had before its first key update
+	if len(rekeys) > 0 {
+		output = rekeys[0].OutputHash
+	}
+
+	if output != receipt.OutputHash {
+		return newError(CodeInvalidState, map[string]string{"id": id, "resultID": receipt.ResultID}, "The output of %s does not match %s", receipt.ResultID, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,266 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// resultKeyIndex is the composite key namespace of the results and result sets by the key they are encrypted under
+const resultKeyIndex = "result~key"
+
+// rekeyProgressIndex is the composite key namespace of the progress of the rekeying of the results of each rotated key
+const rekeyProgressIndex = "rekey~progress"
+
+// Rekey records that a result was moved from a rotated key. OutputHash is the hash of the
+// output before the move, so the first rekey holds the output its receipt was taken over.
+type Rekey struct {
+	FromKeyID  string             `json:"fromKeyID"`
+	OutputHash string             `json:"outputHash"`
+	Rekeyed    TransactionDetails `json:"rekeyed"`
+}
+
+// RekeyProgress is how far the results of a rotated key have been moved to its new key
+type RekeyProgress struct {
+	OldKeyID  string             `json:"oldKeyID"`
+	NewKeyID  string             `json:"newKeyID"`
+	Rekeyed   int                `json:"rekeyed"`
+	Remaining bool               `json:"remaining"`
+	Updated   TransactionDetails `json:"updated"`
+}
+
+// indexResultKey adds a result or result set to the results of its key
+func indexResultKey(ctx contractapi.TransactionContextInterface, keyID string, id string) error {
+	key, err := ctx.GetStub().CreateCompositeKey(resultKeyIndex, []string{keyID, id})
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+// rekeyResult moves a result or result set to a new key with a token and returns it encoded
+func (s *SimpleContract) rekeyResult(ctx contractapi.TransactionContextInterface, id string, q phewrap.Modulus, token phewrap.Token, newKeyID string, rekey Rekey) ([]byte, error) {
+	update := func(value string) (string, error) {
+		c, err := parseCiphertext(id, value)
+
+		if err != nil {
+			return "", err
+		}
+
+		return q.KeyUpdate(token, c).String(), nil
+	}
+
+	fingerprint, err := registeredFingerprint(ctx, newKeyID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(id, "RESULTSET") {
+		resultSet, err := s.FindResultSet(ctx, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		rekey.OutputHash = outputHash("", resultSet.Values)
+
+		for name, value := range resultSet.Values {
+			if resultSet.Values[name], err = update(value); err != nil {
+				return nil, err
+			}
+		}
+
+		resultSet.KeyID = newKeyID
+		resultSet.KeyFingerprint = fingerprint
+		resultSet.Rekeys = append(resultSet.Rekeys, rekey)
+		resultSet.Metadata.Updated = rekey.Rekeyed
+
+		return json.Marshal(resultSet)
+	}
+
+	result, err := s.FindResult(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rekey.OutputHash = outputHash(result.Value, nil)
+
+	if result.Value, err = update(result.Value); err != nil {
+		return nil, err
+	}
+
+	result.KeyID = newKeyID
+	result.KeyFingerprint = fingerprint
+	result.Rekeys = append(result.Rekeys, rekey)
+	result.Metadata.Updated = rekey.Rekeyed
+
+	return json.Marshal(result)
+}
+
+// RekeyResults moves a page of the results and result sets encrypted under a rotated key to the
+// key it was rotated to, with the token of the key update, and returns the progress. Each call
+// is atomic, and the results it moves leave the old key, so the owner of the old key calls it
+// again while results remain.
+func (s *SimpleContract) RekeyResults(ctx contractapi.TransactionContextInterface, oldKeyID string, newKeyID string, firstToken string, secondToken string) (*RekeyProgress, error) {
+	oldKey, err := s.FindKey(ctx, oldKeyID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if oldKey.Status != KeyRotated || oldKey.RotatedTo != newKeyID {
+		return nil, newError(CodeInvalidState, map[string]string{"id": oldKeyID, "newKeyID": newKeyID}, "%s was not rotated to %s", oldKeyID, newKeyID)
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mspID != oldKey.OwnerMSP {
+		return nil, newError(CodePermissionDenied, map[string]string{"id": oldKeyID, "mspID": mspID}, "Only %s can rekey the results of %s", oldKey.OwnerMSP, oldKeyID)
+	}
+
+	q, err := parseModulus(oldKey.Modulo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := parseToken(firstToken, secondToken)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := resolvePageSize(ctx, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	progress, err := s.FindRekeyProgress(ctx, oldKeyID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(resultKeyIndex, []string{oldKeyID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	rekeyed := 0
+	progress.Remaining = false
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		if rekeyed == int(pageSize) {
+			progress.Remaining = true
+			return ledgeriter.ErrStop
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		id := attributes[1]
+
+		recordAsBytes, err := s.rekeyResult(ctx, id, q, token, newKeyID, Rekey{FromKeyID: oldKeyID, Rekeyed: details})
+
+		if err != nil {
+			return withDetail(err, "resultID", id)
+		}
+
+		if err := ctx.GetStub().PutState(id, recordAsBytes); err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return err
+		}
+
+		if err := indexResultKey(ctx, newKeyID, id); err != nil {
+			return err
+		}
+
+		rekeyed++
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := accountKeyUsage(ctx, oldKeyID, 0, -rekeyed); err != nil {
+		return nil, err
+	}
+
+	if err := accountKeyUsage(ctx, newKeyID, 0, rekeyed); err != nil {
+		return nil, err
+	}
+
+	progress.NewKeyID = newKeyID
+	progress.Rekeyed += rekeyed
+	progress.Updated = details
+
+	key, err := ctx.GetStub().CreateCompositeKey(rekeyProgressIndex, []string{oldKeyID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	progressAsBytes, _ := json.Marshal(progress)
+
+	if err := ctx.GetStub().PutState(key, progressAsBytes); err != nil {
+		return nil, err
+	}
+
+	return progress, nil
+}
+
+// FindRekeyProgress returns how far the results of a key have been rekeyed, nothing yet if it never was
+func (s *SimpleContract) FindRekeyProgress(ctx contractapi.TransactionContextInterface, oldKeyID string) (*RekeyProgress, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(rekeyProgressIndex, []string{oldKeyID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	progressAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	progress := &RekeyProgress{OldKeyID: oldKeyID}
+
+	if progressAsBytes != nil {
+		_ = json.Unmarshal(progressAsBytes, progress)
+	}
+
+	return progress, nil
+}
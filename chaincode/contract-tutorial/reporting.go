@@ -0,0 +1,192 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// ReportingNamespace is the name of the reporting contract, called as "reporting:<function>"
+const ReportingNamespace = "reporting"
+
+// RoleReporting is the contract.role of the identities of reporting tools
+const RoleReporting = "reporting"
+
+// reportingFunctions are the functions of the reporting contract, all tagged as evaluate
+var reportingFunctions = []string{
+	"GetUsageStatistics", "GetOrgStatistics", "GetCompactionReport", "GetQuotaUsage", "OverdueProposals",
+	"AllPublications", "FindProposal", "FindResult", "FindResultSet", "GetResultLineage",
+	"FindComputationReceipt", "GetEventsSince",
+}
+
+// ReportingContract serves the statistics, listings and lineage of the contract to reporting
+// tools, called as "reporting:<function>". Its context refuses every write, so an identity
+// granted the reporting role can't change the state whether it evaluates or submits. The
+// statistics admins read in v2 are open to that role here, the other functions keep the
+// checks of their v2 counterpart.
+type ReportingContract struct {
+	contractapi.Contract
+	current SimpleContract
+}
+
+// newReportingContract returns the reporting contract
+func newReportingContract() *ReportingContract {
+	reportingContract := new(ReportingContract)
+	reportingContract.Name = ReportingNamespace
+	reportingContract.Info.Version = ContractVersion
+	reportingContract.Info.Description = "Read-only statistics, listings and lineage for reporting tools"
+	reportingContract.TransactionContextHandler = new(ReportingContext)
+	reportingContract.BeforeTransaction = beforeTransaction
+
+	return reportingContract
+}
+
+// GetEvaluateTransactions tags every function of the contract as evaluate in its metadata
+func (r *ReportingContract) GetEvaluateTransactions() []string {
+	return reportingFunctions
+}
+
+// ReportingContext is the transaction context of the reporting contract, whose stub refuses writes
+type ReportingContext struct {
+	CachingContext
+}
+
+// SetStub sets the stub of the transaction behind a read-only stub
+func (c *ReportingContext) SetStub(stub shim.ChaincodeStubInterface) {
+	c.CachingContext.SetStub(&readOnlyStub{ChaincodeStubInterface: stub})
+}
+
+// readOnlyStub refuses the writes and events of the reporting contract
+type readOnlyStub struct {
+	shim.ChaincodeStubInterface
+}
+
+// errReadOnly is the error of every write of the reporting contract
+func errReadOnly() error {
+	return newError(CodePermissionDenied, map[string]string{"contract": ReportingNamespace}, "The %s contract is read-only", ReportingNamespace)
+}
+
+// PutState refuses the write
+func (s *readOnlyStub) PutState(key string, value []byte) error {
+	return errReadOnly()
+}
+
+// DelState refuses the write
+func (s *readOnlyStub) DelState(key string) error {
+	return errReadOnly()
+}
+
+// SetStateValidationParameter refuses the write
+func (s *readOnlyStub) SetStateValidationParameter(key string, ep []byte) error {
+	return errReadOnly()
+}
+
+// PutPrivateData refuses the write
+func (s *readOnlyStub) PutPrivateData(collection string, key string, value []byte) error {
+	return errReadOnly()
+}
+
+// DelPrivateData refuses the write
+func (s *readOnlyStub) DelPrivateData(collection string, key string) error {
+	return errReadOnly()
+}
+
+// SetPrivateDataValidationParameter refuses the write
+func (s *readOnlyStub) SetPrivateDataValidationParameter(collection string, key string, ep []byte) error {
+	return errReadOnly()
+}
+
+// SetEvent refuses the event
+func (s *readOnlyStub) SetEvent(name string, payload []byte) error {
+	return errReadOnly()
+}
+
+// requireReporter refuses callers that are neither admins nor of the reporting role
+func requireReporter(ctx contractapi.TransactionContextInterface) error {
+	if requireAdmin(ctx) == nil {
+		return nil
+	}
+
+	role, err := callerRole(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if role != RoleReporting {
+		return newError(CodePermissionDenied, map[string]string{"attribute": roleAttribute, "role": role}, "Caller is neither an admin nor of the %s role", RoleReporting)
+	}
+
+	return nil
+}
+
+// GetUsageStatistics ...
+func (r *ReportingContract) GetUsageStatistics(ctx contractapi.TransactionContextInterface, from string, to string) (*UsageStatistics, error) {
+	return r.current.GetUsageStatistics(ctx, from, to)
+}
+
+// GetOrgStatistics returns the statistics of an org to admins and reporting identities
+func (r *ReportingContract) GetOrgStatistics(ctx contractapi.TransactionContextInterface, mspID string) (*OrgStatistics, error) {
+	if err := requireReporter(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.current.orgStatistics(ctx, mspID)
+}
+
+// GetCompactionReport returns the compaction report to admins and reporting identities
+func (r *ReportingContract) GetCompactionReport(ctx contractapi.TransactionContextInterface) (*CompactionReport, error) {
+	if err := requireReporter(ctx); err != nil {
+		return nil, err
+	}
+
+	return r.current.compactionReport(ctx)
+}
+
+// GetQuotaUsage ...
+func (r *ReportingContract) GetQuotaUsage(ctx contractapi.TransactionContextInterface, custodianMSP string, requesterMSP string) ([]QuotaUsage, error) {
+	return r.current.GetQuotaUsage(ctx, custodianMSP, requesterMSP)
+}
+
+// OverdueProposals ...
+func (r *ReportingContract) OverdueProposals(ctx contractapi.TransactionContextInterface) ([]OverdueProposal, error) {
+	return r.current.OverdueProposals(ctx)
+}
+
+// AllPublications ...
+func (r *ReportingContract) AllPublications(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*PublicationPage, error) {
+	return r.current.AllPublications(ctx, pageSize, bookmark)
+}
+
+// FindProposal ...
+func (r *ReportingContract) FindProposal(ctx contractapi.TransactionContextInterface, id string) (*Proposal, error) {
+	return r.current.FindProposal(ctx, id)
+}
+
+// FindResult ...
+func (r *ReportingContract) FindResult(ctx contractapi.TransactionContextInterface, id string) (*Result, error) {
+	return r.current.FindResult(ctx, id)
+}
+
+// FindResultSet ...
+func (r *ReportingContract) FindResultSet(ctx contractapi.TransactionContextInterface, id string) (*ResultSet, error) {
+	return r.current.FindResultSet(ctx, id)
+}
+
+// GetResultLineage ...
+func (r *ReportingContract) GetResultLineage(ctx contractapi.TransactionContextInterface, resultID string) (*ResultLineage, error) {
+	return r.current.GetResultLineage(ctx, resultID)
+}
+
+// FindComputationReceipt ...
+func (r *ReportingContract) FindComputationReceipt(ctx contractapi.TransactionContextInterface, id string) (*ComputationReceipt, error) {
+	return r.current.FindComputationReceipt(ctx, id)
+}
+
+// GetEventsSince ...
+func (r *ReportingContract) GetEventsSince(ctx contractapi.TransactionContextInterface, position string, limit int) ([]JournalEntry, error) {
+	return r.current.GetEventsSince(ctx, position, limit)
+}
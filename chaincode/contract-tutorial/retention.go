@@ -0,0 +1,376 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// minimizationIndex is the composite key namespace of the log of minimized fields, by patient
+const minimizationIndex = "minimization~patient"
+
+// Events a retention rule counts its period from
+const (
+	// RetentionFromCreation counts from the creation of the patient
+	RetentionFromCreation = "CREATION"
+	// RetentionFromDischarge counts from the discharge of the patient, never elapsing before it
+	RetentionFromDischarge = "DISCHARGE"
+)
+
+var retentionAnchors = []string{RetentionFromCreation, RetentionFromDischarge}
+
+// minimizableFields are the fields of a patient retention rules can clear. The key and the
+// metadata identify the record, so they are kept as long as it is.
+var minimizableFields = []string{FieldName, FieldPreExistingConditions, FieldDiagnosisID, FieldStatusID, FieldMeasurements}
+
+// RetentionRule keeps a field of the patients for Seconds after the event of its anchor
+type RetentionRule struct {
+	Anchor  string `json:"anchor"`
+	Seconds int64  `json:"seconds"`
+}
+
+// Minimization logs the clearing of a field of a patient by its retention rule. The value
+// is only kept as its hash, so a copy held elsewhere can be matched but not recovered.
+type Minimization struct {
+	PatientID string             `json:"patientID"`
+	Field     string             `json:"field"`
+	Rule      RetentionRule      `json:"rule"`
+	ValueHash string             `json:"valueHash"`
+	Minimized TransactionDetails `json:"minimized"`
+}
+
+// retentionAnchor returns the timestamp the period of a rule counts from, empty before its event
+func retentionAnchor(patient *Patient, anchor string) string {
+	if anchor == RetentionFromDischarge {
+		if patient.Discharged == nil {
+			return ""
+		}
+
+		return patient.Discharged.Timestamp
+	}
+
+	return patient.Metadata.Created.Timestamp
+}
+
+// fieldValue returns the value of a minimizable field of a patient as it is logged
+func fieldValue(patient *Patient, field string) string {
+	switch field {
+	case FieldName:
+		return patient.Name
+	case FieldPreExistingConditions:
+		return patient.PreExistingConditions
+	case FieldDiagnosisID:
+		return patient.DiagnosisID
+	case FieldStatusID:
+		return patient.StatusID
+	case FieldMeasurements:
+		if len(patient.Measurements) == 0 {
+			return ""
+		}
+
+		measurementsAsBytes, _ := json.Marshal(patient.Measurements)
+
+		return string(measurementsAsBytes)
+	}
+
+	return ""
+}
+
+// clearField clears a minimizable field of a patient
+func clearField(patient *Patient, field string) {
+	switch field {
+	case FieldName:
+		patient.Name = ""
+	case FieldPreExistingConditions:
+		patient.PreExistingConditions = ""
+	case FieldDiagnosisID:
+		patient.DiagnosisID = ""
+	case FieldStatusID:
+		patient.StatusID = ""
+	case FieldMeasurements:
+		patient.Measurements = nil
+		patient.Proofs = nil
+	}
+}
+
+// minimizeFields clears up to limit fields of the patients of the caller's org past their
+// retention, logging each, and tells whether fields past their retention remain. Frozen
+// patients are kept whole, and the value of a patient locked by a proposal until it is
+// executed. A transaction holds a single event, so minimizations are only logged, not
+// emitted as PatientUpdated.
+func minimizeFields(ctx contractapi.TransactionContextInterface, now time.Time, limit int) (int, bool, error) {
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	if len(config.RetentionRules) == 0 {
+		return 0, false, nil
+	}
+
+	fields := []string{}
+
+	for field := range config.RetentionRules {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(patientOrgIndex, []string{mspID})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	minimized := 0
+	remaining := false
+
+	// Patients are indexed by org, not by age, so every one is looked at
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		pid := attributes[1]
+		patient, err := findPatient(ctx, pid)
+
+		if err != nil || patient.Freeze != nil || patient.custodian() != mspID {
+			return nil
+		}
+
+		cleared := []string{}
+
+		for _, field := range fields {
+			rule := config.RetentionRules[field]
+			value := fieldValue(patient, field)
+
+			if value == "" || !expiredSince(retentionAnchor(patient, rule.Anchor), time.Duration(rule.Seconds)*time.Second, now) {
+				continue
+			}
+
+			if field == FieldPreExistingConditions {
+				proposalID, err := patientLock(ctx, pid)
+
+				if err != nil {
+					return err
+				}
+
+				if proposalID != "" {
+					continue
+				}
+			}
+
+			if minimized == limit {
+				remaining = true
+				break
+			}
+
+			if err := logMinimization(ctx, pid, field, rule, value); err != nil {
+				return err
+			}
+
+			clearField(patient, field)
+			cleared = append(cleared, field)
+			minimized++
+		}
+
+		// The versions kept for study cutoffs lose the fields too, the one replaced included
+		if len(cleared) > 0 {
+			if err := minimizePatientVersions(ctx, pid, cleared); err != nil {
+				return err
+			}
+
+			if err := keepPatientVersion(ctx, pid, patient); err != nil {
+				return err
+			}
+
+			if err := patient.Metadata.touch(ctx); err != nil {
+				return err
+			}
+
+			patientAsBytes, _ := json.Marshal(patient)
+
+			if err := ctx.GetStub().PutState(pid, patientAsBytes); err != nil {
+				return err
+			}
+		}
+
+		if remaining {
+			return ledgeriter.ErrStop
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, false, err
+	}
+
+	return minimized, remaining, nil
+}
+
+// logMinimization records the clearing of a field of a patient
+func logMinimization(ctx contractapi.TransactionContextInterface, pid string, field string, rule RetentionRule, value string) error {
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(minimizationIndex, []string{pid, field, details.TxID})
+
+	if err != nil {
+		return err
+	}
+
+	minimization := Minimization{PatientID: pid, Field: field, Rule: rule, ValueHash: hashString(value), Minimized: details}
+	minimizationAsBytes, _ := json.Marshal(minimization)
+
+	return ctx.GetStub().PutState(key, minimizationAsBytes)
+}
+
+// SetFieldRetention sets for how many seconds after the creation or the discharge of a
+// patient a field of it is kept before CollectGarbage clears it. 0 seconds removes the rule,
+// and fields without one are kept as long as the patient.
+func (s *SimpleContract) SetFieldRetention(ctx contractapi.TransactionContextInterface, field string, anchor string, seconds int64) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if !contains(minimizableFields, field) {
+		return newError(CodeInvalidArgument, map[string]string{"field": field}, "Retention rules apply to %s", strings.Join(minimizableFields, ", "))
+	}
+
+	if !contains(retentionAnchors, anchor) {
+		return newError(CodeInvalidArgument, map[string]string{"anchor": anchor}, "Retention is counted from %s", strings.Join(retentionAnchors, ", "))
+	}
+
+	if seconds < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"seconds": fmt.Sprint(seconds)}, "The retention can't be negative")
+	}
+
+	config, err := findConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if config.RetentionRules == nil {
+		config.RetentionRules = map[string]RetentionRule{}
+	}
+
+	if seconds == 0 {
+		delete(config.RetentionRules, field)
+	} else {
+		config.RetentionRules[field] = RetentionRule{Anchor: anchor, Seconds: seconds}
+	}
+
+	return putConfig(ctx, config)
+}
+
+// DischargePatient records the discharge of a patient, which the retention rules counted
+// from DISCHARGE start from. Only its custodian discharges a patient, once.
+func (s *SimpleContract) DischargePatient(ctx contractapi.TransactionContextInterface, id string) error {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if patient.custodian() != mspID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "custodianMSP": patient.custodian()}, "Only %s discharges %s", patient.custodian(), id)
+	}
+
+	if err := checkNotFrozen(id, patient); err != nil {
+		return err
+	}
+
+	if patient.Discharged != nil {
+		return newError(CodeInvalidState, map[string]string{"id": id, "discharged": patient.Discharged.Timestamp}, "%s was discharged on %s", id, patient.Discharged.Timestamp)
+	}
+
+	discharged, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	patient.Discharged = &discharged
+
+	if err := patient.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	return putPatient(ctx, id, patient)
+}
+
+// GetMinimizations returns the log of the fields of a patient cleared by retention rules, to
+// the members of its custodian org
+func (s *SimpleContract) GetMinimizations(ctx contractapi.TransactionContextInterface, patientID string) ([]Minimization, error) {
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if patient.custodian() != mspID {
+		return nil, newError(CodePermissionDenied, map[string]string{"id": patientID, "custodianMSP": patient.custodian()}, "Only %s reads the minimizations of %s", patient.custodian(), patientID)
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(minimizationIndex, []string{patientID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	minimizations := []Minimization{}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		minimization := Minimization{}
+		_ = json.Unmarshal(queryResponse.Value, &minimization)
+		minimizations = append(minimizations, minimization)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(minimizations, func(i, j int) bool {
+		return minimizations[i].Minimized.Timestamp < minimizations[j].Minimized.Timestamp
+	})
+
+	return minimizations, nil
+}
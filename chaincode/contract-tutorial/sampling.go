@@ -0,0 +1,178 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// CohortSelector selects the patients of a custodian a cohort is sampled from. Diagnoses and
+// statuses are comma separated, empty lists don't restrict. A chapter or block of the diagnosis
+// taxonomy selects the codes below it.
+type CohortSelector struct {
+	CustodianMSP string `json:"custodianMSP"`
+	DiagnosisIDs string `json:"diagnosisIDs,omitempty" metadata:",optional"`
+	StatusIDs    string `json:"statusIDs,omitempty" metadata:",optional"`
+	ProtocolID   string `json:"protocolID,omitempty" metadata:",optional"`
+}
+
+// CohortSample is a sample of a selection, its patients comma separated as proposals take them
+type CohortSample struct {
+	PatientsIDs string `json:"patientsIDs"`
+	Selected    int    `json:"selected"`
+	Seed        string `json:"seed"`
+}
+
+// sampleRank orders the patients of a sample, the same for every endorser given the seed
+func sampleRank(seed string, patientID string) string {
+	return hashString(seed + "\x00" + patientID)
+}
+
+// SampleCohort returns n patients of a selection, those whose SHA-256 hash of the seed and their
+// ID ranks first, so every endorser samples the same cohort and anyone can recompute it. The
+// selection is the unfrozen patients of the custodian filtered by diagnosis and status. Under a
+// protocol, patients outside its diagnoses or withdrawn from it are left out and n can't exceed
+// its max cohort size. The first k patients of a sample are its sample of k.
+func (s *SimpleContract) SampleCohort(ctx contractapi.TransactionContextInterface, selector CohortSelector, n int, seed string) (*CohortSample, error) {
+	if n < 1 {
+		return nil, newError(CodeInvalidArgument, map[string]string{"n": fmt.Sprint(n)}, "The sample size must be at least 1")
+	}
+
+	if seed == "" {
+		return nil, newError(CodeInvalidArgument, nil, "The seed is empty")
+	}
+
+	custodianMSP := selector.CustodianMSP
+
+	filter, err := newResponseFilter(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if (selector.DiagnosisIDs != "" && !filter.lets(FieldDiagnosisID)) || (selector.StatusIDs != "" && !filter.lets(FieldStatusID)) {
+		return nil, newError(CodePermissionDenied, nil, "The read policy of the caller does not let it select patients by the fields it can't read")
+	}
+
+	diagnosisIDs, err := expandDiagnoses(ctx, splitList(selector.DiagnosisIDs))
+
+	if err != nil {
+		return nil, err
+	}
+
+	statusIDs := splitList(selector.StatusIDs)
+
+	if selector.ProtocolID != "" {
+		protocol, err := s.FindStudyProtocol(ctx, selector.ProtocolID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if protocol.Scope.MaxCohortSize > 0 && n > protocol.Scope.MaxCohortSize {
+			return nil, newError(CodeInvalidArgument, map[string]string{"protocolID": selector.ProtocolID, "maxCohortSize": fmt.Sprint(protocol.Scope.MaxCohortSize)}, "%s allows cohorts of at most %d patients", selector.ProtocolID, protocol.Scope.MaxCohortSize)
+		}
+
+		scope, err := expandDiagnoses(ctx, protocol.Scope.DiagnosisIDs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(scope) > 0 {
+			scoped := []string{}
+
+			for _, id := range scope {
+				if len(diagnosisIDs) == 0 || contains(diagnosisIDs, id) {
+					scoped = append(scoped, id)
+				}
+			}
+
+			if len(scoped) == 0 {
+				return nil, newError(CodeInvalidArgument, map[string]string{"protocolID": selector.ProtocolID}, "%s covers none of the selected diagnoses", selector.ProtocolID)
+			}
+
+			diagnosisIDs = scoped
+		}
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(patientOrgIndex, []string{custodianMSP})
+
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []string{}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		patient, err := findPatient(ctx, attributes[1])
+
+		if err != nil {
+			return err
+		}
+
+		if patient.Freeze != nil || (len(diagnosisIDs) > 0 && !contains(diagnosisIDs, patient.DiagnosisID)) || (len(statusIDs) > 0 && !contains(statusIDs, patient.StatusID)) {
+			return nil
+		}
+
+		if selector.ProtocolID != "" {
+			withdrawn, err := isWithdrawn(ctx, attributes[1], selector.ProtocolID)
+
+			if err != nil || withdrawn {
+				return err
+			}
+		}
+
+		selected = append(selected, attributes[1])
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	if len(selected) == 0 {
+		return nil, newError(CodeNotFound, map[string]string{"custodianMSP": custodianMSP}, "No patient of %s matches the selection", custodianMSP)
+	}
+
+	ranks := make(map[string]string, len(selected))
+
+	for _, pid := range selected {
+		ranks[pid] = sampleRank(seed, pid)
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		return ranks[selected[i]] < ranks[selected[j]]
+	})
+
+	sample := CohortSample{Selected: len(selected), Seed: seed}
+
+	if n > len(selected) {
+		n = len(selected)
+	}
+
+	sample.PatientsIDs = strings.Join(selected[:n], ",")
+
+	return &sample, nil
+}
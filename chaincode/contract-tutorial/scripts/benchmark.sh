@@ -0,0 +1,49 @@
+#!/bin/bash
+#
+# SPDX-License-Identifier: Apache-2.0
+#
+# Measures the endorsement time of ExecuteProposal on a running network for
+# cohorts of 100, 1000 and 10000 patients. Run it once with the peers on
+# LevelDB and once on CouchDB, with the peer environment of the custodian
+# organization (Org2MSP) set, e.g.
+#
+#   ./scripts/benchmark.sh -C mychannel -n contract-tutorial --peerAddresses ...
+#
+# The requester side of the proposal is submitted with REQUESTER_ENV, a file
+# sourced before each requester call that sets the Org1MSP peer environment.
+
+set -e
+
+REQUESTER_ENV=${REQUESTER_ENV:-./requester.env}
+SIZES=${SIZES:-"100 1000 10000"}
+BATCH=${BATCH:-500}
+
+cd "$(dirname "$0")/.."
+
+# The proposals are made under a protocol of the requester, kept across runs
+(source "$REQUESTER_ENV" && peer chaincode invoke "$@" --waitForEvent -c '{"function":"v2:RegisterStudyProtocol","Args":["BENCHPROTOCOL","Benchmark","IRB-BENCH","MEAN","","","0"]}' > /dev/null 2>&1) || true
+
+for size in $SIZES; do
+  go run ./cmd/datagen -n "$size" -prefix "BENCH${size}P" -keyout "bench-${size}.key.json" -format json > "bench-${size}.json"
+
+  # Create the cohort in batches to stay within the transaction size limits
+  for ((start = 0; start < size; start += BATCH)); do
+    batch=$(jq -c ".[$start:$((start + BATCH))]" "bench-${size}.json")
+    peer chaincode invoke "$@" --waitForEvent -c "$(jq -nc --arg b "$batch" '{function: "v2:CreatePatientsBatch", Args: [$b]}')" > /dev/null
+  done
+
+  # Execution requires the consent of every patient to the requester
+  for ((start = 0; start < size; start += BATCH)); do
+    batch=$(jq -c --argjson size "$size" --argjson start "$start" '[.[$start:$start + '"$BATCH"'] | to_entries[] | {id: "CONSENT\($size * 100000 + $start + .key)", patientID: .value.id, granteeMSP: "Org1MSP", terms: "benchmark", purposes: "RESEARCH"}]' "bench-${size}.json")
+    peer chaincode invoke "$@" --waitForEvent -c "$(jq -nc --arg b "$batch" '{function: "v2:GrantConsentsBatch", Args: [$b]}')" > /dev/null
+  done
+
+  ids=$(jq -r '[.[].id] | join(",")' "bench-${size}.json")
+  modulo=$(jq -r '.q' "bench-${size}.key.json")
+
+  (source "$REQUESTER_ENV" && peer chaincode invoke "$@" --waitForEvent -c "$(jq -nc --arg p "BENCH${size}" --arg ids "$ids" '{function: "v2:CreateProposal", Args: [$p, "BENCHPROTOCOL", "Org1MSP", "Org2MSP", $ids, "KEY0", "MEAN", ""]}')" > /dev/null)
+  peer chaincode invoke "$@" --waitForEvent -c "{\"function\":\"v2:ApproveProposal\",\"Args\":[\"BENCH${size}\"]}" > /dev/null
+
+  echo "ExecuteProposal over ${size} patients:"
+  time (source "$REQUESTER_ENV" && peer chaincode invoke "$@" -c "{\"function\":\"v2:ExecuteProposal\",\"Args\":[\"BENCH${size}\",\"${modulo}\"]}" > /dev/null)
+done
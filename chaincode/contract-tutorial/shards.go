@@ -0,0 +1,352 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// patientShardIndex is the composite key namespace bucketing patients by the hash of their ID
+const patientShardIndex = "patient~shard"
+
+// shardConfigKey is the world state key of the sharding configuration
+const shardConfigKey = "SHARDCONFIG"
+
+// defaultShardCount is the number of buckets until ResizeShards is called
+const defaultShardCount = 16
+
+// adminAttribute is the client identity attribute granting the admin transactions
+const adminAttribute = "contract.admin"
+
+// ShardConfig is the number of buckets of the patient index. While a resize is in
+// progress Target is the new number of buckets and Rebalanced the old buckets already moved.
+type ShardConfig struct {
+	Count      int      `json:"count"`
+	Target     int      `json:"target,omitempty" metadata:",optional"`
+	Rebalanced []int    `json:"rebalanced,omitempty" metadata:",optional"`
+	Metadata   Metadata `json:"metadata"`
+}
+
+// PatientPage is a page of patients. Truncated tells more pages follow from Bookmark.
+type PatientPage struct {
+	Records   []QueryResult `json:"records"`
+	Bookmark  string        `json:"bookmark"`
+	Count     int32         `json:"count"`
+	Truncated bool          `json:"truncated"`
+}
+
+// newPatientPage applies the read policy of the caller to a page of patients
+func newPatientPage(ctx contractapi.TransactionContextInterface, results ledgeriter.Page[QueryResult]) (*PatientPage, error) {
+	records, err := filterRecords(ctx, results.Records)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &PatientPage{Records: records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}
+
+// requireAdmin refuses callers without the admin attribute
+func requireAdmin(ctx contractapi.TransactionContextInterface) error {
+	if err := ctx.GetClientIdentity().AssertAttributeValue(adminAttribute, "true"); err != nil {
+		return newError(CodePermissionDenied, map[string]string{"attribute": adminAttribute}, "Caller is not an admin. %s", err.Error())
+	}
+
+	return nil
+}
+
+// shardOf returns the bucket of a patient among count buckets
+func shardOf(id string, count int) int {
+	sum := sha256.Sum256([]byte(id))
+
+	return int(binary.BigEndian.Uint64(sum[:8]) % uint64(count))
+}
+
+// shardName formats a bucket so the buckets sort in order
+func shardName(shard int) string {
+	return fmt.Sprintf("%04d", shard)
+}
+
+// findShardConfig returns the sharding configuration, the default one if it was never resized
+func findShardConfig(ctx contractapi.TransactionContextInterface) (*ShardConfig, error) {
+	configAsBytes, err := cachedState(ctx, shardConfigKey)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	config := &ShardConfig{Count: defaultShardCount}
+
+	if configAsBytes != nil {
+		_ = json.Unmarshal(configAsBytes, config)
+	}
+
+	return config, nil
+}
+
+// buckets returns the number of buckets a patient may be in
+func (c *ShardConfig) buckets() int {
+	if c.Target > c.Count {
+		return c.Target
+	}
+
+	return c.Count
+}
+
+// indexPatient adds a new patient to its bucket, the new one during a resize
+func indexPatient(ctx contractapi.TransactionContextInterface, id string) error {
+	config, err := findShardConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	count := config.Count
+
+	if config.Target != 0 {
+		count = config.Target
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(patientShardIndex, []string{shardName(shardOf(id, count)), id})
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+// GetShardConfig returns the number of buckets patients are sharded into, for GetPatientsInShard
+func (s *SimpleContract) GetShardConfig(ctx contractapi.TransactionContextInterface) (*ShardConfig, error) {
+	return findShardConfig(ctx)
+}
+
+// GetPatientsInShard returns a page of the patients of a bucket the caller's org is the
+// custodian of. Clients scan the buckets of GetShardConfig in parallel, each one page by
+// page. Count is the number of index entries read, patients of other orgs included.
+func (s *SimpleContract) GetPatientsInShard(ctx contractapi.TransactionContextInterface, shard int, pageSize int32, bookmark string) (*PatientPage, error) {
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := findShardConfig(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if shard < 0 || shard >= config.buckets() {
+		return nil, newError(CodeInvalidArgument, map[string]string{"shard": fmt.Sprint(shard)}, "Shard must be between 0 and %d", config.buckets()-1)
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	page := &PatientPage{Records: []QueryResult{}}
+
+	page.Bookmark, page.Count, err = ledgeriter.ForEachPage(queryContext(), indexPages(ctx, patientShardIndex, []string{shardName(shard)}), size, bookmark, 1, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		patient, err := findPatient(ctx, attributes[1])
+
+		if err != nil {
+			return err
+		}
+
+		if patient.custodian() == mspID {
+			page.Records = append(page.Records, QueryResult{Key: attributes[1], Record: patient})
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	page.Records, err = filterRecords(ctx, page.Records)
+
+	if err != nil {
+		return nil, err
+	}
+
+	page.Truncated = page.Bookmark != ""
+
+	return page, nil
+}
+
+// ResizeShards starts moving the patient index to a new number of buckets.
+// The old buckets are then moved one by one with RebalanceShard.
+func (s *SimpleContract) ResizeShards(ctx contractapi.TransactionContextInterface, count int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	if count < 1 || count > 9999 {
+		return newError(CodeInvalidArgument, map[string]string{"count": fmt.Sprint(count)}, "Shard count must be between 1 and 9999")
+	}
+
+	config, err := findShardConfig(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if config.Target != 0 {
+		return newError(CodeInvalidState, map[string]string{"target": fmt.Sprint(config.Target)}, "A resize to %d shards is in progress", config.Target)
+	}
+
+	if count == config.Count {
+		return newError(CodeInvalidArgument, map[string]string{"count": fmt.Sprint(count)}, "There are already %d shards", count)
+	}
+
+	if err := touchShardConfig(ctx, config); err != nil {
+		return err
+	}
+
+	config.Target = count
+
+	configAsBytes, _ := json.Marshal(config)
+
+	return putCachedState(ctx, shardConfigKey, configAsBytes)
+}
+
+// RebalanceShard moves up to limit patients of an old bucket to their new bucket and
+// returns how many were moved, so it is called until it moves fewer than limit.
+// The resize completes once every old bucket is drained.
+func (s *SimpleContract) RebalanceShard(ctx contractapi.TransactionContextInterface, shard int, limit int) (int, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+
+	config, err := findShardConfig(ctx)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if config.Target == 0 {
+		return 0, newError(CodeInvalidState, nil, "No resize is in progress")
+	}
+
+	if shard < 0 || shard >= config.Count {
+		return 0, newError(CodeInvalidArgument, map[string]string{"shard": fmt.Sprint(shard)}, "Shard must be between 0 and %d", config.Count-1)
+	}
+
+	for _, done := range config.Rebalanced {
+		if done == shard {
+			return 0, nil
+		}
+	}
+
+	if limit < 1 {
+		return 0, newError(CodeInvalidArgument, map[string]string{"limit": fmt.Sprint(limit)}, "Limit must be positive")
+	}
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(patientShardIndex, []string{shardName(shard)})
+
+	if err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	more := false
+
+	// Patients staying in the bucket are read but not counted, so the walk has no limit of its own
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		if moved == limit {
+			more = true
+			return ledgeriter.ErrStop
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		target := shardOf(attributes[1], config.Target)
+
+		if target == shard {
+			return nil
+		}
+
+		key, err := ctx.GetStub().CreateCompositeKey(patientShardIndex, []string{shardName(target), attributes[1]})
+
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().DelState(queryResponse.Key); err != nil {
+			return err
+		}
+
+		moved++
+
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	// More patients may be left to move in this bucket
+	if more {
+		return moved, nil
+	}
+
+	config.Rebalanced = append(config.Rebalanced, shard)
+
+	if len(config.Rebalanced) == config.Count {
+		config.Count = config.Target
+		config.Target = 0
+		config.Rebalanced = nil
+	}
+
+	if err := touchShardConfig(ctx, config); err != nil {
+		return 0, err
+	}
+
+	configAsBytes, _ := json.Marshal(config)
+
+	return moved, putCachedState(ctx, shardConfigKey, configAsBytes)
+}
+
+// touchShardConfig sets the metadata of the configuration, creating it the first time
+func touchShardConfig(ctx contractapi.TransactionContextInterface, config *ShardConfig) error {
+	if config.Metadata.Created.TxID == "" {
+		metadata, err := newMetadata(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		config.Metadata = metadata
+
+		return nil
+	}
+
+	return config.Metadata.touch(ctx)
+}
@@ -10,8 +10,11 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/hanesbarbosa/phe"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/payloadschema"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
 )
 
 // SimpleContract provides functions for managing a car
@@ -21,52 +24,170 @@ type SimpleContract struct {
 
 // Patient describes basic details of a patient
 type Patient struct {
-	Name                  string `json:"name"`
-	PreExistingConditions string `json:"preExistingConditions"`
-	DiagnosisID           string `json:"diagnosisID"`
-	StatusID              string `json:"statusID"`
-	KeyID                 string `json:"keyID"`
+	Name                  string                      `json:"name"`
+	PreExistingConditions string                      `json:"preExistingConditions"`
+	DiagnosisID           string                      `json:"diagnosisID"`
+	StatusID              string                      `json:"statusID"`
+	KeyID                 string                      `json:"keyID"`
+	KeyFingerprint        string                      `json:"keyFingerprint,omitempty" metadata:",optional"`
+	Measurements          map[string]string           `json:"measurements,omitempty" metadata:",optional"`
+	Proofs                map[string]MeasurementProof `json:"proofs,omitempty" metadata:",optional"`
+	Freeze                *Freeze                     `json:"freeze,omitempty" metadata:",optional"`
+	CustodianMSP          string                      `json:"custodianMSP,omitempty" metadata:",optional"`
+	Transfer              *PatientTransfer            `json:"transfer,omitempty" metadata:",optional"`
+	Discharged            *TransactionDetails         `json:"discharged,omitempty" metadata:",optional"`
+	Metadata              Metadata                    `json:"metadata"`
+	// Redacted lists the fields the read policy of the caller withheld, it is never stored
+	Redacted []string `json:"redacted,omitempty" metadata:",optional"`
 }
 
-// Proposal ...
+// Proposal statuses
+const (
+	ProposalPending  = "PENDING"
+	ProposalApproved = "APPROVED"
+	ProposalExecuted = "EXECUTED"
+)
+
+// OperationMean averages the cohort's encrypted values
+const OperationMean = "MEAN"
+
+// Proposal requests an operation over a cohort of patients of another org, executed under
+// the key of the requester once the requested org approves it
 type Proposal struct {
-	RequesterID string `json:"requesterID"`
-	RequestedID string `json:"requestedID"`
-	PatientsIDs string `json:"patientsIDs"`
-	KeyID       string `json:"keyID"`
-	Value       string `json:"value"`
+	ProtocolID             string                 `json:"protocolID"`
+	RequesterID            string                 `json:"requesterID"`
+	RequestedID            string                 `json:"requestedID"`
+	PatientsIDs            string                 `json:"patientsIDs"`
+	KeyID                  string                 `json:"keyID"`
+	Operation              string                 `json:"operation"`
+	Expiry                 string                 `json:"expiry"`
+	AsOf                   string                 `json:"asOf,omitempty" metadata:",optional"`
+	Status                 string                 `json:"status"`
+	Version                int                    `json:"version"`
+	Approvals              []TransactionDetails   `json:"approvals,omitempty" metadata:",optional"`
+	Negotiation            []CounterProposal      `json:"negotiation,omitempty" metadata:",optional"`
+	Metrics                []string               `json:"metrics,omitempty" metadata:",optional"`
+	Definitions            []MeasurementSemantics `json:"definitions,omitempty" metadata:",optional"`
+	SkipIncomplete         bool                   `json:"skipIncomplete,omitempty" metadata:",optional"`
+	Skipped                []SkippedPatient       `json:"skipped,omitempty" metadata:",optional"`
+	Value                  string                 `json:"value"`
+	Values                 map[string]string      `json:"values,omitempty" metadata:",optional"`
+	ResultIDs              []string               `json:"resultIDs,omitempty" metadata:",optional"`
+	Purpose                string                 `json:"purpose,omitempty" metadata:",optional"`
+	GroupBy                string                 `json:"groupBy,omitempty" metadata:",optional"`
+	GroupSizes             map[string]int         `json:"groupSizes,omitempty" metadata:",optional"`
+	DistinctDiagnoses      int                    `json:"distinctDiagnoses,omitempty" metadata:",optional"`
+	ContentHash            string                 `json:"contentHash,omitempty" metadata:",optional"`
+	Exclusions             []Exclusion            `json:"exclusions,omitempty" metadata:",optional"`
+	ExclusionsHash         string                 `json:"exclusionsHash,omitempty" metadata:",optional"`
+	ExclusionsAcknowledged *TransactionDetails    `json:"exclusionsAcknowledged,omitempty" metadata:",optional"`
+	MultiKey               bool                   `json:"multiKey,omitempty" metadata:",optional"`
+	Partials               []KeyPartial           `json:"partials,omitempty" metadata:",optional"`
+	NoiseRequired          bool                   `json:"noiseRequired,omitempty" metadata:",optional"`
+	Noise                  string                 `json:"noise,omitempty" metadata:",optional"`
+	ResponseDue            string                 `json:"responseDue,omitempty" metadata:",optional"`
+	Escalated              *TransactionDetails    `json:"escalated,omitempty" metadata:",optional"`
+	Released               *TransactionDetails    `json:"released,omitempty" metadata:",optional"`
+	Reason                 *DecisionReason        `json:"reason,omitempty" metadata:",optional"`
+	Metadata               Metadata               `json:"metadata"`
 }
 
-// Result ...
+// Result is the value of an executed proposal released to its requester, moved to its key
 type Result struct {
-	ProposalID string `json:"proposalID"`
-	KeyID      string `json:"keyID"`
-	Value      string `json:"value"`
+	ProposalID     string   `json:"proposalID"`
+	KeyID          string   `json:"keyID"`
+	KeyFingerprint string   `json:"keyFingerprint,omitempty" metadata:",optional"`
+	Value          string   `json:"value"`
+	Manifest       Manifest `json:"manifest"`
+	ReceiptID      string   `json:"receiptID,omitempty" metadata:",optional"`
+	Rekeys         []Rekey  `json:"rekeys,omitempty" metadata:",optional"`
+	Embargo        string   `json:"embargo,omitempty" metadata:",optional"`
+	Metadata       Metadata `json:"metadata"`
 }
 
-// QueryResult ...
+// QueryResult is a patient of a range query with its key
 type QueryResult struct {
 	Key    string `json:"Key"`
 	Record *Patient
 }
 
-// CreatePatient ...
+// CreatePatient creates a patient whose pre-existing conditions are encrypted under a key, the
+// caller's org becoming its custodian
 func (s *SimpleContract) CreatePatient(ctx contractapi.TransactionContextInterface, id string, name string, preExistingConditions string, diagnosisID string, statusID string, keyID string) error {
+	if done, err := beginRequest(ctx, "CreatePatient", id, name, preExistingConditions, diagnosisID, statusID, keyID); err != nil || done {
+		return err
+	}
+
+	if err := s.putPatient(ctx, id, name, preExistingConditions, diagnosisID, statusID, keyID); err != nil {
+		return err
+	}
+
+	if err := countUsage(ctx, counterPatientsCreated, 1); err != nil {
+		return err
+	}
+
+	return accountKeyUsage(ctx, keyID, 0, 1)
+}
+
+// putPatient writes a new patient without accounting for its key. A patient of another org is
+// moved with a custody transfer, never created again.
+func (s *SimpleContract) putPatient(ctx contractapi.TransactionContextInterface, id string, name string, preExistingConditions string, diagnosisID string, statusID string, keyID string) error {
+	if err := checkIDFormat(ctx, EntityPatient, id); err != nil {
+		return err
+	}
+
+	if err := checkIDUnused(ctx, id); err != nil {
+		return err
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := registeredFingerprint(ctx, keyID)
+
+	if err != nil {
+		return err
+	}
+
 	patient := Patient{
 		Name:                  name,
 		PreExistingConditions: preExistingConditions,
 		DiagnosisID:           diagnosisID,
 		StatusID:              statusID,
 		KeyID:                 keyID,
+		KeyFingerprint:        fingerprint,
+		Metadata:              metadata,
 	}
 
 	patientAsBytes, _ := json.Marshal(patient)
 
-	return ctx.GetStub().PutState(id, patientAsBytes)
+	if err := ctx.GetStub().PutState(id, patientAsBytes); err != nil {
+		return err
+	}
+
+	if err := indexPatientOrg(ctx, metadata.Created.MSPID, id); err != nil {
+		return err
+	}
+
+	return indexPatient(ctx, id)
 }
 
-// FindPatient ...
+// FindPatient returns the fields of a patient the read policy of the caller lets through
 func (s *SimpleContract) FindPatient(ctx contractapi.TransactionContextInterface, id string) (*Patient, error) {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return filterPatient(ctx, patient)
+}
+
+// findPatient returns the whole record of a patient
+func findPatient(ctx contractapi.TransactionContextInterface, id string) (*Patient, error) {
 	patientAsBytes, err := ctx.GetStub().GetState(id)
 
 	if err != nil {
@@ -74,7 +195,7 @@ func (s *SimpleContract) FindPatient(ctx contractapi.TransactionContextInterface
 	}
 
 	if patientAsBytes == nil {
-		return nil, fmt.Errorf("%s does not exist", id)
+		return nil, errNotFound(id)
 	}
 
 	patient := new(Patient)
@@ -83,89 +204,540 @@ func (s *SimpleContract) FindPatient(ctx contractapi.TransactionContextInterface
 	return patient, nil
 }
 
-// AllPatients ...
-func (s *SimpleContract) AllPatients(ctx contractapi.TransactionContextInterface, firstID string, lastID string) ([]QueryResult, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange(firstID, lastID)
+// AllPatients returns a page of the records in a range of keys the caller's org is the custodian of.
+// The patients of other orgs are only reached through proposals and consents.
+func (s *SimpleContract) AllPatients(ctx contractapi.TransactionContextInterface, firstID string, lastID string, pageSize int32, bookmark string) (*PatientPage, error) {
+	mspID, err := callerMSP(ctx)
 
 	if err != nil {
 		return nil, err
 	}
-	defer resultsIterator.Close()
-
-	results := []QueryResult{}
 
-	for resultsIterator.HasNext() {
-		queryResponse, err := resultsIterator.Next()
+	size, err := resolvePageSize(ctx, pageSize)
 
-		if err != nil {
-			return nil, err
-		}
+	if err != nil {
+		return nil, err
+	}
 
+	results, err := ledgeriter.CollectPage(queryContext(), rangePages(ctx, firstID, lastID), size, bookmark, func(queryResponse *queryresult.KV) (QueryResult, bool, error) {
 		patient := new(Patient)
 		_ = json.Unmarshal(queryResponse.Value, patient)
 
-		queryResult := QueryResult{Key: queryResponse.Key, Record: patient}
-		results = append(results, queryResult)
+		return QueryResult{Key: queryResponse.Key, Record: patient}, patient.custodian() == mspID, nil
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	return results, nil
+	return newPatientPage(ctx, results)
 }
 
-// UpdatePatient ...
+// UpdatePatient replaces the fields of a patient, unless it is frozen, or its pre-existing
+// conditions while a proposal locks it, and emits PatientUpdated
 func (s *SimpleContract) UpdatePatient(ctx contractapi.TransactionContextInterface, id string, name string, preExistingConditions string, diagnosisID string, statusID string, keyID string) error {
-	patient, err := s.FindPatient(ctx, id)
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := checkNotFrozen(id, patient); err != nil {
+		return err
+	}
+
+	if patient.PreExistingConditions != preExistingConditions {
+		proposalID, err := patientLock(ctx, id)
+
+		if err != nil {
+			return err
+		}
+
+		if proposalID != "" {
+			return newError(CodeInvalidState, map[string]string{"id": id, "proposalID": proposalID}, "%s is locked by %s until it is executed", id, proposalID)
+		}
+	}
+
+	if patient.KeyID != keyID {
+		if err := accountKeyUsage(ctx, patient.KeyID, 0, -1); err != nil {
+			return err
+		}
+
+		if err := accountKeyUsage(ctx, keyID, 0, 1); err != nil {
+			return err
+		}
+	}
+
+	fingerprint, err := registeredFingerprint(ctx, keyID)
 
 	if err != nil {
 		return err
 	}
 
+	before := trackedFields(patient)
+
 	patient.Name = name
 	patient.PreExistingConditions = preExistingConditions
 	patient.DiagnosisID = diagnosisID
 	patient.StatusID = statusID
 	patient.KeyID = keyID
+	patient.KeyFingerprint = fingerprint
 
-	patientAsBytes, _ := json.Marshal(patient)
+	if err := patient.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	if err := emitPatientUpdated(ctx, id, before, patient); err != nil {
+		return err
+	}
+
+	return putPatient(ctx, id, patient)
+}
+
+// CreateProposal requests an operation over the comma separated patients of another org under
+// a study protocol, leaving out those who withdrew or exhausted their budget, and notifies the
+// requested org it awaits its approval
+func (s *SimpleContract) CreateProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, operation string, expiry string) error {
+	if done, err := beginRequest(ctx, "CreateProposal", id, protocolID, requesterID, requestedID, patientsIDs, keyID, operation, expiry); err != nil || done {
+		return err
+	}
+
+	proposal, err := s.proposeCohort(ctx, id, protocolID, requesterID, requestedID, patientsIDs, keyID, operation, expiry, nil)
+
+	if err != nil {
+		return err
+	}
+
+	return s.storeNewProposal(ctx, id, proposal)
+}
+
+// proposeCohort returns a pending proposal over the comma separated patients of a cohort,
+// leaving out those who withdrew from the protocol or exhausted their budget
+func (s *SimpleContract) proposeCohort(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, operation string, expiry string, metrics []string) (*Proposal, error) {
+	patientsIDs, err := excludeWithdrawn(ctx, protocolID, patientsIDs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	patientsIDs, err = s.excludeExhausted(ctx, requesterID, patientsIDs, operation, metrics)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newProposal(ctx, id, protocolID, requesterID, requestedID, patientsIDs, keyID, operation, expiry, metrics)
+}
+
+// storeNewProposal stores a new proposal unless it duplicates a recent one, indexes it, and
+// notifies the requested org it awaits its approval
+func (s *SimpleContract) storeNewProposal(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal) error {
+	if err := checkDuplicate(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	if err := indexProposalOrgs(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	if err := indexAuditRecord(ctx, AuditRecordProposal, id); err != nil {
+		return err
+	}
+
+	if err := notify(ctx, proposal.RequestedID, NotificationProposalAwaitingApproval, id, fmt.Sprintf("%s requests your approval of %s", proposal.RequesterID, id)); err != nil {
+		return err
+	}
 
-	return ctx.GetStub().PutState(id, patientAsBytes)
+	if err := emitEvent(ctx, EventProposalCreated, &ProposalEvent{ProposalID: id, Status: proposal.Status}); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
 }
 
-// CreateProposal ...
-func (s *SimpleContract) CreateProposal(ctx contractapi.TransactionContextInterface, id string, requesterID string, requestedID string, patientsIDs string, keyID string, modulo string) error {
-	var ms []string
+// newProposal returns a pending proposal of the protocol's principal investigator after
+// validating its terms. Without metrics the proposal computes over DefaultMetric.
+func (s *SimpleContract) newProposal(ctx contractapi.TransactionContextInterface, id string, protocolID string, requesterID string, requestedID string, patientsIDs string, keyID string, operation string, expiry string, metrics []string) (*Proposal, error) {
+	if err := checkIDFormat(ctx, EntityProposal, id); err != nil {
+		return nil, err
+	}
+
+	if err := checkIDUnused(ctx, id); err != nil {
+		return nil, err
+	}
+
+	protocol, err := s.validateProposalTerms(ctx, protocolID, patientsIDs, operation, metrics, expiry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if requesterID != protocol.PrincipalInvestigatorMSP {
+		return nil, newError(CodePermissionDenied, map[string]string{"protocolID": protocolID, "requesterID": requesterID}, "Proposals under %s are requested by %s", protocolID, protocol.PrincipalInvestigatorMSP)
+	}
+
+	scopeMetrics := metrics
+
+	if len(scopeMetrics) == 0 {
+		scopeMetrics = []string{DefaultMetric}
+	}
+
+	if err := checkProtocolMetrics(protocolID, protocol, scopeMetrics); err != nil {
+		return nil, err
+	}
+
+	if err := checkKeyUsable(ctx, keyID); err != nil {
+		return nil, err
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return nil, err
+	}
 
 	proposal := Proposal{
+		ProtocolID:  protocolID,
 		RequesterID: requesterID,
 		RequestedID: requestedID,
 		PatientsIDs: patientsIDs,
 		KeyID:       keyID,
+		Operation:   operation,
+		Expiry:      expiry,
+		Status:      ProposalPending,
+		Version:     1,
+		Metrics:     metrics,
+		Purpose:     PurposeResearch,
+		Metadata:    metadata,
+	}
+
+	proposal.NoiseRequired, err = checkAgreementTerms(ctx, id, &proposal)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := awaitResponse(ctx, id, &proposal); err != nil {
+		return nil, err
+	}
+
+	return &proposal, nil
+}
+
+// validateProposalTerms checks the cohort, operation, metrics and expiry of a proposal
+// against its study protocol and returns the protocol
+func (s *SimpleContract) validateProposalTerms(ctx contractapi.TransactionContextInterface, protocolID string, patientsIDs string, operation string, metrics []string, expiry string) (*StudyProtocol, error) {
+	if err := checkOperationMetrics(operation, metrics); err != nil {
+		return nil, err
+	}
+
+	if expiry != "" {
+		if _, err := parseTimestamp("expiry", expiry); err != nil {
+			return nil, err
+		}
+	}
+
+	protocol, err := s.FindStudyProtocol(ctx, protocolID)
+
+	if err != nil {
+		return nil, err
 	}
 
 	// Split patients' ids
-	pids := strings.Split(proposal.PatientsIDs, ",")
+	pids := strings.Split(patientsIDs, ",")
+	patients := []*Patient{}
 
-	// Get all patients' values
 	for _, pid := range pids {
-		patient, err := s.FindPatient(ctx, pid)
+		patient, err := findPatient(ctx, pid)
 
 		if err != nil {
+			return nil, err
+		}
+
+		if err := checkNotFrozen(pid, patient); err != nil {
+			return nil, err
+		}
+
+		patients = append(patients, patient)
+	}
+
+	// The diagnoses of the scope cover the codes below them in the taxonomy
+	scoped := *protocol
+	scoped.Scope.DiagnosisIDs, err = expandDiagnoses(ctx, protocol.Scope.DiagnosisIDs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkProtocolScope(protocolID, &scoped, operation, patients); err != nil {
+		return nil, err
+	}
+
+	return protocol, nil
+}
+
+// ApproveProposal approves a pending proposal by an admin or a current delegate of the requested org
+func (s *SimpleContract) ApproveProposal(ctx contractapi.TransactionContextInterface, id string) error {
+	return s.approveProposal(ctx, id, nil)
+}
+
+// approveProposal approves a pending proposal, storing the reason of the approver if any
+func (s *SimpleContract) approveProposal(ctx contractapi.TransactionContextInterface, id string, reason *DecisionReason) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalPending {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not pending", id)
+	}
+
+	if err := requireApprover(ctx, proposal.RequestedID, id); err != nil {
+		return err
+	}
+
+	approval, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	proposal.Approvals = append(proposal.Approvals, approval)
+	proposal.Status = ProposalApproved
+	proposal.Reason = reason
+	proposal.Metadata.Updated = approval
+
+	if err := checkQuota(ctx, id, proposal, false); err != nil {
+		return err
+	}
+
+	if err := checkDefinitions(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	if err := lockCohort(ctx, id, proposal); err != nil {
+		return err
+	}
+
+	event := &ProposalEvent{ProposalID: id, Status: proposal.Status}
+
+	if reason != nil {
+		event.ReasonCode, event.ReasonText = reason.Code, reason.Text
+
+		if err := notify(ctx, proposal.RequesterID, NotificationProposalApproved, id, fmt.Sprintf("%s approved %s. %s", proposal.RequestedID, id, reason.message())); err != nil {
 			return err
 		}
+	}
+
+	if err := emitEvent(ctx, EventProposalApproved, event); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
+
+// findExecutableProposal returns a proposal that is approved and not expired
+func (s *SimpleContract) findExecutableProposal(ctx contractapi.TransactionContextInterface, id string) (*Proposal, error) {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
 
-		ms = append(ms, patient.PreExistingConditions)
+	if proposal.Status != ProposalApproved {
+		return nil, newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not approved", id)
 	}
 
-	// Calculate average
-	m := phe.MeanFromString(modulo, ms)
+	if proposal.Expiry != "" {
+		now, err := txTime(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if timeutil.Expired(proposal.Expiry, now) {
+			return nil, newError(CodeInvalidState, map[string]string{"id": id, "expiry": proposal.Expiry}, "%s expired at %s", id, proposal.Expiry)
+		}
+	}
+
+	if proposal.NoiseRequired && proposal.Noise == "" {
+		return nil, newError(CodeInvalidState, map[string]string{"id": id}, "%s awaits the noise of %s", id, proposal.RequestedID)
+	}
+
+	if err := checkDefinitions(ctx, id, proposal); err != nil {
+		return nil, err
+	}
+
+	return proposal, nil
+}
+
+// ExecuteProposal computes the operation of an approved proposal over the encrypted values of
+// its cohort, once every patient consented and proved its values, and stores the encrypted
+// value on the proposal
+func (s *SimpleContract) ExecuteProposal(ctx contractapi.TransactionContextInterface, id string, modulo string) error {
+	proposal, err := s.findExecutableProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	// Split patients' ids
+	pids := strings.Split(proposal.PatientsIDs, ",")
+
+	if err := checkCohortLimit(ctx, id, len(pids)); err != nil {
+		return err
+	}
+
+	pids, skipped, err := s.skipIncomplete(ctx, proposal, pids)
+
+	if err != nil {
+		return err
+	}
+
+	if len(pids) == 0 {
+		return newError(CodeInvalidState, map[string]string{"id": id}, "Every patient of %s was skipped as incomplete", id)
+	}
+
+	proposal.Skipped = append(proposal.Skipped, skipped...)
+
+	if err := s.authorizeCohort(ctx, id, proposal, pids); err != nil {
+		return err
+	}
+
+	if err := s.checkCohortProofs(ctx, proposal, pids); err != nil {
+		return err
+	}
+
+	if proposal.MultiKey {
+		return s.executePartials(ctx, id, proposal, pids, modulo)
+	}
+
+	if len(proposal.ResultIDs) > 0 {
+		proposal.Value, err = s.combineResults(ctx, proposal, modulo)
+
+		if err != nil {
+			return err
+		}
+	} else if proposal.Operation == OperationSum {
+		proposal.Value, err = s.outlierSum(ctx, id, proposal, pids, modulo)
+
+		if err != nil {
+			return err
+		}
+	} else if proposal.Operation == OperationGroupMean {
+		proposal.Values, proposal.GroupSizes, err = s.groupMeans(ctx, proposal, pids, modulo)
+
+		if err != nil {
+			return err
+		}
+	} else if proposal.Operation == OperationDistinctDiagnoses {
+		proposal.Values, proposal.DistinctDiagnoses, err = s.diagnosisCounts(ctx, proposal, pids, modulo)
+
+		if err != nil {
+			return err
+		}
+	} else if proposal.Operation != OperationMean {
+		proposal.Value, err = s.count(ctx, proposal, pids, modulo)
+
+		if err != nil {
+			return err
+		}
+	} else if len(proposal.Metrics) == 0 {
+		ms, err := s.cohortValues(ctx, pids, "", proposal.AsOf)
+
+		if err != nil {
+			return err
+		}
+
+		q, err := parseModulus(modulo)
+
+		if err != nil {
+			return err
+		}
+
+		// Calculate average
+		proposal.Value, err = mean(q, pids, ms)
+
+		if err != nil {
+			return err
+		}
+	} else {
+		q, err := parseModulus(modulo)
+
+		if err != nil {
+			return err
+		}
+
+		proposal.Values = map[string]string{}
+
+		for _, metric := range proposal.Metrics {
+			ms, err := s.cohortValues(ctx, pids, metric, proposal.AsOf)
+
+			if err != nil {
+				return err
+			}
+
+			proposal.Values[metric], err = mean(q, pids, ms)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return completeExecution(ctx, id, proposal, pids, modulo)
+}
+
+// completeExecution accounts for the execution of a proposal whose values are computed, adds
+// the noise of the custodian to its value, releases its cohort and saves it
+func completeExecution(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal, pids []string, modulo string) error {
+	if err := addNoise(proposal, modulo); err != nil {
+		return err
+	}
+
+	if err := accountKeyUsage(ctx, proposal.KeyID, 1, 0); err != nil {
+		return err
+	}
+
+	if err := checkQuota(ctx, id, proposal, true); err != nil {
+		return err
+	}
+
+	if err := countUsage(ctx, counterProposalsExecuted, 1); err != nil {
+		return err
+	}
+
+	if err := countUsage(ctx, counterCohortPatients, len(pids)); err != nil {
+		return err
+	}
+
+	// The values are computed, the cohort can change again
+	if err := unlockCohort(ctx, id, proposal); err != nil {
+		return err
+	}
 
 	// Save proposal
-	proposal.Value = m
+	proposal.Status = ProposalExecuted
+
+	if err := proposal.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	if err := emitEvent(ctx, EventProposalExecuted, &ProposalEvent{ProposalID: id, Status: proposal.Status}); err != nil {
+		return err
+	}
+
 	proposalAsBytes, _ := json.Marshal(proposal)
 
 	return ctx.GetStub().PutState(id, proposalAsBytes)
 }
 
-// FindProposal ...
+// FindProposal returns a proposal
 func (s *SimpleContract) FindProposal(ctx contractapi.TransactionContextInterface, id string) (*Proposal, error) {
 	proposalAsBytes, err := ctx.GetStub().GetState(id)
 
@@ -174,7 +746,7 @@ func (s *SimpleContract) FindProposal(ctx contractapi.TransactionContextInterfac
 	}
 
 	if proposalAsBytes == nil {
-		return nil, fmt.Errorf("%s does not exist", id)
+		return nil, errNotFound(id)
 	}
 
 	proposal := new(Proposal)
@@ -183,7 +755,21 @@ func (s *SimpleContract) FindProposal(ctx contractapi.TransactionContextInterfac
 	return proposal, nil
 }
 
-// CreateResult ...
+// resultID returns the ID of the result of a proposal, derived from the whole proposal ID so
+// no two proposals share one
+func resultID(proposalID string) string {
+	return "RESULT-" + proposalID
+}
+
+// legacyResultID returns the ID a result of a proposal took before result IDs were derived
+// from the whole proposal ID, numbered after the first digits of the proposal ID
+func legacyResultID(prefix string, proposalID string) string {
+	return prefix + regexp.MustCompile(`[0-9]+`).FindString(proposalID)
+}
+
+// CreateResult releases the value of an executed single-valued proposal to its requester,
+// moving it to the key of the requester with the tokens of the requested org, with its
+// manifest and computation receipt
 func (s *SimpleContract) CreateResult(ctx contractapi.TransactionContextInterface, proposalID string, firstToken string, secondToken string, keyID string, modulo string) error {
 	proposal, err := s.FindProposal(ctx, proposalID)
 
@@ -191,25 +777,152 @@ func (s *SimpleContract) CreateResult(ctx contractapi.TransactionContextInterfac
 		return err
 	}
 
-	newValue := phe.KeyUpdateFromString(modulo, firstToken, secondToken, proposal.Value)
+	if proposal.Status != ProposalExecuted {
+		return newError(CodeInvalidState, map[string]string{"id": proposalID, "status": proposal.Status}, "%s has not been executed", proposalID)
+	}
+
+	if proposal.multiValued() {
+		return newError(CodeInvalidArgument, map[string]string{"id": proposalID}, "%s has several values, its result is a result set", proposalID)
+	}
+
+	if err := checkExclusionsAcknowledged(proposalID, proposal); err != nil {
+		return err
+	}
+
+	id := resultID(proposalID)
+
+	if err := checkUnreleased(ctx, proposalID, proposal); err != nil {
+		return err
+	}
+
+	if err := checkKeyUsable(ctx, keyID); err != nil {
+		return err
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return err
+	}
+
+	token, err := parseToken(firstToken, secondToken)
+
+	if err != nil {
+		return err
+	}
+
+	value, err := parseCiphertext(proposalID, proposal.Value)
+
+	if err != nil {
+		return err
+	}
+
+	newValue := q.KeyUpdate(token, value).String()
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	manifest, err := s.newManifest(ctx, proposalID, proposal)
+
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := registeredFingerprint(ctx, keyID)
+
+	if err != nil {
+		return err
+	}
 
 	result := Result{
-		ProposalID: proposalID,
-		KeyID:      keyID,
-		Value:      newValue,
+		ProposalID:     proposalID,
+		KeyID:          keyID,
+		KeyFingerprint: fingerprint,
+		Value:          newValue,
+		Manifest:       manifest,
+		Metadata:       metadata,
+	}
+
+	result.ReceiptID, err = s.putComputationReceipt(ctx, id, proposalID, proposal, keyID, modulo, outputHash(result.Value, nil))
+
+	if err != nil {
+		return err
+	}
+
+	if err := accountKeyUsage(ctx, keyID, 1, 1); err != nil {
+		return err
 	}
 
-	// Get the number out of proposal ID
-	re := regexp.MustCompile(`[0-9]+`)
-	idNumber := string(re.Find([]byte(proposalID)))
-	id := "RESULT" + idNumber
+	if err := indexResultKey(ctx, keyID, id); err != nil {
+		return err
+	}
+
+	if err := putIndexEntry(ctx, resultOrgIndex, proposal.RequesterID, id); err != nil {
+		return err
+	}
+
+	if err := indexAuditRecord(ctx, AuditRecordResult, id); err != nil {
+		return err
+	}
+
+	if err := notify(ctx, proposal.RequesterID, NotificationResultReleased, id, fmt.Sprintf("%s of %s has been released", id, proposalID)); err != nil {
+		return err
+	}
+
+	if err := emitEvent(ctx, EventResultReleased, &ResultReleasedEvent{ResultID: id, ProposalID: proposalID}); err != nil {
+		return err
+	}
+
+	if err := markReleased(ctx, proposalID, proposal); err != nil {
+		return err
+	}
 
 	resultAsBytes, _ := json.Marshal(result)
 
 	return ctx.GetStub().PutState(id, resultAsBytes)
 }
 
-// FindResult ...
+// checkUnreleased refuses to release the result of a proposal twice, which would overwrite
+// it and account its key once more. Proposals released before they were marked are told by
+// their result.
+func checkUnreleased(ctx contractapi.TransactionContextInterface, proposalID string, proposal *Proposal) error {
+	if proposal.Released != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": proposalID, "released": proposal.Released.Timestamp}, "The result of %s was already released", proposalID)
+	}
+
+	released, err := releasedResultID(ctx, proposalID)
+
+	if err != nil {
+		return err
+	}
+
+	if released != "" {
+		return newError(CodeAlreadyExists, map[string]string{"id": proposalID, "resultID": released}, "%s already exists", released)
+	}
+
+	return nil
+}
+
+// markReleased records on a proposal that its result was released. Its metadata is left as
+// updated by the execution, which the lineage of the result reports.
+func markReleased(ctx contractapi.TransactionContextInterface, proposalID string, proposal *Proposal) error {
+	released, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	proposal.Released = &released
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(proposalID, proposalAsBytes)
+}
+
+// FindResult returns a result, refusing orgs other than the requester and the custodian while it is under embargo
 func (s *SimpleContract) FindResult(ctx contractapi.TransactionContextInterface, id string) (*Result, error) {
 	resultAsBytes, err := ctx.GetStub().GetState(id)
 
@@ -218,11 +931,63 @@ func (s *SimpleContract) FindResult(ctx contractapi.TransactionContextInterface,
 	}
 
 	if resultAsBytes == nil {
-		return nil, fmt.Errorf("%s does not exist", id)
+		return nil, errNotFound(id)
 	}
 
 	result := new(Result)
 	_ = json.Unmarshal(resultAsBytes, result)
 
+	if err := s.checkEmbargo(ctx, id, result); err != nil {
+		return nil, err
+	}
+
 	return result, nil
 }
+
+// PatientInput describes a patient to be created by CreatePatientsBatch
+type PatientInput struct {
+	ID                    string `json:"id"`
+	Name                  string `json:"name"`
+	PreExistingConditions string `json:"preExistingConditions"`
+	DiagnosisID           string `json:"diagnosisID"`
+	StatusID              string `json:"statusID"`
+	KeyID                 string `json:"keyID"`
+}
+
+// CreatePatientsBatch creates several patients in one transaction as CreatePatient does,
+// failing them all with the ID of the first patient refused
+func (s *SimpleContract) CreatePatientsBatch(ctx contractapi.TransactionContextInterface, patients []PatientInput) error {
+	patientsAsBytes, _ := json.Marshal(patients)
+
+	if err := validatePayload(payloadschema.Patients, patientsAsBytes); err != nil {
+		return err
+	}
+
+	if done, err := beginRequest(ctx, "CreatePatientsBatch", patients); err != nil || done {
+		return err
+	}
+
+	// Keys are accounted for once per batch, as the writes of the transaction can't be read back
+	records := map[string]int{}
+	keyIDs := []string{}
+
+	for _, p := range patients {
+		if err := s.putPatient(ctx, p.ID, p.Name, p.PreExistingConditions, p.DiagnosisID, p.StatusID, p.KeyID); err != nil {
+			return withDetail(err, "patientID", p.ID)
+		}
+
+		if records[p.KeyID] == 0 {
+			keyIDs = append(keyIDs, p.KeyID)
+		}
+
+		records[p.KeyID]++
+	}
+
+	for _, keyID := range keyIDs {
+		if err := accountKeyUsage(ctx, keyID, 0, records[keyID]); err != nil {
+			return err
+		}
+	}
+
+	return countUsage(ctx, counterPatientsCreated, len(patients))
+}
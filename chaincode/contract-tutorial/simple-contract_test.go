@@ -0,0 +1,6869 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/metadocs"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phefake"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hanesbarbosa/phe"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	"github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/leanovate/gopter"
+	"github.com/leanovate/gopter/gen"
+	"github.com/leanovate/gopter/prop"
+)
+
+// mockIdentity is a client identity with a fixed id, MSP and attributes. Anonymous
+// identities have no ID, as Idemix ones.
+type mockIdentity struct {
+	id        string
+	mspID     string
+	attrs     map[string]string
+	cert      *x509.Certificate
+	anonymous bool
+}
+
+func (m *mockIdentity) GetID() (string, error) {
+	if m.anonymous {
+		return "", fmt.Errorf("cannot determine identity")
+	}
+
+	return m.id, nil
+}
+
+func (m *mockIdentity) GetMSPID() (string, error) {
+	return m.mspID, nil
+}
+
+func (m *mockIdentity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := m.attrs[attrName]
+	return value, found, nil
+}
+
+func (m *mockIdentity) AssertAttributeValue(attrName, attrValue string) error {
+	if m.attrs[attrName] != attrValue {
+		return fmt.Errorf("attribute %s is not %s", attrName, attrValue)
+	}
+
+	return nil
+}
+
+func (m *mockIdentity) GetX509Certificate() (*x509.Certificate, error) {
+	return m.cert, nil
+}
+
+// cloneKey copies a secret key, since phe alters the keys it derives tokens or decrypts with
+func cloneKey(sk *phe.SecretKey) *phe.SecretKey {
+	return &phe.SecretKey{K1: phe.CloneMultivector(sk.K1), K2: phe.CloneMultivector(sk.K2), G: new(big.Int).Set(sk.G)}
+}
+
+// newContext returns a transaction context over the stub for the given identity
+func newContext(stub *shimtest.MockStub, id string, mspID string, attrs map[string]string) *contractapi.TransactionContext {
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(&pagingStub{MockStub: stub})
+	ctx.SetClientIdentity(&mockIdentity{id: id, mspID: mspID, attrs: attrs})
+
+	return ctx
+}
+
+// pagingStub adds the paginated queries and the transient data the mock stub lacks,
+// bookmarks being the key of the first record of the next page
+type pagingStub struct {
+	*shimtest.MockStub
+	transient map[string][]byte
+	history   map[string][]*queryresult.KeyModification
+}
+
+func (p *pagingStub) GetTransient() (map[string][]byte, error) {
+	return p.transient, nil
+}
+
+// sliceIterator iterates over the records of a page
+type sliceIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *sliceIterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *sliceIterator) Next() (*queryresult.KV, error) {
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+
+	return kv, nil
+}
+
+func (it *sliceIterator) Close() error {
+	return nil
+}
+
+// page reads a page of pageSize records from the bookmark
+func (p *pagingStub) page(it shim.StateQueryIteratorInterface, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	defer it.Close()
+
+	page := &sliceIterator{}
+	next := ""
+
+	for it.HasNext() {
+		kv, err := it.Next()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if kv.Key < bookmark {
+			continue
+		}
+
+		if int32(len(page.kvs)) == pageSize {
+			next = kv.Key
+			break
+		}
+
+		page.kvs = append(page.kvs, kv)
+	}
+
+	return page, &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(page.kvs)), Bookmark: next}, nil
+}
+
+func (p *pagingStub) GetStateByRangeWithPagination(startKey string, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	it, err := p.GetStateByRange(startKey, endKey)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p.page(it, pageSize, bookmark)
+}
+
+func (p *pagingStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	it, err := p.GetStateByPartialCompositeKey(objectType, keys)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p.page(it, pageSize, bookmark)
+}
+
+// GetPrivateDataByRange lists the simple keys of a collection in order, as the peer does
+func (p *pagingStub) GetPrivateDataByRange(collection string, startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	keys := []string{}
+
+	for key := range p.PvtState[collection] {
+		if !strings.HasPrefix(key, "\x00") && key >= startKey && (endKey == "" || key < endKey) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	it := &sliceIterator{}
+
+	for _, key := range keys {
+		it.kvs = append(it.kvs, &queryresult.KV{Key: key, Value: p.PvtState[collection][key]})
+	}
+
+	return it, nil
+}
+
+func (p *pagingStub) DelPrivateData(collection string, key string) error {
+	delete(p.PvtState[collection], key)
+
+	return nil
+}
+
+// historyIterator iterates over the modifications of a key
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool {
+	return len(it.modifications) > 0
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+
+	return modification, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
+
+func (p *pagingStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &historyIterator{modifications: p.history[key]}, nil
+}
+
+// newStub returns a mock stub for the contract
+func newStub(t *testing.T) *shimtest.MockStub {
+	cc, err := contractapi.NewChaincode(new(SimpleContract))
+
+	if err != nil {
+		t.Fatalf("Failed to create chaincode. %s", err.Error())
+	}
+
+	return shimtest.NewMockStub("contract-tutorial", cc)
+}
+
+// grantConsents grants the consent of every patient to the grantee for research
+func grantConsents(tb testing.TB, s *SimpleContract, ctx contractapi.TransactionContextInterface, granteeMSP string, pids ...string) {
+	consents := []ConsentInput{}
+
+	for _, pid := range pids {
+		consents = append(consents, ConsentInput{ID: "CONSENT-" + pid, PatientID: pid, GranteeMSP: granteeMSP, Terms: "terms", Purposes: PurposeResearch})
+	}
+
+	if err := s.GrantConsentsBatch(ctx, consents); err != nil {
+		tb.Fatalf("GrantConsentsBatch failed. %s", err.Error())
+	}
+}
+
+func TestGetTransactionDetails(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	ctx := newContext(stub, "creator", "Org1MSP", nil)
+	if err := s.CreatePatient(ctx, "PATIENT0", "Alice", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	ctx = newContext(stub, "updater", "Org2MSP", nil)
+	if err := s.UpdatePatient(ctx, "PATIENT0", "Alice", "1", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("UpdatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	provenance, err := s.GetTransactionDetails(ctx, "PATIENT0")
+
+	if err != nil {
+		t.Fatalf("GetTransactionDetails failed. %s", err.Error())
+	}
+
+	if provenance.Created.TxID != "tx1" || provenance.Created.ClientID != "creator" || provenance.Created.MSPID != "Org1MSP" {
+		t.Errorf("Unexpected creating transaction %+v", provenance.Created)
+	}
+
+	if provenance.LastUpdated.TxID != "tx2" || provenance.LastUpdated.ClientID != "updater" || provenance.LastUpdated.MSPID != "Org2MSP" {
+		t.Errorf("Unexpected updating transaction %+v", provenance.LastUpdated)
+	}
+
+	_, err = s.GetTransactionDetails(ctx, "PATIENT1")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeNotFound || contractError.Details["id"] != "PATIENT1" {
+		t.Errorf("Expected a not found error for a missing record, got %v", err)
+	}
+}
+
+func TestAmendProposal(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	ctx := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(ctx, id, "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(requester, "PROPOSAL0"); err == nil {
+		t.Error("Expected the requester to be unable to approve")
+	}
+	if err := s.ApproveProposal(ctx, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.AmendProposal(ctx, "PROPOSAL0", "PATIENT0,PATIENT1", OperationMean, ""); err == nil {
+		t.Error("Expected the custodian to be unable to amend")
+	}
+	if err := s.AmendProposal(requester, "PROPOSAL0", "PATIENT0,PATIENT1", OperationMean, ""); err != nil {
+		t.Fatalf("AmendProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, _ := s.FindProposal(ctx, "PROPOSAL0")
+
+	if proposal.Status != ProposalPending || proposal.Version != 2 || len(proposal.Approvals) != 0 {
+		t.Errorf("Unexpected amended proposal %+v", proposal)
+	}
+
+	page, err := s.GetProposalVersions(ctx, "PROPOSAL0", 0, "")
+
+	if err != nil {
+		t.Fatalf("GetProposalVersions failed. %s", err.Error())
+	}
+
+	versions := page.Records
+
+	if len(versions) != 2 || versions[0].PatientsIDs != "PATIENT0" || versions[0].Status != ProposalApproved || versions[1].PatientsIDs != "PATIENT0,PATIENT1" {
+		t.Errorf("Unexpected versions %+v", versions)
+	}
+}
+
+func TestCounterPropose(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	member := newContext(stub, "nurse", "Org2MSP", nil)
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err, ok := s.CounterPropose(member, "PROPOSAL0", "PATIENT0", OperationMean, "", "smaller cohort").(*ContractError); !ok || err.Code != CodePermissionDenied {
+		t.Errorf("Expected a member of the custodian that can't approve to be unable to counter, got %v", err)
+	}
+	if err := s.CounterPropose(custodian, "PROPOSAL0", "PATIENT0", OperationMean, "", "smaller cohort"); err != nil {
+		t.Fatalf("CounterPropose failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err == nil {
+		t.Error("Expected a countered proposal to need the requester's answer")
+	}
+	if err := s.AnswerCounterProposal(custodian, "PROPOSAL0", true); err == nil {
+		t.Error("Expected the custodian to be unable to answer its own counter-proposal")
+	}
+	if err := s.AnswerCounterProposal(requester, "PROPOSAL0", true); err != nil {
+		t.Fatalf("AnswerCounterProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, _ := s.FindProposal(custodian, "PROPOSAL0")
+
+	if proposal.Status != ProposalApproved || proposal.PatientsIDs != "PATIENT0" || proposal.Version != 2 {
+		t.Errorf("Unexpected proposal %+v", proposal)
+	}
+
+	if len(proposal.Negotiation) != 1 || proposal.Negotiation[0].Status != CounterAccepted {
+		t.Errorf("Unexpected negotiation %+v", proposal.Negotiation)
+	}
+}
+
+func TestMultiMetricProposal(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	encrypt := func(m int64) string {
+		return phe.Encrypt(sk, pk, big.NewInt(m)).ToString()
+	}
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", encrypt(0), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd("tx1")
+		stub.MockTransactionStart("tx1")
+		if err := s.SetPatientMeasurement(custodian, id, "bmi", encrypt(int64(20+i*4))); err != nil {
+			t.Fatalf("SetPatientMeasurement failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd("tx1")
+		stub.MockTransactionStart("tx1")
+		if err := s.SetPatientMeasurement(custodian, id, "glucose", encrypt(int64(90+i*10))); err != nil {
+			t.Fatalf("SetPatientMeasurement failed. %s", err.Error())
+		}
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.CreateMultiMetricProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY1", "bmi,glucose", ""); err != nil {
+		t.Fatalf("CreateMultiMetricProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err == nil {
+		t.Error("Expected CreateResult to refuse a multi-metric proposal")
+	}
+	if err := s.CreateResultSet(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResultSet failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	resultSet, err := s.FindResultSet(requester, "RESULTSET-PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("FindResultSet failed. %s", err.Error())
+	}
+
+	if resultSet.Manifest.CohortSize != 2 || len(resultSet.Values) != 2 {
+		t.Errorf("Unexpected result set %+v", resultSet)
+	}
+
+	for metric, expected := range map[string]int64{"bmi": 22, "glucose": 95} {
+		m := phe.Decrypt(cloneKey(requesterSK), pk, phe.StringToMultivector(resultSet.Values[metric]))
+
+		if m.Cmp(big.NewRat(expected, 1)) != 0 {
+			t.Errorf("Expected mean %s of %d, got %s", metric, expected, m.String())
+		}
+	}
+
+	receipt, err := s.FindComputationReceipt(requester, resultSet.ReceiptID)
+
+	if err != nil {
+		t.Fatalf("FindComputationReceipt failed. %s", err.Error())
+	}
+
+	if resultSet.ReceiptID != "COMPUTATION-PROPOSAL0" || receipt.ProposalID != "PROPOSAL0" {
+		t.Errorf("Expected the receipt of PROPOSAL0 under its whole ID, got %s %+v", resultSet.ReceiptID, receipt)
+	}
+
+	if len(receipt.Inputs) != 2 || len(receipt.Inputs[0].Hashes) != 2 {
+		t.Errorf("Expected the hashes of 2 metrics of 2 patients, got %+v", receipt.Inputs)
+	}
+
+	if err := s.VerifyComputationReceipt(requester, resultSet.ReceiptID); err != nil {
+		t.Errorf("VerifyComputationReceipt failed. %s", err.Error())
+	}
+}
+
+func TestRebalanceShards(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	ctx := newContext(stub, "clinician", "Org2MSP", nil)
+	for i := 0; i < 50; i++ {
+		if err := s.CreatePatient(ctx, fmt.Sprintf("PATIENT%d", i), "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.ResizeShards(ctx, 4); err == nil {
+		t.Errorf("Expected callers without the admin attribute to be refused")
+	}
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+	if err := s.ResizeShards(admin, 4); err != nil {
+		t.Fatalf("ResizeShards failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	for shard := 0; shard < defaultShardCount; shard++ {
+		for {
+			stub.MockTransactionStart("rebalance")
+			moved, err := s.RebalanceShard(admin, shard, 2)
+			stub.MockTransactionEnd("rebalance")
+
+			if err != nil {
+				t.Fatalf("RebalanceShard failed. %s", err.Error())
+			}
+
+			if config, _ := s.GetShardConfig(admin); moved < 2 || config.Target == 0 {
+				break
+			}
+		}
+	}
+
+	config, err := s.GetShardConfig(ctx)
+
+	if err != nil || config.Count != 4 || config.Target != 0 {
+		t.Fatalf("Expected the resize to complete, got %+v %v", config, err)
+	}
+
+	indexed := 0
+
+	for shard := 0; shard < config.Count; shard++ {
+		resultsIterator, _ := stub.GetStateByPartialCompositeKey(patientShardIndex, []string{shardName(shard)})
+		for resultsIterator.HasNext() {
+			queryResponse, _ := resultsIterator.Next()
+			_, attributes, _ := stub.SplitCompositeKey(queryResponse.Key)
+
+			if shardOf(attributes[1], 4) != shard {
+				t.Errorf("%s is in shard %d", attributes[1], shard)
+			}
+
+			indexed++
+		}
+		resultsIterator.Close()
+	}
+
+	if indexed != 50 {
+		t.Errorf("Expected 50 indexed patients, got %d", indexed)
+	}
+}
+
+func TestPatientsInShard(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+	other := newContext(stub, "clinician", "Org1MSP", nil)
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+
+	stub.MockTransactionStart("tx1")
+	for i := 0; i < 40; i++ {
+		ctx := custodian
+		if i%4 == 3 {
+			ctx = other
+		}
+		if err := s.CreatePatient(ctx, fmt.Sprintf("PATIENT%d", i), "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// scan reads every bucket page by page, and returns how many times each patient was read
+	scan := func() map[string]int {
+		t.Helper()
+
+		config, _ := s.GetShardConfig(custodian)
+		read := map[string]int{}
+		truncated := false
+
+		for shard := 0; shard < config.buckets(); shard++ {
+			bookmark := ""
+
+			for {
+				page, err := s.GetPatientsInShard(custodian, shard, 2, bookmark)
+
+				if err != nil {
+					t.Fatalf("GetPatientsInShard failed. %s", err.Error())
+				}
+				if len(page.Records) > 2 || page.Count > 2 {
+					t.Errorf("Expected pages of 2 entries, got %d records of %d entries", len(page.Records), page.Count)
+				}
+
+				for _, record := range page.Records {
+					read[record.Key]++
+				}
+
+				if !page.Truncated {
+					break
+				}
+
+				truncated = true
+				bookmark = page.Bookmark
+			}
+		}
+
+		if !truncated {
+			t.Errorf("Expected a bucket to span several pages")
+		}
+
+		return read
+	}
+
+	// expectCustodian checks the scan read the patients of Org2MSP once each, and no other
+	expectCustodian := func(read map[string]int, count int) {
+		t.Helper()
+
+		for i := 0; i < count; i++ {
+			id := fmt.Sprintf("PATIENT%d", i)
+			expected := 1
+
+			if i%4 == 3 {
+				expected = 0
+			}
+
+			if read[id] != expected {
+				t.Errorf("Expected %s to be read %d times, got %d", id, expected, read[id])
+			}
+		}
+
+		if len(read) != count-count/4 {
+			t.Errorf("Expected %d patients, got %d", count-count/4, len(read))
+		}
+	}
+
+	expectCustodian(scan(), 40)
+
+	for _, shard := range []int{-1, defaultShardCount} {
+		_, err := s.GetPatientsInShard(custodian, shard, 2, "")
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidArgument {
+			t.Errorf("Expected shard %d to be refused, got %v", shard, err)
+		}
+	}
+
+	// While a resize is in progress the patients are in their old bucket or their new one
+	stub.MockTransactionStart("tx2")
+	if err := s.ResizeShards(admin, 4); err != nil {
+		t.Fatalf("ResizeShards failed. %s", err.Error())
+	}
+	if err := s.CreatePatient(custodian, "PATIENT40", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	for shard := 0; shard < defaultShardCount/2; shard++ {
+		stub.MockTransactionStart("rebalance")
+		if _, err := s.RebalanceShard(admin, shard, 100); err != nil {
+			t.Fatalf("RebalanceShard failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd("rebalance")
+	}
+
+	if config, _ := s.GetShardConfig(custodian); config.Target != 4 {
+		t.Fatalf("Expected the resize to be in progress, got %+v", config)
+	}
+
+	expectCustodian(scan(), 41)
+
+	if _, err := s.GetPatientsInShard(custodian, defaultShardCount, 2, ""); err == nil {
+		t.Errorf("Expected a shard past the old buckets to be refused during the resize")
+	}
+}
+
+// newCertificate returns a PEM encoded certificate for key, signed by parent or self-signed
+func newCertificate(t *testing.T, template *x509.Certificate, parent *x509.Certificate, key *ecdsa.PrivateKey, parentKey *ecdsa.PrivateKey) (*x509.Certificate, string) {
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+
+	if err != nil {
+		t.Fatalf("Failed to create certificate. %s", err.Error())
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+
+	return cert, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestApproveProposalWithAttestation(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	adminKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	ca, caPEM := newCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ca.org2"}, NotBefore: notBefore, NotAfter: notAfter, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, caKey, nil)
+	_, adminPEM := newCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "Admin@org2", OrganizationalUnit: []string{adminOU}}, NotBefore: notBefore, NotAfter: notAfter, KeyUsage: x509.KeyUsageDigitalSignature}, ca, adminKey, caKey)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	if err := s.RegisterMSPRoot(custodian, "Org2MSP", caPEM); err != nil {
+		t.Fatalf("RegisterMSPRoot failed. %s", err.Error())
+	}
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	sign := func(message []byte) string {
+		digest := sha256.Sum256(message)
+		sig, _ := ecdsa.SignASN1(rand.Reader, adminKey, digest[:])
+
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.ApproveProposalWithAttestation(requester, "PROPOSAL0", adminPEM, sign(attestationMessage("PROPOSAL0", 2))); err == nil {
+		t.Errorf("Expected a signature over another version to be refused")
+	}
+	if err := s.ApproveProposalWithAttestation(requester, "PROPOSAL0", caPEM, sign(attestationMessage("PROPOSAL0", 1))); err == nil {
+		t.Errorf("Expected a certificate without the admin OU to be refused")
+	}
+	if err := s.ApproveProposalWithAttestation(requester, "PROPOSAL0", adminPEM, sign(attestationMessage("PROPOSAL0", 1))); err != nil {
+		t.Fatalf("ApproveProposalWithAttestation failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+
+	if proposal.Status != ProposalApproved || len(proposal.Approvals) != 1 || proposal.Approvals[0].MSPID != "Org2MSP" {
+		t.Errorf("Expected the proposal to be approved by Org2MSP, got %+v", proposal)
+	}
+}
+
+func TestMergeConsent(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	ctx := newContext(stub, "clinician", "Org2MSP", nil)
+	if err := s.CreatePatient(ctx, "PATIENT0", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.GrantConsent(ctx, "CONSENT0", "PATIENT0", "Org1MSP", "terms v1", PurposeResearch, ""); err != nil {
+		t.Fatalf("GrantConsent failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	decide := func(txID string, action string, terms string, purposes string) {
+		stub.MockTransactionStart(txID)
+		if err := s.RecordConsentDecision(ctx, "CONSENT0", action, terms, purposes); err != nil {
+			t.Fatalf("RecordConsentDecision failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(txID)
+	}
+
+	// The grants of the patient and the guardian race, the latest wins
+	decide("tx2", ConsentActionGrant, "terms v2", PurposeResearch)
+	decide("tx3", ConsentActionGrant, "terms v3", "RESEARCH,PUBLIC_HEALTH")
+	stub.MockTransactionStart("merge1")
+	consent, err := s.MergeConsent(ctx, "CONSENT0")
+	stub.MockTransactionEnd("merge1")
+
+	if err != nil || consent.Status != ConsentGranted || consent.TermsHash != hashString("terms v3") || !consent.covers(PurposePublicHealth, time.Now()) {
+		t.Errorf("Expected the latest grant to win, got %+v %v", consent, err)
+	}
+
+	// A revocation wins over a concurrent grant
+	decide("tx4", ConsentActionRevoke, "", "")
+	decide("tx5", ConsentActionGrant, "terms v4", PurposeResearch)
+	stub.MockTransactionStart("merge2")
+	consent, err = s.MergeConsent(ctx, "CONSENT0")
+	stub.MockTransactionEnd("merge2")
+
+	if err != nil || consent.Status != ConsentRevoked {
+		t.Errorf("Expected the revocation to win, got %+v %v", consent, err)
+	}
+
+	if _, err := s.MergeConsent(ctx, "CONSENT0"); err == nil {
+		t.Errorf("Expected merging without pending decisions to fail")
+	}
+}
+
+func TestStudyProtocolScope(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+	for i, diagnosisID := range []string{"D1", "D1", "D2"} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", "0", diagnosisID, "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "D1", "", 2); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	for _, patientsIDs := range []string{"PATIENT0,PATIENT2", "PATIENT0,PATIENT1,PATIENT0"} {
+		err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", patientsIDs, "KEY0", OperationMean, "")
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+			t.Errorf("Expected a cohort of %s beyond the protocol to be refused, got %v", patientsIDs, err)
+		}
+	}
+	if err := s.CreateProposal(custodian, "PROPOSAL0", "PROTOCOL0", "Org2MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err == nil {
+		t.Errorf("Expected a proposal of another org than the principal investigator's to be refused")
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Errorf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+}
+
+func TestCountProposal(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	encrypt := func(m int64) string {
+		return phe.Encrypt(sk, pk, big.NewInt(m)).ToString()
+	}
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	flags := []map[string]int64{
+		{"smoker": 1, "vaccinated": 1, conjunction("smoker", "vaccinated"): 1},
+		{"smoker": 1, "vaccinated": 0, conjunction("smoker", "vaccinated"): 0},
+		{"smoker": 0, "vaccinated": 1, conjunction("smoker", "vaccinated"): 0},
+		{"smoker": 0, "vaccinated": 0, conjunction("smoker", "vaccinated"): 0},
+	}
+	for i, patientFlags := range flags {
+		id := fmt.Sprintf("PATIENT%d", i)
+		if err := s.CreatePatient(custodian, id, "Name", encrypt(0), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+		for flag, value := range patientFlags {
+			if err := s.SetPatientMeasurement(custodian, id, flag, encrypt(value)); err != nil {
+				t.Fatalf("SetPatientMeasurement failed. %s", err.Error())
+			}
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2", "PATIENT3")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", "COUNT,AND,OR", "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	for i, c := range []struct {
+		operation string
+		flags     string
+		expected  int64
+	}{
+		{OperationCount, "smoker", 2},
+		{OperationAnd, "smoker,vaccinated", 1},
+		{OperationOr, "smoker,vaccinated", 3},
+	} {
+		id := fmt.Sprintf("PROPOSAL%d", i)
+		stub.MockTransactionStart(id)
+		if err := s.CreateCountProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2,PATIENT3", "KEY0", c.operation, c.flags, ""); err != nil {
+			t.Fatalf("CreateCountProposal failed. %s", err.Error())
+		}
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+		if err := s.ExecuteProposal(requester, id, pk.Q.String()); err != nil {
+			t.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(id)
+
+		proposal, _ := s.FindProposal(requester, id)
+		m := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Value))
+
+		if m.Cmp(big.NewRat(c.expected, 1)) != 0 {
+			t.Errorf("Expected %s over %s of %d, got %s", c.operation, c.flags, c.expected, m.String())
+		}
+	}
+
+	if err := s.CreateCountProposal(requester, "PROPOSAL9", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationAnd, "smoker", ""); err == nil {
+		t.Errorf("Expected AND over a single flag to be refused")
+	}
+}
+
+func TestGetEventsSince(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	events, err := s.GetEventsSince(requester, "", 10)
+
+	if err != nil {
+		t.Fatalf("GetEventsSince failed. %s", err.Error())
+	}
+
+	if len(events) != 2 || events[0].Name != EventProposalCreated || events[0].TxID != "tx2" || events[1].Name != EventProposalApproved || events[1].Position <= events[0].Position {
+		t.Fatalf("Expected the creation then the approval of PROPOSAL0, got %v", events)
+	}
+
+	events, _ = s.GetEventsSince(requester, events[0].Position, 10)
+
+	if len(events) != 1 || events[0].Name != EventProposalApproved {
+		t.Errorf("Expected only the approval after the creation, got %v", events)
+	}
+
+	// The events of a transaction are journaled under positions of its own, in the order emitted
+	ctx := new(CachingContext)
+	ctx.SetStub(&pagingStub{MockStub: stub})
+	ctx.SetClientIdentity(&mockIdentity{id: "clinician", mspID: "Org2MSP"})
+
+	stub.MockTransactionStart("tx4")
+	for _, pid := range []string{"PATIENT0", "PATIENT1"} {
+		if err := emitEvent(ctx, EventPatientUpdated, &PatientUpdatedEvent{PatientID: pid}); err != nil {
+			t.Fatalf("emitEvent failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx4")
+
+	if value, _ := stub.GetState(eventSequenceKey); value != nil {
+		t.Errorf("Expected no shared sequence to be written, got %s", value)
+	}
+
+	events, _ = s.GetEventsSince(requester, events[0].Position, 10)
+
+	if len(events) != 2 || events[0].TxID != "tx4" || events[1].TxID != "tx4" || events[0].Position >= events[1].Position ||
+		!strings.Contains(events[0].Payload, "PATIENT0") || !strings.Contains(events[1].Payload, "PATIENT1") {
+		t.Errorf("Expected both updates of tx4 in order, got %v", events)
+	}
+}
+
+func TestGetEventSchemas(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	schemas, err := s.GetEventSchemas(requester)
+
+	if err != nil {
+		t.Fatalf("GetEventSchemas failed. %s", err.Error())
+	}
+
+	if len(schemas) != len(eventTypes) {
+		t.Fatalf("Expected a schema for each of the %d events, got %d", len(eventTypes), len(schemas))
+	}
+
+	for _, schema := range schemas {
+		parsed := struct {
+			Title      string                     `json:"title"`
+			Properties map[string]json.RawMessage `json:"properties"`
+			Required   []string                   `json:"required"`
+		}{}
+
+		if err := json.Unmarshal([]byte(schema.Schema), &parsed); err != nil {
+			t.Fatalf("Expected the schema of %s to be JSON. %s", schema.Name, err.Error())
+		}
+
+		if parsed.Title != schema.Name || schema.SchemaVersion < 1 || !contains(parsed.Required, "schemaVersion") {
+			t.Errorf("Expected the schema of %s to require its version, got %s", schema.Name, schema.Schema)
+		}
+	}
+
+	stub.MockTransactionStart("tx1")
+	if err := emitEvent(requester, EventProposalCreated, &ProposalEvent{ProposalID: "PROPOSAL0", Status: ProposalPending}); err != nil {
+		t.Fatalf("emitEvent failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	events, _ := s.GetEventsSince(requester, "", 1)
+	event := new(ProposalEvent)
+
+	if len(events) != 1 || json.Unmarshal([]byte(events[0].Payload), event) != nil || event.SchemaVersion != eventTypes[EventProposalCreated].version {
+		t.Errorf("Expected the payload to carry its schema version, got %v", events)
+	}
+}
+
+func TestMetaAnalysis(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, value := range []int64{10, 20, 30} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	for i, patientsIDs := range []string{"PATIENT0,PATIENT1", "PATIENT2"} {
+		id := fmt.Sprintf("PROPOSAL%d", i)
+		stub.MockTransactionStart(id)
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", patientsIDs, "KEY0", OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+		if err := s.ExecuteProposal(requester, id, pk.Q.String()); err != nil {
+			t.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+		if err := s.CreateResult(requester, id, token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+			t.Fatalf("CreateResult failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(id)
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.CreateMetaAnalysisProposal(requester, "PROPOSAL2", "PROTOCOL0", "Org1MSP", "Org2MSP", "RESULT-PROPOSAL0,RESULT-PROPOSAL1", "KEY0", ""); err == nil {
+		t.Errorf("Expected results under another key to be refused")
+	}
+	if err := s.CreateMetaAnalysisProposal(requester, "PROPOSAL2", "PROTOCOL0", "Org1MSP", "Org2MSP", "RESULT-PROPOSAL0,RESULT-PROPOSAL1", "KEY1", ""); err != nil {
+		t.Fatalf("CreateMetaAnalysisProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL2"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL2", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	// The mean of 15 over 2 patients weighted with the mean of 30 over 1
+	proposal, _ := s.FindProposal(requester, "PROPOSAL2")
+	m := phe.Decrypt(cloneKey(requesterSK), pk, phe.StringToMultivector(proposal.Value))
+
+	if m.Cmp(big.NewRat(20, 1)) != 0 {
+		t.Errorf("Expected a pooled mean of 20, got %s", m.String())
+	}
+}
+
+func TestPurposeLimitedConsent(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.GrantConsent(custodian, "CONSENT0", "PATIENT0", "Org1MSP", "terms", "TREATMENT,BILLING", ""); err != nil {
+		t.Fatalf("GrantConsent failed. %s", err.Error())
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	for i, purpose := range []string{PurposeResearch, PurposeTreatment} {
+		id := fmt.Sprintf("PROPOSAL%d", i)
+		stub.MockTransactionStart(id)
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", fmt.Sprintf("KEY%d", i), OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+		if err := s.SetProposalPurpose(requester, id, purpose); err != nil {
+			t.Fatalf("SetProposalPurpose failed. %s", err.Error())
+		}
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+		err := s.ExecuteProposal(requester, id, pk.Q.String())
+		stub.MockTransactionEnd(id)
+
+		if contractError, ok := err.(*ContractError); purpose == PurposeResearch && (!ok || contractError.Code != CodePermissionDenied) {
+			t.Errorf("Expected research beyond the consented purposes to be refused, got %v", err)
+		}
+		if purpose == PurposeTreatment && err != nil {
+			t.Errorf("ExecuteProposal failed. %s", err.Error())
+		}
+	}
+
+	page, err := s.GetAccessLog(custodian, "PATIENT0", 0, "")
+
+	if err != nil {
+		t.Fatalf("GetAccessLog failed. %s", err.Error())
+	}
+
+	entries := page.Records
+
+	if len(entries) != 1 || entries[0].ProposalID != "PROPOSAL1" || entries[0].Purpose != PurposeTreatment {
+		t.Errorf("Expected the treatment access of PROPOSAL1 to be logged, got %+v", entries)
+	}
+
+	if _, err := s.GetAccessLog(requester, "PATIENT0", 0, ""); err == nil {
+		t.Errorf("Expected the access log to be refused to other orgs than the custodian")
+	}
+}
+
+func TestEmergencyAccess(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+	physician := newContext(stub, "physician", "Org1MSP", map[string]string{emergencyAttribute: "true"})
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	for _, refused := range []struct {
+		ctx           *contractapi.TransactionContext
+		justification string
+		code          string
+	}{
+		{newContext(stub, "researcher", "Org1MSP", nil), "Unconscious patient", CodePermissionDenied},
+		{newContext(stub, "researcher", "Org1MSP", map[string]string{emergencyAttribute: "false"}), "Unconscious patient", CodePermissionDenied},
+		{physician, "", CodeInvalidArgument},
+	} {
+		err := s.BreakGlass(refused.ctx, "PATIENT0", refused.justification)
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != refused.code {
+			t.Errorf("Expected the emergency access to be refused with %s, got %v", refused.code, err)
+		}
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if page, _ := s.GetBreakGlassLog(custodian, "PATIENT0", 0, ""); len(page.Records) != 0 {
+		t.Fatalf("Expected refused accesses not to be logged, got %+v", page.Records)
+	}
+
+	// Evaluating the read leaves no log, so it needs a committed access
+	stub.MockTransactionStart("tx3")
+	_, err := s.EmergencyAccessPatient(physician, "PATIENT0")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected the patient to be refused before the glass is broken, got %v", err)
+	}
+	if err := s.BreakGlass(physician, "PATIENT0", "Unconscious patient"); err != nil {
+		t.Fatalf("BreakGlass failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	stub.TxTimestamp.Seconds += 10 * 60
+	patient, err := s.EmergencyAccessPatient(physician, "PATIENT0")
+	if err != nil {
+		t.Fatalf("EmergencyAccessPatient failed. %s", err.Error())
+	}
+	if patient.DiagnosisID != "D1" {
+		t.Errorf("Expected PATIENT0 without consent, got %+v", patient)
+	}
+	if _, err := s.EmergencyAccessPatient(newContext(stub, "paramedic", "Org1MSP", map[string]string{emergencyAttribute: "true"}), "PATIENT0"); err == nil {
+		t.Errorf("Expected the access of another caller not to let the paramedic read the patient")
+	}
+	if _, err := s.EmergencyAccessPatient(newContext(stub, "physician", "Org1MSP", nil), "PATIENT0"); err == nil {
+		t.Errorf("Expected the physician to need the emergency attribute to read the patient")
+	}
+	stub.MockTransactionEnd("tx4")
+
+	stub.MockTransactionStart("tx5")
+	stub.TxTimestamp.Seconds += int64(breakGlassWindow/time.Second) + 1
+	if _, err := s.EmergencyAccessPatient(physician, "PATIENT0"); err == nil {
+		t.Errorf("Expected the access to lapse after %s", breakGlassWindow)
+	}
+	stub.MockTransactionEnd("tx5")
+
+	_, err = s.GetBreakGlassLog(physician, "PATIENT0", 0, "")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected the log to be read by the custodian only, got %v", err)
+	}
+
+	page, err := s.GetBreakGlassLog(custodian, "PATIENT0", 0, "")
+	if err != nil {
+		t.Fatalf("GetBreakGlassLog failed. %s", err.Error())
+	}
+	if len(page.Records) != 1 || page.Records[0].Justification != "Unconscious patient" || page.Records[0].Accessed.MSPID != "Org1MSP" || page.Records[0].Accessed.TxID != "tx3" {
+		t.Errorf("Expected the access of Org1MSP to be logged with its justification, got %+v", page.Records)
+	}
+
+	if log, _ := s.GetAccessLog(custodian, "PATIENT0", 0, ""); len(log.Records) != 1 || log.Records[0].Purpose != PurposeTreatment {
+		t.Errorf("Expected the access to be logged as treatment, got %+v", log.Records)
+	}
+
+	inbox, _ := s.GetMyNotifications(custodian, false, 0, "")
+	if len(inbox.Records) != 1 || inbox.Records[0].Type != NotificationEmergencyAccess || inbox.Records[0].Subject != "PATIENT0" || !strings.Contains(inbox.Records[0].Message, "Unconscious patient") {
+		t.Errorf("Expected the custodian to be notified of the emergency access, got %+v", inbox.Records)
+	}
+
+	events, _ := s.GetEventsSince(custodian, "", maxEventsPage)
+	event := new(EmergencyAccessEvent)
+	found := false
+	for _, entry := range events {
+		if entry.Name == EventEmergencyAccess {
+			found = json.Unmarshal([]byte(entry.Payload), event) == nil
+		}
+	}
+	if !found || event.PatientID != "PATIENT0" || event.CustodianMSP != "Org2MSP" || event.AccessorMSP != "Org1MSP" || event.Priority != PriorityHigh {
+		t.Errorf("Expected a high priority emergency access event, got %+v", event)
+	}
+}
+
+func TestGroupedProposal(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, p := range []struct {
+		diagnosisID string
+		value       int64
+	}{{"D1", 10}, {"D2", 40}, {"D1", 20}} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(p.value)).ToString(), p.diagnosisID, "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationGroupMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateGroupedProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2", "KEY0", "", GroupByDiagnosis, ""); err != nil {
+		t.Fatalf("CreateGroupedProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+
+	for group, expected := range map[string]struct {
+		mean int64
+		size int
+	}{"D1": {15, 2}, "D2": {40, 1}} {
+		m := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Values[group]))
+
+		if m.Cmp(big.NewRat(expected.mean, 1)) != 0 || proposal.GroupSizes[group] != expected.size {
+			t.Errorf("Expected a mean of %d over %d patients for %s, got %s over %d", expected.mean, expected.size, group, m.String(), proposal.GroupSizes[group])
+		}
+	}
+}
+
+func TestLegacyContract(t *testing.T) {
+	cc, err := newChaincode()
+
+	if err != nil {
+		t.Fatalf("Failed to create chaincode. %s", err.Error())
+	}
+
+	stub := shimtest.NewMockStub("contract-tutorial", cc)
+
+	response := stub.MockInvoke("tx1", [][]byte{[]byte("v1:FindPatient"), []byte("PATIENT1")})
+
+	if response.Message != "PATIENT1 does not exist" {
+		t.Fatalf("v1:FindPatient did not return a plain text error, %s", response.Message)
+	}
+
+	response = stub.MockInvoke("tx2", [][]byte{[]byte("FindPatient"), []byte("PATIENT1")})
+
+	if response.Message != "PATIENT1 does not exist" {
+		t.Fatalf("FindPatient did not default to %s, %s", APIVersion1, response.Message)
+	}
+
+	response = stub.MockInvoke("tx2", [][]byte{[]byte(APIVersion2 + ":FindPatient"), []byte("PATIENT1")})
+
+	if response.Message != errNotFound("PATIENT1").Error() {
+		t.Fatalf("v2:FindPatient did not return an error envelope, %s", response.Message)
+	}
+
+	response = stub.MockInvoke("tx2", [][]byte{[]byte("GetContractInfo")})
+	info := new(ContractInfo)
+	_ = json.Unmarshal(response.Payload, info)
+
+	if info.DefaultAPIVersion != APIVersion1 || !contains(info.DeprecatedAPIVersions, APIVersion1) {
+		t.Errorf("Expected %s to be the deprecated default, got %+v", APIVersion1, info)
+	}
+
+	response = stub.MockInvoke("tx2", [][]byte{[]byte("ApproveProposal"), []byte("PROPOSAL1")})
+
+	if response.Status == shim.OK || !strings.Contains(response.Message, "not found") {
+		t.Errorf("Expected functions of %s only to need their version, got %s", APIVersion2, response.Message)
+	}
+
+	response = stub.MockInvoke("tx3", [][]byte{[]byte("v1:CreateProposal"), []byte("PROPOSAL1"), []byte("Org2MSP"), []byte("Org1MSP"), []byte("PATIENT1"), []byte("KEY1"), []byte("7")})
+
+	if response.Status == shim.OK || strings.HasPrefix(response.Message, "{") || !strings.Contains(response.Message, APIVersion2+" then ExecuteProposal") {
+		t.Fatalf("Expected v1:CreateProposal to be refused in plain text naming its replacement, got %s", response.Message)
+	}
+
+	// Unversioned calls are v1 ones
+	response = stub.MockInvoke("tx3", [][]byte{[]byte("CreateProposal"), []byte("PROPOSAL1"), []byte("Org2MSP"), []byte("Org1MSP"), []byte("PATIENT1"), []byte("KEY1"), []byte("7")})
+
+	if response.Status == shim.OK || strings.HasPrefix(response.Message, "{") || !strings.Contains(response.Message, "no longer supported") {
+		t.Errorf("Expected CreateProposal to be refused in plain text, got %s", response.Message)
+	}
+
+	if proposal, _ := stub.GetState("PROPOSAL1"); proposal != nil {
+		t.Errorf("Expected the refused proposal not to be stored, got %s", proposal)
+	}
+}
+
+func TestReadPolicy(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+	researcher := newContext(stub, "researcher", "Org2MSP", map[string]string{roleAttribute: "researcher"})
+	if err := s.CreatePatient(admin, "PATIENT0", "Name", "7", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.SetReadPolicy(admin, "researcher", "diagnosisID,statusID,keyID"); err != nil {
+		t.Fatalf("SetReadPolicy failed. %s", err.Error())
+	}
+	if err := s.SetReadPolicy(admin, "researcher", "ssn"); err == nil {
+		t.Errorf("Expected a policy over an unknown field to be refused")
+	}
+	stub.MockTransactionEnd("tx1")
+
+	patient, err := s.FindPatient(researcher, "PATIENT0")
+
+	if err != nil {
+		t.Fatalf("FindPatient failed. %s", err.Error())
+	}
+
+	if patient.Name != "" || patient.PreExistingConditions != "" || patient.DiagnosisID != "D1" || !contains(patient.Redacted, FieldName) {
+		t.Errorf("Expected the name and values to be withheld from researchers, got %+v", patient)
+	}
+
+	page, err := s.AllPatients(researcher, "PATIENT0", "PATIENT1", 0, "")
+
+	if err != nil {
+		t.Fatalf("AllPatients failed. %s", err.Error())
+	}
+
+	results := page.Records
+
+	if len(results) != 1 || results[0].Record.Name != "" {
+		t.Errorf("Expected AllPatients to apply the read policy, got %+v", results)
+	}
+
+	patient, err = s.FindPatient(admin, "PATIENT0")
+
+	if err != nil {
+		t.Fatalf("FindPatient failed. %s", err.Error())
+	}
+
+	if patient.Name != "Name" || patient.Redacted != nil {
+		t.Errorf("Expected roles without a policy to read every field, got %+v", patient)
+	}
+}
+
+func TestAgeBuckets(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+
+	indicators := func(bucket int) string {
+		values := []string{}
+
+		for i := range ageBuckets {
+			indicator := int64(0)
+
+			if i == bucket {
+				indicator = 1
+			}
+
+			values = append(values, phe.Encrypt(sk, pk, big.NewInt(indicator)).ToString())
+		}
+
+		return strings.Join(values, ",")
+	}
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// PATIENT1 is moved from 18-40 to 41-65
+	for i, set := range []struct {
+		id     string
+		bucket int
+	}{{"PATIENT0", 1}, {"PATIENT1", 1}, {"PATIENT1", 2}} {
+		txID := fmt.Sprintf("tx%d", i+2)
+		stub.MockTransactionStart(txID)
+		if err := s.SetPatientAgeBucket(custodian, set.id, phe.Encrypt(sk, pk, big.NewInt(30)).ToString(), indicators(set.bucket), pk.Q.String()); err != nil {
+			t.Fatalf("SetPatientAgeBucket failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(txID)
+	}
+
+	counts, err := s.GetAgeBucketCounts(custodian, "Org2MSP", "KEY0")
+
+	if err != nil {
+		t.Fatalf("GetAgeBucketCounts failed. %s", err.Error())
+	}
+
+	if counts.Patients != 2 {
+		t.Errorf("Expected 2 patients to be counted, got %d", counts.Patients)
+	}
+
+	for i, expected := range []int64{0, 1, 1, 0} {
+		count := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(counts.Counts[ageBuckets[i]]))
+
+		if count.Cmp(big.NewRat(expected, 1)) != 0 {
+			t.Errorf("Expected %d patients in %s, got %s", expected, ageBuckets[i], count.String())
+		}
+	}
+}
+
+func TestCompactionReport(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(admin, id, "Name", "7", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// A patient deleted outside of the contract leaves its index entries behind
+	stub.MockTransactionStart("tx2")
+	if err := stub.DelState("PATIENT1"); err != nil {
+		t.Fatalf("DelState failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	report, err := s.GetCompactionReport(admin)
+	stub.MockTransactionEnd("tx3")
+
+	if err != nil {
+		t.Fatalf("GetCompactionReport failed. %s", err.Error())
+	}
+
+	patients := 0
+
+	for _, entity := range report.Entities {
+		if entity.Name == EntityPatient {
+			patients = entity.Records
+		}
+	}
+
+	if patients != 1 {
+		t.Errorf("Expected 1 patient to be reported, got %+v", report.Entities)
+	}
+
+	if len(report.OrphanedIndexEntries) != 2 || report.OrphanedIndexEntries[0].MissingID != "PATIENT1" {
+		t.Errorf("Expected the org and shard entries of PATIENT1 to be orphaned, got %+v", report.OrphanedIndexEntries)
+	}
+
+	if _, err := s.GetCompactionReport(newContext(stub, "clinician", "Org2MSP", nil)); err == nil {
+		t.Errorf("Expected the report to be refused to callers other than admins")
+	}
+}
+
+func TestDelegateApproval(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+	delegate := newContext(stub, "officer", "Org2MSP", map[string]string{"department": "research-office"})
+	clinician := newContext(stub, "clinician", "Org2MSP", nil)
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(admin, "PATIENT0", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	for i, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", fmt.Sprintf("KEY%d", i), OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+	}
+	if err := s.DelegateApproval(clinician, "DELEGATION0", "", "department=research-office", ""); err == nil {
+		t.Errorf("Expected delegations to be refused to callers other than admins")
+	}
+	if err := s.DelegateApproval(admin, "DELEGATION0", "", "department=research-office", ""); err != nil {
+		t.Fatalf("DelegateApproval failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.ApproveProposal(clinician, "PROPOSAL0"); err == nil {
+		t.Errorf("Expected members of the custodian org without a delegation to be unable to approve")
+	}
+	if err := s.ApproveProposal(delegate, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.RevokeDelegation(admin, "DELEGATION0"); err != nil {
+		t.Fatalf("RevokeDelegation failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if err := s.ApproveProposal(delegate, "PROPOSAL1"); err == nil {
+		t.Errorf("Expected a revoked delegate to be unable to approve")
+	}
+}
+
+func TestResultIDs(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// Proposals sharing their first digits, or without any, release distinct results
+	for i, id := range []string{"PROPOSAL-A1", "PROPOSAL-B1", "PROPOSAL-C"} {
+		stub.MockTransactionStart(id)
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", fmt.Sprintf("KEY%d", i), OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+		if err := s.ExecuteProposal(requester, id, pk.Q.String()); err != nil {
+			t.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+		if err := s.CreateResult(requester, id, token.T1.ToString(), token.T2.ToString(), "KEY9", pk.Q.String()); err != nil {
+			t.Fatalf("CreateResult of %s failed. %s", id, err.Error())
+		}
+		stub.MockTransactionEnd(id)
+
+		if result, err := s.FindResult(requester, "RESULT-"+id); err != nil || result.ProposalID != id {
+			t.Errorf("Expected the result of %s under its whole ID, got %+v %v", id, result, err)
+		}
+	}
+
+	// A result numbered after its proposal before results took the whole proposal ID is
+	// still told from the results of other proposals sharing the number
+	stub.MockTransactionStart("tx2")
+	if err := s.CreateProposal(requester, "PROPOSAL-D1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY3", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL-D1"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL-D1", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	legacy := new(Result)
+	_ = json.Unmarshal(stub.State["RESULT-PROPOSAL-A1"], legacy)
+	legacy.ProposalID = "PROPOSAL-D1"
+	legacyAsBytes, _ := json.Marshal(legacy)
+	_ = stub.PutState("RESULT1", legacyAsBytes)
+	stub.MockTransactionEnd("tx2")
+
+	if released, _ := releasedResultID(requester, "PROPOSAL-D1"); released != "RESULT1" {
+		t.Errorf("Expected the legacy result of PROPOSAL-D1 to be found, got %q", released)
+	}
+	if released, _ := releasedResultID(requester, "PROPOSAL-A1"); released != "RESULT-PROPOSAL-A1" {
+		t.Errorf("Expected the result of PROPOSAL-A1 under its whole ID, got %q", released)
+	}
+
+	stub.MockTransactionStart("tx3")
+	err := s.CreateResult(requester, "PROPOSAL-D1", token.T1.ToString(), token.T2.ToString(), "KEY9", pk.Q.String())
+	stub.MockTransactionEnd("tx3")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists || contractError.Details["resultID"] != "RESULT1" {
+		t.Errorf("Expected the legacy result to refuse a second release, got %v", err)
+	}
+}
+
+func TestDecryptionAttestation(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	result, _ := s.FindResult(requester, "RESULT-PROPOSAL0")
+	plaintext := phe.Decrypt(cloneKey(requesterSK), pk, phe.StringToMultivector(result.Value)).RatString()
+	plaintextHash := hashString(plaintext)
+
+	signerKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	cert, _ := newCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "researcher"}, NotBefore: notBefore, NotAfter: notAfter, KeyUsage: x509.KeyUsageDigitalSignature}, nil, signerKey, nil)
+	digest := sha256.Sum256(decryptionMessage("RESULT-PROPOSAL0", hashString(result.Value), plaintextHash))
+	sig, _ := ecdsa.SignASN1(rand.Reader, signerKey, digest[:])
+	signature := base64.StdEncoding.EncodeToString(sig)
+
+	stub.MockTransactionStart("tx2")
+	custodian.SetClientIdentity(&mockIdentity{id: "clinician", mspID: "Org2MSP", cert: cert})
+	if err := s.SubmitDecryptionAttestation(custodian, "RESULT-PROPOSAL0", plaintextHash, signature); err == nil {
+		t.Errorf("Expected an org other than the owner of the key to be refused")
+	}
+	requester.SetClientIdentity(&mockIdentity{id: "researcher", mspID: "Org1MSP", cert: cert})
+	if err := s.SubmitDecryptionAttestation(requester, "RESULT-PROPOSAL0", hashString("8"), signature); err == nil {
+		t.Errorf("Expected a signature over another plaintext hash to be refused")
+	}
+	if err := s.SubmitDecryptionAttestation(requester, "RESULT-PROPOSAL0", plaintextHash, signature); err != nil {
+		t.Fatalf("SubmitDecryptionAttestation failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if ok, err := s.VerifyDecryptedValue(custodian, "RESULT-PROPOSAL0", plaintext); err != nil || !ok {
+		t.Errorf("Expected %s to be the attested plaintext, got %t %v", plaintext, ok, err)
+	}
+	if ok, _ := s.VerifyDecryptedValue(custodian, "RESULT-PROPOSAL0", "8"); ok {
+		t.Errorf("Expected 8 not to be the attested plaintext")
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.SubmitDecryptionAttestation(requester, "RESULT-PROPOSAL0", plaintextHash, signature); err == nil {
+		t.Errorf("Expected a second attestation to be refused")
+	}
+	stub.MockTransactionEnd("tx3")
+}
+
+// memberStub denies the reads of the implicit collections of other orgs, as their peers do
+type memberStub struct {
+	*pagingStub
+	mspID string
+}
+
+func (m *memberStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	if strings.HasPrefix(collection, "_implicit_org_") && collection != "_implicit_org_"+m.mspID {
+		return nil, fmt.Errorf("tx creator does not have read access permission on privatedata in collectionName: %s", collection)
+	}
+
+	return m.pagingStub.GetPrivateData(collection, key)
+}
+
+func TestStudyPseudonyms(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	member := func(id string, mspID string, transient map[string][]byte) *contractapi.TransactionContext {
+		ctx := newContext(stub, id, mspID, map[string]string{adminAttribute: "true"})
+		ctx.SetStub(&memberStub{pagingStub: &pagingStub{MockStub: stub, transient: transient}, mspID: mspID})
+		return ctx
+	}
+	withSecret := func(mspID string, secret []byte) *contractapi.TransactionContext {
+		return member("clinician", mspID, map[string][]byte{"secret": secret})
+	}
+
+	stub.MockTransactionStart("tx1")
+	custodian := member("clinician", "Org2MSP", nil)
+	requester := member("researcher", "Org1MSP", nil)
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	for i, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", fmt.Sprintf("KEY%d", i), OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	if _, err := s.GeneratePseudonym(custodian, "PATIENT0", "PROPOSAL0"); err == nil {
+		t.Errorf("Expected no pseudonym before the study has a secret")
+	}
+
+	stub.MockTransactionStart("tx2")
+	for _, refused := range []struct {
+		ctx     *contractapi.TransactionContext
+		studyID string
+		code    string
+	}{{withSecret("Org1MSP", secret), "PROPOSAL0", CodePermissionDenied}, {withSecret("Org2MSP", secret[:31]), "PROPOSAL0", CodeInvalidArgument}, {withSecret("Org2MSP", secret), "PROPOSAL9", CodeNotFound}} {
+		err := s.SetStudySecret(refused.ctx, refused.studyID)
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != refused.code {
+			t.Errorf("Expected SetStudySecret to be refused with %s, got %v", refused.code, err)
+		}
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if previous, _ := studySecret(custodian, "Org2MSP", "PROPOSAL0"); previous != nil {
+		t.Fatalf("Expected the refused secrets not to be stored")
+	}
+
+	stub.MockTransactionStart("tx3")
+	for _, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.SetStudySecret(withSecret("Org2MSP", secret), id); err != nil {
+			t.Fatalf("SetStudySecret failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx3")
+
+	secretKey, _ := stub.CreateCompositeKey(studySecretIndex, []string{"PROPOSAL0"})
+	if stored, _ := stub.GetPrivateData("_implicit_org_Org2MSP", secretKey); string(stored) != string(secret) {
+		t.Errorf("Expected the secret in the implicit collection of the custodian, got %q", stored)
+	}
+
+	stub.MockTransactionStart("tx4")
+	err := s.SetStudySecret(withSecret("Org2MSP", []byte("fedcba9876543210fedcba9876543210")), "PROPOSAL0")
+	stub.MockTransactionEnd("tx4")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists {
+		t.Errorf("Expected the secret to be set once, got %v", err)
+	}
+
+	first, err := s.GeneratePseudonym(custodian, "PATIENT0", "PROPOSAL0")
+	if err != nil {
+		t.Fatalf("GeneratePseudonym failed. %s", err.Error())
+	}
+	second, _ := s.GeneratePseudonym(custodian, "PATIENT1", "PROPOSAL0")
+	if again, _ := s.GeneratePseudonym(custodian, "PATIENT0", "PROPOSAL0"); first != pseudonym(secret, "PATIENT0") || again != first || second == first {
+		t.Errorf("Expected stable, distinct pseudonyms under the first secret, got %s %s %s", first, again, second)
+	}
+	if _, err := s.GeneratePseudonym(custodian, "PATIENT9", "PROPOSAL0"); err == nil {
+		t.Errorf("Expected no pseudonym of a missing patient")
+	}
+	_, err = s.GeneratePseudonym(requester, "PATIENT0", "PROPOSAL0")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected only the custodian to link patients to their pseudonyms, got %v", err)
+	}
+
+	// The requester releases PROPOSAL0 without reading the secret, the custodian PROPOSAL1
+	for _, release := range []struct {
+		ctx        *contractapi.TransactionContext
+		proposalID string
+		resultID   string
+		pseudonyms int
+	}{{requester, "PROPOSAL0", "RESULT-PROPOSAL0", 0}, {custodian, "PROPOSAL1", "RESULT-PROPOSAL1", 2}} {
+		stub.MockTransactionStart("tx5")
+		if err := s.ApproveProposal(custodian, release.proposalID); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+		if err := s.ExecuteProposal(requester, release.proposalID, pk.Q.String()); err != nil {
+			t.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+		if err := s.CreateResult(release.ctx, release.proposalID, token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+			t.Fatalf("CreateResult failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd("tx5")
+
+		result, _ := s.FindResult(requester, release.resultID)
+		if pseudonyms := result.Manifest.Pseudonyms; len(pseudonyms) != release.pseudonyms || (len(pseudonyms) > 0 && (!contains(pseudonyms, pseudonym(secret, "PATIENT0")) || !contains(pseudonyms, pseudonym(secret, "PATIENT1")))) {
+			t.Errorf("Expected the manifest of %s to hold %d pseudonyms, got %v", release.resultID, release.pseudonyms, pseudonyms)
+		}
+	}
+}
+
+func TestWithdrawPatientFromProposal(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.WithdrawPatientFromProposal(requester, "PROPOSAL0", "PATIENT1"); err == nil {
+		t.Errorf("Expected an org other than the custodian to be refused")
+	}
+	if err := s.WithdrawPatientFromProposal(custodian, "PROPOSAL0", "PATIENT1"); err != nil {
+		t.Fatalf("WithdrawPatientFromProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+
+	if proposal.PatientsIDs != "PATIENT0" || proposal.Status != ProposalApproved || proposal.Version != 2 {
+		t.Errorf("Expected version 2 of the approved proposal over PATIENT0, got %+v", proposal)
+	}
+
+	if lock, _ := patientLock(custodian, "PATIENT1"); lock != "" {
+		t.Errorf("Expected PATIENT1 to be unlocked, got a lock of %s", lock)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL2", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT1", "KEY0", OperationMean, ""); err == nil {
+		t.Errorf("Expected a cohort of withdrawn patients only to be refused")
+	}
+	if err := s.WithdrawPatientFromProposal(custodian, "PROPOSAL1", "PATIENT0"); err == nil {
+		t.Errorf("Expected the withdrawal of the only patient to be refused")
+	}
+	stub.MockTransactionEnd("tx3")
+
+	proposal, _ = s.FindProposal(requester, "PROPOSAL1")
+
+	if proposal.PatientsIDs != "PATIENT0" {
+		t.Errorf("Expected PATIENT1 to be left out of PROPOSAL1, got %s", proposal.PatientsIDs)
+	}
+
+	page, _ := s.GetPatientWithdrawals(custodian, "PATIENT1", 0, "")
+	withdrawals := page.Records
+
+	if len(withdrawals) != 1 || withdrawals[0].ProposalID != "PROPOSAL0" || withdrawals[0].ProtocolID != "PROTOCOL0" {
+		t.Errorf("Expected the withdrawal from PROPOSAL0 to be recorded, got %+v", withdrawals)
+	}
+}
+
+func TestCohortLock(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	encrypt := func(m int64) string {
+		return phe.Encrypt(sk, pk, big.NewInt(m)).ToString()
+	}
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", encrypt(1), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	expiry := time.Unix(stub.TxTimestamp.Seconds, 0).UTC().Add(time.Hour).Format(time.RFC3339)
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, expiry); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	for _, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		stub.MockTransactionStart("locked")
+		err := s.SetPatientMeasurement(custodian, id, "glucose", encrypt(2))
+		stub.MockTransactionEnd("locked")
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidState {
+			t.Errorf("Expected %s to be locked by its approved proposal, got %v", id, err)
+		}
+	}
+
+	// The lock of a proposal that expired unexecuted no longer holds
+	stub.MockTransactionStart("tx2")
+	stub.TxTimestamp.Seconds += 2 * 60 * 60
+	if err := s.SetPatientMeasurement(custodian, "PATIENT0", "glucose", encrypt(2)); err != nil {
+		t.Errorf("Expected the lock of the expired proposal to be ignored, got %v", err)
+	}
+	stub.MockTransactionEnd("tx2")
+
+	// The execution releases the lock, before the result is released
+	stub.MockTransactionStart("tx3")
+	if err := s.ExecuteProposal(requester, "PROPOSAL1", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	if lock, err := patientLock(custodian, "PATIENT1"); err != nil || lock != "" {
+		t.Errorf("Expected PATIENT1 to be unlocked by the execution, got a lock of %q %v", lock, err)
+	}
+
+	stub.MockTransactionStart("tx4")
+	if err := s.SetPatientMeasurement(custodian, "PATIENT1", "glucose", encrypt(2)); err != nil {
+		t.Errorf("Expected PATIENT1 to change once its proposal is executed, got %v", err)
+	}
+	stub.MockTransactionEnd("tx4")
+}
+
+func TestPagination(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+	for _, id := range []string{"PATIENT0", "PATIENT1", "PATIENT2"} {
+		if err := s.CreatePatient(admin, id, "Name", "7", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	page, err := s.AllPatients(admin, "PATIENT0", "PATIENT9", 2, "")
+
+	if err != nil {
+		t.Fatalf("AllPatients failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 2 || !page.Truncated || page.Bookmark != "PATIENT2" {
+		t.Fatalf("Expected a truncated first page, got %+v", page)
+	}
+
+	page, err = s.AllPatients(admin, "PATIENT0", "PATIENT9", 2, page.Bookmark)
+
+	if err != nil {
+		t.Fatalf("AllPatients failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 1 || page.Truncated || page.Records[0].Key != "PATIENT2" {
+		t.Errorf("Expected the last page to hold PATIENT2 alone, got %+v", page)
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.SetQueryLimits(admin, 5, 2); err == nil {
+		t.Errorf("Expected a default page size above the result limit to be refused")
+	}
+	if err := s.SetQueryLimits(admin, 1, 2); err != nil {
+		t.Fatalf("SetQueryLimits failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	page, err = s.AllPatients(admin, "PATIENT0", "PATIENT9", 0, "")
+
+	if err != nil {
+		t.Fatalf("AllPatients failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 1 || !page.Truncated {
+		t.Errorf("Expected the configured default page size, got %+v", page)
+	}
+
+	page, err = s.AllPatients(admin, "PATIENT0", "PATIENT9", 50, "")
+
+	if err != nil {
+		t.Fatalf("AllPatients failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 2 || !page.Truncated {
+		t.Errorf("Expected the page size to be capped by the result limit, got %+v", page)
+	}
+}
+
+func TestSampleCohort(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+	for i, diagnosisID := range []string{"D1", "D1", "D2", "D1", "D1"} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", "0", diagnosisID, "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	selector := CohortSelector{CustodianMSP: "Org2MSP", DiagnosisIDs: "D1"}
+
+	sample, err := s.SampleCohort(requester, selector, 3, "pilot")
+
+	if err != nil {
+		t.Fatalf("SampleCohort failed. %s", err.Error())
+	}
+
+	pids := strings.Split(sample.PatientsIDs, ",")
+
+	if sample.Selected != 4 || len(pids) != 3 || contains(pids, "PATIENT2") {
+		t.Fatalf("Expected 3 of the 4 patients with D1, got %+v", sample)
+	}
+
+	for i := 1; i < len(pids); i++ {
+		if sampleRank("pilot", pids[i-1]) > sampleRank("pilot", pids[i]) {
+			t.Errorf("Expected the sample to be ordered by rank, got %s", sample.PatientsIDs)
+		}
+	}
+
+	smaller, err := s.SampleCohort(custodian, CohortSelector{CustodianMSP: "Org2MSP", DiagnosisIDs: "D1"}, 2, "pilot")
+
+	if err != nil {
+		t.Fatalf("SampleCohort failed. %s", err.Error())
+	}
+
+	if smaller.PatientsIDs != strings.Join(pids[:2], ",") {
+		t.Errorf("Expected a smaller sample to be a prefix of %s, got %s", sample.PatientsIDs, smaller.PatientsIDs)
+	}
+
+	if _, err := s.SampleCohort(requester, CohortSelector{CustodianMSP: "Org3MSP"}, 1, "pilot"); err == nil {
+		t.Errorf("Expected an empty selection to be refused")
+	}
+}
+
+func TestDuplicateProposal(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(admin, id, "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org1MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.SetDuplicateWindow(admin, 60); err != nil {
+		t.Fatalf("SetDuplicateWindow failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org1MSP", "PATIENT1,PATIENT0", "KEY0", OperationMean, "")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists || contractError.Details["id"] != "PROPOSAL0" {
+		t.Errorf("Expected the same cohort in another order to duplicate PROPOSAL0, got %v", err)
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL2", "PROTOCOL0", "Org1MSP", "Org1MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Errorf("Expected another cohort not to be a duplicate. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	stub.TxTimestamp.Seconds += 120
+	if err := s.CreateProposal(requester, "PROPOSAL3", "PROTOCOL0", "Org1MSP", "Org1MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Errorf("Expected an identical proposal to be accepted once the window passed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	if err := s.SetDuplicateWindow(admin, -1); err == nil {
+		t.Errorf("Expected a negative window to be refused")
+	}
+}
+
+func TestFreezePatient(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.FreezePatient(requester, "PATIENT0", "Legal hold"); err == nil {
+		t.Errorf("Expected callers other than admins and the custodian to be unable to freeze")
+	}
+	if err := s.FreezePatient(custodian, "PATIENT0", "Legal hold"); err != nil {
+		t.Fatalf("FreezePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	patient, err := s.FindPatient(requester, "PATIENT0")
+
+	if err != nil {
+		t.Fatalf("FindPatient failed. %s", err.Error())
+	}
+
+	if patient.Freeze == nil || patient.Freeze.Reason != "Legal hold" || patient.Freeze.Frozen.MSPID != "Org2MSP" {
+		t.Fatalf("Expected the freeze to be surfaced, got %+v", patient.Freeze)
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.UpdatePatient(custodian, "PATIENT0", "Name", "1", "D1", "S1", "KEY0"); err == nil {
+		t.Errorf("Expected a frozen patient to be unwritable")
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err == nil {
+		t.Errorf("Expected a frozen patient to be kept out of proposals")
+	}
+	if err := s.UnfreezeRecord(custodian, "PATIENT0"); err != nil {
+		t.Fatalf("UnfreezeRecord failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.UpdatePatient(custodian, "PATIENT0", "Name", "1", "D1", "S1", "KEY0"); err != nil {
+		t.Errorf("Expected an unfrozen patient to be writable. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+}
+
+func TestRequesterQuota(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, value := range []int64{10, 20, 30} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.SetDataSharingAgreement(requester, "Org1MSP", 1, 0); err == nil {
+		t.Errorf("Expected agreements to be refused to callers other than admins")
+	}
+	if err := s.SetDataSharingAgreement(custodian, "Org1MSP", 1, 0); err != nil {
+		t.Fatalf("SetDataSharingAgreement failed. %s", err.Error())
+	}
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("PROPOSAL%d", i)
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", fmt.Sprintf("PATIENT%d", i), "KEY0", OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	for _, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	err := s.ExecuteProposal(requester, "PROPOSAL1", pk.Q.String())
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeQuotaExceeded {
+		t.Errorf("Expected the daily quota to be exhausted, got %v", err)
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL2"); err == nil {
+		t.Errorf("Expected approvals to be refused once the quota is exhausted")
+	}
+	stub.MockTransactionEnd("tx4")
+
+	usages, err := s.GetQuotaUsage(requester, "Org2MSP", "Org1MSP")
+
+	if err != nil {
+		t.Fatalf("GetQuotaUsage failed. %s", err.Error())
+	}
+
+	if len(usages) != 1 || usages[0].Quota != 1 || usages[0].Used != 1 {
+		t.Errorf("Expected the daily quota to be used, got %+v", usages)
+	}
+
+	stub.MockTransactionStart("tx5")
+	stub.TxTimestamp.Seconds += 24 * 60 * 60
+	if err := s.ExecuteProposal(requester, "PROPOSAL1", pk.Q.String()); err != nil {
+		t.Errorf("Expected the quota to be renewed the next day. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx5")
+
+	// Executions are counted in entries of their own, summed with a counter written whole before
+	stub.MockTransactionStart("tx6")
+	stub.TxTimestamp.Seconds += 24 * 60 * 60
+	day, _ := quotaPeriods(time.Unix(stub.TxTimestamp.Seconds, 0))
+	counterKey, _ := stub.CreateCompositeKey(quotaUsageIndex, []string{"Org2MSP", "Org1MSP", day})
+	if value, _ := stub.GetState(counterKey); value != nil {
+		t.Errorf("Expected the counter itself not to be written, got %s", value)
+	}
+	_ = stub.PutState(counterKey, []byte("2"))
+	stub.MockTransactionEnd("tx6")
+
+	if usages, _ := s.GetQuotaUsage(requester, "Org2MSP", "Org1MSP"); len(usages) != 1 || usages[0].Used != 3 {
+		t.Errorf("Expected the counter and the entry to be summed, got %+v", usages)
+	}
+}
+
+func TestMeasurementProofs(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	encrypt := func(m int64) string {
+		return phe.Encrypt(sk, pk, big.NewInt(m)).ToString()
+	}
+	proof := base64.StdEncoding.EncodeToString([]byte("proof"))
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, value := range []int64{10, 20} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", encrypt(value), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.SetMeasurementProof(requester, "PATIENT0", DefaultMetric, "bulletproofs", proof); err == nil {
+		t.Errorf("Expected proofs to be refused to callers other than the custodian")
+	}
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.SetMeasurementProof(custodian, id, DefaultMetric, "bulletproofs", proof); err != nil {
+			t.Fatalf("SetMeasurementProof failed. %s", err.Error())
+		}
+	}
+	if err := s.SetStrictProofs(custodian, true); err != nil {
+		t.Fatalf("SetStrictProofs failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.UpdatePatient(custodian, "PATIENT1", "Name", encrypt(30), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("UpdatePatient failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	status, err := s.VerifyMeasurementProof(requester, "PATIENT1", DefaultMetric)
+
+	if err != nil {
+		t.Fatalf("VerifyMeasurementProof failed. %s", err.Error())
+	}
+
+	if status.Proof == nil || status.Proof.Scheme != "bulletproofs" || status.Current {
+		t.Fatalf("Expected the proof of the previous value to be stale, got %+v", status)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err == nil {
+		t.Errorf("Expected strict mode to refuse measurements lacking a proof")
+	}
+	if err := s.SetMeasurementProof(custodian, "PATIENT1", DefaultMetric, "bulletproofs", proof); err != nil {
+		t.Fatalf("SetMeasurementProof failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Errorf("Expected measurements with current proofs to be computed over. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx4")
+}
+
+func TestRekeyResults(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	oldSK, _ := phe.GenerateKeys(256)
+	newSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(oldSK), pk, pk)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, value := range []int64{10, 20} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	for _, keyID := range []string{"KEY1", "KEY2"} {
+		if err := s.RegisterKey(requester, keyID, pk.Q.String(), 0, ""); err != nil {
+			t.Fatalf("RegisterKey failed. %s", err.Error())
+		}
+	}
+	if err := s.SetQueryLimits(custodian, 1, 0); err != nil {
+		t.Fatalf("SetQueryLimits failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	for i := 0; i < 2; i++ {
+		id := fmt.Sprintf("PROPOSAL%d", i)
+		stub.MockTransactionStart(id)
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", fmt.Sprintf("PATIENT%d", i), "KEY0", OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+		if err := s.ExecuteProposal(requester, id, pk.Q.String()); err != nil {
+			t.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+		if err := s.CreateResult(requester, id, token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+			t.Fatalf("CreateResult failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(id)
+	}
+
+	rekeyToken := phe.GenerateToken(cloneKey(oldSK), cloneKey(newSK), pk, pk)
+
+	stub.MockTransactionStart("tx2")
+	if _, err := s.RekeyResults(requester, "KEY1", "KEY2", rekeyToken.T1.ToString(), rekeyToken.T2.ToString()); err == nil {
+		t.Errorf("Expected the results of a key not rotated to be refused")
+	}
+	if err := s.RotateKey(requester, "KEY1", "KEY2"); err != nil {
+		t.Fatalf("RotateKey failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	for i, remaining := range []bool{true, false} {
+		stub.MockTransactionStart("tx3")
+		if _, err := s.RekeyResults(custodian, "KEY1", "KEY2", rekeyToken.T1.ToString(), rekeyToken.T2.ToString()); err == nil {
+			t.Errorf("Expected callers other than the owner of the key to be refused")
+		}
+		progress, err := s.RekeyResults(requester, "KEY1", "KEY2", rekeyToken.T1.ToString(), rekeyToken.T2.ToString())
+		stub.MockTransactionEnd("tx3")
+
+		if err != nil {
+			t.Fatalf("RekeyResults failed. %s", err.Error())
+		}
+
+		if progress.Rekeyed != i+1 || progress.Remaining != remaining {
+			t.Fatalf("Expected a page of one result at a time, got %+v", progress)
+		}
+	}
+
+	for i, expected := range []int64{10, 20} {
+		result, err := s.FindResult(requester, fmt.Sprintf("RESULT-PROPOSAL%d", i))
+
+		if err != nil {
+			t.Fatalf("FindResult failed. %s", err.Error())
+		}
+
+		m := phe.Decrypt(cloneKey(newSK), pk, phe.StringToMultivector(result.Value))
+
+		if result.KeyID != "KEY2" || len(result.Rekeys) != 1 || m.Cmp(big.NewRat(expected, 1)) != 0 {
+			t.Errorf("Expected RESULT-PROPOSAL%d to decrypt to %d under KEY2, got %s", i, expected, m.String())
+		}
+
+		if err := s.VerifyComputationReceipt(requester, result.ReceiptID); err != nil {
+			t.Errorf("VerifyComputationReceipt failed. %s", err.Error())
+		}
+	}
+}
+
+func TestQueryPatientsDSL(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+	researcher := newContext(stub, "researcher", "Org1MSP", map[string]string{roleAttribute: "researcher"})
+	for i, ids := range [][2]string{{"D1", "S1"}, {"D1", "S2"}, {"D2", "S1"}, {"D1", "S3"}} {
+		if err := s.CreatePatient(admin, fmt.Sprintf("PATIENT%d", i), "Name", "0", ids[0], ids[1], "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.CreatePatient(newContext(stub, "clinician", "Org2MSP", nil), "PATIENT4", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.SetReadPolicy(admin, "researcher", "diagnosisID,keyID"); err != nil {
+		t.Fatalf("SetReadPolicy failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	page, err := s.QueryPatientsDSL(admin, `diagnosisID = D1 AND (statusID = "S1" OR statusID = S2)`, 0, "")
+
+	if err != nil {
+		t.Fatalf("QueryPatientsDSL failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 2 || page.Records[0].Key != "PATIENT0" || page.Records[1].Key != "PATIENT1" {
+		t.Errorf("Expected PATIENT0 and PATIENT1 of the caller's org, got %+v", page.Records)
+	}
+
+	if _, err := s.QueryPatientsDSL(admin, "preExistingConditions = 0", 0, ""); err == nil {
+		t.Errorf("Expected encrypted fields to be refused")
+	}
+
+	if _, err := s.QueryPatientsDSL(admin, "diagnosisID = D1 AND", 0, ""); err == nil {
+		t.Errorf("Expected an incomplete expression to be refused")
+	}
+
+	_, err = s.QueryPatientsDSL(researcher, "statusID = S1", 0, "")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected a field withheld by the read policy to be refused, got %v", err)
+	}
+
+	// On CouchDB the selector matches the patients of the custodian, transferred ones included
+	stub.MockTransactionStart("tx2")
+	if err := s.SetStateDatabase(admin, StateDatabaseCouchDB); err != nil {
+		t.Fatalf("SetStateDatabase failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	couchDB := &selectorStub{pagingStub: pagingStub{MockStub: stub}}
+	admin.SetStub(couchDB)
+
+	if _, err := s.QueryPatientsDSL(admin, "diagnosisID = D1", 0, ""); err != nil {
+		t.Fatalf("QueryPatientsDSL failed. %s", err.Error())
+	}
+
+	custodian := `{"$or":[{"custodianMSP":"Org1MSP"},{"custodianMSP":{"$exists":false},"metadata.created.mspID":"Org1MSP"}]}`
+
+	if !strings.Contains(couchDB.query, custodian) {
+		t.Errorf("Expected the selector to match the custodian, got %s", couchDB.query)
+	}
+}
+
+// selectorStub records the CouchDB queries it is given, matching no records
+type selectorStub struct {
+	pagingStub
+	query string
+}
+
+func (c *selectorStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	c.query = query
+
+	return &sliceIterator{}, &peer.QueryResponseMetadata{}, nil
+}
+
+func TestGetUsageStatistics(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	patients := []PatientInput{}
+	for i, value := range []int64{10, 20, 30} {
+		patients = append(patients, PatientInput{ID: fmt.Sprintf("PATIENT%d", i), Name: "Name", PreExistingConditions: phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), DiagnosisID: "D1", StatusID: "S1", KeyID: "KEY0"})
+	}
+	if err := s.CreatePatientsBatch(custodian, patients); err != nil {
+		t.Fatalf("CreatePatientsBatch failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.CreatePatient(custodian, "PATIENT3", "Name", phe.Encrypt(sk, pk, big.NewInt(40)).ToString(), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2", "PATIENT3")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT3", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	for _, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx3")
+
+	var today time.Time
+	for i, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		stub.MockTransactionStart(fmt.Sprintf("tx%d", 4+i))
+		if i == 0 {
+			today = time.Unix(stub.TxTimestamp.Seconds, 0).UTC()
+		}
+		stub.TxTimestamp.Seconds += int64(i) * 24 * 60 * 60
+		if err := s.ExecuteProposal(requester, id, pk.Q.String()); err != nil {
+			t.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(fmt.Sprintf("tx%d", 4+i))
+	}
+
+	// The counters of a day are summed beyond the result limit
+	stub.MockTransactionStart("tx6")
+	if err := s.SetQueryLimits(custodian, 0, 1); err != nil {
+		t.Fatalf("SetQueryLimits failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx6")
+
+	statistics, err := s.GetUsageStatistics(requester, today.Format("2006-01-02"), today.AddDate(0, 0, 1).Format("2006-01-02"))
+
+	if err != nil {
+		t.Fatalf("GetUsageStatistics failed. %s", err.Error())
+	}
+
+	if len(statistics.Days) != 2 || statistics.Days[0].PatientsCreated != 4 || statistics.Days[0].ProposalsExecuted != 1 || statistics.Days[0].AverageCohortSize != 3 {
+		t.Errorf("Expected 4 patients and a proposal over 3 the first day, got %+v", statistics.Days)
+	}
+
+	if statistics.Total.PatientsCreated != 4 || statistics.Total.ProposalsExecuted != 2 || statistics.Total.AverageCohortSize != 2 {
+		t.Errorf("Expected 2 proposals over 2 patients on average in total, got %+v", statistics.Total)
+	}
+
+	if _, err := s.GetUsageStatistics(requester, "2026-01-02", "2026-01-01"); err == nil {
+		t.Errorf("Expected a backward range to be refused")
+	}
+}
+
+func TestExpireConsents(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	now := time.Now().UTC()
+
+	stub.MockTransactionStart("tx1")
+	for i, value := range []int64{10, 20} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.GrantConsent(custodian, "CONSENT0", "PATIENT0", "Org1MSP", "terms", PurposeResearch, "soon"); err == nil {
+		t.Errorf("Expected an invalid expiry to be refused")
+	}
+	consents := []ConsentInput{
+		{ID: "CONSENT0", PatientID: "PATIENT0", GranteeMSP: "Org1MSP", Terms: "terms", Purposes: PurposeResearch, Expiry: now.Add(10 * 24 * time.Hour).Format(time.RFC3339)},
+		{ID: "CONSENT1", PatientID: "PATIENT1", GranteeMSP: "Org1MSP", Terms: "terms", Purposes: PurposeResearch, Expiry: now.Add(60 * 24 * time.Hour).Format(time.RFC3339)},
+	}
+	if err := s.GrantConsentsBatch(custodian, consents); err != nil {
+		t.Fatalf("GrantConsentsBatch failed. %s", err.Error())
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	report, err := s.ExpireConsents(custodian)
+	if err != nil {
+		t.Fatalf("ExpireConsents failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if len(report.Notified) != 1 || report.Notified[0] != "CONSENT0" || len(report.Expired) != 0 {
+		t.Errorf("Expected the custodian to be notified of CONSENT0 only, got %+v", report)
+	}
+
+	page, err := s.GetMyNotifications(custodian, false, 0, "")
+	if err != nil {
+		t.Fatalf("GetMyNotifications failed. %s", err.Error())
+	}
+
+	found := false
+	for _, notification := range page.Records {
+		found = found || (notification.Type == NotificationConsentExpiring && notification.Subject == "CONSENT0")
+	}
+	if !found {
+		t.Errorf("Expected a CONSENT_EXPIRING notification for CONSENT0, got %+v", page.Records)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if report, err := s.ExpireConsents(custodian); err != nil || len(report.Notified) != 0 {
+		t.Errorf("Expected the custodian to be notified once, got %+v %v", report, err)
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	stub.TxTimestamp.Seconds += 11 * 24 * 60 * 60
+	err = s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String())
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected an expired consent to be treated as revoked, got %v", err)
+	}
+	stub.MockTransactionEnd("tx4")
+
+	stub.MockTransactionStart("tx5")
+	stub.TxTimestamp.Seconds += 11 * 24 * 60 * 60
+	report, err = s.ExpireConsents(custodian)
+	if err != nil {
+		t.Fatalf("ExpireConsents failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx5")
+
+	if len(report.Expired) != 1 || report.Expired[0] != "CONSENT0" || len(report.Notified) != 0 {
+		t.Errorf("Expected CONSENT0 to expire, got %+v", report)
+	}
+
+	if consent, _ := s.FindConsent(custodian, "CONSENT0"); consent.Status != ConsentExpired {
+		t.Errorf("Expected CONSENT0 to be %s, got %s", ConsentExpired, consent.Status)
+	}
+}
+
+func TestExecuteProposalChunk(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	pids := []string{}
+	for i, value := range []int64{10, 20, 30, 40, 50} {
+		pids = append(pids, fmt.Sprintf("PATIENT%d", i))
+		if err := s.CreatePatient(custodian, pids[i], "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", pids...)
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", strings.Join(pids, ","), "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	for i, bookmark := range []string{"2", "4", ""} {
+		stub.MockTransactionStart(fmt.Sprintf("chunk%d", i))
+		checkpoint, err := s.ExecuteProposalChunk(requester, "PROPOSAL0", pk.Q.String(), 2)
+		stub.MockTransactionEnd(fmt.Sprintf("chunk%d", i))
+
+		if err != nil {
+			t.Fatalf("ExecuteProposalChunk failed. %s", err.Error())
+		}
+
+		if checkpoint.Bookmark != bookmark || checkpoint.Remaining != (bookmark != "") {
+			t.Errorf("Expected bookmark %q, got %+v", bookmark, checkpoint)
+		}
+
+		if i == 0 {
+			stub.MockTransactionStart("early")
+			if err := s.FinalizeProposal(requester, "PROPOSAL0"); err == nil {
+				t.Errorf("Expected a proposal with patients left to be refused")
+			}
+			stub.MockTransactionEnd("early")
+		}
+	}
+
+	stub.MockTransactionStart("finalize")
+	if _, err := s.ExecuteProposalChunk(requester, "PROPOSAL0", pk.Q.String(), 2); err == nil {
+		t.Errorf("Expected a chunk past the cohort to be refused")
+	}
+	if err := s.FinalizeProposal(requester, "PROPOSAL0"); err != nil {
+		t.Fatalf("FinalizeProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("finalize")
+
+	proposal, err := s.FindProposal(requester, "PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("FindProposal failed. %s", err.Error())
+	}
+
+	if proposal.Status != ProposalExecuted {
+		t.Errorf("Expected PROPOSAL0 to be executed, got %s", proposal.Status)
+	}
+
+	if m := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Value)); m.Cmp(big.NewRat(30, 1)) != 0 {
+		t.Errorf("Expected mean 30, got %s", m.String())
+	}
+
+	if _, err := s.FindExecutionCheckpoint(requester, "PROPOSAL0"); err == nil {
+		t.Errorf("Expected the checkpoint to be removed once finalized")
+	}
+}
+
+func TestIdemixResearcher(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	researcher := newContext(stub, "", "Org1MSP", nil)
+	researcher.SetClientIdentity(&mockIdentity{mspID: "Org1MSP", attrs: map[string]string{idemixOUAttribute: "researcher", idemixRoleAttribute: "member"}, anonymous: true})
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.SetReadPolicy(custodian, "researcher", "diagnosisID"); err != nil {
+		t.Fatalf("SetReadPolicy failed. %s", err.Error())
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0")
+	if err := s.RegisterStudyProtocol(researcher, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(researcher, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, err := s.FindProposal(researcher, "PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("FindProposal failed. %s", err.Error())
+	}
+
+	if proposal.Metadata.Created.ClientID != "idemix::Org1MSP::researcher" {
+		t.Errorf("Expected the proposal to be created by the OU of the credential, got %s", proposal.Metadata.Created.ClientID)
+	}
+
+	patient, err := s.FindPatient(researcher, "PATIENT0")
+
+	if err != nil {
+		t.Fatalf("FindPatient failed. %s", err.Error())
+	}
+
+	if patient.Name != "" || patient.DiagnosisID != "D1" {
+		t.Errorf("Expected the read policy of the researcher OU to apply, got %+v", patient)
+	}
+}
+
+func TestGetResultLineage(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	oldSK, _ := phe.GenerateKeys(256)
+	newSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(oldSK), pk, pk)
+	rekeyToken := phe.GenerateToken(cloneKey(oldSK), cloneKey(newSK), pk, pk)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, value := range []int64{10, 20} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	for _, keyID := range []string{"KEY1", "KEY2"} {
+		if err := s.RegisterKey(requester, keyID, pk.Q.String(), 0, ""); err != nil {
+			t.Fatalf("RegisterKey failed. %s", err.Error())
+		}
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	if err := s.RotateKey(requester, "KEY1", "KEY2"); err != nil {
+		t.Fatalf("RotateKey failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx4")
+
+	stub.MockTransactionStart("tx5")
+	if _, err := s.RekeyResults(requester, "KEY1", "KEY2", rekeyToken.T1.ToString(), rekeyToken.T2.ToString()); err != nil {
+		t.Fatalf("RekeyResults failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx5")
+
+	lineage, err := s.GetResultLineage(requester, "RESULT-PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("GetResultLineage failed. %s", err.Error())
+	}
+
+	if lineage.ProposalID != "PROPOSAL0" || lineage.CohortHash != hashString("PATIENT0,PATIENT1") || lineage.PatientsIDs != nil {
+		t.Errorf("Expected the requester to get the hash of the cohort only, got %+v", lineage)
+	}
+
+	if len(lineage.Approvals) != 1 || lineage.Approvals[0].TxID != "tx2" || lineage.Executed.TxID != "tx3" {
+		t.Errorf("Expected the approval and execution transactions, got %+v %+v", lineage.Approvals, lineage.Executed)
+	}
+
+	if len(lineage.Tokens) != 2 || lineage.Tokens[0].FromKeyID != "KEY0" || lineage.Tokens[0].Submitted.TxID != "tx4" || lineage.Tokens[1].ToKeyID != "KEY2" || lineage.Tokens[1].Submitted.TxID != "tx5" {
+		t.Errorf("Expected the release and the rekeying of RESULT-PROPOSAL0, got %+v", lineage.Tokens)
+	}
+
+	if len(lineage.Keys) != 3 || lineage.Keys[0].Record != nil || lineage.Keys[2].Record == nil {
+		t.Errorf("Expected KEY0, KEY1 and KEY2 with the records of the registered ones, got %+v", lineage.Keys)
+	}
+
+	if lineage, err := s.GetResultLineage(custodian, "RESULT-PROPOSAL0"); err != nil || len(lineage.PatientsIDs) != 2 {
+		t.Errorf("Expected the custodian to get the patients of the cohort, got %+v %v", lineage, err)
+	}
+}
+
+func TestKeyUsage(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, value := range []int64{10, 20} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.RegisterKey(requester, "KEY1", pk.Q.String(), -1, ""); err == nil {
+		t.Errorf("Expected a negative usage limit to be refused")
+	}
+	for keyID, expiry := range map[string]string{"KEY1": "", "KEY2": "2000-01-01T00:00:00Z", "KEY3": ""} {
+		if err := s.RegisterKey(requester, keyID, pk.Q.String(), 1, expiry); err != nil {
+			t.Fatalf("RegisterKey failed. %s", err.Error())
+		}
+	}
+	if err := s.RotateKey(requester, "KEY3", "KEY1"); err != nil {
+		t.Fatalf("RotateKey failed. %s", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		id := fmt.Sprintf("PROPOSAL%d", i)
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", fmt.Sprintf("PATIENT%d", i), "KEY0", OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+		if err := s.ExecuteProposal(requester, id, pk.Q.String()); err != nil {
+			t.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+	}
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String())
+	stub.MockTransactionEnd("tx2")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists {
+		t.Errorf("Expected the result of PROPOSAL0 not to be released twice, got %v", err)
+	}
+
+	if proposal, _ := s.FindProposal(requester, "PROPOSAL0"); proposal.Released == nil || proposal.Released.MSPID != "Org1MSP" {
+		t.Errorf("Expected PROPOSAL0 to be marked released, got %+v", proposal.Released)
+	}
+
+	key, err := s.FindKey(requester, "KEY1")
+
+	if err != nil {
+		t.Fatalf("FindKey failed. %s", err.Error())
+	}
+
+	if key.Computations != 1 || key.Records != 1 {
+		t.Errorf("Expected KEY1 to be used once, got %d computations and %d records", key.Computations, key.Records)
+	}
+
+	for _, keyID := range []string{"KEY1", "KEY2", "KEY3"} {
+		stub.MockTransactionStart("tx3")
+		err := s.CreateResult(requester, "PROPOSAL1", token.T1.ToString(), token.T2.ToString(), keyID, pk.Q.String())
+		stub.MockTransactionEnd("tx3")
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidState {
+			t.Errorf("Expected %s to be refused as overused, expired or rotated, got %v", keyID, err)
+		}
+	}
+
+	stub.MockTransactionStart("tx4")
+	if err := s.CreateResult(requester, "PROPOSAL1", token.T1.ToString(), token.T2.ToString(), "KEY4", pk.Q.String()); err != nil {
+		t.Errorf("Expected keys not registered not to be limited, got %v", err)
+	}
+	stub.MockTransactionEnd("tx4")
+}
+
+func TestKeyUsageEntries(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	owner := newContext(stub, "researcher", "Org1MSP", nil)
+	ctx := new(CachingContext)
+	ctx.SetStub(&pagingStub{MockStub: stub})
+	ctx.SetClientIdentity(&mockIdentity{id: "researcher", mspID: "Org1MSP"})
+
+	stub.MockTransactionStart("tx1")
+	if err := s.RegisterKey(owner, "KEY1", "7", 2, ""); err != nil {
+		t.Fatalf("RegisterKey failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// The usage a transaction counts twice on a key is written in entries of its own
+	for i, usage := range [][2]int{{1, 1}, {0, 2}, {1, 0}} {
+		stub.MockTransactionStart(fmt.Sprintf("tx%d", 2+i/2))
+		if err := accountKeyUsage(ctx, "KEY1", usage[0], usage[1]); err != nil {
+			t.Fatalf("accountKeyUsage failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(fmt.Sprintf("tx%d", 2+i/2))
+	}
+
+	if stored, _ := findKeyRecord(owner, "KEY1"); stored.Computations != 0 || stored.Records != 0 {
+		t.Errorf("Expected the registry entry not to be written, got %+v", stored)
+	}
+
+	key, err := s.FindKey(owner, "KEY1")
+
+	if err != nil {
+		t.Fatalf("FindKey failed. %s", err.Error())
+	}
+
+	if key.Computations != 2 || key.Records != 3 {
+		t.Errorf("Expected 2 computations and 3 records, got %d and %d", key.Computations, key.Records)
+	}
+
+	if err := checkKeyUsable(owner, "KEY1"); err == nil {
+		t.Errorf("Expected KEY1 to be refused at its limit")
+	}
+
+	stub.MockTransactionStart("tx4")
+	if _, err := s.CompactKeyUsage(newContext(stub, "researcher", "Org2MSP", nil), "KEY1"); err == nil {
+		t.Errorf("Expected orgs other than the owner to be refused")
+	}
+	folded, err := s.CompactKeyUsage(owner, "KEY1")
+	stub.MockTransactionEnd("tx4")
+
+	if err != nil || folded != 4 {
+		t.Fatalf("Expected the 4 entries to be folded, got %d %v", folded, err)
+	}
+
+	if key, _ := s.FindKey(owner, "KEY1"); key.Computations != 2 || key.Records != 3 {
+		t.Errorf("Expected the usage to be kept by the compaction, got %+v", key)
+	}
+
+	if err := checkKeyUsable(owner, "KEY1"); err == nil {
+		t.Errorf("Expected KEY1 to stay at its limit once compacted")
+	}
+}
+
+func TestGetOrgStatistics(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", map[string]string{adminAttribute: "true"})
+	for i, value := range []int64{10, 20} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.RegisterKey(requester, "KEY1", pk.Q.String(), 10, ""); err != nil {
+		t.Fatalf("RegisterKey failed. %s", err.Error())
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.CreateProposal(requester, fmt.Sprintf("PROPOSAL%d", i), "PROTOCOL0", "Org1MSP", "Org2MSP", fmt.Sprintf("PATIENT%d", i), "KEY0", OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if _, err := s.GetOrgStatistics(newContext(stub, "researcher", "Org1MSP", nil), "Org1MSP"); err == nil {
+		t.Errorf("Expected callers other than admins to be refused")
+	}
+
+	statistics, err := s.GetOrgStatistics(requester, "Org1MSP")
+
+	if err != nil {
+		t.Fatalf("GetOrgStatistics failed. %s", err.Error())
+	}
+
+	if statistics.Patients != 0 || statistics.ActiveProposalsAsRequester != 1 || statistics.ReleasedResults != 1 {
+		t.Errorf("Expected 1 active proposal and 1 result for Org1MSP, got %+v", statistics)
+	}
+
+	if len(statistics.Keys) != 1 || statistics.Keys[0].KeyID != "KEY1" || statistics.Keys[0].Computations != 1 || statistics.Keys[0].UsageLimit != 10 {
+		t.Errorf("Expected the usage of KEY1, got %+v", statistics.Keys)
+	}
+
+	statistics, err = s.GetOrgStatistics(custodian, "Org2MSP")
+
+	if err != nil {
+		t.Fatalf("GetOrgStatistics failed. %s", err.Error())
+	}
+
+	if statistics.Patients != 2 || statistics.ActiveProposalsAsRequested != 1 || statistics.ActiveProposalsAsRequester != 0 {
+		t.Errorf("Expected 2 patients and 1 active proposal requested of Org2MSP, got %+v", statistics)
+	}
+}
+
+func TestBlobChunks(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	value := phe.Encrypt(sk, pk, big.NewInt(42)).ToString()
+	ctx := newContext(stub, "clinician", "Org2MSP", nil)
+	ctx.SetStub(newBlobStub(&pagingStub{MockStub: stub}, 256, 128))
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(ctx, id, "Name", value, "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := ctx.GetStub().PutState("BLOB0", []byte(value)); err != nil {
+		t.Fatalf("PutState failed. %s", err.Error())
+	}
+	if err := ctx.GetStub().PutState("BLOB1", []byte(value)); err != nil {
+		t.Fatalf("PutState failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	raw, _ := stub.GetState("PATIENT0")
+
+	if !bytes.HasPrefix(raw, blobPrefix) {
+		t.Errorf("Expected PATIENT0 to be stored as a manifest, got %q", raw)
+	}
+
+	patient, err := s.FindPatient(ctx, "PATIENT0")
+
+	if err != nil {
+		t.Fatalf("FindPatient failed. %s", err.Error())
+	}
+
+	if patient.PreExistingConditions != value {
+		t.Errorf("Expected the ciphertext to be reassembled")
+	}
+
+	page, err := s.AllPatients(ctx, "PATIENT0", "PATIENT2", 0, "")
+
+	if err != nil {
+		t.Fatalf("AllPatients failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 2 || page.Records[1].Record.PreExistingConditions != value {
+		t.Errorf("Expected the patients of a range to be reassembled, got %+v", page.Records)
+	}
+
+	chunks := 0
+	it, _ := stub.GetStateByPartialCompositeKey(blobChunkIndex, []string{})
+	for it.HasNext() {
+		it.Next()
+		chunks++
+	}
+	it.Close()
+
+	if blob, err := ctx.GetStub().GetState("BLOB1"); err != nil || string(blob) != value {
+		t.Errorf("Expected BLOB1 to be reassembled, got %v", err)
+	}
+
+	// The chunks of BLOB0 and BLOB1 are shared
+	stub.MockTransactionStart("tx2")
+	if err := ctx.GetStub().PutState("BLOB2", []byte(value)); err != nil {
+		t.Fatalf("PutState failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	it, _ = stub.GetStateByPartialCompositeKey(blobChunkIndex, []string{})
+	for it.HasNext() {
+		it.Next()
+		chunks--
+	}
+	it.Close()
+
+	if chunks != 0 {
+		t.Errorf("Expected identical values to share their chunks")
+	}
+}
+
+func TestIdempotentRequests(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	ctx := newContext(stub, "clinician", "Org1MSP", nil)
+	ctx.SetStub(&pagingStub{MockStub: stub, transient: map[string][]byte{requestIDField: []byte("9b2f6c1e-request")}})
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(ctx, "PATIENT0", "Alice", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// A gateway retry after a timeout neither rewrites the patient nor counts it twice
+	stub.MockTransactionStart("tx2")
+	if err := s.CreatePatient(ctx, "PATIENT0", "Alice", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("Duplicate CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	patient, _ := s.FindPatient(ctx, "PATIENT0")
+
+	if patient.Metadata.Updated.TxID != "tx1" {
+		t.Errorf("Expected the duplicate to leave PATIENT0 as tx1 wrote it, got %s", patient.Metadata.Updated.TxID)
+	}
+
+	stub.MockTransactionStart("tx3")
+	err := s.CreatePatient(ctx, "PATIENT1", "Bob", "0", "D1", "S1", "KEY0")
+	stub.MockTransactionEnd("tx3")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists {
+		t.Errorf("Expected a request ID reused for other arguments to fail with ALREADY_EXISTS, got %v", err)
+	}
+
+	request, err := s.FindProcessedRequest(ctx, "9b2f6c1e-request")
+
+	if err != nil {
+		t.Fatalf("FindProcessedRequest failed. %s", err.Error())
+	}
+
+	if request.Function != "CreatePatient" || request.Processed.TxID != "tx1" {
+		t.Errorf("Unexpected processed request %+v", request)
+	}
+
+	// Other orgs have their own request IDs
+	stub.MockTransactionStart("tx4")
+	other := newContext(stub, "clinician", "Org2MSP", nil)
+	other.SetStub(ctx.GetStub())
+	if err := s.CreatePatient(other, "PATIENT2", "Carol", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient of Org2MSP failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx4")
+
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+
+	stub.MockTransactionStart("tx5")
+	report, err := s.PurgeExpiredRequests(admin)
+	stub.MockTransactionEnd("tx5")
+
+	if err != nil || report.Purged != 0 {
+		t.Fatalf("Expected no request to expire yet, got %+v, %v", report, err)
+	}
+
+	stub.MockTransactionStart("tx6")
+	stub.TxTimestamp.Seconds += int64(defaultRequestTTL/time.Second) + 1
+	report, err = s.PurgeExpiredRequests(admin)
+	stub.MockTransactionEnd("tx6")
+
+	if err != nil || report.Purged != 2 || report.Remaining {
+		t.Fatalf("Expected both requests to be purged, got %+v, %v", report, err)
+	}
+
+	if _, err := s.FindProcessedRequest(ctx, "9b2f6c1e-request"); err == nil {
+		t.Errorf("Expected the purged request to be forgotten")
+	}
+}
+
+func TestGuardianProxyConsent(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org1MSP", nil)
+	guardian := newContext(stub, "parent", "Org3MSP", nil)
+	stranger := newContext(stub, "stranger", "Org3MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(custodian, "PATIENT0", "Minor", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	now := time.Unix(stub.TxTimestamp.Seconds, 0).UTC()
+	validTo := now.Add(24 * time.Hour).Format(time.RFC3339)
+
+	stub.MockTransactionStart("tx2")
+	err := s.RegisterGuardian(stranger, "PATIENT0", "parent", RelationshipParent, PurposeResearch, "", validTo, "Birth certificate 1234")
+	stub.MockTransactionEnd("tx2")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected only the custodian to register guardians, got %v", err)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.RegisterGuardian(custodian, "PATIENT0", "parent", RelationshipParent, PurposeResearch, "", validTo, "Birth certificate 1234"); err != nil {
+		t.Fatalf("RegisterGuardian failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	for _, c := range []struct {
+		ctx      contractapi.TransactionContextInterface
+		purposes string
+	}{
+		{stranger, PurposeResearch},
+		{guardian, "RESEARCH,BILLING"},
+	} {
+		stub.MockTransactionStart("denied")
+		err := s.GrantProxyConsent(c.ctx, "CONSENT9", "PATIENT0", "Org2MSP", "terms", c.purposes, "")
+		stub.MockTransactionEnd("denied")
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+			t.Errorf("Expected the proxy consent for %s to be denied, got %v", c.purposes, err)
+		}
+	}
+
+	stub.MockTransactionStart("tx4")
+	if err := s.GrantProxyConsent(guardian, "CONSENT0", "PATIENT0", "Org2MSP", "terms", PurposeResearch, ""); err != nil {
+		t.Fatalf("GrantProxyConsent failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx4")
+
+	consent, _ := s.FindConsent(guardian, "CONSENT0")
+	receipt, _ := s.FindConsentReceipt(guardian, consent.ReceiptID)
+
+	if consent.Proxy == nil || consent.Proxy.GuardianID != "parent" || consent.Proxy.Relationship != RelationshipParent || consent.Proxy.VerificationSource != "Birth certificate 1234" {
+		t.Errorf("Expected the guardianship on the consent, got %+v", consent.Proxy)
+	}
+
+	if receipt.Proxy == nil || receipt.Proxy.GuardianID != "parent" {
+		t.Errorf("Expected the guardianship on the receipt, got %+v", receipt.Proxy)
+	}
+
+	stub.MockTransactionStart("tx5")
+	if err := s.RecordConsentDecision(guardian, "CONSENT0", ConsentActionGrant, "terms v2", PurposeResearch); err != nil {
+		t.Fatalf("RecordConsentDecision failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx5")
+
+	page, err := s.GetConsentDecisions(guardian, "CONSENT0", 0, "")
+
+	if err != nil || len(page.Records) != 1 || page.Records[0].Proxy == nil {
+		t.Errorf("Expected the decision of the guardian to record its guardianship, got %+v %v", page, err)
+	}
+
+	// The guardianship ends with its validity
+	stub.MockTransactionStart("tx6")
+	stub.TxTimestamp.Seconds += 2 * 24 * 60 * 60
+	err = s.GrantProxyConsent(guardian, "CONSENT1", "PATIENT0", "Org2MSP", "terms", PurposeResearch, "")
+	stub.MockTransactionEnd("tx6")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected a lapsed guardianship to be denied, got %v", err)
+	}
+
+	// Decisions are taken by the custodian and the guardians in force only
+	for _, c := range []struct {
+		name string
+		ctx  contractapi.TransactionContextInterface
+	}{{"stranger", stranger}, {"lapsed guardian", guardian}} {
+		stub.MockTransactionStart("undecided")
+		stub.TxTimestamp.Seconds += 2 * 24 * 60 * 60
+		err = s.RecordConsentDecision(c.ctx, "CONSENT0", ConsentActionRevoke, "", "")
+		stub.MockTransactionEnd("undecided")
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+			t.Errorf("Expected the decision of the %s to be denied, got %v", c.name, err)
+		}
+	}
+
+	stub.MockTransactionStart("decided")
+	if err := s.RecordConsentDecision(custodian, "CONSENT0", ConsentActionRevoke, "", ""); err != nil {
+		t.Fatalf("RecordConsentDecision failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("decided")
+
+	page, err = s.GetConsentDecisions(custodian, "CONSENT0", 0, "")
+
+	if err != nil || len(page.Records) != 2 || (page.Records[0].Proxy == nil) == (page.Records[1].Proxy == nil) {
+		t.Errorf("Expected the decision of the custodian next to that of the guardian, got %+v %v", page, err)
+	}
+
+	stub.MockTransactionStart("tx7")
+	if err := s.RevokeGuardian(custodian, "PATIENT0", "parent"); err != nil {
+		t.Fatalf("RevokeGuardian failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx7")
+
+	if g, err := s.FindGuardian(custodian, "PATIENT0", "parent"); err != nil || g.Status != GuardianRevoked {
+		t.Errorf("Expected the guardian to be revoked, got %+v %v", g, err)
+	}
+}
+
+func TestPayloadSchemas(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	ctx := newContext(stub, "clinician", "Org1MSP", nil)
+	sk, pk := phe.GenerateKeys(256)
+	ciphertext := phe.Encrypt(sk, pk, big.NewInt(10)).ToString()
+
+	patients := []PatientInput{
+		{ID: "PATIENT0", Name: "Alice", PreExistingConditions: ciphertext, DiagnosisID: "D1", StatusID: "S1", KeyID: "KEY0"},
+		{ID: "PATIENT1", Name: "Bob", PreExistingConditions: "10", DiagnosisID: "D1", StatusID: "S1"},
+	}
+
+	stub.MockTransactionStart("tx1")
+	err := s.CreatePatientsBatch(ctx, patients)
+	stub.MockTransactionEnd("tx1")
+
+	contractError, ok := err.(*ContractError)
+
+	if !ok || contractError.Code != CodeInvalidArgument || contractError.Details["pointer"] != "/1/keyID" || contractError.Details["violations"] != "2" {
+		t.Fatalf("Expected the batch to be refused at /1/keyID, got %v", err)
+	}
+
+	if _, err := s.FindPatient(ctx, "PATIENT0"); err == nil {
+		t.Errorf("Expected no patient of a refused batch to be created")
+	}
+
+	consents := []ConsentInput{{ID: "CONSENT0", PatientID: "PATIENT0", GranteeMSP: "Org2MSP", Terms: "terms", Purposes: PurposeResearch, Expiry: "tomorrow"}}
+
+	stub.MockTransactionStart("tx2")
+	err = s.GrantConsentsBatch(ctx, consents)
+	stub.MockTransactionEnd("tx2")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Details["pointer"] != "/0/expiry" {
+		t.Errorf("Expected the consent to be refused at /0/expiry, got %v", err)
+	}
+
+	draft := newContext(stub, "clinician", "Org1MSP", nil)
+	draft.SetStub(&pagingStub{MockStub: stub, transient: map[string][]byte{"patient": []byte(`{"id":"PATIENT2","diagnosis":"D1"}`)}})
+
+	stub.MockTransactionStart("tx3")
+	err = s.SaveDraftPatient(draft)
+	stub.MockTransactionEnd("tx3")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Details["pointer"] != "/diagnosis" {
+		t.Errorf("Expected the draft to be refused at /diagnosis, got %v", err)
+	}
+
+	violations, err := s.ValidatePayload(ctx, "patients", `[{"id":"PATIENT0","name":7}]`)
+
+	if err != nil {
+		t.Fatalf("ValidatePayload failed. %s", err.Error())
+	}
+
+	if len(violations) != 5 || violations[0].Pointer != "/0/diagnosisID" || violations[1].Pointer != "/0/keyID" {
+		t.Errorf("Expected every violation in order of pointer, got %v", violations)
+	}
+
+	if _, err := s.ValidatePayload(ctx, "templates", `{}`); err == nil {
+		t.Errorf("Expected an unknown schema to be refused")
+	}
+
+	if schemas, _ := s.GetPayloadSchemas(ctx); len(schemas) != 4 || schemas[0].Name != "consents" {
+		t.Errorf("Expected the 4 payload schemas, got %v", schemas)
+	}
+}
+
+func TestOutlierExclusion(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for i, value := range []int64{10, 20, 30, 1000} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2", "PATIENT3")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationSum, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateSumProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2,PATIENT3", "KEY0", "", ""); err != nil {
+		t.Fatalf("CreateSumProposal failed. %s", err.Error())
+	}
+	if err := s.FlagOutlier(requester, "PROPOSAL0", "PATIENT3", "implausible value"); err == nil {
+		t.Errorf("Expected only the custodian to flag outliers")
+	}
+	if err := s.FlagOutlier(custodian, "PROPOSAL0", "PATIENT3", "implausible value"); err != nil {
+		t.Fatalf("FlagOutlier failed. %s", err.Error())
+	}
+	if err := s.FlagOutlier(custodian, "PROPOSAL0", "PATIENT3", "implausible value"); err == nil {
+		t.Errorf("Expected a patient to be flagged once")
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+	m := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Value))
+
+	if m.Cmp(big.NewRat(60, 1)) != 0 {
+		t.Errorf("Expected the sum of the patients but the outlier to be 60, got %s", m.String())
+	}
+
+	if proposal.ExclusionsHash != exclusionsHash([]Exclusion{{PatientID: "PATIENT3", Reason: "implausible value"}}) {
+		t.Errorf("Unexpected exclusions hash %s", proposal.ExclusionsHash)
+	}
+
+	page, _ := s.GetMyNotifications(requester, false, 0, "")
+	found := false
+	for _, notification := range page.Records {
+		found = found || (notification.Type == NotificationExclusionsAwaitingAcknowledgment && notification.Subject == "PROPOSAL0")
+	}
+	if !found {
+		t.Errorf("Expected the requester to be notified of the exclusions, got %+v", page.Records)
+	}
+
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+
+	stub.MockTransactionStart("tx2")
+	err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY0", pk.Q.String())
+	stub.MockTransactionEnd("tx2")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidState {
+		t.Errorf("Expected the result to wait for the acknowledgment, got %v", err)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.AcknowledgeExclusions(requester, "PROPOSAL0", hashString("[]")); err == nil {
+		t.Errorf("Expected an acknowledgment of other exclusions to be refused")
+	}
+	if err := s.AcknowledgeExclusions(custodian, "PROPOSAL0", proposal.ExclusionsHash); err == nil {
+		t.Errorf("Expected only the requester to acknowledge the exclusions")
+	}
+	if err := s.AcknowledgeExclusions(requester, "PROPOSAL0", proposal.ExclusionsHash); err != nil {
+		t.Fatalf("AcknowledgeExclusions failed. %s", err.Error())
+	}
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY0", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	result, _ := s.FindResult(requester, "RESULT-PROPOSAL0")
+
+	if result.Manifest.CohortSize != 3 || result.Manifest.Excluded != 1 || result.Manifest.ExclusionsHash != proposal.ExclusionsHash {
+		t.Errorf("Unexpected manifest %+v", result.Manifest)
+	}
+}
+
+func TestAuditSample(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	auditor := newContext(stub, "auditor", "Org3MSP", nil)
+	blockHash := hashString("block 42")
+
+	stub.MockTransactionStart("tx1")
+	for i := 0; i < 4; i++ {
+		if err := s.CreatePatient(admin, fmt.Sprintf("PATIENT%d", i), "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	for i := 0; i < 4; i++ {
+		if err := s.CreateProposal(requester, fmt.Sprintf("PROPOSAL%d", i), "PROTOCOL0", "Org1MSP", "Org2MSP", fmt.Sprintf("PATIENT%d", i), "KEY0", OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+	}
+	period := time.Unix(stub.TxTimestamp.Seconds, 0).UTC().Format("2006-01")
+	if err := s.CloseAuditPeriod(admin, period, blockHash); err == nil {
+		t.Errorf("Expected a period to be closed once it is over")
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	stub.TxTimestamp.Seconds += 32 * 24 * 3600
+	if err := s.CloseAuditPeriod(admin, period, "not a hash"); err == nil {
+		t.Errorf("Expected a malformed block hash to be refused")
+	}
+	if err := s.CloseAuditPeriod(admin, period, blockHash); err != nil {
+		t.Fatalf("CloseAuditPeriod failed. %s", err.Error())
+	}
+	if _, err := s.SelectAuditSample(admin, period, 0.5); err == nil {
+		t.Errorf("Expected sampling to need an auditor org")
+	}
+	if err := s.SetAuditorMSP(admin, "Org3MSP"); err != nil {
+		t.Fatalf("SetAuditorMSP failed. %s", err.Error())
+	}
+	sample, err := s.SelectAuditSample(admin, period, 0.5)
+	if err != nil {
+		t.Fatalf("SelectAuditSample failed. %s", err.Error())
+	}
+	if _, err := s.SelectAuditSample(admin, period, 0.5); err == nil {
+		t.Errorf("Expected a period to be sampled once")
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if sample.Population != 4 || len(sample.Assignments) != 2 {
+		t.Fatalf("Expected 2 of 4 proposals to be sampled, got %+v", sample)
+	}
+
+	// The sample is the records ranking first by the hash of the seed and their ID
+	ranks := []string{}
+	for i := 0; i < 4; i++ {
+		ranks = append(ranks, sampleRank(blockHash, fmt.Sprintf("PROPOSAL%d", i)))
+	}
+	sort.Strings(ranks)
+	for i, assignment := range sample.Assignments {
+		if sampleRank(blockHash, assignment.RecordID) != ranks[i] || assignment.AuditorMSP != "Org3MSP" || assignment.Status != AuditPending {
+			t.Errorf("Unexpected assignment %+v", assignment)
+		}
+	}
+
+	page, _ := s.GetMyNotifications(auditor, false, 0, "")
+	if page.Count != 2 || page.Records[0].Type != NotificationAuditAssigned {
+		t.Errorf("Expected the auditor to be notified of 2 assignments, got %+v", page.Records)
+	}
+
+	recordID := sample.Assignments[0].RecordID
+
+	stub.MockTransactionStart("tx3")
+	if err := s.CompleteAuditAssignment(requester, period, recordID, "No finding"); err == nil {
+		t.Errorf("Expected only the auditor to complete an assignment")
+	}
+	if err := s.CompleteAuditAssignment(auditor, period, recordID, "No finding"); err != nil {
+		t.Fatalf("CompleteAuditAssignment failed. %s", err.Error())
+	}
+	if err := s.CompleteAuditAssignment(auditor, period, recordID, "No finding"); err == nil {
+		t.Errorf("Expected an assignment to be completed once")
+	}
+	stub.MockTransactionEnd("tx3")
+
+	assignments, err := s.GetAuditAssignments(auditor, period)
+	if err != nil {
+		t.Fatalf("GetAuditAssignments failed. %s", err.Error())
+	}
+
+	completed := 0
+	for _, assignment := range assignments {
+		if assignment.Status == AuditCompleted {
+			completed++
+		}
+	}
+	if len(assignments) != 2 || completed != 1 {
+		t.Errorf("Expected 1 of 2 assignments to be completed, got %+v", assignments)
+	}
+}
+
+func TestPatientTransfer(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	receiverSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(receiverSK), pk, pk)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+	receiver := newContext(stub, "clinician", "Org3MSP", nil)
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.InitiateTransfer(requester, "PATIENT0", "Org3MSP"); err == nil {
+		t.Errorf("Expected only the custodian to be able to transfer")
+	}
+	if err := s.InitiateTransfer(custodian, "PATIENT0", "Org3MSP"); err != nil {
+		t.Fatalf("InitiateTransfer failed. %s", err.Error())
+	}
+	if err := s.InitiateTransfer(custodian, "PATIENT0", "Org1MSP"); err == nil {
+		t.Errorf("Expected a patient to be transferred once at a time")
+	}
+	if err := s.InitiateTransfer(custodian, "PATIENT1", "Org3MSP"); err != nil {
+		t.Fatalf("InitiateTransfer failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.AcceptTransfer(requester, "PATIENT0", "KEY3", token.T1.ToString(), token.T2.ToString(), pk.Q.String()); err == nil {
+		t.Errorf("Expected only the receiving org to be able to accept")
+	}
+	if err := s.AcceptTransfer(receiver, "PATIENT0", "KEY3", token.T1.ToString(), token.T2.ToString(), pk.Q.String()); err != nil {
+		t.Fatalf("AcceptTransfer failed. %s", err.Error())
+	}
+	if err := s.CancelTransfer(receiver, "PATIENT1"); err != nil {
+		t.Fatalf("CancelTransfer failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	patient, err := s.FindPatient(receiver, "PATIENT0")
+
+	if err != nil {
+		t.Fatalf("FindPatient failed. %s", err.Error())
+	}
+
+	if patient.custodian() != "Org3MSP" || patient.KeyID != "KEY3" || patient.Transfer.Status != TransferAccepted {
+		t.Fatalf("Expected PATIENT0 to be in custody of Org3MSP under KEY3, got %+v", patient)
+	}
+
+	value := phe.Decrypt(cloneKey(receiverSK), pk, phe.StringToMultivector(patient.PreExistingConditions))
+
+	if value.Cmp(big.NewRat(7, 1)) != 0 {
+		t.Errorf("Expected the value to be decrypted with the receiving key, got %s", value.String())
+	}
+
+	if policy, _ := stub.GetStateValidationParameter("PATIENT0"); len(policy) == 0 {
+		t.Errorf("Expected PATIENT0 to be bound to the endorsement of Org3MSP")
+	}
+
+	page, err := s.GetMyOrgPatients(receiver, 0, "")
+
+	if err != nil {
+		t.Fatalf("GetMyOrgPatients failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 1 || page.Records[0].Key != "PATIENT0" {
+		t.Errorf("Expected PATIENT0 to be listed by Org3MSP, got %+v", page.Records)
+	}
+
+	patient, err = s.FindPatient(custodian, "PATIENT1")
+
+	if err != nil {
+		t.Fatalf("FindPatient failed. %s", err.Error())
+	}
+
+	if patient.custodian() != "Org2MSP" || patient.Transfer.Status != TransferCancelled {
+		t.Errorf("Expected PATIENT1 to stay with Org2MSP, got %+v", patient)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.InitiateTransfer(custodian, "PATIENT0", "Org1MSP"); err == nil {
+		t.Errorf("Expected the former custodian to be unable to transfer")
+	}
+	stub.MockTransactionEnd("tx3")
+}
+
+func TestCompressedQuery(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for i := 0; i < 20; i++ {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	decode := func(response *CompressedResponse) []byte {
+		payload, err := base64.StdEncoding.DecodeString(response.Payload)
+
+		if err != nil {
+			t.Fatalf("Expected a base64 payload. %s", err.Error())
+		}
+
+		if response.Encoding == EncodingGzip {
+			reader, err := gzip.NewReader(bytes.NewReader(payload))
+
+			if err != nil {
+				t.Fatalf("Expected a gzip payload. %s", err.Error())
+			}
+
+			payload, _ = io.ReadAll(reader)
+		}
+
+		return payload
+	}
+
+	page, err := s.GetMyOrgPatients(custodian, 0, "")
+
+	if err != nil {
+		t.Fatalf("GetMyOrgPatients failed. %s", err.Error())
+	}
+
+	expected, _ := json.Marshal(page)
+
+	response, err := s.CompressedQuery(custodian, EncodingGzip, "GetMyOrgPatients", `["0", ""]`)
+
+	if err != nil {
+		t.Fatalf("CompressedQuery failed. %s", err.Error())
+	}
+
+	if response.Encoding != EncodingGzip || len(response.Payload) >= len(expected) {
+		t.Errorf("Expected the listing to be gzipped below %d bytes, got %s of %d bytes", len(expected), response.Encoding, len(response.Payload))
+	}
+
+	if payload := decode(response); !bytes.Equal(payload, expected) {
+		t.Errorf("Expected the decompressed listing to match GetMyOrgPatients, got %s", payload)
+	}
+
+	response, err = s.CompressedQuery(custodian, EncodingGzip, "AllPatients", `["PATIENT0", "PATIENT1", "0", ""]`)
+
+	if err != nil {
+		t.Fatalf("CompressedQuery failed. %s", err.Error())
+	}
+
+	if response.Encoding != EncodingIdentity || !strings.Contains(string(decode(response)), "PATIENT0") {
+		t.Errorf("Expected a small listing to be returned as is, got %+v", response)
+	}
+
+	if _, err := s.CompressedQuery(custodian, "br", "GetMyOrgPatients", `["0", ""]`); err == nil {
+		t.Errorf("Expected unsupported encodings to be refused")
+	}
+
+	if _, err := s.CompressedQuery(custodian, EncodingGzip, "FindPatient", `["PATIENT0"]`); err == nil {
+		t.Errorf("Expected only listings to be compressed")
+	}
+
+	if _, err := s.CompressedQuery(custodian, EncodingGzip, "GetMyOrgPatients", `["0"]`); err == nil {
+		t.Errorf("Expected the arguments of the listing to be checked")
+	}
+}
+
+func TestResultEmbargo(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	outsider := newContext(stub, "researcher", "Org3MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", phe.Encrypt(sk, pk, big.NewInt(10)).ToString(), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	embargo := time.Unix(stub.TxTimestamp.Seconds, 0).UTC().Add(24 * time.Hour).Format(time.RFC3339)
+
+	stub.MockTransactionStart("tx2")
+	if err := s.SetResultEmbargo(custodian, "RESULT-PROPOSAL0", embargo); err == nil {
+		t.Errorf("Expected only the requester to be able to set the embargo")
+	}
+	if err := s.SetResultEmbargo(requester, "RESULT-PROPOSAL0", "tomorrow"); err == nil {
+		t.Errorf("Expected malformed embargoes to be refused")
+	}
+	if err := s.SetResultEmbargo(requester, "RESULT-PROPOSAL0", embargo); err != nil {
+		t.Fatalf("SetResultEmbargo failed. %s", err.Error())
+	}
+	if err := s.PublishAnonymizedResult(requester, "RESULT-PROPOSAL0", "", "", ""); err != nil {
+		t.Fatalf("PublishAnonymizedResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	for _, ctx := range []*contractapi.TransactionContext{requester, custodian} {
+		if _, err := s.FindResult(ctx, "RESULT-PROPOSAL0"); err != nil {
+			t.Errorf("Expected the parties to read the result under embargo. %s", err.Error())
+		}
+	}
+
+	if _, err := s.FindResult(outsider, "RESULT-PROPOSAL0"); err == nil {
+		t.Errorf("Expected other orgs to be refused the result under embargo")
+	}
+
+	if _, err := s.FindPublication(outsider, "PUBLICATION-RESULT-PROPOSAL0"); err == nil {
+		t.Errorf("Expected the publication to be withheld under embargo")
+	}
+
+	page, err := s.AllPublications(outsider, 0, "")
+
+	if err != nil {
+		t.Fatalf("AllPublications failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 0 {
+		t.Errorf("Expected the publication to be left out under embargo, got %+v", page.Records)
+	}
+
+	stub.TxTimestamp.Seconds += 2 * 24 * 60 * 60
+
+	if _, err := s.FindResult(outsider, "RESULT-PROPOSAL0"); err != nil {
+		t.Errorf("Expected the result to be readable once the embargo lifts. %s", err.Error())
+	}
+
+	page, err = s.AllPublications(outsider, 0, "")
+
+	if err != nil {
+		t.Fatalf("AllPublications failed. %s", err.Error())
+	}
+
+	if len(page.Records) != 1 || page.Records[0].Embargo != embargo {
+		t.Errorf("Expected the publication to be listed once the embargo lifts, got %+v", page.Records)
+	}
+
+	// A result published before publications took the whole result ID keeps its former one,
+	// as does a result numbered after its proposal before results took the whole proposal ID
+	legacy := &Publication{ID: "PUBLICATION0", Operation: OperationMean}
+	legacyAsBytes, _ := json.Marshal(legacy)
+	legacyKey, _ := stub.CreateCompositeKey(publicationIndex, []string{"PUBLICATION0"})
+
+	stub.MockTransactionStart("tx3")
+	_ = stub.PutState("RESULT0", stub.State["RESULT-PROPOSAL0"])
+	_ = stub.PutState(legacyKey, legacyAsBytes)
+	if err := s.SetResultEmbargo(requester, "RESULT0", ""); err != nil {
+		t.Fatalf("SetResultEmbargo failed. %s", err.Error())
+	}
+	err = s.PublishAnonymizedResult(requester, "RESULT0", "", "", "")
+	stub.MockTransactionEnd("tx3")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists || contractError.Details["id"] != "PUBLICATION0" {
+		t.Errorf("Expected RESULT0 to be published already as PUBLICATION0, got %v", err)
+	}
+
+	if publication, err := s.FindPublication(outsider, "PUBLICATION0"); err != nil || publication.Embargo != "" {
+		t.Errorf("Expected the embargo of the former publication to be lifted, got %+v %v", publication, err)
+	}
+}
+
+func TestIntegrityRepair(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1", "PATIENT2"} {
+		if err := s.CreatePatient(custodian, id, "Name", "7", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT2", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// Records deleted or written outside of the contract leave references dangling
+	stub.MockTransactionStart("tx2")
+	for _, id := range []string{"PATIENT1", "PATIENT2"} {
+		if err := stub.DelState(id); err != nil {
+			t.Fatalf("DelState failed. %s", err.Error())
+		}
+	}
+	resultAsBytes, _ := json.Marshal(Result{ProposalID: "PROPOSAL9", KeyID: "KEY0", Value: "1"})
+	if err := stub.PutState("RESULT9", resultAsBytes); err != nil {
+		t.Fatalf("PutState failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if _, err := s.CheckIntegrity(custodian); err == nil {
+		t.Errorf("Expected callers other than admins to be refused")
+	}
+
+	report, err := s.CheckIntegrity(admin)
+
+	if err != nil {
+		t.Fatalf("CheckIntegrity failed. %s", err.Error())
+	}
+
+	kinds := map[string]int{}
+
+	for _, reference := range report.DanglingReferences {
+		kinds[reference.Kind]++
+	}
+
+	if kinds[ReferenceProposalPatient] != 2 || kinds[ReferenceResultProposal] != 1 || kinds[ReferenceIndexEntry] == 0 {
+		t.Fatalf("Expected 2 proposals, 1 result and index entries to dangle, got %+v", report.DanglingReferences)
+	}
+
+	stub.MockTransactionStart("tx3")
+	repair, err := s.RepairIntegrity(admin)
+	stub.MockTransactionEnd("tx3")
+
+	if err != nil {
+		t.Fatalf("RepairIntegrity failed. %s", err.Error())
+	}
+
+	actions := map[string]string{}
+
+	for _, change := range repair.Repairs {
+		actions[change.Reference.Key] = change.Action
+	}
+
+	if actions["PROPOSAL0"] != RepairRemovedReference || actions["PROPOSAL1"] != RepairQuarantined || actions["RESULT9"] != RepairQuarantined {
+		t.Errorf("Expected PROPOSAL0 to be fixed and PROPOSAL1 and RESULT9 to be quarantined, got %+v", repair.Repairs)
+	}
+
+	proposal, err := s.FindProposal(requester, "PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("FindProposal failed. %s", err.Error())
+	}
+
+	if proposal.PatientsIDs != "PATIENT0" {
+		t.Errorf("Expected PATIENT1 to be dropped from the cohort, got %s", proposal.PatientsIDs)
+	}
+
+	if _, err := s.FindProposal(requester, "PROPOSAL1"); err == nil {
+		t.Errorf("Expected PROPOSAL1 to be moved out of the way")
+	}
+
+	quarantined, err := s.FindQuarantinedRecord(admin, "PROPOSAL1")
+
+	if err != nil {
+		t.Fatalf("FindQuarantinedRecord failed. %s", err.Error())
+	}
+
+	if !strings.Contains(quarantined.Value, "PATIENT2") || quarantined.Reason.MissingIDs[0] != "PATIENT2" {
+		t.Errorf("Expected PROPOSAL1 to be kept with the reason of its quarantine, got %+v", quarantined)
+	}
+
+	report, err = s.CheckIntegrity(admin)
+
+	if err != nil {
+		t.Fatalf("CheckIntegrity failed. %s", err.Error())
+	}
+
+	if len(report.DanglingReferences) != 0 {
+		t.Errorf("Expected no dangling reference after the repair, got %+v", report.DanglingReferences)
+	}
+}
+
+func TestMultiKeyProposal(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	otherSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(otherSK), cloneKey(sk), pk, pk)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	holder := newContext(stub, "clinician", "Org3MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.RegisterKey(holder, "KEY1", pk.Q.String(), 0, ""); err != nil {
+		t.Fatalf("RegisterKey failed. %s", err.Error())
+	}
+	for i, patient := range []struct {
+		sk    *phe.SecretKey
+		keyID string
+		value int64
+	}{{sk, "KEY0", 10}, {otherSK, "KEY1", 20}, {otherSK, "KEY1", 30}} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(patient.sk, pk, big.NewInt(patient.value)).ToString(), "D1", "S1", patient.keyID); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateMultiKeyProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2", "KEY0", ""); err != nil {
+		t.Fatalf("CreateMultiKeyProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, err := s.FindProposal(requester, "PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("FindProposal failed. %s", err.Error())
+	}
+
+	if len(proposal.Partials) != 2 || proposal.Partials[1].KeyID != "KEY1" || proposal.Partials[1].HolderMSP != "Org3MSP" || proposal.Partials[1].Patients != 2 {
+		t.Fatalf("Expected a partial under KEY0 and one of 2 patients under KEY1 held by Org3MSP, got %+v", proposal.Partials)
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.CombineKeyPartials(requester, "PROPOSAL0", pk.Q.String()); err == nil {
+		t.Errorf("Expected the partials to be combined once translated")
+	}
+	if err := s.SubmitKeyTranslation(custodian, "PROPOSAL0", "KEY1", token.T1.ToString(), token.T2.ToString(), pk.Q.String()); err == nil {
+		t.Errorf("Expected only the holder of KEY1 to translate its partial")
+	}
+	if err := s.SubmitKeyTranslation(holder, "PROPOSAL0", "KEY1", token.T1.ToString(), token.T2.ToString(), pk.Q.String()); err != nil {
+		t.Fatalf("SubmitKeyTranslation failed. %s", err.Error())
+	}
+	if err := s.CombineKeyPartials(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("CombineKeyPartials failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	proposal, err = s.FindProposal(requester, "PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("FindProposal failed. %s", err.Error())
+	}
+
+	if proposal.Status != ProposalExecuted {
+		t.Fatalf("Expected PROPOSAL0 to be executed, got %s", proposal.Status)
+	}
+
+	m := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Value))
+
+	if m.Cmp(big.NewRat(20, 1)) != 0 {
+		t.Errorf("Expected a mean of 20 under KEY0, got %s", m.String())
+	}
+}
+
+func TestPatientUpdatedEvent(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", "7", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.UpdatePatient(custodian, "PATIENT0", "Name", "8", "D2", "S1", "KEY0"); err != nil {
+		t.Fatalf("UpdatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.SetPatientMeasurement(custodian, "PATIENT0", "weight", "70"); err != nil {
+		t.Fatalf("SetPatientMeasurement failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	if err := s.UpdatePatient(custodian, "PATIENT0", "Name", "8", "D2", "S1", "KEY0"); err != nil {
+		t.Fatalf("UpdatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx4")
+
+	entries, err := s.GetEventsSince(custodian, "", 10)
+
+	if err != nil {
+		t.Fatalf("GetEventsSince failed. %s", err.Error())
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("Expected an event for each update changing fields, got %+v", entries)
+	}
+
+	event := new(PatientUpdatedEvent)
+	_ = json.Unmarshal([]byte(entries[0].Payload), event)
+
+	expected := []FieldChange{
+		{Field: FieldDiagnosisID, OldHash: hashString("D1"), NewHash: hashString("D2")},
+		{Field: FieldPreExistingConditions, OldHash: hashString("7"), NewHash: hashString("8")},
+	}
+
+	if entries[0].Name != EventPatientUpdated || event.PatientID != "PATIENT0" || fmt.Sprint(event.Changes) != fmt.Sprint(expected) {
+		t.Errorf("Expected the changes of diagnosisID and preExistingConditions, got %+v", event)
+	}
+
+	if strings.Contains(entries[0].Payload, "D2") {
+		t.Errorf("Expected the event to carry hashes rather than values, got %s", entries[0].Payload)
+	}
+
+	event = new(PatientUpdatedEvent)
+	_ = json.Unmarshal([]byte(entries[1].Payload), event)
+
+	if len(event.Changes) != 1 || event.Changes[0].Field != "measurements.weight" || event.Changes[0].OldHash != "" {
+		t.Errorf("Expected a new measurement to have no old hash, got %+v", event.Changes)
+	}
+}
+
+func TestAccessRules(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+	clerk := newContext(stub, "clerk", "Org1MSP", nil)
+	other := newContext(stub, "other", "Org2MSP", map[string]string{roleAttribute: "researcher"})
+	if err := s.CreatePatient(admin, "PATIENT0", "Name", "7", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.SetAccessRule(clerk, "UpdatePatient", "", "", RelationshipCustodian); err == nil {
+		t.Errorf("Expected a non admin to be refused")
+	}
+	if err := s.SetAccessRule(admin, "NoSuchFunction", "", "Org1MSP", ""); err == nil {
+		t.Errorf("Expected a rule over an unknown function to be refused")
+	}
+	if err := s.SetAccessRule(admin, "UpdatePatient", "", "", "FRIEND"); err == nil {
+		t.Errorf("Expected an unknown relationship to be refused")
+	}
+	if err := s.SetAccessRule(admin, "UpdatePatient", "", "", RelationshipCustodian); err != nil {
+		t.Fatalf("SetAccessRule failed. %s", err.Error())
+	}
+	if err := s.SetAccessRule(admin, "AllPatients", roleAttribute+"=researcher", "Org2MSP", ""); err != nil {
+		t.Fatalf("SetAccessRule failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	if err := checkAccessRule(clerk, "UpdatePatient", []string{"PATIENT0", "Name"}); err != nil {
+		t.Errorf("Expected the custodian to be let through. %s", err.Error())
+	}
+	err := checkAccessRule(other, "UpdatePatient", []string{"PATIENT0", "Name"})
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected another org to be denied, got %v", err)
+	}
+	if err := checkAccessRule(other, "UpdatePatient", []string{"PATIENT9"}); err == nil {
+		t.Errorf("Expected a missing patient to relate to no org")
+	}
+	if err := checkAccessRule(other, "AllPatients", []string{"", "", "10", ""}); err != nil {
+		t.Errorf("Expected a researcher of Org2MSP to be let through. %s", err.Error())
+	}
+	if err := checkAccessRule(clerk, "AllPatients", []string{"", "", "10", ""}); err == nil {
+		t.Errorf("Expected a caller without the attribute to be denied")
+	}
+	if err := checkAccessRule(clerk, "FindPatient", []string{"PATIENT0"}); err != nil {
+		t.Errorf("Expected a function without a rule to be let through. %s", err.Error())
+	}
+	_, err = s.CompressedQuery(clerk, EncodingGzip, "AllPatients", `["","","10",""]`)
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected compression not to bypass the rule of the listing, got %v", err)
+	}
+
+	rules, err := s.GetAccessRules(clerk)
+	if err != nil {
+		t.Fatalf("GetAccessRules failed. %s", err.Error())
+	}
+	if len(rules) != 2 || rules["AllPatients"].Attributes[roleAttribute] != "researcher" || !contains(rules["UpdatePatient"].Relationships, RelationshipCustodian) {
+		t.Errorf("Expected the two rules, got %+v", rules)
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.SetAccessRule(admin, "UpdatePatient", "", "", ""); err != nil {
+		t.Fatalf("SetAccessRule failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if err := checkAccessRule(other, "UpdatePatient", []string{"PATIENT0"}); err != nil {
+		t.Errorf("Expected the removed rule to let every org through. %s", err.Error())
+	}
+	if err := checkAccessRule(other, "SetAccessRule", []string{"AllPatients"}); err != nil {
+		t.Errorf("Expected SetAccessRule to have no rule. %s", err.Error())
+	}
+}
+
+func TestDistinctDiagnosesProposal(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, diagnosisID := range []string{"D1", "D2", "D1", "D3"} {
+		id := fmt.Sprintf("PATIENT%d", i)
+		if err := s.CreatePatient(custodian, id, "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), diagnosisID, "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+		if err := s.SetPatientMeasurement(custodian, id, "enrolled", phe.Encrypt(sk, pk, big.NewInt(1)).ToString()); err != nil {
+			t.Fatalf("SetPatientMeasurement failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2", "PATIENT3")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationDistinctDiagnoses, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateDistinctDiagnosesProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2,PATIENT3", "KEY0", "", ""); err == nil {
+		t.Errorf("Expected a proposal without a flag to be refused")
+	}
+	if err := s.CreateDistinctDiagnosesProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2,PATIENT3", "KEY0", "enrolled", ""); err != nil {
+		t.Fatalf("CreateDistinctDiagnosesProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+
+	if proposal.DistinctDiagnoses != 3 || len(proposal.GroupSizes) != 0 {
+		t.Errorf("Expected 3 distinct diagnoses and no sizes in the clear, got %d and %v", proposal.DistinctDiagnoses, proposal.GroupSizes)
+	}
+	for diagnosisID, expected := range map[string]int64{"D1": 2, "D2": 1, "D3": 1} {
+		m := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Values[diagnosisID]))
+
+		if m.Cmp(big.NewRat(expected, 1)) != 0 {
+			t.Errorf("Expected %d patients with %s, got %s", expected, diagnosisID, m.String())
+		}
+	}
+	if !proposal.multiValued() {
+		t.Errorf("Expected the counts to be released as a result set")
+	}
+}
+
+func TestEmergencyContacts(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+	if err := s.CreatePatient(admin, "PATIENT0", "Name", "7", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+
+	withContact := func(id string, mspID string, role string, contact string) *contractapi.TransactionContext {
+		ctx := newContext(stub, id, mspID, map[string]string{roleAttribute: role})
+		ctx.SetStub(&pagingStub{MockStub: stub, transient: map[string][]byte{"contact": []byte(contact)}})
+
+		return ctx
+	}
+
+	clinician := withContact("clinician", "Org2MSP", clinicianRole, `{"id":"CONTACT0","name":"Ann","relationship":"sister","phone":"555-0100","nextOfKin":true}`)
+	if err := s.AddEmergencyContact(clinician, "PATIENT0"); err != nil {
+		t.Fatalf("AddEmergencyContact failed. %s", err.Error())
+	}
+	if err := s.AddEmergencyContact(clinician, "PATIENT0"); err == nil {
+		t.Errorf("Expected a contact with the same ID to be refused")
+	}
+	if err := s.AddEmergencyContact(withContact("clinician", "Org2MSP", clinicianRole, `{"id":"CONTACT1"}`), "PATIENT0"); err == nil {
+		t.Errorf("Expected a contact without a name to be refused")
+	}
+	if err := s.AddEmergencyContact(withContact("researcher", "Org2MSP", "researcher", `{"id":"CONTACT1","name":"Bob"}`), "PATIENT0"); err == nil {
+		t.Errorf("Expected a caller without the clinician role to be refused")
+	}
+	if err := s.AddEmergencyContact(withContact("clinician", "Org1MSP", clinicianRole, `{"id":"CONTACT1","name":"Bob"}`), "PATIENT0"); err == nil {
+		t.Errorf("Expected a clinician of another org to be refused")
+	}
+	if err := s.UpdateEmergencyContact(withContact("clinician", "Org2MSP", clinicianRole, `{"id":"CONTACT0","name":"Ann","phone":"555-0199"}`), "PATIENT0"); err != nil {
+		t.Fatalf("UpdateEmergencyContact failed. %s", err.Error())
+	}
+	if err := s.UpdateEmergencyContact(withContact("clinician", "Org2MSP", clinicianRole, `{"id":"CONTACT9","name":"Eve"}`), "PATIENT0"); err == nil {
+		t.Errorf("Expected an unknown contact not to be updated")
+	}
+	stub.MockTransactionEnd("tx1")
+
+	if stub.State[emergencyContactIndex] != nil || len(stub.PvtState["_implicit_org_Org2MSP"]) != 1 {
+		t.Errorf("Expected the contacts to be kept in the implicit collection of the custodian only")
+	}
+
+	contacts, err := s.GetEmergencyContacts(clinician, "PATIENT0")
+	if err != nil {
+		t.Fatalf("GetEmergencyContacts failed. %s", err.Error())
+	}
+	if len(contacts.Contacts) != 1 || contacts.Contacts[0].Phone != "555-0199" || contacts.Contacts[0].NextOfKin {
+		t.Errorf("Expected the updated contact, got %+v", contacts.Contacts)
+	}
+	if _, err := s.GetEmergencyContacts(admin, "PATIENT0"); err == nil {
+		t.Errorf("Expected an admin without the clinician role to be refused")
+	}
+}
+
+func TestPublicHealthEmergency(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	governor := newContext(stub, "governor", "Org1MSP", map[string]string{adminAttribute: "true"})
+	for i, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", phe.Encrypt(sk, pk, big.NewInt(int64(10*(i+1)))).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	for id, cohort := range map[string]string{"PROPOSAL0": "PATIENT0,PATIENT1", "PROPOSAL1": "PATIENT1"} {
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", cohort, "KEY0", OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err == nil {
+		t.Errorf("Expected a patient without consent to be refused")
+	}
+
+	now := time.Unix(stub.TxTimestamp.Seconds, 0).UTC()
+	expiry := now.Add(24 * time.Hour).Format(time.RFC3339)
+	if err := s.DeclarePublicHealthEmergency(requester, "EMERGENCY0", OperationMean, "Outbreak", expiry); err == nil {
+		t.Errorf("Expected a non admin to be refused")
+	}
+	if err := s.DeclarePublicHealthEmergency(custodian, "EMERGENCY0", OperationMean, "Outbreak", now.Add(365*24*time.Hour).Format(time.RFC3339)); err == nil {
+		t.Errorf("Expected an emergency beyond 90 days to be refused")
+	}
+	if err := s.DeclarePublicHealthEmergency(custodian, "EMERGENCY0", OperationMean, "Outbreak", expiry); err != nil {
+		t.Fatalf("DeclarePublicHealthEmergency failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err == nil {
+		t.Errorf("Expected a declared emergency to need the approval of another org")
+	}
+	if err := s.ApprovePublicHealthEmergency(custodian, "EMERGENCY0"); err == nil {
+		t.Errorf("Expected the declaring org not to approve twice")
+	}
+	if err := s.ApprovePublicHealthEmergency(governor, "EMERGENCY0"); err != nil {
+		t.Fatalf("ApprovePublicHealthEmergency failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	emergency, _ := s.FindPublicHealthEmergency(requester, "EMERGENCY0")
+	if emergency.Status != EmergencyActive || len(emergency.Approvals) != 2 {
+		t.Errorf("Expected an emergency approved by two orgs, got %+v", emergency)
+	}
+
+	uses, err := s.GetEmergencyUses(custodian, "EMERGENCY0", 0, "")
+	if err != nil {
+		t.Fatalf("GetEmergencyUses failed. %s", err.Error())
+	}
+	if len(uses.Records) != 1 || uses.Records[0].ProposalID != "PROPOSAL0" || strings.Join(uses.Records[0].PatientsIDs, ",") != "PATIENT1" {
+		t.Errorf("Expected the consentless access to PATIENT1 to be logged, got %+v", uses.Records)
+	}
+
+	notifications, _ := s.GetMyNotifications(custodian, false, 0, "")
+	found := false
+	for _, notification := range notifications.Records {
+		found = found || notification.Type == NotificationConsentlessAccess
+	}
+	if !found {
+		t.Errorf("Expected the custodian to be notified of the consentless access")
+	}
+
+	log, _ := s.GetAccessLog(custodian, "PATIENT1", 0, "")
+	if len(log.Records) != 1 || log.Records[0].Purpose != PurposePublicHealth {
+		t.Errorf("Expected the access to be logged for public health, got %+v", log.Records)
+	}
+
+	stub.MockTransactionStart("tx2")
+	stub.TxTimestamp.Seconds += 2 * 24 * 60 * 60
+	if err := s.ExecuteProposal(requester, "PROPOSAL1", pk.Q.String()); err == nil {
+		t.Errorf("Expected the expired emergency not to let the patient without consent through")
+	}
+	if emergency, _ := s.FindPublicHealthEmergency(requester, "EMERGENCY0"); emergency.Status != EmergencyExpired {
+		t.Errorf("Expected the emergency to expire, got %s", emergency.Status)
+	}
+	if err := s.EndPublicHealthEmergency(custodian, "EMERGENCY0"); err == nil {
+		t.Errorf("Expected an expired emergency not to be ended")
+	}
+	stub.MockTransactionEnd("tx2")
+}
+
+func TestPromoteDraft(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	clinician := newContext(stub, "clinician", "Org1MSP", nil)
+	sk, pk := phe.GenerateKeys(256)
+	c := phe.Encrypt(sk, pk, big.NewInt(7)).ToString()
+	drafts := map[string]string{
+		"PATIENT0": `{"id":"PATIENT0","name":"Name","preExistingConditions":"` + c + `","diagnosisID":"D1","statusID":"S1","keyID":"KEY0"}`,
+		"PATIENT1": `{"id":"PATIENT1","preExistingConditions":"` + c + `","diagnosisID":"D1","statusID":"S1","keyID":"KEY0"}`,
+		"PATIENT2": `{"id":"PATIENT2","name":"Name","preExistingConditions":"seven","diagnosisID":"D1","statusID":"S1","keyID":"KEY0"}`,
+		"PATIENT3": `{"id":"PATIENT3","name":"Name","preExistingConditions":"` + c + `","diagnosisID":"D1","statusID":"S1","keyID":"KEY0"}`,
+	}
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1", "PATIENT2", "PATIENT3"} {
+		ctx := newContext(stub, "clinician", "Org1MSP", nil)
+		ctx.SetStub(&pagingStub{MockStub: stub, transient: map[string][]byte{"patient": []byte(drafts[id])}})
+		if err := s.SaveDraftPatient(ctx); err != nil {
+			t.Fatalf("SaveDraftPatient failed. %s", err.Error())
+		}
+	}
+	if err := s.CreatePatient(newContext(stub, "clinician", "Org2MSP", nil), "PATIENT3", "Other", c, "D2", "S2", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.PromoteDraft(clinician, "PATIENT0"); err != nil {
+		t.Fatalf("PromoteDraft failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	patient, err := findPatient(clinician, "PATIENT0")
+
+	if err != nil || patient.Name != "Name" || patient.custodian() != "Org1MSP" {
+		t.Errorf("Expected PATIENT0 to be published by Org1MSP, got %+v %v", patient, err)
+	}
+
+	if _, err := s.FindDraftPatient(clinician, "PATIENT0"); err == nil {
+		t.Errorf("Expected the promoted draft to be deleted")
+	}
+
+	for id, expected := range map[string]struct {
+		code  string
+		field string
+	}{"PATIENT1": {CodeInvalidArgument, "name"}, "PATIENT2": {CodeInvalidArgument, "preExistingConditions"}, "PATIENT3": {CodeAlreadyExists, ""}, "PATIENT4": {CodeNotFound, ""}} {
+		stub.MockTransactionStart("tx3")
+		err := s.PromoteDraft(clinician, id)
+		stub.MockTransactionEnd("tx3")
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != expected.code || contractError.Details["field"] != expected.field {
+			t.Errorf("Expected %s to be refused with %s, got %v", id, expected.code, err)
+		}
+	}
+
+	for _, id := range []string{"PATIENT1", "PATIENT2", "PATIENT3"} {
+		if _, err := s.FindDraftPatient(clinician, id); err != nil {
+			t.Errorf("Expected the draft of %s to be kept. %s", id, err.Error())
+		}
+	}
+
+	if patient, _ := findPatient(clinician, "PATIENT3"); patient.Name != "Other" || patient.custodian() != "Org2MSP" {
+		t.Errorf("Expected PATIENT3 of Org2MSP to be left as it was, got %+v", patient)
+	}
+}
+
+func TestAcknowledgeNotification(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sender := newContext(stub, "admin", "Org1MSP", nil)
+	recipient := newContext(stub, "admin", "Org2MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := notify(sender, "Org2MSP", NotificationKeyExpiring, "KEY0", "KEY0 expires"); err != nil {
+		t.Fatalf("notify failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	inbox, _ := s.GetMyNotifications(recipient, false, 0, "")
+	if len(inbox.Records) != 1 || inbox.Records[0].Acknowledged != nil {
+		t.Fatalf("Expected one notification awaiting acknowledgment, got %+v", inbox.Records)
+	}
+	id := inbox.Records[0].ID
+
+	stub.MockTransactionStart("tx2")
+	stub.TxTimestamp.Seconds += 60 * 60
+	err := s.AcknowledgeNotification(sender, id)
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeNotFound {
+		t.Errorf("Expected another org to find no such notification, got %v", err)
+	}
+	if err := s.AcknowledgeNotification(recipient, id); err != nil {
+		t.Fatalf("AcknowledgeNotification failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if inbox, _ := s.GetMyNotifications(recipient, false, 0, ""); len(inbox.Records) != 0 {
+		t.Errorf("Expected acknowledged notifications to be left out, got %+v", inbox.Records)
+	}
+
+	inbox, _ = s.GetMyNotifications(recipient, true, 0, "")
+	if len(inbox.Records) != 1 || inbox.Records[0].Acknowledged == nil || inbox.Records[0].Acknowledged.MSPID != "Org2MSP" || inbox.Records[0].Acknowledged.TxID != "tx2" {
+		t.Fatalf("Expected the notification to be acknowledged by Org2MSP in tx2, got %+v", inbox.Records)
+	}
+
+	entries, _ := stub.GetStateByPartialCompositeKey(notificationAgeIndex, []string{})
+	ages := []string{}
+	for entries.HasNext() {
+		entry, _ := entries.Next()
+		_, attributes, _ := stub.SplitCompositeKey(entry.Key)
+		ages = append(ages, attributes[0])
+	}
+	if len(ages) != 1 || ages[0] != inbox.Records[0].Acknowledged.Timestamp {
+		t.Errorf("Expected the TTL of the notification to run from its acknowledgment, got %v", ages)
+	}
+
+	stub.MockTransactionStart("tx3")
+	err = s.AcknowledgeNotification(recipient, id)
+	stub.MockTransactionEnd("tx3")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidState {
+		t.Errorf("Expected acknowledging twice to be refused with INVALID_STATE, got %v", err)
+	}
+
+	if inbox, _ := s.GetMyNotifications(recipient, true, 0, ""); inbox.Records[0].Acknowledged.TxID != "tx2" {
+		t.Errorf("Expected the first acknowledgment to be kept, got %+v", inbox.Records[0].Acknowledged)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	stub.MockTransactionStart("tx1")
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+	admin.SetStub(&pagingStub{MockStub: stub, transient: map[string][]byte{
+		"patient":      []byte(`{"id":"PATIENT0","name":"Name"}`),
+		requestIDField: []byte("request-0"),
+	}})
+	if err := s.SaveDraftPatient(admin); err != nil {
+		t.Fatalf("SaveDraftPatient failed. %s", err.Error())
+	}
+	if err := s.CreatePatient(admin, "PATIENT1", "Name", "7", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	for _, keyID := range []string{"KEY0", "KEY1"} {
+		if err := notify(admin, "Org2MSP", NotificationKeyExpiring, keyID, keyID+" expires"); err != nil {
+			t.Fatalf("notify failed. %s", err.Error())
+		}
+	}
+	if err := s.SetHousekeepingTTL(admin, "CONSENT", 60); err == nil {
+		t.Errorf("Expected an unknown type to be refused")
+	}
+	if err := s.SetHousekeepingTTL(admin, HousekeepingNotification, 3600); err != nil {
+		t.Fatalf("SetHousekeepingTTL failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// The TTL of an acknowledged notification runs from its acknowledgment
+	recipient := newContext(stub, "admin", "Org2MSP", nil)
+	inbox, _ := s.GetMyNotifications(recipient, false, 0, "")
+	stub.MockTransactionStart("tx1b")
+	stub.TxTimestamp.Seconds += 90 * 60
+	for _, notification := range inbox.Records {
+		if notification.Subject == "KEY1" {
+			if err := s.AcknowledgeNotification(recipient, notification.ID); err != nil {
+				t.Fatalf("AcknowledgeNotification failed. %s", err.Error())
+			}
+		}
+	}
+	stub.MockTransactionEnd("tx1b")
+
+	counts := func(report *HousekeepingReport) map[string]int {
+		deleted := map[string]int{}
+		for _, count := range report.Counts {
+			deleted[count.Type] = count.Deleted
+		}
+		return deleted
+	}
+
+	stub.MockTransactionStart("tx2")
+	stub.TxTimestamp.Seconds += 2 * 60 * 60
+	report, err := s.CollectGarbage(admin)
+	stub.MockTransactionEnd("tx2")
+
+	if err != nil {
+		t.Fatalf("CollectGarbage failed. %s", err.Error())
+	}
+	if deleted := counts(report); deleted[HousekeepingNotification] != 1 || deleted[HousekeepingDraft] != 0 || deleted[HousekeepingRequest] != 0 {
+		t.Errorf("Expected only the notification past its TTL to be deleted, got %+v", report.Counts)
+	}
+	if _, err := s.FindDraftPatient(admin, "PATIENT0"); err != nil {
+		t.Errorf("Expected the draft within its TTL to be kept. %s", err.Error())
+	}
+	if inbox, _ := s.GetMyNotifications(recipient, true, 0, ""); len(inbox.Records) != 1 || inbox.Records[0].Subject != "KEY1" {
+		t.Errorf("Expected the notification acknowledged within its TTL to be kept, got %+v", inbox.Records)
+	}
+
+	stub.MockTransactionStart("tx3")
+	stub.TxTimestamp.Seconds += 31 * 24 * 60 * 60
+	report, err = s.CollectGarbage(admin)
+	stub.MockTransactionEnd("tx3")
+
+	if err != nil {
+		t.Fatalf("CollectGarbage failed. %s", err.Error())
+	}
+	if deleted := counts(report); deleted[HousekeepingNotification] != 1 || deleted[HousekeepingDraft] != 1 || deleted[HousekeepingRequest] != 1 {
+		t.Errorf("Expected the notification, the draft and the request to be deleted, got %+v", report.Counts)
+	}
+	if entries, _ := stub.GetStateByPartialCompositeKey(notificationAgeIndex, []string{}); entries.HasNext() {
+		t.Errorf("Expected the age index of the notifications to be emptied")
+	}
+	if _, err := s.FindDraftPatient(admin, "PATIENT0"); err == nil {
+		t.Errorf("Expected the draft to be deleted")
+	}
+	if _, err := s.FindProcessedRequest(admin, "request-0"); err == nil {
+		t.Errorf("Expected the request to be deleted")
+	}
+	if _, err := findPatient(admin, "PATIENT1"); err != nil {
+		t.Errorf("Expected the patient to be kept. %s", err.Error())
+	}
+	if _, err := s.CollectGarbage(newContext(stub, "clerk", "Org1MSP", nil)); err == nil {
+		t.Errorf("Expected a non admin to be refused")
+	}
+}
+
+func TestDiffPatientVersions(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	history := map[string][]*queryresult.KeyModification{}
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+	auditor := newContext(stub, "auditor", "Org3MSP", map[string]string{roleAttribute: "auditor"})
+	auditor.SetStub(&pagingStub{MockStub: stub, history: history})
+	admin.SetStub(&pagingStub{MockStub: stub, history: history})
+
+	write := func(txID string, f func() error) {
+		stub.MockTransactionStart(txID)
+		if err := f(); err != nil {
+			t.Fatalf("%s failed. %s", txID, err.Error())
+		}
+		stub.MockTransactionEnd(txID)
+		history["PATIENT0"] = append(history["PATIENT0"], &queryresult.KeyModification{TxId: txID, Value: stub.State["PATIENT0"], Timestamp: stub.TxTimestamp})
+	}
+
+	write("tx1", func() error { return s.CreatePatient(admin, "PATIENT0", "Name", "7", "D1", "S1", "KEY0") })
+	write("tx2", func() error { return s.UpdatePatient(admin, "PATIENT0", "Other", "7", "D2", "S1", "KEY0") })
+	write("tx3", func() error { return s.SetPatientMeasurement(admin, "PATIENT0", "bmi", "22") })
+
+	diff, err := s.DiffPatientVersions(auditor, "PATIENT0", "tx1", "tx3")
+	if err != nil {
+		t.Fatalf("DiffPatientVersions failed. %s", err.Error())
+	}
+	expected := []FieldDiff{
+		{Field: FieldDiagnosisID, OldValue: "D1", NewValue: "D2"},
+		{Field: "measurements.bmi", NewValue: "22"},
+		{Field: FieldName, OldValue: "Name", NewValue: "Other"},
+	}
+	if fmt.Sprint(diff.Changes) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, diff.Changes)
+	}
+	if diff.From.TxID != "tx1" || diff.To.TxID != "tx3" || diff.To.Updated == nil || diff.To.Updated.TxID != "tx3" {
+		t.Errorf("Expected the versions of tx1 and tx3, got %+v and %+v", diff.From, diff.To)
+	}
+
+	if _, err := s.DiffPatientVersions(auditor, "PATIENT0", "tx1", "tx9"); err == nil {
+		t.Errorf("Expected a transaction that didn't write the patient to be refused")
+	}
+
+	stub.MockTransactionStart("tx4")
+	if err := s.SetReadPolicy(admin, "auditor", "diagnosisID,statusID,keyID"); err != nil {
+		t.Fatalf("SetReadPolicy failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx4")
+
+	diff, _ = s.DiffPatientVersions(auditor, "PATIENT0", "tx1", "tx2")
+	if len(diff.Changes) != 1 || diff.Changes[0].Field != FieldDiagnosisID || !contains(diff.Redacted, FieldName) || diff.To.Updated != nil {
+		t.Errorf("Expected the fields withheld by the read policy to be left out, got %+v", diff)
+	}
+}
+
+func TestRetrospectiveStudy(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	encrypt := func(m int64) string {
+		return phe.Encrypt(sk, pk, big.NewInt(m)).ToString()
+	}
+	history := map[string][]*queryresult.KeyModification{}
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	custodian.SetStub(&pagingStub{MockStub: stub, history: history})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	now := time.Now().UTC().Truncate(time.Second)
+	cutoff := now.Add(-2 * time.Hour).Format(time.RFC3339)
+
+	write := func(txID string, at time.Duration, ids []string, f func() error) {
+		stub.MockTransactionStart(txID)
+		stub.TxTimestamp.Seconds = now.Add(at).Unix()
+		if err := f(); err != nil {
+			t.Fatalf("%s failed. %s", txID, err.Error())
+		}
+		stub.MockTransactionEnd(txID)
+		for _, id := range ids {
+			history[id] = append(history[id], &queryresult.KeyModification{TxId: txID, Value: stub.State[id], Timestamp: stub.TxTimestamp})
+		}
+	}
+
+	original := encrypt(10)
+	write("tx1", -3*time.Hour, []string{"PATIENT0", "PATIENT1"}, func() error {
+		if err := s.CreatePatient(custodian, "PATIENT0", "Name", original, "D1", "S1", "KEY0"); err != nil {
+			return err
+		}
+		if err := s.CreatePatient(custodian, "PATIENT1", "Name", encrypt(20), "D1", "S1", "KEY0"); err != nil {
+			return err
+		}
+		grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+		return s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0)
+	})
+	write("tx2", -time.Hour, []string{"PATIENT0"}, func() error {
+		return s.UpdatePatient(custodian, "PATIENT0", "Name", encrypt(30), "D1", "S1", "KEY0")
+	})
+	write("tx2b", -30*time.Minute, []string{"PATIENT0"}, func() error {
+		return s.UpdatePatient(custodian, "PATIENT0", "Name", encrypt(40), "D1", "S1", "KEY0")
+	})
+
+	// The history rebuilds the patient as it was at the cutoff
+	patient, err := s.GetPatientAsOf(custodian, "PATIENT0", cutoff)
+
+	if err != nil || patient.PreExistingConditions != original {
+		t.Errorf("Expected PATIENT0 as it was before tx2, got %+v %v", patient, err)
+	}
+
+	_, err = s.GetPatientAsOf(custodian, "PATIENT0", now.Add(-4*time.Hour).Format(time.RFC3339))
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeNotFound {
+		t.Errorf("Expected PATIENT0 not to exist before tx1, got %v", err)
+	}
+
+	if _, err := s.GetPatientAsOf(custodian, "PATIENT0", "yesterday"); err == nil {
+		t.Errorf("Expected a malformed timestamp to be refused")
+	}
+
+	stub.MockTransactionStart("tx3")
+	for i, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", fmt.Sprintf("KEY%d", i), OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx3")
+
+	for _, c := range []struct {
+		ctx    contractapi.TransactionContextInterface
+		cutoff string
+		code   string
+	}{
+		{custodian, cutoff, CodePermissionDenied},
+		{requester, now.Add(time.Hour).Format(time.RFC3339), CodeInvalidArgument},
+		{requester, "yesterday", CodeInvalidArgument},
+	} {
+		stub.MockTransactionStart("refused")
+		err := s.SetProposalCutoff(c.ctx, "PROPOSAL0", c.cutoff)
+		stub.MockTransactionEnd("refused")
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != c.code {
+			t.Errorf("Expected the cutoff %s to be refused with %s, got %v", c.cutoff, c.code, err)
+		}
+	}
+
+	stub.MockTransactionStart("tx4")
+	for _, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.SetProposalCutoff(requester, id, cutoff); err != nil {
+			t.Fatalf("SetProposalCutoff failed. %s", err.Error())
+		}
+	}
+	if err := s.SetProposalSkipIncomplete(requester, "PROPOSAL1", true); err != nil {
+		t.Fatalf("SetProposalSkipIncomplete failed. %s", err.Error())
+	}
+	for _, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx4")
+
+	// Executions never read the history: a patient changed since the cutoff is read from the
+	// version it kept at the cutoff
+	stub.MockTransactionStart("tx5")
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx5")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+	m := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Value))
+
+	if len(proposal.Skipped) != 0 || m.Cmp(big.NewRat(15, 1)) != 0 {
+		t.Errorf("Expected the mean of PATIENT0 and PATIENT1 at the cutoff, got %+v and %s", proposal.Skipped, m.String())
+	}
+
+	// A patient changed before versions were kept can't be read at the cutoff
+	stub.MockTransactionStart("tx6")
+	versions, _ := stub.GetStateByPartialCompositeKey(patientVersionIndex, []string{"PATIENT0"})
+	for versions.HasNext() {
+		version, _ := versions.Next()
+		_ = stub.DelState(version.Key)
+	}
+	versions.Close()
+	stub.MockTransactionEnd("tx6")
+
+	stub.MockTransactionStart("tx7")
+	if err := s.ExecuteProposal(requester, "PROPOSAL1", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx7")
+
+	proposal, _ = s.FindProposal(requester, "PROPOSAL1")
+	m = phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Value))
+
+	if len(proposal.Skipped) != 1 || proposal.Skipped[0] != (SkippedPatient{PatientID: "PATIENT0", Reason: SkipChangedSinceCutoff}) || m.Cmp(big.NewRat(20, 1)) != 0 {
+		t.Errorf("Expected PATIENT0 to be skipped and the mean of PATIENT1 at the cutoff, got %+v and %s", proposal.Skipped, m.String())
+	}
+
+	_, err = patientAtCutoff(requester, "PATIENT0", now.Add(-2*time.Hour))
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidState || contractError.Details["id"] != "PATIENT0" {
+		t.Errorf("Expected PATIENT0 without versions to be refused, got %v", err)
+	}
+}
+
+func TestCitationTokens(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	reader := newContext(stub, "reader", "Org3MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", phe.Encrypt(sk, pk, big.NewInt(10)).ToString(), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if _, err := s.GenerateCitationToken(custodian, "RESULT-PROPOSAL0"); err == nil {
+		t.Errorf("Expected only the requester to be able to cite the result")
+	}
+	citationToken, err := s.GenerateCitationToken(requester, "RESULT-PROPOSAL0")
+	if err != nil {
+		t.Fatalf("GenerateCitationToken failed. %s", err.Error())
+	}
+	again, _ := s.GenerateCitationToken(requester, "RESULT-PROPOSAL0")
+	stub.MockTransactionEnd("tx2")
+
+	if len(citationToken) != citationTokenLength || again != citationToken {
+		t.Errorf("Expected a stable token of %d digits, got %s and %s", citationTokenLength, citationToken, again)
+	}
+
+	citation, err := s.VerifyCitationToken(reader, citationToken)
+	if err != nil {
+		t.Fatalf("VerifyCitationToken failed. %s", err.Error())
+	}
+	if citation.ResultID != "RESULT-PROPOSAL0" || citation.Operation != OperationMean || citation.CohortSizeBand != "1-9" || citation.TxID != "tx1" {
+		t.Errorf("Expected the citation of RESULT-PROPOSAL0 created by tx1, got %+v", citation)
+	}
+
+	if _, err := s.VerifyCitationToken(reader, "0123456789abcdef0123"); err == nil {
+		t.Errorf("Expected an unknown token to be refused")
+	}
+
+	result := new(Result)
+	_ = json.Unmarshal(stub.State["RESULT-PROPOSAL0"], result)
+	result.Manifest.CohortSize = 40
+	stub.State["RESULT-PROPOSAL0"], _ = json.Marshal(result)
+
+	_, err = s.VerifyCitationToken(reader, citationToken)
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidState {
+		t.Errorf("Expected a result whose manifest changed to no longer match its token, got %v", err)
+	}
+}
+
+func TestContributionStatements(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", map[string]string{adminAttribute: "true"})
+	custodian.SetStub(&pagingStub{MockStub: stub})
+	requester.SetStub(&pagingStub{MockStub: stub})
+
+	stub.MockTransactionStart("tx1")
+	for _, pid := range []string{"PATIENT0", "PATIENT1", "PATIENT2"} {
+		if err := s.CreatePatient(custodian, pid, "Name", phe.Encrypt(sk, pk, big.NewInt(10)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	period := time.Unix(stub.TxTimestamp.Seconds, 0).UTC().Format("2006-01")
+	if err := s.GenerateContributionStatement(custodian, period); err == nil {
+		t.Errorf("Expected a period not yet over to be refused")
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	stub.TxTimestamp.Seconds += 40 * 24 * 60 * 60
+	if err := s.GenerateContributionStatement(newContext(stub, "clinician", "Org2MSP", nil), period); err == nil {
+		t.Errorf("Expected only admins to generate statements")
+	}
+	if err := s.GenerateContributionStatement(custodian, period); err != nil {
+		t.Fatalf("GenerateContributionStatement failed. %s", err.Error())
+	}
+	if err := s.GenerateContributionStatement(custodian, period); err == nil {
+		t.Errorf("Expected a statement to be generated once per period")
+	}
+	if err := s.GenerateContributionStatement(requester, period); err != nil {
+		t.Fatalf("GenerateContributionStatement failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	statement, err := s.GetContributionStatement(custodian, period)
+	if err != nil {
+		t.Fatalf("GetContributionStatement failed. %s", err.Error())
+	}
+	if statement.MSPID != "Org2MSP" || statement.Patients != 2 || len(statement.Studies) != 1 {
+		t.Fatalf("Expected two patients in one study, got %+v", statement)
+	}
+	study := statement.Studies[0]
+	if study.ProposalID != "PROPOSAL0" || study.ProtocolID != "PROTOCOL0" || study.Purpose != PurposeResearch || fmt.Sprint(study.PatientsIDs) != "[PATIENT0 PATIENT1]" || len(study.ConsentIDs) != 2 {
+		t.Errorf("Expected PATIENT0 and PATIENT1 in PROPOSAL0 under their consents, got %+v", study)
+	}
+
+	statement, err = s.GetContributionStatement(requester, period)
+	if err != nil {
+		t.Fatalf("GetContributionStatement failed. %s", err.Error())
+	}
+	if statement.MSPID != "Org1MSP" || statement.Patients != 0 || len(statement.Studies) != 0 {
+		t.Errorf("Expected other orgs to get only their own statement, got %+v", statement)
+	}
+
+	if _, err := s.GetContributionStatement(custodian, "2000-01"); err == nil {
+		t.Errorf("Expected a period without a statement to be refused")
+	}
+}
+
+func TestAgeBucketCounterEntries(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+
+	indicators := func(bucket int) string {
+		values := []string{}
+
+		for i := range ageBuckets {
+			indicator := int64(0)
+
+			if i == bucket {
+				indicator = 1
+			}
+
+			values = append(values, phe.Encrypt(sk, pk, big.NewInt(indicator)).ToString())
+		}
+
+		return strings.Join(values, ",")
+	}
+
+	entries := func() int {
+		n := 0
+
+		for key := range stub.State {
+			if strings.HasPrefix(key, "\x00"+ageCounterIndex) {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1", "PATIENT2"} {
+		if err := s.CreatePatient(custodian, id, "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	// Every transaction writes its own counter entry rather than updating a shared one
+	for i, id := range []string{"PATIENT0", "PATIENT1", "PATIENT2"} {
+		txID := fmt.Sprintf("tx%d", i+2)
+		stub.MockTransactionStart(txID)
+		stub.TxTimestamp.Seconds += int64(i * 60)
+		if err := s.SetPatientAgeBucket(custodian, id, phe.Encrypt(sk, pk, big.NewInt(30)).ToString(), indicators(i), pk.Q.String()); err != nil {
+			t.Fatalf("SetPatientAgeBucket failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(txID)
+	}
+
+	if entries() != 3 {
+		t.Errorf("Expected an entry per transaction, got %d", entries())
+	}
+
+	stub.MockTransactionStart("tx5")
+	folded, err := s.CompactAgeBucketCounts(custodian, "KEY0")
+	stub.MockTransactionEnd("tx5")
+
+	if err != nil {
+		t.Fatalf("CompactAgeBucketCounts failed. %s", err.Error())
+	}
+
+	if folded != 3 || entries() != 1 {
+		t.Errorf("Expected 3 entries to be folded into 1, got %d folded and %d left", folded, entries())
+	}
+
+	counts, err := s.GetAgeBucketCounts(custodian, "Org2MSP", "KEY0")
+
+	if err != nil {
+		t.Fatalf("GetAgeBucketCounts failed. %s", err.Error())
+	}
+
+	if counts.Patients != 3 || counts.Metadata.Created.TxID != "tx2" || counts.Metadata.Updated.TxID != "tx4" {
+		t.Errorf("Expected the counters of 3 patients set from tx2 to tx4, got %+v", counts)
+	}
+
+	for i, expected := range []int64{1, 1, 1, 0} {
+		count := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(counts.Counts[ageBuckets[i]]))
+
+		if count.Cmp(big.NewRat(expected, 1)) != 0 {
+			t.Errorf("Expected %d patients in %s, got %s", expected, ageBuckets[i], count.String())
+		}
+	}
+}
+
+func TestVerifyKeyBinding(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", nil)
+	outOfRange := fmt.Sprintf("%se0+0e1+0e2+0e3+0e12+0e13+0e23+0e123", new(big.Int).Add(pk.Q, big.NewInt(1)).String())
+
+	stub.MockTransactionStart("tx1")
+	if err := s.RegisterKey(custodian, "KEY0", pk.Q.String(), 0, ""); err != nil {
+		t.Fatalf("RegisterKey failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.CreatePatient(custodian, "PATIENT1", "Name", outOfRange, "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.CreatePatient(custodian, "PATIENT2", "Name", phe.Encrypt(sk, pk, big.NewInt(7)).ToString(), "D1", "S1", "KEY9"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	key, _ := s.FindKey(custodian, "KEY0")
+	patient, _ := s.FindPatient(custodian, "PATIENT0")
+
+	if key.Fingerprint == "" || patient.KeyFingerprint != key.Fingerprint {
+		t.Errorf("Expected the patient to record the fingerprint of its key, got %s and %s", patient.KeyFingerprint, key.Fingerprint)
+	}
+
+	binding, err := s.VerifyKeyBinding(custodian, "PATIENT0")
+	if err != nil {
+		t.Fatalf("VerifyKeyBinding failed. %s", err.Error())
+	}
+	if !binding.Bound || len(binding.Mismatches) != 0 {
+		t.Errorf("Expected PATIENT0 to be bound to KEY0, got %+v", binding)
+	}
+
+	binding, _ = s.VerifyKeyBinding(custodian, "PATIENT1")
+	if binding.Bound || fmt.Sprint(binding.Mismatches) != "["+FieldPreExistingConditions+"]" {
+		t.Errorf("Expected a value out of the range of the modulus of KEY0 to be caught, got %+v", binding)
+	}
+
+	// A record tagged with a key other than the one it was written under
+	patient.KeyFingerprint = keyFingerprint(new(big.Int).Add(pk.Q, big.NewInt(2)).String())
+	stub.State["PATIENT0"], _ = json.Marshal(patient)
+
+	binding, _ = s.VerifyKeyBinding(custodian, "PATIENT0")
+	if binding.Bound || fmt.Sprint(binding.Mismatches) != "[keyFingerprint]" {
+		t.Errorf("Expected the fingerprint of another key to be caught, got %+v", binding)
+	}
+
+	if _, err := s.VerifyKeyBinding(custodian, "PATIENT2"); err == nil {
+		t.Errorf("Expected records of unregistered keys to be refused")
+	}
+}
+
+func TestAgreementOperations(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for i, value := range []int64{10, 20, 30} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean+","+OperationCount, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.SetAgreementOperations(requester, "Org1MSP", OperationMean); err == nil {
+		t.Errorf("Expected agreements to be refused to callers other than admins")
+	}
+	if err := s.SetAgreementOperations(custodian, "Org1MSP", OperationMean); err != nil {
+		t.Fatalf("SetAgreementOperations failed. %s", err.Error())
+	}
+	if err := s.SetAgreementOperationTerms(custodian, "Org1MSP", OperationCount, 10, false); err == nil {
+		t.Errorf("Expected terms to be refused for operations the agreement doesn't allow")
+	}
+	if err := s.SetAgreementOperationTerms(custodian, "Org1MSP", OperationMean, 2, true); err != nil {
+		t.Fatalf("SetAgreementOperationTerms failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	err := s.CreateCountProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationCount, "smoker", "")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected an operation the agreement doesn't allow to be refused, got %v", err)
+	}
+	err = s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2", "KEY0", OperationMean, "")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected a cohort over the max of the agreement to be refused, got %v", err)
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	err = s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String())
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidState {
+		t.Errorf("Expected a proposal awaiting its noise to be held, got %v", err)
+	}
+	if err := s.SetProposalNoise(requester, "PROPOSAL0", phe.Encrypt(sk, pk, big.NewInt(0)).ToString()); err == nil {
+		t.Errorf("Expected only the requested org to add noise")
+	}
+	if err := s.SetProposalNoise(custodian, "PROPOSAL0", phe.Encrypt(sk, pk, big.NewInt(5)).ToString()); err != nil {
+		t.Fatalf("SetProposalNoise failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+	value := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(proposal.Value))
+
+	if !proposal.NoiseRequired || value.Cmp(big.NewRat(20, 1)) != 0 {
+		t.Errorf("Expected the mean of 15 with a noise of 5, got %s", value.String())
+	}
+}
+
+func TestPipelineProperties(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	keys, _ := phefake.NewKeys(48271)
+	requesterKeys, _ := phefake.NewKeys(69621)
+	token, _ := keys.TokenTo(requesterKeys)
+	first, second := token.Strings()
+	modulo := keys.Modulus().String()
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	runs := 0
+
+	stub.MockTransactionStart("tx0")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean+","+OperationSum, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx0")
+
+	// execute runs a proposal over patients of the values through the contract, and moves its
+	// value to the requester key when a result is requested
+	execute := func(operation string, values []int64, result bool) (*Proposal, *Result) {
+		runs++
+		id := fmt.Sprintf("PROPOSAL%d", runs)
+		pids := []string{}
+
+		stub.MockTransactionStart(fmt.Sprintf("tx%d", runs))
+		defer func() {
+			stub.MockTransactionEnd(fmt.Sprintf("tx%d", runs))
+
+			// The mock stub blocks once its event channel is full
+			for len(stub.ChaincodeEventsChannel) > 0 {
+				<-stub.ChaincodeEventsChannel
+			}
+		}()
+
+		for i, value := range values {
+			pid := fmt.Sprintf("PATIENT%d_%d", runs, i)
+			pids = append(pids, pid)
+
+			if err := s.CreatePatient(custodian, pid, "Name", keys.Encrypt(big.NewInt(value)).String(), "D1", "S1", "KEY0"); err != nil {
+				t.Fatalf("CreatePatient failed. %s", err.Error())
+			}
+		}
+		grantConsents(t, s, custodian, "Org1MSP", pids...)
+
+		var err error
+
+		if operation == OperationSum {
+			err = s.CreateSumProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", strings.Join(pids, ","), "KEY0", "", "")
+		} else {
+			err = s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", strings.Join(pids, ","), "KEY0", operation, "")
+		}
+		if err != nil {
+			t.Fatalf("Creating the proposal failed. %s", err.Error())
+		}
+		if err := s.ApproveProposal(custodian, id); err != nil {
+			t.Fatalf("ApproveProposal failed. %s", err.Error())
+		}
+		if err := s.ExecuteProposal(requester, id, modulo); err != nil {
+			t.Fatalf("ExecuteProposal failed. %s", err.Error())
+		}
+
+		proposal, _ := s.FindProposal(requester, id)
+
+		if !result {
+			return proposal, nil
+		}
+
+		if err := s.CreateResult(requester, id, first, second, "KEY1", modulo); err != nil {
+			t.Fatalf("CreateResult failed. %s", err.Error())
+		}
+
+		r, _ := s.FindResult(requester, fmt.Sprintf("RESULT-PROPOSAL%d", runs))
+
+		return proposal, r
+	}
+
+	decrypt := func(k phewrap.Keys, value string) *big.Rat {
+		c, _ := phewrap.ParseCiphertext(value)
+
+		return k.Decrypt(c)
+	}
+
+	sum := func(values []int64) int64 {
+		total := int64(0)
+
+		for _, value := range values {
+			total += value
+		}
+
+		return total
+	}
+
+	parameters := gopter.DefaultTestParameters()
+	parameters.MinSuccessfulTests = 20
+	parameters.MaxSize = 8
+	properties := gopter.NewProperties(parameters)
+	values := gen.SliceOf(gen.Int64Range(0, 1000)).SuchThat(func(values []int64) bool { return len(values) > 0 })
+
+	properties.Property("means decrypt to the mean of the values", prop.ForAll(func(values []int64) bool {
+		proposal, _ := execute(OperationMean, values, false)
+
+		return decrypt(keys, proposal.Value).Cmp(big.NewRat(sum(values), int64(len(values)))) == 0
+	}, values))
+
+	properties.Property("sums decrypt to the sum of the values", prop.ForAll(func(values []int64) bool {
+		proposal, _ := execute(OperationSum, values, false)
+
+		return decrypt(keys, proposal.Value).Cmp(big.NewRat(sum(values), 1)) == 0
+	}, values))
+
+	properties.Property("results decrypt under the requester key to the value of the proposal", prop.ForAll(func(values []int64) bool {
+		proposal, result := execute(OperationMean, values, true)
+
+		return decrypt(requesterKeys, result.Value).Cmp(decrypt(keys, proposal.Value)) == 0
+	}, values))
+
+	properties.TestingRun(t)
+}
+
+func TestResponseSLA(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.SetAgreementResponseSLA(custodian, "Org1MSP", -1); err == nil {
+		t.Errorf("Expected a negative SLA to be refused")
+	}
+	if err := s.SetAgreementResponseSLA(custodian, "Org1MSP", 7*24*3600); err != nil {
+		t.Fatalf("SetAgreementResponseSLA failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.CreatePatient(custodian, "PATIENT0", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	for i, id := range []string{"PROPOSAL0", "PROPOSAL1"} {
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", fmt.Sprintf("KEY%d", i), OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.ApproveProposal(custodian, "PROPOSAL1"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if overdue, _ := s.OverdueProposals(custodian); len(overdue) != 0 {
+		t.Errorf("Expected no proposal to be overdue within the SLA, got %v", overdue)
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	stub.TxTimestamp.Seconds += 8 * 24 * 3600
+	overdue, err := s.OverdueProposals(requester)
+	if err != nil {
+		t.Fatalf("OverdueProposals failed. %s", err.Error())
+	}
+	if len(overdue) != 1 || overdue[0].ProposalID != "PROPOSAL0" || overdue[0].Escalated {
+		t.Errorf("Expected PROPOSAL0 to be overdue, got %v", overdue)
+	}
+	report, err := s.EscalateOverdueProposals(requester)
+	if err != nil {
+		t.Fatalf("EscalateOverdueProposals failed. %s", err.Error())
+	}
+	if len(report.Escalated) != 1 || report.Escalated[0] != "PROPOSAL0" {
+		t.Errorf("Expected PROPOSAL0 to be escalated, got %v", report.Escalated)
+	}
+	stub.MockTransactionEnd("tx4")
+
+	stub.MockTransactionStart("tx5")
+	stub.TxTimestamp.Seconds += 8 * 24 * 3600
+	if report, _ := s.EscalateOverdueProposals(requester); len(report.Escalated) != 0 {
+		t.Errorf("Expected proposals to be escalated once, got %v", report.Escalated)
+	}
+	stub.MockTransactionEnd("tx5")
+
+	if overdue, _ := s.OverdueProposals(requester); len(overdue) != 1 || !overdue[0].Escalated {
+		t.Errorf("Expected PROPOSAL0 to remain overdue once escalated, got %v", overdue)
+	}
+	if agreement, _ := s.FindDataSharingAgreement(requester, "Org2MSP", "Org1MSP"); agreement.Breaches != 1 {
+		t.Errorf("Expected 1 breach of the agreement, got %d", agreement.Breaches)
+	}
+	for _, ctx := range []*contractapi.TransactionContext{custodian, requester} {
+		page, _ := s.GetMyNotifications(ctx, false, 0, "")
+		escalations := 0
+
+		for _, notification := range page.Records {
+			if notification.Type == NotificationResponseOverdue && notification.Subject == "PROPOSAL0" {
+				escalations++
+			}
+		}
+
+		if escalations != 1 {
+			t.Errorf("Expected both orgs to be notified of the breach once, got %d", escalations)
+		}
+	}
+}
+
+func TestCiphertextEncodings(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for i, e := range []phewrap.Encoding{phewrap.EncodingHex, phewrap.EncodingBase64URL, phewrap.EncodingDecimal} {
+		value := phewrap.Encrypt(sk, pk, big.NewInt(int64(10*(i+1)))).Encode(e)
+
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", value, "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+
+	if !strings.HasPrefix(proposal.Value, "hex:") {
+		t.Errorf("Expected the mean to keep the encoding of the first patient, got %s", proposal.Value)
+	}
+
+	decimal, err := s.ConvertCiphertext(requester, proposal.Value, "dec")
+	if err != nil {
+		t.Fatalf("ConvertCiphertext failed. %s", err.Error())
+	}
+	if value := phe.Decrypt(cloneKey(sk), pk, phe.StringToMultivector(decimal)); value.Cmp(big.NewRat(20, 1)) != 0 {
+		t.Errorf("Expected a mean of 20, got %s", value.String())
+	}
+	if _, err := s.ConvertCiphertext(requester, proposal.Value, "base32"); err == nil {
+		t.Errorf("Expected unknown encodings to be refused")
+	}
+}
+
+func TestDiagnosisTaxonomy(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.RegisterDiagnosis(requester, "J00-J99", "Diseases of the respiratory system", DiagnosisChapter, ""); err == nil {
+		t.Errorf("Expected diagnoses to be refused to callers other than admins")
+	}
+	for _, node := range [][4]string{
+		{"J00-J99", "Diseases of the respiratory system", DiagnosisChapter, ""},
+		{"I00-I99", "Diseases of the circulatory system", DiagnosisChapter, ""},
+		{"J09-J18", "Influenza and pneumonia", DiagnosisBlock, "J00-J99"},
+		{"I10-I16", "Hypertensive diseases", DiagnosisBlock, "I00-I99"},
+		{"J10", "Influenza due to other identified influenza virus", DiagnosisCode, "J09-J18"},
+		{"J10.1", "Influenza with other respiratory manifestations", DiagnosisCode, "J10"},
+		{"I10", "Essential hypertension", DiagnosisCode, "I10-I16"},
+	} {
+		if err := s.RegisterDiagnosis(custodian, node[0], node[1], node[2], node[3]); err != nil {
+			t.Fatalf("RegisterDiagnosis failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	for _, node := range [][3]string{{"J11", DiagnosisCode, ""}, {"J12", DiagnosisBlock, "J10"}, {"J10", DiagnosisCode, "J09-J18"}, {"J13", DiagnosisCode, "J99"}} {
+		if err := s.RegisterDiagnosis(custodian, node[0], "Name", node[1], node[2]); err == nil {
+			t.Errorf("Expected %s under %q to be refused", node[0], node[2])
+		}
+	}
+	for i, diagnosisID := range []string{"J10", "J10.1", "I10", "D1"} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", "0", diagnosisID, "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2", "PATIENT3")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "J09-J18", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	page, err := s.GetPatientsByDiagnosisSubtree(custodian, "J00-J99", 0, "")
+	if err != nil {
+		t.Fatalf("GetPatientsByDiagnosisSubtree failed. %s", err.Error())
+	}
+	if len(page.Records) != 2 || page.Records[0].Key != "PATIENT0" || page.Records[1].Key != "PATIENT1" {
+		t.Errorf("Expected the patients diagnosed with the codes of the chapter, got %v", page.Records)
+	}
+	_, err = s.GetPatientsByDiagnosisSubtree(custodian, "D1", 0, "")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeNotFound {
+		t.Errorf("Expected diagnoses outside the taxonomy to be unknown, got %v", err)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Errorf("Expected the scope of a block to cover its codes. %s", err.Error())
+	}
+	err = s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT2", "KEY0", OperationMean, "")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+		t.Errorf("Expected codes of another chapter to be out of scope, got %v", err)
+	}
+	stub.MockTransactionEnd("tx3")
+
+	sample, err := s.SampleCohort(requester, CohortSelector{CustodianMSP: "Org2MSP", DiagnosisIDs: "J00-J99,D1"}, 10, "seed")
+	if err != nil {
+		t.Fatalf("SampleCohort failed. %s", err.Error())
+	}
+	if sample.Selected != 3 {
+		t.Errorf("Expected the chapter and the code outside the taxonomy to select 3 patients, got %d", sample.Selected)
+	}
+}
+
+func TestPrivacyBudget(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for i, value := range []int64{10, 20, 30} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.SetPrivacyBudget(requester, 3); err == nil {
+		t.Errorf("Expected the channel's budget to be set by admins only")
+	}
+	if err := s.SetPrivacyBudget(custodian, 3); err != nil {
+		t.Fatalf("SetPrivacyBudget failed. %s", err.Error())
+	}
+	if err := s.SetConsentPrivacyBudget(requester, "CONSENT-PATIENT0", 1); err == nil {
+		t.Errorf("Expected the budget of a consent to be set by the custodian of its patient only")
+	}
+	if err := s.SetConsentPrivacyBudget(custodian, "CONSENT-PATIENT0", 1); err != nil {
+		t.Fatalf("SetConsentPrivacyBudget failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	for pid, expected := range map[string]int{"PATIENT0": 1, "PATIENT1": 3} {
+		budget, err := s.GetPrivacyBudget(requester, pid, "Org1MSP")
+
+		if err != nil {
+			t.Fatalf("GetPrivacyBudget failed. %s", err.Error())
+		}
+
+		if budget.Budget != expected || budget.Spent != 1 {
+			t.Errorf("Expected %s to have spent 1 of %d, got %d of %d", pid, expected, budget.Spent, budget.Budget)
+		}
+	}
+
+	stub.MockTransactionStart("tx4")
+	if err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL1"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.SetConsentPrivacyBudget(custodian, "CONSENT-PATIENT1", 1); err != nil {
+		t.Fatalf("SetConsentPrivacyBudget failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx4")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL1")
+
+	if proposal.PatientsIDs != "PATIENT1,PATIENT2" {
+		t.Errorf("Expected the patient who spent their budget to be left out, got %s", proposal.PatientsIDs)
+	}
+
+	stub.MockTransactionStart("tx5")
+	err := s.ExecuteProposal(requester, "PROPOSAL1", pk.Q.String())
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeQuotaExceeded {
+		t.Errorf("Expected a patient whose budget was lowered since to hold the execution, got %v", err)
+	}
+	stub.MockTransactionEnd("tx5")
+
+	stub.MockTransactionStart("tx6")
+	stub.TxTimestamp.Seconds += 31 * 24 * 60 * 60
+	if err := s.ExecuteProposal(requester, "PROPOSAL1", pk.Q.String()); err != nil {
+		t.Fatalf("Expected the budgets to reset the next month. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx6")
+
+	budget, _ := s.GetPrivacyBudget(requester, "PATIENT1", "Org1MSP")
+
+	if budget.Budget != 1 || budget.Spent != 1 {
+		t.Errorf("Expected PATIENT1 to have spent 1 of 1 in the new month, got %d of %d", budget.Spent, budget.Budget)
+	}
+}
+
+func TestChannelConfig(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+
+	channelIDs, _ := s.GetChannels(admin)
+
+	for _, channelID := range channelIDs {
+		stub.ChannelID = channelID
+		stub.MockTransactionStart("tx0")
+		// Every default of the descriptor must pass the checks of the setters
+		config, _ := s.GetChannelDefaults(admin)
+		for entity, pattern := range config.IDFormats {
+			if err := s.SetIDFormat(admin, entity, pattern); err != nil {
+				t.Errorf("Invalid ID format of %s in channels.json. %s", channelID, err.Error())
+			}
+		}
+		if err := s.SetQueryLimits(admin, config.DefaultPageSize, config.MaxResults); err != nil {
+			t.Errorf("Invalid query limits of %s in channels.json. %s", channelID, err.Error())
+		}
+		if config.StateDatabase != "" {
+			if err := s.SetStateDatabase(admin, config.StateDatabase); err != nil {
+				t.Errorf("Invalid state database of %s in channels.json. %s", channelID, err.Error())
+			}
+		}
+		stub.MockTransactionEnd("tx0")
+	}
+
+	stub = newStub(t)
+	stub.ChannelID = "eu-health"
+	admin = newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+
+	config, err := s.GetConfig(admin)
+
+	if err != nil {
+		t.Fatalf("GetConfig failed. %s", err.Error())
+	}
+
+	if config.PrivacyBudget != 12 || !config.StrictProofs || config.IDFormats[EntityPatient] == "" {
+		t.Errorf("Expected the defaults of eu-health, got %+v", config)
+	}
+
+	stub.MockTransactionStart("tx1")
+	err = s.CreatePatient(admin, "PATIENT0", "Alice", "0", "D1", "S1", "KEY0")
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidArgument {
+		t.Errorf("Expected the ID format of the channel to apply, got %v", err)
+	}
+	if err := s.SetPrivacyBudget(admin, 5); err != nil {
+		t.Fatalf("SetPrivacyBudget failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	config, _ = s.GetConfig(admin)
+
+	if config.PrivacyBudget != 5 || !config.StrictProofs {
+		t.Errorf("Expected the setting to be made over the defaults of the channel, got %+v", config)
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.ResetConfig(newContext(stub, "researcher", "Org1MSP", nil)); err == nil {
+		t.Errorf("Expected the configuration to be reset by admins only")
+	}
+	if err := s.ResetConfig(admin); err != nil {
+		t.Fatalf("ResetConfig failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	config, _ = s.GetConfig(admin)
+
+	if config.PrivacyBudget != 12 || config.Metadata.Created.TxID != "tx1" || config.Metadata.Updated.TxID != "tx2" {
+		t.Errorf("Expected the defaults of eu-health back, got %+v", config)
+	}
+
+	stub.ChannelID = "other"
+	stub.MockTransactionStart("tx3")
+	defaults, _ := s.GetChannelDefaults(admin)
+	stub.MockTransactionEnd("tx3")
+
+	if defaults.PrivacyBudget != 0 || len(defaults.IDFormats) != 0 {
+		t.Errorf("Expected no defaults for a channel the descriptor doesn't list, got %+v", defaults)
+	}
+}
+
+func TestDeletionProofs(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	notBefore, notAfter := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	ca, caPEM := newCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ca.org2"}, NotBefore: notBefore, NotAfter: notAfter, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, caKey, nil)
+	adminKeys := []*ecdsa.PrivateKey{}
+	adminPEMs := []string{}
+
+	for i := 0; i < 2; i++ {
+		adminKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		_, adminPEM := newCertificate(t, &x509.Certificate{SerialNumber: big.NewInt(int64(i + 2)), Subject: pkix.Name{CommonName: fmt.Sprintf("Admin%d@org2", i), OrganizationalUnit: []string{adminOU}}, NotBefore: notBefore, NotAfter: notAfter, KeyUsage: x509.KeyUsageDigitalSignature}, ca, adminKey, caKey)
+		adminKeys = append(adminKeys, adminKey)
+		adminPEMs = append(adminPEMs, adminPEM)
+	}
+
+	sign := func(key *ecdsa.PrivateKey, message []byte) string {
+		digest := sha256.Sum256(message)
+		sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	custodian := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+	custodian.SetStub(&pagingStub{MockStub: stub, transient: map[string][]byte{"key": []byte("contacts~PATIENT0")}})
+	auditor := newContext(stub, "auditor", "Org3MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.RegisterMSPRoot(custodian, "Org2MSP", caPEM); err != nil {
+		t.Fatalf("RegisterMSPRoot failed. %s", err.Error())
+	}
+	if err := stub.PutPrivateData("_implicit_org_Org2MSP", "contacts~PATIENT0", []byte(`{"name":"Bob"}`)); err != nil {
+		t.Fatalf("PutPrivateData failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.PurgePrivateData(newContext(stub, "clinician", "Org2MSP", nil), "DELETION0", "", 2); err == nil {
+		t.Errorf("Expected purges to be refused to callers other than admins")
+	}
+	if err := s.PurgePrivateData(custodian, "DELETION0", "_implicit_org_Org1MSP", 2); err == nil {
+		t.Errorf("Expected the implicit collection of another org to be refused")
+	}
+	if err := s.PurgePrivateData(custodian, "DELETION0", "", 2); err != nil {
+		t.Fatalf("PurgePrivateData failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if value, _ := stub.GetPrivateData("_implicit_org_Org2MSP", "contacts~PATIENT0"); value != nil {
+		t.Errorf("Expected the key to be purged, got %s", value)
+	}
+
+	proof, err := s.GetDeletionProof(auditor, "DELETION0")
+
+	if err != nil {
+		t.Fatalf("GetDeletionProof failed. %s", err.Error())
+	}
+
+	if proof.KeyHash != hashString("contacts~PATIENT0") || proof.ValueHash != hashString(`{"name":"Bob"}`) || proof.Purged.TxID != "tx2" || proof.Status != DeletionPending {
+		t.Errorf("Expected a pending proof of the purge in tx2, got %+v", proof)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.AttestDeletion(auditor, "DELETION0", adminPEMs[0], sign(adminKeys[0], []byte("PURGED\x00DELETION0"))); err == nil {
+		t.Errorf("Expected a signature over another message to be refused")
+	}
+	if err := s.AttestDeletion(auditor, "DELETION0", adminPEMs[0], sign(adminKeys[0], deletionMessage(proof))); err != nil {
+		t.Fatalf("AttestDeletion failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	err = s.AttestDeletion(auditor, "DELETION0", adminPEMs[0], sign(adminKeys[0], deletionMessage(proof)))
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists {
+		t.Errorf("Expected an admin to attest once, got %v", err)
+	}
+	stub.MockTransactionEnd("tx4")
+
+	if verified, _ := s.VerifyDeletionProof(auditor, "DELETION0"); verified {
+		t.Errorf("Expected a proof short of attestors not to verify")
+	}
+
+	stub.MockTransactionStart("tx5")
+	if err := s.AttestDeletion(auditor, "DELETION0", adminPEMs[1], sign(adminKeys[1], deletionMessage(proof))); err != nil {
+		t.Fatalf("AttestDeletion failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx5")
+
+	proof, _ = s.GetDeletionProof(auditor, "DELETION0")
+	verified, err := s.VerifyDeletionProof(auditor, "DELETION0")
+
+	if err != nil || !verified || proof.Status != DeletionVerified {
+		t.Errorf("Expected the proof to be verified by two admins, got %s and %v", proof.Status, err)
+	}
+}
+
+func TestFieldRetention(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1", "PATIENT2"} {
+		if err := s.CreatePatient(admin, id, "Name "+id, "7", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.SetFieldRetention(admin, FieldKeyID, RetentionFromCreation, 60); err == nil {
+		t.Errorf("Expected the key to be kept as long as the patient")
+	}
+	if err := s.SetFieldRetention(admin, FieldName, "ADMISSION", 60); err == nil {
+		t.Errorf("Expected an unknown anchor to be refused")
+	}
+	if err := s.SetFieldRetention(newContext(stub, "clerk", "Org1MSP", nil), FieldName, RetentionFromDischarge, 60); err == nil {
+		t.Errorf("Expected a non admin to be refused")
+	}
+	if err := s.SetFieldRetention(admin, FieldName, RetentionFromDischarge, 90*24*60*60); err != nil {
+		t.Fatalf("SetFieldRetention failed. %s", err.Error())
+	}
+	if err := s.SetFieldRetention(admin, FieldDiagnosisID, RetentionFromCreation, 10*365*24*60*60); err != nil {
+		t.Fatalf("SetFieldRetention failed. %s", err.Error())
+	}
+	if err := s.SetHousekeepingTTL(admin, HousekeepingField, 60); err == nil {
+		t.Errorf("Expected fields to be kept by retention rules rather than a TTL")
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.DischargePatient(newContext(stub, "admin", "Org2MSP", nil), "PATIENT0"); err == nil {
+		t.Errorf("Expected another org not to discharge the patient")
+	}
+	if err := s.DischargePatient(admin, "PATIENT0"); err != nil {
+		t.Fatalf("DischargePatient failed. %s", err.Error())
+	}
+	if err := s.DischargePatient(admin, "PATIENT1"); err != nil {
+		t.Fatalf("DischargePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	stub.MockTransactionStart("tx3")
+	if err := s.DischargePatient(admin, "PATIENT0"); err == nil {
+		t.Errorf("Expected a patient to be discharged once")
+	}
+	if err := s.FreezePatient(admin, "PATIENT1", "Litigation hold"); err != nil {
+		t.Fatalf("FreezePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	minimized := func(txID string, days int64) int {
+		stub.MockTransactionStart(txID)
+		stub.TxTimestamp.Seconds += days * 24 * 60 * 60
+		report, err := s.CollectGarbage(admin)
+		stub.MockTransactionEnd(txID)
+
+		if err != nil {
+			t.Fatalf("CollectGarbage failed. %s", err.Error())
+		}
+		for _, count := range report.Counts {
+			if count.Type == HousekeepingField {
+				return count.Deleted
+			}
+		}
+		return -1
+	}
+
+	if n := minimized("tx4", 0); n != 0 {
+		t.Errorf("Expected no field to be cleared within its retention, got %d", n)
+	}
+
+	if n := minimized("tx5", 91); n != 1 {
+		t.Errorf("Expected the name of the discharged patient to be cleared, got %d", n)
+	}
+
+	patient, _ := findPatient(admin, "PATIENT0")
+
+	if patient.Name != "" || patient.DiagnosisID != "D1" || patient.PreExistingConditions != "7" {
+		t.Errorf("Expected only the name to be cleared, got %+v", patient)
+	}
+
+	// The versions kept for study cutoffs lose the name too
+	versions, _ := stub.GetStateByPartialCompositeKey(patientVersionIndex, []string{"PATIENT0"})
+	kept := 0
+	for versions.HasNext() {
+		queryResponse, _ := versions.Next()
+		version := new(Patient)
+		_ = json.Unmarshal(queryResponse.Value, version)
+		if version.Name != "" || version.DiagnosisID != "D1" {
+			t.Errorf("Expected the name of the versions of PATIENT0 to be cleared, got %+v", version)
+		}
+		kept++
+	}
+	versions.Close()
+
+	if kept == 0 {
+		t.Errorf("Expected the version replaced by the minimization to be kept")
+	}
+	if patient, _ := findPatient(admin, "PATIENT1"); patient.Name != "Name PATIENT1" {
+		t.Errorf("Expected a frozen patient to be kept whole, got %+v", patient)
+	}
+	if patient, _ := findPatient(admin, "PATIENT2"); patient.Name != "Name PATIENT2" {
+		t.Errorf("Expected the name of a patient never discharged to be kept, got %+v", patient)
+	}
+
+	if n := minimized("tx6", 10*365); n != 2 {
+		t.Errorf("Expected the diagnoses of the patients not frozen to be cleared, got %d", n)
+	}
+
+	minimizations, err := s.GetMinimizations(admin, "PATIENT0")
+
+	if err != nil {
+		t.Fatalf("GetMinimizations failed. %s", err.Error())
+	}
+	if len(minimizations) != 2 || minimizations[0].Field != FieldName || minimizations[0].ValueHash != hashString("Name PATIENT0") || minimizations[0].Minimized.TxID != "tx5" || minimizations[1].Field != FieldDiagnosisID || minimizations[1].Rule.Anchor != RetentionFromCreation {
+		t.Errorf("Expected the name then the diagnosis to be logged, got %+v", minimizations)
+	}
+	if _, err := s.GetMinimizations(newContext(stub, "researcher", "Org2MSP", nil), "PATIENT0"); err == nil {
+		t.Errorf("Expected the log to be read by the custodian only")
+	}
+	if n := minimized("tx7", 10*365); n != 0 {
+		t.Errorf("Expected cleared fields not to be cleared again, got %d", n)
+	}
+}
+
+func TestReservedIDs(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+
+	stub.MockTransactionStart("tx1")
+	defer stub.MockTransactionEnd("tx1")
+
+	refused := []struct {
+		id       string
+		detail   string
+		expected string
+	}{
+		{"", "id", ""},
+		{"PATIENT\x000", "position", "7"},
+		{"PATIENT\U0010FFFF", "character", "U+10FFFF"},
+		{"PATIENT\n0", "character", "U+000A"},
+		{"PATIENT\xff", "id", "PATIENT\xff"},
+		{configKey, "reserved", configKey},
+		{shardConfigKey, "reserved", shardConfigKey},
+		{patientOrgIndex, "reserved", patientOrgIndex},
+		{"RESULT7", "reserved", "RESULT"},
+		{"RESULTSET7", "reserved", "RESULT"},
+		{"RECEIPT7", "reserved", "RECEIPT"},
+		{"COMPUTATION-PROPOSAL7", "reserved", "COMPUTATION"},
+		{"PUBLICATION7", "reserved", "PUBLICATION"},
+		{eventJournalPrefix + "000000000001~ProposalCreated", "reserved", eventJournalPrefix},
+	}
+
+	for _, r := range refused {
+		err := s.ValidateID(admin, EntityPatient, r.id)
+
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeInvalidArgument || contractError.Details[r.detail] != r.expected {
+			t.Errorf("Expected %q to be refused with %s %q, got %v", r.id, r.detail, r.expected, err)
+		}
+	}
+
+	if err := s.CreatePatient(admin, "RESULT7", "Name", "7", "D1", "S1", "KEY0"); err == nil {
+		t.Errorf("Expected a patient not to be created under a result ID")
+	}
+	if err := s.RegisterKey(admin, configKey, "7", 0, ""); err == nil {
+		t.Errorf("Expected a key not to be registered under the configuration key")
+	}
+	if err := s.DeclarePublicHealthEmergency(admin, eventSequenceKey, OperationMean, "Outbreak", ""); err == nil {
+		t.Errorf("Expected an emergency not to be declared under the event sequence key")
+	}
+	if err := s.RegisterDiagnosis(admin, "C\x0001", "Cholera", DiagnosisChapter, ""); err == nil {
+		t.Errorf("Expected a diagnosis ID holding U+0000 to be refused")
+	}
+	if err := s.DelegateApproval(admin, "DELEGATION\x00", "User1", "", ""); err == nil {
+		t.Errorf("Expected a delegation ID holding U+0000 to be refused")
+	}
+	for _, id := range []string{"PATIENT0", "RES7", "Patient-é", "CONFIG7"} {
+		if err := s.ValidateID(admin, EntityPatient, id); err != nil {
+			t.Errorf("Expected %q to be accepted. %s", id, err.Error())
+		}
+	}
+
+	// Entities share one namespace, an ID holding a record of any is refused
+	if err := s.CreatePatient(admin, "PATIENT0", "Name", "7", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	if err := s.RegisterStudyProtocol(admin, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	taken := map[string]error{
+		"CreateProposal":               s.CreateProposal(admin, "PATIENT0", "PROTOCOL0", "Org1MSP", "Org1MSP", "PATIENT0", "KEY0", OperationMean, ""),
+		"RegisterStudyProtocol":        s.RegisterStudyProtocol(admin, "PATIENT0", "Study", "IRB-0001", OperationMean, "", "", 0),
+		"DeclarePublicHealthEmergency": s.DeclarePublicHealthEmergency(admin, "PROTOCOL0", OperationMean, "Outbreak", ""),
+	}
+	for function, err := range taken {
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists {
+			t.Errorf("Expected %s to refuse an ID in use, got %v", function, err)
+		}
+	}
+	if patient, err := s.FindPatient(admin, "PATIENT0"); err != nil || patient.Name != "Name" {
+		t.Errorf("Expected PATIENT0 to be kept, got %+v %v", patient, err)
+	}
+}
+
+func TestProposalDecisionReasons(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	for id, patientsIDs := range map[string]string{"PROPOSAL0": "PATIENT0", "PROPOSAL1": "PATIENT1"} {
+		if err := s.CreateProposal(requester, id, "PROTOCOL0", "Org1MSP", "Org2MSP", patientsIDs, "KEY0", OperationMean, ""); err != nil {
+			t.Fatalf("CreateProposal failed. %s", err.Error())
+		}
+	}
+	if err := s.RejectProposal(requester, "PROPOSAL0", ReasonAmendCohort, "Too small"); err == nil {
+		t.Errorf("Expected the requester to be unable to reject")
+	}
+	if err := s.RejectProposal(custodian, "PROPOSAL0", ReasonNote, "Too small"); err == nil {
+		t.Errorf("Expected a rejection to need a rejection code")
+	}
+	if err := s.RejectProposal(custodian, "PROPOSAL0", ReasonAmendCohort, strings.Repeat("x", maxReasonText+1)); err == nil {
+		t.Errorf("Expected an over-long reason to be refused")
+	}
+	if err := s.RejectProposal(custodian, "PROPOSAL0", ReasonAmendCohort, "A cohort of one patient can be re-identified"); err != nil {
+		t.Fatalf("RejectProposal failed. %s", err.Error())
+	}
+	if err := s.RejectProposal(custodian, "PROPOSAL1", ReasonDeclined, "Out of our consent terms"); err != nil {
+		t.Fatalf("RejectProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+
+	if proposal.Status != ProposalRejected || proposal.Reason == nil || proposal.Reason.Code != ReasonAmendCohort || proposal.Reason.Decided.MSPID != "Org2MSP" {
+		t.Errorf("Expected the rejection and its reason to be stored, got %+v", proposal)
+	}
+
+	notifications := func() map[string]string {
+		page, err := s.GetMyNotifications(requester, false, 0, "")
+
+		if err != nil {
+			t.Fatalf("GetMyNotifications failed. %s", err.Error())
+		}
+
+		messages := map[string]string{}
+		for _, notification := range page.Records {
+			messages[notification.Type+" "+notification.Subject] = notification.Message
+		}
+		return messages
+	}
+
+	if message := notifications()[NotificationProposalRejected+" PROPOSAL0"]; !strings.Contains(message, "AMEND_COHORT: A cohort of one patient can be re-identified") {
+		t.Errorf("Expected the requester to be notified of the reason, got %q", message)
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err == nil {
+		t.Errorf("Expected a rejected proposal not to be approved")
+	}
+	if err := s.AmendProposal(requester, "PROPOSAL1", "PATIENT0,PATIENT1", OperationMean, ""); err == nil {
+		t.Errorf("Expected a declined proposal not to be amended")
+	}
+	if err := s.AmendProposal(requester, "PROPOSAL0", "PATIENT0,PATIENT1", OperationMean, ""); err != nil {
+		t.Fatalf("AmendProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if proposal, _ := s.FindProposal(requester, "PROPOSAL0"); proposal.Status != ProposalPending || proposal.Reason != nil {
+		t.Errorf("Expected the amended proposal to be pending without the reason of its rejection, got %+v", proposal)
+	}
+
+	stub.MockTransactionStart("tx3")
+	if err := s.ApproveProposalWithReason(custodian, "PROPOSAL0", ReasonFixKey, ""); err == nil {
+		t.Errorf("Expected an approval to need an approval code")
+	}
+	if err := s.ApproveProposalWithReason(custodian, "PROPOSAL0", ReasonNote, "Approved for this cohort only"); err != nil {
+		t.Fatalf("ApproveProposalWithReason failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	if proposal, _ := s.FindProposal(requester, "PROPOSAL0"); proposal.Status != ProposalApproved || proposal.Reason == nil || proposal.Reason.Text != "Approved for this cohort only" {
+		t.Errorf("Expected the approval and its comment to be stored, got %+v", proposal)
+	}
+	if message := notifications()[NotificationProposalApproved+" PROPOSAL0"]; !strings.Contains(message, "NOTE: Approved for this cohort only") {
+		t.Errorf("Expected the requester to be notified of the comment, got %q", message)
+	}
+}
+
+func TestReportingContract(t *testing.T) {
+	s := new(SimpleContract)
+	r := newReportingContract()
+	stub := newStub(t)
+	admin := newContext(stub, "admin", "Org1MSP", map[string]string{adminAttribute: "true"})
+
+	reportingContext := func(id string, attrs map[string]string) *ReportingContext {
+		ctx := new(ReportingContext)
+		ctx.SetStub(&pagingStub{MockStub: stub})
+		ctx.SetClientIdentity(&mockIdentity{id: id, mspID: "Org3MSP", attrs: attrs})
+
+		return ctx
+	}
+
+	reporter := reportingContext("dashboard", map[string]string{roleAttribute: RoleReporting})
+	clerk := reportingContext("clerk", nil)
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(admin, id, "Name", "7", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	defer stub.MockTransactionEnd("tx2")
+
+	statistics, err := r.GetOrgStatistics(reporter, "Org1MSP")
+
+	if err != nil {
+		t.Fatalf("GetOrgStatistics failed. %s", err.Error())
+	}
+	if statistics.Patients != 2 {
+		t.Errorf("Expected the patients of Org1MSP to be counted, got %+v", statistics)
+	}
+	if _, err := r.GetCompactionReport(reporter); err != nil {
+		t.Errorf("Expected reporting identities to read the compaction report. %s", err.Error())
+	}
+	if _, err := r.GetOrgStatistics(clerk, "Org1MSP"); err == nil {
+		t.Errorf("Expected identities without the reporting role to be refused")
+	}
+	if _, err := s.GetOrgStatistics(reporter, "Org1MSP"); err == nil {
+		t.Errorf("Expected the v2 statistics to stay with admins")
+	}
+	if _, err := r.GetEventsSince(reporter, "", 10); err != nil {
+		t.Errorf("GetEventsSince failed. %s", err.Error())
+	}
+
+	writes := map[string]error{
+		"PutState":       reporter.GetStub().PutState("PATIENT2", []byte("{}")),
+		"DelState":       reporter.GetStub().DelState("PATIENT0"),
+		"PutPrivateData": reporter.GetStub().PutPrivateData("_implicit_org_Org3MSP", "PATIENT0", []byte("{}")),
+		"SetEvent":       reporter.GetStub().SetEvent(EventPatientUpdated, []byte("{}")),
+	}
+
+	for name, err := range writes {
+		if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodePermissionDenied {
+			t.Errorf("Expected %s to be refused, got %v", name, err)
+		}
+	}
+	if err := s.CreatePatient(reporter, "PATIENT2", "Name", "7", "D1", "S1", "KEY0"); err == nil {
+		t.Errorf("Expected a v2 function run in the reporting context to be unable to write")
+	}
+	if stub.State["PATIENT2"] != nil {
+		t.Errorf("Expected nothing to be written")
+	}
+
+	cc, err := newChaincode()
+
+	if err != nil {
+		t.Fatalf("Failed to create chaincode. %s", err.Error())
+	}
+
+	ccStub := shimtest.NewMockStub("contract-tutorial", cc)
+
+	if response := ccStub.MockInvoke("tx3", [][]byte{[]byte("reporting:CreatePatient"), []byte("PATIENT0")}); response.Status == shim.OK {
+		t.Errorf("Expected the reporting contract to have no CreatePatient")
+	}
+
+	response := ccStub.MockInvoke("tx4", [][]byte{[]byte("org.hyperledger.fabric:GetMetadata")})
+	contracts := struct {
+		Contracts map[string]struct {
+			Transactions []struct {
+				Name string   `json:"name"`
+				Tags []string `json:"tag"`
+			} `json:"transactions"`
+		} `json:"contracts"`
+	}{}
+	_ = json.Unmarshal(response.Payload, &contracts)
+	transactions := contracts.Contracts[ReportingNamespace].Transactions
+
+	if len(transactions) != len(reportingFunctions) {
+		t.Errorf("Expected the %d reporting functions, got %+v", len(reportingFunctions), transactions)
+	}
+	for _, transaction := range transactions {
+		if !contains(transaction.Tags, "evaluate") {
+			t.Errorf("Expected %s to be tagged evaluate, got %v", transaction.Name, transaction.Tags)
+		}
+	}
+}
+
+func TestBiometricBinding(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	template := sha256.Sum256([]byte("template"))
+	other := sha256.Sum256([]byte("other"))
+
+	stub.MockTransactionStart("tx1")
+	admin := newContext(stub, "admin", "Org2MSP", map[string]string{adminAttribute: "true"})
+	if err := s.CreatePatient(admin, "PATIENT0", "Name", "7", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+
+	withBiometric := func(id string, mspID string, role string, biometric string) *contractapi.TransactionContext {
+		ctx := newContext(stub, id, mspID, map[string]string{roleAttribute: role})
+		ctx.SetStub(&pagingStub{MockStub: stub, transient: map[string][]byte{biometricField: []byte(biometric)}})
+
+		return ctx
+	}
+
+	clinician := withBiometric("clinician", "Org2MSP", clinicianRole, hex.EncodeToString(template[:]))
+	if _, err := s.VerifyBiometricBinding(clinician, "PATIENT0"); err == nil {
+		t.Errorf("Expected a patient without a binding not to be verified")
+	}
+	if err := s.SetBiometricBinding(withBiometric("clinician", "Org2MSP", clinicianRole, "template"), "PATIENT0"); err == nil {
+		t.Errorf("Expected a raw template to be refused")
+	}
+	if err := s.SetBiometricBinding(withBiometric("clinician", "Org1MSP", clinicianRole, hex.EncodeToString(template[:])), "PATIENT0"); err == nil {
+		t.Errorf("Expected a clinician of another org to be refused")
+	}
+	if err := s.SetBiometricBinding(clinician, "PATIENT0"); err != nil {
+		t.Fatalf("SetBiometricBinding failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	for _, value := range stub.PvtState["_implicit_org_Org2MSP"] {
+		if bytes.Contains(value, []byte(hex.EncodeToString(template[:]))) {
+			t.Errorf("Expected only the salted hash to be stored")
+		}
+	}
+	for key := range stub.State {
+		if strings.Contains(key, biometricIndex) {
+			t.Errorf("Expected the binding to be kept off the shared ledger")
+		}
+	}
+
+	stub.MockTransactionStart("tx2")
+	if matched, err := s.VerifyBiometricBinding(clinician, "PATIENT0"); err != nil || !matched {
+		t.Errorf("Expected the bound hash to match, got %v %v", matched, err)
+	}
+	if matched, err := s.VerifyBiometricBinding(withBiometric("clinician", "Org2MSP", clinicianRole, strings.ToUpper(hex.EncodeToString(template[:]))), "PATIENT0"); err != nil || !matched {
+		t.Errorf("Expected an upper case hash to match, got %v %v", matched, err)
+	}
+	if matched, err := s.VerifyBiometricBinding(withBiometric("clinician", "Org2MSP", clinicianRole, hex.EncodeToString(other[:])), "PATIENT0"); err != nil || matched {
+		t.Errorf("Expected another hash not to match, got %v %v", matched, err)
+	}
+	if _, err := s.VerifyBiometricBinding(withBiometric("admin", "Org2MSP", "", hex.EncodeToString(template[:])), "PATIENT0"); err == nil {
+		t.Errorf("Expected a caller without the clinician role to be refused")
+	}
+	if err := s.RemoveBiometricBinding(clinician, "PATIENT0"); err != nil {
+		t.Fatalf("RemoveBiometricBinding failed. %s", err.Error())
+	}
+	if _, err := s.VerifyBiometricBinding(clinician, "PATIENT0"); err == nil {
+		t.Errorf("Expected a removed binding not to be verified")
+	}
+	stub.MockTransactionEnd("tx2")
+}
+
+func TestContractMetadata(t *testing.T) {
+	docs, err := metadocs.Parse(".", contractReceivers)
+	if err != nil {
+		t.Fatalf("Parse failed. %s", err.Error())
+	}
+	embedded := metadocs.Docs{}
+	_ = json.Unmarshal(contractDocs, &embedded)
+	if !reflect.DeepEqual(*docs, embedded) {
+		t.Fatalf("contract-docs.json is out of date, run go generate")
+	}
+
+	if _, err := newChaincode(); err != nil {
+		t.Fatalf("newChaincode failed. %s", err.Error())
+	}
+	s := new(SimpleContract)
+	stub := newStub(t)
+	stub.MockTransactionStart("tx1")
+	metadataAsString, err := s.GetMetadata(newContext(stub, "client", "Org1MSP", nil))
+	if err != nil {
+		t.Fatalf("GetMetadata failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	metadata := contractChaincodeMetadata{}
+	if err := json.Unmarshal([]byte(metadataAsString), &metadata); err != nil {
+		t.Fatalf("Expected JSON metadata. %s", err.Error())
+	}
+	if metadata.Info["version"] != ContractVersion || metadata.Contracts[APIVersion1].Info["description"] == nil {
+		t.Errorf("Expected the chaincode and the contracts to be described, got %v %v", metadata.Info, metadata.Contracts[APIVersion1].Info)
+	}
+
+	for name, contract := range metadata.Contracts {
+		if name == "org.hyperledger.fabric" {
+			continue
+		}
+		for _, transaction := range contract.Transactions {
+			if transaction.Description == "" {
+				t.Errorf("Expected %s:%s to be described", name, transaction.Name)
+			}
+			for i, parameter := range transaction.Parameters {
+				if parameter.Name == fmt.Sprintf("param%d", i) {
+					t.Errorf("Expected the parameters of %s:%s to be named", name, transaction.Name)
+				}
+			}
+		}
+	}
+
+	find := func(contract string, name string) contractTransaction {
+		for _, transaction := range metadata.Contracts[contract].Transactions {
+			if transaction.Name == name {
+				return transaction
+			}
+		}
+		t.Fatalf("%s:%s not found", contract, name)
+		return contractTransaction{}
+	}
+	createPatient := find(APIVersion2, "CreatePatient")
+	if len(createPatient.Parameters) != 6 || createPatient.Parameters[0].Name != "id" || createPatient.Parameters[5].Name != "keyID" {
+		t.Errorf("Expected the parameters of CreatePatient, got %+v", createPatient.Parameters)
+	}
+	if find(APIVersion1, "FindPatient").Description != find(APIVersion2, "FindPatient").Description {
+		t.Errorf("Expected the v1 shims to be described by their v2 function")
+	}
+
+	patient := metadata.Components.Schemas["Patient"]
+	if patient["description"] == nil || patient["properties"].(map[string]interface{})["custodianMSP"] == nil {
+		t.Errorf("Expected Patient to be described with plain property names, got %v", patient)
+	}
+	if strings.Contains(metadataAsString, ",omitempty") || strings.Contains(metadataAsString, `"$ref":"TransactionDetails"`) {
+		t.Errorf("Expected the properties and references of the components to be fixed")
+	}
+}
+
+// contractChaincodeMetadata is the part of the metadata of GetMetadata TestContractMetadata reads
+type contractChaincodeMetadata struct {
+	Info      map[string]interface{} `json:"info"`
+	Contracts map[string]struct {
+		Info         map[string]interface{} `json:"info"`
+		Transactions []contractTransaction  `json:"transactions"`
+	} `json:"contracts"`
+	Components struct {
+		Schemas map[string]map[string]interface{} `json:"schemas"`
+	} `json:"components"`
+}
+
+// contractTransaction is a transaction of the metadata of GetMetadata
+type contractTransaction struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Parameters  []struct {
+		Name string `json:"name"`
+	} `json:"parameters"`
+}
+
+func TestExecutionLimits(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	// Executions are metered by the context of the contract, one per transaction
+	metered := func() *CachingContext {
+		ctx := new(CachingContext)
+		ctx.SetStub(&pagingStub{MockStub: stub})
+		ctx.SetClientIdentity(&mockIdentity{id: "researcher", mspID: "Org1MSP"})
+
+		return ctx
+	}
+	exhausted := func(err error, detail string) bool {
+		contractError, ok := err.(*ContractError)
+		return ok && contractError.Code == CodeQuotaExceeded && contractError.Details[detail] != ""
+	}
+
+	stub.MockTransactionStart("tx1")
+	pids := []string{}
+	for i, value := range []int64{10, 20, 30} {
+		pids = append(pids, fmt.Sprintf("PATIENT%d", i))
+		if err := s.CreatePatient(custodian, pids[i], "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", pids...)
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", strings.Join(pids, ","), "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.SetExecutionLimits(requester, 2, 0, 0); err == nil {
+		t.Errorf("Expected a caller that isn't an admin to be refused")
+	}
+	if err := s.SetExecutionLimits(custodian, -1, 0, 0); err == nil {
+		t.Errorf("Expected a negative limit to be refused")
+	}
+	if err := s.SetExecutionLimits(custodian, 2, 0, 0); err != nil {
+		t.Fatalf("SetExecutionLimits failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err := s.ExecuteProposal(metered(), "PROPOSAL0", pk.Q.String()); !exhausted(err, "maxCohort") {
+		t.Errorf("Expected a cohort above the limit to be refused, got %v", err)
+	}
+	if checkpoint, err := s.ExecuteProposalChunk(metered(), "PROPOSAL0", pk.Q.String(), 10); err != nil || checkpoint.Processed != 2 {
+		t.Errorf("Expected a chunk of the cohort limit, got %+v %v", checkpoint, err)
+	}
+	if err := s.SetExecutionLimits(custodian, 0, 0, 2); err != nil {
+		t.Fatalf("SetExecutionLimits failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	// Averaging reads each patient of the cohort once, the third read aborts
+	stub.MockTransactionStart("tx3")
+	if err := s.ExecuteProposal(metered(), "PROPOSAL0", pk.Q.String()); !exhausted(err, "maxExecutionSteps") {
+		t.Errorf("Expected the execution to be aborted past the step limit, got %v", err)
+	}
+	if err := s.SetExecutionLimits(custodian, 0, 16, 0); err != nil {
+		t.Fatalf("SetExecutionLimits failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx3")
+
+	stub.MockTransactionStart("tx4")
+	if err := s.ExecuteProposal(metered(), "PROPOSAL0", pk.Q.String()); !exhausted(err, "maxCiphertextLength") {
+		t.Errorf("Expected a ciphertext above the limit to be refused, got %v", err)
+	}
+	if err := s.SetExecutionLimits(custodian, 0, 0, 0); err != nil {
+		t.Fatalf("SetExecutionLimits failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx4")
+
+	stub.MockTransactionStart("tx5")
+	limits, err := s.GetExecutionLimits(requester)
+	if err != nil || *limits != (ExecutionLimits{MaxCohort: defaultMaxCohort, MaxCiphertextLength: defaultMaxCiphertextLength, MaxExecutionSteps: defaultMaxExecutionSteps}) {
+		t.Errorf("Expected the built-in limits, got %+v %v", limits, err)
+	}
+	if err := s.ExecuteProposal(metered(), "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Errorf("ExecuteProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx5")
+}
+
+func TestPatientParticipation(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for i, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", phe.Encrypt(sk, pk, big.NewInt(int64(7+i))).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	stub.TxTimestamp.Seconds += 60
+	if err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL1"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	report, err := s.GetPatientParticipation(custodian, "PATIENT0")
+	if err != nil {
+		t.Fatalf("GetPatientParticipation failed. %s", err.Error())
+	}
+	if len(report.Participations) != 2 {
+		t.Fatalf("Expected both proposals, got %+v", report.Participations)
+	}
+	executed, approved := report.Participations[0], report.Participations[1]
+	if executed.ProposalID != "PROPOSAL0" || executed.Status != ProposalExecuted || executed.ResultID != "RESULT-PROPOSAL0" || len(executed.Accessed) != 1 || !reflect.DeepEqual(executed.Purposes, []string{PurposeResearch}) {
+		t.Errorf("Expected the executed proposal with its result, got %+v", executed)
+	}
+	if approved.ProposalID != "PROPOSAL1" || approved.Status != ProposalApproved || approved.ResultID != "" || len(approved.Accessed) != 0 || approved.RequesterMSP != "Org1MSP" {
+		t.Errorf("Expected the approved proposal without accesses, got %+v", approved)
+	}
+
+	if report, err := s.GetPatientParticipation(custodian, "PATIENT1"); err != nil || len(report.Participations) != 1 || report.Participations[0].ProposalID != "PROPOSAL1" {
+		t.Errorf("Expected only the proposal including PATIENT1, got %+v %v", report, err)
+	}
+	if _, err := s.GetPatientParticipation(requester, "PATIENT0"); err == nil {
+		t.Errorf("Expected an org other than the custodian to be refused")
+	}
+}
+
+func TestMeasurementDefinitions(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i, value := range []int64{953, 1021, 880, 1104} {
+		if err := s.CreatePatient(custodian, fmt.Sprintf("PATIENT%d", i), "Name", phe.Encrypt(sk, pk, big.NewInt(value)).ToString(), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2", "PATIENT3")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	if err := s.DefineMeasurement(requester, DefaultMetric, "mg/dL", 10, 0, 6000); err == nil {
+		t.Errorf("Expected measurements to be defined by admins only")
+	}
+	if err := s.DefineMeasurement(custodian, DefaultMetric, "mg/dL", 0, 0, 6000); err == nil {
+		t.Errorf("Expected a scale of 0 to be refused")
+	}
+	if err := s.DefineMeasurement(custodian, DefaultMetric, "mg/dL", 10, 0, 6000); err != nil {
+		t.Fatalf("DefineMeasurement failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	definition, err := s.FindMeasurementDefinition(requester, "Org2MSP", DefaultMetric)
+
+	if err != nil {
+		t.Fatalf("FindMeasurementDefinition failed. %s", err.Error())
+	}
+
+	if definition.Unit != "mg/dL" || definition.Scale != 10 || definition.Max != 6000 {
+		t.Errorf("Expected glucose in mg/dL at scale 10, got %+v", definition)
+	}
+
+	stub.MockTransactionStart("PROPOSAL0")
+	if err := s.CreateProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.SetProposalDefinition(requester, "PROPOSAL0", "glucose", "mg/dL", 10); err == nil {
+		t.Errorf("Expected a definition of a metric the proposal doesn't compute to be refused")
+	}
+	if err := s.SetProposalDefinition(requester, "PROPOSAL0", DefaultMetric, "mmol/L", 10); err != nil {
+		t.Fatalf("SetProposalDefinition failed. %s", err.Error())
+	}
+	if err, ok := s.ApproveProposal(custodian, "PROPOSAL0").(*ContractError); !ok || err.Code != CodeInvalidState || err.Details["unit"] != "mg/dL" {
+		t.Errorf("Expected a proposal expecting another unit to be refused, got %v", err)
+	}
+	if err := s.SetProposalDefinition(requester, "PROPOSAL0", DefaultMetric, "mg/dL", 10); err != nil {
+		t.Fatalf("SetProposalDefinition failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	if err := s.CreateResult(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("PROPOSAL0")
+
+	stub.MockTransactionStart("PROPOSAL1")
+	if err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT2", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.SetProposalDefinition(requester, "PROPOSAL1", DefaultMetric, "mg/dL", 10); err != nil {
+		t.Fatalf("SetProposalDefinition failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL1"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	// The custodian moves to mmol/L between the approval and the execution
+	if err := s.DefineMeasurement(custodian, DefaultMetric, "mmol/L", 100, 0, 33000); err != nil {
+		t.Fatalf("DefineMeasurement failed. %s", err.Error())
+	}
+	if err, ok := s.ExecuteProposal(requester, "PROPOSAL1", pk.Q.String()).(*ContractError); !ok || err.Code != CodeInvalidState {
+		t.Errorf("Expected a proposal whose metric was redefined to be refused, got %v", err)
+	}
+	stub.MockTransactionEnd("PROPOSAL1")
+
+	stub.MockTransactionStart("PROPOSAL2")
+	if err := s.CreateProposal(requester, "PROPOSAL2", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT3", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL2"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL2", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	if err := s.CreateResult(requester, "PROPOSAL2", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResult failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("PROPOSAL2")
+
+	for id, unit := range map[string]string{"RESULT-PROPOSAL0": "mg/dL", "RESULT-PROPOSAL2": "mmol/L"} {
+		result, err := s.FindResult(requester, id)
+
+		if err != nil {
+			t.Fatalf("FindResult failed. %s", err.Error())
+		}
+
+		if definitions := result.Manifest.Definitions; len(definitions) != 1 || definitions[0].Metric != DefaultMetric || definitions[0].Unit != unit {
+			t.Errorf("Expected the manifest of %s to define %s in %s, got %+v", id, DefaultMetric, unit, definitions)
+		}
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err, ok := s.CreateMetaAnalysisProposal(requester, "PROPOSAL3", "PROTOCOL0", "Org1MSP", "Org2MSP", "RESULT-PROPOSAL0,RESULT-PROPOSAL2", "KEY1", "").(*ContractError); !ok || err.Code != CodeInvalidArgument || err.Details["metric"] != DefaultMetric {
+		t.Errorf("Expected results in mg/dL and mmol/L not to be combined, got %v", err)
+	}
+	stub.MockTransactionEnd("tx2")
+}
+
+func TestResultCoverage(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	sk, pk := phe.GenerateKeys(256)
+	requesterSK, _ := phe.GenerateKeys(256)
+	token := phe.GenerateToken(cloneKey(sk), cloneKey(requesterSK), pk, pk)
+	encrypt := func(m int64) string {
+		return phe.Encrypt(sk, pk, big.NewInt(m)).ToString()
+	}
+
+	stub.MockTransactionStart("tx1")
+	custodian := newContext(stub, "clinician", "Org2MSP", map[string]string{adminAttribute: "true"})
+	requester := newContext(stub, "researcher", "Org1MSP", nil)
+	for i := 0; i < 6; i++ {
+		id := fmt.Sprintf("PATIENT%d", i)
+		if err := s.CreatePatient(custodian, id, "Name", encrypt(0), "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd("tx1")
+		stub.MockTransactionStart("tx1")
+		// PATIENT3 has no glucose measurement
+		if i == 3 {
+			continue
+		}
+		if err := s.SetPatientMeasurement(custodian, id, "glucose", encrypt(int64(90+i*10))); err != nil {
+			t.Fatalf("SetPatientMeasurement failed. %s", err.Error())
+		}
+	}
+	// PATIENT4 has not consented
+	grantConsents(t, s, custodian, "Org1MSP", "PATIENT0", "PATIENT1", "PATIENT2", "PATIENT3", "PATIENT5")
+	if err := s.RegisterStudyProtocol(requester, "PROTOCOL0", "Study", "IRB-0001", OperationMean, "", "", 0); err != nil {
+		t.Fatalf("RegisterStudyProtocol failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("PROPOSAL0")
+	if err := s.CreateMultiMetricProposal(requester, "PROPOSAL0", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT1,PATIENT2,PATIENT3,PATIENT4,PATIENT5", "KEY0", "glucose", ""); err != nil {
+		t.Fatalf("CreateMultiMetricProposal failed. %s", err.Error())
+	}
+	if err := s.SetProposalSkipIncomplete(custodian, "PROPOSAL0", true); err == nil {
+		t.Errorf("Expected orgs other than the requester to be refused")
+	}
+	if err := s.SetProposalSkipIncomplete(requester, "PROPOSAL0", true); err != nil {
+		t.Fatalf("SetProposalSkipIncomplete failed. %s", err.Error())
+	}
+	if err := s.WithdrawPatientFromProposal(custodian, "PROPOSAL0", "PATIENT5"); err != nil {
+		t.Fatalf("WithdrawPatientFromProposal failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL0"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	if err := s.ExecuteProposal(requester, "PROPOSAL0", pk.Q.String()); err != nil {
+		t.Fatalf("ExecuteProposal failed. %s", err.Error())
+	}
+	if err := s.CreateResultSet(requester, "PROPOSAL0", token.T1.ToString(), token.T2.ToString(), "KEY1", pk.Q.String()); err != nil {
+		t.Fatalf("CreateResultSet failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("PROPOSAL0")
+
+	proposal, _ := s.FindProposal(requester, "PROPOSAL0")
+	expected := []SkippedPatient{{"PATIENT5", SkipWithdrawn}, {"PATIENT3", SkipMissingMeasurement}, {"PATIENT4", SkipNoConsent}}
+
+	if !reflect.DeepEqual(proposal.Skipped, expected) {
+		t.Errorf("Expected %+v to be skipped, got %+v", expected, proposal.Skipped)
+	}
+
+	resultSet, err := s.FindResultSet(requester, "RESULTSET-PROPOSAL0")
+
+	if err != nil {
+		t.Fatalf("FindResultSet failed. %s", err.Error())
+	}
+
+	coverage := resultSet.Manifest.Coverage
+	skipped := map[string]int{SkipWithdrawn: 1, SkipMissingMeasurement: 1, SkipNoConsent: 1}
+
+	if coverage == nil || coverage.Selected != 6 || coverage.Contributed != 3 || !reflect.DeepEqual(coverage.Skipped, skipped) || coverage.Missingness != 0.5 {
+		t.Errorf("Expected 3 of 6 patients to contribute, got %+v", coverage)
+	}
+
+	// The mean is over the effective N, 90, 100 and 110
+	m := phe.Decrypt(cloneKey(requesterSK), pk, phe.StringToMultivector(resultSet.Values["glucose"]))
+
+	if m.Cmp(big.NewRat(100, 1)) != 0 {
+		t.Errorf("Expected a mean of 100, got %s", m.String())
+	}
+
+	stub.MockTransactionStart("PROPOSAL1")
+	if err := s.CreateProposal(requester, "PROPOSAL1", "PROTOCOL0", "Org1MSP", "Org2MSP", "PATIENT0,PATIENT4", "KEY0", OperationMean, ""); err != nil {
+		t.Fatalf("CreateProposal failed. %s", err.Error())
+	}
+	if err := s.SetProposalSkipIncomplete(requester, "PROPOSAL1", true); err != nil {
+		t.Fatalf("SetProposalSkipIncomplete failed. %s", err.Error())
+	}
+	if err := s.ApproveProposal(custodian, "PROPOSAL1"); err != nil {
+		t.Fatalf("ApproveProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("PROPOSAL1")
+
+	// Chunks record their skipped patients until the proposal is finalized
+	for i := 0; i < 2; i++ {
+		stub.MockTransactionStart(fmt.Sprintf("chunk%d", i))
+		if _, err := s.ExecuteProposalChunk(requester, "PROPOSAL1", pk.Q.String(), 1); err != nil {
+			t.Fatalf("ExecuteProposalChunk failed. %s", err.Error())
+		}
+		stub.MockTransactionEnd(fmt.Sprintf("chunk%d", i))
+	}
+
+	stub.MockTransactionStart("tx2")
+	if err := s.FinalizeProposal(requester, "PROPOSAL1"); err != nil {
+		t.Fatalf("FinalizeProposal failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx2")
+
+	proposal, _ = s.FindProposal(requester, "PROPOSAL1")
+
+	if len(proposal.Skipped) != 1 || proposal.Skipped[0].Reason != SkipNoConsent || len(proposal.aggregatedPatients()) != 1 {
+		t.Errorf("Expected PATIENT4 to be skipped for lack of consent, got %+v", proposal.Skipped)
+	}
+}
+
+func TestGrantConsentAuthorization(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	custodian := newContext(stub, "clinician", "Org1MSP", nil)
+	requester := newContext(stub, "researcher", "Org2MSP", nil)
+	stranger := newContext(stub, "stranger", "Org9MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	for _, id := range []string{"PATIENT0", "PATIENT1"} {
+		if err := s.CreatePatient(custodian, id, "Name", "0", "D1", "S1", "KEY0"); err != nil {
+			t.Fatalf("CreatePatient failed. %s", err.Error())
+		}
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	if err, ok := s.GrantConsent(stranger, "PATIENT1", "PATIENT0", "Org9MSP", "terms", PurposeResearch, "").(*ContractError); !ok || err.Code != CodeAlreadyExists {
+		t.Errorf("Expected a consent ID holding a patient to be refused, got %v", err)
+	}
+	if err, ok := s.GrantConsent(requester, "CONSENT9", "PATIENT0", "Org2MSP", "terms", PurposeResearch, "").(*ContractError); !ok || err.Code != CodePermissionDenied {
+		t.Errorf("Expected a requester granting itself consent to be refused, got %v", err)
+	}
+	for _, id := range []string{"CONSENT-A1", "CONSENT-B1"} {
+		if err := s.GrantConsent(custodian, id, "PATIENT0", "Org2MSP", "terms of "+id, PurposeResearch, ""); err != nil {
+			t.Fatalf("GrantConsent failed. %s", err.Error())
+		}
+	}
+	if err, ok := s.GrantConsent(custodian, "CONSENT-A1", "PATIENT1", "Org2MSP", "terms", PurposeResearch, "").(*ContractError); !ok || err.Code != CodeAlreadyExists {
+		t.Errorf("Expected an existing consent not to be overwritten, got %v", err)
+	}
+	stub.MockTransactionEnd("tx2")
+
+	if patient, err := s.FindPatient(custodian, "PATIENT1"); err != nil || patient.Name != "Name" {
+		t.Errorf("Expected PATIENT1 to be kept, got %+v %v", patient, err)
+	}
+
+	if _, err := s.FindConsent(custodian, "CONSENT9"); err == nil {
+		t.Errorf("Expected no consent to be granted by the requester")
+	}
+
+	// Consents whose IDs hold the same digits keep receipts of their own
+	for _, id := range []string{"CONSENT-A1", "CONSENT-B1"} {
+		consent, _ := s.FindConsent(custodian, id)
+		receipt, err := s.FindConsentReceipt(custodian, consent.ReceiptID)
+
+		if err != nil || receipt.ConsentID != id || receipt.TermsHash != hashString("terms of "+id) {
+			t.Errorf("Expected the receipt of %s, got %+v %v", id, receipt, err)
+		}
+	}
+}
+
+func TestCreatePatientExisting(t *testing.T) {
+	s := new(SimpleContract)
+	stub := newStub(t)
+	org1 := newContext(stub, "clinician", "Org1MSP", nil)
+	org2 := newContext(stub, "clinician", "Org2MSP", nil)
+
+	stub.MockTransactionStart("tx1")
+	if err := s.CreatePatient(org1, "PATIENT0", "Name", "0", "D1", "S1", "KEY0"); err != nil {
+		t.Fatalf("CreatePatient failed. %s", err.Error())
+	}
+	stub.MockTransactionEnd("tx1")
+
+	stub.MockTransactionStart("tx2")
+	err := s.CreatePatient(org2, "PATIENT0", "Other", "1", "D2", "S2", "KEY1")
+	stub.MockTransactionEnd("tx2")
+
+	if contractError, ok := err.(*ContractError); !ok || contractError.Code != CodeAlreadyExists {
+		t.Errorf("Expected a patient of another org not to be created again, got %v", err)
+	}
+
+	patient, err := s.FindPatient(org1, "PATIENT0")
+
+	if err != nil || patient.Name != "Name" || patient.custodian() != "Org1MSP" {
+		t.Errorf("Expected PATIENT0 to stay with Org1MSP, got %+v %v", patient, err)
+	}
+
+	for _, c := range []struct {
+		ctx   contractapi.TransactionContextInterface
+		count int
+	}{{org1, 1}, {org2, 0}} {
+		page, err := s.GetMyOrgPatients(c.ctx, 0, "")
+
+		if err != nil || len(page.Records) != c.count {
+			t.Errorf("Expected %d patients, got %+v %v", c.count, page, err)
+		}
+	}
+}
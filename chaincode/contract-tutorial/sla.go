@@ -0,0 +1,267 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// proposalDueIndex is the composite key namespace of the proposals awaiting a response under
+// an SLA, in order of their due time. Entries of proposals answered since are left behind
+// and deleted by EscalateOverdueProposals.
+const proposalDueIndex = "proposal~due"
+
+// OverdueProposal is a pending proposal its custodian didn't answer by the time the SLA of
+// their agreement set
+type OverdueProposal struct {
+	ProposalID  string `json:"proposalID"`
+	RequesterID string `json:"requesterID"`
+	RequestedID string `json:"requestedID"`
+	ResponseDue string `json:"responseDue"`
+	Escalated   bool   `json:"escalated"`
+}
+
+// EscalationReport is what a run of EscalateOverdueProposals did. Remaining tells whether
+// overdue proposals are left to escalate.
+type EscalationReport struct {
+	Escalated []string `json:"escalated"`
+	Remaining bool     `json:"remaining"`
+}
+
+// awaitResponse starts the SLA of a proposal becoming pending, when the agreement of its
+// custodian with its requester sets one
+func awaitResponse(ctx contractapi.TransactionContextInterface, id string, proposal *Proposal) error {
+	proposal.ResponseDue = ""
+	proposal.Escalated = nil
+
+	agreement, err := findAgreement(ctx, proposal.RequestedID, proposal.RequesterID)
+
+	if err != nil || agreement == nil || agreement.ResponseSLA == 0 {
+		return err
+	}
+
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	proposal.ResponseDue = timeutil.Format(now.Add(time.Duration(agreement.ResponseSLA) * time.Second))
+
+	key, err := ctx.GetStub().CreateCompositeKey(proposalDueIndex, []string{proposal.ResponseDue, id})
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte{0x00})
+}
+
+// SetAgreementResponseSLA sets the seconds the caller's org has to approve or counter the
+// proposals of a requester, 0 removing the SLA. Proposals already pending keep their due time.
+func (s *SimpleContract) SetAgreementResponseSLA(ctx contractapi.TransactionContextInterface, requesterMSP string, seconds int64) error {
+	if seconds < 0 {
+		return newError(CodeInvalidArgument, map[string]string{"seconds": fmt.Sprint(seconds)}, "The SLA can't be negative")
+	}
+
+	agreement, err := callerAgreement(ctx, requesterMSP)
+
+	if err != nil {
+		return err
+	}
+
+	agreement.ResponseSLA = seconds
+
+	return putAgreement(ctx, agreement)
+}
+
+// overdueProposals calls back with the proposals past their due time at a time, in order of
+// due time, and with the index entries of the proposals answered since, whose proposal is nil
+func (s *SimpleContract) overdueProposals(ctx contractapi.TransactionContextInterface, now time.Time, callback func(key string, id string, proposal *Proposal) error) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(proposalDueIndex, []string{})
+
+	if err != nil {
+		return err
+	}
+
+	return ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		due, err := timeutil.Parse(attributes[0])
+
+		if err != nil {
+			return err
+		}
+
+		if now.Before(due) {
+			return ledgeriter.ErrStop
+		}
+
+		proposal, err := s.FindProposal(ctx, attributes[1])
+
+		if err != nil {
+			return err
+		}
+
+		if proposal.Status != ProposalPending || proposal.ResponseDue != attributes[0] {
+			proposal = nil
+		}
+
+		return callback(queryResponse.Key, attributes[1], proposal)
+	})
+}
+
+// OverdueProposals returns the pending proposals whose custodian didn't answer them by the
+// time the SLA of their agreement set, in order of due time
+func (s *SimpleContract) OverdueProposals(ctx contractapi.TransactionContextInterface) ([]OverdueProposal, error) {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := []OverdueProposal{}
+
+	err = s.overdueProposals(ctx, now, func(key string, id string, proposal *Proposal) error {
+		if proposal == nil {
+			return nil
+		}
+
+		if len(results) == int(limit) {
+			return ledgeriter.ErrStop
+		}
+
+		results = append(results, OverdueProposal{
+			ProposalID:  id,
+			RequesterID: proposal.RequesterID,
+			RequestedID: proposal.RequestedID,
+			ResponseDue: proposal.ResponseDue,
+			Escalated:   proposal.Escalated != nil,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	return results, nil
+}
+
+// EscalateOverdueProposals notifies both orgs of the proposals left unanswered past their SLA
+// and counts the breach on their agreement, once per proposal. Proposals are escalated again
+// if they breach the SLA anew after being amended or countered. Each call handles a page of
+// proposals, in order of due time, and is called again while some remain.
+func (s *SimpleContract) EscalateOverdueProposals(ctx contractapi.TransactionContextInterface) (*EscalationReport, error) {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize, err := resolvePageSize(ctx, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	report := &EscalationReport{Escalated: []string{}}
+
+	// The writes of the transaction can't be read back, so the breaches are counted here
+	agreements := map[string]*DataSharingAgreement{}
+
+	err = s.overdueProposals(ctx, now, func(key string, id string, proposal *Proposal) error {
+		if proposal == nil {
+			return ctx.GetStub().DelState(key)
+		}
+
+		// Proposals already escalated wait for an answer without taking a place in the page
+		if proposal.Escalated != nil {
+			return nil
+		}
+
+		if len(report.Escalated) == int(pageSize) {
+			report.Remaining = true
+			return ledgeriter.ErrStop
+		}
+
+		pair := proposal.RequestedID + "\x00" + proposal.RequesterID
+		agreement, ok := agreements[pair]
+
+		if !ok {
+			found, err := findAgreement(ctx, proposal.RequestedID, proposal.RequesterID)
+
+			if err != nil {
+				return err
+			}
+
+			agreement = found
+			agreements[pair] = found
+		}
+
+		if agreement != nil {
+			agreement.Breaches++
+		}
+
+		message := fmt.Sprintf("%s was due an answer from %s at %s", id, proposal.RequestedID, proposal.ResponseDue)
+
+		for _, mspID := range []string{proposal.RequestedID, proposal.RequesterID} {
+			if err := notify(ctx, mspID, NotificationResponseOverdue, id, message); err != nil {
+				return err
+			}
+		}
+
+		proposal.Escalated = &details
+		report.Escalated = append(report.Escalated, id)
+
+		proposalAsBytes, _ := json.Marshal(proposal)
+
+		return ctx.GetStub().PutState(id, proposalAsBytes)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, agreement := range agreements {
+		if agreement == nil {
+			continue
+		}
+
+		if err := agreement.Metadata.touch(ctx); err != nil {
+			return nil, err
+		}
+
+		if err := putAgreement(ctx, agreement); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
+}
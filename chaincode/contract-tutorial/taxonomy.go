@@ -0,0 +1,274 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// diagnosisNodeIndex is the composite key namespace of the nodes of the diagnosis taxonomy
+const diagnosisNodeIndex = "diagnosis~node"
+
+// diagnosisParentIndex is the composite key namespace of the children of each diagnosis node
+const diagnosisParentIndex = "diagnosis~parent"
+
+// Levels of the diagnosis taxonomy
+const (
+	DiagnosisChapter = "CHAPTER"
+	DiagnosisBlock   = "BLOCK"
+	DiagnosisCode    = "CODE"
+)
+
+// parentLevels are the levels the parent of a node of each level may have
+var parentLevels = map[string][]string{
+	DiagnosisChapter: {},
+	DiagnosisBlock:   {DiagnosisChapter},
+	DiagnosisCode:    {DiagnosisBlock, DiagnosisCode},
+}
+
+// DiagnosisNode is a chapter, block or code of the diagnosis taxonomy. Chapters are roots,
+// blocks belong to a chapter and codes to a block or to a broader code, as in ICD-10.
+type DiagnosisNode struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Level    string   `json:"level"`
+	ParentID string   `json:"parentID,omitempty" metadata:",optional"`
+	Metadata Metadata `json:"metadata"`
+}
+
+// findDiagnosis returns a node of the taxonomy, nil if it is not registered
+func findDiagnosis(ctx contractapi.TransactionContextInterface, id string) (*DiagnosisNode, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(diagnosisNodeIndex, []string{id})
+
+	if err != nil {
+		return nil, err
+	}
+
+	nodeAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if nodeAsBytes == nil {
+		return nil, nil
+	}
+
+	node := new(DiagnosisNode)
+	_ = json.Unmarshal(nodeAsBytes, node)
+
+	return node, nil
+}
+
+// RegisterDiagnosis adds a chapter, block or code to the diagnosis taxonomy under its parent,
+// chapters having none. Patients keep their diagnosis IDs, the codes of the taxonomy. Only
+// admins register diagnoses.
+func (s *SimpleContract) RegisterDiagnosis(ctx contractapi.TransactionContextInterface, id string, name string, level string, parentID string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	levels, ok := parentLevels[level]
+
+	if !ok {
+		return newError(CodeInvalidArgument, map[string]string{"level": level}, "Levels are %s, %s and %s", DiagnosisChapter, DiagnosisBlock, DiagnosisCode)
+	}
+
+	if err := checkIDCharacters(id); err != nil {
+		return err
+	}
+
+	existing, err := findDiagnosis(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		return newError(CodeAlreadyExists, map[string]string{"id": id}, "Diagnosis %s already exists", id)
+	}
+
+	if (len(levels) == 0) != (parentID == "") {
+		return newError(CodeInvalidArgument, map[string]string{"id": id, "parentID": parentID}, "Only chapters have no parent")
+	}
+
+	if parentID != "" {
+		parent, err := findDiagnosis(ctx, parentID)
+
+		if err != nil {
+			return err
+		}
+
+		if parent == nil {
+			return newError(CodeNotFound, map[string]string{"parentID": parentID}, "Diagnosis %s does not exist", parentID)
+		}
+
+		if !contains(levels, parent.Level) {
+			return newError(CodeInvalidArgument, map[string]string{"id": id, "parentID": parentID, "level": parent.Level}, "A %s can't belong to a %s", level, parent.Level)
+		}
+
+		key, err := ctx.GetStub().CreateCompositeKey(diagnosisParentIndex, []string{parentID, id})
+
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+			return err
+		}
+	}
+
+	metadata, err := newMetadata(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(diagnosisNodeIndex, []string{id})
+
+	if err != nil {
+		return err
+	}
+
+	nodeAsBytes, _ := json.Marshal(DiagnosisNode{ID: id, Name: name, Level: level, ParentID: parentID, Metadata: metadata})
+
+	return ctx.GetStub().PutState(key, nodeAsBytes)
+}
+
+// FindDiagnosis returns a node of the diagnosis taxonomy
+func (s *SimpleContract) FindDiagnosis(ctx contractapi.TransactionContextInterface, id string) (*DiagnosisNode, error) {
+	node, err := findDiagnosis(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if node == nil {
+		return nil, newError(CodeNotFound, map[string]string{"id": id}, "Diagnosis %s does not exist", id)
+	}
+
+	return node, nil
+}
+
+// expandDiagnoses returns diagnoses and every node below them in the taxonomy, so selecting a
+// chapter or a block selects its codes. Diagnoses outside the taxonomy select themselves only.
+func expandDiagnoses(ctx contractapi.TransactionContextInterface, ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	expanded := []string{}
+	queue := append([]string{}, ids...)
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		if contains(expanded, id) {
+			continue
+		}
+
+		expanded = append(expanded, id)
+
+		resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(diagnosisParentIndex, []string{id})
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, limit, func(queryResponse *queryresult.KV) error {
+			_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+			if err != nil {
+				return err
+			}
+
+			queue = append(queue, attributes[1])
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, queryError(err)
+		}
+
+		if len(expanded) > limit {
+			return nil, newError(CodeInvalidArgument, map[string]string{"limit": fmt.Sprint(limit)}, "The subtrees of %s hold more than %d diagnoses, narrow them down", strings.Join(ids, ", "), limit)
+		}
+	}
+
+	return expanded, nil
+}
+
+// GetPatientsByDiagnosisSubtree returns a page of the patients of the caller's org diagnosed
+// with a chapter, block or code of the taxonomy or any code below it, so a study of a chapter
+// doesn't enumerate its codes
+func (s *SimpleContract) GetPatientsByDiagnosisSubtree(ctx contractapi.TransactionContextInterface, diagnosisID string, pageSize int32, bookmark string) (*PatientPage, error) {
+	if _, err := s.FindDiagnosis(ctx, diagnosisID); err != nil {
+		return nil, err
+	}
+
+	filter, err := newResponseFilter(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !filter.lets(FieldDiagnosisID) {
+		return nil, newError(CodePermissionDenied, nil, "The read policy of the caller does not let it select patients by the fields it can't read")
+	}
+
+	diagnosisIDs, err := expandDiagnoses(ctx, []string{diagnosisID})
+
+	if err != nil {
+		return nil, err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectPage(queryContext(), indexPages(ctx, patientOrgIndex, []string{mspID}), size, bookmark, func(queryResponse *queryresult.KV) (QueryResult, bool, error) {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return QueryResult{}, false, err
+		}
+
+		patient, err := findPatient(ctx, attributes[1])
+
+		if err != nil {
+			return QueryResult{}, false, err
+		}
+
+		return QueryResult{Key: attributes[1], Record: patient}, contains(diagnosisIDs, patient.DiagnosisID), nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newPatientPage(ctx, results)
+}
@@ -0,0 +1,280 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// patientAsOf reconstructs a patient as it was at the given time from the history of its key
+func patientAsOf(ctx contractapi.TransactionContextInterface, id string, asOf time.Time) (*Patient, error) {
+	limit, err := resultLimit(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resultsIterator, err := ctx.GetStub().GetHistoryForKey(id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var latest time.Time
+	var value []byte
+	found := false
+
+	// The order of the history isn't relied upon, the latest modification not after asOf wins
+	err = ledgeriter.ForEach[*queryresult.KeyModification](queryContext(), resultsIterator, limit, func(modification *queryresult.KeyModification) error {
+		ts := time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+
+		if ts.After(asOf) || (found && ts.Before(latest)) {
+			return nil
+		}
+
+		latest = ts
+		found = true
+		value = modification.Value
+
+		if modification.IsDelete {
+			value = nil
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	if value == nil {
+		return nil, newError(CodeNotFound, map[string]string{"id": id, "asOf": timeutil.Format(asOf)}, "%s did not exist at %s", id, timeutil.Format(asOf))
+	}
+
+	patient := new(Patient)
+	_ = json.Unmarshal(value, patient)
+
+	return patient, nil
+}
+
+// patientVersionIndex keys the versions of a patient replaced by an update, by the timestamp
+// of their own last update
+const patientVersionIndex = "patient~version"
+
+// putPatient writes a patient, keeping the version it replaces unless both were written at
+// the same time
+func putPatient(ctx contractapi.TransactionContextInterface, id string, patient *Patient) error {
+	previous, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if previous.Metadata.Updated.Timestamp != patient.Metadata.Updated.Timestamp {
+		if err := keepPatientVersion(ctx, id, previous); err != nil {
+			return err
+		}
+	}
+
+	patientAsBytes, _ := json.Marshal(patient)
+
+	return ctx.GetStub().PutState(id, patientAsBytes)
+}
+
+// keepPatientVersion writes a version of a patient under the timestamp of its last update
+func keepPatientVersion(ctx contractapi.TransactionContextInterface, id string, version *Patient) error {
+	key, err := ctx.GetStub().CreateCompositeKey(patientVersionIndex, []string{id, version.Metadata.Updated.Timestamp})
+
+	if err != nil {
+		return err
+	}
+
+	versionAsBytes, _ := json.Marshal(version)
+
+	return ctx.GetStub().PutState(key, versionAsBytes)
+}
+
+// patientVersionAt returns the latest kept version of a patient last updated at or before a
+// cutoff, nil if none was kept
+func patientVersionAt(ctx contractapi.TransactionContextInterface, id string, cutoff time.Time) (*Patient, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(patientVersionIndex, []string{id})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var latest time.Time
+	var value []byte
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		updated, err := timeutil.Parse(attributes[1])
+
+		if err != nil || updated.After(cutoff) || (value != nil && updated.Before(latest)) {
+			return nil
+		}
+
+		latest = updated
+		value = queryResponse.Value
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, queryError(err)
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+
+	version := new(Patient)
+	_ = json.Unmarshal(value, version)
+
+	return version, nil
+}
+
+// minimizePatientVersions clears fields from every kept version of a patient
+func minimizePatientVersions(ctx contractapi.TransactionContextInterface, id string, fields []string) error {
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(patientVersionIndex, []string{id})
+
+	if err != nil {
+		return err
+	}
+
+	return ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		version := new(Patient)
+		_ = json.Unmarshal(queryResponse.Value, version)
+
+		for _, field := range fields {
+			clearField(version, field)
+		}
+
+		versionAsBytes, _ := json.Marshal(version)
+
+		return ctx.GetStub().PutState(queryResponse.Key, versionAsBytes)
+	})
+}
+
+// patientAtCutoff returns a patient as it was at a cutoff from the world state alone, as
+// executions do: the history of a key isn't validated at commit and may differ between
+// peers. A patient created after the cutoff didn't exist at it, and one changed since is
+// read from the version it kept at the cutoff. Patients changed before versions were kept
+// can't be rebuilt.
+func patientAtCutoff(ctx contractapi.TransactionContextInterface, id string, cutoff time.Time) (*Patient, error) {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := timeutil.Parse(patient.Metadata.Created.Timestamp)
+
+	if err == nil && created.After(cutoff) {
+		return nil, newError(CodeNotFound, map[string]string{"id": id, "asOf": timeutil.Format(cutoff)}, "%s did not exist at %s", id, timeutil.Format(cutoff))
+	}
+
+	updated, err := timeutil.Parse(patient.Metadata.Updated.Timestamp)
+
+	if err == nil && !updated.After(cutoff) {
+		return patient, nil
+	}
+
+	version, err := patientVersionAt(ctx, id, cutoff)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if version == nil {
+		return nil, newError(CodeInvalidState, map[string]string{"id": id, "asOf": timeutil.Format(cutoff), "updated": patient.Metadata.Updated.Timestamp}, "%s changed since %s and kept no version of it", id, timeutil.Format(cutoff))
+	}
+
+	return version, nil
+}
+
+// GetPatientAsOf returns a patient as it was at an RFC 3339 timestamp, rebuilt from the
+// history of its key. It is meant to be evaluated, never submitted.
+func (s *SimpleContract) GetPatientAsOf(ctx contractapi.TransactionContextInterface, id string, timestamp string) (*Patient, error) {
+	asOf, err := parseTimestamp("timestamp", timestamp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	patient, err := patientAsOf(ctx, id, asOf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return filterPatient(ctx, patient)
+}
+
+// SetProposalCutoff makes a pending proposal compute over the cohort as it was at
+// the study cutoff, an RFC 3339 timestamp. An empty cutoff uses the current data. The
+// execution reads the patients as they were at the cutoff from the versions their updates
+// kept, refusing those without one or skipping them as CHANGED_SINCE_CUTOFF if the
+// proposal skips incomplete patients.
+func (s *SimpleContract) SetProposalCutoff(ctx contractapi.TransactionContextInterface, id string, asOf string) error {
+	proposal, err := s.FindProposal(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status != ProposalPending {
+		return newError(CodeInvalidState, map[string]string{"id": id, "status": proposal.Status}, "%s is not pending", id)
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != proposal.RequesterID {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": mspID}, "Only %s can set the cutoff of %s", proposal.RequesterID, id)
+	}
+
+	if asOf != "" {
+		cutoff, err := parseTimestamp("asOf", asOf)
+
+		if err != nil {
+			return err
+		}
+
+		now, err := txTime(ctx)
+
+		if err != nil {
+			return err
+		}
+
+		if cutoff.After(now) {
+			return newError(CodeInvalidArgument, map[string]string{"asOf": asOf}, "Cutoff %s is in the future", asOf)
+		}
+	}
+
+	proposal.AsOf = asOf
+
+	if err := proposal.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(id, proposalAsBytes)
+}
@@ -0,0 +1,340 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/phewrap"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// Transfer statuses
+const (
+	TransferPending   = "PENDING"
+	TransferAccepted  = "ACCEPTED"
+	TransferCancelled = "CANCELLED"
+)
+
+// PatientTransfer is the last handover of the custody of a patient to another org, pending
+// until the receiving org accepts it or either org cancels it
+type PatientTransfer struct {
+	FromMSP   string              `json:"fromMSP"`
+	ToMSP     string              `json:"toMSP"`
+	Status    string              `json:"status"`
+	Initiated TransactionDetails  `json:"initiated"`
+	Answered  *TransactionDetails `json:"answered,omitempty" metadata:",optional"`
+}
+
+// custodian returns the MSP of the org in custody of the patient, its creator until it is transferred
+func (p *Patient) custodian() string {
+	if p.CustodianMSP != "" {
+		return p.CustodianMSP
+	}
+
+	return p.Metadata.Created.MSPID
+}
+
+// pendingTransfer returns the pending transfer of a patient, refusing patients without one
+func pendingTransfer(id string, patient *Patient) (*PatientTransfer, error) {
+	if patient.Transfer == nil || patient.Transfer.Status != TransferPending {
+		return nil, newError(CodeInvalidState, map[string]string{"id": id}, "%s has no pending transfer", id)
+	}
+
+	return patient.Transfer, nil
+}
+
+// InitiateTransfer offers the custody of a patient to another org. The caller's org stays
+// the custodian until the receiving org accepts with AcceptTransfer, and either org can
+// cancel the transfer with CancelTransfer meanwhile.
+func (s *SimpleContract) InitiateTransfer(ctx contractapi.TransactionContextInterface, id string, toMSP string) error {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != patient.custodian() {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": details.MSPID}, "Only %s can transfer %s", patient.custodian(), id)
+	}
+
+	if toMSP == "" || toMSP == details.MSPID {
+		return newError(CodeInvalidArgument, map[string]string{"toMSP": toMSP}, "%s is transferred to another org", id)
+	}
+
+	if patient.Transfer != nil && patient.Transfer.Status == TransferPending {
+		return newError(CodeAlreadyExists, map[string]string{"id": id, "toMSP": patient.Transfer.ToMSP}, "%s is already being transferred to %s", id, patient.Transfer.ToMSP)
+	}
+
+	if err := checkNotFrozen(id, patient); err != nil {
+		return err
+	}
+
+	proposalID, err := patientLock(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposalID != "" {
+		return newError(CodeInvalidState, map[string]string{"id": id, "proposalID": proposalID}, "%s is locked by %s until it is executed", id, proposalID)
+	}
+
+	patient.Transfer = &PatientTransfer{FromMSP: details.MSPID, ToMSP: toMSP, Status: TransferPending, Initiated: details}
+	patient.Metadata.Updated = details
+
+	if err := notify(ctx, toMSP, NotificationTransferAwaitingAcceptance, id, fmt.Sprintf("%s offers you the custody of %s", details.MSPID, id)); err != nil {
+		return err
+	}
+
+	return putPatient(ctx, id, patient)
+}
+
+// AcceptTransfer takes the custody of a patient offered to the caller's org. The encrypted
+// values of the patient, its measurements and age bucket indicators included, are moved to a
+// key of the receiving org with a token, as results are released, and its age bucket
+// indicators move to the counters of the receiving org. Proofs of the former values are
+// dropped. The patient key is bound to an endorsement policy requiring a peer of the
+// receiving org, so the former custodian can no longer write it alone.
+func (s *SimpleContract) AcceptTransfer(ctx contractapi.TransactionContextInterface, id string, keyID string, firstToken string, secondToken string, modulo string) error {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	transfer, err := pendingTransfer(id, patient)
+
+	if err != nil {
+		return err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != transfer.ToMSP {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": details.MSPID}, "Only %s can accept the transfer of %s", transfer.ToMSP, id)
+	}
+
+	if err := checkNotFrozen(id, patient); err != nil {
+		return err
+	}
+
+	proposalID, err := patientLock(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	if proposalID != "" {
+		return newError(CodeInvalidState, map[string]string{"id": id, "proposalID": proposalID}, "%s is locked by %s until it is executed", id, proposalID)
+	}
+
+	if err := checkKeyUsable(ctx, keyID); err != nil {
+		return err
+	}
+
+	key, err := findKeyRecord(ctx, keyID)
+
+	if err != nil {
+		return err
+	}
+
+	if key != nil && key.OwnerMSP != transfer.ToMSP {
+		return newError(CodePermissionDenied, map[string]string{"keyID": keyID, "ownerMSP": key.OwnerMSP}, "%s is a key of %s", keyID, key.OwnerMSP)
+	}
+
+	q, err := parseModulus(modulo)
+
+	if err != nil {
+		return err
+	}
+
+	token, err := parseToken(firstToken, secondToken)
+
+	if err != nil {
+		return err
+	}
+
+	before := trackedFields(patient)
+
+	update := func(value string) (string, error) {
+		c, err := parseCiphertext(id, value)
+
+		if err != nil {
+			return "", err
+		}
+
+		return q.KeyUpdate(token, c).String(), nil
+	}
+
+	if patient.PreExistingConditions, err = update(patient.PreExistingConditions); err != nil {
+		return err
+	}
+
+	for metric, value := range patient.Measurements {
+		if patient.Measurements[metric], err = update(value); err != nil {
+			return err
+		}
+	}
+
+	if err := s.transferAgeBuckets(ctx, id, transfer, keyID, update, q); err != nil {
+		return err
+	}
+
+	if patient.KeyID != keyID {
+		if err := accountKeyUsage(ctx, patient.KeyID, 0, -1); err != nil {
+			return err
+		}
+
+		if err := accountKeyUsage(ctx, keyID, 0, 1); err != nil {
+			return err
+		}
+	}
+
+	fingerprint, err := registeredFingerprint(ctx, keyID)
+
+	if err != nil {
+		return err
+	}
+
+	orgKey, err := ctx.GetStub().CreateCompositeKey(patientOrgIndex, []string{transfer.FromMSP, id})
+
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.GetStub().DelState(orgKey); err != nil {
+		return err
+	}
+
+	if err := indexPatientOrg(ctx, transfer.ToMSP, id); err != nil {
+		return err
+	}
+
+	if err := bindCustodianPolicy(ctx, id, transfer.ToMSP); err != nil {
+		return err
+	}
+
+	patient.KeyID = keyID
+	patient.KeyFingerprint = fingerprint
+	patient.Proofs = nil
+	patient.CustodianMSP = transfer.ToMSP
+	transfer.Status = TransferAccepted
+	transfer.Answered = &details
+	patient.Metadata.Updated = details
+
+	if err := emitPatientUpdated(ctx, id, before, patient); err != nil {
+		return err
+	}
+
+	return putPatient(ctx, id, patient)
+}
+
+// transferAgeBuckets moves the age bucket indicators of a patient, if any, from the counters of
+// the former custodian to those of the receiving org under its key
+func (s *SimpleContract) transferAgeBuckets(ctx contractapi.TransactionContextInterface, id string, transfer *PatientTransfer, keyID string, update func(string) (string, error), q phewrap.Modulus) error {
+	entryKey, err := ctx.GetStub().CreateCompositeKey(ageBucketIndex, []string{id})
+
+	if err != nil {
+		return err
+	}
+
+	entryAsBytes, err := ctx.GetStub().GetState(entryKey)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	if entryAsBytes == nil {
+		return nil
+	}
+
+	entry := new(AgeBucketEntry)
+	_ = json.Unmarshal(entryAsBytes, entry)
+
+	if err := updateAgeBuckets(ctx, transfer.FromMSP, entry.KeyID, nil, entry.Indicators, q); err != nil {
+		return err
+	}
+
+	for bucket, indicator := range entry.Indicators {
+		if entry.Indicators[bucket], err = update(indicator); err != nil {
+			return err
+		}
+	}
+
+	if err := updateAgeBuckets(ctx, transfer.ToMSP, keyID, entry.Indicators, nil, q); err != nil {
+		return err
+	}
+
+	entry.KeyID = keyID
+	entryAsBytes, _ = json.Marshal(entry)
+
+	return ctx.GetStub().PutState(entryKey, entryAsBytes)
+}
+
+// bindCustodianPolicy sets the endorsement policy of a patient key to a peer of its custodian
+func bindCustodianPolicy(ctx contractapi.TransactionContextInterface, id string, custodianMSP string) error {
+	policy, err := statebased.NewStateEP(nil)
+
+	if err != nil {
+		return err
+	}
+
+	if err := policy.AddOrgs(statebased.RoleTypePeer, custodianMSP); err != nil {
+		return err
+	}
+
+	policyAsBytes, err := policy.Policy()
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().SetStateValidationParameter(id, policyAsBytes)
+}
+
+// CancelTransfer cancels the pending transfer of a patient, withdrawn by its custodian or
+// declined by the receiving org. The custodian keeps the patient.
+func (s *SimpleContract) CancelTransfer(ctx contractapi.TransactionContextInterface, id string) error {
+	patient, err := findPatient(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	transfer, err := pendingTransfer(id, patient)
+
+	if err != nil {
+		return err
+	}
+
+	details, err := newTransactionDetails(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if details.MSPID != transfer.FromMSP && details.MSPID != transfer.ToMSP {
+		return newError(CodePermissionDenied, map[string]string{"id": id, "mspID": details.MSPID}, "Only %s and %s can cancel the transfer of %s", transfer.FromMSP, transfer.ToMSP, id)
+	}
+
+	transfer.Status = TransferCancelled
+	transfer.Answered = &details
+	patient.Metadata.Updated = details
+
+	return putPatient(ctx, id, patient)
+}
@@ -0,0 +1,155 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/timeutil"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+// usageIndex is the composite key namespace of the usage counters, by UTC day and transaction
+const usageIndex = "usage~day"
+
+// maxUsageDays is the longest range of days GetUsageStatistics covers at once
+const maxUsageDays = 366
+
+// Usage counters
+const (
+	counterPatientsCreated   = "patientsCreated"
+	counterProposalsExecuted = "proposalsExecuted"
+	counterCohortPatients    = "cohortPatients"
+)
+
+// DailyUsage is the utilization of the platform over a day, or a range of days for the totals
+type DailyUsage struct {
+	Day               string  `json:"day"`
+	PatientsCreated   int     `json:"patientsCreated"`
+	ProposalsExecuted int     `json:"proposalsExecuted"`
+	AverageCohortSize float64 `json:"averageCohortSize"`
+}
+
+// UsageStatistics is the utilization of the platform over a range of days
+type UsageStatistics struct {
+	From  string       `json:"from"`
+	To    string       `json:"to"`
+	Total DailyUsage   `json:"total"`
+	Days  []DailyUsage `json:"days"`
+}
+
+// countUsage adds to a usage counter of the day of the transaction. Every transaction writes
+// its own entry, so concurrent transactions never conflict on the counters.
+func countUsage(ctx contractapi.TransactionContextInterface, counter string, n int) error {
+	now, err := txTime(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	key, err := ctx.GetStub().CreateCompositeKey(usageIndex, []string{timeutil.Day(now), counter, ctx.GetStub().GetTxID()})
+
+	if err != nil {
+		return err
+	}
+
+	return ctx.GetStub().PutState(key, []byte(strconv.Itoa(n)))
+}
+
+// dailyUsage sums the usage counters of a day. A day has an entry per transaction, so they
+// are all summed rather than refused beyond the result limit; each is a few bytes.
+func dailyUsage(ctx contractapi.TransactionContextInterface, day string) (DailyUsage, int, error) {
+	usage := DailyUsage{Day: day}
+	cohortPatients := 0
+
+	resultsIterator, err := ctx.GetStub().GetStateByPartialCompositeKey(usageIndex, []string{day})
+
+	if err != nil {
+		return usage, 0, err
+	}
+
+	err = ledgeriter.ForEach[*queryresult.KV](queryContext(), resultsIterator, 0, func(queryResponse *queryresult.KV) error {
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(queryResponse.Key)
+
+		if err != nil {
+			return err
+		}
+
+		n, _ := strconv.Atoi(string(queryResponse.Value))
+
+		switch attributes[1] {
+		case counterPatientsCreated:
+			usage.PatientsCreated += n
+		case counterProposalsExecuted:
+			usage.ProposalsExecuted += n
+		case counterCohortPatients:
+			cohortPatients += n
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return usage, 0, err
+	}
+
+	usage.AverageCohortSize = averageCohortSize(cohortPatients, usage.ProposalsExecuted)
+
+	return usage, cohortPatients, nil
+}
+
+// averageCohortSize returns the mean cohort size of executed proposals, 0 without any
+func averageCohortSize(cohortPatients int, executed int) float64 {
+	if executed == 0 {
+		return 0
+	}
+
+	return float64(cohortPatients) / float64(executed)
+}
+
+// GetUsageStatistics returns the patients created, the proposals executed and their average
+// cohort size for every UTC day between from and to, YYYY-MM-DD dates included, with the
+// totals of the range, at most a year
+func (s *SimpleContract) GetUsageStatistics(ctx contractapi.TransactionContextInterface, from string, to string) (*UsageStatistics, error) {
+	first, err := parseDay("from", from)
+
+	if err != nil {
+		return nil, err
+	}
+
+	last, err := parseDay("to", to)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if last.Before(first) || last.Sub(first) >= maxUsageDays*24*time.Hour {
+		return nil, newError(CodeInvalidArgument, map[string]string{"from": from, "to": to}, "The range must run forward over at most %d days", maxUsageDays)
+	}
+
+	statistics := &UsageStatistics{From: from, To: to, Total: DailyUsage{Day: fmt.Sprintf("%s/%s", from, to)}, Days: []DailyUsage{}}
+	cohortPatients := 0
+
+	for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+		usage, patients, err := dailyUsage(ctx, timeutil.Day(day))
+
+		if err != nil {
+			return nil, err
+		}
+
+		statistics.Days = append(statistics.Days, usage)
+		statistics.Total.PatientsCreated += usage.PatientsCreated
+		statistics.Total.ProposalsExecuted += usage.ProposalsExecuted
+		cohortPatients += patients
+	}
+
+	statistics.Total.AverageCohortSize = averageCohortSize(cohortPatients, statistics.Total.ProposalsExecuted)
+
+	return statistics, nil
+}
@@ -0,0 +1,194 @@
+/*
+SPDX-License-Identifier: Apache-2.0
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hanesbarbosa/fabric-samples/chaincode/contract-tutorial/internal/ledgeriter"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// withdrawalIndex is the composite key namespace of the patients withdrawn from each study protocol
+const withdrawalIndex = "withdrawal~patient"
+
+// Withdrawal records that a patient was withdrawn from a proposal and from the later
+// proposals of its study protocol
+type Withdrawal struct {
+	PatientID  string             `json:"patientID"`
+	ProtocolID string             `json:"protocolID"`
+	ProposalID string             `json:"proposalID"`
+	Withdrawn  TransactionDetails `json:"withdrawn"`
+}
+
+// withdrawalKey returns the key of the withdrawal of a patient from a protocol
+func withdrawalKey(ctx contractapi.TransactionContextInterface, patientID string, protocolID string) (string, error) {
+	return ctx.GetStub().CreateCompositeKey(withdrawalIndex, []string{patientID, protocolID})
+}
+
+// isWithdrawn tells whether a patient was withdrawn from a protocol
+func isWithdrawn(ctx contractapi.TransactionContextInterface, patientID string, protocolID string) (bool, error) {
+	key, err := withdrawalKey(ctx, patientID, protocolID)
+
+	if err != nil {
+		return false, err
+	}
+
+	withdrawalAsBytes, err := ctx.GetStub().GetState(key)
+
+	if err != nil {
+		return false, fmt.Errorf("Failed to read from world state. %s", err.Error())
+	}
+
+	return withdrawalAsBytes != nil, nil
+}
+
+// excludeWithdrawn removes the patients withdrawn from a protocol from a cohort
+func excludeWithdrawn(ctx contractapi.TransactionContextInterface, protocolID string, patientsIDs string) (string, error) {
+	remaining := []string{}
+
+	for _, pid := range strings.Split(patientsIDs, ",") {
+		withdrawn, err := isWithdrawn(ctx, pid, protocolID)
+
+		if err != nil {
+			return "", err
+		}
+
+		if !withdrawn {
+			remaining = append(remaining, pid)
+		}
+	}
+
+	if len(remaining) == 0 {
+		return "", newError(CodeInvalidArgument, map[string]string{"protocolID": protocolID}, "Every patient of the cohort was withdrawn from %s", protocolID)
+	}
+
+	return strings.Join(remaining, ","), nil
+}
+
+// WithdrawPatientFromProposal removes a patient from the cohort of a proposal not yet executed,
+// releasing its lock if the proposal is approved. The withdrawal is recorded, and later proposals
+// under the same study protocol leave the patient out. Executed results are not affected.
+func (s *SimpleContract) WithdrawPatientFromProposal(ctx contractapi.TransactionContextInterface, proposalID string, patientID string) error {
+	proposal, err := s.FindProposal(ctx, proposalID)
+
+	if err != nil {
+		return err
+	}
+
+	if proposal.Status == ProposalExecuted {
+		return newError(CodeInvalidState, map[string]string{"id": proposalID}, "%s has already been executed", proposalID)
+	}
+
+	if len(proposal.ResultIDs) > 0 {
+		return newError(CodeInvalidState, map[string]string{"id": proposalID}, "%s combines results, its cohort can't be changed", proposalID)
+	}
+
+	patient, err := findPatient(ctx, patientID)
+
+	if err != nil {
+		return err
+	}
+
+	mspID, err := callerMSP(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if mspID != patient.custodian() {
+		return newError(CodePermissionDenied, map[string]string{"id": patientID, "mspID": mspID}, "Only %s can withdraw %s", patient.custodian(), patientID)
+	}
+
+	pids := strings.Split(proposal.PatientsIDs, ",")
+
+	if !contains(pids, patientID) {
+		return newError(CodeInvalidArgument, map[string]string{"id": proposalID, "patientID": patientID}, "%s is not in the cohort of %s", patientID, proposalID)
+	}
+
+	if len(pids) == 1 {
+		return newError(CodeInvalidState, map[string]string{"id": proposalID, "patientID": patientID}, "%s is the only patient of %s", patientID, proposalID)
+	}
+
+	if proposal.Status == ProposalApproved {
+		lockKey, err := ctx.GetStub().CreateCompositeKey(patientLockIndex, []string{patientID, proposalID})
+
+		if err != nil {
+			return err
+		}
+
+		if err := ctx.GetStub().DelState(lockKey); err != nil {
+			return err
+		}
+	}
+
+	remaining := []string{}
+
+	for _, pid := range pids {
+		if pid != patientID {
+			remaining = append(remaining, pid)
+		}
+	}
+
+	if err := saveVersion(ctx, proposalID, proposal); err != nil {
+		return err
+	}
+
+	proposal.PatientsIDs = strings.Join(remaining, ",")
+	proposal.Skipped = append(proposal.Skipped, SkippedPatient{PatientID: patientID, Reason: SkipWithdrawn})
+
+	if err := proposal.Metadata.touch(ctx); err != nil {
+		return err
+	}
+
+	withdrawal := Withdrawal{PatientID: patientID, ProtocolID: proposal.ProtocolID, ProposalID: proposalID, Withdrawn: proposal.Metadata.Updated}
+
+	key, err := withdrawalKey(ctx, patientID, proposal.ProtocolID)
+
+	if err != nil {
+		return err
+	}
+
+	withdrawalAsBytes, _ := json.Marshal(withdrawal)
+
+	if err := ctx.GetStub().PutState(key, withdrawalAsBytes); err != nil {
+		return err
+	}
+
+	if err := notify(ctx, proposal.RequesterID, NotificationPatientWithdrawn, proposalID, fmt.Sprintf("%s withdrew %s from %s", mspID, patientID, proposalID)); err != nil {
+		return err
+	}
+
+	proposalAsBytes, _ := json.Marshal(proposal)
+
+	return ctx.GetStub().PutState(proposalID, proposalAsBytes)
+}
+
+// WithdrawalPage is a page of withdrawals
+type WithdrawalPage struct {
+	Records   []Withdrawal `json:"records"`
+	Bookmark  string       `json:"bookmark"`
+	Count     int32        `json:"count"`
+	Truncated bool         `json:"truncated"`
+}
+
+// GetPatientWithdrawals returns a page of the withdrawals of a patient from study protocols
+func (s *SimpleContract) GetPatientWithdrawals(ctx contractapi.TransactionContextInterface, patientID string, pageSize int32, bookmark string) (*WithdrawalPage, error) {
+	size, err := resolvePageSize(ctx, pageSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := ledgeriter.CollectTypedPage[Withdrawal](queryContext(), indexPages(ctx, withdrawalIndex, []string{patientID}), size, bookmark)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &WithdrawalPage{Records: results.Records, Bookmark: results.Bookmark, Count: results.Count, Truncated: results.Bookmark != ""}, nil
+}